@@ -0,0 +1,436 @@
+// Command gen generates the Go types, client methods and server handler
+// adapters of the operations of the OpenAPI specification carrying a tag.
+// It is run by go generate in pkg/api
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/openapi"
+)
+
+var (
+	specFile = flag.String("spec", "api/openapi.json", "OpenAPI specification")
+	tag      = flag.String("tag", "", "generate the operations with this tag, all when empty")
+	pkg      = flag.String("package", "api", "package of the generated code")
+	output   = flag.String("o", "", "output file, stdout when empty")
+)
+
+// operation is an operation as seen by the templates
+type operation struct {
+	ID      string
+	Summary string
+	Method  string
+	Path    string
+	// Body is the Go type of the JSON request body, empty without body
+	Body string
+	// Responses are the JSON responses by status code
+	Responses []response
+	// Problem tells that some responses are problem details
+	Problem bool
+}
+
+type response struct {
+	Code string
+	Type string
+}
+
+// goType is a generated struct type
+type goType struct {
+	Name        string
+	Description string
+	Fields      []field
+}
+
+type field struct {
+	Name        string
+	Type        string
+	Tag         string
+	Description string
+}
+
+type generator struct {
+	spec  *openapi.Spec
+	ops   []operation
+	types map[string]*goType
+}
+
+func main() {
+	flag.Parse()
+	spec, err := openapi.Load(*specFile)
+	if err != nil {
+		fail(err)
+	}
+	g := &generator{spec: spec, types: make(map[string]*goType)}
+	if err := g.operations(); err != nil {
+		fail(err)
+	}
+	code, err := g.generate()
+	if err != nil {
+		fail(err)
+	}
+	if *output == "" {
+		_, _ = os.Stdout.Write(code)
+		return
+	}
+	if err := ioutil.WriteFile(*output, code, 0644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "gen: %v\n", err)
+	os.Exit(1)
+}
+
+// operations collects the tagged operations and the schemas they use
+func (g *generator) operations() error {
+	paths := make([]string, 0, len(g.spec.Paths))
+	for path := range g.spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		methods := make([]string, 0)
+		for method := range g.spec.Paths[path] {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			op := g.spec.Paths[path][method]
+			if *tag != "" && !contains(op.Tags, *tag) {
+				continue
+			}
+			if strings.Contains(path, "{") {
+				return fmt.Errorf("%s %s: path parameters are not supported",
+					method, path)
+			}
+			if op.OperationID == "" {
+				return fmt.Errorf("%s %s: no operationId", method, path)
+			}
+			o := operation{ID: op.OperationID, Summary: op.Summary,
+				Method: strings.ToUpper(method), Path: path}
+			if schema := op.BodySchema(); schema != nil {
+				t, err := g.typeOf(op.OperationID+"Body", schema)
+				if err != nil {
+					return err
+				}
+				o.Body = t
+			}
+			codes := make([]string, 0, len(op.Responses))
+			for code := range op.Responses {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+			for _, code := range codes {
+				r := g.spec.ResponseOf(op.Responses[code])
+				for mediaType, content := range r.Content {
+					switch {
+					case mediaType == "application/problem+json":
+						o.Problem = true
+					case mediaType == "application/json" && content.Schema != nil:
+						t, err := g.typeOf(op.OperationID+code+"Response",
+							content.Schema)
+						if err != nil {
+							return err
+						}
+						o.Responses = append(o.Responses,
+							response{Code: code, Type: t})
+					}
+				}
+			}
+			g.ops = append(g.ops, o)
+		}
+	}
+	return nil
+}
+
+// typeOf returns the Go type of the schema, generating the struct types of
+// the referenced objects. name is used for the inline objects
+func (g *generator) typeOf(name string, s *openapi.Schema) (string, error) {
+	if s.GoType != "" {
+		return s.GoType, nil
+	}
+	if s.Ref != "" {
+		ref := s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		target, ok := g.spec.Components.Schemas[ref]
+		if !ok {
+			return "", fmt.Errorf("unknown reference %q", s.Ref)
+		}
+		if target.Type != "object" {
+			return g.typeOf(ref, target)
+		}
+		if _, ok := g.types[ref]; !ok {
+			if err := g.object(ref, target); err != nil {
+				return "", err
+			}
+		}
+		return ref, nil
+	}
+	switch s.Type {
+	case "string":
+		return "string", nil
+	case "integer":
+		return "int64", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "[]interface{}", nil
+		}
+		t, err := g.typeOf(name+"Item", s.Items)
+		return "[]" + t, err
+	case "object":
+		if len(s.Properties) == 0 {
+			return "map[string]interface{}", nil
+		}
+		if _, ok := g.types[name]; !ok {
+			if err := g.object(name, s); err != nil {
+				return "", err
+			}
+		}
+		return name, nil
+	}
+	return "interface{}", nil
+}
+
+// object generates the struct type of an object schema
+func (g *generator) object(name string, s *openapi.Schema) error {
+	t := &goType{Name: name, Description: s.Description}
+	g.types[name] = t
+	props := make([]string, 0, len(s.Properties))
+	for prop := range s.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	for _, prop := range props {
+		ps := s.Properties[prop]
+		fieldName := ps.GoName
+		if fieldName == "" {
+			fieldName = goName(prop)
+		}
+		ft, err := g.typeOf(name+fieldName, ps)
+		if err != nil {
+			return err
+		}
+		jsonTag := prop
+		if !contains(s.Required, prop) {
+			jsonTag += ",omitempty"
+		}
+		t.Fields = append(t.Fields, field{Name: fieldName, Type: ft,
+			Tag: "`json:\"" + jsonTag + "\"`", Description: ps.Description})
+	}
+	return nil
+}
+
+// initialisms are kept upper case in the Go names
+var initialisms = map[string]string{"Id": "ID", "Uri": "URI", "Url": "URL",
+	"Nf": "NF", "Api": "API", "Http": "HTTP", "Json": "JSON"}
+
+// goName converts a camelCase JSON name into an exported Go name
+func goName(name string) string {
+	var words []string
+	start := 0
+	for i := 1; i <= len(name); i++ {
+		if i == len(name) || (name[i] >= 'A' && name[i] <= 'Z') {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	var b strings.Builder
+	for _, w := range words {
+		w = strings.ToUpper(w[:1]) + w[1:]
+		if up, ok := initialisms[w]; ok {
+			w = up
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) generate() ([]byte, error) {
+	names := make([]string, 0, len(g.types))
+	for name := range g.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	types := make([]*goType, 0, len(names))
+	for _, name := range names {
+		types = append(types, g.types[name])
+	}
+	problem := false
+	for _, o := range g.ops {
+		problem = problem || o.Problem
+	}
+	var buf bytes.Buffer
+	err := codeTemplate.Execute(&buf, map[string]interface{}{
+		"Spec":    filepath.Base(*specFile),
+		"Package": *pkg,
+		"Types":   types,
+		"Ops":     g.ops,
+		"Problem": problem,
+	})
+	if err != nil {
+		return nil, err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting the generated code: %v\n%s", err,
+			buf.Bytes())
+	}
+	return code, nil
+}
+
+var codeTemplate = template.Must(template.New("code").Funcs(
+	template.FuncMap{"comment": comment}).Parse(`// Code generated by api/gen from {{.Spec}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{- if .Problem}}
+	"encoding/json"
+{{- end}}
+	"net/http"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/message"
+{{- if .Problem}}
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+{{- end}}
+)
+{{range .Types}}
+{{comment .Name .Description}}
+type {{.Name}} struct {
+{{- range .Fields}}
+{{- if .Description}}
+	{{comment .Name .Description}}
+{{- end}}
+	{{.Name}} {{.Type}} {{.Tag}}
+{{- end}}
+}
+{{end}}
+// Paths of the operations, relative to the API root prefix
+const (
+{{- range .Ops}}
+	{{.ID}}Path = "{{.Path}}"
+{{- end}}
+)
+
+// Message types of the operations, in the envelope of the messages from
+// version 2 on
+const (
+{{- range .Ops}}
+	{{.ID}}Message = "{{.ID}}"
+{{- end}}
+)
+{{range $op := .Ops}}
+// {{.ID}}Response is the response of {{.ID}}
+type {{.ID}}Response struct {
+	HTTPResponse *http.Response
+	Body         []byte
+{{- range .Responses}}
+	// JSON{{.Code}} is the decoded body of the {{.Code}} response
+	JSON{{.Code}} *{{.Type}}
+{{- end}}
+{{- if .Problem}}
+	// Problem is the decoded body of the error responses
+	Problem *problem.Details
+{{- end}}
+}
+
+// StatusCode returns the status code of the response
+func (r *{{.ID}}Response) StatusCode() int {
+	return r.HTTPResponse.StatusCode
+}
+
+{{comment .ID .Summary}}
+func (c *Client) {{.ID}}(ctx context.Context{{if .Body}}, body {{.Body}}{{end}}) (*{{.ID}}Response, error) {
+	req, err := c.newRequest({{.ID}}Message, "{{.Method}}", {{.ID}}Path, {{if .Body}}body{{else}}nil{{end}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doer.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return Parse{{.ID}}Response(resp)
+}
+
+// New{{.ID}}Request builds the {{.ID}} request to the API root prefix server{{if .Body}}, with a JSON body{{end}} in the current message version
+func New{{.ID}}Request(server string{{if .Body}}, body {{.Body}}{{end}}) (*http.Request, error) {
+	return newRequest("{{.Method}}", strings.TrimRight(server, "/")+{{.ID}}Path,
+		codec.JSON, message.Current, {{.ID}}Message, {{if .Body}}body{{else}}nil{{end}}, nil)
+}
+
+// Parse{{.ID}}Response reads the {{.ID}} response and decodes its body
+func Parse{{.ID}}Response(resp *http.Response) (*{{.ID}}Response, error) {
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	r := &{{.ID}}Response{HTTPResponse: resp, Body: body}
+	switch {
+{{- range .Responses}}
+	case resp.StatusCode == {{.Code}} && decodable(resp):
+		var dest {{.Type}}
+		if err := unmarshal(resp, body, {{$op.ID}}Message, &dest); err != nil {
+			return nil, err
+		}
+		r.JSON{{.Code}} = &dest
+{{- end}}
+{{- if .Problem}}
+	case isProblem(resp):
+		var dest problem.Details
+		if err := json.Unmarshal(body, &dest); err != nil {
+			return nil, err
+		}
+		r.Problem = &dest
+{{- end}}
+	}
+	return r, nil
+}
+{{if .Body}}
+// {{.ID}}HandlerFunc handles the {{.ID}} requests with their decoded body
+type {{.ID}}HandlerFunc func(w http.ResponseWriter, r *http.Request, body {{.Body}})
+
+// ServeHTTP decodes the body and calls f. A body that cannot be decoded is
+// rejected with a 400 problem
+func (f {{.ID}}HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body {{.Body}}
+	if !decodeBody(w, r, {{.ID}}Message, &body) {
+		return
+	}
+	f(w, r, body)
+}
+{{end}}
+{{- end}}`))
+
+// comment returns a doc comment made of the name and the description
+func comment(name, text string) string {
+	if text == "" {
+		return "// " + name + " is generated from the specification"
+	}
+	return "// " + name + " " + strings.TrimSuffix(text, ".")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+)
+
+// auditVerifyCommand checks the sequence and the hash chain of an audit log
+// file. It fails on the first record removed, inserted or altered
+func auditVerifyCommand(args []string) error {
+	fs := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	file := fs.String("file", "", "audit log file")
+	_ = fs.Parse(args)
+	if *file == "" {
+		return errors.New("usage: nfservice audit-verify -file audit.jsonl")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := audit.Verify(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *file, err)
+	}
+	fmt.Printf("%d records verified\n", n)
+	return nil
+}
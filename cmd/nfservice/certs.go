@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+)
+
+// certsCommand shows the certificates of the files given, or of the
+// certificate files of the role configuration: subject, issuer, names and
+// validity. It fails when one of them is expired or not valid yet
+func certsCommand(args []string) error {
+	var rf roleFlags
+	fs := flag.NewFlagSet("certs", flag.ExitOnError)
+	rf.register(fs)
+	_ = fs.Parse(args)
+	files := fs.Args()
+	if len(files) == 0 {
+		r, o, err := rf.lookup()
+		if err != nil {
+			return err
+		}
+		common, err := r.load(o)
+		if err != nil {
+			return fmt.Errorf("%s: %v", o.ConfigFile, err)
+		}
+		files = common.CertFiles(r.servers...)
+	}
+	invalid := 0
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		n, err := showCerts(file)
+		if err != nil {
+			return err
+		}
+		invalid += n
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d certificates out of their validity", invalid)
+	}
+	return nil
+}
+
+// showCerts prints the certificates of the PEM file, only counting the
+// private keys, and returns the number out of their validity
+func showCerts(file string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	found, keys, invalid := 0, 0, 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			keys++
+			continue
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return invalid, fmt.Errorf("%s: %v", file, err)
+		}
+		found++
+		state := fmt.Sprintf("valid, expires in %d days",
+			int(cert.NotAfter.Sub(now).Hours()/24))
+		switch {
+		case now.After(cert.NotAfter):
+			state = "EXPIRED"
+			invalid++
+		case now.Before(cert.NotBefore):
+			state = "NOT YET VALID"
+			invalid++
+		}
+		fmt.Printf("%s #%d\n", file, found)
+		fmt.Printf("  Subject:    %s\n", cert.Subject)
+		fmt.Printf("  Issuer:     %s\n", cert.Issuer)
+		fmt.Printf("  Names:      %s\n",
+			strings.Join(tlsutil.Names(cert), ", "))
+		fmt.Printf("  CA:         %v\n", cert.IsCA)
+		fmt.Printf("  Not before: %s\n", cert.NotBefore.Format(time.RFC3339))
+		fmt.Printf("  Not after:  %s (%s)\n",
+			cert.NotAfter.Format(time.RFC3339), state)
+	}
+	if keys > 0 {
+		fmt.Printf("%s: %d private keys\n", file, keys)
+	}
+	if found == 0 && keys == 0 {
+		return invalid, errors.New(file + ": no certificate found")
+	}
+	return invalid, nil
+}
@@ -0,0 +1,216 @@
+// Command nfservice runs the NF roles and the tools around them:
+//
+//	nfservice run --role=nf1|nf2 [--config=file] [--version=2] [--mock-peer]
+//	nfservice supervise [--config=file] [--version=2]
+//	nfservice validate-config --role=nf1|nf2 [--config=file]
+//	nfservice schema --role=nf1|nf2
+//	nfservice certs [--role=nf1|nf2 [--config=file]] [file...]
+//	nfservice request [--role=nf1|nf2] [-X method] [-d body] url
+//	nfservice selftest [--version=2] [-n 20]
+//	nfservice replay -file recording -target url [-side server|client]
+//	nfservice audit-verify -file audit.jsonl
+//
+// Each subcommand takes -h for its flags
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/internal/nf1"
+	"github.com/Nishat-Zaman/nfservice_http2/internal/nf2"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+)
+
+// role is an NF the process can run
+type role struct {
+	// configPath is the default configuration file
+	configPath string
+	// servers are the names of the server endpoints of the role
+	servers []string
+	run     func(ctx context.Context, o config.Options) error
+	// load returns the shared sections of the configuration of the role
+	load func(o config.Options) (config.Common, error)
+	// check loads the configuration of the role and returns the problems
+	// config.Common.Check finds in it
+	check func(o config.Options) ([]config.Problem, error)
+	// config is the zero configuration of the role, whose JSON Schema
+	// describes the configuration file
+	config interface{}
+}
+
+var roles = map[string]role{
+	"nf1": {
+		configPath: nf1.ConfigPath,
+		servers:    []string{"API", "NF"},
+		run:        nf1.Run,
+		load: func(o config.Options) (config.Common, error) {
+			c, err := nf1.LoadConfig(o)
+			return c.Common, err
+		},
+		check: func(o config.Options) ([]config.Problem, error) {
+			c, err := nf1.LoadConfig(o)
+			if err != nil {
+				return nil, err
+			}
+			return c.Check(c.Endpoints(), o.HTTPVersion == 2), nil
+		},
+		config: nf1.Config{},
+	},
+	"nf2": {
+		configPath: nf2.ConfigPath,
+		servers:    []string{"NF2"},
+		run:        nf2.Run,
+		load: func(o config.Options) (config.Common, error) {
+			c, err := nf2.LoadConfig(o)
+			return c.Common, err
+		},
+		check: func(o config.Options) ([]config.Problem, error) {
+			c, err := nf2.LoadConfig(o)
+			if err != nil {
+				return nil, err
+			}
+			return c.Check(c.Endpoints(), o.HTTPVersion == 2), nil
+		},
+		config: nf2.Config{},
+	},
+}
+
+// command is a subcommand, run with its arguments
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"run": {"run an NF role", runCommand},
+	"supervise": {"run several NF roles in one process",
+		superviseCommand},
+	"validate-config": {"check the configuration of an NF role",
+		validateCommand},
+	"schema": {"print the JSON Schema of the configuration of an NF role",
+		schemaCommand},
+	"certs": {"show the certificates of an NF role or files",
+		certsCommand},
+	"request": {"send a test request to an NF", requestCommand},
+	"selftest": {"run the location exchange between NF1 and NF2 in the " +
+		"process", selftestCommand},
+	"replay": {"send the recorded requests to an NF and compare the " +
+		"responses", replayCommand},
+	"audit-verify": {"check the hash chain of an audit log file",
+		auditVerifyCommand},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "-h" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		logging.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: nfservice <command> [flags]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].summary)
+	}
+}
+
+// roleFlags are the flags selecting the role and the settings it runs with
+type roleFlags struct {
+	fs   *flag.FlagSet
+	role string
+	opts config.Options
+}
+
+// register adds the role flags to the flag set
+func (f *roleFlags) register(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.role, "role", os.Getenv("NF_ROLE"),
+		"NF role: "+strings.Join(roleNames(), " or "))
+	fs.IntVar(&f.opts.HTTPVersion, "version", 2, "HTTP version")
+	fs.StringVar(&f.opts.ConfigFile, "config", os.Getenv("NF_CONFIG"),
+		"configuration file, the one of the role by default")
+	fs.StringVar(&f.opts.Section, "section", "",
+		"section of the configuration file holding the role configuration")
+	fs.StringVar(&f.opts.LogLevel, "loglevel", "",
+		"log level: debug, info, warn or error")
+	fs.StringVar(&f.opts.LogFormat, "logformat", "",
+		"log format: console or json")
+	fs.Var(&f.opts.Overrides, "set",
+		"override a configuration field, e.g. -set log.level=debug")
+}
+
+// lookup returns the role selected, with the options defaulting to its
+// configuration file unless -config or NF_CONFIG is set. Set empty, the
+// role runs without file
+func (f *roleFlags) lookup() (role, config.Options, error) {
+	r, ok := roles[f.role]
+	if !ok {
+		return role{}, f.opts, fmt.Errorf("unknown role %q, expected %s",
+			f.role, strings.Join(roleNames(), " or "))
+	}
+	o := f.opts
+	_, set := os.LookupEnv("NF_CONFIG")
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "config" {
+			set = true
+		}
+	})
+	if !set {
+		o.ConfigFile = r.configPath
+	}
+	return r, o, nil
+}
+
+func roleNames() []string {
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runCommand runs the role until SIGTERM
+func runCommand(args []string) error {
+	var rf roleFlags
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	rf.register(fs)
+	fs.BoolVar(&rf.opts.MockPeer, "mock-peer", false,
+		"answer the peer API with the canned responses of the \"mock\" "+
+			"configuration instead of running the role")
+	_ = fs.Parse(args)
+	r, o, err := rf.lookup()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := server.SignalContext(context.Background())
+	defer cancel()
+	return r.run(ctx, o)
+}
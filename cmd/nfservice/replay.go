@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/record"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+)
+
+// replayCommand sends the requests of a recording to a target NF with the
+// client of the role, nf1 by default, and compares the responses with the
+// recorded ones. It fails when one differs
+func replayCommand(args []string) error {
+	var rf roleFlags
+	var p record.Replayer
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	rf.register(fs)
+	file := fs.String("file", "", "recording file")
+	target := fs.String("target", "",
+		"scheme and host:port of the NF, e.g. https://localhost:8080")
+	side := fs.String("side", record.SideServer,
+		"exchanges replayed: server (served) or client (sent to a peer)")
+	endpoint := fs.String("endpoint", "",
+		"server endpoint name or peer host:port replayed, all when empty")
+	ignore := fs.String("ignore", "",
+		"members of the JSON bodies not compared, separated by commas")
+	fs.Float64Var(&p.Speed, "speed", 0,
+		"pace of the requests, 1 as recorded, 0 back to back")
+	fs.BoolVar(&p.CompareBodies, "body", false,
+		"compare the response bodies besides the statuses")
+	fs.DurationVar(&p.Timeout, "timeout", 10*time.Second, "request timeout")
+	_ = fs.Parse(args)
+	if *file == "" || *target == "" {
+		return errors.New("usage: nfservice replay -file recording " +
+			"-target url [flags]")
+	}
+	if *side != record.SideServer && *side != record.SideClient {
+		return fmt.Errorf("unknown side %q, expected server or client", *side)
+	}
+	var err error
+	if p.Target, err = url.Parse(*target); err != nil {
+		return err
+	}
+	if *ignore != "" {
+		p.Ignore = strings.Split(*ignore, ",")
+	}
+	if rf.role == "" {
+		rf.role = "nf1"
+	}
+	r, o, err := rf.lookup()
+	if err != nil {
+		return err
+	}
+	common, err := r.load(o)
+	if err != nil {
+		return fmt.Errorf("%s: %v", o.ConfigFile, err)
+	}
+	c, err := client.New(o.HTTPVersion, "nfservice", common)
+	if err != nil {
+		return err
+	}
+	p.Do = c.Do
+
+	all, err := record.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	var exchanges []record.Exchange
+	for _, e := range all {
+		if e.Side == *side && (*endpoint == "" || e.Endpoint == *endpoint) {
+			exchanges = append(exchanges, e)
+		}
+	}
+	ctx, cancel := server.SignalContext(context.Background())
+	defer cancel()
+	failed, err := p.Replay(ctx, exchanges, func(res record.Result) {
+		e := res.Exchange
+		switch {
+		case res.Err != nil:
+			fmt.Printf("FAIL %s %s: %v\n", e.Method, e.URL, res.Err)
+		case !res.OK():
+			fmt.Printf("FAIL %s %s: %s\n", e.Method, e.URL,
+				strings.Join(res.Differences, ", "))
+		default:
+			fmt.Printf("ok   %s %s %d (%v)\n", e.Method, e.URL, res.Status,
+				res.Duration.Round(time.Millisecond))
+		}
+	})
+	fmt.Printf("%d exchanges replayed, %d failed\n", len(exchanges), failed)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d exchanges differ from the recording",
+			failed, len(exchanges))
+	}
+	return nil
+}
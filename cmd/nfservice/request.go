@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+)
+
+// headers is a repeatable flag collecting "Name: value" request headers
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headers) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("%q is not a Name: value header", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// requestCommand sends a request with the client of the role, nf1 by
+// default, so that its TLS material, peers and access tokens settings
+// apply, and prints the response
+func requestCommand(args []string) error {
+	var rf roleFlags
+	var header headers
+	fs := flag.NewFlagSet("request", flag.ExitOnError)
+	rf.register(fs)
+	method := fs.String("X", "", "request method, GET or POST with a body")
+	data := fs.String("d", "", "request body, read from the file after @")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	fs.Var(&header, "H", "request header, e.g. -H 'Accept: application/cbor'")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: nfservice request [flags] url")
+	}
+	if rf.role == "" {
+		rf.role = "nf1"
+	}
+	r, o, err := rf.lookup()
+	if err != nil {
+		return err
+	}
+	common, err := r.load(o)
+	if err != nil {
+		return fmt.Errorf("%s: %v", o.ConfigFile, err)
+	}
+	c, err := client.New(o.HTTPVersion, "nfservice", common)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if file := strings.TrimPrefix(*data, "@"); file != *data {
+		if body, err = ioutil.ReadFile(filepath.Clean(file)); err != nil {
+			return err
+		}
+	} else {
+		body = []byte(*data)
+	}
+	if *method == "" {
+		*method = http.MethodGet
+		if len(body) > 0 {
+			*method = http.MethodPost
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, *method, fs.Arg(0),
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Body = http.NoBody
+	}
+	for _, h := range header {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("%s %s\n", resp.Proto, resp.Status)
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range resp.Header[name] {
+			fmt.Printf("%s: %s\n", name, v)
+		}
+	}
+	fmt.Println()
+	_, err = io.Copy(os.Stdout, resp.Body)
+	fmt.Println()
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/nftest"
+)
+
+// selftestCommand runs NF1 and NF2 in the process on ephemeral ports,
+// checks one location exchange, then n concurrent ones. Run with
+// `go run -race` it checks the exchange for data races
+func selftestCommand(args []string) error {
+	var o nftest.Options
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	fs.IntVar(&o.Version, "version", 2, "HTTP version")
+	fs.StringVar(&o.LogLevel, "loglevel", "", "log level of the roles")
+	fs.Var(&o.NF1, "set1", "override a configuration field of NF1")
+	fs.Var(&o.NF2, "set2", "override a configuration field of NF2")
+	n := fs.Int("n", 20, "concurrent location requests")
+	timeout := fs.Duration("timeout", 30*time.Second, "time allowed")
+	_ = fs.Parse(args)
+
+	pair, err := nftest.Start(o)
+	if err != nil {
+		return err
+	}
+	defer pair.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	start := time.Now()
+	msg, err := pair.Location(ctx)
+	if err != nil {
+		return fmt.Errorf("location exchange: %v", err)
+	}
+	fmt.Printf("ok   location exchange %s (%v)\n", msg.CorrelationID,
+		time.Since(start).Round(time.Millisecond))
+	start = time.Now()
+	if err := pair.Concurrent(ctx, *n); err != nil {
+		return fmt.Errorf("concurrent location exchanges: %v", err)
+	}
+	fmt.Printf("ok   %d concurrent location exchanges (%v)\n", *n,
+		time.Since(start).Round(time.Millisecond))
+	return pair.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/supervisor"
+)
+
+// multiConfigPath is the default configuration file of the multi-instance
+// mode
+const multiConfigPath = "config/multi.json"
+
+// superviseCommand runs the roles listed in the "supervisor" section of
+// the configuration file in one process until SIGTERM, each configured by
+// the section of its name, and restarts the ones that fail
+func superviseCommand(args []string) error {
+	var o config.Options
+	fs := flag.NewFlagSet("supervise", flag.ExitOnError)
+	fs.IntVar(&o.HTTPVersion, "version", 2, "HTTP version")
+	fs.StringVar(&o.ConfigFile, "config", envOr("NF_CONFIG", multiConfigPath),
+		"configuration file with a section per role")
+	fs.StringVar(&o.LogLevel, "loglevel", "",
+		"log level: debug, info, warn or error")
+	fs.StringVar(&o.LogFormat, "logformat", "",
+		"log format: console or json")
+	_ = fs.Parse(args)
+
+	var cfg config.SupervisorConfig
+	loader := &config.Loader{Path: o.ConfigFile, Section: "supervisor",
+		EnvPrefix: "NF_SUPERVISOR"}
+	if err := loader.Load(&cfg); err != nil {
+		return err
+	}
+	if len(cfg.Roles) == 0 {
+		return fmt.Errorf("%s: no roles in the supervisor section",
+			o.ConfigFile)
+	}
+	var supervised []supervisor.Role
+	for _, name := range cfg.Roles {
+		r, ok := roles[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown role %q", o.ConfigFile, name)
+		}
+		ro := o
+		ro.Section = name
+		supervised = append(supervised, supervisor.Role{Name: name,
+			Run: func(ctx context.Context) error {
+				return r.run(ctx, ro)
+			}})
+	}
+	ctx, cancel := server.SignalContext(context.Background())
+	defer cancel()
+	return supervisor.New(cfg, supervised...).Run(ctx)
+}
+
+func envOr(name, def string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/openapi"
+)
+
+// validateCommand checks the configuration file of the role against its
+// JSON Schema, then loads and validates it and checks the endpoints, API
+// roots, TLS files and timeouts. It fails when an error is found, the
+// warnings are printed only
+func validateCommand(args []string) error {
+	var rf roleFlags
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	rf.register(fs)
+	_ = fs.Parse(args)
+	r, o, err := rf.lookup()
+	if err != nil {
+		return err
+	}
+	if o.ConfigFile != "" {
+		invalid, err := checkSchema(r, o.ConfigFile, o.Section)
+		if err != nil {
+			return err
+		}
+		for _, msg := range invalid {
+			fmt.Printf("%s: %s\n", o.ConfigFile, msg)
+		}
+		if len(invalid) > 0 {
+			return fmt.Errorf("%s: %d schema errors", o.ConfigFile,
+				len(invalid))
+		}
+	}
+	problems, err := r.check(o)
+	if err != nil {
+		return fmt.Errorf("%s: %v", o.ConfigFile, err)
+	}
+	errs := 0
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", o.ConfigFile, p)
+		if !p.Warning {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%s: %d errors", o.ConfigFile, errs)
+	}
+	fmt.Printf("%s: valid %s configuration\n", o.ConfigFile, rf.role)
+	return nil
+}
+
+// checkSchema validates the configuration file, or its section, against
+// the JSON Schema of the role and returns the members that do not match it
+func checkSchema(r role, file, section string) ([]string, error) {
+	data, err := config.ReadSection(file, section)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := roleSchema(r)
+	if err != nil {
+		return nil, err
+	}
+	params, _, err := openapi.ValidateSchema(schema, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	invalid := make([]string, 0, len(params))
+	for _, p := range params {
+		invalid = append(invalid, p.Param+": "+p.Reason)
+	}
+	return invalid, nil
+}
+
+// roleSchema returns the JSON Schema of the configuration of the role
+func roleSchema(r role) (*openapi.Schema, error) {
+	data, err := json.Marshal(config.Schema("", r.config))
+	if err != nil {
+		return nil, err
+	}
+	var schema openapi.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// schemaCommand prints the JSON Schema of the configuration file of the
+// role, as found in config/<role>.schema.json
+func schemaCommand(args []string) error {
+	var rf roleFlags
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	rf.register(fs)
+	_ = fs.Parse(args)
+	r, _, err := rf.lookup()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config.Schema(rf.role+" configuration", r.config))
+}
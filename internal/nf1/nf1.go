@@ -0,0 +1,1068 @@
+// Package nf1 is the NF1 role: it answers the location requests of the API
+// clients with the location NF2 reports back
+package nf1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/internal/nfcommon"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/batch"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/broker"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/dedup"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/discovery"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/events"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/exchanges"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/faults"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/grpc"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/jobs"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/kube"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/location"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/message"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/mock"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/mq"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/nrfclient"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/oauth2"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/outbox"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/record"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/replication"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/reply"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/resolver"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/routing"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/subscription"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tracing"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+// opts are the command line settings the NF runs with
+var opts config.Options
+var ver string
+
+// HTTPConfig contains the configuration for the HTTP 1.1
+type HTTPConfig struct {
+	ApiEndpoint string `json:"apiendpoint" env:"NF_API_ENDPOINT"`
+	NfEndpoint  string `json:"nfendpoint" env:"NF_NF_ENDPOINT"`
+}
+
+// Config contains NF Module Configuration Data Structure
+type Config struct {
+	// API Roots for the remote NF, the primary first
+	RemoteNfAPIRoot          config.APIRoots `json:"remotenfapiroot" env:"NF_REMOTE_API_ROOT"`
+	LocalNfAPIRoot           string          `json:"localapirootprefix" env:"NF_LOCAL_API_ROOT"`
+	NfNotificationResURIPath string          `json:"nfNotificationResUriPath"`
+	HTTPConfig               HTTPConfig
+	Discovery                config.DiscoveryConfig `json:"discovery"`
+	// DNS contains the resolution of the remote NF API root into the
+	// addresses of its instances
+	DNS config.DNSConfig `json:"dns"`
+	// Failover contains the health checks of the remote NF API roots
+	Failover config.FailoverConfig `json:"failover"`
+	// Batch contains the batch location requests
+	Batch config.BatchConfig `json:"batch"`
+	// CallbackTimeout is the time in milliseconds an API request waits for
+	// the NF2 callback, answered 504 when it expires
+	CallbackTimeout int `json:"callbacktimeout" env:"NF_CALLBACK_TIMEOUT"`
+	config.Common
+}
+
+// ConfigPath is the default configuration file of NF1
+const ConfigPath string = "config/nf1.json"
+
+// nfConfig loads the NF1 configuration and reloads it, with the remote NF
+// API roots
+var nfConfig = &nfcommon.Lifecycle[Config]{
+	Default: defaultConfig,
+	Common:  func(cfg *Config) *config.Common { return &cfg.Common },
+	LocalAPIRoot: func(cfg *Config) *string {
+		return &cfg.LocalNfAPIRoot
+	},
+	Print:   printConfig,
+	Prepare: prepareEndpoints,
+}
+
+var nfClient *client.Client
+var tokens *oauth2.TokenClient
+var nfLocation string
+var nfDiscovery *discovery.Discovery
+
+// nfBalancer chooses among the discovered NF2 instances
+var nfBalancer client.Balancer
+
+// nfResolver resolves the remote NF API root in DNS, nil when disabled
+var nfResolver *resolver.Resolver
+
+// nfDNSBalancer chooses among the NF2 instances resolved in DNS
+var nfDNSBalancer client.Balancer
+
+// nfFailover sends the requests to the remote NF API roots in order of
+// priority
+var nfFailover *client.Failover
+var callbacks = broker.New()
+var subscriptions *subscription.Manager
+var eventHub *events.Hub
+
+// nfQueue publishes the NF1 events and callbacks to the message queue, nil
+// without one
+var nfQueue *mq.Queue
+
+// nfOutbox keeps the notifications whose peer is unreachable, to send
+// them again, nil when disabled
+var nfOutbox *outbox.Outbox
+
+// nfJobs runs the API requests asking for the asynchronous mode, nil when
+// disabled
+var nfJobs *jobs.Manager
+
+// callbackDedup recognizes the callbacks received again, nil when disabled
+var callbackDedup *dedup.Window
+
+// nfStore keeps the subscriptions, the correlations and the NF records
+var nfStore store.Store
+
+// nfRecord is the location resource of the NF2 location reported
+var nfRecord *location.Resource
+
+// nfService mirrors the events to the monitor dashboards
+var nfService *server.Service
+
+// nfReplies renders the responses to the location reports
+var nfReplies *reply.Replies
+
+// Event notified to the subscribers when NF2 reports its location
+const locationReportEvent = "LOCATION_REPORT"
+
+// Time NF1 waits for the NF2 callback of an API request by default
+const defaultCallbackTimeout = 10000
+
+// batchPath is the API route of the batch location requests
+const batchPath = api.GetNF2LocationPath + "/batch"
+
+// Collections of the NF1 store
+const (
+	// correlationsCollection holds the API requests waiting for their
+	// callback, so that a replica receiving the callback of another one
+	// forwards it
+	correlationsCollection = "correlations"
+	// nfsCollection holds the last location report of each NF2
+	nfsCollection = "nfs"
+)
+
+// correlationTTL covers the request to NF2 and the wait of its callback
+func correlationTTL(wait time.Duration) time.Duration {
+	return 2 * wait
+}
+
+// correlation is the stored record of an API request waiting for its
+// callback
+type correlation struct {
+	// Owner is the callback URI of the replica holding the request
+	Owner string `json:"owner"`
+}
+
+// Run runs NF1 with the command line settings until ctx is canceled, e.g.
+// on SIGTERM. It returns the error failing the startup, if any
+func Run(ctx context.Context, o config.Options) error {
+	opts = o
+	svc, err := server.New("NF App", opts.HTTPVersion)
+	if err != nil {
+		return err
+	}
+	nfService = svc
+	ver = svc.Scheme()
+
+	// Read the configuration
+	cfg, err := nfConfig.Load(opts)
+	if err != nil {
+		return fmt.Errorf("failed to load NF configuration: %v", err)
+	}
+	svc.Config = cfg.Common
+
+	if err = logging.Configure(cfg.Log); err != nil {
+		return fmt.Errorf("failed to configure logging: %v", err)
+	}
+	if err = secrets.Configure(cfg.Secrets); err != nil {
+		return fmt.Errorf("failed to configure the secret providers: %v", err)
+	}
+	if err = audit.Configure("NF1", cfg.Audit); err != nil {
+		return fmt.Errorf("failed to open the audit log: %v", err)
+	}
+	svc.APIRoot = cfg.LocalNfAPIRoot
+	printConfig(&cfg)
+
+	nfClient, err = client.New(opts.HTTPVersion, "NF1", cfg.Common)
+	if err != nil {
+		return fmt.Errorf("failed to create NF client: %v", err)
+	}
+
+	nfFailover, err = client.NewFailover(nfClient, remoteAPIRoots(&cfg),
+		cfg.Failover)
+	if err != nil {
+		return fmt.Errorf("failed to configure the remote NF: %v", err)
+	}
+
+	nfFaults, err := faults.New(cfg.Faults)
+	if err != nil {
+		return fmt.Errorf("failed to configure the fault injection: %v", err)
+	}
+	svc.Faults = nfFaults
+	nfClient.SetFaults(nfFaults)
+
+	nfRecorder, err := record.New(cfg.Record)
+	if err != nil {
+		return fmt.Errorf("failed to configure the recording: %v", err)
+	}
+	defer nfRecorder.Close()
+	svc.Recorder = nfRecorder
+	nfClient.SetRecorder(nfRecorder)
+
+	nfStore, err = store.Open(cfg.Store)
+	if err != nil {
+		return fmt.Errorf("failed to open the NF store: %v", err)
+	}
+	defer nfStore.Close()
+	var replicator *replication.Replicator
+	if cfg.Replication.Enabled {
+		replicator, err = replication.New(cfg.Replication, cfg.Store.Backend,
+			nfStore, nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the replication: %v", err)
+		}
+		nfStore = replicator
+	}
+	svc.Store = nfStore
+	if cfg.Dedup.Enabled {
+		callbackDedup, err = dedup.New(cfg.Dedup, nfStore)
+		if err != nil {
+			return fmt.Errorf("failed to configure the callback "+
+				"deduplication: %v", err)
+		}
+	}
+
+	nfExchanges, err := exchanges.New(cfg.Exchanges, nfStore)
+	if err != nil {
+		return fmt.Errorf("failed to configure the exchange history: %v", err)
+	}
+	defer nfExchanges.Close()
+	svc.Exchanges = nfExchanges
+	nfClient.SetExchanges(nfExchanges)
+
+	if err = svc.AddServer("API", cfg.HTTPConfig.ApiEndpoint); err != nil {
+		return err
+	}
+	if err = svc.AddServer("NF", cfg.HTTPConfig.NfEndpoint); err != nil {
+		return err
+	}
+	if err = svc.AddAdminServer(); err != nil {
+		return err
+	}
+	if replicator != nil {
+		svc.Router("NF").HandleStream(replicator.Path(), replicator.Handler(),
+			0)
+		svc.AddTask("Replication", replicator.Run)
+	}
+	if !opts.MockPeer {
+		svc.Router("API").HandleFunc(api.GetNF2LocationPath, apiHandler)
+		svc.Router("API").HandleFunc(batchPath, batchHandler)
+	}
+	if admin := svc.Admin(); admin != nil {
+		admin.Handle("/admin/breakers", nfClient.BreakerHandler())
+		admin.Handle("/admin/config", server.JSONHandler(func() interface{} {
+			return currentConfig()
+		}))
+		admin.Handle("/admin/cache", nfClient.CacheHandler())
+		admin.HandleFunc("/admin/nfs", nfsHandler)
+		admin.Handle("/admin/endpoints", server.JSONHandler(
+			func() interface{} {
+				return nfFailover.Status()
+			}))
+	}
+	nfLocation = svc.URI("NF", api.ReportNF2LocationPath)
+	nfRecord = location.New(cfg.Locations, nfStore, "nf1",
+		"ReportedLocation")
+	if nfReplies, err = reply.New("nf1", nfLocation, cfg.Replies); err != nil {
+		return fmt.Errorf("failed to configure the replies: %v", err)
+	}
+	if opts.MockPeer {
+		/* canned responses to NF2 instead of the NF1 logic, whose API
+		   waits on the reports */
+		m, err := mock.New(mockConfig(cfg.Mock), nfClient, nfLocation)
+		if err != nil {
+			return fmt.Errorf("failed to configure the mock peer: %v", err)
+		}
+		m.Register(svc.Router("NF"))
+	} else {
+		handler := nfRecord.Handler(
+			api.ReportNF2LocationHandlerFunc(nf1Handler))
+		svc.Router("NF").Handle(api.ReportNF2LocationPath, handler)
+		svc.Router("NF").Handle(api.ReportNF2LocationPath+
+			location.HistoryPath, handler)
+	}
+
+	// gRPC service, on the NF listener unless it has its own
+	if cfg.GRPC.Enabled {
+		rpcServer := "NF"
+		if cfg.GRPC.Address != "" {
+			rpcServer = "GRPC"
+			if err = svc.AddServer(rpcServer, cfg.GRPC.Address); err != nil {
+				return err
+			}
+		}
+		svc.Router(rpcServer).HandleRPC(grpc.ServicePath,
+			grpc.NewServer(grpc.Handlers{
+				ReportNF2Location: reportHandler,
+				ExchangeLocations: grpc.AnswerLocations(nfLocation),
+			}, cfg.GRPC.MaxMessageSize))
+	}
+
+	// Proxy mode, forwarding the routed requests to the upstream NFs
+	if cfg.Routing.Enabled {
+		proxy, err := routing.New(cfg.Routing, nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the routing: %v", err)
+		}
+		if err = svc.AddServer("PROXY", cfg.Routing.Address); err != nil {
+			return err
+		}
+		for _, pattern := range proxy.Patterns() {
+			svc.Router("PROXY").HandleStream(pattern, proxy, 0)
+		}
+		svc.AddTask("Upstream checker", proxy.Run)
+		if admin := svc.Admin(); admin != nil {
+			admin.Handle("/admin/upstreams", server.JSONHandler(
+				func() interface{} {
+					return proxy.Status()
+				}))
+		}
+	}
+
+	// Subscriptions to the NF1 events
+	notifPath := cfg.NfNotificationResURIPath
+	if notifPath == "" {
+		notifPath = subscription.DefaultPath
+	}
+	subscriptions = subscription.New(cfg.Subscriptions, nfClient, nfStore)
+	subscriptions.BaseURI = svc.URI("API", notifPath)
+	svc.Router("API").Handle(notifPath, subscriptions)
+	svc.Router("API").Handle(notifPath+"/", subscriptions)
+	svc.AddTask("Notifier", subscriptions.Run)
+
+	// Asynchronous mode of the API requests
+	if cfg.Jobs.Enabled {
+		jobsPath := cfg.Jobs.Path
+		if jobsPath == "" {
+			jobsPath = jobs.DefaultPath
+		}
+		nfJobs = jobs.New(cfg.Jobs, nfClient, nfStore)
+		nfJobs.BaseURI = svc.URI("API", jobsPath)
+		svc.Router("API").Handle(jobsPath+"/", nfJobs)
+		svc.AddTask("Job runner", nfJobs.Run)
+	}
+
+	// Event stream of the NF1 events, and history of the exchanges on the
+	// same path for the requests accepting JSON
+	eventHub = events.New(cfg.Events)
+	if cfg.Events.Enabled || cfg.Exchanges.Enabled {
+		eventsPath := cfg.Events.Path
+		if eventsPath == "" {
+			eventsPath = events.DefaultPath
+		}
+		if cfg.Events.Enabled {
+			handler := nfExchanges.Handler(eventHub)
+			svc.Router("API").HandleStream(eventsPath, handler, 0)
+			svc.Router("NF").HandleStream(eventsPath, handler, 0)
+			svc.OnShutdown(eventHub.Close)
+		} else {
+			handler := nfExchanges.Handler(nil)
+			svc.Router("API").Handle(eventsPath, handler)
+			svc.Router("NF").Handle(eventsPath, handler)
+		}
+	}
+
+	// Message queue of the events and callbacks, and consumer of the SBI
+	// requests
+	if cfg.MQ.Backend != "" {
+		nfQueue, err = mq.New(cfg.MQ, "nf1", cfg.TLS.ClientFiles(), nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the message queue: %v", err)
+		}
+		if nfQueue.Callbacks() {
+			subscriptions.Publisher = nfQueue
+		}
+		svc.AddTask("Message queue", nfQueue.Run)
+	}
+
+	// Outbound queue of the requests sent again once their peer recovers
+	if cfg.Outbox.Enabled {
+		nfOutbox, err = outbox.New(cfg.Outbox, nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the outbox: %v", err)
+		}
+		subscriptions.Outbox = nfOutbox
+		svc.AddTask("Outbox", nfOutbox.Run)
+		if admin := svc.Admin(); admin != nil {
+			admin.Handle("/admin/outbox", nfOutbox.Handler())
+		}
+	}
+
+	nfInstanceID := cfg.NRF.NfInstanceID
+	if cfg.NRF.APIRoot != "" {
+		nrf := nrfclient.New(cfg.NRF, nfClient, ver)
+		nfInstanceID = nrf.NfInstanceID()
+		svc.AddTask("NRF client", nrf.Run)
+	}
+	svc.InstanceID = nfInstanceID
+	if cfg.Pod.Enabled && cfg.Pod.ReadinessGate != "" {
+		/* the environment was checked with the configuration */
+		pod, _ := kube.FromEnv(cfg.Pod)
+		gate, err := kube.NewReadiness(pod, cfg.Pod, svc.Ready)
+		if err != nil {
+			return fmt.Errorf("failed to configure the readiness gate: %v",
+				err)
+		}
+		svc.AddTask("Readiness gate", gate.Run)
+	}
+	if cfg.OAuth2.Enabled {
+		tokens = oauth2.New(cfg.Common, nfInstanceID, nfClient)
+		nfClient.SetAuthorizer(tokens)
+	}
+	if cfg.NRF.APIRoot != "" && cfg.Discovery.TargetNfType != "" {
+		nfDiscovery = discovery.New(cfg.NRF.APIRoot, cfg.Discovery, nfClient)
+		nfBalancer, err = client.NewBalancer(cfg.Discovery.LoadBalancing)
+		if err != nil {
+			return fmt.Errorf("failed to configure the discovery: %v", err)
+		}
+	}
+	if cfg.DNS.Enabled {
+		nfResolver = resolver.New(cfg.DNS)
+		nfDNSBalancer, err = client.NewBalancer(cfg.DNS.LoadBalancing)
+		if err != nil {
+			return fmt.Errorf("failed to configure the DNS resolution: %v", err)
+		}
+	}
+
+	if cfg.Tracing.ServiceName == "" {
+		cfg.Tracing.ServiceName = "nf1"
+	}
+	if tracer := tracing.Configure(cfg.Tracing); tracer != nil {
+		svc.AddTask("Tracing exporter", tracer.Run)
+	}
+
+	nfConfig.Watch(svc, cfg, nfClient, tokens, nfReplies, "API", "NF")
+	svc.AddCheck("remote NF", server.TCPCheck(remoteAddr))
+	svc.AddTask("Endpoint checker", nfFailover.Run)
+
+	// Start the Servers until the context is canceled
+	return svc.Run(ctx)
+}
+
+// Validate checks if configuration is valid
+func (cfg *Config) Validate() error {
+	if cfg.HTTPConfig.ApiEndpoint == "" {
+		logging.Errorf("API %s Server endpoint  not configured", ver)
+		return errors.New("API " + ver + " Server endpoint  not configured")
+	}
+
+	if cfg.HTTPConfig.NfEndpoint == "" {
+		logging.Errorf("NF %s Server endpoint not configured", ver)
+		return errors.New("NF " + ver + " Server endpoint  not configured")
+	}
+
+	if ver == "https" {
+		if err := cfg.TLS.Validate("API", "NF"); err != nil {
+			logging.Errorf("TLS configuration error: %v", err)
+			return err
+		}
+	}
+
+	if cfg.CallbackTimeout <= 0 {
+		logging.Errorf("Callback timeout not positive")
+		return fmt.Errorf("callbacktimeout: %d ms is not positive",
+			cfg.CallbackTimeout)
+	}
+
+	/* Check the url type - if its https or http */
+
+	if len(cfg.RemoteNfAPIRoot) == 0 {
+		logging.Errorf("RemoteNfAPIRoot not configured")
+		return errors.New("RemoteNfAPIRoot not configured")
+	}
+	for _, root := range cfg.RemoteNfAPIRoot {
+		if err := config.CheckURL(ver + root); err != nil {
+			logging.Errorf("RemoteNfAPIRoot URL error: %v", err)
+			return fmt.Errorf("invalid RemoteNfAPIRoot %q: %v", root, err)
+		}
+	}
+	return nil
+}
+
+// Endpoints returns the listen addresses of the NF1 server endpoints
+func (cfg *Config) Endpoints() []config.Endpoint {
+	return []config.Endpoint{
+		{Server: "API", Field: "HTTPConfig.apiendpoint",
+			Address: cfg.HTTPConfig.ApiEndpoint},
+		{Server: "NF", Field: "HTTPConfig.nfendpoint",
+			Address: cfg.HTTPConfig.NfEndpoint},
+	}
+}
+
+// defaultConfig returns the configuration used for the fields missing from
+// the configuration file and environment
+func defaultConfig() Config {
+	c := Config{
+		RemoteNfAPIRoot:          config.APIRoots{"://localhost:8090/nf2"},
+		LocalNfAPIRoot:           "://localhost",
+		NfNotificationResURIPath: "/subscriptions",
+		HTTPConfig: HTTPConfig{
+			ApiEndpoint: ":8060",
+			NfEndpoint:  ":8070",
+		},
+		CallbackTimeout: defaultCallbackTimeout,
+	}
+	c.Metrics.Enabled = true
+	return c
+}
+
+// LoadConfig reads and validates the configuration NF1 would run with
+// given the command line settings, without running it
+func LoadConfig(o config.Options) (Config, error) {
+	scheme, err := server.Scheme(o.HTTPVersion)
+	if err != nil {
+		return Config{}, err
+	}
+	opts, ver = o, scheme
+	return nfConfig.Load(o)
+}
+
+// remoteAddr returns the host:port of the active remote NF API root, or the
+// unix:// path of its socket
+func remoteAddr() string {
+	c := currentConfig()
+	u, err := url.Parse(nfFailover.Active())
+	if err != nil {
+		return ""
+	}
+	if socket := c.Peers[u.Host].Socket; socket != "" {
+		return "unix://" + socket
+	}
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), u.Scheme)
+	}
+	return u.Host
+}
+
+// currentConfig returns the configuration in use
+func currentConfig() Config {
+	return nfConfig.Current()
+}
+
+func printConfig(cfg *Config) {
+
+	logging.Infof("********************* NF CONFIGURATION ******************")
+	for _, root := range cfg.RemoteNfAPIRoot {
+		logging.Infof("Remote API: %v", ver+root)
+	}
+	logging.Infof("Local NF API Rootprefix :%v", ver+cfg.LocalNfAPIRoot)
+	logging.Infof("API End Point: %v", cfg.HTTPConfig.ApiEndpoint)
+	logging.Infof("NF End Point: %v", cfg.HTTPConfig.NfEndpoint)
+	logging.Infof("API Certificate: %v", cfg.TLS.ServerFiles("API").CertFile)
+	logging.Infof("NF Certificate: %v", cfg.TLS.ServerFiles("NF").CertFile)
+	logging.Infof("Root CA: %v", cfg.TLS.ClientFiles().CAFile)
+	logging.Infof("Mutual TLS: %v", cfg.TLS.MutualTLS)
+	logging.Infof("NRF: %v", cfg.NRF.APIRoot)
+	logging.Infof("Discovered NF type: %v", cfg.Discovery.TargetNfType)
+	logging.Infof("DNS resolution: %v", cfg.DNS.Enabled)
+	logging.Infof("Subscriptions path: %v", cfg.NfNotificationResURIPath)
+	logging.Infof("Store: %v", cfg.Store.Backend)
+	logging.Infof("*************************************************************")
+
+}
+
+// prepareEndpoints checks the remote NF API roots of a reloaded
+// configuration, and returns the function applying them
+func prepareEndpoints(cfg *Config) (func(), error) {
+	apply, err := nfFailover.PrepareEndpoints(remoteAPIRoots(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("remote NF API roots: %v", err)
+	}
+	return apply, nil
+}
+
+// remoteAPIRoots returns the API roots of the remote NF configured in
+// RemoteNfAPIRoot, without the operation path ending them, if any
+func remoteAPIRoots(c *Config) []string {
+	roots := make([]string, 0, len(c.RemoteNfAPIRoot))
+	for _, root := range c.RemoteNfAPIRoot {
+		roots = append(roots, remoteRoot(root))
+	}
+	return roots
+}
+
+// remoteRoot returns the API root of the remote NF configured as root
+func remoteRoot(root string) string {
+	remote := strings.TrimSuffix(ver+root, api.RequestNF2LocationPath)
+	u, err := url.Parse(remote)
+	if err != nil {
+		return remote
+	}
+	/* h2c peers are reached over http */
+	u.Scheme = nfClient.Scheme(u.Host)
+	return u.String()
+}
+
+// remoteAPIRoot returns the API root of the remote NF and the function to
+// call once the request sent to it completed. It is discovered through the
+// NRF or resolved in DNS when configured, the instance chosen by the load
+// balancing policy, the primary of RemoteNfAPIRoot is used otherwise and
+// fails over to the next ones. The requests of a tenant with its own remote
+// API root go there
+func remoteAPIRoot(ctx context.Context) (string, func()) {
+	if t, ok := server.Tenant(ctx); ok && t.RemoteAPIRoot != "" {
+		return remoteRoot(t.RemoteAPIRoot), func() {}
+	}
+	cfg := currentConfig()
+	remote := nfFailover.Primary()
+	if nfDiscovery == nil && nfResolver != nil {
+		instances, err := nfResolver.Resolve(ctx, remote)
+		if err != nil {
+			logging.FromContext(ctx).Warnf(
+				"DNS resolution failed, using configured endpoints: %v", err)
+			return remote, func() {}
+		}
+		inst, done := nfDNSBalancer.Pick(instances)
+		return inst.APIRoot, done
+	}
+	u, err := url.Parse(remote)
+	if err != nil || nfDiscovery == nil {
+		return remote, func() {}
+	}
+	root, done := nfDiscovery.Pick(ctx, discovery.Query{
+		TargetNfType:    cfg.Discovery.TargetNfType,
+		RequesterNfType: cfg.NRF.NfType,
+		ServiceName:     cfg.Discovery.ServiceName,
+	}, nfBalancer, u.Scheme+"://"+u.Host)
+	return root + u.Path, done
+}
+
+// alternateAPIRoot returns the API root of another NF2 instance the
+// request to root is hedged to, and the function to call once the request
+// completed. It is empty when hedging is disabled or there is no other
+// instance
+func alternateAPIRoot(ctx context.Context, root string) (string, func()) {
+	if !currentConfig().Hedging.Enabled {
+		return "", func() {}
+	}
+	if root == nfFailover.Primary() {
+		return nfFailover.Standby(), func() {}
+	}
+	/* another instance discovered or resolved */
+	alternate, done := remoteAPIRoot(ctx)
+	if alternate == root {
+		done()
+		return "", func() {}
+	}
+	return alternate, done
+}
+
+// requestNF2Location sends the location request to NF2, with the REST
+// operation or the gRPC service as configured for the peer. The REST
+// request is hedged to the alternate API root, if any
+func requestNF2Location(ctx context.Context, root, alternate string,
+	nf2body api.NF) error {
+	nf2 := api.NewClient(root, nfClient.Hedged(nfFailover, root, alternate))
+	host := nf2.Host()
+	if root == nfFailover.Primary() {
+		/* the gRPC requests go to the active API root, without failover */
+		if u, err := url.Parse(nfFailover.Active()); err == nil {
+			host = u.Host
+		}
+	}
+	if host, ok := nfClient.GRPCHost(host); ok {
+		_, err := grpc.NewClient(nfClient.Scheme(host)+"://"+host,
+			nfClient).RequestNF2Location(ctx, nf2body)
+		return err
+	}
+	nf2.ContentType = nfClient.ContentType(nf2.Host())
+	nf2.MessageVersion = nfClient.MessageVersion(nf2.Host())
+	nf2.Metadata = map[string]string{"sender": nfLocation}
+	rsp, err := nf2.RequestNF2Location(ctx, nf2body)
+	if err == nil && rsp.StatusCode() != http.StatusOK {
+		err = fmt.Errorf("remote NF answered %d", rsp.StatusCode())
+		if rsp.Problem != nil {
+			err = fmt.Errorf("remote NF answered %d: %v", rsp.StatusCode(),
+				rsp.Problem)
+		}
+	}
+	return err
+}
+
+// callbackError is the failure to get the NF2 callback of a request
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string {
+	return "no callback from the remote NF: " + e.err.Error()
+}
+
+func (e *callbackError) Unwrap() error {
+	return e.err
+}
+
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	if nfJobs != nil && jobs.Requested(r) {
+		/* answered at once, the job is polled for the location */
+		nfJobs.Accept(w, r, func(ctx context.Context) (interface{}, error) {
+			msg, err := fetchNF2Location(ctx)
+			if err != nil {
+				return nil, locationProblem(ctx, err)
+			}
+			return msg, nil
+		})
+		return
+	}
+	ctx := r.Context()
+	msg, err := fetchNF2Location(ctx)
+	if err != nil {
+		var open *client.CircuitOpenError
+		var overloaded *client.OverloadError
+		switch {
+		case errors.As(err, &open) && open.RetryAfter > 0:
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(open.RetryAfter.Seconds()+0.5)))
+		case errors.As(err, &overloaded):
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(overloaded.RetryAfter.Seconds()+0.5)))
+		}
+		problem.Write(w, locationProblem(ctx, err))
+		return
+	}
+
+	/* in the media type accepted by the API client */
+	message.Write(w, r, http.StatusOK, api.GetNF2LocationMessage, msg,
+		map[string]string{"sender": nfLocation})
+}
+
+// batchHandler fetches the location of NF2 once per request of the batch,
+// concurrently, and answers with their results, in a job when the client
+// asks for the asynchronous mode
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	c := currentConfig().Batch
+	items, ok := batch.Decode(w, r, c)
+	if !ok {
+		return
+	}
+	work := func(ctx context.Context, _ batch.Item) (interface{}, error) {
+		msg, err := fetchNF2Location(ctx)
+		if err != nil {
+			return nil, locationProblem(ctx, err)
+		}
+		return msg, nil
+	}
+	if nfJobs != nil && jobs.Requested(r) {
+		nfJobs.Accept(w, r, func(ctx context.Context) (interface{}, error) {
+			return batch.Run(ctx, c, items, work), nil
+		})
+		return
+	}
+	codec.Write(w, r, http.StatusMultiStatus,
+		batch.Run(r.Context(), c, items, work))
+}
+
+// locationProblem returns the problem details answering a failure of
+// fetchNF2Location
+func locationProblem(ctx context.Context, err error) *problem.Details {
+	var open *client.CircuitOpenError
+	var overloaded *client.OverloadError
+	var noCallback *callbackError
+	switch {
+	case errors.As(err, &open), errors.As(err, &overloaded):
+		/* The remote NF is failing or overloaded, answer right away */
+		return problem.New(http.StatusServiceUnavailable,
+			problem.CauseNFServiceUnavailable, err.Error())
+	case errors.As(err, &noCallback), ctx.Err() == context.DeadlineExceeded:
+		/* the deadline expired while waiting on the remote NF */
+		return problem.New(http.StatusGatewayTimeout,
+			problem.CauseTimedOutRequest, err.Error())
+	}
+	return problem.New(http.StatusBadGateway,
+		problem.CauseTargetNFNotReachable, err.Error())
+}
+
+// fetchNF2Location requests the location of NF2 and returns the callback
+// of NF2 carrying it
+func fetchNF2Location(ctx context.Context) (interface{}, error) {
+	l := logging.FromContext(ctx)
+
+	var nf2body api.NF
+
+	nf2body.Time = api.Now()
+	/* the callbacks of a tenant come back under its prefix or host */
+	nf2body.Location = nfService.TenantURI(ctx, "NF",
+		api.ReportNF2LocationPath)
+	nf2body.CorrelationID = uuid.New()
+	l = l.With(logging.Fields{"correlation_id": nf2body.CorrelationID})
+	server.SetCorrelationID(ctx, nf2body.CorrelationID)
+	wait := time.Duration(currentConfig().CallbackTimeout) * time.Millisecond
+
+	/* Wait for the callback before sending, it may arrive first */
+	waiter := callbacks.Register(nf2body.CorrelationID)
+	defer waiter.Close()
+	/* the callback may reach another replica, which forwards it here */
+	if err := store.PutJSON(ctx, nfStore, correlationsCollection,
+		nf2body.CorrelationID, correlation{Owner: nfLocation},
+		correlationTTL(wait)); err != nil {
+		l.Warnf("Correlation not stored: %v", err)
+	}
+	defer func() {
+		_, _ = nfStore.Delete(context.Background(), correlationsCollection,
+			nf2body.CorrelationID)
+	}()
+
+	l.Infof("Sending a request to the server")
+	root, done := remoteAPIRoot(ctx)
+	alternate, alternateDone := alternateAPIRoot(ctx, root)
+	err := requestNF2Location(ctx, root, alternate, nf2body)
+	done()
+	alternateDone()
+	var open *client.CircuitOpenError
+	var overloaded *client.OverloadError
+	if errors.As(err, &open) || errors.As(err, &overloaded) {
+		l.Warnf("%v", err)
+		return nil, err
+	}
+	if err != nil {
+		l.Errorf("%v", err)
+		return nil, err
+	}
+
+	// wait for the response
+	l.Infof("Waiting for the POST req")
+	msg, err := waiter.Wait(ctx, wait)
+	switch {
+	case errors.Is(err, context.Canceled):
+		/* nobody reads the answer, the correlation is dropped */
+		l.Infof("API request canceled while waiting for the callback")
+		return nil, err
+	case errors.Is(err, broker.ErrTimeout):
+		l.Errorf("No callback from the remote NF within %v", wait)
+		return nil, &callbackError{err: fmt.Errorf("%w after %v", err,
+			wait)}
+	case err != nil:
+		l.Errorf("No callback from the remote NF: %v", err)
+		return nil, &callbackError{err: err}
+	}
+	l.Infof("POST request received")
+	return msg, nil
+}
+
+func nf1Handler(w http.ResponseWriter, r *http.Request, nfBody api.NF) {
+	ctx := r.Context()
+	l := logging.FromContext(ctx)
+	server.SetCorrelationID(ctx, nfBody.CorrelationID)
+
+	key := callbackDedup.Key(nfBody.CorrelationID, nfBody)
+	switch callbackDedup.Begin(ctx, api.ReportNF2LocationPath, key) {
+	case dedup.Duplicate:
+		l.Infof("Duplicate callback acknowledged")
+		nfReplies.Write(w, r, api.ReportNF2LocationPath, nfBody,
+			replyState(false, true))
+		return
+	case dedup.InFlight:
+		/* acknowledged once the first delivery is handled, which may
+		 * still fail */
+		l.Infof("Callback received again while being handled")
+		w.Header().Set("Retry-After", "1")
+		problem.Error(w, http.StatusConflict, "",
+			"callback with this correlation ID being handled")
+		return
+	}
+	// now hand the body to the API request waiting for it
+	if !callbacks.Deliver(nfBody.CorrelationID, nfBody) {
+		forwarded, err := forwardCallback(ctx, nfBody)
+		if err != nil {
+			callbackDedup.Release(ctx, key)
+			l.Errorf("Callback not forwarded: %v", err)
+			problem.Error(w, http.StatusBadGateway,
+				problem.CauseTargetNFNotReachable, err.Error())
+			return
+		}
+		if !forwarded {
+			callbackDedup.Release(ctx, key)
+			l.Warnf("No API request waiting for correlation ID %q",
+				nfBody.CorrelationID)
+			problem.Write(w, problem.New(http.StatusNotFound,
+				problem.CauseContextNotFound, "unknown correlation ID").
+				WithInvalidParams(problem.InvalidParam{
+					Param: "/correlationid", Reason: "no request waiting"}))
+			return
+		}
+		callbackDedup.Handled(ctx, key)
+		nfReplies.Write(w, r, api.ReportNF2LocationPath, nfBody,
+			replyState(true, false))
+		l.Infof("Callback forwarded to the replica waiting for it")
+		return
+	}
+	callbackDedup.Handled(ctx, key)
+	nfReplies.Write(w, r, api.ReportNF2LocationPath, nfBody,
+		replyState(false, false))
+	locationReported(ctx, nfBody)
+	l.Infof("NF1 Handler Completed")
+}
+
+// replyState returns the state of the replies to the callbacks: forwarded
+// to the replica waiting for them, or acknowledged as a duplicate
+func replyState(forwarded, duplicate bool) map[string]interface{} {
+	return map[string]interface{}{"forwarded": forwarded,
+		"duplicate": duplicate}
+}
+
+// mockConfig returns the rules of the mock peer mode, by default answering
+// the location reports of NF2 as nf1Handler does
+func mockConfig(m config.MockConfig) config.MockConfig {
+	if len(m.Rules) > 0 {
+		return m
+	}
+	return config.MockConfig{Rules: []config.MockRule{{
+		Method:    http.MethodPost,
+		Path:      api.ReportNF2LocationPath,
+		Responses: []config.MockResponse{{Body: reply.DefaultBody}},
+	}}}
+}
+
+// reportHandler is the gRPC variant of nf1Handler
+func reportHandler(ctx context.Context, nfBody api.NF) (grpc.Ack, error) {
+	server.SetCorrelationID(ctx, nfBody.CorrelationID)
+	key := callbackDedup.Key(nfBody.CorrelationID, nfBody)
+	switch callbackDedup.Begin(ctx, api.ReportNF2LocationPath, key) {
+	case dedup.Duplicate:
+		logging.FromContext(ctx).Infof("Duplicate callback acknowledged")
+		return ack(ctx, nfBody, replyState(false, true))
+	case dedup.InFlight:
+		return grpc.Ack{}, grpc.Errorf(grpc.Unavailable,
+			"callback %q being handled, to be retried",
+			nfBody.CorrelationID)
+	}
+	if !callbacks.Deliver(nfBody.CorrelationID, nfBody) {
+		forwarded, err := forwardCallback(ctx, nfBody)
+		if err != nil {
+			callbackDedup.Release(ctx, key)
+			return grpc.Ack{}, grpc.Errorf(grpc.Unavailable,
+				"callback not forwarded: %v", err)
+		}
+		if !forwarded {
+			callbackDedup.Release(ctx, key)
+			return grpc.Ack{}, grpc.Errorf(grpc.NotFound,
+				"unknown correlation ID %q", nfBody.CorrelationID)
+		}
+		callbackDedup.Handled(ctx, key)
+		return ack(ctx, nfBody, replyState(true, false))
+	}
+	callbackDedup.Handled(ctx, key)
+	locationReported(ctx, nfBody)
+	return ack(ctx, nfBody, replyState(false, false))
+}
+
+// ack returns the acknowledgement of a location report received over gRPC
+// with the state of the reply
+func ack(ctx context.Context, nfBody api.NF,
+	state map[string]interface{}) (grpc.Ack, error) {
+	msg, err := nfReplies.Message(ctx, api.ReportNF2LocationPath, nfBody,
+		state)
+	if err != nil {
+		return grpc.Ack{}, grpc.Errorf(grpc.Internal, "%v", err)
+	}
+	return grpc.Ack{Message: msg}, nil
+}
+
+// forwardCallback sends a callback nobody waits for here to the replica
+// whose API request waits for it, as recorded in the store. It returns
+// false when no other replica waits for it
+func forwardCallback(ctx context.Context, nfBody api.NF) (bool, error) {
+	var c correlation
+	err := store.GetJSON(ctx, nfStore, correlationsCollection,
+		nfBody.CorrelationID, &c)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if c.Owner == "" || c.Owner == nfLocation {
+		return false, nil
+	}
+	owner := api.NewClient(strings.TrimSuffix(c.Owner,
+		api.ReportNF2LocationPath), nfClient)
+	owner.ContentType = nfClient.ContentType(owner.Host())
+	owner.MessageVersion = nfClient.MessageVersion(owner.Host())
+	owner.Metadata = map[string]string{"sender": nfLocation}
+	rsp, err := owner.ReportNF2Location(ctx, nfBody)
+	if err != nil {
+		return false, err
+	}
+	switch rsp.StatusCode() {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		/* the request of the replica timed out meanwhile */
+		return false, nil
+	}
+	return false, fmt.Errorf("replica %s answered %d", owner.Host(),
+		rsp.StatusCode())
+}
+
+// locationReported records the NF2 location reported, also as a revision
+// of the location resource, and notifies the subscribers
+func locationReported(ctx context.Context, nfBody api.NF) {
+	l := logging.FromContext(ctx)
+	if err := store.PutJSON(ctx, nfStore, nfsCollection, nfBody.Location,
+		nfBody, 0); err != nil {
+		l.Warnf("NF record not stored: %v", err)
+	}
+	if _, err := nfRecord.Record(ctx, nfBody); err != nil {
+		l.Warnf("Location revision not stored: %v", err)
+	}
+	subscriptions.Notify(ctx, locationReportEvent, nfBody)
+	eventHub.Publish(locationReportEvent, nfBody)
+	nfQueue.Publish(locationReportEvent, nfBody)
+	nfService.Publish(locationReportEvent, nfBody)
+}
+
+// nfsHandler answers the last location report of each NF2, by location
+func nfsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		problem.Error(w, http.StatusMethodNotAllowed, "",
+			r.Method+" not allowed")
+		return
+	}
+	values, err := nfStore.List(r.Context(), nfsCollection)
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	nfs := make(map[string]json.RawMessage, len(values))
+	for location, v := range values {
+		nfs[location] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(nfs)
+}
@@ -0,0 +1,550 @@
+// Package nf2 is the NF2 role: it reports its location to the NF1 asking
+// for it
+package nf2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/internal/nfcommon"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/events"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/exchanges"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/faults"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/grpc"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/kube"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/location"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/mock"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/mq"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/nrfclient"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/oauth2"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/outbox"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/record"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/replication"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/reply"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/routing"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tracing"
+)
+
+// opts are the command line settings the NF runs with
+var opts config.Options
+var ver string
+
+// Config contains NF Module Configuration Data Structure
+type Config struct {
+	// API Root for the remote NF
+	NFEndpoint     string `json:"nfendpoint" env:"NF_NF_ENDPOINT"`
+	LocalNfAPIRoot string `json:"localapirootprefix" env:"NF_LOCAL_API_ROOT"`
+	config.Common
+}
+
+// ConfigPath is the default configuration file of NF2
+const ConfigPath string = "config/nf2.json"
+
+// nfConfig loads the NF2 configuration and reloads it
+var nfConfig = &nfcommon.Lifecycle[Config]{
+	Default: defaultConfig,
+	Common:  func(cfg *Config) *config.Common { return &cfg.Common },
+	LocalAPIRoot: func(cfg *Config) *string {
+		return &cfg.LocalNfAPIRoot
+	},
+	Print: printConfig,
+}
+
+var nfClient *client.Client
+var tokens *oauth2.TokenClient
+var nfLocation string
+var eventHub *events.Hub
+
+// nfQueue publishes the NF2 events and callbacks to the message queue, nil
+// without one
+var nfQueue *mq.Queue
+
+// nfOutbox keeps the location reports whose peer is unreachable, to send
+// them again, nil when disabled
+var nfOutbox *outbox.Outbox
+
+// nfRecord is the location resource of the NF1 location requests
+var nfRecord *location.Resource
+
+// nfService mirrors the events to the monitor dashboards
+var nfService *server.Service
+
+// nfReplies renders the responses to the location requests
+var nfReplies *reply.Replies
+
+// Event published when NF2 reported its location to NF1
+const locationReportEvent = "LOCATION_REPORT"
+
+// Run runs NF2 with the command line settings until ctx is canceled, e.g.
+// on SIGTERM. It returns the error failing the startup, if any
+func Run(ctx context.Context, o config.Options) error {
+	opts = o
+	svc, err := server.New("NF2", opts.HTTPVersion)
+	if err != nil {
+		return err
+	}
+	nfService = svc
+	ver = svc.Scheme()
+
+	// Read the configuration
+	cfg, err := nfConfig.Load(opts)
+	printConfig(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load NF configuration: %v", err)
+	}
+	svc.Config = cfg.Common
+
+	if err = logging.Configure(cfg.Log); err != nil {
+		return fmt.Errorf("failed to configure logging: %v", err)
+	}
+	if err = secrets.Configure(cfg.Secrets); err != nil {
+		return fmt.Errorf("failed to configure the secret providers: %v", err)
+	}
+	if err = audit.Configure("NF2", cfg.Audit); err != nil {
+		return fmt.Errorf("failed to open the audit log: %v", err)
+	}
+	svc.APIRoot = cfg.LocalNfAPIRoot
+
+	nfClient, err = client.New(opts.HTTPVersion, "NF2", cfg.Common)
+	if err != nil {
+		return fmt.Errorf("failed to create NF client: %v", err)
+	}
+
+	nfFaults, err := faults.New(cfg.Faults)
+	if err != nil {
+		return fmt.Errorf("failed to configure the fault injection: %v", err)
+	}
+	svc.Faults = nfFaults
+	nfClient.SetFaults(nfFaults)
+
+	nfRecorder, err := record.New(cfg.Record)
+	if err != nil {
+		return fmt.Errorf("failed to configure the recording: %v", err)
+	}
+	defer nfRecorder.Close()
+	svc.Recorder = nfRecorder
+	nfClient.SetRecorder(nfRecorder)
+
+	// Store of the responses replayed to the retried requests
+	nfStore, err := store.Open(cfg.Store)
+	if err != nil {
+		return fmt.Errorf("failed to open the NF store: %v", err)
+	}
+	defer nfStore.Close()
+	var replicator *replication.Replicator
+	if cfg.Replication.Enabled {
+		replicator, err = replication.New(cfg.Replication, cfg.Store.Backend,
+			nfStore, nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the replication: %v", err)
+		}
+		nfStore = replicator
+	}
+	svc.Store = nfStore
+
+	nfExchanges, err := exchanges.New(cfg.Exchanges, nfStore)
+	if err != nil {
+		return fmt.Errorf("failed to configure the exchange history: %v", err)
+	}
+	defer nfExchanges.Close()
+	svc.Exchanges = nfExchanges
+	nfClient.SetExchanges(nfExchanges)
+
+	if err = svc.AddServer("NF2", cfg.NFEndpoint); err != nil {
+		return err
+	}
+	if replicator != nil {
+		svc.Router("NF2").HandleStream(replicator.Path(), replicator.Handler(),
+			0)
+		svc.AddTask("Replication", replicator.Run)
+	}
+	nfLocation = svc.URI("NF2", api.RequestNF2LocationPath)
+	nfRecord = location.New(cfg.Locations, nfStore, "nf2",
+		"RequestedLocation")
+	if nfReplies, err = reply.New("nf2", nfLocation, cfg.Replies); err != nil {
+		return fmt.Errorf("failed to configure the replies: %v", err)
+	}
+	if opts.MockPeer {
+		/* canned responses to NF1 instead of the NF2 logic */
+		m, err := mock.New(mockConfig(cfg.Mock), nfClient, nfLocation)
+		if err != nil {
+			return fmt.Errorf("failed to configure the mock peer: %v", err)
+		}
+		m.Register(svc.Router("NF2"))
+	} else {
+		handler := nfRecord.Handler(
+			api.RequestNF2LocationHandlerFunc(handlerWithCtx))
+		svc.Router("NF2").Handle(api.RequestNF2LocationPath, handler)
+		svc.Router("NF2").Handle(api.RequestNF2LocationPath+
+			location.HistoryPath, handler)
+	}
+	if err = svc.AddAdminServer(); err != nil {
+		return err
+	}
+	if admin := svc.Admin(); admin != nil {
+		admin.Handle("/admin/config", server.JSONHandler(func() interface{} {
+			return currentConfig()
+		}))
+		admin.Handle("/admin/cache", nfClient.CacheHandler())
+	}
+
+	// gRPC service, on the NF2 listener unless it has its own
+	if cfg.GRPC.Enabled {
+		rpcServer := "NF2"
+		if cfg.GRPC.Address != "" {
+			rpcServer = "GRPC"
+			if err = svc.AddServer(rpcServer, cfg.GRPC.Address); err != nil {
+				return err
+			}
+		}
+		svc.Router(rpcServer).HandleRPC(grpc.ServicePath,
+			grpc.NewServer(grpc.Handlers{
+				RequestNF2Location: requestHandler,
+				ExchangeLocations:  grpc.AnswerLocations(nfLocation),
+			}, cfg.GRPC.MaxMessageSize))
+	}
+
+	// Proxy mode, forwarding the routed requests to the upstream NFs
+	if cfg.Routing.Enabled {
+		proxy, err := routing.New(cfg.Routing, nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the routing: %v", err)
+		}
+		if err = svc.AddServer("PROXY", cfg.Routing.Address); err != nil {
+			return err
+		}
+		for _, pattern := range proxy.Patterns() {
+			svc.Router("PROXY").HandleStream(pattern, proxy, 0)
+		}
+		svc.AddTask("Upstream checker", proxy.Run)
+		if admin := svc.Admin(); admin != nil {
+			admin.Handle("/admin/upstreams", server.JSONHandler(
+				func() interface{} {
+					return proxy.Status()
+				}))
+		}
+	}
+
+	// Event stream of the NF2 events, and history of the exchanges on the
+	// same path for the requests accepting JSON
+	eventHub = events.New(cfg.Events)
+	if cfg.Events.Enabled || cfg.Exchanges.Enabled {
+		eventsPath := cfg.Events.Path
+		if eventsPath == "" {
+			eventsPath = events.DefaultPath
+		}
+		if cfg.Events.Enabled {
+			handler := nfExchanges.Handler(eventHub)
+			svc.Router("NF2").HandleStream(eventsPath, handler, 0)
+			svc.OnShutdown(eventHub.Close)
+		} else {
+			handler := nfExchanges.Handler(nil)
+			svc.Router("NF2").Handle(eventsPath, handler)
+		}
+	}
+
+	// Message queue of the events and callbacks, and consumer of the SBI
+	// requests
+	if cfg.MQ.Backend != "" {
+		nfQueue, err = mq.New(cfg.MQ, "nf2", cfg.TLS.ClientFiles(), nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the message queue: %v", err)
+		}
+		svc.AddTask("Message queue", nfQueue.Run)
+	}
+
+	// Outbound queue of the requests sent again once their peer recovers
+	if cfg.Outbox.Enabled {
+		nfOutbox, err = outbox.New(cfg.Outbox, nfClient)
+		if err != nil {
+			return fmt.Errorf("failed to configure the outbox: %v", err)
+		}
+		svc.AddTask("Outbox", nfOutbox.Run)
+		if admin := svc.Admin(); admin != nil {
+			admin.Handle("/admin/outbox", nfOutbox.Handler())
+		}
+	}
+
+	nfInstanceID := cfg.NRF.NfInstanceID
+	if cfg.NRF.APIRoot != "" {
+		nrf := nrfclient.New(cfg.NRF, nfClient, ver)
+		nfInstanceID = nrf.NfInstanceID()
+		svc.AddTask("NRF client", nrf.Run)
+	}
+	svc.InstanceID = nfInstanceID
+	if cfg.Pod.Enabled && cfg.Pod.ReadinessGate != "" {
+		/* the environment was checked with the configuration */
+		pod, _ := kube.FromEnv(cfg.Pod)
+		gate, err := kube.NewReadiness(pod, cfg.Pod, svc.Ready)
+		if err != nil {
+			return fmt.Errorf("failed to configure the readiness gate: %v",
+				err)
+		}
+		svc.AddTask("Readiness gate", gate.Run)
+	}
+	if cfg.OAuth2.Enabled {
+		tokens = oauth2.New(cfg.Common, nfInstanceID, nfClient)
+		nfClient.SetAuthorizer(tokens)
+	}
+
+	if cfg.Tracing.ServiceName == "" {
+		cfg.Tracing.ServiceName = "nf2"
+	}
+	if tracer := tracing.Configure(cfg.Tracing); tracer != nil {
+		svc.AddTask("Tracing exporter", tracer.Run)
+	}
+
+	nfConfig.Watch(svc, cfg, nfClient, tokens, nfReplies, "NF2")
+
+	// Start the Server until the context is canceled
+	return svc.Run(ctx)
+}
+
+// Validate checks if configuration is valid
+func (cfg *Config) Validate() error {
+	if cfg.NFEndpoint == "" {
+		logging.Errorf("NF %s Server endpoint  not configured", ver)
+		return errors.New("NF " + ver + " Server endpoint  not configured")
+	}
+
+	if ver == "https" {
+		if err := cfg.TLS.Validate("NF2"); err != nil {
+			logging.Errorf("TLS configuration error: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Endpoints returns the listen addresses of the NF2 server endpoints
+func (cfg *Config) Endpoints() []config.Endpoint {
+	return []config.Endpoint{
+		{Server: "NF2", Field: "nfendpoint", Address: cfg.NFEndpoint},
+	}
+}
+
+// defaultConfig returns the configuration used for the fields missing from
+// the configuration file and environment
+func defaultConfig() Config {
+	c := Config{
+		NFEndpoint:     ":8090",
+		LocalNfAPIRoot: "://localhost",
+	}
+	c.Metrics.Enabled = true
+	return c
+}
+
+// LoadConfig reads and validates the configuration NF2 would run with
+// given the command line settings, without running it
+func LoadConfig(o config.Options) (Config, error) {
+	scheme, err := server.Scheme(o.HTTPVersion)
+	if err != nil {
+		return Config{}, err
+	}
+	opts, ver = o, scheme
+	return nfConfig.Load(o)
+}
+
+// currentConfig returns the configuration in use
+func currentConfig() Config {
+	return nfConfig.Current()
+}
+
+func printConfig(cfg *Config) {
+
+	logging.Infof("********************* NF CONFIGURATION ******************")
+	logging.Infof("NF2 End Point: %v", cfg.NFEndpoint)
+	logging.Infof("NF2 Local API Root Prefix: %v", ver+cfg.LocalNfAPIRoot)
+	logging.Infof("NF2 Certificate: %v", cfg.TLS.ServerFiles("NF2").CertFile)
+	logging.Infof("Root CA: %v", cfg.TLS.ClientFiles().CAFile)
+	logging.Infof("Mutual TLS: %v", cfg.TLS.MutualTLS)
+	logging.Infof("NRF: %v", cfg.NRF.APIRoot)
+	logging.Infof("*************************************************************")
+
+}
+
+func handlerWithCtx(w http.ResponseWriter, r *http.Request,
+	nf1Body api.NF) {
+
+	ctx := r.Context()
+
+	l := logging.FromContext(r.Context())
+	server.SetCorrelationID(ctx, nf1Body.CorrelationID)
+
+	l.Infof("NF2 Request received")
+	if err := nfClient.CheckCallback(ctx, nf1Body.Location); err != nil {
+		problem.Write(w, problem.New(http.StatusBadRequest,
+			problem.CauseMandatoryIEIncorrect, "location not allowed").
+			WithInvalidParams(problem.InvalidParam{Param: "/location",
+				Reason: err.Error()}))
+		return
+	}
+
+	nfReplies.Write(w, r, api.RequestNF2LocationPath, nf1Body,
+		locationRequested(ctx, nf1Body))
+
+	defer l.Infof("NF2 Handler Completed")
+	/* the reply is written: the report failure is only logged */
+	if err := reportLocation(ctx, nf1Body); err != nil {
+		l.Errorf("%v", err)
+	}
+}
+
+// mockConfig returns the rules of the mock peer mode, by default answering
+// NF1 as handlerWithCtx does: the response, then the location report one
+// second later
+func mockConfig(m config.MockConfig) config.MockConfig {
+	if len(m.Rules) > 0 {
+		return m
+	}
+	return config.MockConfig{Rules: []config.MockRule{{
+		Method: http.MethodPost,
+		Path:   api.RequestNF2LocationPath,
+		Responses: []config.MockResponse{{
+			Body: reply.DefaultBody,
+			Callback: &config.MockCallback{
+				Delay: 1000,
+				URL:   "{{.Body.location}}",
+				Body: `{"correlationid": {{json .Body.correlationid}}, ` +
+					`"location": {{json .Location}}, "time": {{json .Now}}}`,
+			},
+		}},
+	}}}
+}
+
+// requestHandler is the gRPC variant of handlerWithCtx
+func requestHandler(ctx context.Context, nf1Body api.NF) (grpc.Ack, error) {
+	server.SetCorrelationID(ctx, nf1Body.CorrelationID)
+	if err := nfClient.CheckCallback(ctx, nf1Body.Location); err != nil {
+		return grpc.Ack{}, grpc.Errorf(grpc.InvalidArgument,
+			"location not allowed: %v", err)
+	}
+	state := locationRequested(ctx, nf1Body)
+	if err := reportLocation(ctx, nf1Body); err != nil {
+		return grpc.Ack{}, err
+	}
+	msg, err := nfReplies.Message(ctx, api.RequestNF2LocationPath, nf1Body,
+		state)
+	if err != nil {
+		return grpc.Ack{}, grpc.Errorf(grpc.Internal, "%v", err)
+	}
+	return grpc.Ack{Message: msg}, nil
+}
+
+// locationRequested records the NF1 location of a request as a revision of
+// the location resource, and returns the state of the record the replies
+// are rendered with
+func locationRequested(ctx context.Context,
+	nf1Body api.NF) map[string]interface{} {
+	rev, err := nfRecord.Record(ctx, nf1Body)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("Location revision not stored: %v",
+			err)
+		return nil
+	}
+	return map[string]interface{}{"revision": rev.Revision}
+}
+
+// reportLocation reports the NF2 location to the NF1 that sent nf1Body,
+// one second later. Only the end of the context is returned, the failures
+// of the report are logged
+func reportLocation(ctx context.Context, nf1Body api.NF) error {
+	l := logging.FromContext(ctx)
+	select {
+	case <-time.After(1 * time.Second):
+		/* Send a POST with the body received */
+		nf1location := nf1Body.Location
+
+		nf1Body.Location = nfService.TenantURI(ctx, "NF2",
+			api.RequestNF2LocationPath)
+		nf1Body.Time = api.Now()
+
+		l.Infof("Sending a request to the NF1 server")
+		root := strings.TrimSuffix(nf1location, api.ReportNF2LocationPath)
+		if err := sendReport(ctx, root, nf1Body); err != nil {
+			if req, rerr := reportRequest(ctx, root, nf1Body); rerr == nil &&
+				nfOutbox.Defer(req, err) {
+				return nil
+			}
+			l.Errorf("%v", err)
+			return nil
+		}
+		eventHub.Publish(locationReportEvent, nf1Body)
+		nfQueue.Publish(locationReportEvent, nf1Body)
+		nfService.Publish(locationReportEvent, nf1Body)
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendReport sends the location report to NF1, with the REST operation or
+// the gRPC service as configured for the peer, or publishes it to the
+// message queue with the callbacks going through it. The location is a
+// callback URI given by NF1
+func sendReport(ctx context.Context, root string, nf1Body api.NF) error {
+	if nfQueue.Callbacks() {
+		return publishReport(ctx, root, nf1Body)
+	}
+	ctx = nfClient.CallbackContext(ctx)
+	nf1 := api.NewClient(root, nfClient)
+	if host, ok := nfClient.GRPCHost(nf1.Host()); ok {
+		_, err := grpc.NewClient(nfClient.Scheme(host)+"://"+host,
+			nfClient).ReportNF2Location(ctx, nf1Body)
+		return err
+	}
+	nf1.ContentType = nfClient.ContentType(nf1.Host())
+	nf1.MessageVersion = nfClient.MessageVersion(nf1.Host())
+	nf1.Metadata = map[string]string{"sender": nfLocation}
+	rsp, err := nf1.ReportNF2Location(ctx, nf1Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("NF1 answered %d: %s", rsp.StatusCode(),
+			logging.FromContext(ctx).Body(rsp.Body))
+	}
+	return nil
+}
+
+// publishReport publishes the location report to the message queue, for a
+// consumer to send it to NF1
+func publishReport(ctx context.Context, root string, nf1Body api.NF) error {
+	req, err := reportRequest(ctx, root, nf1Body)
+	if err != nil {
+		return err
+	}
+	return nfQueue.PublishRequest(req)
+}
+
+// reportRequest returns the JSON POST request of the location report
+func reportRequest(ctx context.Context, root string, nf1Body api.NF) (
+	*http.Request, error) {
+	body, err := json.Marshal(nf1Body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		root+api.ReportNF2LocationPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req.WithContext(ctx), nil
+}
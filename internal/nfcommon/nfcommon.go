@@ -0,0 +1,237 @@
+// Package nfcommon is the configuration lifecycle shared by the NF roles:
+// loading the configuration, reloading it when its file changes and
+// applying the certificates renewed
+package nfcommon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/kube"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/oauth2"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/reply"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+)
+
+// Lifecycle loads the configuration C of an NF role and, once the role
+// runs, reloads it. The hooks give the parts of C the role keeps apart
+// from config.Common
+type Lifecycle[C any] struct {
+	// Default returns the configuration used for the fields missing from
+	// the configuration file and environment
+	Default func() C
+	// Common returns the sections of the configuration shared by the roles
+	Common func(cfg *C) *config.Common
+	// LocalAPIRoot returns the local API root prefix, whose host is the
+	// pod's when enabled
+	LocalAPIRoot func(cfg *C) *string
+	// Print logs the configuration
+	Print func(cfg *C)
+	// Prepare, if set, checks the role settings of a reloaded
+	// configuration, and returns the function applying them
+	Prepare func(cfg *C) (func(), error)
+
+	opts config.Options
+
+	// Services reloaded, set by Watch
+	svc     *server.Service
+	client  *client.Client
+	tokens  *oauth2.TokenClient
+	replies *reply.Replies
+
+	// mu guards cfg and err once the servers are started
+	mu  sync.RWMutex
+	cfg C
+	// err is the error of the last configuration reload
+	err error
+}
+
+// Load reads the configuration with the command line settings: the
+// defaults, then the configuration file, the NF_ environment variables and
+// the -set flags. The log flags and the pod hosts are applied to it
+func (l *Lifecycle[C]) Load(o config.Options) (C, error) {
+	l.opts = o
+	return l.load()
+}
+
+func (l *Lifecycle[C]) load() (C, error) {
+	o := l.opts
+	c := l.Default()
+	loader := &config.Loader{Path: o.ConfigFile, Section: o.Section,
+		EnvPrefix: "NF", Overrides: o.Overrides}
+	err := loader.Load(&c)
+	l.applyFlags(&c)
+	if err == nil {
+		err = l.applyPod(&c)
+	}
+	return c, err
+}
+
+// applyFlags overrides the configured log settings with the flags
+func (l *Lifecycle[C]) applyFlags(cfg *C) {
+	common := l.Common(cfg)
+	if l.opts.LogLevel != "" {
+		common.Log.Level = l.opts.LogLevel
+	}
+	if l.opts.LogFormat != "" {
+		common.Log.Format = l.opts.LogFormat
+	}
+}
+
+// applyPod replaces the hosts of the local API root prefix and of the NRF
+// profile with those of the pod the NF runs in, when enabled
+func (l *Lifecycle[C]) applyPod(cfg *C) error {
+	common := l.Common(cfg)
+	if !common.Pod.Enabled {
+		return nil
+	}
+	if err := common.Pod.Validate(); err != nil {
+		return err
+	}
+	pod, err := kube.FromEnv(common.Pod)
+	if err != nil {
+		return fmt.Errorf("pod: %v", err)
+	}
+	root := l.LocalAPIRoot(cfg)
+	*root = pod.APIRoot(*root, common.Pod.Advertise)
+	common.NRF = pod.NRF(common.NRF)
+	return nil
+}
+
+// Watch keeps cfg as the configuration in use and adds to svc the check of
+// the configuration reloads and the watchers of the configuration file, of
+// the certificates of the servers and of the secrets. The reloads apply to
+// svc, c, tokens when not nil and replies
+func (l *Lifecycle[C]) Watch(svc *server.Service, cfg C, c *client.Client,
+	tokens *oauth2.TokenClient, replies *reply.Replies, servers ...string) {
+	l.svc, l.client, l.tokens, l.replies = svc, c, tokens, replies
+	l.mu.Lock()
+	l.cfg = cfg
+	l.mu.Unlock()
+
+	svc.AddCheck("config", l.check)
+	svc.AddTask("Config watcher", config.NewWatcher(l.opts.ConfigFile,
+		l.reloadConfig).Run)
+	if svc.Version == 2 {
+		svc.AddTask("Certificate watcher", config.NewFilesWatcher(
+			func() []string {
+				cfg := l.Current()
+				return l.Common(&cfg).CertFiles(servers...)
+			}, l.reloadCertificates).Run)
+	}
+	svc.AddTask("Secrets watcher", secrets.NewWatcher(
+		l.Common(&cfg).Secrets, l.reloadCertificates).Run)
+}
+
+// Current returns the configuration in use
+func (l *Lifecycle[C]) Current() C {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cfg
+}
+
+// reloadConfig reads the configuration file again and applies the settings
+// that do not need a restart: the log settings, the TLS material, the
+// client peers and retry policy, the replies and the role settings of
+// Prepare. They are all loaded before any is applied, so that a
+// configuration that fails to load or validate is ignored as a whole
+func (l *Lifecycle[C]) reloadConfig() {
+	var err error
+	defer func() { l.audit(audit.ConfigChange, err) }()
+	newCfg, err := l.load()
+	l.setError(err)
+	if err != nil {
+		logging.Errorf("Configuration not reloaded: %v", err)
+		return
+	}
+	common := l.Common(&newCfg)
+	applyLog, err := logging.Prepare(common.Log)
+	if err != nil {
+		logging.Errorf("Configuration not reloaded: %v", err)
+		return
+	}
+	applyServer, err := l.svc.PrepareReload(*common)
+	if err != nil {
+		logging.Errorf("Configuration not reloaded: %v", err)
+		return
+	}
+	applyClient, err := l.client.PrepareReload(*common)
+	if err != nil {
+		logging.Errorf("Client configuration not reloaded: %v", err)
+		return
+	}
+	applyRole := func() {}
+	if l.Prepare != nil {
+		if applyRole, err = l.Prepare(&newCfg); err != nil {
+			logging.Errorf("Configuration not reloaded: %v", err)
+			return
+		}
+	}
+	applyReplies, err := l.replies.Prepare(common.Replies)
+	if err != nil {
+		logging.Errorf("Replies not reloaded: %v", err)
+		return
+	}
+	applyLog()
+	applyServer()
+	applyClient()
+	applyRole()
+	if l.tokens != nil {
+		l.tokens.Reload(*common)
+	}
+	applyReplies()
+	l.mu.Lock()
+	l.cfg = newCfg
+	l.mu.Unlock()
+	logging.Infof("Configuration reloaded")
+	l.Print(&newCfg)
+}
+
+// reloadCertificates applies the certificates, keys and CA bundles of the
+// configuration in use after their files changed, e.g. renewed by
+// cert-manager. The new certificates are used from the next handshake
+func (l *Lifecycle[C]) reloadCertificates() {
+	var err error
+	defer func() { l.audit(audit.CertRotation, err) }()
+	c := l.Current()
+	common := l.Common(&c)
+	if err = l.svc.Reload(*common); err != nil {
+		logging.Errorf("Certificates not reloaded: %v", err)
+		return
+	}
+	if err = l.client.Reload(*common); err != nil {
+		logging.Errorf("Client certificates not reloaded: %v", err)
+		return
+	}
+	logging.Infof("Certificates reloaded")
+}
+
+// audit writes the outcome of a configuration or certificate reload to the
+// audit log
+func (l *Lifecycle[C]) audit(eventType string, err error) {
+	e := audit.Event{Type: eventType, Outcome: audit.Outcome(err),
+		Action: "reload " + l.opts.ConfigFile}
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	audit.Log(context.Background(), e)
+}
+
+func (l *Lifecycle[C]) setError(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+}
+
+// check fails when the last configuration reload failed
+func (l *Lifecycle[C]) check(context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.err
+}
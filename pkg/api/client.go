@@ -0,0 +1,183 @@
+// Package api contains the types, client methods and server handler
+// adapters of the NF location operations, generated from the OpenAPI
+// specification in api/openapi.json
+package api
+
+//go:generate go run ../../api/gen -spec ../../api/openapi.json -tag location -o api.gen.go
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/message"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Doer sends the requests of the client, e.g. a *client.Client applying the
+// retry policy and the circuit breakers
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client calls the operations on a server
+type Client struct {
+	// Server is the API root prefix of the server, e.g.
+	// http://localhost:8090. The operation paths are appended to it
+	Server string
+	// ContentType is the media type of the request bodies, also preferred
+	// for the responses, e.g. application/cbor. JSON when empty
+	ContentType string
+	// MessageVersion is the version of the messages sent, also preferred
+	// for the responses: message.V1 for the bare messages of the older
+	// peers. message.Current when 0
+	MessageVersion int
+	// Metadata is added to the envelope of the messages from version 2
+	// on, e.g. the sending NF
+	Metadata map[string]string
+	doer     Doer
+}
+
+// NewClient creates a client of the server sending the requests with doer
+func NewClient(server string, doer Doer) *Client {
+	return &Client{Server: server, doer: doer}
+}
+
+// Host returns the host:port of the server, empty when Server is not a
+// URL
+func (c *Client) Host() string {
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// newRequest builds a request to the path of the server, the body the
+// payload of a message of type typ encoded with the codec of the client
+// content type, in the client message version
+func (c *Client) newRequest(typ, method, path string, body interface{}) (
+	*http.Request, error) {
+	cd, err := codec.ForContentType(c.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	version := c.MessageVersion
+	if version == 0 {
+		version = message.Current
+	}
+	return newRequest(method, strings.TrimRight(c.Server, "/")+path, cd,
+		version, typ, body, c.Metadata)
+}
+
+// newRequest builds a request with the body, the payload of a message of
+// type typ in the version, encoded by the codec, nil for no body. The codec
+// media type in the version is accepted for the responses, along with the
+// problem details
+func newRequest(method, url string, cd codec.Codec, version int, typ string,
+	body interface{}, metadata map[string]string) (*http.Request, error) {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		data, ct, err := cd.Marshal(message.Wrap(version, typ, body,
+			metadata))
+		if err != nil {
+			return nil, err
+		}
+		reader, contentType = bytes.NewReader(data),
+			message.ContentType(ct, version)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", message.ContentType(cd.MediaType(), version)+
+		", "+problem.ContentType)
+	return req, nil
+}
+
+// readBody reads and closes the response body
+func readBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func mediaType(header http.Header) string {
+	mt, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mt
+}
+
+// decodable tells whether the response has a body in a media type with a
+// codec, the problem details excluded
+func decodable(resp *http.Response) bool {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" || isProblem(resp) {
+		return false
+	}
+	_, err := codec.ForContentType(contentType)
+	return err == nil
+}
+
+// unmarshal decodes the payload of the message of type typ in the response
+// body with the codec of its media type
+func unmarshal(resp *http.Response, body []byte, typ string,
+	v interface{}) error {
+	contentType := resp.Header.Get("Content-Type")
+	cd, err := codec.ForContentType(contentType)
+	if err != nil {
+		return err
+	}
+	_, err = message.Unmarshal(cd, body, contentType, typ, v)
+	return err
+}
+
+func isProblem(resp *http.Response) bool {
+	return mediaType(resp.Header) == problem.ContentType
+}
+
+// decodeBody decodes the payload of the message of type typ in the body of
+// the request into v with the codec of its Content-Type, leaving the body
+// readable by the handler. It answers with a 400 problem and returns false
+// when the body is empty or malformed, and with a 415 problem when the
+// media type has no codec or the message version is not supported
+func decodeBody(w http.ResponseWriter, r *http.Request, typ string,
+	v interface{}) bool {
+	contentType := r.Header.Get("Content-Type")
+	cd, err := codec.ForContentType(contentType)
+	if err != nil {
+		problem.Write(w, problem.New(http.StatusUnsupportedMediaType, "",
+			err.Error()))
+		return false
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			problem.Write(w, problem.FromDecodeError(err))
+			return false
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		problem.Write(w, problem.FromDecodeError(io.EOF))
+		return false
+	}
+	metadata, err := message.Unmarshal(cd, body, contentType, typ, v)
+	if err != nil {
+		problem.Write(w, message.Problem(err))
+		return false
+	}
+	if metadata != nil {
+		logging.FromContext(r.Context()).Debugf("%s message metadata: %v",
+			typ, metadata)
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// legacyTimeLayout is the format of time.Time.String, sent by the older
+// peers
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Time is the time of a message, encoded in RFC 3339 with the fractional
+// seconds. The format of the older peers is decoded too during the
+// transition
+type Time struct {
+	time.Time
+}
+
+// Now returns the current time, without the monotonic clock reading
+func Now() Time {
+	return Time{time.Now().Round(0)}
+}
+
+// ParseTime parses an RFC 3339 time or one in the format of the older
+// peers, the time.Time.String format with or without the monotonic clock
+// reading
+func ParseTime(s string) (Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return Time{t}, nil
+	}
+	legacy := s
+	if i := strings.Index(legacy, " m="); i >= 0 {
+		legacy = legacy[:i]
+	}
+	if t, legacyErr := time.Parse(legacyTimeLayout, legacy); legacyErr == nil {
+		return Time{t}, nil
+	}
+	return Time{}, err
+}
+
+// String returns the RFC 3339 form of the time
+func (t Time) String() string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON decodes a time string as ParseTime does, rejecting the
+// other values and the unparseable times
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("time: %s is not a string", data)
+	}
+	parsed, err := ParseTime(s)
+	if err != nil {
+		return fmt.Errorf("time: %q is not an RFC 3339 time", s)
+	}
+	*t = parsed
+	return nil
+}
@@ -0,0 +1,289 @@
+// Package audit writes the security relevant events of the NF to an
+// append-only audit log, separate from the log: authentication failures,
+// authorization denials, configuration changes, certificate rotations and
+// admin actions. The records are JSON objects numbered in sequence, each
+// carrying the SHA-256 hash of the previous one, so that Verify detects the
+// records removed, inserted or altered
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+)
+
+// Event types
+const (
+	AuthnFailure = "authn_failure"
+	AuthzDenied  = "authz_denied"
+	ConfigChange = "config_change"
+	CertRotation = "cert_rotation"
+	AdminAction  = "admin_action"
+)
+
+// Outcomes of the events
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// tailSize is the end of the audit file read to resume its sequence
+const tailSize = 1 << 20
+
+var (
+	events = metrics.NewCounterVec("nf_audit_events_total",
+		"Events written to the audit log by type and outcome.", "type",
+		"outcome")
+	writeFailures = metrics.NewCounterVec("nf_audit_write_failures_total",
+		"Events the audit log failed to write.")
+)
+
+// Event is a security relevant event
+type Event struct {
+	Type    string
+	Outcome string
+	// Subject is the identity of the client: certificate name, token
+	// subject or source address
+	Subject string
+	// Action is what was done or attempted, e.g. "PUT /admin/faults"
+	Action string
+	Reason string
+	// Fields are the details of the event
+	Fields map[string]interface{}
+}
+
+// Record is an event as written to the audit log
+type Record struct {
+	Seq       uint64                 `json:"seq"`
+	Time      time.Time              `json:"time"`
+	NF        string                 `json:"nf"`
+	Type      string                 `json:"type"`
+	Outcome   string                 `json:"outcome"`
+	Subject   string                 `json:"subject,omitempty"`
+	Action    string                 `json:"action,omitempty"`
+	Reason    string                 `json:"reason,omitempty"`
+	RequestID string                 `json:"requestid,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	// Prev is the hash of the previous record, empty for the first one
+	Prev string `json:"prev"`
+	// Hash is the hex SHA-256 of the record encoded with an empty Hash
+	Hash string `json:"hash"`
+}
+
+// hash returns the hash of the record
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Logger writes the records of an NF
+type Logger struct {
+	mu   sync.Mutex
+	nf   string
+	out  io.WriteCloser
+	seq  uint64
+	prev string
+}
+
+// New opens the audit log of cfg for the named NF, nil when disabled. A
+// file resumes the sequence of its last record
+func New(nf string, cfg config.AuditConfig) (*Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	l := &Logger{nf: nf}
+	if cfg.Output == config.AuditSyslog {
+		out, err := dialSyslog(cfg.Syslog)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to syslog: %v", err)
+		}
+		l.out = out
+		return l, nil
+	}
+	file := filepath.Clean(cfg.File)
+	last, err := lastRecord(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading the audit log: %v", err)
+	}
+	l.seq, l.prev = last.Seq, last.Hash
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening the audit log: %v", err)
+	}
+	l.out = f
+	return l, nil
+}
+
+// lastRecord returns the last record of the file, the zero record when the
+// file is missing or empty
+func lastRecord(file string) (Record, error) {
+	var last Record
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return last, nil
+	}
+	if err != nil {
+		return last, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return last, err
+	}
+	offset := info.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return last, err
+	}
+	lines := bytes.Split(bytes.TrimSpace(tail), []byte("\n"))
+	line := lines[len(lines)-1]
+	if len(line) == 0 {
+		return last, nil
+	}
+	if err := json.Unmarshal(line, &last); err != nil {
+		return last, fmt.Errorf("last record: %v", err)
+	}
+	return last, nil
+}
+
+// Log writes the event, with the request ID of the context
+func (l *Logger) Log(ctx context.Context, e Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := Record{Seq: l.seq + 1, Time: time.Now().UTC(), NF: l.nf,
+		Type: e.Type, Outcome: e.Outcome, Subject: e.Subject,
+		Action: e.Action, Reason: e.Reason,
+		RequestID: requestid.FromContext(ctx), Fields: e.Fields,
+		Prev: l.prev}
+	var err error
+	if r.Hash, err = r.hash(); err == nil {
+		var b []byte
+		if b, err = json.Marshal(r); err == nil {
+			_, err = l.out.Write(append(b, '\n'))
+		}
+	}
+	if err != nil {
+		writeFailures.WithLabelValues().Inc()
+		logging.Errorf("Audit event %s of %s not written: %v", e.Type,
+			e.Subject, err)
+		return
+	}
+	l.seq, l.prev = r.Seq, r.Hash
+	events.WithLabelValues(e.Type, e.Outcome).Inc()
+}
+
+// Close closes the audit log
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+var (
+	mu  sync.Mutex
+	std *Logger
+)
+
+// Configure opens the audit log of the process, closing the previous one.
+// The events are not written when it is disabled
+func Configure(nf string, cfg config.AuditConfig) error {
+	l, err := New(nf, cfg)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	prev := std
+	std = l
+	mu.Unlock()
+	return prev.Close()
+}
+
+// Log writes the event to the audit log of the process
+func Log(ctx context.Context, e Event) {
+	mu.Lock()
+	l := std
+	mu.Unlock()
+	l.Log(ctx, e)
+}
+
+// Outcome returns Success for a nil error, Failure otherwise
+func Outcome(err error) string {
+	if err != nil {
+		return Failure
+	}
+	return Success
+}
+
+// Verify checks the records of an audit log: the sequence numbers follow
+// each other, each record carries the hash of the previous one, and its
+// own hash matches its content. It returns the number of records checked
+// and the first problem found. A log whose first records were rotated out
+// is checked from its first record on
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), tailSize)
+	n := 0
+	var prev Record
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		var rec Record
+		dec := json.NewDecoder(bytes.NewReader(line))
+		/* the numbers of the fields are hashed as written */
+		dec.UseNumber()
+		if err := dec.Decode(&rec); err != nil {
+			return n, fmt.Errorf("record %d: %v", n, err)
+		}
+		hash, err := rec.hash()
+		if err != nil {
+			return n, fmt.Errorf("record %d: %v", n, err)
+		}
+		switch {
+		case hash != rec.Hash:
+			return n, fmt.Errorf("record %d (seq %d): content altered",
+				n, rec.Seq)
+		case n > 1 && rec.Seq != prev.Seq+1:
+			return n, fmt.Errorf("record %d: seq %d follows seq %d", n,
+				rec.Seq, prev.Seq)
+		case n > 1 && rec.Prev != prev.Hash:
+			return n, fmt.Errorf("record %d (seq %d): chain broken, the "+
+				"previous record was altered or removed", n, rec.Seq)
+		}
+		prev = rec
+	}
+	return n, scanner.Err()
+}
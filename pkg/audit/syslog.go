@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// Default syslog settings
+const (
+	defaultSyslogNetwork  = "unixgram"
+	defaultSyslogAddress  = "/dev/log"
+	defaultSyslogFacility = 10
+	defaultSyslogTag      = "nfservice"
+	syslogDialTimeout     = 5 * time.Second
+	// severities of the failures and of the other events
+	severityWarning = 4
+	severityNotice  = 5
+)
+
+// syslogWriter sends each record as an RFC 5424 message, redialing the
+// server after a failure. The stream networks frame the messages with
+// their length (RFC 6587 octet counting)
+type syslogWriter struct {
+	cfg      config.SyslogConfig
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func dialSyslog(cfg config.SyslogConfig) (*syslogWriter, error) {
+	if cfg.Network == "" {
+		cfg.Network = defaultSyslogNetwork
+	}
+	if cfg.Address == "" {
+		cfg.Address = defaultSyslogAddress
+	}
+	if cfg.Facility == 0 {
+		cfg.Facility = defaultSyslogFacility
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = defaultSyslogTag
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	w := &syslogWriter{cfg: cfg, hostname: hostname}
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *syslogWriter) dial() error {
+	conn, err := net.DialTimeout(w.cfg.Network, w.cfg.Address,
+		syslogDialTimeout)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+// Write sends a JSON record, retrying once on a new connection
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msg := w.format(bytes.TrimRight(p, "\n"))
+	var err error
+	for i := 0; i < 2; i++ {
+		if w.conn == nil {
+			if err = w.dial(); err != nil {
+				continue
+			}
+		}
+		if _, err = w.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	return 0, err
+}
+
+// format returns the RFC 5424 message of the record, the failures with the
+// warning severity
+func (w *syslogWriter) format(record []byte) []byte {
+	severity := severityNotice
+	if bytes.Contains(record, []byte(`"outcome":"`+Failure+`"`)) {
+		severity = severityWarning
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d audit - %s",
+		w.cfg.Facility*8+severity,
+		time.Now().UTC().Format(time.RFC3339Nano), w.hostname, w.cfg.Tag,
+		os.Getpid(), record)
+	switch w.cfg.Network {
+	case "tcp", "unix":
+		return []byte(strconv.Itoa(len(msg)) + " " + msg)
+	}
+	return []byte(msg)
+}
+
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
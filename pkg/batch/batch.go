@@ -0,0 +1,196 @@
+// Package batch runs the requests of a batch concurrently, a bounded number
+// at once, and answers with the result of each of them in a Multi-Status
+// body: the batch succeeds as a whole with 207 even when some of its
+// requests fail, each result carrying the status of its request and its
+// body or problem details, e.g.
+//
+//	[{"id": "a"}, {"id": "b"}]
+//
+//	207 Multi-Status
+//	{"results": [{"id": "a", "status": 200, "body": {...}},
+//	  {"id": "b", "status": 504, "problem": {...}}],
+//	 "succeeded": 1, "failed": 1}
+package batch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+const (
+	defaultMaxItems    = 100
+	defaultParallelism = 8
+)
+
+var items = metrics.NewCounterVec("nf_batch_items_total",
+	"Requests of the batches run, by status code.", "code")
+
+// Item is a request of a batch
+type Item struct {
+	// ID identifies the request in the results, its index in the batch
+	// when empty
+	ID string `json:"id,omitempty"`
+}
+
+// Result is the outcome of a request of a batch
+type Result struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	// Body is the answer to a request that succeeded
+	Body interface{} `json:"body,omitempty"`
+	// Problem is the failure of a request
+	Problem *problem.Details `json:"problem,omitempty"`
+}
+
+// Response is the Multi-Status answer to a batch, the results in the order
+// of the requests
+type Response struct {
+	Results   []Result `json:"results"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+}
+
+// Work runs a request of a batch, returning its answer or its failure, the
+// problem details giving the status when it is a *problem.Details
+type Work func(ctx context.Context, item Item) (interface{}, error)
+
+// Decode reads the requests of the batch in the body of r, a JSON or CBOR
+// array, naming those without ID after their index. It answers with a 400
+// problem and returns false when the body is malformed or empty, has more
+// requests than the configuration allows or two requests with the same ID,
+// and with 415 when the media type has no codec
+func Decode(w http.ResponseWriter, r *http.Request,
+	cfg config.BatchConfig) ([]Item, bool) {
+	contentType := r.Header.Get("Content-Type")
+	cd, err := codec.ForContentType(contentType)
+	if err != nil {
+		problem.Write(w, problem.New(http.StatusUnsupportedMediaType, "",
+			err.Error()))
+		return nil, false
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			problem.Write(w, problem.FromDecodeError(err))
+			return nil, false
+		}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		problem.Write(w, problem.FromDecodeError(io.EOF))
+		return nil, false
+	}
+	var batch []Item
+	if err := cd.Unmarshal(body, contentType, &batch); err != nil {
+		problem.Write(w, problem.FromDecodeError(err))
+		return nil, false
+	}
+	max := cfg.MaxItems
+	if max <= 0 {
+		max = defaultMaxItems
+	}
+	switch {
+	case len(batch) == 0:
+		problem.Error(w, http.StatusBadRequest,
+			problem.CauseMandatoryIEMissing, "empty batch")
+		return nil, false
+	case len(batch) > max:
+		problem.Error(w, http.StatusBadRequest,
+			problem.CauseMandatoryIEIncorrect,
+			fmt.Sprintf("%d requests in the batch, at most %d", len(batch),
+				max))
+		return nil, false
+	}
+	seen := make(map[string]int, len(batch))
+	for i := range batch {
+		if batch[i].ID == "" {
+			batch[i].ID = strconv.Itoa(i)
+		}
+		if j, ok := seen[batch[i].ID]; ok {
+			problem.Write(w, problem.New(http.StatusBadRequest,
+				problem.CauseMandatoryIEIncorrect, "duplicate request ID").
+				WithInvalidParams(problem.InvalidParam{
+					Param: fmt.Sprintf("/%d/id", i),
+					Reason: fmt.Sprintf("%q is the ID of request %d",
+						batch[i].ID, j)}))
+			return nil, false
+		}
+		seen[batch[i].ID] = i
+	}
+	return batch, true
+}
+
+// Run runs the work of each request of the batch, at most the parallelism
+// of the configuration at once, and returns their results once all are
+// done. A request not started when ctx is done fails without running
+func Run(ctx context.Context, cfg config.BatchConfig, batch []Item,
+	work Work) *Response {
+	parallelism := cfg.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	l := logging.FromContext(ctx)
+	resp := &Response{Results: make([]Result, len(batch))}
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, item := range batch {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			resp.Results[i] = failure(item, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(i int, item Item) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			il := l.With(logging.Fields{"batch_item": item.ID})
+			body, err := work(logging.NewContext(ctx, il), item)
+			if err != nil {
+				il.Warnf("Batch request %s failed: %v", item.ID, err)
+				resp.Results[i] = failure(item, err)
+				return
+			}
+			resp.Results[i] = Result{ID: item.ID, Status: http.StatusOK,
+				Body: body}
+		}(i, item)
+	}
+	wg.Wait()
+	for _, res := range resp.Results {
+		items.WithLabelValues(strconv.Itoa(res.Status)).Inc()
+		if res.Problem == nil {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+// failure returns the result of a request that failed with err
+func failure(item Item, err error) Result {
+	var p *problem.Details
+	if !errors.As(err, &p) {
+		status, cause := http.StatusInternalServerError,
+			problem.CauseSystemFailure
+		if errors.Is(err, context.DeadlineExceeded) ||
+			errors.Is(err, context.Canceled) {
+			status, cause = http.StatusGatewayTimeout,
+				problem.CauseTimedOutRequest
+		}
+		p = problem.New(status, cause, err.Error())
+	}
+	return Result{ID: item.ID, Status: p.Status, Problem: p}
+}
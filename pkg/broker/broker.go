@@ -0,0 +1,81 @@
+// Package broker routes the asynchronous answers received by an NF to the
+// handler waiting for them, matched by correlation ID
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned by Wait when no answer arrived in time
+var ErrTimeout = errors.New("timed out waiting for the answer")
+
+// Broker holds a channel per pending correlation ID
+type Broker struct {
+	mu      sync.Mutex
+	pending map[string]chan interface{}
+}
+
+// New creates an empty broker
+func New() *Broker {
+	return &Broker{pending: make(map[string]chan interface{})}
+}
+
+// Waiter receives the answer of one correlation ID
+type Waiter struct {
+	id string
+	b  *Broker
+	ch chan interface{}
+}
+
+// Register starts waiting for the answer of the correlation ID. It must be
+// called before the request is sent, so that a fast answer is not lost, and
+// the waiter must be closed once done with
+func (b *Broker) Register(id string) *Waiter {
+	ch := make(chan interface{}, 1)
+	b.mu.Lock()
+	b.pending[id] = ch
+	b.mu.Unlock()
+	return &Waiter{id: id, b: b, ch: ch}
+}
+
+// Deliver hands the answer to the waiter of the correlation ID. It returns
+// false when nobody waits for it, e.g. the waiter timed out
+func (b *Broker) Deliver(id string, msg interface{}) bool {
+	b.mu.Lock()
+	ch, ok := b.pending[id]
+	delete(b.pending, id)
+	b.mu.Unlock()
+	if !ok {
+		return false
+	}
+	ch <- msg
+	return true
+}
+
+// Wait returns the answer, or an error when the timeout expires or the
+// context is canceled first
+func (w *Waiter) Wait(ctx context.Context, timeout time.Duration) (
+	interface{}, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-w.ch:
+		return msg, nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops waiting for the correlation ID
+func (w *Waiter) Close() {
+	w.b.mu.Lock()
+	if w.b.pending[w.id] == w.ch {
+		delete(w.b.pending, w.id)
+	}
+	w.b.mu.Unlock()
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+var balancedRequests = metrics.NewCounterVec(
+	"nf_client_balanced_requests_total",
+	"Requests sent to the peer instances chosen by the load balancing "+
+		"policy, by policy and instance.", "policy", "instance")
+
+// Instance is a peer NF instance a Balancer chooses from
+type Instance struct {
+	// APIRoot of the instance, e.g. https://10.0.0.1:8090
+	APIRoot string
+	// Priority of the instance, the lowest value being the highest
+	// priority
+	Priority int
+	// Capacity of the instance relative to the other ones
+	Capacity int
+}
+
+// Balancer chooses the peer instance a request is sent to
+type Balancer interface {
+	// Pick returns the instance of the list, not empty, the request is
+	// sent to and the function to call once the request completed
+	Pick(instances []Instance) (Instance, func())
+}
+
+var (
+	balancersMu sync.RWMutex
+	balancers   = map[string]func() Balancer{
+		config.BalanceRoundRobin:    func() Balancer { return &roundRobin{} },
+		config.BalanceLeastRequests: newLeastRequests,
+		config.BalanceWeighted:      func() Balancer { return weighted{} },
+	}
+)
+
+// RegisterBalancer adds a load balancing policy, replacing the policy of
+// the same name. newBalancer is called for each peer or route using it
+func RegisterBalancer(policy string, newBalancer func() Balancer) {
+	balancersMu.Lock()
+	defer balancersMu.Unlock()
+	balancers[policy] = newBalancer
+}
+
+// Policies returns the names of the load balancing policies, sorted
+func Policies() []string {
+	balancersMu.RLock()
+	defer balancersMu.RUnlock()
+	names := make([]string, 0, len(balancers))
+	for name := range balancers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBalancer returns a balancer of the policy, round robin when empty.
+// The requests it chooses an instance for are counted per instance
+func NewBalancer(policy string) (Balancer, error) {
+	if policy == "" {
+		policy = config.BalanceRoundRobin
+	}
+	balancersMu.RLock()
+	newBalancer, ok := balancers[policy]
+	balancersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown load balancing policy %q", policy)
+	}
+	return counted{policy: policy, Balancer: newBalancer()}, nil
+}
+
+// counted counts the instances chosen by the balancer
+type counted struct {
+	policy string
+	Balancer
+}
+
+func (c counted) Pick(instances []Instance) (Instance, func()) {
+	inst, done := c.Balancer.Pick(instances)
+	balancedRequests.WithLabelValues(c.policy, inst.APIRoot).Inc()
+	return inst, done
+}
+
+func noop() {}
+
+// roundRobin chooses the instances in turn
+type roundRobin struct {
+	next atomic.Uint32
+}
+
+func (b *roundRobin) Pick(instances []Instance) (Instance, func()) {
+	n := uint32(len(instances))
+	return instances[(b.next.Add(1)-1)%n], noop
+}
+
+// leastRequests chooses the instance with the fewest requests in progress,
+// in turn among the ones with as few
+type leastRequests struct {
+	mu          sync.Mutex
+	outstanding map[string]int
+	next        int
+}
+
+func newLeastRequests() Balancer {
+	return &leastRequests{outstanding: make(map[string]int)}
+}
+
+func (b *leastRequests) Pick(instances []Instance) (Instance, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(instances)
+	b.next++
+	best := -1
+	for i := 0; i < n; i++ {
+		j := (b.next + i) % n
+		if best < 0 || b.outstanding[instances[j].APIRoot] <
+			b.outstanding[instances[best].APIRoot] {
+			best = j
+		}
+	}
+	inst := instances[best]
+	b.outstanding[inst.APIRoot]++
+	var once sync.Once
+	return inst, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.outstanding[inst.APIRoot]--
+			if b.outstanding[inst.APIRoot] <= 0 {
+				delete(b.outstanding, inst.APIRoot)
+			}
+		})
+	}
+}
+
+// weighted chooses among the instances of the highest priority, the
+// lowest value, at random in proportion to their capacity. An instance
+// without capacity counts as 1
+type weighted struct{}
+
+func (weighted) Pick(instances []Instance) (Instance, func()) {
+	best := instances[0].Priority
+	for _, inst := range instances[1:] {
+		if inst.Priority < best {
+			best = inst.Priority
+		}
+	}
+	var candidates []Instance
+	total := 0
+	for _, inst := range instances {
+		if inst.Priority == best {
+			candidates = append(candidates, inst)
+			total += capacity(inst)
+		}
+	}
+	n := rand.Intn(total)
+	for _, inst := range candidates {
+		if n -= capacity(inst); n < 0 {
+			return inst, noop
+		}
+	}
+	return candidates[len(candidates)-1], noop
+}
+
+func capacity(inst Instance) int {
+	if inst.Capacity <= 0 {
+		return 1
+	}
+	return inst.Capacity
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCooldown         = 10 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// ErrCircuitOpen is returned, wrapped in a *CircuitOpenError, for the
+// requests rejected by an open circuit breaker
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned without sending the request when the circuit
+// of the peer is open
+type CircuitOpenError struct {
+	Peer string
+	// RetryAfter is the time left before the circuit lets probes through
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Peer, ErrCircuitOpen)
+}
+
+// Unwrap makes errors.Is(err, ErrCircuitOpen) true
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// Circuit states, also the values of the state gauge
+type circuitState int
+
+const (
+	stateClosed circuitState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+var stateNames = []string{"closed", "open", "half-open"}
+
+func (s circuitState) String() string {
+	return stateNames[s]
+}
+
+var (
+	circuitStateGauge = metrics.NewGaugeVec("nf_client_circuit_state",
+		"Circuit breaker state per peer: 0 closed, 1 open, 2 half-open.",
+		"peer")
+	circuitRejections = metrics.NewCounterVec(
+		"nf_client_circuit_rejections_total",
+		"Requests rejected by an open circuit breaker.", "peer")
+)
+
+// breaker is the circuit breaker of one peer host:port
+type breaker struct {
+	peer string
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func (b *breaker) setState(s circuitState) {
+	b.state = s
+	circuitStateGauge.WithLabelValues(b.peer).Set(float64(s))
+}
+
+// breakers holds the circuit breakers of the peers
+type breakers struct {
+	enabled   bool
+	threshold int
+	cooldown  time.Duration
+	probes    int
+
+	mu    sync.Mutex
+	peers map[string]*breaker
+}
+
+func newBreakers(cfg config.BreakerConfig) *breakers {
+	bs := &breakers{
+		enabled:   cfg.Enabled,
+		threshold: cfg.FailureThreshold,
+		cooldown:  time.Duration(cfg.Cooldown) * time.Millisecond,
+		probes:    cfg.HalfOpenRequests,
+		peers:     make(map[string]*breaker),
+	}
+	if bs.threshold <= 0 {
+		bs.threshold = defaultFailureThreshold
+	}
+	if bs.cooldown <= 0 {
+		bs.cooldown = defaultCooldown
+	}
+	if bs.probes <= 0 {
+		bs.probes = defaultHalfOpenRequests
+	}
+	return bs
+}
+
+func (bs *breakers) get(peer string) *breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.peers[peer]
+	if !ok {
+		b = &breaker{peer: peer}
+		b.setState(stateClosed)
+		bs.peers[peer] = b
+	}
+	return b
+}
+
+// allow returns the breaker of the peer when the request may be sent, or a
+// *CircuitOpenError. Every allowed request must be followed by done
+func (bs *breakers) allow(peer string) (*breaker, error) {
+	if !bs.enabled {
+		return nil, nil
+	}
+	b := bs.get(peer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateOpen {
+		left := bs.cooldown - time.Since(b.openedAt)
+		if left > 0 {
+			circuitRejections.WithLabelValues(peer).Inc()
+			return nil, &CircuitOpenError{Peer: peer, RetryAfter: left}
+		}
+		b.setState(stateHalfOpen)
+		b.probes = 0
+	}
+	if b.state == stateHalfOpen {
+		if b.probes >= bs.probes {
+			circuitRejections.WithLabelValues(peer).Inc()
+			return nil, &CircuitOpenError{Peer: peer}
+		}
+		b.probes++
+	}
+	return b, nil
+}
+
+// done records the outcome of a request allowed by the breaker
+func (bs *breakers) done(b *breaker, resp *http.Response, err error) {
+	if b == nil {
+		return
+	}
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateHalfOpen {
+		b.probes--
+	}
+	if errors.Is(err, ErrDryRun) {
+		/* the peer was not reached */
+		return
+	}
+	if !failed {
+		b.failures = 0
+		if b.state != stateClosed {
+			b.setState(stateClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= bs.threshold {
+		b.setState(stateOpen)
+		b.openedAt = time.Now()
+	}
+}
+
+// BreakerStatus is the state of the circuit breaker of a peer
+type BreakerStatus struct {
+	Peer     string `json:"peer"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+}
+
+// Breakers returns the state of the circuit breakers, sorted by peer
+func (c *Client) Breakers() []BreakerStatus {
+	c.breakers.mu.Lock()
+	list := make([]*breaker, 0, len(c.breakers.peers))
+	for _, b := range c.breakers.peers {
+		list = append(list, b)
+	}
+	c.breakers.mu.Unlock()
+
+	out := make([]BreakerStatus, 0, len(list))
+	for _, b := range list {
+		b.mu.Lock()
+		state := b.state
+		if state == stateOpen &&
+			time.Since(b.openedAt) >= c.breakers.cooldown {
+			state = stateHalfOpen
+		}
+		out = append(out, BreakerStatus{Peer: b.peer,
+			State: state.String(), Failures: b.failures})
+		b.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Peer < out[j].Peer
+	})
+	return out
+}
+
+// BreakerHandler serves the state of the circuit breakers as JSON
+func (c *Client) BreakerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c.Breakers())
+	})
+}
@@ -0,0 +1,337 @@
+package client
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	defaultCacheEntries = 1000
+	defaultCacheMaxBody = 64 << 10
+)
+
+var cacheRequests = metrics.NewCounterVec("nf_client_cache_requests_total",
+	"GET requests to the peer NFs by cache result: hit, revalidated, miss "+
+		"or bypass.", "peer", "result")
+
+// cachedResponse is a response kept by the cache
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expiry  time.Time
+	element *list.Element
+}
+
+// responseCache keeps the responses to the GET requests, the most recently
+// used first
+type responseCache struct {
+	mu         sync.Mutex
+	enabled    bool
+	maxEntries int
+	maxBody    int
+	entries    map[string]*cachedResponse
+	lru        *list.List
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]*cachedResponse),
+		lru: list.New()}
+}
+
+// configure applies the settings, dropping the responses kept when the
+// cache is disabled
+func (rc *responseCache) configure(cfg config.ClientCacheConfig) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.enabled = cfg.Enabled
+	rc.maxEntries = cfg.MaxEntries
+	if rc.maxEntries <= 0 {
+		rc.maxEntries = defaultCacheEntries
+	}
+	rc.maxBody = cfg.MaxBodySize
+	if rc.maxBody <= 0 {
+		rc.maxBody = defaultCacheMaxBody
+	}
+	if !rc.enabled {
+		rc.entries = make(map[string]*cachedResponse)
+		rc.lru.Init()
+	}
+	rc.evict()
+}
+
+// do answers a GET request from the cache while the response kept is
+// fresh, revalidates it with If-None-Match once stale, and keeps the
+// cacheable responses sent by send. The responses to the other methods
+// invalidate the responses kept for their URL
+func (rc *responseCache) do(req *http.Request,
+	send func(*http.Request) (*http.Response, error)) (*http.Response,
+	error) {
+	rc.mu.Lock()
+	enabled := rc.enabled
+	rc.mu.Unlock()
+	if !enabled {
+		return send(req)
+	}
+	if req.Method != http.MethodGet {
+		resp, err := send(req)
+		if err == nil && req.Method != http.MethodHead &&
+			req.Method != http.MethodOptions && resp.StatusCode < 400 {
+			rc.Invalidate(req.URL.String())
+		}
+		return resp, err
+	}
+	peer := req.URL.Host
+	directives := cacheControl(req.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		cacheRequests.WithLabelValues(peer, "bypass").Inc()
+		return send(req)
+	}
+	key := cacheKey(req)
+	cached := rc.get(key)
+	_, noCache := directives["no-cache"]
+	if cached != nil && !noCache && time.Now().Before(cached.expiry) {
+		cacheRequests.WithLabelValues(peer, "hit").Inc()
+		return cached.response(req), nil
+	}
+	if cached != nil && req.Header.Get("If-None-Match") == "" {
+		if tag := cached.header.Get("ETag"); tag != "" {
+			next, err := rewind(req)
+			if err == nil {
+				req = next
+				req.Header.Set("If-None-Match", tag)
+			}
+		}
+	}
+	resp, err := send(req)
+	if err != nil {
+		return resp, err
+	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified &&
+		req.Header.Get("If-None-Match") == cached.header.Get("ETag") {
+		discard(resp)
+		rc.refresh(cached, resp.Header)
+		cacheRequests.WithLabelValues(peer, "revalidated").Inc()
+		return cached.response(req), nil
+	}
+	cacheRequests.WithLabelValues(peer, "miss").Inc()
+	return rc.store(key, resp)
+}
+
+// store keeps the response when it is cacheable, and returns it with its
+// body readable again
+func (rc *responseCache) store(key string,
+	resp *http.Response) (*http.Response, error) {
+	expiry, ok := freshness(resp)
+	if !ok || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	rc.mu.Lock()
+	maxBody := rc.maxBody
+	rc.mu.Unlock()
+	if resp.ContentLength > int64(maxBody) {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxBody)+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxBody {
+		/* too large, the rest is read by the caller */
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	entry := &cachedResponse{key: key, status: resp.StatusCode,
+		header: resp.Header.Clone(), body: body, stored: time.Now(),
+		expiry: expiry}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if prev, ok := rc.entries[key]; ok {
+		rc.lru.Remove(prev.element)
+	}
+	entry.element = rc.lru.PushFront(entry)
+	rc.entries[key] = entry
+	rc.evict()
+	return resp, nil
+}
+
+// get returns the response kept for the key, nil when there is none
+func (rc *responseCache) get(key string) *cachedResponse {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil
+	}
+	rc.lru.MoveToFront(entry.element)
+	return entry
+}
+
+// refresh updates the freshness of a response kept after its revalidation
+func (rc *responseCache) refresh(entry *cachedResponse, header http.Header) {
+	expiry, ok := freshness(&http.Response{Header: header})
+	if !ok {
+		expiry = time.Now()
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry.stored = time.Now()
+	entry.expiry = expiry
+}
+
+// evict drops the least recently used responses above the limit. rc.mu is
+// held
+func (rc *responseCache) evict() {
+	for rc.lru.Len() > rc.maxEntries {
+		oldest := rc.lru.Back()
+		rc.lru.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// Invalidate drops the responses kept for the URLs starting with prefix,
+// all of them when prefix is empty
+func (rc *responseCache) Invalidate(prefix string) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	n := 0
+	for key, entry := range rc.entries {
+		if strings.HasPrefix(urlOf(key), prefix) {
+			rc.lru.Remove(entry.element)
+			delete(rc.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// response returns a response to the request with the kept status, header
+// and body, and its Age
+func (e *cachedResponse) response(req *http.Request) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// cacheKey returns the key of the response to the request: its URL and
+// the media types it accepts
+func cacheKey(req *http.Request) string {
+	return req.URL.String() + "\n" + req.Header.Get("Accept")
+}
+
+// urlOf returns the URL of a cache key
+func urlOf(key string) string {
+	if i := strings.IndexByte(key, '\n'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// freshness returns the time until which the response is fresh, per its
+// Cache-Control max-age or its Expires, and false when it must not be
+// kept. A response with no-cache or without freshness is kept when it has
+// an ETag, stale at once, to be revalidated
+func freshness(resp *http.Response) (time.Time, bool) {
+	now := time.Now()
+	directives := cacheControl(resp.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return now, false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return now, false
+	}
+	_, noCache := directives["no-cache"]
+	if maxAge, ok := directives["max-age"]; ok && !noCache {
+		if secs, err := strconv.Atoi(maxAge); err == nil && secs > 0 {
+			return now.Add(time.Duration(secs) * time.Second), true
+		}
+	}
+	if expires := resp.Header.Get("Expires"); expires != "" && !noCache {
+		if t, err := http.ParseTime(expires); err == nil && t.After(now) {
+			return t, true
+		}
+	}
+	return now, resp.Header.Get("ETag") != ""
+}
+
+// cacheControl parses the directives of a Cache-Control value, lower
+// cased, with their argument if any
+func cacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] =
+			strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
+
+// CacheStatus is the state of the response cache
+type CacheStatus struct {
+	Enabled bool `json:"enabled"`
+	Entries int  `json:"entries"`
+}
+
+// InvalidateCache drops the responses kept for the URLs starting with
+// prefix, all of them when prefix is empty, e.g. after the peer changed
+// them in a way the client cannot see. It returns the number dropped
+func (c *Client) InvalidateCache(prefix string) int {
+	return c.cache.Invalidate(prefix)
+}
+
+// CacheHandler serves the state of the response cache as JSON on GET, and
+// drops the responses kept for the URLs starting with the prefix query
+// parameter on DELETE
+func (c *Client) CacheHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			c.cache.mu.Lock()
+			status := CacheStatus{Enabled: c.cache.enabled,
+				Entries: len(c.cache.entries)}
+			c.cache.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(status)
+		case http.MethodDelete:
+			n := c.InvalidateCache(r.URL.Query().Get("prefix"))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]int{"dropped": n})
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+// metadataAddrs are the cloud metadata services outside the link-local
+// ranges
+var metadataAddrs = []net.IP{
+	net.ParseIP("100.100.100.200"),
+	net.ParseIP("fd00:ec2::254"),
+}
+
+// callbackKey is the context key of the guard of the callback requests
+type callbackKey struct{}
+
+// callbackNamedKey is the context key of whether the host of a callback
+// request is trusted by its name, its addresses then being dialed outside
+// the CIDRs of the hosts
+type callbackNamedKey struct{}
+
+// callbackGuard checks the callback URIs and the addresses they are dialed
+// at
+type callbackGuard struct {
+	schemes []string
+	// names and nets are the hosts allowed, any when both are empty
+	names []string
+	nets  []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newCallbackGuard returns the guard of the configuration, nil when it is
+// disabled
+func newCallbackGuard(cfg config.CallbacksConfig) (*callbackGuard, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	g := &callbackGuard{schemes: cfg.Schemes}
+	if len(g.schemes) == 0 {
+		g.schemes = []string{"http", "https"}
+	}
+	for _, h := range cfg.Hosts {
+		if !strings.Contains(h, "/") {
+			g.names = append(g.names, strings.ToLower(h))
+			continue
+		}
+		_, n, _ := net.ParseCIDR(h)
+		g.nets = append(g.nets, n)
+	}
+	for _, d := range cfg.Deny {
+		_, n, _ := net.ParseCIDR(d)
+		g.deny = append(g.deny, n)
+	}
+	return g, nil
+}
+
+// refused returns why the address is refused, empty when it is not
+func (g *callbackGuard) refused(ip net.IP) string {
+	switch {
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return "link-local address"
+	case ip.IsUnspecified(), ip.IsMulticast():
+		return "unspecified or multicast address"
+	}
+	for _, m := range metadataAddrs {
+		if ip.Equal(m) {
+			return "metadata address"
+		}
+	}
+	for _, n := range g.deny {
+		if n.Contains(ip) {
+			return "denied address"
+		}
+	}
+	return ""
+}
+
+// allowedName tells whether the host name matches a host pattern
+func (g *callbackGuard) allowedName(host string) bool {
+	host = strings.ToLower(host)
+	for _, p := range g.names {
+		if ok, _ := path.Match(p, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedIP tells whether the address belongs to a CIDR of the hosts, true
+// when no host is listed
+func (g *callbackGuard) allowedIP(ip net.IP) bool {
+	if len(g.names) == 0 && len(g.nets) == 0 {
+		return true
+	}
+	for _, n := range g.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the error refusing the URI with its reason for the
+// metrics. The configured peers are trusted, the host names allowed need
+// not resolve, e.g. reached through a proxy
+func (g *callbackGuard) check(ctx context.Context, uri string,
+	peers map[string]config.PeerConfig) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return "uri", fmt.Errorf("%q is not an absolute URI", uri)
+	}
+	if !containsFold(g.schemes, u.Scheme) {
+		return "scheme", fmt.Errorf("scheme %q not allowed", u.Scheme)
+	}
+	if _, ok := peers[u.Host]; ok {
+		return "", nil
+	}
+	host := u.Hostname()
+	named := g.allowedName(host)
+	if !named && len(g.nets) == 0 && len(g.names) > 0 {
+		return "host", fmt.Errorf("host %s not allowed", host)
+	}
+	var ips []net.IP
+	literal := net.ParseIP(host)
+	if literal != nil {
+		ips = []net.IP{literal}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			if named {
+				return "", nil
+			}
+			return "resolution", fmt.Errorf("host %s not resolved: %v",
+				host, err)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if why := g.refused(ip); why != "" && literal != nil {
+			return "address", fmt.Errorf("%s is a %s", ip, why)
+		} else if why != "" {
+			return "address", fmt.Errorf("host %s resolves to the %s %s",
+				host, why, ip)
+		}
+		if !named && !g.allowedIP(ip) {
+			return "host", fmt.Errorf("host %s not allowed", host)
+		}
+	}
+	return "", nil
+}
+
+// control refuses to open the connections of the callback requests to the
+// refused addresses, and unless the host is trusted by its name, to the
+// addresses outside the CIDRs of the hosts, e.g. of a host resolving to
+// another address since it was checked
+func (g *callbackGuard) control(ctx context.Context, _, address string,
+	_ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	if why := g.refused(ip); why != "" {
+		callbacksRefused.WithLabelValues("address").Inc()
+		return fmt.Errorf("callback connection to the %s %s refused", why,
+			ip)
+	}
+	if named, _ := ctx.Value(callbackNamedKey{}).(bool); !named &&
+		!g.allowedIP(ip) {
+		callbacksRefused.WithLabelValues("host").Inc()
+		return fmt.Errorf("callback connection to %s refused, out of the "+
+			"hosts allowed", ip)
+	}
+	return nil
+}
+
+// callbackHost returns the request to a callback URI with whether its
+// host, the peer, is trusted by its name in the context: a configured
+// peer, a host name allowed, or the SCP the request is routed through
+func (c *Client) callbackHost(req *http.Request, peer string) *http.Request {
+	g, ok := req.Context().Value(callbackKey{}).(*callbackGuard)
+	if !ok {
+		return req
+	}
+	c.mu.RLock()
+	_, configured := c.peers[peer]
+	c.mu.RUnlock()
+	named := configured || req.URL.Host != peer ||
+		g.allowedName(req.URL.Hostname())
+	return req.WithContext(context.WithValue(req.Context(),
+		callbackNamedKey{}, named))
+}
+
+// CheckCallback checks a callback URI given by a client against the
+// callbacks configuration: its scheme, its host and the addresses the host
+// resolves to. The URIs refused are logged and counted, all are accepted
+// when the checks are disabled
+func (c *Client) CheckCallback(ctx context.Context, uri string) error {
+	c.mu.RLock()
+	g, peers := c.callbacks, c.peers
+	c.mu.RUnlock()
+	if g == nil {
+		return nil
+	}
+	reason, err := g.check(ctx, uri, peers)
+	if err != nil {
+		callbacksRefused.WithLabelValues(reason).Inc()
+		logging.FromContext(ctx).Warnf("Callback to %s refused: %v", uri,
+			err)
+	}
+	return err
+}
+
+// CallbackContext returns the context of the requests sent to a callback
+// URI, whose connections are refused when they are opened to a refused
+// address, and whose bodies are signed with the signatures enabled
+func (c *Client) CallbackContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, callbackRequestKey{}, true)
+	c.mu.RLock()
+	g := c.callbacks
+	c.mu.RUnlock()
+	if g == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, callbackKey{}, g)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+func newGuard(t *testing.T, cfg config.CallbacksConfig) *callbackGuard {
+	t.Helper()
+	cfg.Enabled = true
+	g, err := newCallbackGuard(cfg)
+	if err != nil {
+		t.Fatalf("newCallbackGuard: %v", err)
+	}
+	return g
+}
+
+// dial opens a connection to the address through the guard
+func dial(g *callbackGuard, address string) error {
+	d := net.Dialer{ControlContext: g.control}
+	c, err := d.DialContext(context.Background(), "tcp", address)
+	if err == nil {
+		_ = c.Close()
+	}
+	return err
+}
+
+func TestCallbackDial(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	g := newGuard(t, config.CallbacksConfig{
+		Deny: []string{"10.20.99.0/24"}})
+	denyLoopback := newGuard(t, config.CallbacksConfig{
+		Deny: []string{"127.0.0.0/8", "::1/128"}})
+	tests := []struct {
+		name    string
+		guard   *callbackGuard
+		address string
+		refused string
+	}{
+		{"link-local", g, "169.254.10.1:80", "link-local address"},
+		{"instance metadata", g, "169.254.169.254:80",
+			"link-local address"},
+		{"alibaba metadata", g, "100.100.100.200:80", "metadata address"},
+		{"aws IPv6 metadata", g, "[fd00:ec2::254]:80", "metadata address"},
+		{"IPv6 link-local", g, "[fe80::1]:80", "link-local address"},
+		{"denied CIDR", g, "10.20.99.7:80", "denied address"},
+		{"name resolving to a denied CIDR", denyLoopback,
+			net.JoinHostPort("localhost", port), "denied address"},
+		{"allowed", g, srv.Listener.Addr().String(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dial(tt.guard, tt.address)
+			switch {
+			case tt.refused == "" && err != nil:
+				t.Fatalf("refused: %v", err)
+			case tt.refused != "" && (err == nil ||
+				!strings.Contains(err.Error(), tt.refused)):
+				t.Fatalf("error %v, want %q", err, tt.refused)
+			}
+		})
+	}
+}
+
+func TestCallbackCheck(t *testing.T) {
+	g := newGuard(t, config.CallbacksConfig{Schemes: []string{"https"},
+		Hosts: []string{"*.nf1.example.org", "127.0.0.0/8"},
+		Deny:  []string{"127.0.0.2/32"}})
+	peers := map[string]config.PeerConfig{"peer.example.org:8080": {}}
+	tests := []struct {
+		name   string
+		uri    string
+		reason string
+	}{
+		{"allowed name", "https://a.nf1.example.org/cb", ""},
+		{"allowed CIDR", "https://127.0.0.1:8080/cb", ""},
+		{"configured peer", "https://peer.example.org:8080/cb", ""},
+		{"disallowed scheme", "http://a.nf1.example.org/cb", "scheme"},
+		{"gopher scheme", "gopher://127.0.0.1/cb", "scheme"},
+		{"relative", "/cb", "uri"},
+		{"link-local", "https://169.254.169.254/latest", "address"},
+		{"metadata", "https://100.100.100.200/latest", "address"},
+		{"IPv6 metadata", "https://[fd00:ec2::254]/latest", "address"},
+		{"denied address", "https://127.0.0.2/cb", "address"},
+		{"out of the hosts", "https://192.0.2.1/cb", "host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := g.check(context.Background(), tt.uri, peers)
+			if reason != tt.reason || (err == nil) != (tt.reason == "") {
+				t.Fatalf("reason %q, error %v, want %q", reason, err,
+					tt.reason)
+			}
+		})
+	}
+}
+
+func TestCallbackCheckResolved(t *testing.T) {
+	g := newGuard(t, config.CallbacksConfig{
+		Deny: []string{"127.0.0.0/8", "::1/128"}})
+	reason, err := g.check(context.Background(), "http://localhost:8080/cb",
+		nil)
+	if reason != "address" || err == nil ||
+		!strings.Contains(err.Error(), "resolves to the denied address") {
+		t.Fatalf("reason %q, error %v, want the denied address", reason, err)
+	}
+}
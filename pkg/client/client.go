@@ -0,0 +1,499 @@
+// Package client contains the outgoing HTTP/HTTP2 client used by an NF to
+// reach its peers
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/deadline"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/exchanges"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/faults"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/hooks"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/priority"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/ratelimit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/record"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/scp"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/signature"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tracing"
+)
+
+// Client sends requests to the peer NFs
+type Client struct {
+	// UserAgent is set on every outgoing request
+	UserAgent string
+
+	version    int
+	breakers   *breakers
+	authorizer Authorizer
+	// faults injects faults in the requests sent, none when nil
+	faults *faults.Injector
+	// recorder records the requests sent, none when nil
+	recorder *record.Recorder
+	// exchanges keeps the history of the requests sent, none when nil
+	exchanges *exchanges.Log
+	// latencies keeps the response times the hedging delays follow
+	latencies latencies
+	// cache keeps the responses to the GET requests
+	cache *responseCache
+	// overloads keeps the overload control information of the peers
+	overloads *overloads
+
+	// settings replaced by Reload
+	mu         sync.RWMutex
+	peers      map[string]config.PeerConfig
+	http       *http.Client
+	transports *transports
+	retry      *retryPolicy
+	hedging    *hedgingPolicy
+	// limits holds the outbound token bucket of each peer
+	limits *ratelimit.Limiter
+	// scp is the API root of the SCP the requests are routed through, nil
+	// for direct communication
+	scp *url.URL
+	// direct holds the peers reached without the SCP
+	direct map[string]bool
+	// compressMin is the size from which the request bodies are
+	// compressed for the peers with a ContentEncoding
+	compressMin int
+	// callbacks checks the callback URIs, nil when disabled
+	callbacks *callbackGuard
+	// dryRun logs the requests instead of sending them, nil when disabled
+	dryRun *dryRun
+	// hooks transform the payloads exchanged, nil without hooks
+	hooks *hooks.Hooks
+	// signer signs the callbacks, nil without signatures
+	signer *signature.Signer
+}
+
+// New creates a client for the given HTTP version (1 or 2)
+func New(version int, userAgent string, cfg config.Common) (*Client, error) {
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported http version %d", version)
+	}
+	c := &Client{
+		UserAgent: userAgent,
+		version:   version,
+		breakers:  newBreakers(cfg.Breaker),
+		cache:     newResponseCache(),
+		overloads: newOverloads(),
+	}
+	if err := c.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload applies the TLS material, peer settings, proxies, connection pool
+// limits, outbound rate limit, retry and hedging policies, SCP, body
+// compression, callback checks, response cache, overload control, dry run
+// and payload hooks of cfg. The requests in progress complete with the
+// previous settings, whose idle connections are closed
+func (c *Client) Reload(cfg config.Common) error {
+	apply, err := c.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReload loads the settings of cfg, and returns the function
+// applying them as Reload does
+func (c *Client) PrepareReload(cfg config.Common) (func(), error) {
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := newProxies(cfg.Proxy, cfg.Peers)
+	if err != nil {
+		return nil, err
+	}
+	callbacks, err := newCallbackGuard(cfg.Callbacks)
+	if err != nil {
+		return nil, err
+	}
+	dryRun, err := newDryRun(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hks, err := hooks.New(cfg.Hooks)
+	if err != nil {
+		return nil, fmt.Errorf("hooks: %v", err)
+	}
+	var signer *signature.Signer
+	if cfg.Signatures.Enabled {
+		if signer, err = signature.New(cfg.Signatures); err != nil {
+			return nil, err
+		}
+	}
+	var scpRoot *url.URL
+	direct := make(map[string]bool, len(cfg.SCP.Direct))
+	if cfg.SCP.APIRoot != "" {
+		var ok bool
+		if scpRoot, ok = scp.ParseAPIRoot(cfg.SCP.APIRoot); !ok {
+			return nil, fmt.Errorf("invalid SCP API root %q",
+				cfg.SCP.APIRoot)
+		}
+		for _, host := range cfg.SCP.Direct {
+			direct[host] = true
+		}
+		/* the requests to the SCP itself are not routed */
+		direct[scpRoot.Host] = true
+	}
+	return func() {
+		c.apply(cfg, tlsConfig, proxies, callbacks, dryRun, hks, signer,
+			scpRoot, direct)
+	}, nil
+}
+
+// apply replaces the settings with those loaded by PrepareReload
+func (c *Client) apply(cfg config.Common, tlsConfig *tls.Config,
+	proxies *proxies, callbacks *callbackGuard, dryRun *dryRun,
+	hks *hooks.Hooks, signer *signature.Signer, scpRoot *url.URL,
+	direct map[string]bool) {
+	transports := newTransports(c.version, tlsConfig, proxies, cfg)
+	httpClient := &http.Client{Transport: transports}
+
+	c.mu.Lock()
+	previous, previousTransports := c.http, c.transports
+	c.peers = cfg.Peers
+	c.http = httpClient
+	c.transports = transports
+	c.retry = newRetryPolicy(cfg.Retry)
+	c.hedging = newHedgingPolicy(cfg.Hedging)
+	c.scp = scpRoot
+	c.direct = direct
+	c.callbacks = callbacks
+	c.dryRun = dryRun
+	c.hooks = hks
+	c.signer = signer
+	c.compressMin = cfg.Compression.MinSize
+	if c.compressMin <= 0 {
+		c.compressMin = defaultCompressionMinSize
+	}
+	c.limits = nil
+	if cfg.RateLimit.Enabled {
+		c.limits = ratelimit.NewLimiter(cfg.RateLimit.Outbound.Rate,
+			cfg.RateLimit.Outbound.Burst)
+	}
+	c.mu.Unlock()
+	c.cache.configure(cfg.Cache.Client)
+	c.overloads.configure(cfg.Overload.Honor)
+	if previous != nil {
+		previous.CloseIdleConnections()
+		previousTransports.stop()
+	}
+}
+
+// Authorizer provides the access tokens of the outbound requests
+type Authorizer interface {
+	// Token returns the access token for the peer host:port, empty when
+	// the peer needs none
+	Token(ctx context.Context, host string) (string, error)
+	// Invalidate drops the cached token of the peer after it was rejected
+	Invalidate(host string)
+}
+
+// SetAuthorizer makes the client send an access token with the requests. A
+// request rejected with 401 is sent once more with a new token. It must be
+// called before the client is used
+func (c *Client) SetAuthorizer(a Authorizer) {
+	c.authorizer = a
+}
+
+// SetFaults makes the client inject the client faults of f in the
+// requests sent. It must be called before the client is used
+func (c *Client) SetFaults(f *faults.Injector) {
+	c.faults = f
+}
+
+// SetRecorder makes the client record the requests sent with r. It must be
+// called before the client is used
+func (c *Client) SetRecorder(r *record.Recorder) {
+	c.recorder = r
+}
+
+// SetExchanges makes the client keep the requests sent in the history l.
+// It must be called before the client is used
+func (c *Client) SetExchanges(l *exchanges.Log) {
+	c.exchanges = l
+}
+
+// settings returns the current HTTP client, transports, retry policy and
+// outbound rate limits
+func (c *Client) settings() (*http.Client, *transports, *retryPolicy,
+	*ratelimit.Limiter) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.http, c.transports, c.retry, c.limits
+}
+
+// Scheme returns the URL scheme used to reach the peer host:port
+func (c *Client) Scheme(host string) string {
+	c.mu.RLock()
+	protocol := c.peers[host].Protocol
+	c.mu.RUnlock()
+	return peerScheme(protocol, c.version)
+}
+
+// ContentType returns the media type of the request bodies sent to the
+// peer host:port, empty for JSON
+func (c *Client) ContentType(host string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peers[host].ContentType
+}
+
+// MessageVersion returns the version of the NF messages sent to the peer
+// host:port, 0 for the newest
+func (c *Client) MessageVersion(host string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peers[host].MessageVersion
+}
+
+// GRPCHost returns the host:port the gRPC requests to the peer host:port
+// are sent to, and false when the peer is reached with REST
+func (c *Client) GRPCHost(host string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	peer := c.peers[host]
+	if peer.API != config.APIGRPC {
+		return "", false
+	}
+	if peer.GRPCAddress != "" {
+		return peer.GRPCAddress, true
+	}
+	return host, true
+}
+
+// newTLSConfig returns the client TLS configuration, restricted by the TLS
+// policy. With mutual TLS the client presents the NF certificate, and peers
+// listed in AllowedPeers must present a certificate carrying one of the
+// allowed names
+func newTLSConfig(tlsCfg config.TLSConfig) (*tls.Config, error) {
+	files := tlsCfg.ClientFiles()
+	caCertPool, err := tlsutil.LoadCertPool(files.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		RootCAs: caCertPool,
+	}
+	tlsCfg.Policy.Apply(tlsConfig)
+	if !tlsCfg.MutualTLS {
+		return tlsConfig, nil
+	}
+
+	cert, err := tlsutil.LoadKeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	peers := tlsCfg.AllowedPeers
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		allowed, ok := peers[cs.ServerName]
+		if !ok {
+			return nil
+		}
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("peer %s presented no certificate",
+				cs.ServerName)
+		}
+		return tlsutil.CheckAllowed(cs.PeerCertificates[0], allowed)
+	}
+	return tlsConfig, nil
+}
+
+// Do sends the request after setting the client User-Agent. Failed attempts
+// are retried with backoff according to the retry policy, as long as the
+// retry budget allows it. Requests to a peer whose circuit is open fail
+// immediately with a *CircuitOpenError, and the share of the requests the
+// overload control information of a peer asks to stop sending with a
+// *OverloadError when it is honored. Each attempt waits for a token of
+// the outbound rate limit of the peer, or fails when the request context
+// is done first. With the response cache enabled, the GET requests are
+// answered from the cache while the response kept is fresh. The bodies
+// are compressed for the peers with a ContentEncoding, after the pre-send
+// hooks of the peer transformed them; the post-receive hooks transform the
+// bodies of the responses. The requests to the callback URIs, or all of
+// them when configured, are signed once transformed. The streamed bodies
+// are neither transformed nor signed
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if streaming(req.Context()) {
+		return c.do(req)
+	}
+	req, err := c.preSend(req)
+	if err != nil {
+		return nil, err
+	}
+	if req, err = c.sign(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.cache.do(req, c.do)
+	if err != nil {
+		return resp, err
+	}
+	return c.postReceive(req, resp)
+}
+
+// do sends the request with the retries, circuit breakers and rate limits
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", c.UserAgent)
+	req = c.compressBody(req)
+	httpClient, transports, retry, limits := c.settings()
+	retry.budget.deposit()
+	reauthorized := false
+	for attempt := 0; ; attempt++ {
+		token, err := c.authorize(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := limits.Bucket(req.URL.Host).Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		if err := c.overloads.throttle(req.URL.Host); err != nil {
+			return nil, err
+		}
+		b, err := c.breakers.allow(req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.send(httpClient, transports.timeoutsOf(req.URL.Host),
+			req)
+		c.breakers.done(b, resp, err)
+		if token != "" && !reauthorized && err == nil &&
+			resp.StatusCode == http.StatusUnauthorized {
+			/* the token may have been revoked, send it once more with a
+			 * new one */
+			if next, rerr := rewind(req); rerr == nil {
+				reauthorized = true
+				c.authorizer.Invalidate(req.URL.Host)
+				discard(resp)
+				req = next
+				attempt--
+				continue
+			}
+		}
+		if attempt >= retry.maxRetries {
+			return resp, err
+		}
+		reason, delay, ok := retryable(resp, err)
+		if !ok || !retry.budget.withdraw() {
+			return resp, err
+		}
+		next, rerr := rewind(req)
+		if rerr != nil {
+			return resp, err
+		}
+		if delay == 0 {
+			delay = retry.backoff(attempt)
+		}
+		discard(resp)
+		clientRetries.WithLabelValues(req.URL.Host, reason).Inc()
+		logging.FromContext(req.Context()).Warnf(
+			"%s %s failed (%s), retrying in %v", req.Method, req.URL, reason,
+			delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		req = next
+	}
+}
+
+// authorize sets the Authorization header of the request when the peer
+// requires an access token, and returns the token
+func (c *Client) authorize(req *http.Request) (string, error) {
+	if c.authorizer == nil {
+		return "", nil
+	}
+	token, err := c.authorizer.Token(req.Context(), req.URL.Host)
+	if err != nil || token == "" {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return token, nil
+}
+
+// indirect returns the request routed through the SCP, or the request
+// itself when the peer is reached directly
+func (c *Client) indirect(req *http.Request) *http.Request {
+	c.mu.RLock()
+	root, direct := c.scp, c.direct[req.URL.Host]
+	c.mu.RUnlock()
+	if root == nil || direct {
+		return req
+	}
+	return scp.Route(req, root)
+}
+
+// send sends a single attempt of the request within the peer timeouts,
+// traced and measured. The breakers, rate limits, timeouts and metrics
+// apply to the target peer when the request goes through the SCP
+func (c *Client) send(httpClient *http.Client, to timeouts,
+	req *http.Request) (*http.Response, error) {
+	peer := req.URL.Host
+	c.mu.RLock()
+	dryRun := c.dryRun
+	c.mu.RUnlock()
+	req = c.callbackHost(c.indirect(req), peer)
+	ctx, span := tracing.Start(req.Context(), req.Method+" "+peer,
+		tracing.KindClient)
+	tracing.Inject(ctx, req.Header)
+	requestid.Inject(ctx, req.Header)
+	priority.Inject(ctx, req.Header)
+	deadline.Inject(ctx, req.Header)
+	start := time.Now()
+	resp, err := c.exchanges.RoundTrip(traceConn(req), peer,
+		func(req *http.Request) (*http.Response, error) {
+			return c.recorder.RoundTrip(req,
+				func(req *http.Request) (*http.Response, error) {
+					return c.faults.RoundTrip(req,
+						func(req *http.Request) (*http.Response, error) {
+							if dryRun.applies(peer) {
+								return dryRun.roundTrip(req, peer)
+							}
+							return to.roundTrip(httpClient, req)
+						})
+				})
+		})
+
+	c.overloads.update(peer, resp)
+	code := 0
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+	span.SetAttribute("peer.service", peer)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("http.status_code", code)
+	}
+	span.Finish()
+	clientRequests.WithLabelValues(peer, req.Method, result(code, err)).Inc()
+	clientDuration.WithLabelValues(peer, req.Method).Observe(
+		time.Since(start).Seconds())
+	if err == nil && !streaming(req.Context()) {
+		c.latencies.record(peer, time.Since(start))
+	}
+	if err != nil && isTLSError(err) {
+		clientTLSFailures.WithLabelValues(peer).Inc()
+	}
+	return resp, err
+}
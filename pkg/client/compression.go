@@ -0,0 +1,54 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/compress"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+const defaultCompressionMinSize = 1024
+
+// compressBody compresses the body of the request in the ContentEncoding
+// of its peer when it is at least the compression MinSize and not encoded
+// yet. The body stays replayable for the retries. The request is sent as
+// it is when the body cannot be replayed or compressed
+func (c *Client) compressBody(req *http.Request) *http.Request {
+	c.mu.RLock()
+	encoding := c.peers[req.URL.Host].ContentEncoding
+	minSize := c.compressMin
+	c.mu.RUnlock()
+	if encoding == "" || encoding == compress.Identity ||
+		req.GetBody == nil || req.Header.Get("Content-Encoding") != "" ||
+		(req.ContentLength >= 0 && req.ContentLength < int64(minSize)) {
+		return req
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return req
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || len(data) < minSize {
+		return req
+	}
+	encoded, err := compress.Encode(encoding, data)
+	if err != nil {
+		logging.FromContext(req.Context()).Warnf(
+			"%s %s sent uncompressed: %v", req.Method, req.URL, err)
+		return req
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	out := req.Clone(req.Context())
+	out.Header.Set("Content-Encoding", encoding)
+	out.ContentLength = int64(len(encoded))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(encoded)), nil
+	}
+	out.Body, _ = out.GetBody()
+	return out
+}
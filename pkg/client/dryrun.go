@@ -0,0 +1,153 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/compress"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/openapi"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// ErrDryRun is returned, wrapped in a *DryRunError, for the requests the
+// dry run logged instead of sending them
+var ErrDryRun = errors.New("dry run, request not sent")
+
+// DryRunError is returned for a request logged instead of being sent
+type DryRunError struct {
+	Peer string
+	// Invalid is the problem the peer would answer an invalid request with
+	// according to the OpenAPI specification, nil for a valid request
+	Invalid *problem.Details
+}
+
+func (e *DryRunError) Error() string {
+	if e.Invalid != nil {
+		return fmt.Sprintf("%s: %v, %v", e.Peer, ErrDryRun, e.Invalid)
+	}
+	return fmt.Sprintf("%s: %v", e.Peer, ErrDryRun)
+}
+
+// Unwrap makes errors.Is(err, ErrDryRun) true
+func (e *DryRunError) Unwrap() error {
+	return ErrDryRun
+}
+
+// dryRun decides which peers the requests are only logged for
+type dryRun struct {
+	peers map[string]bool
+	// spec checks the request bodies, none are checked when nil
+	spec *openapi.Spec
+}
+
+// newDryRun returns the dry run of the configuration, nil when disabled
+func newDryRun(cfg config.Common) (*dryRun, error) {
+	if !cfg.Interop.DryRun {
+		return nil, nil
+	}
+	d := &dryRun{}
+	if len(cfg.Interop.Peers) > 0 {
+		d.peers = make(map[string]bool, len(cfg.Interop.Peers))
+		for _, peer := range cfg.Interop.Peers {
+			d.peers[peer] = true
+		}
+	}
+	if cfg.OpenAPI.Spec != "" {
+		spec, err := openapi.Load(cfg.OpenAPI.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("dry run: %v", err)
+		}
+		d.spec = spec
+	}
+	return d, nil
+}
+
+// applies tells whether the requests to the peer are only logged
+func (d *dryRun) applies(peer string) bool {
+	return d != nil && (d.peers == nil || d.peers[peer])
+}
+
+// roundTrip logs the request as it would be sent, headers and body, with
+// the problems of its body against the specification, and fails it with a
+// *DryRunError
+func (d *dryRun) roundTrip(req *http.Request, peer string) (*http.Response,
+	error) {
+	l := logging.FromContext(req.Context())
+	var body []byte
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(rc)
+			rc.Close()
+		}
+	} else if req.Body != nil && req.Body != http.NoBody {
+		body, _ = io.ReadAll(req.Body)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	/* the body as the peer decodes it */
+	contentType := req.Header.Get("Content-Type")
+	if enc := req.Header.Get("Content-Encoding"); enc != "" && len(body) > 0 {
+		rc, err := compress.NewReader(io.NopCloser(bytes.NewReader(body)),
+			enc)
+		if err == nil {
+			if decoded, err := io.ReadAll(rc); err == nil {
+				body = decoded
+			}
+			rc.Close()
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dry run of %s %s\n", req.Method, req.URL)
+	b.WriteString(l.Headers(req.Header))
+	if len(body) > 0 {
+		/* the binary media types are shown as JSON */
+		shown := body
+		if data, err := codec.ToJSON(body, contentType); err == nil {
+			shown = data
+		}
+		b.WriteString("\n" + l.Body(shown) + "\n")
+	}
+	l.Infof("%s", b.String())
+
+	dryErr := &DryRunError{Peer: peer}
+	if op := d.operation(req); op != nil {
+		dryErr.Invalid = d.spec.CheckBody(op, contentType, body)
+		if p := dryErr.Invalid; p != nil {
+			l.Warnf("Dry run of %s %s: the %s operation of the peer would "+
+				"reject it with %d: %s", req.Method, req.URL, op.OperationID,
+				p.Status, p.Detail)
+			for _, param := range p.InvalidParams {
+				l.Warnf("  %s: %s", param.Param, param.Reason)
+			}
+		}
+	}
+	return nil, dryErr
+}
+
+// operation returns the operation of the request in the specification,
+// looked up by path without the leading segments of the API root of the
+// peer, nil when it is not described
+func (d *dryRun) operation(req *http.Request) *openapi.Operation {
+	if d.spec == nil {
+		return nil
+	}
+	path := req.URL.Path
+	for {
+		if op := d.spec.Operation(req.Method, path); op != nil {
+			return op
+		}
+		i := strings.Index(strings.TrimPrefix(path, "/"), "/")
+		if i < 0 {
+			return nil
+		}
+		path = path[i+1:]
+	}
+}
@@ -0,0 +1,434 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+// Default health check settings of the failover endpoints
+const (
+	defaultCheckInterval      = 10 * time.Second
+	defaultCheckTimeout       = 2 * time.Second
+	defaultUnhealthyThreshold = 2
+	defaultHealthyThreshold   = 1
+)
+
+var (
+	endpointRequests = metrics.NewCounterVec(
+		"nf_client_endpoint_requests_total",
+		"Requests sent to the failover endpoints by endpoint and result "+
+			"(status code or error).", "endpoint", "result")
+	endpointHealthy = metrics.NewGaugeVec("nf_client_endpoint_healthy",
+		"Health of the failover endpoints: 1 healthy, 0 unhealthy.",
+		"endpoint")
+	endpointActive = metrics.NewGaugeVec("nf_client_endpoint_active",
+		"Failover endpoint the requests are sent to: 1 active, 0 standby.",
+		"endpoint")
+	failovers = metrics.NewCounterVec("nf_client_failovers_total",
+		"Changes of the active failover endpoint.", "from", "to")
+)
+
+// endpoint is an API root of the failover list
+type endpoint struct {
+	root    *url.URL
+	healthy bool
+	// failures and successes count the checks in a row
+	failures  int
+	successes int
+}
+
+// Failover sends the requests to the first of a prioritized list of API
+// roots, the endpoints, and to the next ones when it fails to connect or
+// answers 5xx. The selection is sticky: the endpoint serving a request
+// serves the next ones until it fails, or until an endpoint of higher
+// priority passes its health checks again
+type Failover struct {
+	client *Client
+	check  config.UpstreamCheckConfig
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	// active is the index of the endpoint the requests are sent to first
+	active int
+}
+
+// NewFailover creates the failover across the API roots, sending the
+// requests with the client
+func NewFailover(c *Client, roots []string,
+	cfg config.FailoverConfig) (*Failover, error) {
+	f := &Failover{client: c, check: cfg.HealthCheck}
+	if err := f.SetEndpoints(roots); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetEndpoints replaces the API roots, e.g. after a configuration reload.
+// The endpoints kept keep their health, the primary becomes active
+func (f *Failover) SetEndpoints(roots []string) error {
+	apply, err := f.PrepareEndpoints(roots)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareEndpoints checks the API roots, and returns the function replacing
+// the endpoints with them as SetEndpoints does
+func (f *Failover) PrepareEndpoints(roots []string) (func(), error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no failover endpoint")
+	}
+	urls := make([]*url.URL, 0, len(roots))
+	for _, root := range roots {
+		u, err := url.Parse(strings.TrimRight(root, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" {
+			return nil, fmt.Errorf("invalid failover endpoint %q", root)
+		}
+		urls = append(urls, u)
+	}
+	return func() { f.setEndpoints(urls) }, nil
+}
+
+func (f *Failover) setEndpoints(urls []*url.URL) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := make(map[string]*endpoint, len(f.endpoints))
+	for _, ep := range f.endpoints {
+		previous[ep.root.String()] = ep
+	}
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		ep, ok := previous[u.String()]
+		if !ok {
+			ep = &endpoint{root: u, healthy: true}
+			endpointHealthy.WithLabelValues(u.Host).Set(1)
+		}
+		delete(previous, u.String())
+		endpoints = append(endpoints, ep)
+	}
+	for _, ep := range previous {
+		endpointActive.WithLabelValues(ep.root.Host).Set(0)
+	}
+	f.endpoints = endpoints
+	f.activate(0)
+}
+
+// Primary returns the API root of highest priority. The requests to the
+// endpoints are built on it
+func (f *Failover) Primary() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.endpoints[0].root.String()
+}
+
+// Active returns the API root the requests are sent to first
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.endpoints[f.active].root.String()
+}
+
+// Standby returns the healthy API root of highest priority besides the
+// active one, empty when there is none
+func (f *Failover) Standby() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, ep := range f.endpoints {
+		if i != f.active && ep.healthy {
+			return ep.root.String()
+		}
+	}
+	return ""
+}
+
+// Do sends the request built on the primary API root to the active
+// endpoint, then to the other healthy ones in order of priority while the
+// request fails to connect or is answered 5xx. The unhealthy endpoints are
+// tried last. The requests not built on the primary API root are sent
+// with the client as they are
+func (f *Failover) Do(req *http.Request) (*http.Response, error) {
+	order, path, ok := f.plan(req.URL)
+	if !ok {
+		return f.client.Do(req)
+	}
+	var resp *http.Response
+	var err error
+	for i, ep := range order {
+		if i > 0 {
+			next, rerr := rewind(req)
+			if rerr != nil {
+				return resp, err
+			}
+			discard(resp)
+			req = next
+		}
+		out := req.Clone(req.Context())
+		out.URL.Scheme = ep.root.Scheme
+		out.URL.Host = ep.root.Host
+		out.URL.Path = ep.root.Path + path
+		out.URL.RawPath = ""
+		out.Host = ""
+		resp, err = f.client.Do(out)
+		endpointRequests.WithLabelValues(ep.root.Host,
+			result(statusOf(resp), err)).Inc()
+		if !failed(resp, err) {
+			f.served(ep)
+			return resp, err
+		}
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		if len(order) > 1 {
+			f.markFailed(ep, resp, err)
+		}
+	}
+	return resp, err
+}
+
+// plan returns the endpoints to try in turn for the URL and its path below
+// the primary API root, false when the URL is not built on it
+func (f *Failover) plan(u *url.URL) ([]*endpoint, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	primary := f.endpoints[0].root
+	if u.Host != primary.Host || !strings.HasPrefix(u.Path, primary.Path) {
+		return nil, "", false
+	}
+	order := []*endpoint{f.endpoints[f.active]}
+	var standby []*endpoint
+	for i, ep := range f.endpoints {
+		switch {
+		case i == f.active:
+		case ep.healthy:
+			order = append(order, ep)
+		default:
+			standby = append(standby, ep)
+		}
+	}
+	return append(order, standby...), strings.TrimPrefix(u.Path,
+		primary.Path), true
+}
+
+// failed tells whether the outcome of a request makes it fail over: no
+// response, e.g. a connection error or an open circuit, or a 5xx
+func failed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// served makes the endpoint which answered a request the active one
+func (f *Failover) served(ep *endpoint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.endpoints {
+		if e == ep && i != f.active {
+			logging.Warnf("Failing over from %s to %s",
+				f.endpoints[f.active].root, ep.root)
+			failovers.WithLabelValues(f.endpoints[f.active].root.Host,
+				ep.root.Host).Inc()
+			f.activate(i)
+			return
+		}
+	}
+}
+
+// markFailed marks the endpoint which failed a request unhealthy, until it
+// passes its health checks again
+func (f *Failover) markFailed(ep *endpoint, resp *http.Response,
+	err error) {
+	reason := err
+	if reason == nil {
+		reason = fmt.Errorf("status %d", resp.StatusCode)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ep.successes = 0
+	if ep.healthy {
+		ep.healthy = false
+		endpointHealthy.WithLabelValues(ep.root.Host).Set(0)
+		logging.Warnf("Endpoint %s unhealthy: %v", ep.root, reason)
+	}
+}
+
+// activate makes the endpoint of index i the active one. f.mu is held
+func (f *Failover) activate(i int) {
+	for j, ep := range f.endpoints {
+		active := 0.0
+		if j == i {
+			active = 1
+		}
+		endpointActive.WithLabelValues(ep.root.Host).Set(active)
+	}
+	f.active = i
+}
+
+// EndpointStatus is the state of a failover endpoint
+type EndpointStatus struct {
+	Endpoint string `json:"endpoint"`
+	Healthy  bool   `json:"healthy"`
+	Active   bool   `json:"active"`
+}
+
+// Status returns the state of the endpoints, in order of priority
+func (f *Failover) Status() []EndpointStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]EndpointStatus, 0, len(f.endpoints))
+	for i, ep := range f.endpoints {
+		out = append(out, EndpointStatus{Endpoint: ep.root.String(),
+			Healthy: ep.healthy, Active: i == f.active})
+	}
+	return out
+}
+
+// Run checks the health of the endpoints until the context is canceled,
+// when there are several, and makes the healthy endpoint of highest
+// priority the active one
+func (f *Failover) Run(ctx context.Context) {
+	interval := time.Duration(f.check.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll checks the endpoints at once, then fails back to the endpoint
+// of highest priority that is healthy
+func (f *Failover) checkAll(ctx context.Context) {
+	f.mu.Lock()
+	endpoints := f.endpoints
+	f.mu.Unlock()
+	if len(endpoints) < 2 {
+		return
+	}
+	timeout := time.Duration(f.check.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *endpoint) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			errs[i] = f.probe(cctx, ep)
+			cancel()
+		}(i, ep)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, ep := range endpoints {
+		f.update(ep, errs[i])
+	}
+	for i, ep := range f.endpoints {
+		if i >= f.active {
+			break
+		}
+		if ep.healthy {
+			logging.Infof("Failing back from %s to %s",
+				f.endpoints[f.active].root, ep.root)
+			failovers.WithLabelValues(f.endpoints[f.active].root.Host,
+				ep.root.Host).Inc()
+			f.activate(i)
+			break
+		}
+	}
+}
+
+// probe requests the health check path of the endpoint, or connects to it
+func (f *Failover) probe(ctx context.Context, ep *endpoint) error {
+	if f.check.Path == "" {
+		host := ep.root.Host
+		if ep.root.Port() == "" {
+			host = net.JoinHostPort(ep.root.Hostname(), ep.root.Scheme)
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	u := *ep.root
+	u.Path += f.check.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(),
+		nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// update counts the outcome of a check and changes the health of the
+// endpoint past the thresholds. f.mu is held
+func (f *Failover) update(ep *endpoint, err error) {
+	unhealthy := f.check.UnhealthyThreshold
+	if unhealthy <= 0 {
+		unhealthy = defaultUnhealthyThreshold
+	}
+	healthy := f.check.HealthyThreshold
+	if healthy <= 0 {
+		healthy = defaultHealthyThreshold
+	}
+	if err != nil {
+		ep.successes = 0
+		ep.failures++
+		if ep.failures >= unhealthy && ep.healthy {
+			ep.healthy = false
+			endpointHealthy.WithLabelValues(ep.root.Host).Set(0)
+			logging.Warnf("Endpoint %s unhealthy: %v", ep.root, err)
+		}
+		return
+	}
+	ep.failures = 0
+	ep.successes++
+	if ep.successes >= healthy && !ep.healthy {
+		ep.healthy = true
+		endpointHealthy.WithLabelValues(ep.root.Host).Set(1)
+		logging.Infof("Endpoint %s healthy again", ep.root)
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	frameHeaderLen = 9
+	// maxGoAwayDebug is the part of the GOAWAY debug data logged
+	maxGoAwayDebug = 256
+)
+
+var clientGoAways = metrics.NewCounterVec("nf_client_goaway_total",
+	"GOAWAY frames received from the peer NFs by error code.", "peer",
+	"code")
+
+// goawayConn watches the frames an HTTP/2 peer sends on the connection for
+// the GOAWAY announcing that it closes it, e.g. when it restarts. The
+// transport stops sending on the connection and sends the requests the
+// peer did not process again on a new one; the GOAWAY is logged with its
+// debug data and counted
+type goawayConn struct {
+	net.Conn
+	peer string
+
+	// header holds the first n bytes of the frame header being read
+	header [frameHeaderLen]byte
+	n      int
+	// left is the number of payload bytes of the current frame to read
+	left int
+	// payload holds the start of the payload of a GOAWAY being read, nil
+	// for the other frames
+	payload []byte
+}
+
+// goawayTLSConn keeps the TLS state of the connection visible to the
+// transport
+type goawayTLSConn struct {
+	*goawayConn
+	tls *tls.Conn
+}
+
+func (c *goawayTLSConn) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+// watchGoAway returns the connection of an HTTP/2 peer watched for GOAWAY
+func watchGoAway(peer string, conn net.Conn) net.Conn {
+	c := &goawayConn{Conn: conn, peer: peer}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return &goawayTLSConn{goawayConn: c, tls: tlsConn}
+	}
+	return c
+}
+
+func (c *goawayConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.scan(b[:n])
+	return n, err
+}
+
+// scan follows the frames through the bytes read
+func (c *goawayConn) scan(data []byte) {
+	for len(data) > 0 {
+		if c.left == 0 && c.payload == nil {
+			k := copy(c.header[c.n:], data)
+			c.n += k
+			data = data[k:]
+			if c.n < frameHeaderLen {
+				return
+			}
+			c.n = 0
+			c.left = int(c.header[0])<<16 | int(c.header[1])<<8 |
+				int(c.header[2])
+			if http2.FrameType(c.header[3]) == http2.FrameGoAway {
+				c.payload = make([]byte, 0, 8+maxGoAwayDebug)
+			}
+		}
+		k := c.left
+		if k > len(data) {
+			k = len(data)
+		}
+		if c.payload != nil {
+			keep := k
+			if room := cap(c.payload) - len(c.payload); keep > room {
+				keep = room
+			}
+			c.payload = append(c.payload, data[:keep]...)
+		}
+		c.left -= k
+		data = data[k:]
+		if c.left == 0 && c.payload != nil {
+			c.goAway(c.payload)
+			c.payload = nil
+		}
+	}
+}
+
+// goAway logs and counts a GOAWAY frame
+func (c *goawayConn) goAway(payload []byte) {
+	if len(payload) < 8 {
+		return
+	}
+	last := binary.BigEndian.Uint32(payload) & (1<<31 - 1)
+	code := http2.ErrCode(binary.BigEndian.Uint32(payload[4:]))
+	clientGoAways.WithLabelValues(c.peer, code.String()).Inc()
+	if debug := payload[8:]; len(debug) > 0 {
+		logging.Infof("%s sent GOAWAY %v after stream %d: %q", c.peer, code,
+			last, debug)
+		return
+	}
+	logging.Infof("%s sent GOAWAY %v after stream %d", c.peer, code, last)
+}
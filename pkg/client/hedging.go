@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	defaultHedgingPercentile = 95
+	defaultHedgingDelay      = 100 * time.Millisecond
+	// latencyWindow is the number of recent response times kept per peer
+	latencyWindow = 100
+	// minLatencies is the number of response times the percentile needs
+	minLatencies = 20
+)
+
+var hedgedRequests = metrics.NewCounterVec("nf_client_hedged_requests_total",
+	"Requests to the peer NFs sent a hedged copy, by the request whose "+
+		"response was used: original or hedge.", "peer", "winner")
+
+// hedgingPolicy decides whether and when a request is hedged
+type hedgingPolicy struct {
+	enabled    bool
+	percentile float64
+	delay      time.Duration
+	methods    map[string]bool
+}
+
+func newHedgingPolicy(cfg config.HedgingConfig) *hedgingPolicy {
+	p := &hedgingPolicy{
+		enabled:    cfg.Enabled,
+		percentile: cfg.Percentile,
+		delay:      time.Duration(cfg.Delay) * time.Millisecond,
+		methods:    make(map[string]bool),
+	}
+	if p.percentile <= 0 || p.percentile > 100 {
+		p.percentile = defaultHedgingPercentile
+	}
+	if p.delay <= 0 {
+		p.delay = defaultHedgingDelay
+	}
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet}
+	}
+	for _, m := range methods {
+		p.methods[strings.ToUpper(m)] = true
+	}
+	return p
+}
+
+// latencies keeps the recent response times of each peer
+type latencies struct {
+	mu    sync.Mutex
+	peers map[string]*window
+}
+
+type window struct {
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) record(peer string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.peers == nil {
+		l.peers = make(map[string]*window)
+	}
+	w, ok := l.peers[peer]
+	if !ok {
+		w = &window{}
+		l.peers[peer] = w
+	}
+	if len(w.samples) < latencyWindow {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindow
+}
+
+// percentile returns the percentile of the recent response times of the
+// peer, false while too few are known
+func (l *latencies) percentile(peer string, p float64) (time.Duration,
+	bool) {
+	l.mu.Lock()
+	w, ok := l.peers[peer]
+	var samples []time.Duration
+	if ok {
+		samples = append(samples, w.samples...)
+	}
+	l.mu.Unlock()
+	if len(samples) < minLatencies {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	i := int(float64(len(samples))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i], true
+}
+
+// Doer sends requests, e.g. a *Client or a *Failover
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Hedged returns a Doer sending the requests built on the API root with
+// doer and, when the response is late, a copy of the request to the
+// alternate API root of another instance of the peer. The response
+// arriving first is used and the other request is canceled. The copy is
+// sent when the response did not arrive within the percentile of the
+// recent response times of the peer, if hedging is enabled, the method is
+// hedged and the body can be replayed
+func (c *Client) Hedged(doer Doer, root, alternate string) Doer {
+	return &hedged{client: c, doer: doer, root: root, alternate: alternate}
+}
+
+type hedged struct {
+	client    *Client
+	doer      Doer
+	root      string
+	alternate string
+}
+
+// outcome is the result of one of the hedged requests
+type outcome struct {
+	resp   *http.Response
+	err    error
+	hedge  bool
+	cancel context.CancelFunc
+}
+
+func (h *hedged) Do(req *http.Request) (*http.Response, error) {
+	c := h.client
+	c.mu.RLock()
+	policy := c.hedging
+	c.mu.RUnlock()
+	copyURL, ok := h.rebase(req.URL)
+	if !policy.enabled || !ok || !policy.methods[req.Method] ||
+		(req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return h.doer.Do(req)
+	}
+	peer := req.URL.Host
+	delay, known := c.latencies.percentile(peer, policy.percentile)
+	if !known || delay < policy.delay {
+		delay = policy.delay
+	}
+
+	results := make(chan outcome, 2)
+	send := func(r *http.Request, doer Doer, hedge bool) {
+		ctx, cancel := context.WithCancel(r.Context())
+		resp, err := doer.Do(r.WithContext(ctx))
+		results <- outcome{resp: resp, err: err, hedge: hedge,
+			cancel: cancel}
+	}
+	go send(req, h.doer, false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	select {
+	case o := <-results:
+		/* answered in time, no copy sent */
+		return o.resp, o.result(o.err)
+	case <-timer.C:
+	}
+	copyReq, err := rewind(req)
+	if err == nil {
+		copyReq.URL = copyURL
+		copyReq.Host = ""
+		logging.FromContext(req.Context()).Debugf(
+			"%s %s late after %v, hedging to %s", req.Method, req.URL,
+			delay, copyURL.Host)
+		go send(copyReq, c, true)
+		pending++
+	}
+	for pending > 0 {
+		o := <-results
+		pending--
+		if o.err == nil || pending == 0 {
+			winner := "original"
+			if o.hedge {
+				winner = "hedge"
+			}
+			if pending > 0 {
+				hedgedRequests.WithLabelValues(peer, winner).Inc()
+				/* cancel the other request and drop its response */
+				go func() {
+					loser := <-results
+					loser.cancel()
+					discard(loser.resp)
+				}()
+			}
+			return o.resp, o.result(o.err)
+		}
+		o.cancel()
+		discard(o.resp)
+	}
+	return nil, err
+}
+
+// result returns err, and keeps the context of a response alive until its
+// body is closed
+func (o outcome) result(err error) error {
+	if o.resp == nil {
+		o.cancel()
+		return err
+	}
+	o.resp.Body = &cancelBody{ReadCloser: o.resp.Body, cancel: o.cancel}
+	return err
+}
+
+// rebase returns the URL on the alternate API root of a URL built on the
+// API root, false when it is not built on it or there is no alternate
+func (h *hedged) rebase(u *url.URL) (*url.URL, bool) {
+	if h.alternate == "" || h.alternate == h.root {
+		return nil, false
+	}
+	root, err := url.Parse(strings.TrimRight(h.root, "/"))
+	if err != nil || u.Host != root.Host ||
+		!strings.HasPrefix(u.Path, root.Path) {
+		return nil, false
+	}
+	alternate, err := url.Parse(strings.TrimRight(h.alternate, "/"))
+	if err != nil || alternate.Host == "" {
+		return nil, false
+	}
+	out := *u
+	out.Scheme = alternate.Scheme
+	out.Host = alternate.Host
+	out.Path = alternate.Path + strings.TrimPrefix(u.Path, root.Path)
+	out.RawPath = ""
+	return &out, true
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/hooks"
+)
+
+// preSend applies the pre-send hooks of the peer to the request and
+// returns it with the body they transformed, replayable for the retries
+func (c *Client) preSend(req *http.Request) (*http.Request, error) {
+	c.mu.RLock()
+	chain := c.hooks.Peer(hooks.PreSend, req.URL.Host)
+	c.mu.RUnlock()
+	if len(chain) == 0 {
+		return req, nil
+	}
+	data, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	data, err = chain.Transform(req.Context(), &hooks.Message{
+		Stage: hooks.PreSend, Method: req.Method, Path: req.URL.Path,
+		Peer: req.URL.Host, Header: out.Header,
+	}, out.Header.Get("Content-Type"), data)
+	if err != nil {
+		return nil, err
+	}
+	return withBody(out, data), nil
+}
+
+// readBody reads the body of the request and closes it
+func readBody(req *http.Request) ([]byte, error) {
+	var data []byte
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if data, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return data, nil
+}
+
+// withBody returns the request with the body, replayable for the retries
+func withBody(req *http.Request, data []byte) *http.Request {
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		if len(data) == 0 {
+			/* a zero length would be taken as unknown with a body */
+			return http.NoBody, nil
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req
+}
+
+// postReceive applies the post-receive hooks of the peer to the response
+// and returns it with the body they transformed
+func (c *Client) postReceive(req *http.Request,
+	resp *http.Response) (*http.Response, error) {
+	c.mu.RLock()
+	chain := c.hooks.Peer(hooks.PostReceive, req.URL.Host)
+	c.mu.RUnlock()
+	if len(chain) == 0 {
+		return resp, nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	data, err = chain.Transform(req.Context(), &hooks.Message{
+		Stage: hooks.PostReceive, Method: req.Method, Path: req.URL.Path,
+		Peer: req.URL.Host, Status: resp.StatusCode, Header: resp.Header,
+	}, resp.Header.Get("Content-Type"), data)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	return resp, nil
+}
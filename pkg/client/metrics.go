@@ -0,0 +1,38 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+var (
+	clientRequests = metrics.NewCounterVec("nf_client_requests_total",
+		"Requests sent to the peer NFs by result (status code or error).",
+		"peer", "method", "result")
+	clientDuration = metrics.NewHistogramVec(
+		"nf_client_request_duration_seconds",
+		"Time taken by the peer NFs to answer.", nil, "peer", "method")
+	clientTLSFailures = metrics.NewCounterVec(
+		"nf_client_tls_handshake_failures_total",
+		"TLS handshakes with the peer NFs that failed.", "peer")
+	callbacksRefused = metrics.NewCounterVec(
+		"nf_client_callbacks_refused_total",
+		"Callback URIs and connections refused by reason: uri, scheme, "+
+			"host, resolution or address.", "reason")
+)
+
+// result returns the result label of a request
+func result(code int, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return strconv.Itoa(code)
+}
+
+// isTLSError reports whether the request failed during the TLS handshake
+func isTLSError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:")
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+var clientDowngrades = metrics.NewCounterVec(
+	"nf_client_protocol_downgrades_total",
+	"Peer NFs reached over HTTP/1.1 after negotiating no HTTP/2 by ALPN.",
+	"peer")
+
+// errNoHTTP2 fails the dial of the HTTP/2 transport to a peer that did not
+// negotiate HTTP/2
+type errNoHTTP2 struct {
+	protocol string
+}
+
+func (e *errNoHTTP2) Error() string {
+	return "peer negotiated " + alpnName(e.protocol) + " instead of h2"
+}
+
+func alpnName(protocol string) string {
+	if protocol == "" {
+		return "no protocol"
+	}
+	return protocol
+}
+
+// negotiatingTransport reaches a TLS peer over HTTP/2 when it negotiates it
+// by ALPN, and falls back to HTTP/1.1 for good when it does not: the
+// transport of the peer then keeps HTTP/1.1 until it is dropped, reaped or
+// replaced by a reload
+type negotiatingTransport struct {
+	peer string
+	h2   *http2.Transport
+	h1   *http.Transport
+	// http1 is 1 once the peer negotiated no HTTP/2
+	http1 int32
+}
+
+// requireHTTP2 wraps the TLS dial of the HTTP/2 transport of a peer: it
+// offers HTTP/1.1 next to HTTP/2, so that the peers only serving HTTP/1.1
+// complete the handshake, and fails the connections negotiating anything
+// else than HTTP/2
+func requireHTTP2(dial func(ctx context.Context, network, addr string,
+	cfg *tls.Config) (net.Conn, error)) func(ctx context.Context, network,
+	addr string, cfg *tls.Config) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string,
+		cfg *tls.Config) (net.Conn, error) {
+		cfg = cfg.Clone()
+		cfg.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		conn, err := dial(ctx, network, addr, cfg)
+		if err != nil {
+			return nil, err
+		}
+		state := conn.(interface {
+			ConnectionState() tls.ConnectionState
+		}).ConnectionState()
+		if p := state.NegotiatedProtocol; p != http2.NextProtoTLS {
+			conn.Close()
+			return nil, &errNoHTTP2{protocol: p}
+		}
+		return conn, nil
+	}
+}
+
+func (t *negotiatingTransport) RoundTrip(req *http.Request) (*http.Response,
+	error) {
+	if atomic.LoadInt32(&t.http1) == 1 {
+		return t.h1.RoundTrip(req)
+	}
+	resp, err := t.h2.RoundTrip(req)
+	var noHTTP2 *errNoHTTP2
+	if err == nil || !errors.As(err, &noHTTP2) {
+		return resp, err
+	}
+	if atomic.CompareAndSwapInt32(&t.http1, 0, 1) {
+		clientDowngrades.WithLabelValues(t.peer).Inc()
+		logging.FromContext(req.Context()).Warnf(
+			"Peer %s negotiated %s instead of h2, falling back to HTTP/1.1",
+			t.peer, alpnName(noHTTP2.protocol))
+		t.h2.CloseIdleConnections()
+	}
+	/* the request was not sent, no connection being open */
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+		body, berr := req.GetBody()
+		if berr != nil {
+			return nil, berr
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.h1.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of both protocols
+func (t *negotiatingTransport) CloseIdleConnections() {
+	t.h2.CloseIdleConnections()
+	t.h1.CloseIdleConnections()
+}
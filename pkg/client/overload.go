@@ -0,0 +1,115 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/overload"
+)
+
+// ErrOverloaded is returned, wrapped in a *OverloadError, for the requests
+// dropped to honor the overload control of the peer
+var ErrOverloaded = errors.New("peer overloaded")
+
+// OverloadError is returned without sending the request when it falls in
+// the share of the requests the OCI of the peer asks to stop sending
+type OverloadError struct {
+	Peer string
+	// Reduction is the percentage of the requests dropped
+	Reduction int
+	// RetryAfter is the time left before the OCI expires
+	RetryAfter time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("%s: %v, %d%% of the requests dropped", e.Peer,
+		ErrOverloaded, e.Reduction)
+}
+
+// Unwrap makes errors.Is(err, ErrOverloaded) true
+func (e *OverloadError) Unwrap() error {
+	return ErrOverloaded
+}
+
+var clientThrottled = metrics.NewCounterVec("nf_client_throttled_total",
+	"Requests dropped to honor the overload control of the peers.", "peer")
+
+// overloads keeps the OCI received from each peer host:port
+type overloads struct {
+	mu     sync.Mutex
+	honor  bool
+	peers  map[string]overload.OCI
+	random *rand.Rand
+}
+
+func newOverloads() *overloads {
+	return &overloads{peers: make(map[string]overload.OCI),
+		random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// configure applies the honor setting, forgetting the OCI received when
+// it is disabled
+func (o *overloads) configure(honor bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.honor = honor
+	if !honor {
+		o.peers = make(map[string]overload.OCI)
+	}
+}
+
+// update keeps the OCI of the response of the peer when it is newer than
+// the one kept. An OCI with no reduction ends the overload
+func (o *overloads) update(peer string, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	value := resp.Header.Get(overload.Header)
+	if value == "" {
+		return
+	}
+	oci, err := overload.Parse(value)
+	if err != nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.honor {
+		return
+	}
+	if kept, ok := o.peers[peer]; ok && !oci.Timestamp.After(kept.Timestamp) {
+		return
+	}
+	if oci.Reduction == 0 || !time.Now().Before(oci.Expires()) {
+		delete(o.peers, peer)
+		return
+	}
+	o.peers[peer] = oci
+}
+
+// throttle returns a *OverloadError when the request to the peer is
+// dropped, at random in the share of the reduction of its OCI
+func (o *overloads) throttle(peer string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	oci, ok := o.peers[peer]
+	if !ok {
+		return nil
+	}
+	left := time.Until(oci.Expires())
+	if left <= 0 {
+		delete(o.peers, peer)
+		return nil
+	}
+	if o.random.Intn(100) >= oci.Reduction {
+		return nil
+	}
+	clientThrottled.WithLabelValues(peer).Inc()
+	return &OverloadError{Peer: peer, Reduction: oci.Reduction,
+		RetryAfter: left}
+}
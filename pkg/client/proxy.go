@@ -0,0 +1,348 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// proxies selects the proxy of each peer: the peer override, else the
+// configured proxy unless the peer matches the NO_PROXY list, else the
+// proxy of the environment
+type proxies struct {
+	url     *url.URL
+	env     bool
+	noProxy []string
+	peers   map[string]*url.URL
+}
+
+func newProxies(cfg config.ProxyConfig,
+	peers map[string]config.PeerConfig) (*proxies, error) {
+	p := &proxies{env: cfg.Environment, noProxy: cfg.NoProxy,
+		peers: make(map[string]*url.URL)}
+	if cfg.URL != "" {
+		u, err := parseProxy(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		p.url = u
+	}
+	for host, peer := range peers {
+		switch peer.Proxy {
+		case "":
+		case config.ProxyDirect:
+			p.peers[host] = nil
+		default:
+			u, err := parseProxy(peer.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("peer %s: %v", host, err)
+			}
+			p.peers[host] = u
+		}
+	}
+	return p, nil
+}
+
+// parseProxy parses a proxy URL, http or socks5
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy %q: unsupported scheme %q", raw,
+			u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %q: no host", raw)
+	}
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "socks5" {
+			port = "1080"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// of returns the proxy of the peer host:port reached with the scheme, nil
+// to dial the peer directly
+func (p *proxies) of(host, scheme string) (*url.URL, error) {
+	if u, ok := p.peers[host]; ok {
+		return u, nil
+	}
+	if p.url != nil {
+		if noProxy(p.noProxy, host) {
+			return nil, nil
+		}
+		return p.url, nil
+	}
+	if !p.env {
+		return nil, nil
+	}
+	/* HTTPS_PROXY, HTTP_PROXY and NO_PROXY, read once by net/http */
+	u, err := http.ProxyFromEnvironment(&http.Request{
+		URL: &url.URL{Scheme: scheme, Host: host}})
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return parseProxy(u.String())
+}
+
+// noProxy tells whether the host:port matches one of the entries
+func noProxy(entries []string, hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		entry = strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]")
+		switch {
+		case entry == "":
+		case entry == host:
+			return true
+		case strings.HasPrefix(entry, "."):
+			if strings.HasSuffix(host, entry) {
+				return true
+			}
+		case strings.HasSuffix(host, "."+entry):
+			return true
+		}
+	}
+	return false
+}
+
+// dialProxy opens a tunnel to addr through the proxy on the connection
+// dialed to it
+func dialProxy(ctx context.Context, conn net.Conn, proxy *url.URL,
+	addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	/* a canceled context aborts the handshake in progress */
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+		_ = conn.SetDeadline(time.Time{})
+	}()
+	var err error
+	if proxy.Scheme == "socks5" {
+		err = socks5Connect(conn, proxy.User, addr)
+	} else {
+		conn, err = httpConnect(conn, proxy.User, addr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("proxy %s: %v", proxy.Host, err)
+	}
+	return conn, nil
+}
+
+// httpConnect opens an HTTP CONNECT tunnel
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) (net.Conn,
+	error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString(
+				[]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		/* the peer bytes already read along with the response */
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929)
+const (
+	socks5Version      = 0x05
+	socks5NoAuth       = 0x00
+	socks5UserPass     = 0x02
+	socks5NoAcceptable = 0xff
+	socks5CmdConnect   = 0x01
+	socks5IPv4         = 0x01
+	socks5Domain       = 0x03
+	socks5IPv6         = 0x04
+	socks5Succeeded    = 0x00
+)
+
+// socks5Connect opens a SOCKS5 tunnel, authenticating with the user name
+// and password when set
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{socks5NoAuth}
+	if user != nil {
+		methods = []byte{socks5NoAuth, socks5UserPass}
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5NoAuth:
+	case socks5UserPass:
+		if user == nil {
+			return errors.New("SOCKS5 proxy requires authentication")
+		}
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return errors.New("no acceptable SOCKS5 authentication method")
+	default:
+		return fmt.Errorf("unsupported SOCKS5 method %d", reply[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, socks5IPv4), ip4...)
+		} else {
+			req = append(append(req, socks5IPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long", host)
+		}
+		req = append(append(req, socks5Domain, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != socks5Succeeded {
+		return fmt.Errorf("SOCKS5 CONNECT %s failed with code %d", addr,
+			head[1])
+	}
+	/* skip the bound address and port */
+	var skip int
+	switch head[3] {
+	case socks5IPv4:
+		skip = net.IPv4len + 2
+	case socks5IPv6:
+		skip = net.IPv6len + 2
+	case socks5Domain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("unsupported SOCKS5 address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// socks5Authenticate runs the user name and password authentication
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	password, _ := user.Password()
+	if len(name) > 255 || len(password) > 255 {
+		return errors.New("SOCKS5 credentials too long")
+	}
+	req := append([]byte{0x01, byte(len(name))}, name...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("SOCKS5 authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultBudgetRatio    = 0.2
+	// retries always allowed by the budget, so that a low traffic NF can
+	// still retry
+	minBudget = 10
+)
+
+var clientRetries = metrics.NewCounterVec("nf_client_retries_total",
+	"Requests to the peer NFs that were retried, by reason.",
+	"peer", "reason")
+
+// retryPolicy decides whether and when a request is retried
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	budget         *retryBudget
+}
+
+func newRetryPolicy(cfg config.RetryConfig) *retryPolicy {
+	p := &retryPolicy{
+		maxRetries:     cfg.MaxRetries,
+		initialBackoff: time.Duration(cfg.InitialBackoff) * time.Millisecond,
+		maxBackoff:     time.Duration(cfg.MaxBackoff) * time.Millisecond,
+	}
+	if p.initialBackoff <= 0 {
+		p.initialBackoff = defaultInitialBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultMaxBackoff
+	}
+	ratio := cfg.BudgetRatio
+	if ratio <= 0 {
+		ratio = defaultBudgetRatio
+	}
+	p.budget = &retryBudget{ratio: ratio, tokens: minBudget}
+	return p
+}
+
+// retryable tells whether the outcome of an attempt may be retried, with
+// the delay requested by the peer if any. Only the failures where the peer
+// did not process the request are retried: connection errors, 502, 503,
+// 504 and responses carrying Retry-After
+func retryable(resp *http.Response, err error) (string, time.Duration,
+	bool) {
+	if err != nil {
+		if isConnError(err) {
+			return "connection", 0, true
+		}
+		return "", 0, false
+	}
+	if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+		return "retry-after", after, true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return strconv.Itoa(resp.StatusCode), 0, true
+	}
+	return "", 0, false
+}
+
+// Unreachable tells whether a request failed because the peer could not
+// be reached: a connection failure or an open circuit
+func Unreachable(err error) bool {
+	return isConnError(err) || errors.Is(err, ErrCircuitOpen)
+}
+
+func isConnError(err error) bool {
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter parses a Retry-After value, in seconds or as an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// backoff returns the delay before the retry: exponential in the attempt
+// number, capped, with equal jitter
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	d := p.initialBackoff << uint(attempt)
+	if d > p.maxBackoff || d <= 0 {
+		d = p.maxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryBudget limits the retries to a ratio of the requests: every request
+// deposits ratio tokens and every retry withdraws one
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens += b.ratio
+	if max := minBudget + 100*b.ratio; b.tokens > max {
+		b.tokens = max
+	}
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rewind returns a copy of the request with a fresh body for a retry
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return r, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	return r, nil
+}
+
+// discard drains and closes a response that is not returned to the caller,
+// so that its connection can be reused
+func discard(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// callbackRequestKey marks the context of the requests sent to a callback
+// URI
+type callbackRequestKey struct{}
+
+// sign sets the signature header of the requests sent to a callback URI,
+// or of all the requests when configured, signing the body as the pre-send
+// hooks transformed it
+func (c *Client) sign(req *http.Request) (*http.Request, error) {
+	c.mu.RLock()
+	signer := c.signer
+	c.mu.RUnlock()
+	if signer == nil || (req.Context().Value(callbackRequestKey{}) == nil &&
+		!signer.All()) {
+		return req, nil
+	}
+	data, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	if err := signer.Sign(out.Header, data); err != nil {
+		return nil, fmt.Errorf("signing the callback: %v", err)
+	}
+	return withBody(out, data), nil
+}
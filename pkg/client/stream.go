@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/stream"
+)
+
+// maxDuration disables a timeout
+const maxDuration = time.Duration(math.MaxInt64)
+
+type streamKey struct{}
+
+func streaming(ctx context.Context) bool {
+	s, _ := ctx.Value(streamKey{}).(bool)
+	return s
+}
+
+// NewStreamRequest returns a request whose body is written by produce while
+// the request is sent, in chunks following the flow control of the peer,
+// instead of being buffered. The body cannot be replayed, so the request is
+// not retried
+func NewStreamRequest(ctx context.Context, method, url, contentType string,
+	produce func(w io.Writer) error) (*http.Request, error) {
+	body := stream.Pipe(produce)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req.WithContext(ctx), nil
+}
+
+// Stream sends the request like Do, for the streams: the response body is
+// returned unread, to be read chunk by chunk with stream.Chunks or
+// stream.Copy and then closed. The response header and overall timeouts of
+// the peer do not apply, the request context bounds the whole exchange
+func (c *Client) Stream(req *http.Request) (*http.Response, error) {
+	return c.Do(req.WithContext(context.WithValue(req.Context(),
+		streamKey{}, true)))
+}
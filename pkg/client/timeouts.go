@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// Default client timeouts in milliseconds
+const (
+	defaultDialTimeout           = 5000
+	defaultTLSHandshakeTimeout   = 10000
+	defaultResponseHeaderTimeout = 10000
+	defaultOverallTimeout        = 30000
+)
+
+// timeouts are the client timeouts of a peer
+type timeouts struct {
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+	overall        time.Duration
+}
+
+// peerTimeouts returns the timeouts of the peer: its overrides, then the
+// client timeouts, then the defaults
+func peerTimeouts(cfg config.ClientTimeouts,
+	peer config.PeerConfig) timeouts {
+	t := config.ClientTimeouts{
+		Dial:           defaultDialTimeout,
+		TLSHandshake:   defaultTLSHandshakeTimeout,
+		ResponseHeader: defaultResponseHeaderTimeout,
+		Overall:        defaultOverallTimeout,
+	}.Merge(cfg).Merge(peer.Timeouts)
+	return timeouts{
+		dial:           time.Duration(t.Dial) * time.Millisecond,
+		tlsHandshake:   time.Duration(t.TLSHandshake) * time.Millisecond,
+		responseHeader: time.Duration(t.ResponseHeader) * time.Millisecond,
+		overall:        time.Duration(t.Overall) * time.Millisecond,
+	}
+}
+
+// roundTrip sends one attempt of the request within the overall timeout,
+// failing when the response headers do not arrive in time. The attempt
+// context is canceled once the response body is closed. The timeouts do
+// not apply to the streams, bounded by their request context only
+func (t timeouts) roundTrip(httpClient *http.Client,
+	req *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if streaming(req.Context()) {
+		ctx, cancel = context.WithCancel(req.Context())
+		t.responseHeader = maxDuration
+	} else {
+		ctx, cancel = context.WithTimeout(req.Context(), t.overall)
+	}
+	var headerTimedOut int32
+	timer := time.AfterFunc(t.responseHeader, func() {
+		atomic.StoreInt32(&headerTimedOut, 1)
+		cancel()
+	})
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		cancel()
+		if atomic.LoadInt32(&headerTimedOut) == 1 {
+			return nil, fmt.Errorf("%s %s: no response headers within %v",
+				req.Method, req.URL, t.responseHeader)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the attempt context when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
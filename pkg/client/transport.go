@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	defaultMaxIdleConns    = 16
+	defaultIdleTimeout     = 90000
+	defaultReadIdleTimeout = 15000
+	defaultPingTimeout     = 5000
+	defaultReapAfter       = 600000
+)
+
+var (
+	clientConnections = metrics.NewGaugeVec("nf_client_connections",
+		"Connections open to the peer NFs.", "peer")
+	clientConnAcquired = metrics.NewCounterVec(
+		"nf_client_connections_acquired_total",
+		"Connections obtained for the requests to the peer NFs, reused from "+
+			"the pool or newly dialed.", "peer", "reused")
+	clientPingFailures = metrics.NewCounterVec(
+		"nf_client_ping_failures_total",
+		"HTTP/2 connections to the peer NFs closed for a PING left "+
+			"unanswered.", "peer")
+	clientTransportsReaped = metrics.NewCounterVec(
+		"nf_client_transports_reaped_total",
+		"Transports to the peer NFs dropped with their connections after "+
+			"going unused.", "peer")
+)
+
+// transports holds one transport per peer host:port. It is created on the
+// first request to the peer and reused by the following ones, so that the
+// connections of each peer are pooled apart, with their own limits. The
+// transports of the peers no request was sent to for the reap time are
+// dropped with their connections
+type transports struct {
+	version   int
+	tlsConfig *tls.Config
+	pool      config.ConnPoolConfig
+	timeouts  config.ClientTimeouts
+	peers     map[string]config.PeerConfig
+	proxies   *proxies
+
+	mu     sync.Mutex
+	byHost map[string]http.RoundTripper
+	// used holds the time of the last request to each peer
+	used map[string]time.Time
+	// done stops the reaper
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newTransports(version int, tlsConfig *tls.Config, proxies *proxies,
+	cfg config.Common) *transports {
+	t := &transports{version: version, tlsConfig: tlsConfig,
+		pool: cfg.ConnPool, timeouts: cfg.Timeouts.Client, peers: cfg.Peers,
+		proxies: proxies, byHost: make(map[string]http.RoundTripper),
+		used: make(map[string]time.Time), done: make(chan struct{})}
+	reapAfter := cfg.ConnPool.ReapAfter
+	if reapAfter == 0 {
+		reapAfter = defaultReapAfter
+	}
+	if reapAfter > 0 {
+		go t.reap(time.Duration(reapAfter) * time.Millisecond)
+	}
+	return t
+}
+
+func (t *transports) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, err := t.get(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	return rt.RoundTrip(req)
+}
+
+// poolOf returns the connection pool limits of the peer
+func (t *transports) poolOf(host string) config.ConnPoolConfig {
+	pool := t.pool.Merge(t.peers[host].ConnPool)
+	if pool.MaxIdleConns <= 0 {
+		pool.MaxIdleConns = defaultMaxIdleConns
+	}
+	if pool.IdleTimeout <= 0 {
+		pool.IdleTimeout = defaultIdleTimeout
+	}
+	if pool.ReadIdleTimeout == 0 {
+		pool.ReadIdleTimeout = defaultReadIdleTimeout
+	}
+	if pool.PingTimeout <= 0 {
+		pool.PingTimeout = defaultPingTimeout
+	}
+	return pool
+}
+
+// timeoutsOf returns the timeouts of the peer
+func (t *transports) timeoutsOf(host string) timeouts {
+	return peerTimeouts(t.timeouts, t.peers[host])
+}
+
+// get returns the transport of the peer, created on first use
+func (t *transports) get(host string) (http.RoundTripper, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.used[host] = time.Now()
+	rt, ok := t.byHost[host]
+	if !ok {
+		var err error
+		if rt, err = t.create(host); err != nil {
+			return nil, err
+		}
+		t.byHost[host] = rt
+	}
+	return rt, nil
+}
+
+// create returns the transport of the peer: HTTP/2 with prior knowledge for
+// the h2c peers, HTTP/2 over TLS for the h2 peers, HTTP/1.1 for the
+// http/1.1 peers, and otherwise the protocol of the client version, the
+// version 2 falling back to HTTP/1.1 when the peer does not negotiate
+// HTTP/2.
+// The connections go through the proxy of the peer, if any
+func (t *transports) create(host string) (http.RoundTripper, error) {
+	to := t.timeoutsOf(host)
+	protocol := t.peers[host].Protocol
+	scheme := peerScheme(protocol, t.version)
+	var proxy *url.URL
+	if t.peers[host].Socket == "" {
+		var err error
+		if proxy, err = t.proxies.of(host, scheme); err != nil {
+			return nil, err
+		}
+	}
+	pool := t.poolOf(host)
+	dial := countingDialer(host, t.peers[host].Socket, proxy, to.dial,
+		time.Duration(pool.KeepAlive)*time.Millisecond)
+	idleTimeout := time.Duration(pool.IdleTimeout) * time.Millisecond
+	switch {
+	case protocol == config.ProtocolH2C:
+		return checkHealth(host, pool, &http2.Transport{
+			AllowHTTP:                  true,
+			StrictMaxConcurrentStreams: pool.StrictMaxConcurrentStreams,
+			DialTLSContext: func(ctx context.Context, network, addr string,
+				_ *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return watchGoAway(host, conn), nil
+			},
+			IdleConnTimeout: idleTimeout,
+		}), nil
+	case protocol == config.ProtocolHTTP11:
+		h1 := http1Transport(t.tlsConfig.Clone(), dial, to, pool)
+		h1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		return h1, nil
+	case protocol == config.ProtocolH2,
+		protocol == config.ProtocolDefault && t.version == 2:
+		h2 := checkHealth(host, pool, &http2.Transport{
+			TLSClientConfig:            t.tlsConfig,
+			StrictMaxConcurrentStreams: pool.StrictMaxConcurrentStreams,
+			DialTLSContext: requireHTTP2(func(ctx context.Context, network,
+				addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn := tls.Client(conn, cfg)
+				hctx, cancel := context.WithTimeout(ctx, to.tlsHandshake)
+				defer cancel()
+				if err := tlsConn.HandshakeContext(hctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return watchGoAway(host, tlsConn), nil
+			}),
+			IdleConnTimeout: idleTimeout,
+		})
+		if protocol == config.ProtocolH2 {
+			/* no fallback, the peers without HTTP/2 fail the requests */
+			return h2, nil
+		}
+		h1 := http1Transport(t.tlsConfig.Clone(), dial, to, pool)
+		h1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		return &negotiatingTransport{peer: host, h2: h2, h1: h1}, nil
+	}
+	return http1Transport(t.tlsConfig, dial, to, pool), nil
+}
+
+// peerScheme returns the URL scheme of a peer reached with the protocol by
+// a client of the version: http for h2c, and for HTTP/1.1 with version 1,
+// https otherwise
+func peerScheme(protocol string, version int) string {
+	switch {
+	case protocol == config.ProtocolH2C:
+		return "http"
+	case protocol == config.ProtocolH2:
+		return "https"
+	case version == 1:
+		return "http"
+	}
+	return "https"
+}
+
+// http1Transport returns the HTTP/1.1 transport of a peer, over TLS with
+// tlsConfig for the https URLs
+func http1Transport(tlsConfig *tls.Config, dial func(ctx context.Context,
+	network, addr string) (net.Conn, error), to timeouts,
+	pool config.ConnPoolConfig) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		DialContext:           dial,
+		TLSHandshakeTimeout:   to.tlsHandshake,
+		ResponseHeaderTimeout: to.responseHeader,
+		MaxIdleConns:          pool.MaxIdleConns,
+		MaxIdleConnsPerHost:   pool.MaxIdleConns,
+		MaxConnsPerHost:       pool.MaxConns,
+		IdleConnTimeout:       time.Duration(pool.IdleTimeout) * time.Millisecond,
+	}
+}
+
+// checkHealth makes the HTTP/2 transport of the peer send a PING on the
+// connections idle for the read idle timeout, and close the ones whose
+// PING goes unanswered, so that the next requests open a new connection
+// instead of waiting on a dead one
+func checkHealth(host string, pool config.ConnPoolConfig,
+	tr *http2.Transport) *http2.Transport {
+	if pool.ReadIdleTimeout < 0 {
+		return tr
+	}
+	tr.ReadIdleTimeout = time.Duration(pool.ReadIdleTimeout) *
+		time.Millisecond
+	tr.PingTimeout = time.Duration(pool.PingTimeout) * time.Millisecond
+	failures := clientPingFailures.WithLabelValues(host)
+	tr.CountError = func(errType string) {
+		if errType == "conn_close_lost_ping" {
+			failures.Inc()
+		}
+	}
+	return tr
+}
+
+// reap drops the transports of the peers unused for reapAfter, closing
+// their idle connections, until stop is called. The connections busy with
+// a stream end with it
+func (t *transports) reap(reapAfter time.Duration) {
+	ticker := time.NewTicker(reapAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			t.mu.Lock()
+			for host, used := range t.used {
+				if now.Sub(used) < reapAfter {
+					continue
+				}
+				if c, ok := t.byHost[host].(interface {
+					CloseIdleConnections()
+				}); ok {
+					c.CloseIdleConnections()
+				}
+				delete(t.byHost, host)
+				delete(t.used, host)
+				clientTransportsReaped.WithLabelValues(host).Inc()
+				logging.Debugf("Transport to %s unused for %v, dropped", host,
+					reapAfter)
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// stop stops the reaper of the transports replaced by a reload
+func (t *transports) stop() {
+	t.stopOnce.Do(func() { close(t.done) })
+}
+
+// CloseIdleConnections closes the idle connections of all the peers
+func (t *transports) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, rt := range t.byHost {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// countingDialer returns a dial function keeping the count of the
+// connections open to the peer. The peer is dialed on the unix domain
+// socket when one is set, otherwise through a tunnel of the proxy when one
+// is set. The TCP connections send keep-alive probes every keepAlive
+func countingDialer(peer, socket string, proxy *url.URL,
+	timeout, keepAlive time.Duration) func(ctx context.Context, network,
+	addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	gauge := clientConnections.WithLabelValues(peer)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		switch {
+		case socket != "":
+			conn, err = d.DialContext(ctx, "unix", socket)
+		case proxy != nil:
+			if conn, err = d.DialContext(ctx, network,
+				proxy.Host); err == nil {
+				conn, err = tunnel(ctx, conn, proxy, addr, timeout)
+			}
+		default:
+			dialer := d
+			if g, ok := ctx.Value(callbackKey{}).(*callbackGuard); ok {
+				dialer.ControlContext = g.control
+			}
+			conn, err = dialer.DialContext(ctx, network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		gauge.Inc()
+		return &countedConn{Conn: conn, gauge: gauge}, nil
+	}
+}
+
+// tunnel opens the tunnel to addr on the connection to the proxy, within
+// the dial timeout, and closes the connection when it fails
+func tunnel(ctx context.Context, conn net.Conn, proxy *url.URL, addr string,
+	timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tunneled, err := dialProxy(ctx, conn, proxy, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunneled, nil
+}
+
+type countedConn struct {
+	net.Conn
+	gauge *metrics.Gauge
+	once  sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.gauge.Dec)
+	return c.Conn.Close()
+}
+
+// traceConn returns the request counting whether its connection is reused,
+// and the attempts the transport sends again on a new connection because
+// the peer closed the previous one before processing the request, e.g. with
+// a GOAWAY
+func traceConn(req *http.Request) *http.Request {
+	peer := req.URL.Host
+	var conns int32
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			clientConnAcquired.WithLabelValues(peer,
+				strconv.FormatBool(info.Reused)).Inc()
+			if atomic.AddInt32(&conns, 1) > 1 {
+				clientRetries.WithLabelValues(peer, "unprocessed").Inc()
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
@@ -0,0 +1,357 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CBOR is the codec of application/cbor (RFC 8949). The values are mapped
+// as with JSON, following the json struct tags: objects become maps with
+// text keys, in sorted order, and []byte become base64 text strings
+var CBOR Codec = cborCodec{}
+
+// maxCBORDepth bounds the nesting of the decoded items
+const maxCBORDepth = 64
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+type cborCodec struct{}
+
+func (cborCodec) MediaType() string {
+	return "application/cbor"
+}
+
+// Marshal encodes v through its JSON representation
+func (cborCodec) Marshal(v interface{}) ([]byte, string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var generic interface{}
+	if err := d.Decode(&generic); err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, generic); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "application/cbor", nil
+}
+
+// Unmarshal decodes the CBOR item into v through its JSON representation
+func (cborCodec) Unmarshal(data []byte, _ string, v interface{}) error {
+	dec := cborDecoder{data: data}
+	generic, err := dec.item(0)
+	if err != nil {
+		return err
+	}
+	if dec.off != len(data) {
+		return errors.New("cbor: trailing data after the top level item")
+	}
+	j, err := json.Marshal(generic)
+	if err != nil {
+		return fmt.Errorf("cbor: %v", err)
+	}
+	return json.Unmarshal(j, v)
+}
+
+func encodeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n >= 0 {
+				writeHead(buf, cborUint, uint64(n))
+			} else {
+				writeHead(buf, cborNegint, uint64(-(n + 1)))
+			}
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeHead(buf, cborUint, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xfb)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(f))
+		buf.Write(b[:])
+	case string:
+		writeHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encodeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeHead(buf, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			writeHead(buf, cborText, uint64(len(k)))
+			buf.WriteString(k)
+			if err := encodeCBOR(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %T", v)
+	}
+	return nil
+}
+
+// writeHead writes the initial byte of an item and its argument in the
+// shortest form
+func writeHead(buf *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		buf.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{m | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	case n <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(m | 27)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		buf.Write(b[:])
+	}
+}
+
+// errBreak is the break stop code ending an indefinite length item
+var errBreak = errors.New("cbor: unexpected break")
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, errors.New("cbor: unexpected end of data")
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+// head reads the initial byte of an item and its argument. indefinite
+// reports the indefinite length items
+func (d *cborDecoder) head() (major byte, info byte, n uint64,
+	indefinite bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		arg, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+		for _, c := range arg {
+			n = n<<8 | uint64(c)
+		}
+	case info == 31:
+		if major == cborSimple {
+			return 0, 0, 0, false, errBreak
+		}
+		indefinite = true
+	default:
+		return 0, 0, 0, false, fmt.Errorf("cbor: reserved additional "+
+			"information %d", info)
+	}
+	return major, info, n, indefinite, nil
+}
+
+func (d *cborDecoder) item(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: items nested too deeply")
+	}
+	major, info, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case cborNegint:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflows")
+		}
+		return json.Number(strconv.FormatInt(-1-int64(n), 10)), nil
+	case cborBytes, cborText:
+		b, err := d.str(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return b, nil
+		}
+		if !utf8.Valid(b) {
+			return nil, errors.New("cbor: invalid UTF-8 text string")
+		}
+		return string(b), nil
+	case cborArray:
+		items := make([]interface{}, 0)
+		for i := uint64(0); indefinite || i < n; i++ {
+			item, err := d.item(depth + 1)
+			if indefinite && err == errBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < n; i++ {
+			key, err := d.item(depth + 1)
+			if indefinite && err == errBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k := key.(type) {
+			case string:
+				m[k] = value
+			case json.Number:
+				m[string(k)] = value
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key %T", key)
+			}
+		}
+		return m, nil
+	case cborTag:
+		/* the tags only qualify the enclosed item */
+		return d.item(depth + 1)
+	}
+	return d.simple(info, n)
+}
+
+// str reads a byte or text string, concatenating the chunks of an
+// indefinite length string
+func (d *cborDecoder) str(major byte, n uint64, indefinite bool) ([]byte,
+	error) {
+	if !indefinite {
+		if n > uint64(len(d.data)-d.off) {
+			return nil, errors.New("cbor: unexpected end of data")
+		}
+		return d.next(int(n))
+	}
+	var b []byte
+	for {
+		m, _, n, ind, err := d.head()
+		if err == errBreak {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m != major || ind {
+			return nil, errors.New("cbor: invalid indefinite length string")
+		}
+		chunk, err := d.str(major, n, false)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+}
+
+// simple returns the simple values and the floats
+func (d *cborDecoder) simple(info byte, n uint64) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		/* null and undefined */
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(n)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", n)
+}
+
+// halfFloat converts an IEEE 754 half precision float
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nested returns the CBOR item of the integer 0 enclosed in depth arrays
+func nested(depth int, indefinite bool) []byte {
+	var b []byte
+	for i := 0; i < depth; i++ {
+		if indefinite {
+			b = append(b, 0x9f)
+		} else {
+			b = append(b, 0x81)
+		}
+	}
+	b = append(b, 0x00)
+	if indefinite {
+		b = append(b, bytes.Repeat([]byte{0xff}, depth)...)
+	}
+	return b
+}
+
+func TestCBORDepth(t *testing.T) {
+	for _, indefinite := range []bool{false, true} {
+		var v interface{}
+		if err := CBOR.Unmarshal(nested(maxCBORDepth, indefinite), "",
+			&v); err != nil {
+			t.Errorf("depth %d, indefinite %v: %v", maxCBORDepth,
+				indefinite, err)
+		}
+		err := CBOR.Unmarshal(nested(maxCBORDepth+1, indefinite), "", &v)
+		if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Errorf("depth %d, indefinite %v: got error %v", maxCBORDepth+1,
+				indefinite, err)
+		}
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+		err  string
+	}{
+		{"uint", []byte{0x19, 0x01, 0xf4}, 500.0, ""},
+		{"negint", []byte{0x38, 0x63}, -100.0, ""},
+		{"half float", []byte{0xf9, 0x3e, 0x00}, 1.5, ""},
+		{"indefinite text", []byte{0x7f, 0x62, 'n', 'f', 0x61, '1', 0xff},
+			"nf1", ""},
+		{"bytes as base64", []byte{0x43, 0x01, 0x02, 0x03}, "AQID", ""},
+		{"tagged", []byte{0xc1, 0x1a, 0x5f, 0x5e, 0x10, 0x00},
+			1600000000.0, ""},
+		{"map", []byte{0xa2, 0x61, 'a', 0xf5, 0x01, 0xf6},
+			map[string]interface{}{"a": true, "1": nil}, ""},
+		{"truncated", []byte{0x82, 0x01}, nil, "unexpected end"},
+		{"trailing", []byte{0x01, 0x02}, nil, "trailing data"},
+		{"stray break", []byte{0xff}, nil, "unexpected break"},
+		{"invalid utf-8", []byte{0x61, 0xff}, nil, "invalid UTF-8"},
+		{"mixed chunks", []byte{0x7f, 0x41, 'a', 0xff}, nil,
+			"invalid indefinite length string"},
+		{"array key", []byte{0xa1, 0x80, 0x01}, nil, "unsupported map key"},
+		{"huge length", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			0xff, 0xff}, nil, "unexpected end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			err := CBOR.Unmarshal(tt.data, "", &v)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v, tt.want) {
+				t.Errorf("got %#v, want %#v", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	type location struct {
+		Supi    string   `json:"supi"`
+		Cells   []uint64 `json:"cells"`
+		Offset  int64    `json:"offset"`
+		Ratio   float64  `json:"ratio"`
+		Payload []byte   `json:"payload,omitempty"`
+		Roaming *bool    `json:"roaming"`
+	}
+	in := location{Supi: "imsi-001010000000001",
+		Cells: []uint64{0, 23, 24, 1 << 40, 1<<64 - 1}, Offset: -1 << 63,
+		Ratio: 0.25, Payload: []byte{0, 1, 2}}
+	data, contentType, err := CBOR.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/cbor" {
+		t.Errorf("got Content-Type %q", contentType)
+	}
+	var out location
+	if err := CBOR.Unmarshal(data, contentType, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+}
+
+func FuzzCBOR(f *testing.F) {
+	for _, v := range []interface{}{
+		nil, true, -1, 1 << 40, 0.5, "nf2", []byte{0xde, 0xad},
+		[]interface{}{1, "a", []interface{}{}},
+		map[string]interface{}{"supi": "imsi-001010000000001",
+			"cells": []int{1, 2}, "ok": false},
+	} {
+		data, _, err := CBOR.Marshal(v)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add(nested(maxCBORDepth, true))
+	f.Add([]byte{0xbf, 0x61, 'a', 0x5f, 0x41, 0x00, 0xff, 0xff})
+	f.Add([]byte{0xf9, 0x7c, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if err := CBOR.Unmarshal(data, "", &v); err != nil {
+			return
+		}
+		again, _, err := CBOR.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal %#v: %v", v, err)
+		}
+		var w interface{}
+		if err := CBOR.Unmarshal(again, "", &w); err != nil {
+			t.Fatalf("unmarshal %x: %v", again, err)
+		}
+		if !reflect.DeepEqual(v, w) {
+			t.Errorf("got %#v, want %#v", w, v)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+// Package codec encodes and decodes the NF message payloads in the media
+// types negotiated with the Accept and Content-Type headers: JSON, CBOR and
+// the 3GPP multipart/related messages carrying binary parts
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Codec encodes and decodes the payloads of a media type
+type Codec interface {
+	// MediaType is the media type handled by the codec, without parameters
+	MediaType() string
+	// Marshal encodes v and returns the Content-Type of the encoding, which
+	// may carry parameters such as a multipart boundary
+	Marshal(v interface{}) ([]byte, string, error)
+	// Unmarshal decodes the data of the Content-Type into v
+	Unmarshal(data []byte, contentType string, v interface{}) error
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = make(map[string]Codec)
+)
+
+func init() {
+	Register(JSON)
+	Register(CBOR)
+	Register(Multipart)
+}
+
+// Register adds the codec to the registry, replacing the codec of the same
+// media type
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[strings.ToLower(c.MediaType())] = c
+}
+
+// Lookup returns the codec of the media type. The +json structured syntax
+// suffix (e.g. application/problem+json) is handled by the JSON codec
+func Lookup(mediaType string) (Codec, bool) {
+	mediaType = strings.ToLower(mediaType)
+	mu.RLock()
+	c, ok := codecs[mediaType]
+	mu.RUnlock()
+	if !ok && strings.HasSuffix(mediaType, "+json") {
+		return JSON, true
+	}
+	return c, ok
+}
+
+// MediaTypes returns the registered media types, sorted
+func MediaTypes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	types := make([]string, 0, len(codecs))
+	for mt := range codecs {
+		types = append(types, mt)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// UnsupportedError is returned for a media type without codec
+type UnsupportedError struct {
+	MediaType string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported media type %q", e.MediaType)
+}
+
+// ForContentType returns the codec of a Content-Type header, JSON when it is
+// empty
+func ForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, &UnsupportedError{MediaType: contentType}
+	}
+	c, ok := Lookup(mt)
+	if !ok {
+		return nil, &UnsupportedError{MediaType: mt}
+	}
+	return c, nil
+}
+
+// Negotiate returns the codec preferred by an Accept header, following the
+// quality values and the order of the media ranges. JSON answers an empty
+// header and the wildcards. false is returned when no codec is acceptable
+func Negotiate(accept string) (Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return JSON, true
+	}
+	var best Codec
+	bestQ := 0.0
+	for _, item := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		var c Codec
+		switch {
+		case mt == "*/*", mt == "application/*":
+			c = JSON
+		default:
+			var ok bool
+			if c, ok = Lookup(mt); !ok {
+				continue
+			}
+		}
+		best, bestQ = c, q
+	}
+	return best, best != nil
+}
+
+// Write encodes v with the codec negotiated with the Accept header of the
+// request and writes it with the status. A 406 problem is written when no
+// codec is acceptable
+func Write(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	c, ok := Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		problem.Write(w, problem.New(http.StatusNotAcceptable, "",
+			"acceptable media types: "+strings.Join(MediaTypes(), ", ")))
+		return
+	}
+	data, contentType, err := c.Marshal(v)
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// ToJSON converts data of the Content-Type into JSON, e.g. for the schema
+// validation. JSON data is returned as it is
+func ToJSON(data []byte, contentType string) ([]byte, error) {
+	c, err := ForContentType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if c == JSON {
+		return data, nil
+	}
+	var v interface{}
+	if err := c.Unmarshal(data, contentType, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// JSON is the codec of application/json
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MediaType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, string, error) {
+	data, err := json.Marshal(v)
+	return data, "application/json", err
+}
+
+func (jsonCodec) Unmarshal(data []byte, _ string, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
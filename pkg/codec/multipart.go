@@ -0,0 +1,192 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// Media types of the binary parts of the 3GPP multipart messages
+const (
+	MediaType5GNAS = "application/vnd.3gpp.5gnas"
+	MediaTypeNGAP  = "application/vnd.3gpp.ngap"
+)
+
+// maxParts bounds the parts of a decoded multipart message
+const maxParts = 16
+
+// Part is a binary part of a multipart/related message, e.g. a NAS
+// message, referenced from the JSON root part by its Content-ID
+type Part struct {
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+// Message is a multipart/related message (3GPP TS 29.500 6.1.2.4): a JSON
+// root part and the binary parts it references. Root is decoded into the
+// value it points to, or into an interface{} when nil
+type Message struct {
+	Root  interface{}
+	Parts []Part
+}
+
+// Part returns the part of the Content-ID, nil when there is none
+func (m *Message) Part(contentID string) *Part {
+	for i := range m.Parts {
+		if m.Parts[i].ContentID == contentID {
+			return &m.Parts[i]
+		}
+	}
+	return nil
+}
+
+// Multipart is the codec of multipart/related. It encodes a Message or
+// *Message with its parts, any other value as the root part alone. Decoding
+// into a *Message keeps the binary parts, into any other value only the
+// root part is decoded
+var Multipart Codec = multipartCodec{}
+
+type multipartCodec struct{}
+
+func (multipartCodec) MediaType() string {
+	return "multipart/related"
+}
+
+func (multipartCodec) Marshal(v interface{}) ([]byte, string, error) {
+	msg, ok := v.(*Message)
+	if !ok {
+		if m, isMsg := v.(Message); isMsg {
+			msg = &m
+		} else {
+			msg = &Message{Root: v}
+		}
+	}
+	root, err := json.Marshal(msg.Root)
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := writePart(mw, "application/json", "", root); err != nil {
+		return nil, "", err
+	}
+	for _, p := range msg.Parts {
+		if err := writePart(mw, p.ContentType, p.ContentID,
+			p.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	contentType := mime.FormatMediaType("multipart/related",
+		map[string]string{"boundary": mw.Boundary(),
+			"type": "application/json"})
+	return buf.Bytes(), contentType, nil
+}
+
+func writePart(mw *multipart.Writer, contentType, contentID string,
+	data []byte) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	if contentID != "" {
+		h.Set("Content-Id", contentID)
+	}
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (multipartCodec) Unmarshal(data []byte, contentType string,
+	v interface{}) error {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("multipart: %v", err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return errors.New("multipart: no boundary")
+	}
+	start := trimID(params["start"])
+
+	var root *Part
+	var parts []Part
+	mr := multipart.NewReader(bytes.NewReader(data), boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("multipart: %v", err)
+		}
+		if len(parts) == maxParts {
+			return fmt.Errorf("multipart: more than %d parts", maxParts)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			return fmt.Errorf("multipart: %v", err)
+		}
+		parts = append(parts, Part{
+			ContentType: p.Header.Get("Content-Type"),
+			ContentID:   trimID(p.Header.Get("Content-Id")),
+			Data:        body,
+		})
+	}
+	if len(parts) == 0 {
+		return errors.New("multipart: no root part")
+	}
+	/* the root is the start part, the first one by default */
+	rootIndex := 0
+	if start != "" {
+		rootIndex = -1
+		for i := range parts {
+			if parts[i].ContentID == start {
+				rootIndex = i
+				break
+			}
+		}
+		if rootIndex < 0 {
+			return fmt.Errorf("multipart: no start part %q", start)
+		}
+	}
+	root = &parts[rootIndex]
+	rootCodec, err := ForContentType(root.ContentType)
+	if err != nil {
+		return fmt.Errorf("multipart root part: %v", err)
+	}
+	if rootCodec == Multipart {
+		return errors.New("multipart: nested multipart root part")
+	}
+	msg, ok := v.(*Message)
+	if !ok {
+		return rootCodec.Unmarshal(root.Data, root.ContentType, v)
+	}
+	if msg.Root == nil {
+		var generic interface{}
+		msg.Root = &generic
+	}
+	if err := rootCodec.Unmarshal(root.Data, root.ContentType,
+		msg.Root); err != nil {
+		return err
+	}
+	msg.Parts = append(append(msg.Parts[:0], parts[:rootIndex]...),
+		parts[rootIndex+1:]...)
+	return nil
+}
+
+// trimID removes the angle brackets of a Content-ID
+func trimID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"),
+		">")
+}
@@ -0,0 +1,247 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type rawPart struct {
+	contentType, contentID, body string
+}
+
+// related returns the multipart/related body of the parts and its
+// Content-Type, with the start parameter when it is not empty
+func related(t testing.TB, start string, parts ...rawPart) ([]byte,
+	string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", p.contentType)
+		if p.contentID != "" {
+			h.Set("Content-Id", p.contentID)
+		}
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(p.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"boundary": mw.Boundary(),
+		"type": "application/json"}
+	if start != "" {
+		params["start"] = start
+	}
+	return buf.Bytes(), mime.FormatMediaType("multipart/related", params)
+}
+
+func TestMultipartRoundTrip(t *testing.T) {
+	in := Message{Root: map[string]interface{}{
+		"n1MessageContainer": map[string]interface{}{
+			"n1MessageContent": map[string]interface{}{"contentId": "nas"}}},
+		Parts: []Part{
+			{ContentType: MediaType5GNAS, ContentID: "nas",
+				Data: []byte{0x7e, 0x00, 0x41}},
+			{ContentType: MediaTypeNGAP, ContentID: "ngap",
+				Data: []byte{0x00, 0x0f}},
+		}}
+	data, contentType, err := Multipart.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Message
+	if err := Multipart.Unmarshal(data, contentType, &out); err != nil {
+		t.Fatal(err)
+	}
+	if root := *out.Root.(*interface{}); !reflect.DeepEqual(root, in.Root) {
+		t.Errorf("got root %#v, want %#v", root, in.Root)
+	}
+	if !reflect.DeepEqual(out.Parts, in.Parts) {
+		t.Errorf("got parts %+v, want %+v", out.Parts, in.Parts)
+	}
+	if p := out.Part("ngap"); p == nil || p.ContentType != MediaTypeNGAP {
+		t.Errorf("got part %+v for ngap", p)
+	}
+
+	/* any other value than a message decodes the root part alone */
+	var root struct {
+		Container struct {
+			Content struct {
+				ContentID string `json:"contentId"`
+			} `json:"n1MessageContent"`
+		} `json:"n1MessageContainer"`
+	}
+	if err := Multipart.Unmarshal(data, contentType, &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Container.Content.ContentID != "nas" {
+		t.Errorf("got root %+v", root)
+	}
+}
+
+func TestMultipartStart(t *testing.T) {
+	parts := []rawPart{
+		{MediaType5GNAS, "<nas>", "\x7e\x00"},
+		{"application/json", "<root>", `{"supi":"imsi-001010000000001"}`},
+		{MediaTypeNGAP, "<ngap>", "\x00\x0f"},
+	}
+	tests := []struct {
+		name  string
+		start string
+		root  interface{}
+		parts []string
+		err   string
+	}{
+		{"second part", "<root>",
+			map[string]interface{}{"supi": "imsi-001010000000001"},
+			[]string{"nas", "ngap"}, ""},
+		{"without brackets", "root",
+			map[string]interface{}{"supi": "imsi-001010000000001"},
+			[]string{"nas", "ngap"}, ""},
+		{"first part by default", "", nil, nil,
+			"unsupported media type"},
+		{"unknown start", "<n2>", nil, nil, `no start part "n2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, contentType := related(t, tt.start, parts...)
+			var msg Message
+			err := Multipart.Unmarshal(data, contentType, &msg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root := *msg.Root.(*interface{}); !reflect.DeepEqual(root,
+				tt.root) {
+				t.Errorf("got root %#v, want %#v", root, tt.root)
+			}
+			var ids []string
+			for _, p := range msg.Parts {
+				ids = append(ids, p.ContentID)
+			}
+			if !reflect.DeepEqual(ids, tt.parts) {
+				t.Errorf("got parts %q, want %q", ids, tt.parts)
+			}
+		})
+	}
+}
+
+func TestMultipartMaxParts(t *testing.T) {
+	for _, n := range []int{maxParts, maxParts + 1} {
+		parts := []rawPart{{"application/json", "", "{}"}}
+		for i := 1; i < n; i++ {
+			parts = append(parts, rawPart{MediaType5GNAS,
+				fmt.Sprintf("<nas%d>", i), "\x7e"})
+		}
+		data, contentType := related(t, "", parts...)
+		var msg Message
+		err := Multipart.Unmarshal(data, contentType, &msg)
+		switch {
+		case n <= maxParts && err != nil:
+			t.Errorf("%d parts: %v", n, err)
+		case n <= maxParts && len(msg.Parts) != n-1:
+			t.Errorf("%d parts: got %d binary parts", n, len(msg.Parts))
+		case n > maxParts && (err == nil ||
+			!strings.Contains(err.Error(), "more than 16 parts")):
+			t.Errorf("%d parts: got error %v", n, err)
+		}
+	}
+}
+
+func TestMultipartErrors(t *testing.T) {
+	data, contentType := related(t, "", rawPart{"application/json", "",
+		"{}"})
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		err         string
+	}{
+		{"no boundary", data, "multipart/related", "no boundary"},
+		{"invalid Content-Type", data, "multipart/related; boundary",
+			"multipart:"},
+		{"no parts", []byte("--b--\r\n"), "multipart/related; boundary=b",
+			"no root part"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg Message
+			err := Multipart.Unmarshal(tt.data, tt.contentType, &msg)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+	nestedData, nestedType := related(t, "", rawPart{contentType, "",
+		string(data)})
+	var msg Message
+	err := Multipart.Unmarshal(nestedData, nestedType, &msg)
+	if err == nil || !strings.Contains(err.Error(), "nested multipart") {
+		t.Errorf("got error %v for a nested multipart root", err)
+	}
+}
+
+func FuzzMultipart(f *testing.F) {
+	for _, msg := range []Message{
+		{Root: map[string]interface{}{"supi": "imsi-001010000000001"}},
+		{Root: []interface{}{1.5, "a"}, Parts: []Part{
+			{ContentType: MediaType5GNAS, ContentID: "nas",
+				Data: []byte{0x7e, 0x00}}}},
+	} {
+		data, contentType, err := Multipart.Marshal(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data, contentType)
+	}
+	data, contentType := related(f, "<root>",
+		rawPart{MediaType5GNAS, "<nas>", "\x7e"},
+		rawPart{"application/cbor", "<root>", "\xa1\x61a\x01"})
+	f.Add(data, contentType)
+	f.Fuzz(func(t *testing.T, data []byte, contentType string) {
+		var msg Message
+		if err := Multipart.Unmarshal(data, contentType, &msg); err != nil {
+			return
+		}
+		again, againType, err := Multipart.Marshal(msg)
+		if err != nil {
+			t.Fatalf("marshal %+v: %v", msg, err)
+		}
+		var out Message
+		if err := Multipart.Unmarshal(again, againType, &out); err != nil {
+			t.Fatalf("unmarshal %q: %v", again, err)
+		}
+		root, outRoot := *msg.Root.(*interface{}), *out.Root.(*interface{})
+		if !reflect.DeepEqual(root, outRoot) {
+			t.Errorf("got root %#v, want %#v", outRoot, root)
+		}
+		if len(out.Parts) != len(msg.Parts) {
+			t.Fatalf("got %d parts, want %d", len(out.Parts), len(msg.Parts))
+		}
+		for i, p := range msg.Parts {
+			q := out.Parts[i]
+			if q.ContentType != p.ContentType ||
+				q.ContentID != trimID(p.ContentID) ||
+				!bytes.Equal(q.Data, p.Data) {
+				t.Errorf("got part %+v, want %+v", q, p)
+			}
+		}
+	})
+}
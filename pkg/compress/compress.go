@@ -0,0 +1,248 @@
+// Package compress encodes and decodes the message bodies in the content
+// codings negotiated with the Accept-Encoding and Content-Encoding
+// headers: gzip, deflate and br
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Identity is the content coding of the bodies sent as they are
+const Identity = "identity"
+
+// Encoding compresses and decompresses the bodies of a content coding
+type Encoding interface {
+	// Name is the content coding, e.g. gzip
+	Name() string
+	// NewReader returns the reader of the data decoded from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	// NewWriter returns the writer encoding the data written to w. The
+	// encoded data is complete once it is closed
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+var (
+	mu        sync.RWMutex
+	encodings = make(map[string]Encoding)
+)
+
+func init() {
+	Register(Gzip)
+	Register(Deflate)
+	Register(Brotli)
+}
+
+// Register adds the encoding to the registry, replacing the encoding of the
+// same name
+func Register(e Encoding) {
+	mu.Lock()
+	defer mu.Unlock()
+	encodings[strings.ToLower(e.Name())] = e
+}
+
+// Lookup returns the encoding of the content coding. x-gzip is gzip
+func Lookup(name string) (Encoding, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "x-gzip" {
+		name = "gzip"
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	e, ok := encodings[name]
+	return e, ok
+}
+
+// Names returns the registered content codings, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(encodings))
+	for name := range encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UnsupportedError is returned for a content coding without encoding
+type UnsupportedError struct {
+	Encoding string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported content coding %q", e.Encoding)
+}
+
+// NewReader returns the reader of the body r of the Content-Encoding, the
+// codings applied in order being decoded in reverse order. r itself is
+// returned for an empty or identity Content-Encoding
+func NewReader(r io.ReadCloser, contentEncoding string) (io.ReadCloser,
+	error) {
+	codings := strings.Split(contentEncoding, ",")
+	body := r
+	for i := len(codings) - 1; i >= 0; i-- {
+		name := strings.TrimSpace(codings[i])
+		if name == "" || strings.EqualFold(name, Identity) {
+			continue
+		}
+		e, ok := Lookup(name)
+		if !ok {
+			return nil, &UnsupportedError{Encoding: name}
+		}
+		decoded, err := e.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		body = readCloser{Reader: decoded, closers: []io.Closer{decoded,
+			body}}
+	}
+	return body, nil
+}
+
+// readCloser closes a decoder and the body it reads
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r readCloser) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Encode returns the data encoded in the content coding
+func Encode(name string, data []byte) ([]byte, error) {
+	e, ok := Lookup(name)
+	if !ok {
+		return nil, &UnsupportedError{Encoding: name}
+	}
+	var buf bytes.Buffer
+	w := e.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Negotiate returns the content coding preferred by an Accept-Encoding
+// header among the offered ones, listed in order of preference, following
+// the quality values. The offer first in the list wins among the codings
+// of the same quality. Identity is returned when the header is empty or
+// accepts none of them
+func Negotiate(acceptEncoding string, offers []string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return Identity
+	}
+	accepted := make(map[string]float64)
+	wildcard := -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				var err error
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		switch name {
+		case "":
+		case "*":
+			wildcard = q
+		case "x-gzip":
+			accepted["gzip"] = q
+		default:
+			accepted[name] = q
+		}
+	}
+	best, bestQ := Identity, 0.0
+	for _, offer := range offers {
+		offer = strings.ToLower(offer)
+		if _, ok := Lookup(offer); !ok {
+			continue
+		}
+		q, ok := accepted[offer]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// Gzip is the encoding of the gzip content coding
+var Gzip Encoding = gzipEncoding{}
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) Name() string {
+	return "gzip"
+}
+
+func (gzipEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipEncoding) NewWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+// Deflate is the encoding of the deflate content coding, the zlib format
+// of RFC 1950
+var Deflate Encoding = deflateEncoding{}
+
+type deflateEncoding struct{}
+
+func (deflateEncoding) Name() string {
+	return "deflate"
+}
+
+func (deflateEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func (deflateEncoding) NewWriter(w io.Writer) io.WriteCloser {
+	zw, _ := zlib.NewWriterLevel(w, flate.DefaultCompression)
+	return zw
+}
+
+// Brotli is the encoding of the br content coding
+var Brotli Encoding = brotliEncoding{}
+
+type brotliEncoding struct{}
+
+func (brotliEncoding) Name() string {
+	return "br"
+}
+
+func (brotliEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
+
+func (brotliEncoding) NewWriter(w io.Writer) io.WriteCloser {
+	return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// ACL actions
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// ACLConfig contains the access control of the routes by the identity of
+// the client certificates, with mutual TLS
+type ACLConfig struct {
+	Enabled bool `json:"enabled"`
+	// Default is the action of the requests no rule matches, ACLDeny when
+	// empty
+	Default string `json:"default"`
+	// Rules are evaluated in order, the first one matching the client,
+	// server, route and method of a request decides
+	Rules []ACLRule `json:"rules"`
+}
+
+// ACLRule allows or denies the requests it matches
+type ACLRule struct {
+	// Clients lists the identities matched, path.Match patterns of the
+	// DNS, URI (e.g. SPIFFE IDs) and IP SANs and the CN of the client
+	// certificate, e.g. "spiffe://example.org/nf2/*" or "*.nf2.example.org".
+	// Any client, with a certificate or not, when empty
+	Clients []string `json:"clients"`
+	// Servers lists the server endpoint names (e.g. "NF"), any when empty
+	Servers []string `json:"servers"`
+	// Routes lists path.Match patterns of the route patterns (e.g.
+	// "/nf1"), any when empty
+	Routes []string `json:"routes"`
+	// Methods lists the request methods, any when empty
+	Methods []string `json:"methods"`
+	// Action is ACLAllow or ACLDeny
+	Action string `json:"action"`
+}
+
+// Validate checks the actions and the patterns of the rules
+func (a *ACLConfig) Validate() error {
+	if a.Default != "" && a.Default != ACLAllow && a.Default != ACLDeny {
+		return fmt.Errorf("acl.default: unknown action %q, expected %s or %s",
+			a.Default, ACLAllow, ACLDeny)
+	}
+	for i, r := range a.Rules {
+		if r.Action != ACLAllow && r.Action != ACLDeny {
+			return fmt.Errorf("acl.rules[%d].action: unknown action %q, "+
+				"expected %s or %s", i, r.Action, ACLAllow, ACLDeny)
+		}
+		for _, patterns := range [][]string{r.Clients, r.Routes} {
+			for _, p := range patterns {
+				if _, err := path.Match(p, ""); err != nil {
+					return fmt.Errorf("acl.rules[%d]: pattern %q: %v", i, p,
+						err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ACME challenge types
+const (
+	ACMEHTTP01    string = "http-01"
+	ACMETLSALPN01 string = "tls-alpn-01"
+)
+
+// Default ACME settings
+const (
+	// DefaultACMECacheDir stores the account key and the certificates
+	DefaultACMECacheDir string = "certs/acme"
+	// DefaultACMEHTTPAddress serves the HTTP-01 challenges
+	DefaultACMEHTTPAddress string = ":80"
+)
+
+// ACMEConfig contains the automatic provisioning of the server certificates
+// from an ACME CA such as Let's Encrypt, for the deployments with public
+// host names. It replaces the certificate files of the servers it applies
+// to, the CA bundle still verifies the peers. It is read at startup only
+type ACMEConfig struct {
+	Enabled bool `json:"enabled"`
+	// Hosts lists the public host names certificates are obtained for, the
+	// handshakes for other names fail
+	Hosts []string `json:"hosts"`
+	// Email is the contact of the ACME account, optional
+	Email string `json:"email"`
+	// AcceptTOS accepts the terms of service of the CA, required
+	AcceptTOS bool `json:"accepttos"`
+	// DirectoryURL is the directory of the CA, Let's Encrypt production
+	// when empty
+	DirectoryURL string `json:"directoryurl"`
+	// CacheDir stores the account key and the certificates across
+	// restarts, DefaultACMECacheDir when empty
+	CacheDir string `json:"cachedir"`
+	// Challenge is ACMETLSALPN01, answered by the servers themselves on
+	// port 443, or ACMEHTTP01, answered on HTTPAddress. ACMETLSALPN01 when
+	// empty
+	Challenge string `json:"challenge"`
+	// HTTPAddress is the listen address of the HTTP-01 challenges, which
+	// redirects the other requests to https, DefaultACMEHTTPAddress when
+	// empty
+	HTTPAddress string `json:"httpaddress"`
+	// RenewBefore in hours renews the certificates before they expire, 720
+	// (30 days) when 0
+	RenewBefore int `json:"renewbefore"`
+	// Servers lists the server endpoint names using the ACME certificates,
+	// all when empty
+	Servers []string `json:"servers"`
+}
+
+// Serves tells whether the named server endpoint uses the ACME certificates
+func (a *ACMEConfig) Serves(server string) bool {
+	if !a.Enabled {
+		return false
+	}
+	if len(a.Servers) == 0 {
+		return true
+	}
+	for _, s := range a.Servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the hosts, the challenge and the acceptance of the terms
+// of service
+func (a *ACMEConfig) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	switch {
+	case len(a.Hosts) == 0:
+		return fmt.Errorf("tls.acme.hosts: no host name")
+	case !a.AcceptTOS:
+		return fmt.Errorf("tls.acme.accepttos: the terms of service of the " +
+			"CA must be accepted")
+	case a.RenewBefore < 0:
+		return fmt.Errorf("tls.acme.renewbefore: %d is negative",
+			a.RenewBefore)
+	}
+	for _, h := range a.Hosts {
+		if h == "" || strings.Contains(h, "*") {
+			return fmt.Errorf("tls.acme.hosts: %q is not a host name, the "+
+				"wildcards need a DNS-01 challenge", h)
+		}
+	}
+	switch a.Challenge {
+	case "", ACMETLSALPN01, ACMEHTTP01:
+	default:
+		return fmt.Errorf("tls.acme.challenge: unknown challenge %q, "+
+			"expected %s or %s", a.Challenge, ACMETLSALPN01, ACMEHTTP01)
+	}
+	if a.DirectoryURL != "" {
+		if err := CheckURL(a.DirectoryURL); err != nil {
+			return fmt.Errorf("tls.acme.directoryurl: %v", err)
+		}
+	}
+	return nil
+}
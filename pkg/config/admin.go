@@ -0,0 +1,46 @@
+package config
+
+// AdminConfig contains the settings of the admin listener serving the
+// runtime controls of the NF
+type AdminConfig struct {
+	Enabled bool `json:"enabled"`
+	// Address is the host:port of the listener. It listens on the loopback
+	// interface when the host is empty
+	Address string `json:"address"`
+	// DrainTimeout is the time in milliseconds given to the requests in
+	// progress when the NF is drained
+	DrainTimeout int `json:"draintimeout"`
+	// Debug serves the pprof profiles under /debug/pprof/ and the expvar
+	// variables on /debug/vars
+	Debug bool `json:"debug"`
+	// TokenFile contains the Bearer token required on the admin and debug
+	// endpoints. They are open when empty
+	TokenFile string `json:"tokenfile"`
+	// Monitor contains the settings of the WebSocket traffic monitor
+	Monitor MonitorConfig `json:"monitor"`
+}
+
+// MonitorConfig contains the settings of the WebSocket endpoint of the admin
+// listener mirroring the NF traffic to the monitoring dashboards
+type MonitorConfig struct {
+	Enabled bool `json:"enabled"`
+	// Path of the endpoint, /admin/monitor by default
+	Path string `json:"path"`
+	// TokenFile contains the token required to connect, given as a Bearer
+	// token or with the access_token query parameter of the browsers. The
+	// admin token is used when empty, the monitor is refused without token
+	TokenFile string `json:"tokenfile"`
+	// Origins lists the origins of the dashboards allowed to connect, any
+	// when empty
+	Origins []string `json:"origins"`
+	// Rate is the number of messages per second sent to a connection, the
+	// messages above it are dropped
+	Rate float64 `json:"rate"`
+	// Burst is the number of messages sent at once above the rate
+	Burst int `json:"burst"`
+	// BufferSize is the number of messages waiting to be sent to a
+	// connection, the messages above it are dropped
+	BufferSize int `json:"buffersize"`
+	// MaxConnections limits the connections, unlimited when 0
+	MaxConnections int `json:"maxconnections"`
+}
@@ -0,0 +1,26 @@
+package config
+
+// AdmissionConfig contains the concurrency limit of each server. The
+// requests over the limit wait in a bounded queue, ordered by their
+// 3gpp-Sbi-Message-Priority, and are shed when it is full or when they
+// waited too long
+type AdmissionConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxInFlight is the number of requests handled at once per server
+	MaxInFlight int `json:"maxinflight"`
+	// MaxQueue is the number of requests waiting per server, none when 0
+	MaxQueue int `json:"maxqueue"`
+	// QueueTimeout is the time in milliseconds a request waits in the
+	// queue before it is shed
+	QueueTimeout int `json:"queuetimeout"`
+	// RetryAfter is the Retry-After in seconds of the shed requests
+	RetryAfter int `json:"retryafter"`
+	// PriorityLevels is the number of queue levels the message priorities
+	// (0 the highest to 31) are evenly mapped onto: the requests of a
+	// higher level are admitted first and take the place of those of a
+	// lower level in a full queue. 4 when 0, 1 for a single queue
+	PriorityLevels int `json:"prioritylevels"`
+	// DefaultPriority is the message priority of the requests without the
+	// 3gpp-Sbi-Message-Priority header, 24 when 0
+	DefaultPriority int `json:"defaultpriority"`
+}
@@ -0,0 +1,64 @@
+package config
+
+import "fmt"
+
+// Audit log outputs
+const (
+	AuditFile   string = "file"
+	AuditSyslog string = "syslog"
+)
+
+// AuditConfig contains the audit log of the security relevant events:
+// authentication failures, authorization denials, configuration changes,
+// certificate rotations and admin actions. It is separate from the log
+// and read at startup only
+type AuditConfig struct {
+	Enabled bool `json:"enabled"`
+	// Output is AuditFile or AuditSyslog, AuditFile when empty
+	Output string `json:"output"`
+	// File the records are appended to, one JSON object per line
+	File string `json:"file"`
+	// Syslog contains the syslog server settings
+	Syslog SyslogConfig `json:"syslog"`
+}
+
+// SyslogConfig contains the syslog server the audit records are sent to, in
+// the RFC 5424 format
+type SyslogConfig struct {
+	// Network is udp, tcp, unix or unixgram, unixgram when empty
+	Network string `json:"network"`
+	// Address is a host:port or a socket path, /dev/log when empty
+	Address string `json:"address"`
+	// Facility is a facility number, 10 (authpriv) when 0
+	Facility int `json:"facility"`
+	// Tag is the APP-NAME of the messages, "nfservice" when empty
+	Tag string `json:"tag"`
+}
+
+// Validate checks the output settings
+func (a *AuditConfig) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	switch a.Output {
+	case "", AuditFile:
+		if a.File == "" {
+			return fmt.Errorf("audit.file: missing file of the audit log")
+		}
+	case AuditSyslog:
+		switch a.Syslog.Network {
+		case "", "udp", "tcp", "unix", "unixgram":
+		default:
+			return fmt.Errorf("audit.syslog.network: unknown network %q",
+				a.Syslog.Network)
+		}
+		if a.Syslog.Facility < 0 || a.Syslog.Facility > 23 {
+			return fmt.Errorf("audit.syslog.facility: %d out of 0-23",
+				a.Syslog.Facility)
+		}
+	default:
+		return fmt.Errorf("audit.output: unknown output %q, expected %s or %s",
+			a.Output, AuditFile, AuditSyslog)
+	}
+	return nil
+}
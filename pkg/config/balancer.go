@@ -0,0 +1,14 @@
+package config
+
+// Load balancing policies choosing among the instances of a peer
+const (
+	// BalanceRoundRobin sends the requests to the instances in turn
+	BalanceRoundRobin string = "roundrobin"
+	// BalanceLeastRequests sends a request to the instance with the
+	// fewest requests in progress
+	BalanceLeastRequests string = "leastrequests"
+	// BalanceWeighted sends the requests to the instances of the highest
+	// priority, the lowest value, at random in proportion to their
+	// capacity
+	BalanceWeighted string = "weighted"
+)
@@ -0,0 +1,12 @@
+package config
+
+// BatchConfig contains the batch location requests of NF1, fanned out to
+// NF2 from POST /nf2loc/batch
+type BatchConfig struct {
+	// MaxItems is the number of requests of a batch above which it is
+	// refused, 100 when 0
+	MaxItems int `json:"maxitems"`
+	// Parallelism is the number of requests of a batch sent at once, 8
+	// when 0
+	Parallelism int `json:"parallelism"`
+}
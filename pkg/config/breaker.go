@@ -0,0 +1,17 @@
+package config
+
+// BreakerConfig contains the circuit breaker settings applied to each peer
+// NF host:port
+type BreakerConfig struct {
+	// Enabled turns the circuit breakers on
+	Enabled bool `json:"enabled"`
+	// FailureThreshold is the number of consecutive failures opening the
+	// circuit
+	FailureThreshold int `json:"failurethreshold"`
+	// Cooldown is the time in milliseconds the circuit stays open before
+	// probe requests are let through
+	Cooldown int `json:"cooldown"`
+	// HalfOpenRequests is the number of concurrent probe requests allowed
+	// while half-open
+	HalfOpenRequests int `json:"halfopenrequests"`
+}
@@ -0,0 +1,32 @@
+package config
+
+// CacheConfig contains the HTTP caching of the responses: the ETags and
+// conditional GET of the servers, and the response cache of the client
+type CacheConfig struct {
+	// ETag sets a strong ETag, the hash of the body, on the 200 responses
+	// to GET of the routes without one, and answers 304 Not Modified to
+	// the requests whose If-None-Match carries the ETag of the response
+	ETag bool `json:"etag"`
+	// Routes lists the route patterns concerned, e.g. "/subscriptions/",
+	// all the routes but the streaming ones when empty
+	Routes []string `json:"routes"`
+	// MaxAge in seconds is set as Cache-Control max-age on those responses
+	// without Cache-Control, none when 0
+	MaxAge int `json:"maxage"`
+	// Client caches the responses of the peers
+	Client ClientCacheConfig `json:"client"`
+}
+
+// ClientCacheConfig contains the cache of the responses to the GET
+// requests of the client: they are reused while fresh per their
+// Cache-Control max-age or Expires, and revalidated with If-None-Match
+// once stale
+type ClientCacheConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxEntries bounds the responses kept, the least recently used being
+	// dropped, 1000 by default
+	MaxEntries int `json:"maxentries"`
+	// MaxBodySize bounds the bodies of the responses kept, 65536 by
+	// default
+	MaxBodySize int `json:"maxbodysize"`
+}
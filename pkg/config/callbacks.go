@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// CallbacksConfig contains the checks of the callback URIs the clients ask
+// the NF to send requests to: the location reports, the job results and
+// the notifications. They protect the networks reachable from the NF from
+// the server side request forgery
+type CallbacksConfig struct {
+	// Enabled checks the callback URIs. The link-local addresses, e.g.
+	// 169.254.169.254, and the cloud metadata addresses are then refused
+	Enabled bool `json:"enabled"`
+	// Schemes lists the schemes allowed, http and https when empty
+	Schemes []string `json:"schemes"`
+	// Hosts lists the hosts allowed, as path.Match patterns of the host
+	// names (e.g. "*.nf1.example.org") or CIDRs (e.g. "10.0.0.0/8") the
+	// addresses of the hosts must all belong to. Any host when empty
+	Hosts []string `json:"hosts"`
+	// Deny lists CIDRs refused besides the link-local and metadata ones,
+	// e.g. the address ranges of the management network
+	Deny []string `json:"deny"`
+}
+
+// Validate checks the host patterns and the CIDRs
+func (c *CallbacksConfig) Validate() error {
+	for _, h := range c.Hosts {
+		if strings.Contains(h, "/") {
+			if _, _, err := net.ParseCIDR(h); err != nil {
+				return fmt.Errorf("callbacks.hosts: %v", err)
+			}
+			continue
+		}
+		if _, err := path.Match(h, ""); err != nil {
+			return fmt.Errorf("callbacks.hosts: pattern %q: %v", h, err)
+		}
+	}
+	for _, d := range c.Deny {
+		if _, _, err := net.ParseCIDR(d); err != nil {
+			return fmt.Errorf("callbacks.deny: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,499 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the servers and clients Check compares the settings with
+const (
+	defaultAdminAddress = "127.0.0.1:9990"
+	defaultWriteTimeout = 30000
+	certExpiryWarning   = 30 * 24 * time.Hour
+	unixEndpointScheme  = "unix://"
+	adminEndpointName   = "ADMIN"
+	grpcEndpointName    = "GRPC"
+	proxyEndpointName   = "PROXY"
+	acmeEndpointName    = "ACME"
+)
+
+// Endpoint is a listen address of a server endpoint of an NF
+type Endpoint struct {
+	// Server is the name of the endpoint, e.g. "API"
+	Server string
+	// Field is the configuration field setting the address
+	Field   string
+	Address string
+}
+
+// Problem is an issue of the configuration found by Check
+type Problem struct {
+	// Field is the path of the setting, e.g. timeouts.server.readheader
+	Field   string
+	Message string
+	// Warning is set for the settings that work but are likely wrong
+	Warning bool
+}
+
+func (p Problem) String() string {
+	if p.Warning {
+		return "warning: " + p.Field + ": " + p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// Check checks what Validate does not: the syntax of the listen addresses
+// of the NF endpoints, those of the shared sections added, and the port
+// conflicts between them, the schemes of the API roots, the TLS files of
+// the endpoints and their expiry when withTLS is set, the TLS policy, for
+// HTTP/2 when withTLS is set, the revocation checks, the sanity of the
+// timeouts, the message versions of the peers, the access control rules,
+// the callback hosts, the tenants, the API versions, the maintenance and
+// the latency objectives
+func (c *Common) Check(endpoints []Endpoint, withTLS bool) []Problem {
+	var problems []Problem
+	add := func(field string, warning bool, format string, a ...interface{}) {
+		problems = append(problems, Problem{Field: field,
+			Message: fmt.Sprintf(format, a...), Warning: warning})
+	}
+	endpoints = append(endpoints, c.sharedEndpoints()...)
+	c.checkEndpoints(endpoints, add)
+	c.checkAPIRoots(add)
+	if withTLS {
+		c.checkTLS(endpoints, add)
+	}
+	c.checkTimeouts(add)
+	c.checkPeers(add)
+	c.checkVHosts(endpoints, add)
+	c.checkALPN(endpoints, withTLS, add)
+	c.checkACL(withTLS, add)
+	if err := c.TLS.Policy.Validate(withTLS); err != nil {
+		field, msg, _ := strings.Cut(err.Error(), ": ")
+		add(field, false, "%s", msg)
+	}
+	if c.TLS.Revocation.Mode != "" && !c.TLS.MutualTLS {
+		add("tls.revocation.mode", true, "no client certificate to check "+
+			"without mutualtls")
+	}
+	for _, v := range []Validator{&c.TLS.Revocation, &c.Callbacks,
+		c.Tenants, &c.Versioning, &c.Maintenance, &c.SLO} {
+		if err := v.Validate(); err != nil {
+			/* the error starts with the field */
+			field, msg, _ := strings.Cut(err.Error(), ": ")
+			add(field, false, "%s", msg)
+		}
+	}
+	if c.Replication.Enabled && c.Replication.Token == "" &&
+		!(withTLS && c.TLS.MutualTLS) {
+		add("replication.token", true, "no token nor mutual TLS, the "+
+			"peers are refused the stream")
+	}
+	return problems
+}
+
+// sharedEndpoints returns the listen addresses set by the shared sections:
+// the admin, gRPC, proxy and ACME servers and the additional addresses of the
+// endpoints
+func (c *Common) sharedEndpoints() []Endpoint {
+	var endpoints []Endpoint
+	if c.Admin.Enabled {
+		addr := c.Admin.Address
+		if addr == "" {
+			addr = defaultAdminAddress
+		}
+		endpoints = append(endpoints, Endpoint{Server: adminEndpointName,
+			Field: "admin.address", Address: addr})
+	}
+	if c.GRPC.Enabled && c.GRPC.Address != "" {
+		endpoints = append(endpoints, Endpoint{Server: grpcEndpointName,
+			Field: "grpc.address", Address: c.GRPC.Address})
+	}
+	if c.Routing.Enabled {
+		endpoints = append(endpoints, Endpoint{Server: proxyEndpointName,
+			Field: "routing.address", Address: c.Routing.Address})
+	}
+	if acme := c.TLS.ACME; acme.Enabled && acme.Challenge == ACMEHTTP01 {
+		addr := acme.HTTPAddress
+		if addr == "" {
+			addr = DefaultACMEHTTPAddress
+		}
+		endpoints = append(endpoints, Endpoint{Server: acmeEndpointName,
+			Field: "tls.acme.httpaddress", Address: addr})
+	}
+	names := make([]string, 0, len(c.Servers))
+	for name := range c.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for i, l := range c.Servers[name].Listen {
+			endpoints = append(endpoints, Endpoint{Server: name,
+				Field:   fmt.Sprintf("servers.%s.listen[%d].address", name, i),
+				Address: l.Address})
+		}
+	}
+	return endpoints
+}
+
+// checkEndpoints checks that the addresses are a host:port with a numeric
+// port or a unix:// path, and that no two endpoints listen on the same
+// port of the same host, the wildcard host overlapping any
+func (c *Common) checkEndpoints(endpoints []Endpoint,
+	add func(string, bool, string, ...interface{})) {
+	type listener struct {
+		Endpoint
+		host string
+	}
+	ports := make(map[string][]listener)
+	sockets := make(map[string]Endpoint)
+	for _, e := range endpoints {
+		if strings.HasPrefix(e.Address, unixEndpointScheme) {
+			path := strings.TrimPrefix(e.Address, unixEndpointScheme)
+			if path == "" {
+				add(e.Field, false, "empty unix socket path")
+				continue
+			}
+			if other, ok := sockets[path]; ok {
+				add(e.Field, false, "socket %s already used by %s (%s)",
+					path, other.Server, other.Field)
+				continue
+			}
+			sockets[path] = e
+			continue
+		}
+		host, port, err := net.SplitHostPort(e.Address)
+		if err != nil {
+			add(e.Field, false, "invalid address %q: %v", e.Address, err)
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			add(e.Field, false, "invalid port %q", port)
+			continue
+		}
+		if host != "" && net.ParseIP(host) == nil && strings.ContainsAny(host,
+			" /:") {
+			add(e.Field, false, "invalid host %q", host)
+			continue
+		}
+		if port == "0" {
+			/* an ephemeral port conflicts with none */
+			continue
+		}
+		for _, other := range ports[port] {
+			if wildcard(host) || wildcard(other.host) || host == other.host {
+				add(e.Field, false, "port %s already used by %s (%s)",
+					port, other.Server, other.Field)
+				break
+			}
+		}
+		ports[port] = append(ports[port], listener{Endpoint: e, host: host})
+	}
+}
+
+// wildcard tells whether the host listens on all the interfaces
+func wildcard(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+// checkAPIRoots checks the API roots of the NRF and the SCP
+func (c *Common) checkAPIRoots(add func(string, bool, string, ...interface{})) {
+	roots := []struct{ field, root string }{
+		{"nrf.apiroot", c.NRF.APIRoot},
+		{"scp.apiroot", c.SCP.APIRoot},
+	}
+	for _, r := range roots {
+		if r.root == "" {
+			continue
+		}
+		if err := CheckURL(r.root); err != nil {
+			add(r.field, false, "%v", err)
+		}
+	}
+}
+
+// CheckURL checks that the URL is absolute with the http or https scheme
+// and a host
+func CheckURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %q in %q, expected http or https",
+			u.Scheme, s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", s)
+	}
+	return nil
+}
+
+// checkTLS checks that the certificate files of the endpoints and of the
+// client exist and that the certificates are not expired, warning about
+// the ones expiring within certExpiryWarning. The secret references are
+// not read
+func (c *Common) checkTLS(endpoints []Endpoint,
+	add func(string, bool, string, ...interface{})) {
+	seen := make(map[string]bool)
+	check := func(field, file string, cert bool) {
+		if seen[file] || isSecretRef(file) {
+			return
+		}
+		seen[file] = true
+		data, err := os.ReadFile(file)
+		if err != nil {
+			add(field, false, "%v", err)
+			return
+		}
+		if !cert {
+			return
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest =
+			pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			x, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				add(field, false, "%s: %v", file, err)
+				return
+			}
+			left := time.Until(x.NotAfter)
+			switch {
+			case left <= 0:
+				add(field, false, "%s: certificate %q expired on %s", file,
+					x.Subject.CommonName, x.NotAfter.Format(time.RFC3339))
+			case left < certExpiryWarning:
+				add(field, true, "%s: certificate %q expires on %s", file,
+					x.Subject.CommonName, x.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+	checkFiles := func(field string, f TLSFiles) {
+		check(field+".certfile", f.CertFile, true)
+		check(field+".keyfile", f.KeyFile, false)
+		check(field+".cafile", f.CAFile, true)
+	}
+	for _, e := range endpoints {
+		if e.Server == adminEndpointName || e.Server == acmeEndpointName ||
+			strings.HasPrefix(e.Address, unixEndpointScheme) ||
+			c.Servers[e.Server].Protocol == ProtocolH2C {
+			continue
+		}
+		files := c.TLS.ServerFiles(e.Server)
+		for _, l := range c.Servers[e.Server].Listen {
+			if l.Address == e.Address {
+				files = files.Override(l.TLS)
+			}
+		}
+		if c.TLS.ACME.Serves(e.Server) {
+			check("tls.servers."+e.Server+".cafile", files.CAFile, true)
+			continue
+		}
+		checkFiles("tls.servers."+e.Server, files)
+	}
+	checkFiles("tls.client", c.TLS.ClientFiles())
+}
+
+// checkTimeouts checks that the timeouts are not negative and are
+// consistent with each other
+func (c *Common) checkTimeouts(add func(string, bool, string, ...interface{})) {
+	s := c.Timeouts.Server
+	for _, t := range []struct {
+		field string
+		value int
+	}{
+		{"timeouts.server.read", s.Read},
+		{"timeouts.server.readheader", s.ReadHeader},
+		{"timeouts.server.write", s.Write},
+		{"timeouts.server.idle", s.Idle},
+	} {
+		if t.value < 0 {
+			add(t.field, false, "negative timeout %d", t.value)
+		}
+	}
+	if s.Read > 0 && s.ReadHeader > s.Read {
+		add("timeouts.server.readheader", false,
+			"%d ms longer than the read timeout (%d ms)", s.ReadHeader, s.Read)
+	}
+	write := s.Write
+	if write <= 0 {
+		write = defaultWriteTimeout
+	}
+	routes := make([]string, 0, len(s.Routes))
+	for route := range s.Routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		field := "timeouts.server.routes." + route
+		switch d := s.Routes[route]; {
+		case d < 0:
+			add(field, false, "negative deadline %d", d)
+		case d > write:
+			add(field, true, "deadline of %d ms longer than the write "+
+				"timeout (%d ms), the response cannot be written", d, write)
+		}
+	}
+	checkClient("timeouts.client", c.Timeouts.Client, add)
+	peers := make([]string, 0, len(c.Peers))
+	for peer := range c.Peers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		t := c.Peers[peer].Timeouts
+		if t == (ClientTimeouts{}) {
+			continue
+		}
+		checkClient("peers."+peer+".timeouts", c.Timeouts.Client.Merge(t), add)
+	}
+}
+
+// checkVHosts checks that the virtual hosts of the endpoints have both a
+// certificate and a key, or none, and route groups of known endpoints
+func (c *Common) checkVHosts(endpoints []Endpoint,
+	add func(string, bool, string, ...interface{})) {
+	known := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		known[e.Server] = true
+	}
+	for _, e := range endpoints {
+		vhosts := c.Servers[e.Server].VHosts
+		hosts := make([]string, 0, len(vhosts))
+		for host := range vhosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			field := "servers." + e.Server + ".vhosts." + host
+			v := vhosts[host]
+			if (v.TLS.CertFile == "") != (v.TLS.KeyFile == "") {
+				add(field+".tls", false, "certfile and keyfile go together")
+			}
+			for _, group := range v.Routes {
+				if !known[group] {
+					add(field+".routes", false, "unknown endpoint %q", group)
+				}
+			}
+		}
+	}
+}
+
+// checkALPN checks the protocols negotiated by the endpoints, which only
+// apply to the ones served over TLS
+func (c *Common) checkALPN(endpoints []Endpoint, withTLS bool,
+	add func(string, bool, string, ...interface{})) {
+	checked := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		s, ok := c.Servers[e.Server]
+		if !ok || len(s.ALPN) == 0 || checked[e.Server] {
+			continue
+		}
+		checked[e.Server] = true
+		field := "servers." + e.Server
+		if err := s.Validate(); err != nil {
+			/* the error starts with the field */
+			sub, msg, _ := strings.Cut(err.Error(), ": ")
+			add(field+"."+sub, false, "%s", msg)
+			continue
+		}
+		if !withTLS || s.Protocol == ProtocolH2C ||
+			e.Server == adminEndpointName {
+			add(field+".alpn", true, "ignored without TLS")
+		}
+	}
+}
+
+// checkPeers checks the message versions sent to the peers, 1 for the bare
+// messages and 2 for the envelope, and the protocols used towards them
+func (c *Common) checkPeers(add func(string, bool, string, ...interface{})) {
+	peers := make([]string, 0, len(c.Peers))
+	for peer := range c.Peers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		if v := c.Peers[peer].MessageVersion; v < 0 || v > 2 {
+			add("peers."+peer+".messageversion", false,
+				"unknown message version %d, expected 1 or 2", v)
+		}
+		switch p := c.Peers[peer].Protocol; p {
+		case ProtocolDefault, ProtocolH2C, ProtocolH2, ProtocolHTTP11:
+		default:
+			add("peers."+peer+".protocol", false,
+				"unknown protocol %q, expected %q, %q or %q", p, ProtocolH2C,
+				ProtocolH2, ProtocolHTTP11)
+		}
+	}
+}
+
+// checkACL checks the access control rules, whose clients match only the
+// verified certificates of mutual TLS or of the connection limits rejecting
+// the expired ones
+func (c *Common) checkACL(withTLS bool,
+	add func(string, bool, string, ...interface{})) {
+	if !c.ACL.Enabled {
+		return
+	}
+	if err := c.ACL.Validate(); err != nil {
+		/* the error starts with the field */
+		field, msg, ok := strings.Cut(err.Error(), ": ")
+		if !ok {
+			field, msg = "acl", err.Error()
+		}
+		add(field, false, "%s", msg)
+		return
+	}
+	if withTLS && (c.TLS.MutualTLS ||
+		c.ConnLimits.Enabled && c.ConnLimits.RejectExpired) {
+		return
+	}
+	for i, r := range c.ACL.Rules {
+		if len(r.Clients) > 0 {
+			add(fmt.Sprintf("acl.rules[%d].clients", i), true, "no client "+
+				"certificate without mutual TLS or connlimits.rejectexpired, "+
+				"the rule never matches")
+		}
+	}
+}
+
+// checkClient checks the client timeouts: an attempt cannot last longer
+// than the overall timeout
+func checkClient(field string, t ClientTimeouts,
+	add func(string, bool, string, ...interface{})) {
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"dial", t.Dial},
+		{"tlshandshake", t.TLSHandshake},
+		{"responseheader", t.ResponseHeader},
+		{"overall", t.Overall},
+	} {
+		if v.value < 0 {
+			add(field+"."+v.name, false, "negative timeout %d", v.value)
+		}
+	}
+	if t.Overall <= 0 {
+		return
+	}
+	if t.ResponseHeader > t.Overall {
+		add(field+".responseheader", false,
+			"%d ms longer than the overall timeout (%d ms)", t.ResponseHeader,
+			t.Overall)
+	}
+	if t.Dial+t.TLSHandshake > t.Overall {
+		add(field+".dial", true, "dial and TLS handshake (%d ms) longer "+
+			"than the overall timeout (%d ms)", t.Dial+t.TLSHandshake,
+			t.Overall)
+	}
+}
@@ -0,0 +1,283 @@
+package config
+
+import "fmt"
+
+// Protocols selectable per server endpoint or peer
+const (
+	// ProtocolDefault follows the -version flag
+	ProtocolDefault string = ""
+	// ProtocolH2C is HTTP/2 over cleartext TCP
+	ProtocolH2C string = "h2c"
+	// ProtocolH2 is HTTP/2 over TLS whatever the -version flag, for the
+	// peers only
+	ProtocolH2 string = "h2"
+	// ProtocolHTTP11 is HTTP/1.1, over TLS with -version 2, for the peers
+	// only
+	ProtocolHTTP11 string = "http/1.1"
+)
+
+// Application protocols the TLS server endpoints negotiate by ALPN
+const (
+	ALPNH2     string = "h2"
+	ALPNHTTP11 string = "http/1.1"
+)
+
+// Common contains the configuration sections shared by all the NFs. It is
+// embedded in the configuration structure of each NF
+type Common struct {
+	TLS TLSConfig `json:"tls"`
+	NRF NRFConfig `json:"nrf"`
+	Log LogConfig `json:"log"`
+	// Metrics contains the Prometheus endpoint settings
+	Metrics MetricsConfig `json:"metrics"`
+	// Tracing contains the OTLP tracing settings
+	Tracing TracingConfig `json:"tracing"`
+	// Retry contains the retry policy of the outbound requests
+	Retry RetryConfig `json:"retry"`
+	// Hedging contains the hedging of the outbound requests
+	Hedging HedgingConfig `json:"hedging"`
+	// HTTP2 contains the HTTP/2 settings of the servers
+	HTTP2 HTTP2Config `json:"http2"`
+	// Timeouts contains the server and client timeouts
+	Timeouts TimeoutsConfig `json:"timeouts"`
+	// ConnPool contains the connection pool limits of the peers
+	ConnPool ConnPoolConfig `json:"connpool"`
+	// Proxy contains the proxy settings of the outbound requests
+	Proxy ProxyConfig `json:"proxy"`
+	// RateLimit contains the inbound and outbound request rate limits
+	RateLimit RateLimitConfig `json:"ratelimit"`
+	// Limits contains the request size limits of the servers
+	Limits LimitsConfig `json:"limits"`
+	// Admission contains the concurrency limits of the servers
+	Admission AdmissionConfig `json:"admission"`
+	// Overload contains the SBI overload control
+	Overload OverloadConfig `json:"overload"`
+	// ConnLimits contains the connection level protections of the servers
+	ConnLimits ConnLimitsConfig `json:"connlimits"`
+	// Admin contains the admin listener settings
+	Admin AdminConfig `json:"admin"`
+	// Breaker contains the circuit breaker settings of the peers
+	Breaker BreakerConfig `json:"circuitbreaker"`
+	// ACL contains the access control of the routes by client certificate
+	ACL ACLConfig `json:"acl"`
+	// Audit contains the audit log of the security relevant events
+	Audit AuditConfig `json:"audit"`
+	// Callbacks contains the checks of the callback URIs of the clients
+	Callbacks CallbacksConfig `json:"callbacks"`
+	// Signatures contains the signatures of the callbacks sent and received
+	Signatures SignaturesConfig `json:"signatures"`
+	// JWT contains the validation settings of the inbound access tokens
+	JWT JWTConfig `json:"jwt"`
+	// OAuth2 contains the access token settings of the outbound requests
+	OAuth2 OAuth2Config `json:"oauth2"`
+	// OpenAPI contains the validation settings of the inbound requests
+	OpenAPI OpenAPIConfig `json:"openapi"`
+	// Subscriptions contains the event subscription settings
+	Subscriptions SubscriptionConfig `json:"subscriptions"`
+	// Events contains the Server-Sent Events stream settings
+	Events EventsConfig `json:"events"`
+	// Tenants are the NF instances served next to the default one, under
+	// their path prefix or host names
+	Tenants Tenants `json:"tenants"`
+	// Versioning serves the routes under their API name and version
+	Versioning VersioningConfig `json:"versioning"`
+	// Outbox contains the queue of the requests sent again once their
+	// unreachable peer recovers
+	Outbox OutboxConfig `json:"outbox"`
+	// MQ contains the publication of the events and callbacks to a message
+	// queue
+	MQ MQConfig `json:"mq"`
+	// GRPC contains the gRPC service settings
+	GRPC GRPCConfig `json:"grpc"`
+	// Store contains the settings of the store of the NF state
+	Store StoreConfig `json:"store"`
+	// Replication contains the replication of the store between the
+	// replicas of the NF
+	Replication ReplicationConfig `json:"replication"`
+	// Idempotency contains the replay of the retried requests
+	Idempotency IdempotencyConfig `json:"idempotency"`
+	// Dedup contains the deduplication of the callbacks received
+	Dedup DedupConfig `json:"dedup"`
+	// Compression contains the compression of the request and response
+	// bodies
+	Compression CompressionConfig `json:"compression"`
+	// Cache contains the ETags of the servers and the response cache of
+	// the client
+	Cache CacheConfig `json:"cache"`
+	// Jobs contains the asynchronous mode of the long operations
+	Jobs JobsConfig `json:"jobs"`
+	// Locations contains the location resources and their history
+	Locations LocationsConfig `json:"locations"`
+	// Routing contains the routing table of the proxy mode
+	Routing RoutingConfig `json:"routing"`
+	// SCP contains the indirect communication settings of the outbound
+	// requests
+	SCP SCPConfig `json:"scp"`
+	// Faults contains the faults injected for resilience testing
+	Faults FaultsConfig `json:"faults"`
+	// Maintenance takes routes out of service before an upgrade
+	Maintenance MaintenanceConfig `json:"maintenance"`
+	// SLO tracks the latency of the routes against their objectives
+	SLO SLOConfig `json:"slo"`
+	// Record contains the recording of the exchanges replayed for
+	// regression testing
+	Record RecordConfig `json:"record"`
+	// Exchanges contains the history of the exchanges queried on the
+	// events path
+	Exchanges ExchangesConfig `json:"exchanges"`
+	// Interop contains the dry run of the outbound requests and the echo
+	// endpoint
+	Interop InteropConfig `json:"interop"`
+	// Hooks contains the transformation hooks of the message payloads
+	Hooks HooksConfig `json:"hooks"`
+	// Mock contains the canned responses of the mock peer mode
+	Mock MockConfig `json:"mock"`
+	// Replies contains the response bodies of the NF handlers
+	Replies RepliesConfig `json:"replies"`
+	// Pod contains the integration of the NF running in a Kubernetes pod
+	Pod PodConfig `json:"pod"`
+	// Secrets contains the providers of the secrets referenced from the
+	// configuration
+	Secrets SecretsConfig `json:"secrets"`
+	// Servers contains the settings per server endpoint name (e.g. "API")
+	Servers map[string]ServerConfig `json:"servers"`
+	// Peers contains the settings per peer NF host:port
+	Peers map[string]PeerConfig `json:"peers"`
+}
+
+// ServerConfig contains the settings of a server endpoint
+type ServerConfig struct {
+	// Protocol served by the endpoint: ProtocolDefault or ProtocolH2C
+	Protocol string `json:"protocol"`
+	// SocketMode is the octal permissions (e.g. "0660") of the socket of a
+	// unix:// endpoint
+	SocketMode string `json:"socketmode"`
+	// Listen adds listen addresses to the endpoint, e.g. "[::]:8443" next
+	// to "0.0.0.0:8443"
+	Listen []ListenConfig `json:"listen"`
+	// VHosts are the virtual hosts of the endpoint by host name
+	VHosts map[string]VHostConfig `json:"vhosts"`
+	// ALPN lists the protocols the endpoint negotiates over TLS, by order
+	// of preference: ALPNH2 and ALPNHTTP11, both when empty. The clients
+	// offering none of them are rejected, as well as the ones without ALPN
+	// unless ALPNHTTP11 is listed
+	ALPN []string `json:"alpn"`
+}
+
+// Validate checks the protocols negotiated by the endpoint
+func (s ServerConfig) Validate() error {
+	seen := make(map[string]bool, len(s.ALPN))
+	for _, p := range s.ALPN {
+		switch {
+		case p != ALPNH2 && p != ALPNHTTP11:
+			return fmt.Errorf("alpn: unknown protocol %q, expected %q or %q",
+				p, ALPNH2, ALPNHTTP11)
+		case seen[p]:
+			return fmt.Errorf("alpn: %q listed twice", p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+// VHostConfig is a virtual host of a server endpoint, recognized by the
+// TLS server name of its requests, or their Host header without TLS
+type VHostConfig struct {
+	// TLS holds the certificate and key served to the host, the ones of
+	// the endpoint when empty
+	TLS TLSFiles `json:"tls"`
+	// Routes lists the route groups served to the host: the names of the
+	// server endpoints whose routes it gets, e.g. ["API", "NF"], the ones
+	// of the endpoint when empty
+	Routes []string `json:"routes"`
+}
+
+// CertFiles returns the certificate, key and CA bundle files of the given
+// server endpoints, including the ones of their additional listen addresses,
+// and of the client, without duplicates
+func (c *Common) CertFiles(servers ...string) []string {
+	files := c.TLS.Files(servers...)
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		seen[file] = true
+	}
+	for _, name := range servers {
+		for _, l := range c.Servers[name].Listen {
+			f := c.TLS.ServerFiles(name).Override(l.TLS)
+			if c.TLS.ACME.Serves(name) {
+				f.CertFile, f.KeyFile = "", ""
+			}
+			for _, file := range []string{f.CertFile, f.KeyFile, f.CAFile} {
+				if file != "" && !seen[file] {
+					seen[file] = true
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	hostFiles := make([]TLSFiles, 0, len(c.Tenants))
+	for _, t := range c.Tenants {
+		hostFiles = append(hostFiles, t.TLS)
+	}
+	for _, name := range servers {
+		for _, v := range c.Servers[name].VHosts {
+			hostFiles = append(hostFiles, v.TLS)
+		}
+	}
+	for _, f := range hostFiles {
+		for _, file := range []string{f.CertFile, f.KeyFile} {
+			if file != "" && !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// ListenConfig is an additional listen address of a server endpoint
+type ListenConfig struct {
+	// Address is a host:port, IPv6 hosts in brackets, or a unix:// path
+	Address string `json:"address"`
+	// TLS overrides the certificate files of the endpoint on this address
+	TLS TLSFiles `json:"tls"`
+}
+
+// PeerConfig contains the settings used to reach a peer NF
+type PeerConfig struct {
+	// Protocol used towards the peer: ProtocolDefault, ProtocolH2C,
+	// ProtocolH2 or ProtocolHTTP11
+	Protocol string `json:"protocol"`
+	// NfType of the peer, the target of its access tokens
+	NfType string `json:"nftype"`
+	// Scope of the access tokens sent to the peer, the service names
+	// separated by spaces. No token is sent when empty
+	Scope string `json:"scope"`
+	// Timeouts overrides the client timeouts set to a non zero value
+	Timeouts ClientTimeouts `json:"timeouts"`
+	// ConnPool overrides the connection pool limits set to a non zero
+	// value, e.g. more idle connections to a busy peer
+	ConnPool ConnPoolConfig `json:"connpool"`
+	// Socket is the path of the unix domain socket the peer is reached
+	// on, e.g. a sidecar proxy. The peer is dialed over TCP when empty
+	Socket string `json:"socket"`
+	// Proxy overrides the proxy URL of the peer, ProxyDirect to reach it
+	// without proxy. The proxy is not used with a socket
+	Proxy string `json:"proxy"`
+	// ContentType is the media type of the request bodies sent to the
+	// peer, e.g. application/cbor. JSON when empty
+	ContentType string `json:"contenttype"`
+	// MessageVersion is the version of the NF messages sent to the peer:
+	// 1 for the bare messages of the older peers, 2 for the versioned
+	// envelope. The newest version when 0
+	MessageVersion int `json:"messageversion"`
+	// ContentEncoding compresses the request bodies sent to the peer of
+	// at least the compression MinSize, e.g. gzip or br. They are sent
+	// as they are when empty
+	ContentEncoding string `json:"contentencoding"`
+	// API is the interface of the peer: APIDefault or APIGRPC
+	API string `json:"api"`
+	// GRPCAddress is the host:port of the dedicated gRPC server of the
+	// peer. The gRPC requests are sent to the peer host:port when empty
+	GRPCAddress string `json:"grpcaddress"`
+}
@@ -0,0 +1,19 @@
+package config
+
+// CompressionConfig contains the compression of the message bodies: the
+// servers decode the request bodies of a known Content-Encoding and
+// compress the responses in the encoding negotiated with Accept-Encoding,
+// the client compresses the request bodies sent to the peers with a
+// ContentEncoding
+type CompressionConfig struct {
+	// Enabled compresses the responses of the servers. The compressed
+	// request bodies are decoded whether it is set or not
+	Enabled bool `json:"enabled"`
+	// Encodings the responses are compressed in, in order of preference
+	// among the ones the request accepts with the same quality, "br" then
+	// "gzip" by default
+	Encodings []string `json:"encodings"`
+	// MinSize in bytes below which the bodies are not compressed, 1024 by
+	// default
+	MinSize int `json:"minsize"`
+}
@@ -0,0 +1,22 @@
+// Package config loads the NF configuration files
+package config
+
+// Validator is implemented by configuration structures that are able to
+// check themselves once loaded
+type Validator interface {
+	Validate() error
+}
+
+// Load reads a file located at configPath, in JSON, YAML or TOML according
+// to its extension, and unmarshals it to the config structure. The
+// structure is validated when it implements Validator
+func Load(configPath string, cfg interface{}) error {
+	return (&Loader{Path: configPath}).Load(cfg)
+}
+
+// LoadJSON is Load, which reads the JSON files as before.
+//
+// Deprecated: use Load
+func LoadJSON(configPath string, cfg interface{}) error {
+	return Load(configPath, cfg)
+}
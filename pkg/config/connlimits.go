@@ -0,0 +1,43 @@
+package config
+
+import "fmt"
+
+// ConnLimitsConfig contains the protections of the NF servers against the
+// connection floods, applied as the connections are accepted, before any
+// request is read. They are read at startup only
+type ConnLimitsConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxPerIP limits the connections open from each source IP, unlimited
+	// when 0
+	MaxPerIP int `json:"maxperip"`
+	// Accept limits the rate of the connections accepted from each source
+	// IP
+	Accept RateLimit `json:"accept"`
+	// Handshakes limits the rate of the TLS handshakes of each server, the
+	// connections over it are closed before the handshake
+	Handshakes RateLimit `json:"handshakes"`
+	// HandshakeTimeout in milliseconds bounds the TLS handshakes, 10000
+	// when 0
+	HandshakeTimeout int `json:"handshaketimeout"`
+	// RejectExpired closes the connections of the clients presenting a
+	// certificate expired or not yet valid. Without mutual TLS the servers
+	// then request the client certificates, still optional but verified
+	// against the root CA
+	RejectExpired bool `json:"rejectexpired"`
+}
+
+// Validate checks the limits are not negative
+func (c *ConnLimitsConfig) Validate() error {
+	switch {
+	case c.MaxPerIP < 0:
+		return fmt.Errorf("connlimits.maxperip: %d is negative", c.MaxPerIP)
+	case c.Accept.Rate < 0 || c.Accept.Burst < 0:
+		return fmt.Errorf("connlimits.accept: negative rate or burst")
+	case c.Handshakes.Rate < 0 || c.Handshakes.Burst < 0:
+		return fmt.Errorf("connlimits.handshakes: negative rate or burst")
+	case c.HandshakeTimeout < 0:
+		return fmt.Errorf("connlimits.handshaketimeout: %d is negative",
+			c.HandshakeTimeout)
+	}
+	return nil
+}
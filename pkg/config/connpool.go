@@ -0,0 +1,58 @@
+package config
+
+// ConnPoolConfig contains the connection pool limits of the outbound
+// client, applied to each peer NF host:port
+type ConnPoolConfig struct {
+	// MaxIdleConns is the number of idle HTTP/1.1 connections kept per peer
+	MaxIdleConns int `json:"maxidleconns"`
+	// MaxConns limits the HTTP/1.1 connections per peer, unlimited when 0
+	MaxConns int `json:"maxconns"`
+	// IdleTimeout is the time in milliseconds an idle connection is kept
+	// before it is closed
+	IdleTimeout int `json:"idletimeout"`
+	// ReadIdleTimeout is the time in milliseconds without a frame received
+	// after which an HTTP/2 connection is checked with a PING, 15000 when
+	// 0, never when negative
+	ReadIdleTimeout int `json:"readidletimeout"`
+	// PingTimeout is the time in milliseconds the answer to the PING is
+	// waited for before the connection is closed as dead, 5000 when 0
+	PingTimeout int `json:"pingtimeout"`
+	// StrictMaxConcurrentStreams makes the requests over the concurrent
+	// stream limit of an HTTP/2 peer wait for a stream to close instead of
+	// opening another connection
+	StrictMaxConcurrentStreams bool `json:"strictmaxconcurrentstreams"`
+	// KeepAlive is the interval in milliseconds of the TCP keep-alive
+	// probes of the connections, 15000 when 0, none when negative
+	KeepAlive int `json:"keepalive"`
+	// ReapAfter is the time in milliseconds after which the transport of a
+	// peer no request was sent to is dropped with its connections, 600000
+	// when 0, never when negative. It is not overridden per peer
+	ReapAfter int `json:"reapafter"`
+}
+
+// Merge returns the pool limits with the non zero values of o replacing
+// the ones of p
+func (p ConnPoolConfig) Merge(o ConnPoolConfig) ConnPoolConfig {
+	if o.MaxIdleConns != 0 {
+		p.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxConns != 0 {
+		p.MaxConns = o.MaxConns
+	}
+	if o.IdleTimeout != 0 {
+		p.IdleTimeout = o.IdleTimeout
+	}
+	if o.ReadIdleTimeout != 0 {
+		p.ReadIdleTimeout = o.ReadIdleTimeout
+	}
+	if o.PingTimeout != 0 {
+		p.PingTimeout = o.PingTimeout
+	}
+	if o.StrictMaxConcurrentStreams {
+		p.StrictMaxConcurrentStreams = true
+	}
+	if o.KeepAlive != 0 {
+		p.KeepAlive = o.KeepAlive
+	}
+	return p
+}
@@ -0,0 +1,39 @@
+package config
+
+import "fmt"
+
+// Deduplication keys of the callbacks
+const (
+	// DedupCorrelationID takes the callbacks with the same correlation ID
+	// as duplicates
+	DedupCorrelationID string = "correlationid"
+	// DedupContent takes the callbacks with the same content as duplicates
+	DedupContent string = "content"
+)
+
+// DedupConfig contains the deduplication of the callbacks received by NF1,
+// a callback received again within the window being acknowledged without
+// being handed to the request waiting for it
+type DedupConfig struct {
+	Enabled bool `json:"enabled"`
+	// Key is DedupCorrelationID or DedupContent, DedupCorrelationID when
+	// empty
+	Key string `json:"key"`
+	// Window in milliseconds during which a callback received again is a
+	// duplicate, 60000 when 0
+	Window int `json:"window"`
+}
+
+// Validate checks the key and the window
+func (d *DedupConfig) Validate() error {
+	switch d.Key {
+	case "", DedupCorrelationID, DedupContent:
+	default:
+		return fmt.Errorf("dedup.key: unknown key %q, expected %s or %s",
+			d.Key, DedupCorrelationID, DedupContent)
+	}
+	if d.Window < 0 {
+		return fmt.Errorf("dedup.window: negative window")
+	}
+	return nil
+}
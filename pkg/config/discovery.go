@@ -0,0 +1,17 @@
+package config
+
+// DiscoveryConfig contains the settings used to discover the peer NF
+// through the NRF configured in NRFConfig
+type DiscoveryConfig struct {
+	// NF type of the peer. Discovery is disabled when empty
+	TargetNfType string `json:"targetnftype"`
+	// Service name searched on the peer
+	ServiceName string `json:"servicename"`
+	// Maximum time in seconds the result is cached, the validity period
+	// returned by the NRF is used when shorter
+	CacheTTL int `json:"cachettl"`
+	// LoadBalancing is the policy choosing among the instances found:
+	// BalanceRoundRobin, BalanceLeastRequests or BalanceWeighted.
+	// BalanceRoundRobin when empty
+	LoadBalancing string `json:"loadbalancing"`
+}
@@ -0,0 +1,22 @@
+package config
+
+// DNSConfig contains the resolution of the API root of a peer into the
+// addresses of its instances: the A and AAAA records of its host, e.g. a
+// Kubernetes headless service, or the SRV records of a host named
+// _service._proto.name
+type DNSConfig struct {
+	// Enabled resolves the API root in DNS
+	Enabled bool `json:"enabled"`
+	// TTL is the time in seconds the addresses are used before they are
+	// resolved again, 30 by default. The TTL of the records is not
+	// available to the resolver
+	TTL int `json:"ttl"`
+	// Server is the host:port of the DNS server queried, the one of the
+	// system when empty
+	Server string `json:"server"`
+	// LoadBalancing is the policy choosing among the addresses:
+	// BalanceRoundRobin, BalanceLeastRequests or BalanceWeighted, which
+	// follows the priority and weight of the SRV records.
+	// BalanceRoundRobin when empty
+	LoadBalancing string `json:"loadbalancing"`
+}
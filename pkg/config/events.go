@@ -0,0 +1,25 @@
+package config
+
+// EventsConfig contains the settings of the Server-Sent Events stream of
+// the NF events
+type EventsConfig struct {
+	// Enabled serves the stream on the NF servers
+	Enabled bool `json:"enabled"`
+	// Path of the stream below the API root prefix, /events by default
+	Path string `json:"path"`
+	// History is the number of past events kept to be replayed to the
+	// subscribers reconnecting with Last-Event-ID
+	History int `json:"history"`
+	// KeepAlive is the interval in milliseconds of the comments keeping
+	// the idle streams open
+	KeepAlive int `json:"keepalive"`
+	// Retry is the reconnection delay in milliseconds advertised to the
+	// subscribers, none when 0
+	Retry int `json:"retry"`
+	// BufferSize is the number of events waiting to be written to a
+	// subscriber above which its stream is closed, to be resumed from
+	// the history
+	BufferSize int `json:"buffersize"`
+	// MaxSubscribers limits the open streams, unlimited when 0
+	MaxSubscribers int `json:"maxsubscribers"`
+}
@@ -0,0 +1,29 @@
+package config
+
+import "errors"
+
+// ExchangesConfig contains the history of the exchanges of the NF, the
+// requests served and sent with their outcome, queried with GET on the
+// events path (/events by default)
+type ExchangesConfig struct {
+	Enabled bool `json:"enabled"`
+	// Size is the number of exchanges kept in memory, 1000 when 0
+	Size int `json:"size"`
+	// Persist keeps the exchanges in the NF store instead, shared by the
+	// instances of a shared store and kept across the restarts
+	Persist bool `json:"persist"`
+	// Retention is the time in seconds a persisted exchange is kept, a
+	// day when 0
+	Retention int `json:"retention"`
+}
+
+// Validate checks the sizes
+func (e *ExchangesConfig) Validate() error {
+	if e.Size < 0 {
+		return errors.New("exchanges.size: negative size")
+	}
+	if e.Retention < 0 {
+		return errors.New("exchanges.retention: negative retention")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// APIRoots is a list of API roots in order of priority, the first one
+// being the primary. It is read from a JSON string for a single API root or
+// from an array
+type APIRoots []string
+
+// UnmarshalJSON accepts a string or an array of strings
+func (r *APIRoots) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "\"") {
+		var root string
+		if err := json.Unmarshal(data, &root); err != nil {
+			return err
+		}
+		*r = APIRoots{root}
+		return nil
+	}
+	var roots []string
+	if err := json.Unmarshal(data, &roots); err != nil {
+		return err
+	}
+	*r = roots
+	return nil
+}
+
+// JSONSchema accepts a string or an array of strings
+func (APIRoots) JSONSchema() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{"oneOf": []interface{}{str,
+		map[string]interface{}{"type": "array", "items": str}}}
+}
+
+// Primary returns the API root of highest priority, empty when there is
+// none
+func (r APIRoots) Primary() string {
+	if len(r) == 0 {
+		return ""
+	}
+	return r[0]
+}
+
+// FailoverConfig contains the failover across the API roots of a peer: the
+// requests go to the primary, or to the next API root when it fails to
+// connect or answers 5xx, which keeps them until the primary is healthy
+// again
+type FailoverConfig struct {
+	// HealthCheck checks the API roots, the unhealthy ones get no request
+	// while a healthy one is left
+	HealthCheck UpstreamCheckConfig `json:"healthcheck"`
+}
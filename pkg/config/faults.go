@@ -0,0 +1,62 @@
+package config
+
+import "fmt"
+
+// FaultsConfig contains the faults injected in the requests served and
+// sent by the NF, to test the resilience of its peers and of itself. The
+// admin API changes them at runtime on /admin/faults
+type FaultsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Server lists the faults of the requests served, the first rule
+	// matching a request applies
+	Server []FaultRule `json:"server"`
+	// Client lists the faults of the requests sent to the peers
+	Client []FaultRule `json:"client"`
+}
+
+// FaultRule injects faults in a share of the requests it matches: a delay,
+// then an error status, a connection reset or a truncated body
+type FaultRule struct {
+	// Server is the name of the server endpoint (e.g. "API") of the
+	// requests served matched, any when empty
+	Server string `json:"server"`
+	// Peer is the host:port of the peer of the requests sent matched, any
+	// when empty
+	Peer string `json:"peer"`
+	// Path is the prefix of the paths matched, any when empty
+	Path string `json:"path"`
+	// Percent is the share of the matched requests faulted, 0 to 100
+	Percent float64 `json:"percent"`
+	// Delay in milliseconds, plus up to DelayJitter, before the request is
+	// handled or sent
+	Delay       int `json:"delay"`
+	DelayJitter int `json:"delayjitter"`
+	// Status answers the request with this status, e.g. 503, instead of
+	// handling or sending it
+	Status int `json:"status"`
+	// Reset aborts the connection, or the HTTP/2 stream, of the request
+	Reset bool `json:"reset"`
+	// Truncate cuts the response body after this number of bytes
+	Truncate int `json:"truncate"`
+}
+
+// Validate checks the rules
+func (f *FaultsConfig) Validate() error {
+	for side, rules := range map[string][]FaultRule{"server": f.Server,
+		"client": f.Client} {
+		for i, r := range rules {
+			switch {
+			case r.Percent < 0 || r.Percent > 100:
+				return fmt.Errorf("faults.%s[%d]: percent %v out of 0-100",
+					side, i, r.Percent)
+			case r.Status != 0 && (r.Status < 400 || r.Status > 599):
+				return fmt.Errorf("faults.%s[%d]: status %d is not an "+
+					"error status", side, i, r.Status)
+			case r.Delay < 0 || r.DelayJitter < 0 || r.Truncate < 0:
+				return fmt.Errorf("faults.%s[%d]: negative delay or "+
+					"truncate", side, i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decoders decode the configuration files per extension into the generic
+// values of their JSON form. The files of the other extensions are JSON
+var decoders = map[string]func(data []byte) (interface{}, error){
+	".yaml": decodeYAML,
+	".yml":  decodeYAML,
+	".toml": decodeTOML,
+}
+
+// ReadFile reads a configuration file in the format of its extension,
+// JSON, YAML (.yaml or .yml) or TOML (.toml), and returns it as JSON. The
+// members are named as in JSON in every format, so the structures are
+// decoded from all of them with their json tags
+func ReadFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	decode, ok := decoders[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return data, nil
+	}
+	v, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+// ReadSection reads a configuration file like ReadFile and returns the
+// member named section of its top level object, or the whole file when
+// section is empty
+func ReadSection(path, section string) ([]byte, error) {
+	data, err := ReadFile(path)
+	if err != nil || section == "" {
+		return data, err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	data, ok := sections[section]
+	if !ok {
+		return nil, fmt.Errorf("%s: no %q section", path, section)
+	}
+	return data, nil
+}
+
+func decodeYAML(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return stringKeys(v), nil
+}
+
+func decodeTOML(data []byte) (interface{}, error) {
+	var v map[string]interface{}
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// stringKeys converts the YAML mappings whose keys are not all strings,
+// e.g. a port number, into the string keyed maps JSON encodes
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = stringKeys(item)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,20 @@
+package config
+
+// APIs selectable per peer
+const (
+	// APIDefault reaches the peer with the REST operations
+	APIDefault string = ""
+	// APIGRPC reaches the peer with the gRPC service
+	APIGRPC string = "grpc"
+)
+
+// GRPCConfig contains the settings of the gRPC service offered along with
+// the REST operations
+type GRPCConfig struct {
+	Enabled bool `json:"enabled"`
+	// Address is the host:port of a dedicated "GRPC" server. The service
+	// shares the listener of the NF server when empty
+	Address string `json:"address"`
+	// MaxMessageSize bounds the messages received, 4 MiB when 0
+	MaxMessageSize int `json:"maxmessagesize"`
+}
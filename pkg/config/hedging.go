@@ -0,0 +1,19 @@
+package config
+
+// HedgingConfig contains the hedging of the outbound requests: when the
+// response of a peer is late, a copy of the request is sent to another
+// instance of the peer and the first response is used
+type HedgingConfig struct {
+	// Enabled sends the hedged requests
+	Enabled bool `json:"enabled"`
+	// Percentile of the recent response times of the peer after which the
+	// copy is sent, 95 by default
+	Percentile float64 `json:"percentile"`
+	// Delay in milliseconds after which the copy is sent until enough
+	// response times of the peer are known, and the minimum delay, 100 by
+	// default
+	Delay int `json:"delay"`
+	// Methods are the request methods hedged, GET by default. The copy of
+	// a request that is not idempotent may be processed as well
+	Methods []string `json:"methods"`
+}
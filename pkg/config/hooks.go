@@ -0,0 +1,30 @@
+package config
+
+// HooksConfig contains the payload transformation hooks applied to the NF
+// messages, registered by name by the code of the deployment or by Go
+// plugins
+type HooksConfig struct {
+	// Plugins are the paths of the Go plugins loaded at startup, which
+	// register their hooks from their init functions
+	Plugins []string `json:"plugins"`
+	// PreSend are the hooks applied in order to the payloads sent: the
+	// requests of the client and the responses of the servers
+	PreSend []HookConfig `json:"presend"`
+	// PostReceive are the hooks applied in order to the payloads
+	// received: the responses to the client and the requests to the
+	// servers
+	PostReceive []HookConfig `json:"postreceive"`
+}
+
+// HookConfig applies a registered hook
+type HookConfig struct {
+	// Name the hook is registered with
+	Name string `json:"name"`
+	// Peers restricts the hook to the messages the client exchanges with
+	// these peer host:port
+	Peers []string `json:"peers"`
+	// Routes restricts the hook to the messages of these route patterns of
+	// the servers. The hook applies to the client and the servers when
+	// neither Peers nor Routes is set, else only to the side restricted
+	Routes []string `json:"routes"`
+}
@@ -0,0 +1,36 @@
+package config
+
+// HTTP2Config contains the HTTP/2 settings of the servers, for both the TLS
+// and the h2c endpoints. 0 keeps the golang.org/x/net/http2 default
+type HTTP2Config struct {
+	// MaxConcurrentStreams is the number of streams a client may open at
+	// once on a connection
+	MaxConcurrentStreams uint32 `json:"maxconcurrentstreams"`
+	// MaxReadFrameSize is the largest frame the server reads, between 16384
+	// and 16777215 bytes
+	MaxReadFrameSize uint32 `json:"maxreadframesize"`
+	// IdleTimeout is the time in milliseconds after which an idle connection
+	// is closed
+	IdleTimeout int `json:"idletimeout"`
+	// MaxUploadBufferPerConnection is the initial flow control window of the
+	// connections in bytes, at least 65535
+	MaxUploadBufferPerConnection int32 `json:"maxuploadbufferperconnection"`
+	// MaxUploadBufferPerStream is the initial flow control window of the
+	// streams in bytes, at least 65535
+	MaxUploadBufferPerStream int32 `json:"maxuploadbufferperstream"`
+	// Push contains the resources pushed with the responses
+	Push PushConfig `json:"push"`
+}
+
+// PushConfig contains the resources the servers push with the responses,
+// e.g. the NF status with the location of NF2
+type PushConfig struct {
+	// Enabled pushes the resources of the routes to the HTTP/2 clients
+	// accepting pushes, and announces them to the others as Link preload
+	// headers
+	Enabled bool `json:"enabled"`
+	// Routes maps a route pattern to the paths of the resources pushed
+	// with the responses to its GET requests, e.g. "/nf2loc": ["/readyz"].
+	// The paths are absolute, the API root prefix included
+	Routes map[string][]string `json:"routes"`
+}
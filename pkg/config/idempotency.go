@@ -0,0 +1,15 @@
+package config
+
+// IdempotencyConfig contains the replay of the responses to the retried
+// requests carrying an Idempotency-Key header
+type IdempotencyConfig struct {
+	Enabled bool `json:"enabled"`
+	// Routes lists the route patterns accepting the header, e.g. "/nf2loc"
+	Routes []string `json:"routes"`
+	// Window in milliseconds during which a retry gets the response of the
+	// first request
+	Window int `json:"window"`
+	// MaxBodySize bounds the response bodies kept, the larger responses are
+	// not replayed
+	MaxBodySize int `json:"maxbodysize"`
+}
@@ -0,0 +1,18 @@
+package config
+
+// InteropConfig contains the aids to the interface bring-up with new peer
+// NF implementations
+type InteropConfig struct {
+	// DryRun logs the requests to the peers, checked against the OpenAPI
+	// specification, instead of sending them
+	DryRun bool `json:"dryrun"`
+	// Peers restricts the dry run to these peer host:port, all the peers
+	// when empty
+	Peers []string `json:"peers"`
+	// Echo serves the echo endpoint on the NF servers, which answers with
+	// the request it received
+	Echo bool `json:"echo"`
+	// EchoPath is the path of the echo endpoint under the API root prefix,
+	// /echo when empty
+	EchoPath string `json:"echopath"`
+}
@@ -0,0 +1,21 @@
+package config
+
+// JobsConfig contains the settings of the asynchronous mode of the long
+// operations, answered 202 Accepted with a job resource polled for the
+// result
+type JobsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Path of the jobs resource, /jobs by default
+	Path string `json:"path"`
+	// Timeout in milliseconds of a job, after which it fails
+	Timeout int `json:"timeout"`
+	// Retention in milliseconds of a finished job, after which its result
+	// is forgotten
+	Retention int `json:"retention"`
+	// MaxRunning is the number of jobs run at once above which the
+	// asynchronous requests are refused, unlimited when 0
+	MaxRunning int `json:"maxrunning"`
+	// CallbackAttempts is the number of times the finished job is sent to
+	// the callback URI of the client before giving up
+	CallbackAttempts int `json:"callbackattempts"`
+}
@@ -0,0 +1,21 @@
+package config
+
+// JWTConfig contains the validation settings of the Bearer access tokens
+// received by the NF servers
+type JWTConfig struct {
+	// Enabled makes the routes of the servers require a valid token. The
+	// probes and metrics endpoints stay open
+	Enabled bool `json:"enabled"`
+	// JWKSURI is the URI of the JSON Web Key Set verifying the signatures
+	JWKSURI string `json:"jwksuri"`
+	// PublicKeyFile is a PEM public key or certificate verifying the
+	// signatures, used when JWKSURI is empty
+	PublicKeyFile string `json:"publickeyfile"`
+	// Issuer expected in the tokens, not checked when empty
+	Issuer string `json:"issuer"`
+	// Audience expected in the tokens (e.g. the NF instance ID or type),
+	// not checked when empty
+	Audience string `json:"audience"`
+	// Scopes lists the scopes required per route pattern (e.g. "/nf2loc")
+	Scopes map[string][]string `json:"scopes"`
+}
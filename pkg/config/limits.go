@@ -0,0 +1,16 @@
+package config
+
+// LimitsConfig contains the size limits of the requests received by the
+// servers
+type LimitsConfig struct {
+	// MaxBodySize in bytes bounds the request bodies of the routes not
+	// listed in Routes, 1048576 by default and unlimited when negative
+	MaxBodySize int64 `json:"maxbodysize"`
+	// Routes contains the body size limits per route pattern, e.g.
+	// "/nf2loc", with the same meaning. The streaming routes have their
+	// own limit
+	Routes map[string]int64 `json:"routes"`
+	// MaxHeaderBytes bounds the request line and header of HTTP/1.1, and
+	// the header list of HTTP/2, 1048576 by default
+	MaxHeaderBytes int `json:"maxheaderbytes"`
+}
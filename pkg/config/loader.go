@@ -0,0 +1,242 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Loader reads a configuration in layers, each one overriding the previous:
+// the defaults already set in the structure, the file, the environment
+// variables and the command line overrides. The structure is validated once
+// all the layers are applied when it implements Validator.
+//
+// The environment variable of a field is named after its JSON path, upper
+// cased and joined with "_" under the prefix, e.g. NF_LOG_LEVEL for the
+// "level" field of the "log" section. An `env` struct tag gives the full
+// name of the variable instead. Map, slice and struct values that are not
+// walked into are given as JSON, string slices also as comma separated
+// values.
+type Loader struct {
+	// Path of the file, JSON, YAML or TOML according to its extension (see
+	// ReadFile). The file layer is skipped when empty
+	Path string
+	// Section is the member of the file holding the configuration, e.g.
+	// the one of a role in the file of several. The whole file when empty
+	Section string
+	// EnvPrefix is the prefix of the environment variables, e.g. "NF"
+	EnvPrefix string
+	// Overrides are "path=value" assignments, the path being the JSON field
+	// names joined with dots, e.g. "log.level=debug"
+	Overrides Overrides
+}
+
+// Load applies the layers to cfg, a pointer to a structure
+func (l *Loader) Load(cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a pointer to a structure", cfg)
+	}
+	if l.Path != "" {
+		data, err := ReadSection(l.Path, l.Section)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("%s: %v", l.Path, err)
+		}
+	}
+	if err := applyEnv(v.Elem(), l.EnvPrefix); err != nil {
+		return err
+	}
+	for _, o := range l.Overrides {
+		if err := applyOverride(v.Elem(), o); err != nil {
+			return err
+		}
+	}
+	if val, ok := cfg.(Validator); ok {
+		return val.Validate()
+	}
+	return nil
+}
+
+// Overrides is a repeatable command line flag collecting "path=value"
+// assignments
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+// Set adds an assignment
+func (o *Overrides) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("%q is not a path=value assignment", value)
+	}
+	*o = append(*o, value)
+	return nil
+}
+
+// jsonName returns the JSON name of a field, empty for embedded structures
+// whose fields are promoted, "-" for ignored fields
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "-"
+	}
+	if name == "" {
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			return ""
+		}
+		return f.Name
+	}
+	return name
+}
+
+func envName(prefix, path string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range strings.ToUpper(path) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// applyEnv sets the fields of the structure v from the environment
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonName(f)
+		if name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			next := prefix
+			if name != "" {
+				next = envName(prefix+"_", name)
+			}
+			if err := applyEnv(field, next); err != nil {
+				return err
+			}
+			continue
+		}
+		env := f.Tag.Get("env")
+		if env == "" {
+			env = envName(prefix+"_", name)
+		}
+		raw, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, raw); err != nil {
+			return fmt.Errorf("%s: %v", env, err)
+		}
+	}
+	return nil
+}
+
+// applyOverride sets the field designated by the path of a "path=value"
+// assignment. Field names are matched case insensitively
+func applyOverride(v reflect.Value, assignment string) error {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("%q is not a path=value assignment", assignment)
+	}
+	field, err := lookup(v, strings.Split(parts[0], "."))
+	if err != nil {
+		return fmt.Errorf("%s: %v", parts[0], err)
+	}
+	if err := setValue(field, parts[1]); err != nil {
+		return fmt.Errorf("%s: %v", parts[0], err)
+	}
+	return nil
+}
+
+func lookup(v reflect.Value, path []string) (reflect.Value, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	if v.Kind() != reflect.Struct {
+		return v, fmt.Errorf("%s is not a section", path[0])
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonName(f)
+		if name == "" {
+			if found, err := lookup(v.Field(i), path); err == nil {
+				return found, nil
+			}
+			continue
+		}
+		if strings.EqualFold(name, path[0]) {
+			return lookup(v.Field(i), path[1:])
+		}
+	}
+	return v, fmt.Errorf("unknown field %s", path[0])
+}
+
+// setValue parses raw into the field according to its type
+func setValue(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String &&
+			!strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			v.Set(reflect.ValueOf(items).Convert(v.Type()))
+			return nil
+		}
+		return json.Unmarshal([]byte(raw), v.Addr().Interface())
+	default:
+		return json.Unmarshal([]byte(raw), v.Addr().Interface())
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package config
+
+// LocationsConfig contains the location resources, the NF record last
+// written to the location routes (/nf1 on NF1, /nf2 on NF2) served with
+// GET, PUT, PATCH and DELETE
+type LocationsConfig struct {
+	// History is the number of revisions of a record kept, 10 when 0
+	History int `json:"history"`
+}
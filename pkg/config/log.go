@@ -0,0 +1,18 @@
+package config
+
+// LogConfig contains the logger settings
+type LogConfig struct {
+	// Level is one of debug, info, warn, error
+	Level string `json:"level"`
+	// Format is console or json
+	Format string `json:"format"`
+	// MaxBodySize is the size above which logged bodies are redacted, and
+	// captured bodies truncated
+	MaxBodySize int `json:"maxbodysize"`
+	// Capture logs every request and response, as the debug level does.
+	// It can be changed at runtime on /admin/logging
+	Capture bool `json:"capture"`
+	// RedactHeaders lists the headers whose values are not captured, in
+	// addition to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+	RedactHeaders []string `json:"redactheaders"`
+}
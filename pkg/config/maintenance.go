@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaintenanceConfig takes routes of the NF out of service, e.g. to drain
+// it from the traffic before an upgrade, while its health endpoints keep
+// answering. The admin API changes it at runtime on /admin/maintenance
+type MaintenanceConfig struct {
+	// Enabled answers the requests of the routes with 503
+	Enabled bool `json:"enabled"`
+	// Servers lists the server endpoints (e.g. "API") whose routes are out
+	// of service, all when empty
+	Servers []string `json:"servers"`
+	// Routes lists the route patterns out of service (e.g. "/nf2loc"), all
+	// the routes of the Servers when empty
+	Routes []string `json:"routes"`
+	// RetryAfter is the Retry-After in seconds of the responses, 60 when 0
+	RetryAfter int `json:"retryafter"`
+	// Message is the detail of the responses
+	Message string `json:"message"`
+}
+
+// Validate checks the route patterns and the Retry-After
+func (m *MaintenanceConfig) Validate() error {
+	if m.RetryAfter < 0 {
+		return fmt.Errorf("maintenance.retryafter: negative value %d",
+			m.RetryAfter)
+	}
+	for i, route := range m.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("maintenance.routes[%d]: %q does not start "+
+				"with /", i, route)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package config
+
+// MetricsConfig contains the settings of the Prometheus metrics endpoint
+type MetricsConfig struct {
+	// Enabled serves the metrics on every server
+	Enabled bool `json:"enabled"`
+	// Path of the metrics endpoint, /metrics by default
+	Path string `json:"path"`
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MockConfig contains the canned responses of the mock peer mode, where the
+// NF answers the API its peers call without running its logic, e.g. a mock
+// NF2 for the development of NF1. The role answers as the real NF does when
+// no rule is set
+type MockConfig struct {
+	// Rules are matched in order, the first one matching the method and
+	// path of a request answers it
+	Rules []MockRule `json:"rules"`
+}
+
+// MockRule answers the requests of a route with scripted responses
+type MockRule struct {
+	// Method matched, any when empty
+	Method string `json:"method"`
+	// Path of the route under the API root prefix, e.g. "/nf2"
+	Path string `json:"path"`
+	// Responses are answered in turn, the last one repeating unless Cycle
+	// is set. A 200 with no body is answered when empty
+	Responses []MockResponse `json:"responses"`
+	// Cycle answers the first response again after the last one
+	Cycle bool `json:"cycle"`
+}
+
+// MockResponse is a response of a rule. The body and the callback URL and
+// body are Go templates of the request, e.g.
+// {"correlationid": {{json .Body.correlationid}}}
+type MockResponse struct {
+	// Status is 200 by default
+	Status int `json:"status"`
+	// Delay in milliseconds, plus up to DelayJitter, before answering
+	Delay       int `json:"delay"`
+	DelayJitter int `json:"delayjitter"`
+	// Headers are set on the response. The Content-Type is JSON when the
+	// body starts with { or [, plain text otherwise, unless set
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// Callback is a request sent once the response is answered, e.g. the
+	// location report of NF2
+	Callback *MockCallback `json:"callback"`
+}
+
+// MockCallback is a request sent by the mock after a response
+type MockCallback struct {
+	// Delay in milliseconds after the response
+	Delay int `json:"delay"`
+	// Method is POST by default
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// Validate checks the rules
+func (m *MockConfig) Validate() error {
+	for i, r := range m.Rules {
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("mock.rules[%d].path: %q is not an absolute "+
+				"path", i, r.Path)
+		}
+		for j, resp := range r.Responses {
+			field := fmt.Sprintf("mock.rules[%d].responses[%d]", i, j)
+			switch {
+			case resp.Status != 0 && (resp.Status < 100 || resp.Status > 599):
+				return fmt.Errorf("%s.status: invalid status %d", field,
+					resp.Status)
+			case resp.Delay < 0 || resp.DelayJitter < 0:
+				return fmt.Errorf("%s.delay: negative delay", field)
+			case resp.Callback != nil && resp.Callback.URL == "":
+				return fmt.Errorf("%s.callback.url: missing URL", field)
+			case resp.Callback != nil && resp.Callback.Delay < 0:
+				return fmt.Errorf("%s.callback.delay: negative delay", field)
+			}
+		}
+	}
+	return nil
+}
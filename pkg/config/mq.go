@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Message queue backends
+const (
+	// MQKafka publishes to and consumes from Kafka topics
+	MQKafka string = "kafka"
+	// MQNATS publishes to and consumes from NATS subjects
+	MQNATS string = "nats"
+)
+
+// MQConfig contains the message queue decoupling the NF from its peers:
+// the NF events are published to a topic, alongside the SSE stream and the
+// subscriptions, and the callbacks may go through a topic of SBI requests
+// sent by the NFs consuming it instead of being sent at once
+type MQConfig struct {
+	// Backend is MQKafka or MQNATS, no message queue when empty
+	Backend string `json:"backend"`
+	// Brokers are the host:port of the Kafka brokers or the URLs of the
+	// NATS servers (e.g. "nats://nats:4222")
+	Brokers []string `json:"brokers"`
+	// TLS secures the connections to the brokers
+	TLS MQTLSConfig `json:"tls"`
+	// Username and Password authenticate to the brokers, with SASL PLAIN
+	// to Kafka. The password may be a secret reference
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// EventsTopic receives the NF events, none are published when empty
+	EventsTopic string `json:"eventstopic"`
+	// Events are the event types published, all when empty
+	Events []string `json:"events"`
+	// RequestsTopic carries the SBI requests published by the NFs with
+	// Callbacks and sent by those with Consume
+	RequestsTopic string `json:"requeststopic"`
+	// Callbacks publishes the callbacks of the NF (the NF2 location
+	// reports, the notifications of the subscriptions) to RequestsTopic
+	// instead of sending them
+	Callbacks bool `json:"callbacks"`
+	// Consume sends the SBI requests of RequestsTopic
+	Consume bool `json:"consume"`
+	// Group is the Kafka consumer group or NATS queue group sharing the
+	// requests between the consumers, "nfservice-" and the NF name when
+	// empty
+	Group string `json:"group"`
+	// QueueSize is the number of events waiting to be published above
+	// which new ones are dropped, 256 when 0
+	QueueSize int `json:"queuesize"`
+	// Timeout in milliseconds of a publication, 5000 when 0
+	Timeout int `json:"timeout"`
+}
+
+// MQTLSConfig contains the TLS settings of the connections to the brokers
+type MQTLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// Certificate, key and CA bundle, those of the NF clients when empty
+	TLSFiles
+	// ServerName verified in the broker certificates, the broker host when
+	// empty
+	ServerName string `json:"servername"`
+}
+
+// Validate checks the backend, the brokers and the topics
+func (m *MQConfig) Validate() error {
+	switch m.Backend {
+	case "":
+		return nil
+	case MQKafka, MQNATS:
+	default:
+		return fmt.Errorf("mq.backend: unknown backend %q, expected %s or %s",
+			m.Backend, MQKafka, MQNATS)
+	}
+	if len(m.Brokers) == 0 {
+		return fmt.Errorf("mq.brokers: no broker")
+	}
+	for i, b := range m.Brokers {
+		if m.Backend == MQNATS && strings.Contains(b, "://") {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			return fmt.Errorf("mq.brokers[%d]: %v", i, err)
+		}
+	}
+	if (m.Callbacks || m.Consume) && m.RequestsTopic == "" {
+		return fmt.Errorf("mq.requeststopic: required by the callbacks " +
+			"and the consumer")
+	}
+	if m.QueueSize < 0 || m.Timeout < 0 {
+		return fmt.Errorf("mq: negative queuesize or timeout")
+	}
+	return nil
+}
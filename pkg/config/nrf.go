@@ -0,0 +1,30 @@
+package config
+
+// NRFConfig contains the settings used to register the NF with an NRF
+type NRFConfig struct {
+	// API root of the NRF (e.g. https://localhost:8000). Registration is
+	// disabled when empty
+	APIRoot string `json:"apiroot"`
+	// NF instance ID, generated at startup when empty
+	NfInstanceID string `json:"nfinstanceid"`
+	// NF type advertised in the profile (e.g. AMF, SMF, ...)
+	NfType string `json:"nftype"`
+	// Heartbeat period in seconds proposed to the NRF
+	HeartBeatTimer int `json:"heartbeattimer"`
+	// FQDN of the NF advertised in the profile, none when empty
+	FQDN string `json:"fqdn"`
+	// IPv4Addresses of the NF advertised in the profile
+	IPv4Addresses []string `json:"ipv4addresses"`
+	// Services advertised in the profile
+	Services []NRFServiceConfig `json:"services"`
+}
+
+// NRFServiceConfig describes an NF service advertised to the NRF
+type NRFServiceConfig struct {
+	// Service name (e.g. nnf-loc)
+	Name string `json:"name"`
+	// Endpoint serving the service as host:port
+	Endpoint string `json:"endpoint"`
+	// API version in the URI (e.g. v1)
+	APIVersion string `json:"apiversion"`
+}
@@ -0,0 +1,18 @@
+package config
+
+// OAuth2Config contains the settings of the OAuth2 access tokens requested
+// from the NRF (client credentials grant) for the outbound requests
+type OAuth2Config struct {
+	// Enabled turns the access tokens on. Tokens are only requested for the
+	// peers that have a scope configured
+	Enabled bool `json:"enabled"`
+	// TokenURI is the token endpoint, the NRF API root followed by
+	// /oauth2/token when empty
+	TokenURI string `json:"tokenuri"`
+	// RefreshMargin is the time in seconds before expiry a token is renewed
+	RefreshMargin int `json:"refreshmargin"`
+	// ClientSecret authenticates the NF instance, its client ID, to the
+	// token endpoint. It is usually a secret reference, e.g.
+	// "vault:nf/oauth2#secret". No secret is sent when empty
+	ClientSecret string `json:"clientsecret"`
+}
@@ -0,0 +1,10 @@
+package config
+
+// OpenAPIConfig contains the request validation settings of the NF servers
+type OpenAPIConfig struct {
+	// Enabled makes the servers reject the request bodies that do not match
+	// the schema of their operation in the specification
+	Enabled bool `json:"enabled"`
+	// Spec is the OpenAPI 3 specification file, in JSON
+	Spec string `json:"spec"`
+}
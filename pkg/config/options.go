@@ -0,0 +1,22 @@
+package config
+
+// Options are the command line settings of an NF, applied over its
+// configuration file
+type Options struct {
+	// HTTPVersion is 1 or 2
+	HTTPVersion int
+	// ConfigFile is the configuration file, none when empty
+	ConfigFile string
+	// Section is the member of the configuration file holding the
+	// configuration of the NF, the whole file when empty
+	Section string
+	// LogLevel and LogFormat override the configured log settings when
+	// set
+	LogLevel  string
+	LogFormat string
+	// Overrides are "path=value" assignments of configuration fields
+	Overrides Overrides
+	// MockPeer answers the API the peers of the NF call with the canned
+	// responses of the mock configuration instead of running its logic
+	MockPeer bool
+}
@@ -0,0 +1,56 @@
+package config
+
+import "fmt"
+
+// OutboxConfig contains the queue of the POST requests the NF sends on its
+// own, the location reports and the notifications, that failed because the
+// peer was unreachable. They are kept in a BoltDB file and sent again with
+// backoff until the peer answers
+type OutboxConfig struct {
+	Enabled bool `json:"enabled"`
+	// Path of the BoltDB file of the queue
+	Path string `json:"path"`
+	// InitialBackoff is the delay in milliseconds before the first
+	// redelivery, doubled after each failure up to MaxBackoff. 1000 and
+	// 60000 when 0
+	InitialBackoff int `json:"initialbackoff"`
+	MaxBackoff     int `json:"maxbackoff"`
+	// MaxAttempts is the number of redeliveries of a request before it is
+	// dropped, 0 for no limit
+	MaxAttempts int `json:"maxattempts"`
+	// MaxAge is the time in milliseconds a request is kept in the queue,
+	// 3600000 when 0
+	MaxAge int `json:"maxage"`
+	// MaxEntries is the number of requests the queue holds, the requests
+	// failing beyond being dropped. 10000 when 0
+	MaxEntries int `json:"maxentries"`
+}
+
+// Validate checks the file and the limits of the queue
+func (o *OutboxConfig) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	if o.Path == "" {
+		return fmt.Errorf("outbox.path: missing file")
+	}
+	for _, f := range []struct {
+		field string
+		value int
+	}{
+		{"outbox.initialbackoff", o.InitialBackoff},
+		{"outbox.maxbackoff", o.MaxBackoff},
+		{"outbox.maxattempts", o.MaxAttempts},
+		{"outbox.maxage", o.MaxAge},
+		{"outbox.maxentries", o.MaxEntries},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("%s: negative value %d", f.field, f.value)
+		}
+	}
+	if o.MaxBackoff > 0 && o.InitialBackoff > o.MaxBackoff {
+		return fmt.Errorf("outbox.initialbackoff: %d ms longer than the "+
+			"maximum backoff (%d ms)", o.InitialBackoff, o.MaxBackoff)
+	}
+	return nil
+}
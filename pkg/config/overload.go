@@ -0,0 +1,53 @@
+package config
+
+import "fmt"
+
+// OverloadConfig contains the SBI overload control: the Overload Control
+// Information (3gpp-Sbi-Oci header) a server under load attaches to its
+// responses, and the throttling of the requests sent to the peers that
+// sent one
+type OverloadConfig struct {
+	// Enabled attaches the OCI to the responses of the servers under load
+	Enabled bool `json:"enabled"`
+	// Capacity is the number of requests in progress on a server taken as
+	// its full load, admission.maxinflight when 0
+	Capacity int `json:"capacity"`
+	// Levels map the load of a server to the reduction asked from the
+	// peers, the level of the highest load reached applying. 80% asks for
+	// 10%, 90% for 30% and 100% for 50% when empty
+	Levels []OverloadLevel `json:"levels"`
+	// Validity is the time in seconds the OCI sent apply, 30 when 0
+	Validity int `json:"validity"`
+	// Honor drops the share of the requests to a peer its OCI asks for,
+	// failing them without sending them
+	Honor bool `json:"honor"`
+}
+
+// OverloadLevel is a load threshold of the overload control
+type OverloadLevel struct {
+	// Load is the percentage of the capacity from which the level applies
+	Load int `json:"load"`
+	// Reduction is the percentage of their requests the peers are asked
+	// to stop sending
+	Reduction int `json:"reduction"`
+}
+
+// Validate checks the levels and that an enabled overload control has a
+// capacity
+func (o *OverloadConfig) Validate(admission AdmissionConfig) error {
+	if o.Enabled && o.Capacity <= 0 &&
+		(!admission.Enabled || admission.MaxInFlight <= 0) {
+		return fmt.Errorf("overload.capacity: missing capacity, without " +
+			"admission.maxinflight")
+	}
+	if o.Validity < 0 {
+		return fmt.Errorf("overload.validity: negative validity")
+	}
+	for i, l := range o.Levels {
+		if l.Load <= 0 || l.Reduction < 0 || l.Reduction > 100 {
+			return fmt.Errorf("overload.levels[%d]: load %d not positive or "+
+				"reduction %d out of 0-100", i, l.Load, l.Reduction)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import "fmt"
+
+// Hosts the NF running in a pod advertises
+const (
+	PodAdvertiseIP      = "ip"
+	PodAdvertiseService = "service"
+)
+
+// PodConfig contains the integration of an NF running in a Kubernetes pod:
+// its addresses read from the downward API environment variables POD_IP,
+// POD_NAME, POD_NAMESPACE and SERVICE_NAME, and its readiness reported to
+// the API server
+type PodConfig struct {
+	// Enabled replaces the host of the local API root prefix and of the
+	// endpoints of the NRF profile with those of the pod, so that the
+	// configuration is the same for every pod
+	Enabled bool `json:"enabled"`
+	// Advertise is the host of the local API root: PodAdvertiseIP, the
+	// default, or PodAdvertiseService for the DNS name of the service of
+	// the pods. The NRF profile carries both
+	Advertise string `json:"advertise"`
+	// ClusterDomain of the service DNS names, cluster.local when empty
+	ClusterDomain string `json:"clusterdomain"`
+	// ReadinessGate is the condition type of the readiness gate of the pod
+	// (e.g. "nfservice.io/ready") the NF sets to its readiness through the
+	// API server, none when empty. The service account needs the patch
+	// permission on pods/status
+	ReadinessGate string `json:"readinessgate"`
+	// ReadinessInterval is the time in seconds between two readiness
+	// checks, 10 when 0
+	ReadinessInterval int `json:"readinessinterval"`
+	// APIServer is the URL of the Kubernetes API server, the one of the
+	// cluster (KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT) when
+	// empty
+	APIServer string `json:"apiserver"`
+}
+
+// Validate checks the advertised host and the readiness interval
+func (p *PodConfig) Validate() error {
+	switch p.Advertise {
+	case "", PodAdvertiseIP, PodAdvertiseService:
+	default:
+		return fmt.Errorf("pod.advertise: unknown host %q, expected %q or "+
+			"%q", p.Advertise, PodAdvertiseIP, PodAdvertiseService)
+	}
+	if p.ReadinessInterval < 0 {
+		return fmt.Errorf("pod.readinessinterval: negative interval")
+	}
+	return nil
+}
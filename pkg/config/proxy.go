@@ -0,0 +1,19 @@
+package config
+
+// ProxyDirect is the proxy of the peers reached without proxy
+const ProxyDirect = "direct"
+
+// ProxyConfig sets the proxy the peers are reached through
+type ProxyConfig struct {
+	// URL of the proxy: http://host:port for HTTP CONNECT tunnels or
+	// socks5://host:port, with optional user:password credentials. No
+	// proxy is used when empty
+	URL string `json:"url"`
+	// Environment uses the HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables
+	// when URL is empty
+	Environment bool `json:"environment"`
+	// NoProxy lists the hosts reached without proxy: host names, which
+	// match their subdomains as well, .domain suffixes, IP addresses and
+	// CIDRs, optionally with a port. "*" disables the proxy
+	NoProxy []string `json:"noproxy"`
+}
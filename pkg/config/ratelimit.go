@@ -0,0 +1,31 @@
+package config
+
+// RateLimitConfig contains the token buckets limiting the inbound requests
+// of the servers and the outbound requests to the peers
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// Default applies to the routes not listed in Routes
+	Default RouteRateLimit `json:"default"`
+	// Routes contains the limits per route pattern (e.g. "/nf2loc")
+	Routes map[string]RouteRateLimit `json:"routes"`
+	// Outbound limits the requests sent to each peer NF host:port. The
+	// requests over the limit wait for a token
+	Outbound RateLimit `json:"outbound"`
+}
+
+// RouteRateLimit contains the limits of a route
+type RouteRateLimit struct {
+	// Global is shared by all the clients of the route
+	Global RateLimit `json:"global"`
+	// Client applies to each client identity, the CN of its certificate
+	// with mutual TLS, its source IP otherwise
+	Client RateLimit `json:"client"`
+}
+
+// RateLimit is a token bucket, unlimited when Rate is 0
+type RateLimit struct {
+	// Rate is the number of requests per second
+	Rate float64 `json:"rate"`
+	// Burst is the number of requests accepted at once above the rate
+	Burst int `json:"burst"`
+}
@@ -0,0 +1,35 @@
+package config
+
+import "errors"
+
+// RecordConfig contains the recording of the exchanges of the NF, replayed
+// against an NF by "nfservice replay". The admin API starts and stops it at
+// runtime on /admin/record
+type RecordConfig struct {
+	Enabled bool `json:"enabled"`
+	// File the exchanges are appended to, one JSON object per line
+	File string `json:"file"`
+	// Server records the requests served
+	Server bool `json:"server"`
+	// Client records the requests sent to the peers
+	Client bool `json:"client"`
+	// Paths lists the prefixes of the paths recorded, all when empty
+	Paths []string `json:"paths"`
+	// MaxBodySize is the size above which the recorded bodies are
+	// truncated, 1 MiB by default
+	MaxBodySize int `json:"maxbodysize"`
+	// RedactHeaders lists the headers whose values are not recorded, in
+	// addition to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+	RedactHeaders []string `json:"redactheaders"`
+}
+
+// Validate checks that an enabled recording has a file
+func (r *RecordConfig) Validate() error {
+	if r.Enabled && r.File == "" {
+		return errors.New("record.file: missing file of the recording")
+	}
+	if r.MaxBodySize < 0 {
+		return errors.New("record.maxbodysize: negative size")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReplicationConfig contains the replication of the NF state between the
+// replicas of an NF keeping it in memory or in a local file: the writes to
+// the store are streamed to the peer replicas over HTTP/2
+type ReplicationConfig struct {
+	// Enabled serves the stream of the writes of the replica and follows
+	// those of the peers
+	Enabled bool `json:"enabled"`
+	// Peers are the API roots of the NF servers of the peer replicas, e.g.
+	// "https://nf1-1.nf1:8070". The replica itself may be listed
+	Peers []string `json:"peers"`
+	// Path of the stream on the NF server, /replication when empty
+	Path string `json:"path"`
+	// Token is the secret the replicas share, sent as a Bearer token to
+	// follow the streams of the peers. It may be a secret reference.
+	// Without it, only the peers verified by mutual TLS follow the stream
+	Token string `json:"token"`
+	// Collections are replicated in the snapshot sent to the peers
+	// connecting besides those written since the start, e.g. the ones of a
+	// BoltDB file
+	Collections []string `json:"collections"`
+	// RetryInterval is the time in milliseconds before following a peer
+	// again once its stream failed, 1000 when 0
+	RetryInterval int `json:"retryinterval"`
+}
+
+// Validate checks the peers and the path
+func (r *ReplicationConfig) Validate(backend string) error {
+	if !r.Enabled {
+		return nil
+	}
+	if backend == StoreRedis {
+		return fmt.Errorf("replication.enabled: the Redis store is shared " +
+			"by the replicas already")
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("replication.path: %q is not an absolute path",
+			r.Path)
+	}
+	for i, peer := range r.Peers {
+		if err := CheckURL(peer); err != nil {
+			return fmt.Errorf("replication.peers[%d]: %v", i, err)
+		}
+	}
+	if r.RetryInterval < 0 {
+		return fmt.Errorf("replication.retryinterval: negative interval")
+	}
+	return nil
+}
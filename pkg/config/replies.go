@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RepliesConfig contains the bodies the NF handlers answer with, e.g. to
+// serve the NFs as test doubles of their peers
+type RepliesConfig struct {
+	// Routes map the route patterns of the handlers (e.g. "/nf2") to their
+	// reply. The routes without reply answer "Hello Thanks !!!"
+	Routes map[string]ReplyConfig `json:"routes"`
+}
+
+// ReplyConfig is the response of a route. The body is a Go template of the
+// request, the NF message received and the state of the NF, e.g.
+// {"correlationid": {{json .Body.correlationid}}, "at": {{json .Now}}}
+type ReplyConfig struct {
+	Body string `json:"body"`
+	// Headers are set on the response. The Content-Type is JSON when the
+	// body starts with { or [, plain text otherwise, unless set
+	Headers map[string]string `json:"headers"`
+}
+
+// Validate checks the route patterns
+func (r *RepliesConfig) Validate() error {
+	for route := range r.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("replies.routes: %q is not an absolute path",
+				route)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package config
+
+// RetryConfig contains the retry policy of the outbound requests
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt, 0
+	// disables retries
+	MaxRetries int `json:"maxretries"`
+	// InitialBackoff is the delay before the first retry in milliseconds
+	InitialBackoff int `json:"initialbackoff"`
+	// MaxBackoff caps the exponential backoff in milliseconds
+	MaxBackoff int `json:"maxbackoff"`
+	// BudgetRatio is the ratio of retries to requests allowed over time,
+	// which keeps retries from amplifying an outage of the peer
+	BudgetRatio float64 `json:"budgetratio"`
+}
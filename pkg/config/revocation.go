@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Revocation check modes of the client certificates
+const (
+	// RevocationSoftFail accepts the certificates whose status is unknown
+	RevocationSoftFail = "softfail"
+	// RevocationHardFail rejects the certificates whose status is unknown
+	RevocationHardFail = "hardfail"
+)
+
+// RevocationConfig checks the revocation of the client certificates with
+// mutual TLS, and staples the OCSP responses of the server certificates
+type RevocationConfig struct {
+	// Staple fetches the OCSP responses of the server certificates from
+	// their responder and staples them to the handshakes
+	Staple bool `json:"staple"`
+	// Mode checks the client certificates, RevocationSoftFail or
+	// RevocationHardFail. No check when empty
+	Mode string `json:"mode"`
+	// CRLFiles are the CRLs, PEM or DER, of the issuers of the client
+	// certificates, read at startup and reload
+	CRLFiles []string `json:"crlfiles"`
+	// OCSP queries the responders of the client certificates no CRL covers
+	OCSP bool `json:"ocsp"`
+	// CacheTime is the longest time in seconds an OCSP response is kept,
+	// 3600 when 0. The next update of the response bounds it as well
+	CacheTime int `json:"cachetime"`
+	// Timeout of the OCSP queries in milliseconds, 5000 when 0
+	Timeout int `json:"timeout"`
+}
+
+// Validate checks the mode, the times and that the CRL files exist
+func (r *RevocationConfig) Validate() error {
+	switch r.Mode {
+	case "":
+	case RevocationSoftFail, RevocationHardFail:
+		if len(r.CRLFiles) == 0 && !r.OCSP {
+			return fmt.Errorf("tls.revocation.mode: neither crlfiles nor " +
+				"ocsp to check with")
+		}
+	default:
+		return fmt.Errorf("tls.revocation.mode: unknown mode %q, %s or %s",
+			r.Mode, RevocationSoftFail, RevocationHardFail)
+	}
+	for i, file := range r.CRLFiles {
+		if _, err := os.Stat(filepath.Clean(file)); err != nil {
+			return fmt.Errorf("tls.revocation.crlfiles[%d]: %v", i, err)
+		}
+	}
+	switch {
+	case r.CacheTime < 0:
+		return fmt.Errorf("tls.revocation.cachetime: negative value %d",
+			r.CacheTime)
+	case r.Timeout < 0:
+		return fmt.Errorf("tls.revocation.timeout: negative value %d",
+			r.Timeout)
+	}
+	return nil
+}
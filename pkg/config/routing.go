@@ -0,0 +1,55 @@
+package config
+
+// RoutingConfig contains the routing table of the proxy mode: the requests
+// received by the "PROXY" server whose path starts with the prefix of a
+// route are forwarded to the upstream NFs of the route, like an SCP does
+type RoutingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Address is the listen address of the "PROXY" server
+	Address string `json:"address"`
+	// Routes are matched by their longest prefix
+	Routes []RouteConfig `json:"routes"`
+	// HealthCheck checks the upstreams, the unhealthy ones get no request
+	HealthCheck UpstreamCheckConfig `json:"healthcheck"`
+}
+
+// RouteConfig is a route of the routing table
+type RouteConfig struct {
+	// Prefix of the request paths, e.g. /nnrf-disc/v1/
+	Prefix string `json:"prefix"`
+	// Upstreams are the API roots of the NFs serving the route, e.g.
+	// https://nrf:8443, chosen by the load balancing policy. They are
+	// reached with the settings of their peer
+	Upstreams []string `json:"upstreams"`
+	// LoadBalancing is the policy choosing among the healthy upstreams:
+	// BalanceRoundRobin, BalanceLeastRequests or BalanceWeighted.
+	// BalanceRoundRobin when empty
+	LoadBalancing string `json:"loadbalancing"`
+	// Rewrite replaces the prefix in the path forwarded when set, e.g. "/"
+	// removes it
+	Rewrite string `json:"rewrite"`
+	// SetHeaders are set on the requests forwarded, replacing the values
+	// of the client
+	SetHeaders map[string]string `json:"setheaders"`
+	// RemoveHeaders are removed from the requests forwarded
+	RemoveHeaders []string `json:"removeheaders"`
+	// ResponseHeaders are set on the responses of the upstreams
+	ResponseHeaders map[string]string `json:"responseheaders"`
+}
+
+// UpstreamCheckConfig contains the health check of the upstreams
+type UpstreamCheckConfig struct {
+	// Interval in milliseconds between the checks, 10000 by default
+	Interval int `json:"interval"`
+	// Timeout in milliseconds of a check, 2000 by default
+	Timeout int `json:"timeout"`
+	// Path is requested with GET on the upstreams, which must answer 2xx.
+	// A TCP connection is opened instead when empty
+	Path string `json:"path"`
+	// UnhealthyThreshold is the number of failed checks in a row making an
+	// upstream unhealthy, 2 by default
+	UnhealthyThreshold int `json:"unhealthythreshold"`
+	// HealthyThreshold is the number of passed checks in a row making an
+	// unhealthy upstream healthy again, 1 by default
+	HealthyThreshold int `json:"healthythreshold"`
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaDialect is the JSON Schema version of the configuration schemas
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaer is implemented by the configuration types whose JSON form is
+// not derived from their Go type, e.g. APIRoots
+type schemaer interface {
+	JSONSchema() map[string]interface{}
+}
+
+// Schema returns the JSON Schema of the configuration file of cfg, a
+// structure or a pointer to one, derived from its JSON field names and
+// types. The members of the structures are not required, the defaults
+// applying to the missing ones, but the unknown ones are rejected to
+// catch the misspelled fields
+func Schema(title string, cfg interface{}) map[string]interface{} {
+	s := schemaOf(reflect.TypeOf(cfg))
+	s["$schema"] = SchemaDialect
+	s["title"] = title
+	return s
+}
+
+var schemaerType = reflect.TypeOf((*schemaer)(nil)).Elem()
+
+func schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(schemaerType) {
+		return reflect.Zero(t).Interface().(schemaer).JSONSchema()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		addProperties(t, properties)
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaOf(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	/* any JSON value */
+	return map[string]interface{}{}
+}
+
+// addProperties adds the members of the structure, those of its embedded
+// structures included, under their JSON names
+func addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addProperties(ft, properties)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaOf(f.Type)
+	}
+}
@@ -0,0 +1,12 @@
+package config
+
+// SCPConfig contains the indirect communication settings: the requests to
+// the peers are sent to the Service Communication Proxy, which routes them
+// to the peer named by their 3gpp-Sbi-Target-apiRoot header
+type SCPConfig struct {
+	// APIRoot of the SCP, e.g. https://scp:8443 or https://scp:8443/prefix.
+	// The peers are reached directly when empty
+	APIRoot string `json:"apiroot"`
+	// Direct lists the peer host:ports still reached directly, e.g. the NRF
+	Direct []string `json:"direct"`
+}
@@ -0,0 +1,72 @@
+package config
+
+import "strings"
+
+// Secret providers, the scheme of the secret references
+const (
+	// SecretVault reads "vault:<path>#<key>", the key of a secret of the
+	// KV version 2 engine of HashiCorp Vault
+	SecretVault string = "vault"
+	// SecretKubernetes reads "k8s:<secret>/<key>", the key of a mounted
+	// Kubernetes secret
+	SecretKubernetes string = "k8s"
+)
+
+// SecretsConfig contains the providers of the secrets referenced from the
+// configuration. The TLS files, the admin and monitor token files, the JWT
+// public key file, the Redis password and the OAuth2 client secret are
+// given either as they are (a path or a plaintext value) or as a
+// "<provider>:<name>" reference to a secret
+type SecretsConfig struct {
+	Vault      VaultConfig      `json:"vault"`
+	Kubernetes KubernetesConfig `json:"kubernetes"`
+	// RefreshInterval is the time in seconds between two reads of the
+	// secrets in use, 60 when 0. The certificates are reloaded when theirs
+	// change
+	RefreshInterval int `json:"refreshinterval"`
+}
+
+// VaultConfig contains the settings of the HashiCorp Vault provider
+type VaultConfig struct {
+	// Address of Vault, e.g. https://vault:8200. VAULT_ADDR when empty,
+	// the provider is disabled when both are
+	Address string `json:"address"`
+	// Namespace of Vault Enterprise, VAULT_NAMESPACE when empty
+	Namespace string `json:"namespace"`
+	// Mount is the path of the KV version 2 engine, "secret" when empty
+	Mount string `json:"mount"`
+	// CAFile is the CA bundle verifying Vault, the system roots when empty
+	CAFile string `json:"cafile"`
+	// TokenFile contains the Vault token, VAULT_TOKEN is used when empty
+	TokenFile string `json:"tokenfile"`
+	// Role logs in with the Kubernetes auth method and the service account
+	// token of the pod instead of a token
+	Role string `json:"role"`
+	// AuthPath is the mount path of the Kubernetes auth method,
+	// "kubernetes" when empty
+	AuthPath string `json:"authpath"`
+	// Timeout in milliseconds of a request to Vault, 5000 when 0
+	Timeout int `json:"timeout"`
+}
+
+// KubernetesConfig contains the settings of the mounted Kubernetes secrets
+// provider
+type KubernetesConfig struct {
+	// Dir is the directory the secrets are mounted in, each one in the
+	// directory of its name, /var/run/secrets/nf when empty
+	Dir string `json:"dir"`
+}
+
+// SecretRef splits a reference to a secret into its provider and name. ok
+// is false for the values that are not references, e.g. a file path
+func SecretRef(s string) (provider, name string, ok bool) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	switch s[:i] {
+	case SecretVault, SecretKubernetes:
+		return s[:i], s[i+1:], true
+	}
+	return "", "", false
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Signature formats of the callbacks
+const (
+	// SignatureHMAC signs with HMAC-SHA256 in the X-Nf-Signature header
+	SignatureHMAC string = "hmac"
+	// SignatureJWS signs with a detached JWS in the X-Nf-Jws-Signature
+	// header
+	SignatureJWS string = "jws"
+)
+
+// SignaturesConfig contains the signatures of the callback requests: the
+// callbacks sent are signed and those received on the routes verified
+type SignaturesConfig struct {
+	// Enabled signs the callbacks sent and verifies the ones received
+	Enabled bool `json:"enabled"`
+	// Format is SignatureHMAC or SignatureJWS, SignatureHMAC when empty
+	Format string `json:"format"`
+	// Keys sign and verify the callbacks, by ID. The keys are rotated by
+	// listing the new key next to the old one on the receivers, then
+	// signing with it, then removing the old one
+	Keys []SignatureKey `json:"keys"`
+	// SigningKey is the ID of the key signing the callbacks, the first
+	// key when empty
+	SigningKey string `json:"signingkey"`
+	// All signs all the requests sent, not only the callbacks, for the
+	// peers verifying their other routes, e.g. "/nf2"
+	All bool `json:"all"`
+	// Routes are the route patterns whose requests must be signed, e.g.
+	// "/nf1"
+	Routes []string `json:"routes"`
+	// Tolerance is the age in seconds above which a signature is rejected
+	// as replayed, 300 when 0
+	Tolerance int `json:"tolerance"`
+}
+
+// SignatureKey is a key signing or verifying the callbacks
+type SignatureKey struct {
+	ID string `json:"id"`
+	// Secret is the shared secret of HMAC-SHA256 or of the JWS with HS256.
+	// It may be a secret reference
+	Secret string `json:"secret"`
+	// PrivateKeyFile signs the JWS with RS256 or ES256 instead, the
+	// receivers verifying them with the PEM public key or certificate
+	// PublicKeyFile
+	PrivateKeyFile string `json:"privatekeyfile"`
+	PublicKeyFile  string `json:"publickeyfile"`
+}
+
+// Validate checks the format and the keys
+func (s *SignaturesConfig) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	switch s.Format {
+	case "", SignatureHMAC, SignatureJWS:
+	default:
+		return fmt.Errorf("signatures.format: unknown format %q, expected "+
+			"%s or %s", s.Format, SignatureHMAC, SignatureJWS)
+	}
+	if len(s.Keys) == 0 {
+		return fmt.Errorf("signatures.keys: no key")
+	}
+	ids := make(map[string]bool, len(s.Keys))
+	for i, k := range s.Keys {
+		field := fmt.Sprintf("signatures.keys[%d]", i)
+		switch {
+		case k.ID == "" || strings.ContainsAny(k.ID, `,=" `):
+			return fmt.Errorf("%s.id: invalid key ID %q", field, k.ID)
+		case ids[k.ID]:
+			return fmt.Errorf("%s.id: duplicate key ID %q", field, k.ID)
+		case k.Secret == "" && s.Format != SignatureJWS:
+			return fmt.Errorf("%s.secret: required by HMAC", field)
+		case k.Secret == "" && k.PrivateKeyFile == "" &&
+			k.PublicKeyFile == "":
+			return fmt.Errorf("%s: no secret nor key file", field)
+		case k.Secret != "" && (k.PrivateKeyFile != "" ||
+			k.PublicKeyFile != ""):
+			return fmt.Errorf("%s: both a secret and key files", field)
+		}
+		ids[k.ID] = true
+	}
+	if s.SigningKey != "" && !ids[s.SigningKey] {
+		return fmt.Errorf("signatures.signingkey: unknown key %q",
+			s.SigningKey)
+	}
+	if s.Tolerance < 0 {
+		return fmt.Errorf("signatures.tolerance: negative tolerance")
+	}
+	return nil
+}
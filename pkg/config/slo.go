@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SLOConfig tracks the latency of the routes served against their service
+// level objectives
+type SLOConfig struct {
+	Enabled bool `json:"enabled"`
+	// Window is the number of recent requests of each route the
+	// percentiles and the burn rate are computed on, 1000 by default
+	Window int `json:"window"`
+	// Default is the objective of the routes not in Routes
+	Default SLOTarget `json:"default"`
+	// Routes are the objectives by route pattern, e.g. "/nf2loc"
+	Routes map[string]SLOTarget `json:"routes"`
+	// LogViolations logs the requests slower than the latency objective of
+	// their route, with their correlation ID
+	LogViolations bool `json:"logviolations"`
+}
+
+// SLOTarget is the latency objective of a route: Objective percent of its
+// requests served within Latency
+type SLOTarget struct {
+	// Latency in milliseconds, no objective when 0
+	Latency int `json:"latency"`
+	// Objective is the share of the requests in percent, e.g. 99.9, 99 by
+	// default
+	Objective float64 `json:"objective"`
+}
+
+// Validate checks the window and the objectives
+func (s *SLOConfig) Validate() error {
+	if s.Window < 0 {
+		return fmt.Errorf("slo.window: negative value %d", s.Window)
+	}
+	if err := s.Default.validate("slo.default"); err != nil {
+		return err
+	}
+	routes := make([]string, 0, len(s.Routes))
+	for route := range s.Routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		if err := s.Routes[route].validate("slo.routes." + route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t SLOTarget) validate(field string) error {
+	switch {
+	case t.Latency < 0:
+		return fmt.Errorf("%s.latency: negative value %d", field, t.Latency)
+	case t.Objective < 0 || t.Objective >= 100:
+		return fmt.Errorf("%s.objective: %v out of 0-100 (excluded)", field,
+			t.Objective)
+	}
+	return nil
+}
+
+// Target returns the objective of the route pattern
+func (s *SLOConfig) Target(route string) SLOTarget {
+	if t, ok := s.Routes[route]; ok {
+		return t
+	}
+	return s.Default
+}
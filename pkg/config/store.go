@@ -0,0 +1,36 @@
+package config
+
+// Store backends
+const (
+	// StoreMemory keeps the state in memory, lost on restart
+	StoreMemory string = "memory"
+	// StoreBolt keeps the state in a BoltDB file, for a single instance
+	StoreBolt string = "bolt"
+	// StoreRedis keeps the state in Redis, shared by the replicas
+	StoreRedis string = "redis"
+)
+
+// StoreConfig contains the settings of the store of the NF state: the
+// subscriptions, the pending correlations and the NF records
+type StoreConfig struct {
+	// Backend is StoreMemory, StoreBolt or StoreRedis. StoreMemory when
+	// empty
+	Backend string `json:"backend"`
+	// Path of the BoltDB file
+	Path string `json:"path"`
+	// Address is the host:port of the Redis server
+	Address string `json:"address"`
+	// Password authenticates to Redis, with Username when set (ACL). It
+	// may be a secret reference
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// DB is the Redis database number
+	DB int `json:"db"`
+	// Prefix of the Redis keys, "nf:" by default, so that NFs can share
+	// a server
+	Prefix string `json:"prefix"`
+	// Timeout in milliseconds of an operation on the store
+	Timeout int `json:"timeout"`
+	// PoolSize is the number of idle Redis connections kept open
+	PoolSize int `json:"poolsize"`
+}
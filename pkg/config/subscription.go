@@ -0,0 +1,17 @@
+package config
+
+// SubscriptionConfig contains the settings of the subscriptions to the NF
+// events and of their notifications
+type SubscriptionConfig struct {
+	// MaxValidity is the maximum lifetime of a subscription in seconds,
+	// also used when the subscriber asks for none
+	MaxValidity int `json:"maxvalidity"`
+	// DeliveryAttempts is the number of times a notification is sent
+	// before it is dropped
+	DeliveryAttempts int `json:"deliveryattempts"`
+	// RetryInterval is the delay in milliseconds between delivery attempts
+	RetryInterval int `json:"retryinterval"`
+	// QueueSize is the number of notifications waiting for delivery above
+	// which new ones are dropped
+	QueueSize int `json:"queuesize"`
+}
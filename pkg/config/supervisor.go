@@ -0,0 +1,20 @@
+package config
+
+// SupervisorConfig contains the settings of the multi-instance mode, where
+// one process runs several NF roles, each configured by the section of its
+// name in the same file
+type SupervisorConfig struct {
+	// Roles lists the roles run, e.g. ["nf1", "nf2"]
+	Roles []string `json:"roles"`
+	// RestartDelay is the time in milliseconds before a failed role is
+	// restarted, 1000 when 0. It doubles on each consecutive failure up to
+	// MaxRestartDelay, 30000 when 0
+	RestartDelay    int `json:"restartdelay"`
+	MaxRestartDelay int `json:"maxrestartdelay"`
+	// MaxRestarts is the number of consecutive restarts of a role after
+	// which the process gives up and exits, unlimited when 0
+	MaxRestarts int `json:"maxrestarts"`
+	// StableTime is the time in milliseconds after which a running role is
+	// no longer counted as failing, 60000 when 0
+	StableTime int `json:"stabletime"`
+}
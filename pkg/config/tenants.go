@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TenantConfig is an NF instance served by the process next to the default
+// one, with its own API root, certificate, peer and rate limits. Its
+// requests are those under its path prefix or to its host names
+type TenantConfig struct {
+	// Name of the tenant, the tenant label of its metrics
+	Name string `json:"name"`
+	// Prefix is the path prefix the routes of the tenant are mounted
+	// under, e.g. "/tenant-a"
+	Prefix string `json:"prefix"`
+	// Hosts are the host names of the tenant, matched against the Host
+	// header and, for the certificate, against the TLS server name
+	Hosts []string `json:"hosts"`
+	// APIRoot is the local API root prefix of the tenant (e.g.
+	// "://tenant-a.example.org"), whose host is that of the callback URIs
+	// of the tenant. The host of the NF API root when empty
+	APIRoot string `json:"apiroot"`
+	// TLS holds the certificate and key served to the Hosts, the ones of
+	// the server endpoint when empty
+	TLS TLSFiles `json:"tls"`
+	// RemoteAPIRoot is the API root of the peer NF the tenant sends its
+	// requests to (e.g. "://nf2.tenant-a:8090/nf2"), the one of the role
+	// when empty
+	RemoteAPIRoot string `json:"remoteapiroot"`
+	// RateLimit limits the requests of the tenant, its routes together
+	RateLimit RouteRateLimit `json:"ratelimit"`
+}
+
+// Tenants lists the tenants of the NF
+type Tenants []TenantConfig
+
+// Validate checks that the tenants have distinct names, prefixes and hosts
+// and a prefix or hosts to recognize their requests
+func (t Tenants) Validate() error {
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	hosts := make(map[string]string)
+	for i, tenant := range t {
+		field := fmt.Sprintf("tenants[%d]", i)
+		switch {
+		case tenant.Name == "":
+			return fmt.Errorf("%s.name: missing name", field)
+		case names[tenant.Name]:
+			return fmt.Errorf("%s.name: duplicate tenant %q", field,
+				tenant.Name)
+		case tenant.Prefix == "" && len(tenant.Hosts) == 0:
+			return fmt.Errorf("%s: neither prefix nor hosts", field)
+		case tenant.Prefix != "" && (!strings.HasPrefix(tenant.Prefix, "/") ||
+			strings.HasSuffix(tenant.Prefix, "/")):
+			return fmt.Errorf("%s.prefix: %q does not start, or ends, with /",
+				field, tenant.Prefix)
+		case prefixes[tenant.Prefix]:
+			return fmt.Errorf("%s.prefix: %q of another tenant", field,
+				tenant.Prefix)
+		case (tenant.TLS.CertFile == "") != (tenant.TLS.KeyFile == ""):
+			return fmt.Errorf("%s.tls: certfile and keyfile go together",
+				field)
+		}
+		names[tenant.Name] = true
+		if tenant.Prefix != "" {
+			prefixes[tenant.Prefix] = true
+		}
+		for _, h := range tenant.Hosts {
+			h = strings.ToLower(h)
+			if other, ok := hosts[h]; ok {
+				return fmt.Errorf("%s.hosts: %q of tenant %q", field, h,
+					other)
+			}
+			hosts[h] = tenant.Name
+		}
+		if tenant.APIRoot != "" {
+			if err := CheckURL("http" + tenant.APIRoot); err != nil {
+				return fmt.Errorf("%s.apiroot: %v", field, err)
+			}
+		}
+		if tenant.RemoteAPIRoot != "" {
+			if err := CheckURL("http" + tenant.RemoteAPIRoot); err != nil {
+				return fmt.Errorf("%s.remoteapiroot: %v", field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Tenant returns the tenant of the name, false when there is none
+func (t Tenants) Tenant(name string) (TenantConfig, bool) {
+	for _, tenant := range t {
+		if tenant.Name == name {
+			return tenant, true
+		}
+	}
+	return TenantConfig{}, false
+}
@@ -0,0 +1,55 @@
+package config
+
+// TimeoutsConfig contains the timeouts of the servers and of the outbound
+// client. All the times are in milliseconds, 0 selects the default
+type TimeoutsConfig struct {
+	Server ServerTimeouts `json:"server"`
+	Client ClientTimeouts `json:"client"`
+}
+
+// ServerTimeouts contains the timeouts of the NF servers
+type ServerTimeouts struct {
+	// Read is the time allowed to read a whole request
+	Read int `json:"read"`
+	// ReadHeader is the time allowed to read the request headers
+	ReadHeader int `json:"readheader"`
+	// Write is the time allowed to write the response
+	Write int `json:"write"`
+	// Idle is the time a keep-alive connection waits for the next request
+	Idle int `json:"idle"`
+	// Routes sets the deadline of the requests per route pattern (e.g.
+	// "/nf2loc"). The handlers stop waiting on the peers at the deadline
+	Routes map[string]int `json:"routes"`
+}
+
+// ClientTimeouts contains the timeouts of the requests sent to the peers
+type ClientTimeouts struct {
+	// Dial is the time allowed to open the TCP connection
+	Dial int `json:"dial"`
+	// TLSHandshake is the time allowed for the TLS handshake
+	TLSHandshake int `json:"tlshandshake"`
+	// ResponseHeader is the time allowed to receive the response headers
+	// once the request is sent
+	ResponseHeader int `json:"responseheader"`
+	// Overall is the time allowed to each attempt, reading the response
+	// body included
+	Overall int `json:"overall"`
+}
+
+// Merge returns the timeouts with the non zero values of o replacing the
+// ones of t
+func (t ClientTimeouts) Merge(o ClientTimeouts) ClientTimeouts {
+	if o.Dial > 0 {
+		t.Dial = o.Dial
+	}
+	if o.TLSHandshake > 0 {
+		t.TLSHandshake = o.TLSHandshake
+	}
+	if o.ResponseHeader > 0 {
+		t.ResponseHeader = o.ResponseHeader
+	}
+	if o.Overall > 0 {
+		t.Overall = o.Overall
+	}
+	return t
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Default certificate files of the NF
+const (
+	DefaultCertFile   string = "certs/server-cert.pem"
+	DefaultKeyFile    string = "certs/server-key.pem"
+	DefaultRootCAFile string = "certs/root-ca-cert.pem"
+)
+
+// TLSFiles contains the certificate files used by a server endpoint
+type TLSFiles struct {
+	// Certificate and key presented by the server
+	CertFile string `json:"certfile"`
+	KeyFile  string `json:"keyfile"`
+	// CA bundle used to verify the peers
+	CAFile string `json:"cafile"`
+}
+
+// TLSConfig contains the TLS settings of the NF servers and clients
+type TLSConfig struct {
+	// Default server certificate, key and CA bundle
+	TLSFiles
+	// Certificate and key presented by the clients with mutual TLS. The
+	// server certificate and key are used when not set
+	ClientCertFile string `json:"clientcertfile"`
+	ClientKeyFile  string `json:"clientkeyfile"`
+	// Servers overrides the files per server endpoint name (e.g. "API")
+	Servers map[string]TLSFiles `json:"servers"`
+
+	// MutualTLS makes the servers require and verify client certificates
+	// and the clients present their own certificate
+	MutualTLS bool `json:"mutualtls"`
+	// AllowedClients lists the SAN/CN accepted in client certificates. An
+	// empty list accepts any client certificate signed by the root CA
+	AllowedClients []string `json:"allowedclients"`
+	// AllowedPeers lists, per peer NF host, the SAN/CN accepted in the
+	// server certificate presented by that peer
+	AllowedPeers map[string][]string `json:"allowedpeers"`
+	// ACME obtains the server certificates from an ACME CA instead of the
+	// files
+	ACME ACMEConfig `json:"acme"`
+	// Policy restricts the TLS versions and cipher suites
+	Policy TLSPolicy `json:"policy"`
+	// Revocation checks the revocation of the client certificates and
+	// staples the OCSP responses of the server ones
+	Revocation RevocationConfig `json:"revocation"`
+}
+
+// ServerFiles returns the files of the named server endpoint, falling back
+// to the NF wide settings and then to the defaults
+func (t *TLSConfig) ServerFiles(name string) TLSFiles {
+	files := t.Servers[name]
+	if files.CertFile == "" {
+		files.CertFile = orDefault(t.CertFile, DefaultCertFile)
+	}
+	if files.KeyFile == "" {
+		files.KeyFile = orDefault(t.KeyFile, DefaultKeyFile)
+	}
+	if files.CAFile == "" {
+		files.CAFile = orDefault(t.CAFile, DefaultRootCAFile)
+	}
+	return files
+}
+
+// serverFiles returns the files of the named server endpoint, without the
+// certificate and key when it uses ACME
+func (t *TLSConfig) serverFiles(name string) TLSFiles {
+	files := t.ServerFiles(name)
+	if t.ACME.Serves(name) {
+		files.CertFile, files.KeyFile = "", ""
+	}
+	return files
+}
+
+// Override returns the files with the ones set in o replacing them
+func (f TLSFiles) Override(o TLSFiles) TLSFiles {
+	f.CertFile = orDefault(o.CertFile, f.CertFile)
+	f.KeyFile = orDefault(o.KeyFile, f.KeyFile)
+	f.CAFile = orDefault(o.CAFile, f.CAFile)
+	return f
+}
+
+// ClientFiles returns the certificate, key and CA bundle used by the clients
+func (t *TLSConfig) ClientFiles() TLSFiles {
+	return TLSFiles{
+		CertFile: orDefault(t.ClientCertFile,
+			orDefault(t.CertFile, DefaultCertFile)),
+		KeyFile: orDefault(t.ClientKeyFile,
+			orDefault(t.KeyFile, DefaultKeyFile)),
+		CAFile: orDefault(t.CAFile, DefaultRootCAFile),
+	}
+}
+
+// Files returns the certificate, key and CA bundle files of the given
+// server endpoints and of the client, without duplicates. The servers using
+// ACME only have their CA bundle
+func (t *TLSConfig) Files(servers ...string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(f TLSFiles) {
+		for _, file := range []string{f.CertFile, f.KeyFile, f.CAFile} {
+			if file != "" && !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	for _, name := range servers {
+		add(t.serverFiles(name))
+	}
+	add(t.ClientFiles())
+	return files
+}
+
+// Validate checks that the certificate files of the given server endpoints
+// and of the client exist and that the key pairs can be loaded, the ACME
+// servers having no key pair, that the policy suits HTTP/2, and the
+// revocation checks. The secret references are read when the TLS
+// configurations are built
+func (t *TLSConfig) Validate(servers ...string) error {
+	if err := t.ACME.Validate(); err != nil {
+		return err
+	}
+	if err := t.Policy.Validate(true); err != nil {
+		return err
+	}
+	if err := t.Revocation.Validate(); err != nil {
+		return err
+	}
+	for _, name := range servers {
+		if err := t.ServerFiles(name).validate(
+			!t.ACME.Serves(name)); err != nil {
+			return fmt.Errorf("%s server TLS: %v", name, err)
+		}
+	}
+	if err := t.ClientFiles().validate(t.MutualTLS); err != nil {
+		return fmt.Errorf("client TLS: %v", err)
+	}
+	return nil
+}
+
+func (f TLSFiles) validate(keyPair bool) error {
+	if !isSecretRef(f.CAFile) {
+		if _, err := os.Stat(filepath.Clean(f.CAFile)); err != nil {
+			return err
+		}
+	}
+	if !keyPair || isSecretRef(f.CertFile) || isSecretRef(f.KeyFile) {
+		return nil
+	}
+	if _, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile); err != nil {
+		return fmt.Errorf("loading %s/%s: %v", f.CertFile, f.KeyFile, err)
+	}
+	return nil
+}
+
+func isSecretRef(s string) bool {
+	_, _, ok := SecretRef(s)
+	return ok
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
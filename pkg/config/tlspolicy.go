@@ -0,0 +1,162 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// http2CipherSuites are the cipher suites one of which RFC 7540 requires
+// the HTTP/2 servers to support
+var http2CipherSuites = []string{
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+}
+
+// TLSPolicy restricts the TLS versions, cipher suites and key exchanges of
+// the NF servers and clients, and the session resumption of the servers
+type TLSPolicy struct {
+	// MinVersion is the oldest TLS version accepted, "1.0" to "1.3", 1.2
+	// when empty. HTTP/2 requires 1.2 at least
+	MinVersion string `json:"minversion"`
+	// CipherSuites lists the TLS 1.2 cipher suites by their IANA name, in
+	// order of preference, e.g. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256".
+	// The TLS 1.3 ones are always enabled. The Go defaults when empty
+	CipherSuites []string `json:"ciphersuites"`
+	// Curves lists the key exchanges by order of preference: "X25519",
+	// "P256", "P384", "P521" or "X25519MLKEM768". The Go defaults when empty
+	Curves []string `json:"curves"`
+	// DisableSessionTickets stops the servers from resuming the sessions
+	// with tickets
+	DisableSessionTickets bool `json:"disablesessiontickets"`
+	// TicketRotation is the time in seconds after which the servers encrypt
+	// the session tickets with a new key, the tickets of the previous key
+	// being resumed for as long. The Go rotation, daily, when 0
+	TicketRotation int `json:"ticketrotation"`
+	// HSTS is the max-age in seconds of the Strict-Transport-Security
+	// header of the responses over TLS, none when 0
+	HSTS int `json:"hsts"`
+}
+
+// Validate checks the names of the policy, and with http2 that it allows
+// neither the TLS versions nor the cipher suites RFC 7540 forbids, and
+// keeps the cipher suite it requires
+func (p *TLSPolicy) Validate(http2 bool) error {
+	if p.MinVersion != "" {
+		v, ok := tlsVersions[p.MinVersion]
+		switch {
+		case !ok:
+			return fmt.Errorf("tls.policy.minversion: unknown TLS version "+
+				"%q, 1.0 to 1.3", p.MinVersion)
+		case http2 && v < tls.VersionTLS12:
+			return fmt.Errorf("tls.policy.minversion: TLS %s not allowed "+
+				"with HTTP/2, 1.2 at least", p.MinVersion)
+		}
+	}
+	required := false
+	for i, name := range p.CipherSuites {
+		field := fmt.Sprintf("tls.policy.ciphersuites[%d]", i)
+		s, insecure := cipherSuite(name)
+		switch {
+		case s == nil:
+			return fmt.Errorf("%s: unknown cipher suite %q", field, name)
+		case insecure:
+			return fmt.Errorf("%s: %s is insecure", field, name)
+		case !tls12Suite(s):
+			return fmt.Errorf("%s: %s is a TLS 1.3 cipher suite, always "+
+				"enabled", field, name)
+		case http2 && !http2Suite(s):
+			return fmt.Errorf("%s: %s not allowed with HTTP/2, an ECDHE "+
+				"key exchange with AEAD cipher is", field, name)
+		}
+		for _, r := range http2CipherSuites {
+			required = required || r == name
+		}
+	}
+	if http2 && len(p.CipherSuites) > 0 && !required {
+		return fmt.Errorf("tls.policy.ciphersuites: HTTP/2 requires %s",
+			strings.Join(http2CipherSuites, " or "))
+	}
+	for i, name := range p.Curves {
+		if _, ok := tlsCurves[name]; !ok {
+			return fmt.Errorf("tls.policy.curves[%d]: unknown curve %q",
+				i, name)
+		}
+	}
+	switch {
+	case p.TicketRotation < 0:
+		return fmt.Errorf("tls.policy.ticketrotation: negative value %d",
+			p.TicketRotation)
+	case p.HSTS < 0:
+		return fmt.Errorf("tls.policy.hsts: negative value %d", p.HSTS)
+	}
+	return nil
+}
+
+// Apply sets the versions, cipher suites, curves and session tickets of
+// the policy, once validated, on the TLS configuration
+func (p *TLSPolicy) Apply(c *tls.Config) {
+	c.MinVersion = tls.VersionTLS12
+	if v, ok := tlsVersions[p.MinVersion]; ok {
+		c.MinVersion = v
+	}
+	for _, name := range p.CipherSuites {
+		if s, _ := cipherSuite(name); s != nil {
+			c.CipherSuites = append(c.CipherSuites, s.ID)
+		}
+	}
+	for _, name := range p.Curves {
+		if id, ok := tlsCurves[name]; ok {
+			c.CurvePreferences = append(c.CurvePreferences, id)
+		}
+	}
+	c.SessionTicketsDisabled = p.DisableSessionTickets
+}
+
+// cipherSuite returns the cipher suite named, nil when unknown, and
+// whether it is insecure
+func cipherSuite(name string) (*tls.CipherSuite, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s, false
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func tls12Suite(s *tls.CipherSuite) bool {
+	for _, v := range s.SupportedVersions {
+		if v == tls.VersionTLS12 {
+			return true
+		}
+	}
+	return false
+}
+
+// http2Suite reports whether the cipher suite is out of the block list of
+// RFC 7540 appendix A: an ephemeral key exchange with an AEAD cipher
+func http2Suite(s *tls.CipherSuite) bool {
+	return strings.HasPrefix(s.Name, "TLS_ECDHE_") &&
+		(strings.Contains(s.Name, "_GCM_") ||
+			strings.Contains(s.Name, "_CHACHA20_POLY1305"))
+}
@@ -0,0 +1,13 @@
+package config
+
+// TracingConfig contains the distributed tracing settings
+type TracingConfig struct {
+	// Enabled creates spans for the inbound and outbound requests
+	Enabled bool `json:"enabled"`
+	// OTLP/HTTP collector endpoint, e.g. http://localhost:4318
+	Endpoint string `json:"endpoint"`
+	// ServiceName reported in the span resource
+	ServiceName string `json:"servicename"`
+	// SampleRatio of the new traces that are exported, 1 by default
+	SampleRatio float64 `json:"sampleratio"`
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultAPIVersion is the API version served without versions configured
+const DefaultAPIVersion string = "v1"
+
+var apiVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+// VersioningConfig serves the routes of the server endpoints under
+// /{apiName}/{apiVersion}, after the path of the API root, as the SBI APIs
+// are
+type VersioningConfig struct {
+	// Enabled serves the routes of the endpoints of APIs versioned
+	Enabled bool `json:"enabled"`
+	// APIs is the API name of the server endpoints, e.g. {"API":
+	// "nnf1-loc"}. The endpoints not listed keep their unversioned routes
+	APIs map[string]string `json:"apis"`
+	// Versions lists the API versions served, the first one being the
+	// version of the URIs sent by the NF. DefaultAPIVersion when empty
+	Versions []string `json:"versions"`
+	// Legacy keeps serving the unversioned paths next to the versioned
+	// ones, for the peers not upgraded yet
+	Legacy bool `json:"legacy"`
+}
+
+// Validate checks the API names and versions
+func (v *VersioningConfig) Validate() error {
+	if !v.Enabled {
+		return nil
+	}
+	servers := make([]string, 0, len(v.APIs))
+	for server := range v.APIs {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	for _, server := range servers {
+		if name := v.APIs[server]; name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("versioning.apis.%s: invalid API name %q",
+				server, name)
+		}
+	}
+	seen := make(map[string]bool, len(v.Versions))
+	for _, version := range v.Versions {
+		switch {
+		case !apiVersionPattern.MatchString(version):
+			return fmt.Errorf("versioning.versions: invalid version %q, "+
+				"expected e.g. %q", version, DefaultAPIVersion)
+		case seen[version]:
+			return fmt.Errorf("versioning.versions: %q listed twice", version)
+		}
+		seen[version] = true
+	}
+	return nil
+}
+
+// Served returns the API versions served, the current one first
+func (v *VersioningConfig) Served() []string {
+	if len(v.Versions) == 0 {
+		return []string{DefaultAPIVersion}
+	}
+	return v.Versions
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Default interval between two checks of the configuration file
+const defaultWatchInterval = 2 * time.Second
+
+// Watcher calls a reload function when one of the watched files, e.g. the
+// configuration file, is modified. The configuration file watcher also
+// reloads when the process receives SIGHUP
+type Watcher struct {
+	paths    func() []string
+	interval time.Duration
+	reload   func()
+	hup      bool
+}
+
+// NewWatcher creates a watcher of the file located at path. reload is
+// called from the watcher Run goroutine
+func NewWatcher(path string, reload func()) *Watcher {
+	w := NewFilesWatcher(func() []string { return []string{path} }, reload)
+	w.hup = true
+	return w
+}
+
+// NewFilesWatcher creates a watcher of the files returned by paths, which
+// is called on every check so that the list can follow the configuration.
+// Files replaced through a symbolic link, as mounted Kubernetes secrets
+// are, are seen as modified
+func NewFilesWatcher(paths func() []string, reload func()) *Watcher {
+	return &Watcher{paths: paths, interval: defaultWatchInterval,
+		reload: reload}
+}
+
+// Run watches the file until the context is canceled
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	if w.hup {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	last := w.stat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last = w.stat()
+			w.reload()
+		case <-ticker.C:
+			if current := w.stat(); !current.equal(last) {
+				last = current
+				w.reload()
+			}
+		}
+	}
+}
+
+// fileState identifies a version of the file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// filesState identifies a version of the watched files
+type filesState map[string]fileState
+
+func (w *Watcher) stat() filesState {
+	state := make(filesState)
+	for _, path := range w.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			state[path] = fileState{}
+			continue
+		}
+		state[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return state
+}
+
+func (s filesState) equal(other filesState) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for path, state := range s {
+		if o, ok := other[path]; !ok || !o.modTime.Equal(state.modTime) ||
+			o.size != state.size {
+			return false
+		}
+	}
+	return true
+}
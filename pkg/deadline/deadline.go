@@ -0,0 +1,81 @@
+// Package deadline carries the deadline of the SBI requests across the NFs,
+// the 3gpp-Sbi-Max-Rsp-Time header of 3GPP TS 29.500: the milliseconds the
+// client waits for the response. The deadline of a request served is
+// shortened to it, and the time left is sent on with the requests made on
+// its behalf
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+const (
+	// Header carries the time the client waits for the response
+	Header = "3gpp-Sbi-Max-Rsp-Time"
+	// Longest time the header carries, in milliseconds (5 digits)
+	maxMillis = 99999
+)
+
+// Parse returns the time of a header value, false when it is not a
+// positive integer of milliseconds
+func Parse(s string) (time.Duration, bool) {
+	ms, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || ms <= 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// Middleware shortens the deadline of the request to the time of its
+// header, keeping an earlier deadline. An invalid time is ignored, the
+// request being handled as one without it
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(Header)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d, ok := Parse(v)
+		if !ok {
+			logging.FromContext(r.Context()).Debugf(
+				"Invalid %s %q ignored", Header, v)
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Inject sets the time left before the deadline of the context, rounded up
+// to the millisecond, on the headers of an outbound request. It is set on
+// every attempt, a retry carrying the time left after the backoff rather
+// than the time of the first attempt. A shorter time already set, e.g. by
+// the caller, is kept. Nothing is set without a deadline or when the time
+// left is longer than the header carries
+func Inject(ctx context.Context, h http.Header) {
+	t, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	left := time.Until(t)
+	ms := (left + time.Millisecond - 1) / time.Millisecond
+	switch {
+	case ms > maxMillis:
+		return
+	case ms < 1:
+		ms = 1
+	}
+	if d, ok := Parse(h.Get(Header)); ok && d < ms*time.Millisecond {
+		return
+	}
+	h.Set(Header, strconv.FormatInt(int64(ms), 10))
+}
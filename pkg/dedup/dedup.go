@@ -0,0 +1,147 @@
+// Package dedup recognizes the callbacks received again within a window,
+// e.g. sent again by a peer that missed the answer, so that they are
+// acknowledged without being handled twice. The callbacks handled are kept
+// in the store, so that the replicas sharing it recognize them as well
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+)
+
+const (
+	collection    = "dedup"
+	defaultWindow = 60000
+)
+
+// Values of the keys, of the callbacks in flight and handled
+var (
+	inFlight = []byte("0")
+	handled  = []byte("1")
+)
+
+// Status is the status of a callback received, told by Begin
+type Status int
+
+const (
+	// Reserved callbacks are new, reserved as in flight to be handled
+	Reserved Status = iota
+	// Duplicate callbacks were handled within the window, to be
+	// acknowledged
+	Duplicate
+	// InFlight callbacks are being handled by another delivery, which may
+	// still fail: the sender is to retry them
+	InFlight
+)
+
+var duplicates = metrics.NewCounterVec("nf_callbacks_duplicate_total",
+	"Callbacks received again within the deduplication window, by route.",
+	"route")
+
+// Window recognizes the callbacks handled within the window. A nil Window
+// sees no duplicate
+type Window struct {
+	st      store.Store
+	window  time.Duration
+	content bool
+}
+
+// New returns the window of the configuration keeping the callbacks in the
+// store
+func New(cfg config.DedupConfig, st store.Store) (*Window, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	d := &Window{st: st, window: time.Duration(cfg.Window) * time.Millisecond,
+		content: cfg.Key == config.DedupContent}
+	if d.window == 0 {
+		d.window = defaultWindow * time.Millisecond
+	}
+	return d, nil
+}
+
+// Key returns the deduplication key of the callback: its correlation ID,
+// or the SHA-256 of its JSON encoding
+func (d *Window) Key(correlationID string, callback interface{}) string {
+	if d == nil || !d.content {
+		return "id:" + correlationID
+	}
+	data, err := json.Marshal(callback)
+	if err != nil {
+		return "id:" + correlationID
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Begin reserves the callback of the key as in flight for the window,
+// unless it was handled or is being handled. The reservation is atomic, so
+// that only one of the deliveries of a callback received at once is
+// handled. It returns Reserved for a new callback, Duplicate for one
+// handled, counted for the route, and InFlight for one being handled, whose
+// delivery may still fail and be released. A failing store sees no
+// duplicate, the callback being handled again rather than lost
+func (d *Window) Begin(ctx context.Context, route, key string) Status {
+	if d == nil {
+		return Reserved
+	}
+	l := logging.FromContext(ctx)
+	/* a reservation released between Create and Get is taken again */
+	for attempt := 0; attempt < 2; attempt++ {
+		created, err := d.st.Create(ctx, collection, key, inFlight, d.window)
+		if err != nil {
+			l.Warnf("Duplicate callbacks not checked: %v", err)
+			return Reserved
+		}
+		if created {
+			return Reserved
+		}
+		value, err := d.st.Get(ctx, collection, key)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			continue
+		case err != nil:
+			l.Warnf("Duplicate callbacks not checked: %v", err)
+			return Reserved
+		case bytes.Equal(value, handled):
+			duplicates.WithLabelValues(route).Inc()
+			return Duplicate
+		}
+		return InFlight
+	}
+	return InFlight
+}
+
+// Handled records the callback reserved by Begin as handled for the window
+func (d *Window) Handled(ctx context.Context, key string) {
+	if d == nil {
+		return
+	}
+	if err := d.st.Put(ctx, collection, key, handled,
+		d.window); err != nil {
+		logging.FromContext(ctx).Warnf("Callback not recorded for the "+
+			"deduplication: %v", err)
+	}
+}
+
+// Release drops the reservation of a callback that could not be handled,
+// so that it is handled when it is delivered again
+func (d *Window) Release(ctx context.Context, key string) {
+	if d == nil {
+		return
+	}
+	if _, err := d.st.Delete(ctx, collection, key); err != nil {
+		logging.FromContext(ctx).Warnf("Callback reservation not released: "+
+			"%v", err)
+	}
+}
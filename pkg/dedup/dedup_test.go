@@ -0,0 +1,80 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+)
+
+func newWindow(t *testing.T) *Window {
+	t.Helper()
+	d, err := New(config.DedupConfig{Enabled: true}, store.NewMemory())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return d
+}
+
+func TestBegin(t *testing.T) {
+	ctx := context.Background()
+	d := newWindow(t)
+	key := d.Key("c1", nil)
+	if got := d.Begin(ctx, "/r", key); got != Reserved {
+		t.Fatalf("first delivery: got %d, want Reserved", got)
+	}
+	if got := d.Begin(ctx, "/r", key); got != InFlight {
+		t.Fatalf("delivery while handled: got %d, want InFlight", got)
+	}
+	d.Handled(ctx, key)
+	if got := d.Begin(ctx, "/r", key); got != Duplicate {
+		t.Fatalf("delivery once handled: got %d, want Duplicate", got)
+	}
+	if got := d.Begin(ctx, "/r", d.Key("c2", nil)); got != Reserved {
+		t.Fatalf("other callback: got %d, want Reserved", got)
+	}
+}
+
+// TestBeginFirstFails checks that a delivery received while the first one
+// is handled, which then fails, is handled once retried rather than lost
+func TestBeginFirstFails(t *testing.T) {
+	ctx := context.Background()
+	d := newWindow(t)
+	key := d.Key("c1", nil)
+	if got := d.Begin(ctx, "/r", key); got != Reserved {
+		t.Fatalf("first delivery: got %d, want Reserved", got)
+	}
+	var wg sync.WaitGroup
+	statuses := make([]Status, 8)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = d.Begin(ctx, "/r", key)
+		}(i)
+	}
+	wg.Wait()
+	for i, got := range statuses {
+		if got != InFlight {
+			t.Fatalf("concurrent delivery %d: got %d, want InFlight", i, got)
+		}
+	}
+	/* the first delivery fails */
+	d.Release(ctx, key)
+	if got := d.Begin(ctx, "/r", key); got != Reserved {
+		t.Fatalf("retried delivery: got %d, want Reserved", got)
+	}
+	d.Handled(ctx, key)
+	if got := d.Begin(ctx, "/r", key); got != Duplicate {
+		t.Fatalf("delivery once handled: got %d, want Duplicate", got)
+	}
+}
+
+func TestNilWindow(t *testing.T) {
+	var d *Window
+	if got := d.Begin(context.Background(), "/r", "k"); got != Reserved {
+		t.Fatalf("got %d, want Reserved", got)
+	}
+}
@@ -0,0 +1,254 @@
+// Package discovery looks up the peer NF instances in the NRF
+// (Nnrf_NFDiscovery) and caches the results
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/nrfclient"
+)
+
+const (
+	nfInstancesPath = "/nnrf-disc/v1/nf-instances"
+	defaultCacheTTL = 300
+)
+
+// Query identifies the NF instances searched in the NRF
+type Query struct {
+	TargetNfType    string
+	RequesterNfType string
+	ServiceName     string
+}
+
+// Instance is a discovered peer NF instance
+type Instance struct {
+	NfInstanceID string
+	// API root of the service, e.g. https://10.0.0.1:8090
+	APIRoot  string
+	Priority int
+	Capacity int
+}
+
+// SearchResult is the NRF discovery response body
+type SearchResult struct {
+	ValidityPeriod int                   `json:"validityPeriod"`
+	NfInstances    []nrfclient.NFProfile `json:"nfInstances"`
+}
+
+type entry struct {
+	instances []Instance
+	expiry    time.Time
+}
+
+// Discovery queries the NRF and caches the results per query
+type Discovery struct {
+	nrfAPIRoot string
+	ttl        time.Duration
+	client     *client.Client
+
+	mu    sync.Mutex
+	cache map[Query]entry
+}
+
+// New creates a Discovery querying the NRF located at nrfAPIRoot
+func New(nrfAPIRoot string, cfg config.DiscoveryConfig,
+	c *client.Client) *Discovery {
+	ttl := cfg.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &Discovery{
+		nrfAPIRoot: nrfAPIRoot,
+		ttl:        time.Duration(ttl) * time.Second,
+		client:     c,
+		cache:      make(map[Query]entry),
+	}
+}
+
+// Resolve returns the API roots of the instances matching the query. The
+// fallback API roots are returned when discovery fails or finds nothing
+func (d *Discovery) Resolve(ctx context.Context, q Query,
+	fallback ...string) []string {
+	instances, err := d.Discover(ctx, q)
+	if err != nil || len(instances) == 0 {
+		if err != nil {
+			logging.FromContext(ctx).Warnf(
+				"NF discovery failed, using configured endpoints: %v", err)
+		}
+		return fallback
+	}
+	roots := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		roots = append(roots, inst.APIRoot)
+	}
+	return roots
+}
+
+// Pick returns the API root of the instance matching the query chosen by
+// the balancer, and the function to call once the request sent to it
+// completed. The fallback API root is returned when discovery fails or
+// finds nothing
+func (d *Discovery) Pick(ctx context.Context, q Query, b client.Balancer,
+	fallback string) (string, func()) {
+	found, err := d.Discover(ctx, q)
+	if err != nil || len(found) == 0 {
+		if err != nil {
+			logging.FromContext(ctx).Warnf(
+				"NF discovery failed, using configured endpoints: %v", err)
+		}
+		return fallback, func() {}
+	}
+	candidates := make([]client.Instance, 0, len(found))
+	for _, inst := range found {
+		candidates = append(candidates, client.Instance{
+			APIRoot: inst.APIRoot, Priority: inst.Priority,
+			Capacity: inst.Capacity})
+	}
+	inst, done := b.Pick(candidates)
+	return inst.APIRoot, done
+}
+
+// Discover returns the instances matching the query, from the cache while
+// the previous result is valid and from the NRF otherwise
+func (d *Discovery) Discover(ctx context.Context,
+	q Query) ([]Instance, error) {
+	d.mu.Lock()
+	e, ok := d.cache[q]
+	d.mu.Unlock()
+	if ok && time.Now().Before(e.expiry) {
+		return e.instances, nil
+	}
+
+	result, err := d.search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	ttl := d.ttl
+	validity := time.Duration(result.ValidityPeriod) * time.Second
+	if validity > 0 && validity < ttl {
+		ttl = validity
+	}
+	e = entry{instances: instances(result, q.ServiceName),
+		expiry: time.Now().Add(ttl)}
+
+	d.mu.Lock()
+	d.cache[q] = e
+	d.mu.Unlock()
+	return e.instances, nil
+}
+
+// Invalidate drops the cached result of the query
+func (d *Discovery) Invalidate(q Query) {
+	d.mu.Lock()
+	delete(d.cache, q)
+	d.mu.Unlock()
+}
+
+func (d *Discovery) search(ctx context.Context,
+	q Query) (*SearchResult, error) {
+	params := url.Values{}
+	params.Set("target-nf-type", q.TargetNfType)
+	params.Set("requester-nf-type", q.RequesterNfType)
+	if q.ServiceName != "" {
+		params.Set("service-names", q.ServiceName)
+	}
+	req, err := http.NewRequest(http.MethodGet,
+		d.nrfAPIRoot+nfInstancesPath+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("NFDiscover returned %d: %s", resp.StatusCode,
+			body)
+	}
+	var result SearchResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// instances converts the discovered profiles to the API roots of the
+// requested service
+func instances(result *SearchResult, serviceName string) []Instance {
+	var found []Instance
+	for _, p := range result.NfInstances {
+		for _, svc := range p.NfServices {
+			if serviceName != "" && svc.ServiceName != serviceName {
+				continue
+			}
+			root := apiRoot(p, svc)
+			if root == "" {
+				continue
+			}
+			inst := Instance{NfInstanceID: p.NfInstanceID, APIRoot: root,
+				Priority: p.Priority, Capacity: p.Capacity}
+			if svc.Priority != 0 {
+				inst.Priority = svc.Priority
+			}
+			if svc.Capacity != 0 {
+				inst.Capacity = svc.Capacity
+			}
+			found = append(found, inst)
+		}
+	}
+	return found
+}
+
+func apiRoot(p nrfclient.NFProfile, svc nrfclient.NFService) string {
+	scheme := svc.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	host := svc.Fqdn
+	port := 0
+	if len(svc.IPEndPoints) > 0 {
+		ep := svc.IPEndPoints[0]
+		port = ep.Port
+		if ep.Ipv4Address != "" {
+			host = ep.Ipv4Address
+		} else if ep.Ipv6Address != "" {
+			host = ep.Ipv6Address
+		}
+	}
+	if host == "" {
+		host = p.Fqdn
+	}
+	if host == "" && len(p.Ipv4Addresses) > 0 {
+		host = p.Ipv4Addresses[0]
+	}
+	if host == "" && len(p.Ipv6Addresses) > 0 {
+		host = p.Ipv6Addresses[0]
+	}
+	if host == "" {
+		return ""
+	}
+	if port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+		host = "[" + host + "]"
+	}
+	return scheme + "://" + host + svc.APIPrefix
+}
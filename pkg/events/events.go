@@ -0,0 +1,374 @@
+// Package events streams the NF events to subscribers with Server-Sent
+// Events. Each subscriber selects the events with query filters and
+// resumes its stream after a reconnection with the Last-Event-ID header,
+// from the history of the past events
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+const (
+	// DefaultPath is the stream path used when the NF configures none
+	DefaultPath = "/events"
+
+	defaultHistory    = 256
+	defaultKeepAlive  = 15000
+	defaultBufferSize = 64
+)
+
+var (
+	published = metrics.NewCounterVec("nf_events_published_total",
+		"Events published to the SSE subscribers.", "event")
+	subscribers = metrics.NewGaugeVec("nf_events_subscribers",
+		"SSE subscribers connected.")
+	evicted = metrics.NewCounterVec("nf_events_evicted_total",
+		"SSE subscribers disconnected for not reading their stream fast "+
+			"enough.")
+)
+
+// Event is an event of the stream
+type Event struct {
+	ID   uint64
+	Type string
+	// Data is the JSON encoding of the event data
+	Data []byte
+
+	// fields are the top level scalar fields of Data, for the filters
+	fields map[string]string
+}
+
+// filter selects the events sent to a subscriber: the event types and the
+// values of top level fields of the data, all the events when empty
+type filter struct {
+	types  map[string]bool
+	fields map[string]string
+}
+
+func (f filter) matches(e *Event) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	for k, v := range f.fields {
+		if e.fields[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFilter reads the filter of the query: types lists the event types,
+// comma separated, and the other parameters are matched against the fields
+func parseFilter(r *http.Request) filter {
+	var f filter
+	for k, values := range r.URL.Query() {
+		switch k {
+		case "types":
+			for _, v := range values {
+				for _, t := range strings.Split(v, ",") {
+					if t = strings.TrimSpace(t); t != "" {
+						if f.types == nil {
+							f.types = make(map[string]bool)
+						}
+						f.types[t] = true
+					}
+				}
+			}
+		case "lastEventId":
+		default:
+			if f.fields == nil {
+				f.fields = make(map[string]string)
+			}
+			f.fields[k] = values[0]
+		}
+	}
+	return f
+}
+
+type subscriber struct {
+	filter filter
+	ch     chan *Event
+	// gone is closed when the subscriber is evicted
+	gone chan struct{}
+}
+
+// Hub publishes the events to the subscribers of its stream. It is the
+// http.Handler of the stream
+type Hub struct {
+	keepAlive time.Duration
+	retry     int
+	buffer    int
+	max       int
+
+	mu      sync.Mutex
+	nextID  uint64
+	history []*Event
+	// head is the index of the oldest event once the history is full
+	head   int
+	size   int
+	subs   map[*subscriber]struct{}
+	closed chan struct{}
+}
+
+// New creates a hub with the settings of the configuration
+func New(cfg config.EventsConfig) *Hub {
+	h := &Hub{
+		keepAlive: time.Duration(cfg.KeepAlive) * time.Millisecond,
+		retry:     cfg.Retry,
+		buffer:    cfg.BufferSize,
+		max:       cfg.MaxSubscribers,
+		size:      cfg.History,
+		nextID:    1,
+		subs:      make(map[*subscriber]struct{}),
+		closed:    make(chan struct{}),
+	}
+	if h.keepAlive <= 0 {
+		h.keepAlive = defaultKeepAlive * time.Millisecond
+	}
+	if h.buffer <= 0 {
+		h.buffer = defaultBufferSize
+	}
+	if h.size <= 0 {
+		h.size = defaultHistory
+	}
+	return h
+}
+
+// Publish sends the event to the subscribers whose filter matches it. The
+// data is encoded in JSON once for all of them. A subscriber whose buffer
+// is full is disconnected, it resumes from the history when reconnecting
+func (h *Hub) Publish(eventType string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		logging.Warnf("Event %s not published: %v", eventType, err)
+		return
+	}
+	e := &Event{Type: eventType, Data: b, fields: topFields(b)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e.ID = h.nextID
+	h.nextID++
+	if len(h.history) < h.size {
+		h.history = append(h.history, e)
+	} else {
+		h.history[h.head] = e
+		h.head = (h.head + 1) % h.size
+	}
+	published.WithLabelValues(eventType).Inc()
+	for s := range h.subs {
+		if !s.filter.matches(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			logging.Warnf("Events subscriber too slow, disconnected")
+			evicted.WithLabelValues().Inc()
+			h.remove(s)
+			close(s.gone)
+		}
+	}
+}
+
+// topFields returns the top level scalar fields of a JSON object
+func topFields(data []byte) map[string]string {
+	var obj map[string]interface{}
+	if json.Unmarshal(data, &obj) != nil {
+		return nil
+	}
+	fields := make(map[string]string, len(obj))
+	for k, v := range obj {
+		switch v := v.(type) {
+		case string:
+			fields[k] = v
+		case float64:
+			fields[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			fields[k] = strconv.FormatBool(v)
+		}
+	}
+	return fields
+}
+
+// since returns the events of the history published after the ID. gap
+// reports that some of them are not in the history anymore
+func (h *Hub) since(id uint64) (events []*Event, gap bool) {
+	n := len(h.history)
+	for i := 0; i < n; i++ {
+		e := h.history[(h.head+i)%n]
+		if e.ID > id {
+			if len(events) == 0 && e.ID > id+1 {
+				gap = true
+			}
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 && h.nextID > id+1 {
+		gap = true
+	}
+	return events, gap
+}
+
+// subscribe adds the subscriber and returns the events to replay after the
+// last event ID, when set
+func (h *Hub) subscribe(s *subscriber, lastID string) ([]*Event, bool,
+	*problem.Details) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+		return nil, false, problem.New(http.StatusServiceUnavailable, "",
+			"event stream closed")
+	default:
+	}
+	if h.max > 0 && len(h.subs) >= h.max {
+		return nil, false, problem.New(http.StatusServiceUnavailable,
+			problem.CauseNFCongestion, "too many event subscribers")
+	}
+	var replay []*Event
+	var gap bool
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			return nil, false, problem.New(http.StatusBadRequest, "",
+				fmt.Sprintf("invalid last event ID %q", lastID))
+		}
+		var events []*Event
+		events, gap = h.since(id)
+		for _, e := range events {
+			if s.filter.matches(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	h.subs[s] = struct{}{}
+	subscribers.WithLabelValues().Inc()
+	return replay, gap, nil
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// remove removes the subscriber, with the lock held
+func (h *Hub) remove(s *subscriber) {
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		subscribers.WithLabelValues().Dec()
+	}
+}
+
+// Close ends the open streams and refuses the new subscribers, so that the
+// servers can be drained
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+	default:
+		close(h.closed)
+	}
+}
+
+// ServeHTTP serves the event stream. The Last-Event-ID header, or the
+// lastEventId query parameter of the clients unable to set headers, replays
+// the events published since that event
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		problem.Error(w, http.StatusMethodNotAllowed, "",
+			r.Method+" not allowed")
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, "streaming not supported")
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	s := &subscriber{filter: parseFilter(r),
+		ch: make(chan *Event, h.buffer), gone: make(chan struct{})}
+	replay, gap, p := h.subscribe(s, lastID)
+	if p != nil {
+		problem.Write(w, p)
+		return
+	}
+	defer h.unsubscribe(s)
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	/* buffering proxies would hold the events back */
+	hdr.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if h.retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", h.retry)
+	}
+	if gap {
+		fmt.Fprintf(w, ": events missed since %s\n\n", lastID)
+	}
+	for _, e := range replay {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flush(w)
+
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closed:
+			return
+		case <-s.gone:
+			return
+		case e := <-s.ch:
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			/* write the events already waiting at once */
+			for n := len(s.ch); n > 0; n-- {
+				if err := writeEvent(w, <-s.ch); err != nil {
+					return
+				}
+			}
+			flush(w)
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flush(w)
+		}
+	}
+}
+
+// writeEvent writes the event in the text/event-stream format. The JSON
+// encoding of the data holds no newline
+func writeEvent(w http.ResponseWriter, e *Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID,
+		e.Type, e.Data)
+	return err
+}
+
+func flush(w http.ResponseWriter) {
+	w.(http.Flusher).Flush()
+}
@@ -0,0 +1,580 @@
+// Package exchanges keeps the history of the exchanges of the NF, who sent
+// what to whom, when, how fast and how it ended, in memory or in the NF
+// store. The history is queried with GET on the events path, filtered by
+// time range, peer and status, the newest exchanges first, in pages linked
+// with cursors:
+//
+//	GET /events?from=2024-05-01T10:00:00Z&peer=nf2&status=5xx&limit=20
+//	Accept: application/json
+//
+//	Link: </events?cursor=...&from=...&limit=20&peer=nf2&status=5xx>; rel="next"
+//
+//	{"items":[{"id":"...","side":"server","peer":"nf2",...}],"links":{"next":"..."}}
+package exchanges
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+// Sides of the exchanges
+const (
+	// SideServer is a request served by the NF
+	SideServer = "server"
+	// SideClient is a request sent by the NF to a peer
+	SideClient = "client"
+)
+
+// Outcomes of the exchanges
+const (
+	// Success is a response below 400
+	Success = "success"
+	// Rejected is a 4xx response
+	Rejected = "rejected"
+	// Failure is a 5xx response
+	Failure = "failure"
+	// Error is an exchange without a response: a request sent that failed
+	// or a request served whose handler panicked
+	Error = "error"
+)
+
+const (
+	defaultSize      = 1000
+	defaultRetention = 24 * 60 * 60
+	defaultLimit     = 100
+	maxLimit         = 1000
+	queueSize        = 256
+	// collection of the persisted exchanges, by ID
+	collection = "exchanges"
+)
+
+var (
+	kept = metrics.NewCounterVec("nf_exchanges_total",
+		"Exchanges kept in the history by side and outcome.", "side",
+		"outcome")
+	dropped = metrics.NewCounterVec("nf_exchanges_dropped_total",
+		"Exchanges not persisted, the store falling behind.")
+)
+
+// Summary describes a body without its content
+type Summary struct {
+	ContentType string `json:"contenttype,omitempty"`
+	// Size is the length of the body in bytes, -1 when unknown
+	Size int64 `json:"size"`
+}
+
+// Entry is an exchange of the history
+type Entry struct {
+	// ID orders the exchanges by the time they ended, it is the cursor of
+	// the pages
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Side string    `json:"side"`
+	// Server is the endpoint name (e.g. "API") of a request served
+	Server string `json:"server,omitempty"`
+	// Peer is the client of a request served, the common name of its
+	// certificate or else its address, the host:port of the peer of a
+	// request sent
+	Peer      string  `json:"peer"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	RequestID string  `json:"requestid,omitempty"`
+	Status    int     `json:"status,omitempty"`
+	Outcome   string  `json:"outcome"`
+	LatencyMs float64 `json:"latency_ms"`
+	Request   Summary `json:"request"`
+	Response  Summary `json:"response"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// outcome returns the outcome of an exchange ended with the status or the
+// error
+func outcome(status int, err string) string {
+	switch {
+	case err != "" || status == 0:
+		return Error
+	case status >= 500:
+		return Failure
+	case status >= 400:
+		return Rejected
+	}
+	return Success
+}
+
+// Log is the history of the exchanges. A nil Log or one disabled keeps
+// nothing
+type Log struct {
+	cfg   config.ExchangesConfig
+	store store.Store
+	ttl   time.Duration
+
+	mu   sync.Mutex
+	ring []Entry
+	// head is the index of the oldest exchange once the ring is full
+	head   int
+	size   int
+	queue  chan *Entry
+	closed bool
+	done   chan struct{}
+}
+
+// New creates the history of the configuration, persisted in st when it
+// says so
+func New(cfg config.ExchangesConfig, st store.Store) (*Log, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l := &Log{cfg: cfg, size: cfg.Size}
+	if l.size == 0 {
+		l.size = defaultSize
+	}
+	if cfg.Enabled && cfg.Persist {
+		if st == nil {
+			return nil, fmt.Errorf("exchanges.persist: no NF store")
+		}
+		retention := cfg.Retention
+		if retention == 0 {
+			retention = defaultRetention
+		}
+		l.store, l.ttl = st, time.Duration(retention)*time.Second
+		l.queue, l.done = make(chan *Entry, queueSize), make(chan struct{})
+		go l.persist()
+	}
+	return l, nil
+}
+
+func (l *Log) enabled() bool {
+	return l != nil && l.cfg.Enabled
+}
+
+// Close writes the exchanges waiting to be persisted
+func (l *Log) Close() error {
+	if l == nil || l.queue == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+	<-l.done
+	return nil
+}
+
+// persist writes the exchanges queued to the store, off the path of the
+// requests
+func (l *Log) persist() {
+	defer close(l.done)
+	for e := range l.queue {
+		if err := store.PutJSON(context.Background(), l.store, collection,
+			e.ID, e, l.ttl); err != nil {
+			logging.Warnf("Exchange %s %s not persisted: %v", e.Method,
+				e.Path, err)
+		}
+	}
+}
+
+// add keeps the exchange, or queues it to be persisted. An exchange is
+// dropped when the store falls behind
+func (l *Log) add(e *Entry) {
+	e.ID = fmt.Sprintf("%020d-%s", time.Now().UnixNano(), uuid.New()[:8])
+	e.Outcome = outcome(e.Status, e.Error)
+	kept.WithLabelValues(e.Side, e.Outcome).Inc()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.queue != nil {
+		if l.closed {
+			return
+		}
+		select {
+		case l.queue <- e:
+		default:
+			dropped.WithLabelValues().Inc()
+			logging.Warnf("Exchange %s %s not persisted: the store is "+
+				"falling behind", e.Method, e.Path)
+		}
+		return
+	}
+	if len(l.ring) < l.size {
+		l.ring = append(l.ring, *e)
+	} else {
+		l.ring[l.head] = *e
+		l.head = (l.head + 1) % l.size
+	}
+}
+
+// entries returns the exchanges kept, in no particular order
+func (l *Log) entries(ctx context.Context) ([]Entry, error) {
+	if l.store == nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return append([]Entry(nil), l.ring...), nil
+	}
+	values, err := l.store.List(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(values))
+	for key, data := range values {
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			logging.Warnf("Exchange %s of the store not decoded: %v", key,
+				err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Query selects the exchanges of a history query
+type Query struct {
+	// From and To bound the time of the exchanges, when not zero
+	From, To time.Time
+	// Peers selects the exchanges with one of the peers, all when empty
+	Peers []string
+	// Statuses selects the exchanges with one of the statuses: a status
+	// code (404), a class (5xx) or an outcome (error), all when empty
+	Statuses []string
+	// Side selects the requests served or sent, both when empty
+	Side string
+	// Cursor is the ID of the last exchange of the previous page
+	Cursor string
+	Limit  int
+}
+
+func (q *Query) match(e *Entry) bool {
+	if (!q.From.IsZero() && e.Time.Before(q.From)) ||
+		(!q.To.IsZero() && e.Time.After(q.To)) ||
+		(q.Side != "" && e.Side != q.Side) {
+		return false
+	}
+	if len(q.Peers) > 0 && !contains(q.Peers, e.Peer) {
+		return false
+	}
+	if len(q.Statuses) == 0 {
+		return true
+	}
+	code := strconv.Itoa(e.Status)
+	for _, s := range q.Statuses {
+		if s == e.Outcome || s == code ||
+			(e.Status > 0 && strings.HasSuffix(s, "xx") && s[0] == code[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns a page of the exchanges selected by q, the newest first,
+// and whether more follow it
+func (l *Log) Query(ctx context.Context, q Query) ([]Entry, bool, error) {
+	entries, err := l.entries(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	page := make([]Entry, 0, q.Limit)
+	for i := range entries {
+		e := &entries[i]
+		if (q.Cursor != "" && e.ID >= q.Cursor) || !q.match(e) {
+			continue
+		}
+		if len(page) == q.Limit {
+			return page, true, nil
+		}
+		page = append(page, *e)
+	}
+	return page, false, nil
+}
+
+// invalid returns the problem of an invalid query parameter
+func invalid(param, reason string) *problem.Details {
+	return problem.New(http.StatusBadRequest,
+		problem.CauseMandatoryIEIncorrect, "invalid "+param).
+		WithInvalidParams(problem.InvalidParam{Param: param, Reason: reason})
+}
+
+// list returns the comma separated values of the query parameter
+func list(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// validStatus tells whether s is a status code, a class or an outcome
+func validStatus(s string) bool {
+	switch s {
+	case Success, Rejected, Failure, Error:
+		return true
+	}
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' {
+		return false
+	}
+	if s[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// parseQuery reads the query of the request: from and to in RFC 3339,
+// peer and status with comma separated values, side, cursor and limit
+func parseQuery(r *http.Request) (Query, *problem.Details) {
+	values := r.URL.Query()
+	q := Query{Peers: list(values["peer"]), Side: values.Get("side"),
+		Cursor: values.Get("cursor"), Limit: defaultLimit}
+	for _, bound := range []struct {
+		param string
+		t     *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if v := values.Get(bound.param); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return q, invalid(bound.param,
+					fmt.Sprintf("%q is not an RFC 3339 time", v))
+			}
+			*bound.t = t
+		}
+	}
+	q.Statuses = list(values["status"])
+	for _, s := range q.Statuses {
+		if !validStatus(s) {
+			return q, invalid("status", fmt.Sprintf("%q is not a status "+
+				"code, a class (e.g. 5xx) or one of %s, %s, %s, %s", s,
+				Success, Rejected, Failure, Error))
+		}
+	}
+	if q.Side != "" && q.Side != SideServer && q.Side != SideClient {
+		return q, invalid("side", fmt.Sprintf("%q is neither %s nor %s",
+			q.Side, SideServer, SideClient))
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return q, invalid("limit", fmt.Sprintf("%q not in [1, %d]", v,
+				maxLimit))
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// page is the answer to a history query
+type page struct {
+	Items []Entry           `json:"items"`
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// queryKey marks the requests querying the history, not kept themselves
+type queryKey struct{}
+
+// wantsHistory tells whether the Accept header of the request asks for the
+// JSON history rather than the stream
+func wantsHistory(r *http.Request) bool {
+	for _, item := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err == nil && mt == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the history queries with GET. When stream is not nil, it
+// serves the requests not accepting application/json, e.g. the Server-Sent
+// Events stream of the same path. A disabled history serves only stream
+func (l *Log) Handler(stream http.Handler) http.Handler {
+	if !l.enabled() {
+		return stream
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stream != nil && !wantsHistory(r) {
+			stream.ServeHTTP(w, r)
+			return
+		}
+		if query, ok := r.Context().Value(queryKey{}).(*bool); ok {
+			*query = true
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+			return
+		}
+		q, p := parseQuery(r)
+		if p != nil {
+			problem.Write(w, p)
+			return
+		}
+		items, more, err := l.Query(r.Context(), q)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorf(
+				"Exchanges not queried: %v", err)
+			problem.Error(w, http.StatusInternalServerError,
+				problem.CauseSystemFailure, "history not available")
+			return
+		}
+		result := page{Items: items}
+		if more {
+			u := *r.URL
+			values := u.Query()
+			values.Set("cursor", items[len(items)-1].ID)
+			u.RawQuery = values.Encode()
+			next := u.RequestURI()
+			result.Links = map[string]string{"next": next}
+			w.Header().Set("Link", "<"+next+">; rel=\"next\"")
+		}
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(result)
+	})
+}
+
+// peer returns the identity of the client of a request served: the common
+// name of its certificate or else its address
+func peer(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if cn := r.TLS.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// countingBody counts the bytes of a request body read by the handler
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Middleware keeps the requests served by the named server once the
+// handler returned, except the history queries
+func (l *Log) Middleware(server string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !l.enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			query := new(bool)
+			r = r.WithContext(context.WithValue(r.Context(), queryKey{},
+				query))
+			e := Entry{Time: start, Side: SideServer, Server: server,
+				Peer: peer(r), Method: r.Method, Path: r.URL.Path,
+				RequestID: requestid.FromContext(r.Context()),
+				Request: Summary{ContentType: r.Header.Get("Content-Type"),
+					Size: r.ContentLength}}
+			var body *countingBody
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingBody{ReadCloser: r.Body}
+				r.Body = body
+			}
+			sw := &logging.StatusWriter{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if !*query {
+					if p != nil {
+						e.Error = fmt.Sprint(p)
+					}
+					e.Status = sw.Status
+					if e.Status == 0 && p == nil {
+						e.Status = http.StatusOK
+					}
+					if body != nil && e.Request.Size < 0 {
+						e.Request.Size = body.n
+					}
+					e.Response = Summary{
+						ContentType: w.Header().Get("Content-Type"),
+						Size:        int64(sw.Size)}
+					e.LatencyMs = millis(time.Since(start))
+					l.add(&e)
+				}
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// RoundTrip sends the request to the peer host:port with send and keeps
+// it with the response headers, the latency being the time to them
+func (l *Log) RoundTrip(req *http.Request, peer string,
+	send func(*http.Request) (*http.Response, error)) (*http.Response,
+	error) {
+	if !l.enabled() {
+		return send(req)
+	}
+	start := time.Now()
+	e := Entry{Time: start, Side: SideClient, Peer: peer, Method: req.Method,
+		Path: req.URL.Path, RequestID: req.Header.Get(requestid.Header),
+		Request: Summary{ContentType: req.Header.Get("Content-Type"),
+			Size: req.ContentLength}}
+	resp, err := send(req)
+	e.LatencyMs = millis(time.Since(start))
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		e.Status = resp.StatusCode
+		e.Response = Summary{ContentType: resp.Header.Get("Content-Type"),
+			Size: resp.ContentLength}
+	}
+	l.add(&e)
+	return resp, err
+}
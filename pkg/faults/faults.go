@@ -0,0 +1,271 @@
+// Package faults injects faults in the requests served and sent by the NF
+// for resilience testing: delays, error statuses, connection resets and
+// truncated bodies, in a share of the requests matched by the rules. The
+// rules are replaced at runtime through the admin API
+package faults
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+var injected = metrics.NewCounterVec("nf_injected_faults_total",
+	"Faults injected by side (server or client) and fault (delay, status, "+
+		"reset or truncate).", "side", "fault")
+
+// Injector applies the fault rules
+type Injector struct {
+	mu  sync.RWMutex
+	cfg config.FaultsConfig
+}
+
+// New creates an injector with the rules of cfg
+func New(cfg config.FaultsConfig) (*Injector, error) {
+	i := &Injector{}
+	if err := i.Set(cfg); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Set replaces the rules
+func (i *Injector) Set(cfg config.FaultsConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.cfg = cfg
+	i.mu.Unlock()
+	if cfg.Enabled {
+		logging.Warnf("Fault injection enabled: %d server and %d client "+
+			"rules", len(cfg.Server), len(cfg.Client))
+	}
+	return nil
+}
+
+// Config returns the rules in use
+func (i *Injector) Config() config.FaultsConfig {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.cfg
+}
+
+// match returns the first rule of the side matching the request that is
+// drawn to apply, false when none
+func (i *Injector) match(server bool, name, path string) (config.FaultRule,
+	bool) {
+	if i == nil {
+		return config.FaultRule{}, false
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.cfg.Enabled {
+		return config.FaultRule{}, false
+	}
+	rules := i.cfg.Client
+	if server {
+		rules = i.cfg.Server
+	}
+	for _, r := range rules {
+		target := r.Peer
+		if server {
+			target = r.Server
+		}
+		if (target != "" && target != name) ||
+			!strings.HasPrefix(path, r.Path) {
+			continue
+		}
+		return r, rand.Float64()*100 < r.Percent
+	}
+	return config.FaultRule{}, false
+}
+
+// delay waits the delay of the rule, false when the context ended first
+func delay(ctx context.Context, side string, r config.FaultRule) bool {
+	d := time.Duration(r.Delay) * time.Millisecond
+	if r.DelayJitter > 0 {
+		d += time.Duration(rand.Intn(r.DelayJitter+1)) * time.Millisecond
+	}
+	if d <= 0 {
+		return true
+	}
+	injected.WithLabelValues(side, "delay").Inc()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Middleware injects the server faults in the requests of the named
+// server. A reset aborts the handler, which closes the HTTP/1.1 connection
+// or resets the HTTP/2 stream
+func (i *Injector) Middleware(server string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule, ok := i.match(true, server, r.URL.Path)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !delay(r.Context(), "server", rule) {
+				return
+			}
+			switch {
+			case rule.Reset:
+				injected.WithLabelValues("server", "reset").Inc()
+				panic(http.ErrAbortHandler)
+			case rule.Status != 0:
+				injected.WithLabelValues("server", "status").Inc()
+				problem.Error(w, rule.Status, "", "injected fault")
+			case rule.Truncate > 0:
+				tw := &truncateWriter{ResponseWriter: w, left: rule.Truncate}
+				next.ServeHTTP(tw, r)
+				if tw.cut {
+					injected.WithLabelValues("server", "truncate").Inc()
+					/* sent as it is, then cut short */
+					_ = http.NewResponseController(w).Flush()
+					panic(http.ErrAbortHandler)
+				}
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// truncateWriter drops the response body after left bytes
+type truncateWriter struct {
+	http.ResponseWriter
+	left int
+	cut  bool
+}
+
+func (w *truncateWriter) Write(b []byte) (int, error) {
+	if len(b) > w.left {
+		w.cut = true
+		n, err := w.ResponseWriter.Write(b[:w.left])
+		w.left -= n
+		if err != nil {
+			return n, err
+		}
+		return len(b), nil
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.left -= n
+	return n, err
+}
+
+// Unwrap returns the underlying writer to the http.ResponseController
+func (w *truncateWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RoundTrip sends the request with send unless a client fault applies to
+// it: the error status is answered without sending it, the reset returns
+// a connection reset error and the truncated body ends with
+// io.ErrUnexpectedEOF
+func (i *Injector) RoundTrip(req *http.Request,
+	send func(*http.Request) (*http.Response, error)) (*http.Response,
+	error) {
+	rule, ok := i.match(false, req.URL.Host, req.URL.Path)
+	if !ok {
+		return send(req)
+	}
+	if !delay(req.Context(), "client", rule) {
+		return nil, req.Context().Err()
+	}
+	switch {
+	case rule.Reset:
+		injected.WithLabelValues("client", "reset").Inc()
+		return nil, &net.OpError{Op: "read", Net: "tcp",
+			Err: syscall.ECONNRESET}
+	case rule.Status != 0:
+		injected.WithLabelValues("client", "status").Inc()
+		body, _ := json.Marshal(problem.New(rule.Status, "",
+			"injected fault"))
+		return &http.Response{
+			Status:        http.StatusText(rule.Status),
+			StatusCode:    rule.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {problem.ContentType}},
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	resp, err := send(req)
+	if err == nil && rule.Truncate > 0 {
+		injected.WithLabelValues("client", "truncate").Inc()
+		resp.Body = &truncatedBody{ReadCloser: resp.Body,
+			left: int64(rule.Truncate)}
+		resp.ContentLength = -1
+	}
+	return resp, err
+}
+
+// truncatedBody ends the body with io.ErrUnexpectedEOF after left bytes
+type truncatedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+// Handler serves the rules on GET and replaces them on PUT, e.g. with
+// {"enabled": true, "client": [{"peer": "nf2:8090", "percent": 50,
+// "status": 503}]}
+func (i *Injector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var cfg config.FaultsConfig
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				problem.Write(w, problem.FromDecodeError(err))
+				return
+			}
+			if err := i.Set(cfg); err != nil {
+				problem.Error(w, http.StatusBadRequest,
+					problem.CauseMandatoryIEIncorrect, err.Error())
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(i.Config())
+	})
+}
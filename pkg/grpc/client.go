@@ -0,0 +1,214 @@
+package grpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+)
+
+// Streamer sends the streaming requests, e.g. a *client.Client. The
+// streaming RPCs are sent with Do when the Doer is not a Streamer
+type Streamer interface {
+	Stream(req *http.Request) (*http.Response, error)
+}
+
+// Client calls the RPCs of the NF service of a server
+type Client struct {
+	// Server is the scheme and host:port of the server, e.g.
+	// https://localhost:8090. Its path is ignored, the RPC paths are
+	// absolute
+	Server string
+	doer   api.Doer
+}
+
+// NewClient creates a client of the server sending the requests with doer
+func NewClient(server string, doer api.Doer) *Client {
+	return &Client{Server: server, doer: doer}
+}
+
+// RequestNF2Location sends the location request of NF1 to NF2
+func (c *Client) RequestNF2Location(ctx context.Context,
+	nf api.NF) (Ack, error) {
+	return c.unary(ctx, "RequestNF2Location", nf)
+}
+
+// ReportNF2Location sends the location callback of NF2 to NF1
+func (c *Client) ReportNF2Location(ctx context.Context,
+	nf api.NF) (Ack, error) {
+	return c.unary(ctx, "ReportNF2Location", nf)
+}
+
+func (c *Client) newRequest(ctx context.Context, method string,
+	body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = ServicePath + method
+	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", formatTimeout(time.Until(deadline)))
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (c *Client) unary(ctx context.Context, method string,
+	nf api.NF) (Ack, error) {
+	req, err := c.newRequest(ctx, method, bytes.NewReader(frame(
+		marshalNF(nf))))
+	if err != nil {
+		return Ack{}, err
+	}
+	resp, err := c.doer.Do(req)
+	if err != nil {
+		return Ack{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return Ack{}, err
+	}
+	r := bufio.NewReader(resp.Body)
+	msg, err := readMessage(r, DefaultMaxMessageSize)
+	if err != nil && err != io.EOF {
+		return Ack{}, err
+	}
+	/* the trailers are read with the end of the body */
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return Ack{}, err
+	}
+	if err := trailerStatus(resp); err != nil {
+		return Ack{}, err
+	}
+	if msg == nil {
+		return Ack{}, Errorf(Internal, "no response message")
+	}
+	ack, err := unmarshalAck(msg)
+	if err != nil {
+		return Ack{}, Errorf(Internal, "%v", err)
+	}
+	return ack, nil
+}
+
+// checkResponse returns the status of a response that is not a gRPC one,
+// or of a trailers-only response
+func checkResponse(resp *http.Response) error {
+	if resp.ProtoMajor != 2 {
+		return Errorf(Unavailable, "gRPC requires HTTP/2, got %s",
+			resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fromHTTP(resp.StatusCode)
+	}
+	if st, ok := parseStatus(resp.Header); ok && st.Code != OK {
+		return st
+	}
+	return nil
+}
+
+// trailerStatus returns the error of the status of the trailers
+func trailerStatus(resp *http.Response) error {
+	st, ok := parseStatus(resp.Trailer)
+	if !ok {
+		st, ok = parseStatus(resp.Header)
+	}
+	if !ok {
+		return Errorf(Internal, "no grpc-status in the response")
+	}
+	if st.Code != OK {
+		return st
+	}
+	return nil
+}
+
+// ClientStream is the client side of the ExchangeLocations stream
+type ClientStream struct {
+	resp *http.Response
+	r    *bufio.Reader
+	pw   *io.PipeWriter
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// ExchangeLocations opens the stream of location messages: each message
+// sent is answered with the location of the server. The stream ends when
+// the context is canceled or after CloseSend once the answers are read
+func (c *Client) ExchangeLocations(ctx context.Context) (*ClientStream,
+	error) {
+	pr, pw := io.Pipe()
+	req, err := c.newRequest(ctx, "ExchangeLocations", pr)
+	if err != nil {
+		return nil, err
+	}
+	var resp *http.Response
+	if s, ok := c.doer.(Streamer); ok {
+		resp, err = s.Stream(req)
+	} else {
+		resp, err = c.doer.Do(req)
+	}
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		pw.Close()
+		resp.Body.Close()
+		return nil, err
+	}
+	return &ClientStream{resp: resp, r: bufio.NewReader(resp.Body),
+		pw: pw}, nil
+}
+
+// Send sends a message to the server
+func (s *ClientStream) Send(nf api.NF) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("grpc: send on a closed stream")
+	}
+	_, err := s.pw.Write(frame(marshalNF(nf)))
+	return err
+}
+
+// CloseSend ends the messages sent to the server
+func (s *ClientStream) CloseSend() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.pw.Close()
+}
+
+// Recv returns the next answer of the server. It returns io.EOF once the
+// server ended the stream with the OK status, the status error otherwise
+func (s *ClientStream) Recv() (api.NF, error) {
+	msg, err := readMessage(s.r, DefaultMaxMessageSize)
+	if err == io.EOF {
+		s.resp.Body.Close()
+		if err := trailerStatus(s.resp); err != nil {
+			return api.NF{}, err
+		}
+		return api.NF{}, io.EOF
+	}
+	if err != nil {
+		s.resp.Body.Close()
+		return api.NF{}, err
+	}
+	nf, err := unmarshalNF(msg)
+	if err != nil {
+		return api.NF{}, Errorf(Internal, "%v", err)
+	}
+	return nf, nil
+}
@@ -0,0 +1,138 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+)
+
+// Protocol buffers wire types
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+// Ack acknowledges a location message
+type Ack struct {
+	Message string
+}
+
+// marshalNF encodes the NF message of api/nf.proto
+func marshalNF(nf api.NF) []byte {
+	var b []byte
+	b = appendString(b, 1, nf.CorrelationID)
+	b = appendString(b, 2, nf.Location)
+	b = appendString(b, 3, nf.Time.String())
+	return b
+}
+
+// unmarshalNF decodes the NF message, rejecting an unparseable time
+func unmarshalNF(data []byte) (api.NF, error) {
+	var nf api.NF
+	var timeErr error
+	err := parseFields(data, func(field int, value []byte) {
+		switch field {
+		case 1:
+			nf.CorrelationID = string(value)
+		case 2:
+			nf.Location = string(value)
+		case 3:
+			nf.Time, timeErr = api.ParseTime(string(value))
+		}
+	})
+	if err == nil && timeErr != nil {
+		err = fmt.Errorf("protobuf: time: %v", timeErr)
+	}
+	return nf, err
+}
+
+func marshalAck(ack Ack) []byte {
+	return appendString(nil, 1, ack.Message)
+}
+
+func unmarshalAck(data []byte) (Ack, error) {
+	var ack Ack
+	err := parseFields(data, func(field int, value []byte) {
+		if field == 1 {
+			ack.Message = string(value)
+		}
+	})
+	return ack, err
+}
+
+// appendString appends a string field, omitted when empty as in proto3
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func readVarint(data []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errTruncated
+}
+
+// parseFields calls fn with the length delimited fields of the message,
+// skipping the fields of the other wire types
+func parseFields(data []byte, fn func(field int, value []byte)) error {
+	for len(data) > 0 {
+		key, n, err := readVarint(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		field, wire := int(key>>3), key&7
+		if field == 0 {
+			return errors.New("protobuf: invalid field number 0")
+		}
+		switch wire {
+		case wireVarint:
+			if _, n, err = readVarint(data); err != nil {
+				return err
+			}
+		case wire64:
+			n = 8
+		case wire32:
+			n = 4
+		case wireBytes:
+			size, m, err := readVarint(data)
+			if err != nil {
+				return err
+			}
+			if size > uint64(len(data)-m) {
+				return errTruncated
+			}
+			fn(field, data[m:m+int(size)])
+			n = m + int(size)
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", wire)
+		}
+		if n > len(data) {
+			return errTruncated
+		}
+		data = data[n:]
+	}
+	return nil
+}
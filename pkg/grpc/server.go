@@ -0,0 +1,288 @@
+// Package grpc offers the NF location exchange as the gRPC service of
+// api/nf.proto, served by the NF HTTP/2 servers along with the REST
+// operations and called with the NF client, so that they share the TLS
+// settings, the middleware and the peer settings. Only the identity
+// message encoding is supported
+package grpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+// ServicePath is the path prefix of the RPCs of the NF service
+const ServicePath = "/nfservice.v1.NFService/"
+
+// DefaultMaxMessageSize bounds the messages received when the configuration
+// sets no limit
+const DefaultMaxMessageSize = 4 << 20
+
+// ContentType of the gRPC requests and responses
+const ContentType = "application/grpc"
+
+// Handlers implement the RPCs of the NF service. The RPCs without handler
+// answer Unimplemented. A handler returns a *Status error to choose the
+// status code, Unknown is answered for the other errors
+type Handlers struct {
+	RequestNF2Location func(ctx context.Context, nf api.NF) (Ack, error)
+	ReportNF2Location  func(ctx context.Context, nf api.NF) (Ack, error)
+	ExchangeLocations  func(ctx context.Context, stream *ServerStream) error
+}
+
+// Server is the http.Handler of the NF service RPCs, registered for
+// ServicePath
+type Server struct {
+	handlers   Handlers
+	maxMessage int
+}
+
+// NewServer creates the server of the handlers, receiving messages of at
+// most maxMessage bytes, DefaultMaxMessageSize when 0
+func NewServer(h Handlers, maxMessage int) *Server {
+	if maxMessage <= 0 {
+		maxMessage = DefaultMaxMessageSize
+	}
+	return &Server{handlers: h, maxMessage: maxMessage}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2",
+			http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, r.Method+" not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != ContentType &&
+		!strings.HasPrefix(ct, ContentType+"+") &&
+		!strings.HasPrefix(ct, ContentType+";") {
+		http.Error(w, "unsupported content type "+ct,
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	ctx := r.Context()
+	if t := r.Header.Get("Grpc-Timeout"); t != "" {
+		d, err := parseTimeout(t)
+		if err != nil {
+			s.finish(w, Errorf(Internal, "%v", err))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		w.Header().Set("Grpc-Accept-Encoding", "identity")
+		s.finish(w, Errorf(Unimplemented, "unsupported encoding %s", enc))
+		return
+	}
+	stream := &ServerStream{w: w, r: bufio.NewReader(r.Body),
+		max: s.maxMessage}
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, ServicePath) {
+	case "RequestNF2Location":
+		err = s.unary(ctx, stream, s.handlers.RequestNF2Location)
+	case "ReportNF2Location":
+		err = s.unary(ctx, stream, s.handlers.ReportNF2Location)
+	case "ExchangeLocations":
+		if s.handlers.ExchangeLocations == nil {
+			err = Errorf(Unimplemented, "%s not implemented", r.URL.Path)
+			break
+		}
+		/* the headers are sent at once, the client waits for them */
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		err = s.handlers.ExchangeLocations(ctx, stream)
+	default:
+		err = Errorf(Unimplemented, "unknown method %s", r.URL.Path)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warnf("gRPC %s: %v", r.URL.Path, err)
+	}
+	s.finish(w, statusOf(err))
+}
+
+func (s *Server) unary(ctx context.Context, stream *ServerStream,
+	h func(ctx context.Context, nf api.NF) (Ack, error)) error {
+	if h == nil {
+		return Errorf(Unimplemented, "method not implemented")
+	}
+	nf, err := stream.Recv()
+	if err == io.EOF {
+		return Errorf(Internal, "no request message")
+	}
+	if err != nil {
+		return err
+	}
+	ack, err := h(ctx, nf)
+	if err != nil {
+		return err
+	}
+	return stream.send(marshalAck(ack))
+}
+
+// finish sends the status in the trailers
+func (s *Server) finish(w http.ResponseWriter, st *Status) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status",
+		strconv.Itoa(int(st.Code)))
+	if st.Message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message",
+			encodeMessage(st.Message))
+	}
+}
+
+// ServerStream receives the NF messages of a streaming RPC and sends its
+// answers
+type ServerStream struct {
+	w   http.ResponseWriter
+	r   *bufio.Reader
+	max int
+}
+
+// Recv returns the next message of the client, io.EOF once the client
+// closed its side of the stream
+func (s *ServerStream) Recv() (api.NF, error) {
+	msg, err := readMessage(s.r, s.max)
+	if err != nil {
+		return api.NF{}, err
+	}
+	nf, err := unmarshalNF(msg)
+	if err != nil {
+		return api.NF{}, Errorf(Internal, "%v", err)
+	}
+	return nf, nil
+}
+
+// Send sends a message to the client
+func (s *ServerStream) Send(nf api.NF) error {
+	return s.send(marshalNF(nf))
+}
+
+func (s *ServerStream) send(msg []byte) error {
+	if _, err := s.w.Write(frame(msg)); err != nil {
+		return err
+	}
+	s.w.(http.Flusher).Flush()
+	return nil
+}
+
+// frame prefixes the message with its uncompressed flag and length
+func frame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// readMessage reads a length prefixed message, io.EOF at the end of the
+// stream
+func readMessage(r io.Reader, max int) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, Errorf(Internal, "truncated message")
+		}
+		return nil, err
+	}
+	if head[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages not supported")
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if uint64(n) > uint64(max) {
+		return nil, Errorf(ResourceExhausted,
+			"message of %d bytes larger than %d", n, max)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, Errorf(Internal, "truncated message")
+	}
+	return msg, nil
+}
+
+// parseTimeout parses a grpc-timeout value, e.g. 100m
+func parseTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute,
+		'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond,
+		'n': time.Nanosecond}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid grpc-timeout unit %q", v)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// formatTimeout formats a grpc-timeout value of at most 8 digits
+func formatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "0n"
+	}
+	for _, u := range []struct {
+		unit byte
+		d    time.Duration
+	}{{'n', time.Nanosecond}, {'u', time.Microsecond},
+		{'m', time.Millisecond}, {'S', time.Second}, {'M', time.Minute}} {
+		if n := d / u.d; n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + string(u.unit)
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Hour), 10) + "H"
+}
+
+// encodeMessage percent encodes a grpc-message value
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// AnswerLocations returns an ExchangeLocations handler answering each
+// message with the location, sent with the correlation ID of the message
+// and the current time
+func AnswerLocations(location string) func(ctx context.Context,
+	stream *ServerStream) error {
+	return func(ctx context.Context, stream *ServerStream) error {
+		for {
+			nf, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := stream.Send(api.NF{CorrelationID: nf.CorrelationID,
+				Location: location, Time: api.Now()}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Code is a gRPC status code
+type Code int
+
+// The gRPC status codes used by the NFs
+const (
+	OK                Code = 0
+	Canceled          Code = 1
+	Unknown           Code = 2
+	InvalidArgument   Code = 3
+	DeadlineExceeded  Code = 4
+	NotFound          Code = 5
+	PermissionDenied  Code = 7
+	ResourceExhausted Code = 8
+	Unimplemented     Code = 12
+	Internal          Code = 13
+	Unavailable       Code = 14
+	Unauthenticated   Code = 16
+)
+
+// Status is the error of a failed RPC
+type Status struct {
+	Code    Code
+	Message string
+}
+
+// Errorf returns the status of the code with a formatted message
+func Errorf(code Code, format string, args ...interface{}) *Status {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", s.Code, s.Message)
+}
+
+// statusOf returns the status of an error returned by a handler
+func statusOf(err error) *Status {
+	var s *Status
+	switch {
+	case err == nil:
+		return &Status{Code: OK}
+	case errors.As(err, &s):
+		return s
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Status{Code: DeadlineExceeded, Message: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &Status{Code: Canceled, Message: err.Error()}
+	}
+	return &Status{Code: Unknown, Message: err.Error()}
+}
+
+// fromHTTP returns the status of an HTTP error answered instead of a gRPC
+// response, e.g. by a proxy or the rate limits of the server
+func fromHTTP(status int) *Status {
+	code := Unknown
+	switch status {
+	case http.StatusBadRequest:
+		code = Internal
+	case http.StatusUnauthorized:
+		code = Unauthenticated
+	case http.StatusForbidden:
+		code = PermissionDenied
+	case http.StatusNotFound:
+		code = Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		code = Unavailable
+	}
+	return &Status{Code: code, Message: fmt.Sprintf("HTTP status %d %s",
+		status, http.StatusText(status))}
+}
+
+// parseStatus reads the grpc-status and grpc-message of the trailers, or
+// of the headers of a trailers-only response
+func parseStatus(h http.Header) (*Status, bool) {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		return nil, false
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil {
+		return &Status{Code: Unknown, Message: "invalid grpc-status " + v},
+			true
+	}
+	msg, err := url.PathUnescape(h.Get("Grpc-Message"))
+	if err != nil {
+		msg = h.Get("Grpc-Message")
+	}
+	return &Status{Code: Code(code), Message: msg}, true
+}
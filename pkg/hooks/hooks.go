@@ -0,0 +1,252 @@
+// Package hooks transforms the payloads of the NF messages with functions
+// the deployment registers by name, e.g. to add vendor-specific attributes
+// or strip personal data, without changing the handlers. The configuration
+// applies them to the messages the client and the servers send (pre-send)
+// and receive (post-receive). Go plugins loaded at startup register their
+// hooks from their init functions
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"plugin"
+	"sort"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+// Stages of the messages the hooks apply to
+const (
+	PreSend     = "presend"
+	PostReceive = "postreceive"
+)
+
+var calls = metrics.NewCounterVec("nf_hook_calls_total",
+	"Payload transformation hook calls by hook, stage and result: ok or "+
+		"error.", "hook", "stage", "result")
+
+// Message is the NF message a hook transforms
+type Message struct {
+	// Stage is PreSend or PostReceive
+	Stage string
+	// Method and Path of the request, set for the responses too
+	Method string
+	Path   string
+	// Peer is the host:port of the peer for the client, the peer NF of the
+	// request for the servers
+	Peer string
+	// Status of a response, 0 for a request
+	Status int
+	// Header of the message, which the hook may change
+	Header http.Header
+	// Body is the JSON payload decoded, numbers as json.Number, which the
+	// hook changes or replaces. It is nil without body or for the media
+	// types other than JSON and CBOR
+	Body interface{}
+}
+
+// Func transforms a message. An error fails the exchange of the message
+type Func func(ctx context.Context, m *Message) error
+
+var (
+	mu    sync.RWMutex
+	funcs = map[string]Func{}
+	// plugins holds the paths of the plugins loaded
+	plugins = map[string]bool{}
+)
+
+// Register adds the hook to the registry, replacing the hook of the same
+// name
+func Register(name string, fn Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	funcs[name] = fn
+}
+
+// Names returns the names of the registered hooks, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hooks are the hooks applied by a configuration
+type Hooks struct {
+	preSend     []hook
+	postReceive []hook
+}
+
+type hook struct {
+	name   string
+	fn     Func
+	peers  []string
+	routes []string
+}
+
+// New loads the plugins of the configuration and returns its hooks, nil
+// when it applies none. The hooks must be registered
+func New(cfg config.HooksConfig) (*Hooks, error) {
+	for _, path := range cfg.Plugins {
+		if err := load(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.PreSend) == 0 && len(cfg.PostReceive) == 0 {
+		return nil, nil
+	}
+	h := &Hooks{}
+	var err error
+	if h.preSend, err = lookup(PreSend, cfg.PreSend); err != nil {
+		return nil, err
+	}
+	if h.postReceive, err = lookup(PostReceive, cfg.PostReceive); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// load opens the plugin, which registers its hooks, once
+func load(path string) error {
+	mu.RLock()
+	loaded := plugins[path]
+	mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	/* the init functions of the plugin call Register */
+	if _, err := plugin.Open(path); err != nil {
+		return fmt.Errorf("plugin %s: %v", path, err)
+	}
+	mu.Lock()
+	plugins[path] = true
+	mu.Unlock()
+	logging.Infof("Loaded hooks plugin %s", path)
+	return nil
+}
+
+// lookup returns the registered hooks of the configurations of the stage
+func lookup(stage string, cfgs []config.HookConfig) ([]hook, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	hooks := make([]hook, 0, len(cfgs))
+	for i, c := range cfgs {
+		fn, ok := funcs[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: unknown hook %q", stage, i, c.Name)
+		}
+		hooks = append(hooks, hook{name: c.Name, fn: fn, peers: c.Peers,
+			routes: c.Routes})
+	}
+	return hooks, nil
+}
+
+// Peer returns the hooks of the stage applying to the messages the client
+// exchanges with the peer
+func (h *Hooks) Peer(stage, peer string) Chain {
+	return h.selectHooks(stage, func(k hook) bool {
+		if len(k.peers) == 0 {
+			return len(k.routes) == 0
+		}
+		return contains(k.peers, peer)
+	})
+}
+
+// Route returns the hooks of the stage applying to the messages of the
+// route pattern of the servers
+func (h *Hooks) Route(stage, pattern string) Chain {
+	return h.selectHooks(stage, func(k hook) bool {
+		if len(k.routes) == 0 {
+			return len(k.peers) == 0
+		}
+		return contains(k.routes, pattern)
+	})
+}
+
+func (h *Hooks) selectHooks(stage string, match func(hook) bool) Chain {
+	if h == nil {
+		return nil
+	}
+	all := h.preSend
+	if stage == PostReceive {
+		all = h.postReceive
+	}
+	var c Chain
+	for _, k := range all {
+		if match(k) {
+			c = append(c, k)
+		}
+	}
+	return c
+}
+
+// Chain is the sequence of hooks applied to a message
+type Chain []hook
+
+// Transform applies the hooks in turn to the message, whose body data is
+// in the media type contentType, and returns the body encoded again. The
+// bodies that are empty, neither JSON nor CBOR or cannot be decoded are
+// returned as they are, the hooks only seeing the header
+func (c Chain) Transform(ctx context.Context, m *Message, contentType string,
+	data []byte) ([]byte, error) {
+	if len(c) == 0 {
+		return data, nil
+	}
+	cd := payloadCodec(contentType, data)
+	if cd != nil {
+		js, err := codec.ToJSON(data, contentType)
+		d := json.NewDecoder(bytes.NewReader(js))
+		d.UseNumber()
+		if err != nil || d.Decode(&m.Body) != nil {
+			cd, m.Body = nil, nil
+		}
+	}
+	for _, k := range c {
+		if err := k.fn(ctx, m); err != nil {
+			calls.WithLabelValues(k.name, m.Stage, "error").Inc()
+			return nil, fmt.Errorf("hook %s: %w", k.name, err)
+		}
+		calls.WithLabelValues(k.name, m.Stage, "ok").Inc()
+	}
+	if cd == nil {
+		return data, nil
+	}
+	if cd == codec.JSON {
+		return json.Marshal(m.Body)
+	}
+	out, _, err := cd.Marshal(m.Body)
+	return out, err
+}
+
+// payloadCodec returns the codec of a JSON or CBOR body, nil for the
+// others
+func payloadCodec(contentType string, data []byte) codec.Codec {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	cd, err := codec.ForContentType(contentType)
+	if err != nil || (cd != codec.JSON && cd != codec.CBOR) {
+		return nil
+	}
+	return cd
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,377 @@
+// Package jobs runs the long NF operations asynchronously: the request is
+// answered 202 Accepted with the URI of a job resource, which the client
+// polls for the result or gets POSTed to its callback URI once finished
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/scp"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	// DefaultPath is the jobs resource path used when the NF configures
+	// none
+	DefaultPath = "/jobs"
+
+	defaultTimeout          = 60000
+	defaultRetention        = 3600000
+	defaultCallbackAttempts = 3
+	// callbackInterval is the delay between the callback attempts
+	callbackInterval = time.Second
+	// pollInterval is the Retry-After of the jobs not finished
+	pollInterval = "1"
+
+	// collection of the store holding the jobs
+	collection = "jobs"
+)
+
+var (
+	jobsTotal = metrics.NewCounterVec("nf_jobs_total",
+		"Asynchronous jobs finished by result: completed or failed.",
+		"result")
+	jobsRunning = metrics.NewGaugeVec("nf_jobs_running",
+		"Asynchronous jobs running.")
+)
+
+// Status of a job
+type Status string
+
+// Job statuses
+const (
+	Running   Status = "RUNNING"
+	Completed Status = "COMPLETED"
+	Failed    Status = "FAILED"
+)
+
+// Job is the job resource
+type Job struct {
+	ID       string     `json:"jobId"`
+	Status   Status     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Result is the response of the operation once completed
+	Result json.RawMessage `json:"result,omitempty"`
+	// Problem is the failure of the operation
+	Problem *problem.Details `json:"problem,omitempty"`
+	// CallbackURI receives the job once finished, when the client set one
+	CallbackURI string `json:"callbackUri,omitempty"`
+}
+
+// Work is a long operation run by a job. It returns the result of the
+// operation, or a *problem.Details error to choose the failure reported
+type Work func(ctx context.Context) (interface{}, error)
+
+// Manager runs the jobs, keeps them in the store and serves the jobs
+// resource. The jobs kept in a shared store are polled from any replica
+type Manager struct {
+	// BaseURI is the URI of the jobs resource, used to build the Location
+	// of the jobs
+	BaseURI string
+
+	timeout   time.Duration
+	retention time.Duration
+	max       int
+	attempts  int
+	client    *client.Client
+	store     store.Store
+
+	mu      sync.Mutex
+	running int
+	wg      sync.WaitGroup
+}
+
+// New creates a manager keeping the jobs in the store and sending the
+// finished jobs to the callback URIs with the client
+func New(cfg config.JobsConfig, c *client.Client, st store.Store) *Manager {
+	m := &Manager{
+		timeout:   time.Duration(cfg.Timeout) * time.Millisecond,
+		retention: time.Duration(cfg.Retention) * time.Millisecond,
+		max:       cfg.MaxRunning,
+		attempts:  cfg.CallbackAttempts,
+		client:    c,
+		store:     st,
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultTimeout * time.Millisecond
+	}
+	if m.retention <= 0 {
+		m.retention = defaultRetention * time.Millisecond
+	}
+	if m.attempts <= 0 {
+		m.attempts = defaultCallbackAttempts
+	}
+	return m
+}
+
+// Requested tells whether the client asks for the asynchronous mode with
+// the Prefer: respond-async header (RFC 7240)
+func Requested(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			name := strings.TrimSpace(strings.SplitN(pref, ";", 2)[0])
+			if strings.EqualFold(name, "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Accept starts a job running the work and answers the request with 202
+// and the job. The callbackUri query parameter sets the URI the job is
+// POSTed to once finished. The work runs with the values of the request
+// context, e.g. its logger, but not its cancellation
+func (m *Manager) Accept(w http.ResponseWriter, r *http.Request, work Work) {
+	l := logging.FromContext(r.Context())
+	callbackURI := r.URL.Query().Get("callbackUri")
+	if callbackURI != "" {
+		u, err := url.Parse(callbackURI)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" {
+			problem.Write(w, problem.New(http.StatusBadRequest,
+				problem.CauseMandatoryIEIncorrect, "invalid callback URI").
+				WithInvalidParams(problem.InvalidParam{
+					Param:  "callbackUri",
+					Reason: fmt.Sprintf("%q is not an http(s) URI", callbackURI)}))
+			return
+		}
+		if err := m.client.CheckCallback(r.Context(), callbackURI); err != nil {
+			problem.Write(w, problem.New(http.StatusBadRequest,
+				problem.CauseMandatoryIEIncorrect, "callback URI not allowed").
+				WithInvalidParams(problem.InvalidParam{
+					Param:  "callbackUri",
+					Reason: err.Error()}))
+			return
+		}
+	}
+	if !m.reserve() {
+		problem.Error(w, http.StatusServiceUnavailable,
+			problem.CauseNFCongestion, "too many jobs running")
+		return
+	}
+	job := &Job{ID: uuid.New(), Status: Running, Created: time.Now(),
+		CallbackURI: callbackURI}
+	ctx := context.WithoutCancel(r.Context())
+	/* a job still running when its timeout is over is failed */
+	if err := m.save(ctx, job, m.timeout+m.retention); err != nil {
+		m.release()
+		l.Errorf("Job not stored: %v", err)
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, "job not stored: "+err.Error())
+		return
+	}
+	m.wg.Add(1)
+	go m.run(ctx, job, work)
+
+	l.Infof("Job %s started", job.ID)
+	w.Header().Set("Location", scp.ReplyURI(r, m.location(job.ID)))
+	w.Header().Set("Preference-Applied", "respond-async")
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// reserve counts a job running, false when there are too many
+func (m *Manager) reserve() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.max > 0 && m.running >= m.max {
+		return false
+	}
+	m.running++
+	jobsRunning.WithLabelValues().Inc()
+	return true
+}
+
+func (m *Manager) release() {
+	m.mu.Lock()
+	m.running--
+	m.mu.Unlock()
+	jobsRunning.WithLabelValues().Dec()
+}
+
+// run runs the work of the job and stores its outcome
+func (m *Manager) run(ctx context.Context, job *Job, work Work) {
+	defer m.wg.Done()
+	l := logging.FromContext(ctx)
+	workCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	result, err := work(workCtx)
+	cancel()
+	m.release()
+
+	finished := time.Now()
+	job.Finished = &finished
+	if err == nil {
+		job.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		job.Status = Failed
+		var p *problem.Details
+		if !errors.As(err, &p) {
+			p = problem.New(http.StatusInternalServerError,
+				problem.CauseSystemFailure, err.Error())
+		}
+		job.Problem = p
+		l.Warnf("Job %s failed: %v", job.ID, err)
+	} else {
+		job.Status = Completed
+		l.Infof("Job %s completed", job.ID)
+	}
+	jobsTotal.WithLabelValues(strings.ToLower(string(job.Status))).Inc()
+	if err := m.save(ctx, job, m.retention); err != nil {
+		l.Errorf("Job %s not stored: %v", job.ID, err)
+	}
+	if job.CallbackURI != "" {
+		m.callback(ctx, job)
+	}
+}
+
+// callback POSTs the finished job to its callback URI, retrying up to the
+// configured number of attempts
+func (m *Manager) callback(ctx context.Context, job *Job) {
+	l := logging.FromContext(ctx)
+	body, err := json.Marshal(job)
+	if err != nil {
+		l.Errorf("Job %s callback not sent: %v", job.ID, err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err := m.post(ctx, job.CallbackURI, body)
+		if err == nil {
+			l.Infof("Job %s sent to %s", job.ID, job.CallbackURI)
+			return
+		}
+		if attempt >= m.attempts {
+			l.Errorf("Job %s callback to %s failed after %d attempts: %v",
+				job.ID, job.CallbackURI, attempt, err)
+			return
+		}
+		l.Warnf("Job %s callback to %s failed: %v", job.ID, job.CallbackURI,
+			err)
+		time.Sleep(callbackInterval)
+	}
+}
+
+func (m *Manager) post(ctx context.Context, uri string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req.WithContext(m.client.CallbackContext(ctx)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (m *Manager) save(ctx context.Context, job *Job,
+	ttl time.Duration) error {
+	return store.PutJSON(ctx, m.store, collection, job.ID, job, ttl)
+}
+
+// Get returns the job, nil when unknown or forgotten
+func (m *Manager) Get(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	err := store.GetJSON(ctx, m.store, collection, id, &job)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == Running && time.Since(job.Created) > m.timeout {
+		/* the replica running it stopped */
+		job.Status = Failed
+		job.Problem = problem.New(http.StatusGatewayTimeout,
+			problem.CauseTimedOutRequest, "job not finished in time")
+	}
+	return &job, nil
+}
+
+// Run waits for the jobs running once the context is canceled, so that
+// the NF stops after them
+func (m *Manager) Run(ctx context.Context) {
+	<-ctx.Done()
+	m.wg.Wait()
+}
+
+// ServeHTTP serves the jobs (GET) below the jobs resource. The manager is
+// registered for the resource path followed by "/"
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, m.basePath()), "/")
+	if id == "" || strings.Contains(id, "/") {
+		notFound(w, id)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		problem.Error(w, http.StatusMethodNotAllowed, "",
+			r.Method+" not allowed on "+r.URL.Path)
+		return
+	}
+	job, err := m.Get(r.Context(), id)
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, "job store: "+err.Error())
+		return
+	}
+	if job == nil {
+		notFound(w, id)
+		return
+	}
+	if job.Status == Running {
+		w.Header().Set("Retry-After", pollInterval)
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// location returns the URI of the job
+func (m *Manager) location(id string) string {
+	return strings.TrimRight(m.BaseURI, "/") + "/" + id
+}
+
+// basePath returns the path of the jobs resource
+func (m *Manager) basePath() string {
+	u, err := url.Parse(m.BaseURI)
+	if err != nil {
+		return m.BaseURI
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
+func notFound(w http.ResponseWriter, id string) {
+	problem.Error(w, http.StatusNotFound, problem.CauseResourceURINotFound,
+		"job "+id+" not found")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
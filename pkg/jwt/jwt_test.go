@@ -0,0 +1,250 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+func segment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns the token of the claims signed by key with the algorithm
+func sign(t *testing.T, alg, kid string, key crypto.Signer,
+	claims map[string]interface{}) string {
+	t.Helper()
+	input := segment(t, header{Alg: alg, Kid: kid}) + "." +
+		segment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256,
+			digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func claims(mod func(map[string]interface{})) map[string]interface{} {
+	c := map[string]interface{}{"iss": "nrf", "aud": "nf1",
+		"exp": time.Now().Add(time.Hour).Unix(), "scope": "nf1"}
+	if mod != nil {
+		mod(c)
+	}
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &Validator{keys: &KeySet{static: &rsaKey.PublicKey},
+		issuer: "nrf", audience: "nf1"}
+	ecValidator := &Validator{keys: &KeySet{static: &ecKey.PublicKey}}
+
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* HS256 keyed with the RSA public key, as a confused verifier would */
+	hsInput := segment(t, header{Alg: "HS256"}) + "." + segment(t, claims(nil))
+	mac := hmac.New(sha256.New, rsaDER)
+	mac.Write([]byte(hsInput))
+	hsToken := hsInput + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		validator *Validator
+		token     string
+		err       string
+	}{
+		{"valid RS256", v, sign(t, "RS256", "", rsaKey, claims(nil)), ""},
+		{"valid ES256", ecValidator,
+			sign(t, "ES256", "", ecKey, claims(nil)), ""},
+		{"alg none", v, segment(t, header{Alg: "none"}) + "." +
+			segment(t, claims(nil)) + ".", "unsupported algorithm"},
+		{"HS256 with the RSA key", v, hsToken, "unsupported algorithm"},
+		{"ES256 with the RSA key", v,
+			sign(t, "ES256", "", ecKey, claims(nil)), "does not match"},
+		{"signature of another key", ecValidator, sign(t, "ES256", "",
+			mustEC(t), claims(nil)), "invalid signature"},
+		{"expired", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["exp"] = time.Now().Add(-time.Minute).Unix()
+			})), "expired"},
+		{"expired within the leeway", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["exp"] = time.Now().Add(-leeway / 2).Unix()
+			})), ""},
+		{"no expiry", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				delete(c, "exp")
+			})), "expired"},
+		{"not yet valid", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["nbf"] = time.Now().Add(time.Minute).Unix()
+			})), "not valid yet"},
+		{"wrong issuer", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["iss"] = "other"
+			})), "unexpected issuer"},
+		{"wrong audience", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["aud"] = []string{"nf2", "nf3"}
+			})), "not issued for"},
+		{"audience in a list", v, sign(t, "RS256", "", rsaKey,
+			claims(func(c map[string]interface{}) {
+				c["aud"] = []string{"nf2", "nf1"}
+			})), ""},
+		{"malformed", v, "a.b", "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.validator.Validate(context.Background(), tt.token)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("accepted, want error %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func mustEC(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// jwksServer serves the RSA keys by key ID as a JWKS, counting the fetches
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, k := range s.keys {
+		set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid, Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(
+				big.NewInt(int64(k.E)).Bytes())})
+	}
+	_ = json.NewEncoder(w).Encode(set)
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func TestKeyRotation(t *testing.T) {
+	k1, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := &jwksServer{keys: map[string]*rsa.PublicKey{"k1": &k1.PublicKey}}
+	srv := httptest.NewServer(jwks)
+	defer srv.Close()
+	v, err := NewValidator(config.JWTConfig{JWKSURI: srv.URL}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := v.Validate(ctx, sign(t, "RS256", "k1", k1,
+		claims(nil))); err != nil {
+		t.Fatalf("k1: %v", err)
+	}
+	if n := jwks.count(); n != 1 {
+		t.Fatalf("%d fetches, want 1", n)
+	}
+
+	/* k2 is published, the JWKS fetched just now is not fetched again */
+	jwks.mu.Lock()
+	jwks.keys["k2"] = &k2.PublicKey
+	jwks.mu.Unlock()
+	token := sign(t, "RS256", "k2", k2, claims(nil))
+	if _, err := v.Validate(ctx, token); err == nil ||
+		!strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("k2 before the refresh interval: %v", err)
+	}
+	if n := jwks.count(); n != 1 {
+		t.Fatalf("%d fetches within the refresh interval, want 1", n)
+	}
+
+	/* past the minimum interval, the unknown key ID fetches the JWKS */
+	v.keys.mu.Lock()
+	v.keys.fetched = time.Now().Add(-jwksMinInterval - time.Second)
+	v.keys.mu.Unlock()
+	if _, err := v.Validate(ctx, token); err != nil {
+		t.Fatalf("k2 after the refresh interval: %v", err)
+	}
+	if n := jwks.count(); n != 2 {
+		t.Fatalf("%d fetches, want 2", n)
+	}
+	if _, err := v.Validate(ctx, sign(t, "RS256", "k1", k1,
+		claims(nil))); err != nil {
+		t.Fatalf("k1 after the rotation: %v", err)
+	}
+	if n := jwks.count(); n != 2 {
+		t.Fatalf("%d fetches for a known key, want 2", n)
+	}
+}
@@ -0,0 +1,215 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+)
+
+const (
+	// Time the fetched key set is used before it is fetched again
+	jwksRefresh = time.Hour
+	// Minimum time between two fetches caused by unknown key IDs
+	jwksMinInterval = 30 * time.Second
+)
+
+// KeySet holds the public keys verifying the token signatures, either a
+// static key or the keys of a JWKS fetched and refreshed from its URI
+type KeySet struct {
+	static crypto.PublicKey
+	uri    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewKeySet creates the key set of the configuration
+func NewKeySet(cfg config.JWTConfig, caFile string) (*KeySet, error) {
+	if cfg.JWKSURI != "" {
+		tlsConfig := &tls.Config{}
+		if pool, err := tlsutil.LoadCertPool(caFile); err == nil {
+			tlsConfig.RootCAs = pool
+		}
+		return &KeySet{uri: cfg.JWKSURI, client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}}, nil
+	}
+	if cfg.PublicKeyFile == "" {
+		return nil, errors.New("jwt: neither jwksuri nor publickeyfile set")
+	}
+	key, err := LoadPublicKey(cfg.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{static: key}, nil
+}
+
+// Key returns the key of the key ID. The JWKS is fetched again when it is
+// old or does not contain the key ID
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey,
+	error) {
+	if ks.static != nil {
+		return ks.static, nil
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[kid]
+	stale := time.Since(ks.fetched) > jwksRefresh
+	if ok && !stale {
+		return key, nil
+	}
+	if stale || time.Since(ks.fetched) > jwksMinInterval {
+		if err := ks.fetch(ctx); err != nil {
+			if ok {
+				/* keep using the known key until the JWKS is back */
+				return key, nil
+			}
+			return nil, err
+		}
+		if key, ok = ks.keys[kid]; ok {
+			return key, nil
+		}
+	}
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// jwk is a JSON Web Key, only the public RSA and EC members
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (ks *KeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ks.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return fmt.Errorf("decoding JWKS: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	ks.keys = keys
+	ks.fetched = time.Now()
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// LoadPublicKey reads a PEM public key or certificate, from a file or a
+// secret reference
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	data, err := secrets.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
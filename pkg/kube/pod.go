@@ -0,0 +1,125 @@
+// Package kube integrates the NFs running in Kubernetes pods: the addresses
+// of the pod read from the downward API environment variables replace the
+// hosts of the configuration, and the readiness of the NF is reported to
+// the API server as the condition of a readiness gate of the pod
+package kube
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// Downward API environment variables of the pod, e.g.
+//
+//	env:
+//	- name: POD_IP
+//	  valueFrom: {fieldRef: {fieldPath: status.podIP}}
+const (
+	EnvPodIP        = "POD_IP"
+	EnvPodName      = "POD_NAME"
+	EnvPodNamespace = "POD_NAMESPACE"
+	// EnvServiceName is the name of the service of the pods, set as a
+	// plain value
+	EnvServiceName = "SERVICE_NAME"
+)
+
+const defaultClusterDomain = "cluster.local"
+
+// Pod is the pod the NF runs in
+type Pod struct {
+	IP        string
+	Name      string
+	Namespace string
+	// Service is the name of the service of the pods, empty when unknown
+	Service string
+	// Domain is the cluster domain of the service DNS names
+	Domain string
+}
+
+// FromEnv returns the pod described by the downward API environment
+// variables. The pod IP is required
+func FromEnv(cfg config.PodConfig) (Pod, error) {
+	p := Pod{IP: os.Getenv(EnvPodIP), Name: os.Getenv(EnvPodName),
+		Namespace: os.Getenv(EnvPodNamespace),
+		Service:   os.Getenv(EnvServiceName), Domain: cfg.ClusterDomain}
+	if p.Domain == "" {
+		p.Domain = defaultClusterDomain
+	}
+	if net.ParseIP(p.IP) == nil {
+		return Pod{}, errors.New("no pod IP in " + EnvPodIP)
+	}
+	if cfg.Advertise == config.PodAdvertiseService && p.FQDN() == "" {
+		return Pod{}, errors.New("no service DNS name without " +
+			EnvServiceName + " and " + EnvPodNamespace)
+	}
+	if cfg.ReadinessGate != "" && (p.Name == "" || p.Namespace == "") {
+		return Pod{}, errors.New("no readiness gate without " + EnvPodName +
+			" and " + EnvPodNamespace)
+	}
+	return p, nil
+}
+
+// FQDN returns the DNS name of the service of the pod, empty when the
+// service or namespace is unknown
+func (p Pod) FQDN() string {
+	if p.Service == "" || p.Namespace == "" {
+		return ""
+	}
+	return p.Service + "." + p.Namespace + ".svc." + p.Domain
+}
+
+// Host returns the host the NF advertises, the pod IP or the DNS name of
+// its service
+func (p Pod) Host(advertise string) string {
+	if advertise == config.PodAdvertiseService {
+		return p.FQDN()
+	}
+	return p.IP
+}
+
+// APIRoot returns the API root prefix (e.g. "://localhost/nnf/v1") with the
+// host advertised, keeping its port and path
+func (p Pod) APIRoot(root, advertise string) string {
+	host := p.Host(advertise)
+	u, err := url.Parse("http" + root)
+	if err != nil || u.Host == "" {
+		return "://" + bracket(host)
+	}
+	if port := u.Port(); port != "" {
+		return "://" + net.JoinHostPort(host, port) + u.Path
+	}
+	return "://" + bracket(host) + u.Path
+}
+
+// NRF returns the registration with the FQDN and IP of the pod, and the
+// pod IP as host of the service endpoints
+func (p Pod) NRF(cfg config.NRFConfig) config.NRFConfig {
+	if fqdn := p.FQDN(); fqdn != "" {
+		cfg.FQDN = fqdn
+	}
+	if ip := net.ParseIP(p.IP); ip.To4() != nil {
+		cfg.IPv4Addresses = []string{p.IP}
+	}
+	services := make([]config.NRFServiceConfig, len(cfg.Services))
+	for i, svc := range cfg.Services {
+		if _, port, err := net.SplitHostPort(svc.Endpoint); err == nil {
+			svc.Endpoint = net.JoinHostPort(p.IP, port)
+		}
+		services[i] = svc
+	}
+	cfg.Services = services
+	return cfg
+}
+
+// bracket returns the host with the brackets of an IPv6 address
+func bracket(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
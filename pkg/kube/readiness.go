@@ -0,0 +1,199 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	// serviceAccountDir holds the token and CA of the service account of
+	// the pod
+	serviceAccountDir        = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultReadinessInterval = 10
+	patchTimeout             = 5 * time.Second
+)
+
+var readinessUpdates = metrics.NewCounterVec(
+	"nf_pod_readiness_updates_total",
+	"Updates of the readiness gate condition of the pod by status and "+
+		"result.", "status", "result")
+
+// Readiness sets the condition of a readiness gate of the pod to the
+// readiness of the NF, so that the pod only gets traffic once the NF is
+// ready and stops getting it when the NF drains
+type Readiness struct {
+	pod       Pod
+	gate      string
+	interval  time.Duration
+	ready     func(context.Context) error
+	apiServer string
+	client    *http.Client
+}
+
+// NewReadiness returns the report of the readiness of the NF, checked by
+// ready, to the readiness gate of the configuration
+func NewReadiness(pod Pod, cfg config.PodConfig,
+	ready func(context.Context) error) (*Readiness, error) {
+	apiServer := cfg.APIServer
+	if apiServer == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("no API server outside of a cluster")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if _, err := url.Parse(apiServer); err != nil {
+		return nil, fmt.Errorf("API server: %v", err)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	switch {
+	case err == nil:
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate in the service account CA")
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	interval := cfg.ReadinessInterval
+	if interval == 0 {
+		interval = defaultReadinessInterval
+	}
+	return &Readiness{pod: pod, gate: cfg.ReadinessGate,
+		interval: time.Duration(interval) * time.Second, ready: ready,
+		apiServer: strings.TrimSuffix(apiServer, "/"),
+		client: &http.Client{Timeout: patchTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// Run checks the readiness of the NF every interval and sets the condition
+// when it changes or was not set, then sets it to False when the context
+// ends, at the shutdown of the NF
+func (r *Readiness) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	var set, last bool
+	for {
+		if ready, reason, ok := r.check(ctx); ok && (!set || ready != last) {
+			if err := r.patch(ctx, ready, reason); err != nil {
+				logging.Warnf("Readiness gate %s not set: %v", r.gate, err)
+			} else {
+				set, last = true, ready
+			}
+		}
+		select {
+		case <-ctx.Done():
+			pctx, cancel := context.WithTimeout(context.Background(),
+				patchTimeout)
+			defer cancel()
+			if err := r.patch(pctx, false, "Shutting down"); err != nil {
+				logging.Warnf("Readiness gate %s not cleared: %v", r.gate,
+					err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check returns the readiness of the NF and its reason, ok being false
+// when the context ended during the check
+func (r *Readiness) check(ctx context.Context) (ready bool, reason string,
+	ok bool) {
+	checkCtx, cancel := context.WithTimeout(ctx, patchTimeout)
+	defer cancel()
+	err := r.ready(checkCtx)
+	if ctx.Err() != nil {
+		return false, "", false
+	}
+	if err != nil {
+		return false, err.Error(), true
+	}
+	return true, "Ready", true
+}
+
+// patch sets the condition of the readiness gate in the status of the pod
+func (r *Readiness) patch(ctx context.Context, ready bool,
+	message string) error {
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []map[string]string{{
+				"type":               r.gate,
+				"status":             status,
+				"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+				"message":            message,
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = r.send(ctx, body)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	readinessUpdates.WithLabelValues(status, result).Inc()
+	if err == nil {
+		logging.Infof("Readiness gate %s of pod %s/%s set to %s",
+			r.gate, r.pod.Namespace, r.pod.Name, status)
+	}
+	return err
+}
+
+// send sends the strategic merge patch of the pod status, the conditions
+// being merged by type
+func (r *Readiness) send(ctx context.Context, body []byte) error {
+	u := r.apiServer + "/api/v1/namespaces/" +
+		url.PathEscape(r.pod.Namespace) + "/pods/" +
+		url.PathEscape(r.pod.Name) + "/status"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, u,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/strategic-merge-patch+json")
+	/* the projected tokens are rotated, read at each request */
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err == nil {
+		req.Header.Set("Authorization",
+			"Bearer "+strings.TrimSpace(string(token)))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("API server answered %d: %s", resp.StatusCode,
+			bytes.TrimSpace(data))
+	}
+	return nil
+}
@@ -0,0 +1,488 @@
+// Package location serves the NF record last written to a location route
+// (/nf1 on NF1, /nf2 on NF2) as a resource: GET returns it, PUT replaces
+// it, PATCH updates it with a JSON merge patch (RFC 7396) and DELETE
+// clears it. Each change is a new revision, kept in the store up to the
+// history size, whose number is the ETag of the record. The revisions are
+// created with store.Create, so that of two writers starting from the same
+// revision, replicas included, only one succeeds: the conditional requests
+// of the other (If-Match) are answered 412
+package location
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/message"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+)
+
+const (
+	// HistoryPath is the path of the revisions below the resource path
+	HistoryPath = "/history"
+	// MergePatchType is the media type of the PATCH bodies
+	MergePatchType = "application/merge-patch+json"
+
+	defaultHistory = 10
+	// writeAttempts bounds the unconditional writes losing the race to
+	// other writers
+	writeAttempts = 5
+
+	// collection of the store holding the revisions
+	collection = "locations"
+)
+
+// errConflict is returned when another writer created the revision first
+var errConflict = errors.New("location: revision written concurrently")
+
+// Revision is a revision of the record, a deletion when Deleted is set
+type Revision struct {
+	Revision uint64    `json:"revision"`
+	Modified time.Time `json:"modified"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	NF       *api.NF   `json:"nf,omitempty"`
+}
+
+// ETag returns the entity tag of the revision
+func (r *Revision) ETag() string {
+	return `"` + strconv.FormatUint(r.Revision, 10) + `"`
+}
+
+// Resource is the record of a location route
+type Resource struct {
+	store   store.Store
+	name    string
+	typ     string
+	history uint64
+}
+
+// New creates the resource of the record name (e.g. "nf1") in the store.
+// typ names its operations, whose IDs are the message types: Get<typ>,
+// Replace<typ>, Update<typ>, Delete<typ> and List<typ>s
+func New(cfg config.LocationsConfig, s store.Store, name,
+	typ string) *Resource {
+	history := uint64(defaultHistory)
+	if cfg.History > 0 {
+		history = uint64(cfg.History)
+	}
+	return &Resource{store: s, name: name, typ: typ, history: history}
+}
+
+// key returns the store key of the revision, zero padded so that the keys
+// sort in order
+func (res *Resource) key(revision uint64) string {
+	return fmt.Sprintf("%s/%020d", res.name, revision)
+}
+
+// headKey is the store key of the last revision number written, a hint the
+// newer revisions are looked up from
+func (res *Resource) headKey() string {
+	return res.name + "/head"
+}
+
+// Latest returns the last revision, nil when the record was never written
+func (res *Resource) Latest(ctx context.Context) (*Revision, error) {
+	var head uint64
+	data, err := res.store.Get(ctx, collection, res.headKey())
+	switch {
+	case err == nil:
+		if head, err = strconv.ParseUint(string(data), 10, 64); err != nil {
+			return nil, fmt.Errorf("location: head %q: %v", data, err)
+		}
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+	var latest *Revision
+	if head > 0 {
+		latest = &Revision{}
+		err := store.GetJSON(ctx, res.store, collection, res.key(head),
+			latest)
+		if errors.Is(err, store.ErrNotFound) {
+			latest = nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	/* the head is updated after the revision is created */
+	for {
+		var next Revision
+		err := store.GetJSON(ctx, res.store, collection, res.key(head+1),
+			&next)
+		if errors.Is(err, store.ErrNotFound) {
+			return latest, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		head, latest = head+1, &next
+	}
+}
+
+// History returns the revisions kept, the newest first
+func (res *Resource) History(ctx context.Context) ([]Revision, error) {
+	latest, err := res.Latest(ctx)
+	if err != nil || latest == nil {
+		return nil, err
+	}
+	var revisions []Revision
+	for n := latest.Revision; n > 0 && latest.Revision-n < res.history; n-- {
+		var r Revision
+		err := store.GetJSON(ctx, res.store, collection, res.key(n), &r)
+		if errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+// write creates the revision following base, the record nf or its deletion
+// when nf is nil, and drops the revisions past the history size. It
+// returns errConflict when another writer created it first
+func (res *Resource) write(ctx context.Context, base *Revision,
+	nf *api.NF) (*Revision, error) {
+	r := &Revision{Revision: 1, Modified: time.Now().UTC(), NF: nf,
+		Deleted: nf == nil}
+	if base != nil {
+		r.Revision = base.Revision + 1
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	created, err := res.store.Create(ctx, collection, res.key(r.Revision),
+		data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, errConflict
+	}
+	l := logging.FromContext(ctx)
+	if err := res.store.Put(ctx, collection, res.headKey(),
+		[]byte(strconv.FormatUint(r.Revision, 10)), 0); err != nil {
+		l.Warnf("Head of the %s record not stored: %v", res.name, err)
+	}
+	if r.Revision > res.history {
+		if _, err := res.store.Delete(ctx, collection,
+			res.key(r.Revision-res.history)); err != nil {
+			l.Warnf("Revision %d of the %s record not dropped: %v",
+				r.Revision-res.history, res.name, err)
+		}
+	}
+	return r, nil
+}
+
+// Record writes the record unconditionally, e.g. from the POST to the
+// route
+func (res *Resource) Record(ctx context.Context, nf api.NF) (*Revision,
+	error) {
+	for i := 0; ; i++ {
+		latest, err := res.Latest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r, err := res.write(ctx, latest, &nf)
+		if !errors.Is(err, errConflict) || i == writeAttempts-1 {
+			return r, err
+		}
+	}
+}
+
+// Handler serves the record: post handles the POST to the route, the
+// other methods the resource. It is registered for the route and for the
+// route followed by HistoryPath, which lists the revisions kept
+func (res *Resource) Handler(post http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, HistoryPath) {
+			if r.Method != http.MethodGet {
+				methodNotAllowed(w, r, http.MethodGet)
+				return
+			}
+			res.list(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			post.ServeHTTP(w, r)
+		case http.MethodGet, http.MethodHead:
+			res.get(w, r)
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			res.change(w, r)
+		default:
+			methodNotAllowed(w, r, "GET, HEAD, POST, PUT, PATCH, DELETE")
+		}
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request,
+	allow string) {
+	w.Header().Set("Allow", allow)
+	problem.Error(w, http.StatusMethodNotAllowed, "",
+		r.Method+" not allowed on "+r.URL.Path)
+}
+
+func (res *Resource) get(w http.ResponseWriter, r *http.Request) {
+	latest, err := res.Latest(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if latest == nil || latest.Deleted {
+		res.notFound(w)
+		return
+	}
+	setValidators(w, latest)
+	if matches(r.Header.Get("If-None-Match"), latest.ETag()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	message.Write(w, r, http.StatusOK, "Get"+res.typ, latest.NF, nil)
+}
+
+func (res *Resource) list(w http.ResponseWriter, r *http.Request) {
+	revisions, err := res.History(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if revisions == nil {
+		revisions = []Revision{}
+	}
+	codec.Write(w, r, http.StatusOK, revisions)
+}
+
+// change serves PUT, PATCH and DELETE, checking the preconditions against
+// the last revision
+func (res *Resource) change(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := logging.FromContext(ctx)
+	var body []byte
+	if r.Method != http.MethodDelete {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			problem.Write(w, problem.FromDecodeError(err))
+			return
+		}
+	}
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	for i := 0; ; i++ {
+		latest, err := res.Latest(ctx)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		current := latest
+		if current != nil && current.Deleted {
+			current = nil
+		}
+		if !preconditions(current, ifMatch, ifNoneMatch) {
+			problem.Error(w, http.StatusPreconditionFailed, "",
+				"the "+res.name+" record does not match the preconditions")
+			return
+		}
+		if current == nil && r.Method != http.MethodPut {
+			res.notFound(w)
+			return
+		}
+		var nf *api.NF
+		switch r.Method {
+		case http.MethodPut:
+			nf, err = res.replacement(r, body)
+		case http.MethodPatch:
+			nf, err = patch(r, current.NF, body)
+		}
+		if err != nil {
+			problem.Write(w, decodeProblem(err))
+			return
+		}
+		written, err := res.write(ctx, latest, nf)
+		if errors.Is(err, errConflict) {
+			if ifMatch != "" || ifNoneMatch != "" {
+				problem.Error(w, http.StatusPreconditionFailed, "",
+					"the "+res.name+" record was changed concurrently")
+				return
+			}
+			if i < writeAttempts-1 {
+				continue
+			}
+		}
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		l.Infof("%s of the %s record: revision %d", r.Method, res.name,
+			written.Revision)
+		if nf == nil {
+			w.Header().Set("ETag", written.ETag())
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		status := http.StatusOK
+		if current == nil {
+			status = http.StatusCreated
+		}
+		setValidators(w, written)
+		message.Write(w, r, status, "Get"+res.typ, nf, nil)
+		return
+	}
+}
+
+// replacement decodes the record of a PUT, a message of type Replace<typ>
+func (res *Resource) replacement(r *http.Request, body []byte) (*api.NF,
+	error) {
+	contentType := r.Header.Get("Content-Type")
+	cd, err := codec.ForContentType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	var nf api.NF
+	if _, err := message.Unmarshal(cd, body, contentType, "Replace"+res.typ,
+		&nf); err != nil {
+		return nil, err
+	}
+	return &nf, check(&nf)
+}
+
+// patch applies the JSON merge patch of a PATCH to the record
+func patch(r *http.Request, nf *api.NF, body []byte) (*api.NF, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != MergePatchType && mt != "application/json" {
+		return nil, &codec.UnsupportedError{MediaType: mt}
+	}
+	target, err := json.Marshal(nf)
+	if err != nil {
+		return nil, err
+	}
+	var doc, p interface{}
+	if err := json.Unmarshal(target, &doc); err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	if err := d.Decode(&p); err != nil {
+		return nil, err
+	}
+	merged, err := json.Marshal(mergePatch(doc, p))
+	if err != nil {
+		return nil, err
+	}
+	var patched api.NF
+	if err := json.Unmarshal(merged, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, check(&patched)
+}
+
+// mergePatch applies the merge patch p to the document doc (RFC 7396)
+func mergePatch(doc, p interface{}) interface{} {
+	patch, ok := p.(map[string]interface{})
+	if !ok {
+		return p
+	}
+	target, ok := doc.(map[string]interface{})
+	if !ok {
+		target = make(map[string]interface{})
+	}
+	for name, value := range patch {
+		if value == nil {
+			delete(target, name)
+			continue
+		}
+		target[name] = mergePatch(target[name], value)
+	}
+	return target
+}
+
+// check checks the mandatory attributes of the record, returning the
+// problem details of the missing ones
+func check(nf *api.NF) error {
+	var params []problem.InvalidParam
+	if nf.Location == "" {
+		params = append(params, problem.InvalidParam{Param: "/location",
+			Reason: "missing"})
+	}
+	if nf.Time.IsZero() {
+		params = append(params, problem.InvalidParam{Param: "/time",
+			Reason: "missing"})
+	}
+	if params == nil {
+		return nil
+	}
+	return problem.New(http.StatusBadRequest, problem.CauseMandatoryIEMissing,
+		"incomplete NF record").WithInvalidParams(params...)
+}
+
+// decodeProblem returns the problem details answering a body that cannot
+// be decoded
+func decodeProblem(err error) *problem.Details {
+	var p *problem.Details
+	var unsupported *codec.UnsupportedError
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &unsupported):
+		return problem.New(http.StatusUnsupportedMediaType, "", err.Error())
+	}
+	return message.Problem(err)
+}
+
+// preconditions evaluates If-Match and If-None-Match against the current
+// revision, nil when there is no record
+func preconditions(current *Revision, ifMatch, ifNoneMatch string) bool {
+	if ifMatch != "" && (current == nil || !matches(ifMatch,
+		current.ETag())) {
+		return false
+	}
+	if ifNoneMatch != "" && current != nil && matches(ifNoneMatch,
+		current.ETag()) {
+		return false
+	}
+	return true
+}
+
+// matches tells whether a list of entity tags carries the tag or is "*"
+func matches(list, tag string) bool {
+	if list == "" {
+		return false
+	}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "*" || v == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// setValidators sets the ETag and Last-Modified of the revision
+func setValidators(w http.ResponseWriter, r *Revision) {
+	w.Header().Set("ETag", r.ETag())
+	w.Header().Set("Last-Modified", r.Modified.Format(http.TimeFormat))
+}
+
+func (res *Resource) notFound(w http.ResponseWriter) {
+	problem.Error(w, http.StatusNotFound, problem.CauseContextNotFound,
+		"no "+res.name+" record")
+}
+
+// storeError answers a failure of the store
+func storeError(w http.ResponseWriter, err error) {
+	problem.Error(w, http.StatusInternalServerError,
+		problem.CauseSystemFailure, "location store: "+err.Error())
+}
@@ -0,0 +1,208 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Headers whose values are never logged
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization",
+	"Cookie", "Set-Cookie"}
+
+// SetCapture turns the request/response capture on or off for the logger
+// and the loggers derived from it
+func (l *Logger) SetCapture(on bool) {
+	l.out.mu.Lock()
+	l.out.capture = on
+	l.out.mu.Unlock()
+}
+
+// Capturing reports whether the requests and responses are logged, which
+// is the case when the capture is on or the level is debug
+func (l *Logger) Capturing() bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.capture || l.out.level == DebugLevel
+}
+
+// Capture logs the requests and the responses of the handler when the
+// logger of the request context is capturing: method, path, headers with
+// the sensitive values redacted, and the bodies truncated to the maximum
+// body size. The bodies are recorded while the handler reads and writes
+// them, so that streaming is not affected
+func Capture(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := FromContext(r.Context())
+		if !l.Capturing() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := l.maxBodySize()
+		var reqBody *capturedBody
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody = &capturedBody{ReadCloser: r.Body, limit: limit}
+			r.Body = reqBody
+		}
+		cw := &captureWriter{StatusWriter: StatusWriter{ResponseWriter: w},
+			body: capturedBody{limit: limit}}
+		next.ServeHTTP(cw, r)
+		if cw.Status == 0 {
+			cw.Status = http.StatusOK
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s %s\n", r.Method, r.URL.RequestURI(), r.Proto)
+		l.writeHeaders(&b, r.Header)
+		if reqBody != nil {
+			b.WriteString("\n" + reqBody.String() + "\n")
+		}
+		fmt.Fprintf(&b, "<=== %d %s\n", cw.Status, http.StatusText(cw.Status))
+		l.writeHeaders(&b, w.Header())
+		if cw.body.n > 0 {
+			b.WriteString("\n" + cw.body.String())
+		}
+		l.Infof("%s", b.String())
+	})
+}
+
+// Headers returns the headers one per line, sorted by name, with the
+// values of the sensitive ones redacted
+func (l *Logger) Headers(h http.Header) string {
+	var b strings.Builder
+	l.writeHeaders(&b, h)
+	return b.String()
+}
+
+// writeHeaders writes the headers sorted by name, redacting the values of
+// the sensitive ones
+func (l *Logger) writeHeaders(b *strings.Builder, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	redacted := l.redactedHeaders()
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		for _, s := range redacted {
+			if strings.EqualFold(name, s) {
+				value = "<redacted>"
+				break
+			}
+		}
+		fmt.Fprintf(b, "%s: %s\n", name, value)
+	}
+}
+
+func (l *Logger) maxBodySize() int {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.maxBodySize
+}
+
+func (l *Logger) redactedHeaders() []string {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.redact
+}
+
+// capturedBody records the first bytes of a body and counts the others
+type capturedBody struct {
+	io.ReadCloser
+	limit int
+	buf   bytes.Buffer
+	n     int
+}
+
+func (c *capturedBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.record(p[:n])
+	return n, err
+}
+
+func (c *capturedBody) record(p []byte) {
+	c.n += len(p)
+	if room := c.limit - c.buf.Len(); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		c.buf.Write(p)
+	}
+}
+
+// String returns the recorded bytes, followed by the number of bytes left
+// out when the body was truncated
+func (c *capturedBody) String() string {
+	if c.n > c.buf.Len() {
+		return fmt.Sprintf("%s... <%d bytes truncated>", c.buf.String(),
+			c.n-c.buf.Len())
+	}
+	return c.buf.String()
+}
+
+// captureWriter records the status code and the first bytes of a response
+type captureWriter struct {
+	StatusWriter
+	body capturedBody
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	n, err := w.StatusWriter.Write(b)
+	w.body.record(b[:n])
+	return n, err
+}
+
+// CaptureHandler reports the capture state and the log level in JSON on
+// GET, and changes them on PUT with a body such as
+// {"capture": true, "level": "debug"}
+func CaptureHandler() http.Handler {
+	type state struct {
+		Capture *bool  `json:"capture,omitempty"`
+		Level   string `json:"level,omitempty"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := Default()
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var s state
+			if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+				problem.Write(w, problem.FromDecodeError(err))
+				return
+			}
+			if s.Level != "" {
+				level, err := ParseLevel(s.Level)
+				if err != nil {
+					problem.Write(w, problem.New(http.StatusBadRequest,
+						problem.CauseMandatoryIEIncorrect, err.Error()).
+						WithInvalidParams(problem.InvalidParam{
+							Param: "/level", Reason: err.Error()}))
+					return
+				}
+				l.SetLevel(level)
+			}
+			if s.Capture != nil {
+				l.SetCapture(*s.Capture)
+			}
+			l.Infof("Log level %s, capture %v", l.Level(), l.Capturing())
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+			return
+		}
+		l.out.mu.Lock()
+		capture := l.out.capture
+		l.out.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(state{Capture: &capture,
+			Level: strings.ToLower(l.Level().String())})
+	})
+}
@@ -0,0 +1,279 @@
+// Package logging is the structured logger of the NFs. Log lines carry a
+// level and key/value fields and are written either as console text or JSON
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// Level of a log line
+type Level int
+
+// Log levels
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel converts a level name (debug, info, warn, error) to a Level
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(name, "warning") {
+		return WarnLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+// Fields are the key/values attached to a log line
+type Fields map[string]interface{}
+
+// Default maximum size of a logged body
+const defaultMaxBodySize = 4096
+
+// output is shared by a logger and the loggers derived from it with With
+type output struct {
+	mu          sync.Mutex
+	w           io.Writer
+	level       Level
+	json        bool
+	maxBodySize int
+	// capture logs the requests and responses, see Capture
+	capture bool
+	// redact lists the headers whose values are not captured
+	redact []string
+}
+
+// Logger writes leveled log lines with fields
+type Logger struct {
+	out    *output
+	fields Fields
+}
+
+// New creates a logger writing to w with the given configuration
+func New(cfg config.LogConfig, w io.Writer) (*Logger, error) {
+	out := &output{w: w, level: InfoLevel, maxBodySize: cfg.MaxBodySize,
+		capture: cfg.Capture,
+		redact: append(append([]string{}, sensitiveHeaders...),
+			cfg.RedactHeaders...)}
+	if cfg.Level != "" {
+		level, err := ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, err
+		}
+		out.level = level
+	}
+	switch cfg.Format {
+	case "", "console":
+	case "json":
+		out.json = true
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	if out.maxBodySize == 0 {
+		out.maxBodySize = defaultMaxBodySize
+	}
+	return &Logger{out: out}, nil
+}
+
+// With returns a logger adding fields to every line
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{out: l.out, fields: merged}
+}
+
+// SetLevel changes the level of the logger and of the loggers derived from it
+func (l *Logger) SetLevel(level Level) {
+	l.out.mu.Lock()
+	l.out.level = level
+	l.out.mu.Unlock()
+}
+
+// Level returns the current level of the logger
+func (l *Logger) Level() Level {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.level
+}
+
+// Enabled reports whether lines of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Debugf logs a debug line
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+// Infof logs an info line
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+// Warnf logs a warning line
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs an error line
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// Body returns the body for logging, replaced by its size when it is above
+// the configured threshold
+func (l *Logger) Body(body []byte) string {
+	if l.out.maxBodySize > 0 && len(body) > l.out.maxBodySize {
+		return fmt.Sprintf("<%d bytes redacted>", len(body))
+	}
+	return string(body)
+}
+
+func (l *Logger) log(level Level, msg string) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if level < l.out.level {
+		return
+	}
+	now := time.Now()
+	var line []byte
+	if l.out.json {
+		entry := make(map[string]interface{}, len(l.fields)+3)
+		for k, v := range l.fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			entry[k] = v
+		}
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = msg
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			line = []byte(fmt.Sprintf(`{"level":"ERROR","msg":%q}`,
+				err.Error()))
+		}
+	} else {
+		var b strings.Builder
+		b.WriteString(now.Format("2006/01/02 15:04:05 "))
+		b.WriteString(level.String())
+		b.WriteByte(' ')
+		b.WriteString(strings.TrimRight(msg, "\n"))
+		keys := make([]string, 0, len(l.fields))
+		for k := range l.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, l.fields[k])
+		}
+		line = []byte(b.String())
+	}
+	line = append(line, '\n')
+	_, _ = l.out.w.Write(line)
+}
+
+// logWriter routes the standard log package output to a Logger
+type logWriter struct {
+	l *Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.log(InfoLevel, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = &Logger{out: &output{w: os.Stderr, level: InfoLevel,
+		maxBodySize: defaultMaxBodySize, redact: sensitiveHeaders}}
+)
+
+// Default returns the process wide logger
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the process wide logger. The standard log package
+// output is redirected to it
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defaultLogger = l
+	defaultMu.Unlock()
+	log.SetFlags(0)
+	log.SetOutput(logWriter{l: l})
+}
+
+// Configure creates a logger writing to stderr from the configuration and
+// makes it the process wide logger
+func Configure(cfg config.LogConfig) error {
+	apply, err := Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare creates a logger writing to stderr from the configuration, and
+// returns the function making it the process wide logger
+func Prepare(cfg config.LogConfig) (func(), error) {
+	l, err := New(cfg, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return func() { SetDefault(l) }, nil
+}
+
+// Debugf logs a debug line with the default logger
+func Debugf(format string, args ...interface{}) {
+	Default().Debugf(format, args...)
+}
+
+// Infof logs an info line with the default logger
+func Infof(format string, args ...interface{}) {
+	Default().Infof(format, args...)
+}
+
+// Warnf logs a warning line with the default logger
+func Warnf(format string, args ...interface{}) {
+	Default().Warnf(format, args...)
+}
+
+// Errorf logs an error line with the default logger
+func Errorf(format string, args ...interface{}) {
+	Default().Errorf(format, args...)
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+)
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the default one
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// StatusWriter records the status code and size of a response
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+	Size   int
+}
+
+// WriteHeader records the status code
+func (w *StatusWriter) WriteHeader(code int) {
+	if w.Status == 0 {
+		w.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.Size += n
+	return n, err
+}
+
+// Flush sends the buffered data when the underlying writer supports it
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Push initiates an HTTP/2 server push when the underlying writer supports it
+func (w *StatusWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware attaches a logger carrying the request fields (method, path,
+// peer NF, request ID and correlation information) to the request context
+// and logs the status and latency of each request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		fields := Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"peer":   PeerNF(r),
+		}
+		if id := requestid.FromContext(r.Context()); id != "" {
+			fields["request_id"] = id
+		}
+		if info := requestid.CorrelationInfo(r.Context()); info != "" {
+			fields["correlation_info"] = info
+		}
+		l := Default().With(fields)
+		sw := &StatusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(NewContext(r.Context(), l)))
+		if sw.Status == 0 {
+			sw.Status = http.StatusOK
+		}
+		l.With(Fields{
+			"status":     sw.Status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+		}).Infof("request completed")
+	})
+}
+
+// PeerNF identifies the NF sending the request: the subject of its client
+// certificate with mutual TLS, its User-Agent otherwise
+func PeerNF(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if ua := r.UserAgent(); ua != "" {
+		return ua
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,241 @@
+// Package message carries the NF messages in a versioned envelope, so that
+// their model evolves without breaking the older peers. The version is
+// negotiated with the version parameter of the media types: a body whose
+// Content-Type has no version is a version 1 message, the bare payload the
+// older peers exchange, and the responses are written in the highest
+// version the Accept header of the request carries. From version 2 on the
+// body is an Envelope naming the message type and carrying metadata, e.g.
+//
+//	Content-Type: application/json; version=2
+//
+//	{"version":2,"type":"RequestNF2Location","payload":{...},"metadata":{...}}
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Param is the media type parameter carrying the message version
+const Param = "version"
+
+// Message versions
+const (
+	// V1 is the bare payload, without envelope
+	V1 = 1
+	// V2 is the payload in an Envelope
+	V2 = 2
+	// Current is the version sent when none is configured
+	Current = V2
+)
+
+// Envelope is a message of version 2 or later
+type Envelope struct {
+	Version int `json:"version"`
+	// Type is the message type, the operation ID of the API operation
+	// sending it, e.g. RequestNF2Location
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+	// Metadata are the details of the message not part of the payload,
+	// e.g. the sending NF
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// UnsupportedVersionError is returned for a message version not handled
+type UnsupportedVersionError struct {
+	Version string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported message version %q, expected %d to %d",
+		e.Version, V1, Current)
+}
+
+// Version returns the message version of a Content-Type, V1 without the
+// version parameter
+func Version(contentType string) (int, error) {
+	if contentType == "" {
+		return V1, nil
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		/* the codec lookup reports the malformed media types */
+		return V1, nil
+	}
+	return parseVersion(params)
+}
+
+func parseVersion(params map[string]string) (int, error) {
+	v, ok := params[Param]
+	if !ok {
+		return V1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < V1 || n > Current {
+		return 0, &UnsupportedVersionError{Version: v}
+	}
+	return n, nil
+}
+
+// Accepted returns the highest supported version among the media ranges
+// of an Accept header, V1 when none carries a version
+func Accepted(accept string) int {
+	version := V1
+	for _, item := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		if n, err := parseVersion(params); err == nil && n > version {
+			version = n
+		}
+	}
+	return version
+}
+
+// ContentType returns the Content-Type with the version parameter, as it is
+// for V1
+func ContentType(contentType string, version int) string {
+	if version <= V1 {
+		return contentType
+	}
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	params[Param] = strconv.Itoa(version)
+	return mime.FormatMediaType(mt, params)
+}
+
+// Wrap returns the body of the message of type typ with the payload v in
+// the version: v itself for V1, its Envelope otherwise
+func Wrap(version int, typ string, v interface{},
+	metadata map[string]string) interface{} {
+	if version <= V1 {
+		return v
+	}
+	return &Envelope{Version: version, Type: typ, Payload: v,
+		Metadata: metadata}
+}
+
+// payload decodes the payload of an envelope into v, noting its presence
+type payload struct {
+	v   interface{}
+	set bool
+}
+
+func (p *payload) UnmarshalJSON(data []byte) error {
+	p.set = true
+	return json.Unmarshal(data, p.v)
+}
+
+// incoming is a received envelope
+type incoming struct {
+	Version  int               `json:"version"`
+	Type     string            `json:"type"`
+	Payload  *payload          `json:"payload"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// check checks that the envelope is of the version of its Content-Type and
+// of the message type expected
+func (in *incoming) check(version int, typ string, set bool) error {
+	switch {
+	case in.Version != version:
+		return fmt.Errorf("message version %d in a version %d body",
+			in.Version, version)
+	case in.Type != typ:
+		return fmt.Errorf("message type %q, expected %s", in.Type, typ)
+	case !set:
+		return errors.New("message without payload")
+	}
+	return nil
+}
+
+// Unmarshal decodes the message of type typ from data of the Content-Type
+// with the codec, the payload into v. It returns the metadata of the
+// envelope, nil for V1
+func Unmarshal(cd codec.Codec, data []byte, contentType, typ string,
+	v interface{}) (map[string]string, error) {
+	version, err := Version(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if version == V1 {
+		return nil, cd.Unmarshal(data, contentType, v)
+	}
+	p := &payload{v: v}
+	in := incoming{Payload: p}
+	if err := cd.Unmarshal(data, contentType, &in); err != nil {
+		return nil, err
+	}
+	if err := in.check(version, typ, p.set); err != nil {
+		return nil, err
+	}
+	return in.Metadata, nil
+}
+
+// Payload returns the JSON payload of the message of type typ, JSON data
+// of the Content-Type, e.g. for the schema validation. The data of V1 is
+// returned as it is
+func Payload(data []byte, contentType, typ string) ([]byte, error) {
+	version, err := Version(contentType)
+	if err != nil || version == V1 {
+		return data, err
+	}
+	var raw json.RawMessage
+	p := &payload{v: &raw}
+	in := incoming{Payload: p}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, err
+	}
+	if err := in.check(version, typ, p.set); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Problem returns the problem details answering a message that cannot be
+// decoded: 415 for an unsupported version, 400 otherwise
+func Problem(err error) *problem.Details {
+	var unsupported *UnsupportedVersionError
+	if errors.As(err, &unsupported) {
+		return problem.New(http.StatusUnsupportedMediaType, "", err.Error())
+	}
+	return problem.FromDecodeError(err)
+}
+
+// Write encodes the message of type typ with the payload v in the media
+// type and the version negotiated with the Accept header of the request,
+// and writes it with the status. A 406 problem is written when no codec is
+// acceptable
+func Write(w http.ResponseWriter, r *http.Request, status int, typ string,
+	v interface{}, metadata map[string]string) {
+	w.Header().Add("Vary", "Accept")
+	accept := r.Header.Get("Accept")
+	c, ok := codec.Negotiate(accept)
+	if !ok {
+		problem.Write(w, problem.New(http.StatusNotAcceptable, "",
+			"acceptable media types: "+
+				strings.Join(codec.MediaTypes(), ", ")))
+		return
+	}
+	version := Accepted(accept)
+	data, contentType, err := c.Marshal(Wrap(version, typ, v, metadata))
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ContentType(contentType, version))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
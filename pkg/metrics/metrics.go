@@ -0,0 +1,387 @@
+// Package metrics implements counters, gauges and histograms with labels and
+// exposes them in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds the metrics exposed by Handler
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// DefaultRegistry is the registry the New functions register metrics with
+var DefaultRegistry = &Registry{}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes all the metrics of the registry in the text format
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	_ = bw.Flush()
+}
+
+// Handler serves the metrics of the default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		DefaultRegistry.WriteText(w)
+	})
+}
+
+// desc is the description shared by all the metric types
+type desc struct {
+	metricName string
+	help       string
+	labels     []string
+	kind       string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName,
+		strings.Replace(d.help, "\n", " ", -1), d.metricName, d.kind)
+}
+
+func (d *desc) checkValues(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d",
+			d.metricName, len(d.labels), len(values)))
+	}
+}
+
+// labelString formats the labels and values as {a="x",b="y"}
+func labelString(labels, values []string, extra ...string) string {
+	if len(labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)+len(extra)/2)
+	for i, l := range labels {
+		parts = append(parts, l+"="+strconv.Quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// children stores the children of a vector by label values
+type children struct {
+	mu    sync.Mutex
+	byKey map[string]interface{}
+	keys  map[string][]string
+}
+
+func (c *children) get(values []string, create func() interface{}) interface{} {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if child, ok := c.byKey[key]; ok {
+		return child
+	}
+	if c.byKey == nil {
+		c.byKey = make(map[string]interface{})
+		c.keys = make(map[string][]string)
+	}
+	child := create()
+	c.byKey[key] = child
+	c.keys[key] = append([]string{}, values...)
+	return child
+}
+
+func (c *children) each(f func(values []string, child interface{})) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.byKey))
+	for k := range c.byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type kv struct {
+		values []string
+		child  interface{}
+	}
+	items := make([]kv, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, kv{c.keys[k], c.byKey[k]})
+	}
+	c.mu.Unlock()
+	for _, it := range items {
+		f(it.values, it.child)
+	}
+}
+
+// value is a float updated under a lock
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *value) set(x float64) {
+	v.mu.Lock()
+	v.v = x
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add adds a positive delta to the counter
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.add(delta)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	return c.get()
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	desc
+	children
+}
+
+// NewCounterVec creates and registers a counter vector
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{metricName: name, help: help, labels: labels,
+		kind: "counter"}}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// WithLabelValues returns the counter of the label values
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	c.checkValues(values)
+	return c.get(values, func() interface{} {
+		return &Counter{}
+	}).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.each(func(values []string, child interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName,
+			labelString(c.labels, values), formatFloat(child.(*Counter).get()))
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value
+}
+
+// Set sets the gauge value
+func (g *Gauge) Set(v float64) {
+	g.set(v)
+}
+
+// Inc adds one to the gauge
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+// Dec subtracts one from the gauge
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return g.get()
+}
+
+// GaugeVec is a set of gauges partitioned by label values
+type GaugeVec struct {
+	desc
+	children
+}
+
+// NewGaugeVec creates and registers a gauge vector
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{desc: desc{metricName: name, help: help, labels: labels,
+		kind: "gauge"}}
+	DefaultRegistry.register(g)
+	return g
+}
+
+// WithLabelValues returns the gauge of the label values
+func (g *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	g.checkValues(values)
+	return g.get(values, func() interface{} {
+		return &Gauge{}
+	}).(*Gauge)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.header(w)
+	g.each(func(values []string, child interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName,
+			labelString(g.labels, values), formatFloat(child.(*Gauge).get()))
+	})
+}
+
+// GaugeFunc is a gauge whose value is read from a function when exposed
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge reading its value from f
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{metricName: name, help: help, kind: "gauge"},
+		f: f}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.f()))
+}
+
+// DefBuckets are the default histogram buckets, in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	desc
+	children
+	buckets []float64
+}
+
+// NewHistogramVec creates and registers a histogram vector. DefBuckets are
+// used when buckets is nil
+func NewHistogramVec(name, help string, buckets []float64,
+	labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{desc: desc{metricName: name, help: help,
+		labels: labels, kind: "histogram"}, buckets: buckets}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// WithLabelValues returns the histogram of the label values
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	h.checkValues(values)
+	return h.get(values, func() interface{} {
+		return &Histogram{buckets: h.buckets,
+			counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.each(func(values []string, child interface{}) {
+		hist := child.(*Histogram)
+		hist.mu.Lock()
+		defer hist.mu.Unlock()
+		for i, b := range hist.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+				labelString(h.labels, values, "le", formatFloat(b)),
+				hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+			labelString(h.labels, values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName,
+			labelString(h.labels, values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName,
+			labelString(h.labels, values), hist.count)
+	})
+}
@@ -0,0 +1,325 @@
+// Package mock answers the API of an NF with canned or scripted responses:
+// a status, a delay, a body rendered from the request and a callback sent
+// afterwards, so that its peers are developed and tested without it. The
+// body and callback templates are Go templates of Data, with a json
+// function encoding a value, e.g. {{json .Body.correlationid}}
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/server"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+// Limits of the mock exchanges
+const (
+	maxRequestBody  = 1 << 20
+	callbackTimeout = 30 * time.Second
+)
+
+var responses = metrics.NewCounterVec("nf_mock_responses_total",
+	"Responses answered by the mock peer mode by route and status code.",
+	"path", "code")
+
+// Data is the request the templates of a response are rendered with
+type Data struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Body is the decoded JSON request body, nil when it is not JSON
+	Body interface{}
+	// RawBody is the request body as received
+	RawBody string
+	// Location is the URI of the mock NF endpoint, as the real NF sends it
+	Location string
+	// Now is the current time, in the format of the NF messages
+	Now string
+	// UUID is a random UUID, e.g. a resource identifier
+	UUID string
+	// Count is the number of the request among those of the rule, from 1
+	Count int
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Mock answers the routes of its rules
+type Mock struct {
+	rules    []*rule
+	client   *client.Client
+	location string
+}
+
+type rule struct {
+	cfg       config.MockRule
+	responses []*response
+
+	mu    sync.Mutex
+	count int
+}
+
+type response struct {
+	cfg      config.MockResponse
+	body     *template.Template
+	url      *template.Template
+	callback *template.Template
+}
+
+// New creates a mock answering with the rules of cfg, sending the callbacks
+// with c, which checks their URIs. location is the URI of its endpoint in
+// the templates
+func New(cfg config.MockConfig, c *client.Client, location string) (*Mock,
+	error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	m := &Mock{client: c, location: location}
+	for i, rc := range cfg.Rules {
+		r := &rule{cfg: rc}
+		for j, resp := range rc.Responses {
+			name := fmt.Sprintf("mock.rules[%d].responses[%d]", i, j)
+			compiled, err := compile(name, resp)
+			if err != nil {
+				return nil, err
+			}
+			r.responses = append(r.responses, compiled)
+		}
+		if len(r.responses) == 0 {
+			r.responses = []*response{{body: template.Must(
+				template.New("").Parse(""))}}
+		}
+		m.rules = append(m.rules, r)
+	}
+	return m, nil
+}
+
+// compile parses the templates of the response
+func compile(name string, cfg config.MockResponse) (*response, error) {
+	parse := func(field, text string) (*template.Template, error) {
+		t, err := template.New(name + "." + field).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", name, field, err)
+		}
+		return t, nil
+	}
+	r := &response{cfg: cfg}
+	var err error
+	if r.body, err = parse("body", cfg.Body); err != nil {
+		return nil, err
+	}
+	if cfg.Callback != nil {
+		if r.url, err = parse("callback.url", cfg.Callback.URL); err != nil {
+			return nil, err
+		}
+		if r.callback, err = parse("callback.body",
+			cfg.Callback.Body); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register registers the routes of the rules on the router
+func (m *Mock) Register(router *server.Router) {
+	seen := make(map[string]bool)
+	for _, r := range m.rules {
+		if seen[r.cfg.Path] {
+			continue
+		}
+		seen[r.cfg.Path] = true
+		router.Handle(r.cfg.Path, m.handler(r.cfg.Path))
+	}
+	logging.Infof("Mock peer mode: %d rules", len(m.rules))
+}
+
+// handler answers the requests of the path with the first rule matching
+// their method
+func (m *Mock) handler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var methods []string
+		for _, rl := range m.rules {
+			if rl.cfg.Path != path {
+				continue
+			}
+			if rl.cfg.Method == "" || strings.EqualFold(rl.cfg.Method,
+				r.Method) {
+				m.answer(w, r, path, rl)
+				return
+			}
+			methods = append(methods, strings.ToUpper(rl.cfg.Method))
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		problem.Error(w, http.StatusMethodNotAllowed, "",
+			r.Method+" not allowed")
+	})
+}
+
+// next returns the response of the rule to the request and its number
+func (rl *rule) next() (*response, int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	i := rl.count
+	rl.count++
+	switch {
+	case i < len(rl.responses):
+	case rl.cfg.Cycle:
+		i %= len(rl.responses)
+	default:
+		i = len(rl.responses) - 1
+	}
+	return rl.responses[i], rl.count
+}
+
+// answer answers the request with the next response of the rule, then
+// sends its callback
+func (m *Mock) answer(w http.ResponseWriter, r *http.Request, path string,
+	rl *rule) {
+	l := logging.FromContext(r.Context())
+	resp, count := rl.next()
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+	if err != nil {
+		problem.Error(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	data := Data{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(),
+		Header: r.Header, RawBody: string(raw), Location: m.location,
+		Now: time.Now().Format(time.RFC3339Nano), UUID: uuid.New(), Count: count}
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &data.Body)
+	}
+
+	if !sleep(r.Context(), resp.cfg.Delay, resp.cfg.DelayJitter) {
+		return
+	}
+	var body bytes.Buffer
+	if err := resp.body.Execute(&body, data); err != nil {
+		l.Errorf("Mock body not rendered: %v", err)
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	for name, value := range resp.cfg.Headers {
+		w.Header().Set(name, value)
+	}
+	if w.Header().Get("Content-Type") == "" && body.Len() > 0 {
+		w.Header().Set("Content-Type", contentType(body.Bytes()))
+	}
+	status := resp.cfg.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+	responses.WithLabelValues(path, strconv.Itoa(status)).Inc()
+	l.Infof("Mock answered %s %s with %d", r.Method, r.URL.Path, status)
+
+	if resp.cfg.Callback != nil {
+		go m.callback(l, resp, data)
+	}
+}
+
+// callback sends the callback of the response after its delay, to a URI
+// the callbacks configuration accepts, the URI coming from the request
+func (m *Mock) callback(l *logging.Logger, resp *response, data Data) {
+	cb := resp.cfg.Callback
+	ctx, cancel := context.WithTimeout(logging.NewContext(
+		context.Background(), l), callbackTimeout+
+		time.Duration(cb.Delay)*time.Millisecond)
+	defer cancel()
+	if !sleep(ctx, cb.Delay, 0) {
+		return
+	}
+	var target, body bytes.Buffer
+	if err := resp.url.Execute(&target, data); err != nil {
+		l.Errorf("Mock callback URL not rendered: %v", err)
+		return
+	}
+	if err := resp.callback.Execute(&body, data); err != nil {
+		l.Errorf("Mock callback body not rendered: %v", err)
+		return
+	}
+	if err := m.client.CheckCallback(ctx, target.String()); err != nil {
+		l.Errorf("Mock callback not sent: %v", err)
+		return
+	}
+	method := cb.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(m.client.CallbackContext(ctx),
+		method, target.String(), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		l.Errorf("Mock callback not sent: %v", err)
+		return
+	}
+	if body.Len() > 0 {
+		req.Header.Set("Content-Type", contentType(body.Bytes()))
+	} else {
+		req.Body = http.NoBody
+	}
+	for name, value := range cb.Headers {
+		req.Header.Set(name, value)
+	}
+	rsp, err := m.client.Do(req)
+	if err != nil {
+		l.Errorf("Mock callback %s %s failed: %v", method, req.URL, err)
+		return
+	}
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	_ = rsp.Body.Close()
+	l.Infof("Mock callback %s %s answered %d", method, req.URL,
+		rsp.StatusCode)
+}
+
+// contentType returns JSON for the bodies looking like JSON, plain text
+// otherwise
+func contentType(body []byte) string {
+	b := bytes.TrimSpace(body)
+	if len(b) > 0 && (b[0] == '{' || b[0] == '[') {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// sleep waits the delay in milliseconds plus up to jitter, false when the
+// context ended first
+func sleep(ctx context.Context, delay, jitter int) bool {
+	d := time.Duration(delay) * time.Millisecond
+	if jitter > 0 {
+		d += time.Duration(rand.Intn(jitter+1)) * time.Millisecond
+	}
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
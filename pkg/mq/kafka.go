@@ -0,0 +1,71 @@
+package mq
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// kafkaBatchTimeout bounds the time a message waits for others to be
+// written with it, the publications waiting for the write
+const kafkaBatchTimeout = 5 * time.Millisecond
+
+// kafkaTransport publishes to the Kafka topics and consumes them within
+// consumer groups, committing the offset of each message once handled
+type kafkaTransport struct {
+	brokers []string
+	writer  *kafka.Writer
+	dialer  *kafka.Dialer
+}
+
+func newKafka(brokers []string, tlsConfig *tls.Config, username,
+	password string, timeout time.Duration) *kafkaTransport {
+	var mechanism sasl.Mechanism
+	if username != "" {
+		mechanism = plain.Mechanism{Username: username, Password: password}
+	}
+	return &kafkaTransport{
+		brokers: brokers,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: kafkaBatchTimeout,
+			RequiredAcks: kafka.RequireAll,
+			Transport: &kafka.Transport{TLS: tlsConfig, SASL: mechanism,
+				DialTimeout: timeout},
+		},
+		dialer: &kafka.Dialer{Timeout: timeout, DualStack: true,
+			TLS: tlsConfig, SASLMechanism: mechanism},
+	}
+}
+
+func (k *kafkaTransport) publish(ctx context.Context, topic, key string,
+	value []byte) error {
+	return k.writer.WriteMessages(ctx, kafka.Message{Topic: topic,
+		Key: []byte(key), Value: value})
+}
+
+func (k *kafkaTransport) consume(ctx context.Context, topic, group string,
+	handle func(context.Context, message)) error {
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: k.brokers,
+		GroupID: group, Topic: topic, Dialer: k.dialer})
+	defer r.Close()
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			return err
+		}
+		handle(ctx, message{value: m.Value})
+		if err := r.CommitMessages(ctx, m); err != nil {
+			return err
+		}
+	}
+}
+
+func (k *kafkaTransport) close() error {
+	return k.writer.Close()
+}
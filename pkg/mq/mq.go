@@ -0,0 +1,344 @@
+// Package mq decouples the NF from its peers through a message queue,
+// Kafka or NATS: the NF events are published to a topic for the consumers
+// not subscribed over HTTP, and the callbacks may be published as SBI
+// requests to a topic instead of being sent, the NFs consuming it sending
+// them in turn
+package mq
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+)
+
+const (
+	defaultQueueSize = 256
+	defaultTimeout   = 5000
+	// maxResponseBody is the part of the response body of a consumed
+	// request sent back to the requester
+	maxResponseBody = 64 << 10
+)
+
+var (
+	published = metrics.NewCounterVec("nf_mq_published_total",
+		"Messages published to the message queue by topic and result: "+
+			"published, failed or dropped.", "topic", "result")
+	consumed = metrics.NewCounterVec("nf_mq_consumed_total",
+		"SBI requests consumed from the message queue by topic and result: "+
+			"sent, failed or invalid.", "topic", "result")
+)
+
+// Event is the message of an NF event
+type Event struct {
+	Event     string      `json:"event"`
+	NF        string      `json:"nf"`
+	TimeStamp time.Time   `json:"timeStamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Request is the message of an SBI request, sent by the consumers
+type Request struct {
+	Method string            `json:"method"`
+	URI    string            `json:"uri"`
+	Header map[string]string `json:"headers,omitempty"`
+	// Body is the JSON body of the request
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Response is the reply of a consumer to a NATS request carrying an SBI
+// request
+type Response struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"headers,omitempty"`
+	// Body is the JSON body of the response, omitted for the other media
+	// types
+	Body  json.RawMessage `json:"body,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// message is a message consumed from a topic
+type message struct {
+	value []byte
+	// reply answers the message, nil when no answer is expected
+	reply func([]byte) error
+}
+
+// transport is the client of a message queue backend
+type transport interface {
+	publish(ctx context.Context, topic, key string, value []byte) error
+	// consume hands the messages of the topic to handle, sharing them
+	// with the consumers of the group, until the context is canceled or
+	// the consumption fails
+	consume(ctx context.Context, topic, group string,
+		handle func(context.Context, message)) error
+	close() error
+}
+
+// Queue publishes the events and the callbacks of the NF and sends the
+// requests it consumes
+type Queue struct {
+	cfg     config.MQConfig
+	nf      string
+	t       transport
+	client  *client.Client
+	events  map[string]bool
+	timeout time.Duration
+	pending chan Event
+}
+
+// New connects to the brokers of the configuration for the NF, the client
+// sending the consumed requests. The TLS files default to files
+func New(cfg config.MQConfig, nf string, files config.TLSFiles,
+	c *client.Client) (*Queue, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled {
+		var err error
+		if tlsConfig, err = loadTLS(cfg.TLS, files); err != nil {
+			return nil, fmt.Errorf("mq.tls: %v", err)
+		}
+	}
+	password, err := secrets.Value(cfg.Password)
+	if err != nil {
+		return nil, fmt.Errorf("mq.password: %v", err)
+	}
+	q := &Queue{cfg: cfg, nf: nf, client: c,
+		timeout: time.Duration(cfg.Timeout) * time.Millisecond}
+	if q.timeout == 0 {
+		q.timeout = defaultTimeout * time.Millisecond
+	}
+	switch cfg.Backend {
+	case config.MQKafka:
+		q.t = newKafka(cfg.Brokers, tlsConfig, cfg.Username, password,
+			q.timeout)
+	case config.MQNATS:
+		if q.t, err = newNATS(cfg.Brokers, "nfservice-"+nf, tlsConfig,
+			cfg.Username, password); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Events) > 0 {
+		q.events = make(map[string]bool, len(cfg.Events))
+		for _, e := range cfg.Events {
+			q.events[e] = true
+		}
+	}
+	size := cfg.QueueSize
+	if size == 0 {
+		size = defaultQueueSize
+	}
+	q.pending = make(chan Event, size)
+	return q, nil
+}
+
+// loadTLS returns the TLS configuration of the connections to the brokers
+func loadTLS(cfg config.MQTLSConfig, files config.TLSFiles) (*tls.Config,
+	error) {
+	files = files.Override(cfg.TLSFiles)
+	pool, err := tlsutil.LoadCertPool(files.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tlsutil.LoadKeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert},
+		ServerName: cfg.ServerName, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Callbacks tells whether the callbacks are published instead of sent
+func (q *Queue) Callbacks() bool {
+	return q != nil && q.cfg.Callbacks
+}
+
+// Publish queues the event for publication to the events topic, unless its
+// type is not published. Events are dropped when the queue is full
+func (q *Queue) Publish(event string, data interface{}) {
+	if q == nil || q.cfg.EventsTopic == "" ||
+		(q.events != nil && !q.events[event]) {
+		return
+	}
+	select {
+	case q.pending <- Event{Event: event, NF: q.nf, TimeStamp: time.Now(),
+		Data: data}:
+	default:
+		logging.Warnf("Message queue full, event %s dropped", event)
+		published.WithLabelValues(q.cfg.EventsTopic, "dropped").Inc()
+	}
+}
+
+// PublishRequest publishes the request to the requests topic, for a
+// consumer to send it, and returns once the broker has it. The body must
+// be JSON
+func (q *Queue) PublishRequest(req *http.Request) error {
+	m := Request{Method: req.Method, URI: req.URL.String(),
+		Header: make(map[string]string, len(req.Header))}
+	for name := range req.Header {
+		m.Header[name] = req.Header.Get(name)
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		if len(body) > 0 && !json.Valid(body) {
+			return fmt.Errorf("%s %s: the body is not JSON", req.Method,
+				req.URL)
+		}
+		m.Body = body
+	}
+	value, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return q.send(req.Context(), q.cfg.RequestsTopic, m.URI, value)
+}
+
+// send publishes the message within the publication timeout
+func (q *Queue) send(ctx context.Context, topic, key string,
+	value []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	if err := q.t.publish(ctx, topic, key, value); err != nil {
+		published.WithLabelValues(topic, "failed").Inc()
+		return fmt.Errorf("publication to %s failed: %v", topic, err)
+	}
+	published.WithLabelValues(topic, "published").Inc()
+	return nil
+}
+
+// Run publishes the queued events and, in consumer mode, sends the
+// requests of the requests topic until the context is canceled. The
+// connections to the brokers are closed then
+func (q *Queue) Run(ctx context.Context) {
+	defer q.t.close()
+	if q.cfg.Consume {
+		done := make(chan struct{})
+		defer func() { <-done }()
+		go func() {
+			defer close(done)
+			q.consume(ctx)
+		}()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-q.pending:
+			value, err := json.Marshal(e)
+			if err == nil {
+				err = q.send(ctx, q.cfg.EventsTopic, e.Event, value)
+			}
+			if err != nil {
+				logging.Warnf("Event %s not published: %v", e.Event, err)
+			}
+		}
+	}
+}
+
+// consume sends the requests of the requests topic, consuming it again a
+// second after it failed
+func (q *Queue) consume(ctx context.Context) {
+	group := q.cfg.Group
+	if group == "" {
+		group = "nfservice-" + q.nf
+	}
+	for {
+		err := q.t.consume(ctx, q.cfg.RequestsTopic, group, q.handle)
+		if ctx.Err() != nil {
+			return
+		}
+		logging.Warnf("Consumption of %s stopped: %v", q.cfg.RequestsTopic,
+			err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// handle sends a consumed request and answers the response to the
+// requester expecting it
+func (q *Queue) handle(ctx context.Context, m message) {
+	topic := q.cfg.RequestsTopic
+	rsp := q.do(ctx, m.value)
+	result := "sent"
+	switch {
+	case rsp.Status == 0 && rsp.Error != "":
+		result = "invalid"
+	case rsp.Status/100 != 2:
+		result = "failed"
+	}
+	consumed.WithLabelValues(topic, result).Inc()
+	if m.reply == nil {
+		return
+	}
+	value, err := json.Marshal(rsp)
+	if err == nil {
+		err = m.reply(value)
+	}
+	if err != nil {
+		logging.Warnf("Response to the request of %s not sent: %v", topic,
+			err)
+	}
+}
+
+// do sends the request of the message and returns its response
+func (q *Queue) do(ctx context.Context, value []byte) Response {
+	var m Request
+	if err := json.Unmarshal(value, &m); err != nil {
+		logging.Warnf("Invalid request in %s: %v", q.cfg.RequestsTopic, err)
+		return Response{Error: "invalid request: " + err.Error()}
+	}
+	if m.Method == "" {
+		m.Method = http.MethodPost
+	}
+	req, err := http.NewRequest(m.Method, m.URI, bytes.NewReader(m.Body))
+	if err != nil {
+		logging.Warnf("Invalid request in %s: %v", q.cfg.RequestsTopic, err)
+		return Response{Error: "invalid request: " + err.Error()}
+	}
+	for name, v := range m.Header {
+		req.Header.Set(name, v)
+	}
+	if len(m.Body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := q.client.Do(req.WithContext(q.client.CallbackContext(ctx)))
+	if err != nil {
+		logging.Warnf("%s %s from %s failed: %v", m.Method, m.URI,
+			q.cfg.RequestsTopic, err)
+		return Response{Status: http.StatusBadGateway, Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	logging.Infof("%s %s from %s answered %d", m.Method, m.URI,
+		q.cfg.RequestsTopic, resp.StatusCode)
+	r := Response{Status: resp.StatusCode,
+		Header: make(map[string]string, len(resp.Header))}
+	for name := range resp.Header {
+		r.Header[name] = resp.Header.Get(name)
+	}
+	if json.Valid(body) {
+		r.Body = body
+	}
+	return r
+}
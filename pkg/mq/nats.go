@@ -0,0 +1,71 @@
+package mq
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBuffer is the number of consumed messages waiting to be handled
+const natsBuffer = 64
+
+// natsTransport publishes to the NATS subjects and consumes them within
+// queue groups. The requests published with a reply subject are answered
+// with their response
+type natsTransport struct {
+	conn *nats.Conn
+}
+
+func newNATS(servers []string, name string, tlsConfig *tls.Config,
+	username, password string) (*natsTransport, error) {
+	opts := []nats.Option{nats.Name(name), nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true)}
+	if tlsConfig != nil {
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+	if username != "" {
+		opts = append(opts, nats.UserInfo(username, password))
+	}
+	conn, err := nats.Connect(strings.Join(servers, ","), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &natsTransport{conn: conn}, nil
+}
+
+func (n *natsTransport) publish(ctx context.Context, subject, _ string,
+	value []byte) error {
+	if err := n.conn.Publish(subject, value); err != nil {
+		return err
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *natsTransport) consume(ctx context.Context, subject, group string,
+	handle func(context.Context, message)) error {
+	ch := make(chan *nats.Msg, natsBuffer)
+	sub, err := n.conn.ChanQueueSubscribe(subject, group, ch)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m := <-ch:
+			msg := message{value: m.Data}
+			if m.Reply != "" {
+				msg.reply = m.Respond
+			}
+			handle(ctx, msg)
+		}
+	}
+}
+
+func (n *natsTransport) close() error {
+	n.conn.Close()
+	return nil
+}
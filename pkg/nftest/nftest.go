@@ -0,0 +1,315 @@
+// Package nftest runs NF1 and NF2 in the process for the integration
+// tests: the pair listens on ephemeral ports, with TLS certificates of its
+// own for HTTP/2, and the location exchange is driven and checked through
+// the NF1 API like a client would. The roles keep their state in package
+// variables, so one pair runs at a time in a process
+package nftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/internal/nf1"
+	"github.com/Nishat-Zaman/nfservice_http2/internal/nf2"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// Default settings of the pair
+const (
+	defaultVersion  = 2
+	defaultLogLevel = "warn"
+	startTimeout    = 10 * time.Second
+)
+
+// Options are the settings of a pair
+type Options struct {
+	// Version is the HTTP version, 1 or 2 over TLS. 2 when 0
+	Version int
+	// LogLevel of the roles, warn when empty
+	LogLevel string
+	// NF1 and NF2 are "path=value" overrides of the configuration of the
+	// roles, e.g. "jobs.enabled=true"
+	NF1 config.Overrides
+	NF2 config.Overrides
+}
+
+// Pair is an NF1 and an NF2 exchanging their locations
+type Pair struct {
+	// API is the API root of the NF1 "API" server, serving /nf2loc
+	API string
+	// NF1 and NF2 are the API roots of the "NF" server of NF1 and of the
+	// "NF2" server, receiving the location requests and reports
+	NF1 string
+	NF2 string
+	// Client sends requests to the pair, trusting its certificates
+	Client *client.Client
+
+	dir    string
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	errs   []error
+}
+
+// Start starts NF2, then NF1 reaching it, and returns once both serve.
+// The pair is stopped by Close
+func Start(o Options) (*Pair, error) {
+	if o.Version == 0 {
+		o.Version = defaultVersion
+	}
+	if o.LogLevel == "" {
+		o.LogLevel = defaultLogLevel
+	}
+	dir, err := ioutil.TempDir("", "nftest")
+	if err != nil {
+		return nil, err
+	}
+	p := &Pair{dir: dir}
+	if err := p.start(o); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Pair) start(o Options) error {
+	scheme := "http"
+	var files config.TLSFiles
+	if o.Version == 2 {
+		scheme = "https"
+		var err error
+		if files, err = writeCertificates(p.dir); err != nil {
+			return fmt.Errorf("generating the certificates: %v", err)
+		}
+	}
+	ports, err := freePorts(3)
+	if err != nil {
+		return err
+	}
+	apiPort, nf1Port, nf2Port := ports[0], ports[1], ports[2]
+	p.API = scheme + "://localhost:" + apiPort
+	p.NF1 = scheme + "://localhost:" + nf1Port
+	p.NF2 = scheme + "://localhost:" + nf2Port
+
+	common := map[string]interface{}{
+		"localapirootprefix": "://localhost",
+		"tls":                files,
+		"admin":              map[string]interface{}{"enabled": false},
+	}
+	sections := map[string]map[string]interface{}{
+		"nf1": {
+			"remotenfapiroot": "://localhost:" + nf2Port + "/nf2",
+			"HTTPConfig": map[string]string{
+				"apiendpoint": ":" + apiPort,
+				"nfendpoint":  ":" + nf1Port,
+			},
+		},
+		"nf2": {"nfendpoint": ":" + nf2Port},
+	}
+	for _, section := range sections {
+		for k, v := range common {
+			section[k] = v
+		}
+	}
+	data, err := json.MarshalIndent(sections, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(p.dir, "pair.json")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return err
+	}
+
+	var clientCfg config.Common
+	clientCfg.TLS.TLSFiles = files
+	/* its certificate is presented as well, for the pairs overridden to
+	   require mutual TLS */
+	clientCfg.TLS.MutualTLS = true
+	if p.Client, err = client.New(o.Version, "nftest",
+		clientCfg); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	opts := config.Options{HTTPVersion: o.Version, ConfigFile: file,
+		LogLevel: o.LogLevel}
+	nf2Opts, nf1Opts := opts, opts
+	nf2Opts.Section, nf2Opts.Overrides = "nf2", o.NF2
+	nf1Opts.Section, nf1Opts.Overrides = "nf1", o.NF1
+	p.run(ctx, "NF2", nf2.Run, nf2Opts)
+	if err := p.wait(p.NF2); err != nil {
+		return fmt.Errorf("NF2: %v", err)
+	}
+	p.run(ctx, "NF1", nf1.Run, nf1Opts)
+	for _, root := range []string{p.API, p.NF1} {
+		if err := p.wait(root); err != nil {
+			return fmt.Errorf("NF1: %v", err)
+		}
+	}
+	return nil
+}
+
+// run runs the role until the context is canceled, recording its error
+func (p *Pair) run(ctx context.Context, name string,
+	run func(context.Context, config.Options) error, o config.Options) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := run(ctx, o); err != nil {
+			p.mu.Lock()
+			p.errs = append(p.errs, fmt.Errorf("%s: %v", name, err))
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// Err returns the errors the roles stopped with, nil while they run
+func (p *Pair) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs[0]
+}
+
+// wait waits until the server of the API root answers its /healthz probe
+func (p *Pair) wait(root string) error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		if err := p.Err(); err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodGet, root+"/healthz", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := p.Client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("%s/healthz returned %d", root, resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not serving after %v: %v", startTimeout, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Close stops the pair and removes its files. It returns the error a role
+// failed with, if any
+func (p *Pair) Close() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+	os.RemoveAll(p.dir)
+	return p.Err()
+}
+
+// freePorts returns n ports free on all the interfaces
+func freePorts(n int) ([]string, error) {
+	var ports []string
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return nil, err
+		}
+		/* kept open until all are chosen so that they differ */
+		defer l.Close()
+		ports = append(ports,
+			strconv.Itoa(l.Addr().(*net.TCPAddr).Port))
+	}
+	return ports, nil
+}
+
+// Location requests the location of NF2 from the NF1 API, which returns
+// the report NF2 sent to NF1 for the request, and checks it: a correlation
+// ID and the location of the NF2 server
+func (p *Pair) Location(ctx context.Context) (api.NF, error) {
+	var msg api.NF
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		p.API+api.GetNF2LocationPath, nil)
+	if err != nil {
+		return msg, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return msg, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return msg, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return msg, fmt.Errorf("%s returned %d: %s", api.GetNF2LocationPath,
+			resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return msg, fmt.Errorf("decoding %s: %v", body, err)
+	}
+	if msg.CorrelationID == "" {
+		return msg, fmt.Errorf("no correlation ID in %s", body)
+	}
+	if want := p.NF2 + api.RequestNF2LocationPath; msg.Location != want {
+		return msg, fmt.Errorf("location %q, expected %q", msg.Location,
+			want)
+	}
+	if msg.Time.IsZero() {
+		return msg, fmt.Errorf("no time in %s", body)
+	}
+	return msg, nil
+}
+
+// Concurrent sends n location requests at once and checks each answer as
+// Location does. The correlation IDs must all differ: a report delivered
+// to the wrong waiting request shows as a duplicate. Run it with the race
+// detector to check the shared state of the exchange
+func (p *Pair) Concurrent(ctx context.Context, n int) error {
+	type result struct {
+		msg api.NF
+		err error
+	}
+	results := make(chan result, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			msg, err := p.Location(ctx)
+			results <- result{msg, err}
+		}()
+	}
+	var errs []error
+	seen := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		r := <-results
+		switch {
+		case r.err != nil:
+			errs = append(errs, r.err)
+		case seen[r.msg.CorrelationID]:
+			errs = append(errs, fmt.Errorf("correlation ID %q answered twice",
+				r.msg.CorrelationID))
+		}
+		seen[r.msg.CorrelationID] = true
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d requests failed, first: %v", len(errs), n,
+			errs[0])
+	}
+	return nil
+}
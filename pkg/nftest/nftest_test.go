@@ -0,0 +1,141 @@
+package nftest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/api"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/message"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/record"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/reply"
+)
+
+// recordTimeout bounds the wait for an exchange to be recorded, the client
+// side being written once its response is read
+const recordTimeout = 5 * time.Second
+
+// recording returns the overrides recording the requests a role sends to
+// its peer into file
+func recording(file string) config.Overrides {
+	return config.Overrides{"record.enabled=true", "record.file=" + file,
+		"record.client=true"}
+}
+
+// sent waits for the message of type typ the role sent to url for the
+// correlation ID in its recording, and returns it with its payload
+func sent(t *testing.T, file, url, typ, correlationID string) (
+	record.Exchange, api.NF) {
+	t.Helper()
+	deadline := time.Now().Add(recordTimeout)
+	for {
+		exchanges, err := record.ReadFile(file)
+		if err != nil {
+			t.Fatalf("reading %s: %v", file, err)
+		}
+		for _, e := range exchanges {
+			if e.URL != url {
+				continue
+			}
+			var body api.NF
+			data, err := message.Payload(e.RequestBody,
+				e.RequestHeader.Get("Content-Type"), typ)
+			if err != nil || json.Unmarshal(data, &body) != nil {
+				continue
+			}
+			if body.CorrelationID == correlationID {
+				return e, body
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no request to %s for %s in %s within %v", url,
+				correlationID, file, recordTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLocationExchange(t *testing.T) {
+	dir := t.TempDir()
+	nf1File := filepath.Join(dir, "nf1.jsonl")
+	nf2File := filepath.Join(dir, "nf2.jsonl")
+	pair, err := Start(Options{NF1: recording(nf1File),
+		NF2: recording(nf2File)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pair.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	msg, err := pair.Location(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* NF1 asks NF2 for its location, giving where to report it */
+	e, nf1Body := sent(t, nf1File, pair.NF2+api.RequestNF2LocationPath,
+		api.RequestNF2LocationMessage, msg.CorrelationID)
+	if e.Status != http.StatusOK ||
+		string(e.ResponseBody) != reply.DefaultBody {
+		t.Errorf("NF2 answered NF1 with %d %q, expected 200 %q", e.Status,
+			e.ResponseBody, reply.DefaultBody)
+	}
+	if want := pair.NF1 + api.ReportNF2LocationPath; nf1Body.Location !=
+		want {
+		t.Errorf("NF1 sent the location %q, expected %q", nf1Body.Location,
+			want)
+	}
+	if nf1Body.Time.IsZero() {
+		t.Errorf("NF1 sent no time")
+	}
+
+	/* NF2 reports its location there, which NF1 answers the API with */
+	e, nf2Body := sent(t, nf2File, pair.NF1+api.ReportNF2LocationPath,
+		api.ReportNF2LocationMessage, msg.CorrelationID)
+	if e.Status != http.StatusOK {
+		t.Errorf("NF1 answered the report of NF2 with %d: %s", e.Status,
+			e.ResponseBody)
+	}
+	if want := pair.NF2 + api.RequestNF2LocationPath; nf2Body.Location !=
+		want {
+		t.Errorf("NF2 reported the location %q, expected %q",
+			nf2Body.Location, want)
+	}
+	if !nf2Body.Time.Equal(msg.Time.Time) {
+		t.Errorf("NF1 answered the time %v, NF2 reported %v", msg.Time,
+			nf2Body.Time)
+	}
+	if nf2Body.Time.Before(nf1Body.Time.Time) {
+		t.Errorf("NF2 reported at %v, before NF1 asked at %v", nf2Body.Time,
+			nf1Body.Time)
+	}
+
+	if err := pair.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentLocations sends location requests at once to the NF1 API,
+// each having to get the report of its own exchange. Run it with -race to
+// check the state NF1 shares between them
+func TestConcurrentLocations(t *testing.T) {
+	pair, err := Start(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pair.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := pair.Concurrent(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
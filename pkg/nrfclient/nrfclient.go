@@ -0,0 +1,255 @@
+// Package nrfclient registers the NF profile with an NRF
+// (Nnrf_NFManagement), keeps the registration alive with heartbeats and
+// deregisters the NF on shutdown
+package nrfclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	nfInstancesPath       = "/nnrf-nfm/v1/nf-instances/"
+	defaultHeartBeatTimer = 60
+	// delay between two registration attempts
+	retryInterval = 5 * time.Second
+	// time allowed for the deregistration at shutdown
+	deregisterTimeout = 5 * time.Second
+)
+
+// Client manages the registration of an NF with the NRF
+type Client struct {
+	cfg    config.NRFConfig
+	client *client.Client
+
+	// mu guards the profile, whose heartbeat timer the NRF may change
+	mu      sync.Mutex
+	profile NFProfile
+}
+
+// New creates an NRF client for the NF. scheme is the scheme the NF services
+// are served on
+func New(cfg config.NRFConfig, c *client.Client, scheme string) *Client {
+	if cfg.NfInstanceID == "" {
+		cfg.NfInstanceID = uuid.New()
+	}
+	if cfg.HeartBeatTimer <= 0 {
+		cfg.HeartBeatTimer = defaultHeartBeatTimer
+	}
+	return &Client{cfg: cfg, client: c, profile: newProfile(cfg, scheme)}
+}
+
+// NfInstanceID returns the ID the NF is registered with
+func (n *Client) NfInstanceID() string {
+	return n.cfg.NfInstanceID
+}
+
+func newProfile(cfg config.NRFConfig, scheme string) NFProfile {
+	profile := NFProfile{
+		NfInstanceID:   cfg.NfInstanceID,
+		NfType:         cfg.NfType,
+		NfStatus:       StatusRegistered,
+		HeartBeatTimer: cfg.HeartBeatTimer,
+		Fqdn:           cfg.FQDN,
+		Ipv4Addresses:  cfg.IPv4Addresses,
+	}
+	for i, svc := range cfg.Services {
+		version := svc.APIVersion
+		if version == "" {
+			version = "v1"
+		}
+		nfService := NFService{
+			ServiceInstanceID: strconv.Itoa(i),
+			ServiceName:       svc.Name,
+			Versions: []NFVersion{{
+				APIVersionInURI: version,
+				APIFullVersion:  strings.TrimPrefix(version, "v") + ".0.0",
+			}},
+			Scheme:          scheme,
+			NfServiceStatus: StatusRegistered,
+		}
+		if ep, ok := ipEndPoint(svc.Endpoint); ok {
+			nfService.IPEndPoints = []IPEndPoint{ep}
+		}
+		profile.NfServices = append(profile.NfServices, nfService)
+	}
+	return profile
+}
+
+func ipEndPoint(endpoint string) (IPEndPoint, bool) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return IPEndPoint{}, false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return IPEndPoint{}, false
+	}
+	ep := IPEndPoint{Transport: "TCP", Port: port}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			ep.Ipv4Address = host
+		} else {
+			ep.Ipv6Address = host
+		}
+	}
+	return ep, true
+}
+
+// Run registers the NF, sends heartbeats until the context is canceled and
+// then deregisters the NF
+func (n *Client) Run(ctx context.Context) {
+	for {
+		err := n.Register(ctx)
+		if err == nil {
+			break
+		}
+		logging.Warnf("NRF registration failed: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+
+	period := n.heartBeatTimer()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			dctx, cancel := context.WithTimeout(context.Background(),
+				deregisterTimeout)
+			if err := n.Deregister(dctx); err != nil {
+				logging.Warnf("NRF deregistration failed: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := n.Heartbeat(ctx); err != nil {
+				logging.Warnf("NRF heartbeat failed: %v", err)
+			}
+			/* registering again takes the timer of the NRF */
+			if p := n.heartBeatTimer(); p != period {
+				period = p
+				ticker.Reset(period)
+			}
+		}
+	}
+}
+
+// heartBeatTimer returns the period of the heartbeats
+func (n *Client) heartBeatTimer() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return time.Duration(n.profile.HeartBeatTimer) * time.Second
+}
+
+// Register sends the NF profile to the NRF (NFRegister)
+func (n *Client) Register(ctx context.Context) error {
+	n.mu.Lock()
+	profile := n.profile
+	n.mu.Unlock()
+	resp, body, err := n.send(ctx, http.MethodPut, "application/json",
+		profile)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("NFRegister returned %d: %s", resp.StatusCode,
+			body)
+	}
+
+	// The NRF may change the heartbeat timer
+	var registered NFProfile
+	n.mu.Lock()
+	if err := json.Unmarshal(body, &registered); err == nil &&
+		registered.HeartBeatTimer > 0 {
+		n.profile.HeartBeatTimer = registered.HeartBeatTimer
+	}
+	timer := n.profile.HeartBeatTimer
+	n.mu.Unlock()
+	logging.Infof("NF %s registered with NRF, heartbeat every %ds",
+		n.cfg.NfInstanceID, timer)
+	return nil
+}
+
+// Heartbeat refreshes the registration (NFUpdate with a JSON patch). The NF
+// registers again when the NRF no longer knows it
+func (n *Client) Heartbeat(ctx context.Context) error {
+	patch := []map[string]interface{}{{
+		"op":    "replace",
+		"path":  "/nfStatus",
+		"value": StatusRegistered,
+	}}
+	resp, body, err := n.send(ctx, http.MethodPatch,
+		"application/json-patch+json", patch)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		logging.Warnf("NF %s unknown to NRF, registering again",
+			n.cfg.NfInstanceID)
+		return n.Register(ctx)
+	}
+	return fmt.Errorf("NFUpdate returned %d: %s", resp.StatusCode, body)
+}
+
+// Deregister removes the NF profile from the NRF (NFDeregister)
+func (n *Client) Deregister(ctx context.Context) error {
+	resp, body, err := n.send(ctx, http.MethodDelete, "", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent &&
+		resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("NFDeregister returned %d: %s", resp.StatusCode,
+			body)
+	}
+	logging.Infof("NF %s deregistered from NRF", n.cfg.NfInstanceID)
+	return nil
+}
+
+func (n *Client) send(ctx context.Context, method, contentType string,
+	v interface{}) (*http.Response, []byte, error) {
+	var reqBody []byte
+	if v != nil {
+		var err error
+		if reqBody, err = json.Marshal(v); err != nil {
+			return nil, nil, err
+		}
+	}
+	url := n.cfg.APIRoot + nfInstancesPath + n.cfg.NfInstanceID
+	req, err := http.NewRequest(method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, body, err
+}
@@ -0,0 +1,48 @@
+package nrfclient
+
+// NFProfile is the NF profile registered with the NRF (3GPP TS 29.510)
+type NFProfile struct {
+	NfInstanceID   string      `json:"nfInstanceId"`
+	NfType         string      `json:"nfType"`
+	NfStatus       string      `json:"nfStatus"`
+	HeartBeatTimer int         `json:"heartBeatTimer,omitempty"`
+	Fqdn           string      `json:"fqdn,omitempty"`
+	Ipv4Addresses  []string    `json:"ipv4Addresses,omitempty"`
+	Ipv6Addresses  []string    `json:"ipv6Addresses,omitempty"`
+	Priority       int         `json:"priority,omitempty"`
+	Capacity       int         `json:"capacity,omitempty"`
+	NfServices     []NFService `json:"nfServices,omitempty"`
+}
+
+// NFService is a service instance of the NF profile
+type NFService struct {
+	ServiceInstanceID string       `json:"serviceInstanceId"`
+	ServiceName       string       `json:"serviceName"`
+	Versions          []NFVersion  `json:"versions"`
+	Scheme            string       `json:"scheme"`
+	NfServiceStatus   string       `json:"nfServiceStatus"`
+	Fqdn              string       `json:"fqdn,omitempty"`
+	IPEndPoints       []IPEndPoint `json:"ipEndPoints,omitempty"`
+	APIPrefix         string       `json:"apiPrefix,omitempty"`
+	Priority          int          `json:"priority,omitempty"`
+	Capacity          int          `json:"capacity,omitempty"`
+}
+
+// NFVersion is an API version supported by an NF service
+type NFVersion struct {
+	APIVersionInURI string `json:"apiVersionInUri"`
+	APIFullVersion  string `json:"apiFullVersion"`
+}
+
+// IPEndPoint is an address an NF service is reachable on
+type IPEndPoint struct {
+	Ipv4Address string `json:"ipv4Address,omitempty"`
+	Ipv6Address string `json:"ipv6Address,omitempty"`
+	Transport   string `json:"transport,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+// NF and NF service status values
+const (
+	StatusRegistered = "REGISTERED"
+)
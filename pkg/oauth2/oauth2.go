@@ -0,0 +1,215 @@
+// Package oauth2 requests the OAuth2 access tokens of the outbound SBI
+// requests from the NRF (Nnrf_AccessToken, client credentials grant) and
+// caches them until they are about to expire
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	tokenPath            = "/oauth2/token"
+	defaultRefreshMargin = 30
+)
+
+var tokenRequests = metrics.NewCounterVec("nf_oauth2_token_requests_total",
+	"Access token requests sent to the NRF by target NF type and result.",
+	"target", "result")
+
+// Doer sends the token requests
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// AccessTokenRsp is the token endpoint response
+type AccessTokenRsp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// AccessTokenErr is the token endpoint error response
+type AccessTokenErr struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// key identifies a cached token
+type key struct {
+	targetNfType string
+	scope        string
+}
+
+type token struct {
+	value  string
+	expiry time.Time
+}
+
+// TokenClient provides the access tokens of the peers with a configured
+// scope. It implements client.Authorizer
+type TokenClient struct {
+	tokenURI     string
+	margin       time.Duration
+	nfInstanceID string
+	nfType       string
+	clientSecret string
+	doer         Doer
+
+	mu     sync.Mutex
+	peers  map[string]config.PeerConfig
+	tokens map[key]token
+}
+
+// New creates a token client for the NF instance, sending the token
+// requests with doer. An instance ID is generated when nfInstanceID is empty
+func New(cfg config.Common, nfInstanceID string, doer Doer) *TokenClient {
+	if nfInstanceID == "" {
+		nfInstanceID = uuid.New()
+	}
+	t := &TokenClient{
+		tokenURI:     cfg.OAuth2.TokenURI,
+		margin:       time.Duration(cfg.OAuth2.RefreshMargin) * time.Second,
+		nfInstanceID: nfInstanceID,
+		nfType:       cfg.NRF.NfType,
+		clientSecret: cfg.OAuth2.ClientSecret,
+		doer:         doer,
+		peers:        cfg.Peers,
+		tokens:       make(map[key]token),
+	}
+	if t.tokenURI == "" {
+		t.tokenURI = strings.TrimRight(cfg.NRF.APIRoot, "/") + tokenPath
+	}
+	if t.margin <= 0 {
+		t.margin = defaultRefreshMargin * time.Second
+	}
+	return t
+}
+
+// Reload applies the peer settings and the client secret of cfg and drops
+// the cached tokens
+func (t *TokenClient) Reload(cfg config.Common) {
+	t.mu.Lock()
+	t.peers = cfg.Peers
+	t.clientSecret = cfg.OAuth2.ClientSecret
+	t.tokens = make(map[key]token)
+	t.mu.Unlock()
+}
+
+// Token returns the access token of the peer host:port, empty when the peer
+// has no scope configured. Tokens are cached and renewed shortly before
+// they expire
+func (t *TokenClient) Token(ctx context.Context, host string) (string,
+	error) {
+	t.mu.Lock()
+	peer := t.peers[host]
+	k := key{targetNfType: peer.NfType, scope: peer.Scope}
+	cached, ok := t.tokens[k]
+	t.mu.Unlock()
+	if peer.Scope == "" {
+		return "", nil
+	}
+	if ok && time.Now().Add(t.margin).Before(cached.expiry) {
+		return cached.value, nil
+	}
+
+	rsp, err := t.request(ctx, k)
+	if err != nil {
+		tokenRequests.WithLabelValues(k.targetNfType, "error").Inc()
+		return "", err
+	}
+	tokenRequests.WithLabelValues(k.targetNfType, "ok").Inc()
+	expiresIn := rsp.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 3600
+	}
+	t.mu.Lock()
+	t.tokens[k] = token{value: rsp.AccessToken,
+		expiry: time.Now().Add(time.Duration(expiresIn) * time.Second)}
+	t.mu.Unlock()
+	logging.FromContext(ctx).Debugf("Access token for %s scope %q valid %ds",
+		host, k.scope, expiresIn)
+	return rsp.AccessToken, nil
+}
+
+// Invalidate drops the cached token of the peer, e.g. after the peer
+// rejected it with 401
+func (t *TokenClient) Invalidate(host string) {
+	t.mu.Lock()
+	peer := t.peers[host]
+	delete(t.tokens, key{targetNfType: peer.NfType, scope: peer.Scope})
+	t.mu.Unlock()
+}
+
+// request asks the NRF for a token
+func (t *TokenClient) request(ctx context.Context, k key) (*AccessTokenRsp,
+	error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("nfInstanceId", t.nfInstanceID)
+	form.Set("scope", k.scope)
+	if t.nfType != "" {
+		form.Set("nfType", t.nfType)
+	}
+	if k.targetNfType != "" {
+		form.Set("targetNfType", k.targetNfType)
+	}
+	t.mu.Lock()
+	clientSecret := t.clientSecret
+	t.mu.Unlock()
+	if clientSecret != "" {
+		secret, err := secrets.Value(clientSecret)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("client_id", t.nfInstanceID)
+		form.Set("client_secret", secret)
+	}
+	req, err := http.NewRequest(http.MethodPost, t.tokenURI,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.doer.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var tokenErr AccessTokenErr
+		if json.Unmarshal(body, &tokenErr) == nil && tokenErr.Error != "" {
+			return nil, fmt.Errorf("access token request for %q: %s",
+				k.scope, tokenErr.Error)
+		}
+		return nil, fmt.Errorf("access token request for %q returned %d",
+			k.scope, resp.StatusCode)
+	}
+	var rsp AccessTokenRsp
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return nil, err
+	}
+	if rsp.AccessToken == "" {
+		return nil, fmt.Errorf("access token request for %q: empty token",
+			k.scope)
+	}
+	return &rsp, nil
+}
@@ -0,0 +1,103 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/message"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Middleware validates the body of the requests, in any media type with a
+// codec, against the JSON schema of their operation, the path being looked
+// up in the specification without prefix. The payload of the versioned
+// messages is validated, their envelope carrying the operation ID as
+// message type. Invalid bodies are rejected with a 400 problem listing the
+// wrong attributes, bodies without codec or in an unsupported message
+// version with a 415 problem. The requests of operations that are not
+// described, or have no JSON schema, are passed as they are
+func (s *Spec) Middleware(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := s.Operation(r.Method, strings.TrimPrefix(r.URL.Path, prefix))
+		if op == nil || op.BodySchema() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			problem.Write(w, problem.FromDecodeError(err))
+			return
+		}
+		if p := s.CheckBody(op, r.Header.Get("Content-Type"),
+			body); p != nil {
+			if len(p.InvalidParams) > 0 {
+				logging.FromContext(r.Context()).Warnf(
+					"Request body does not match the %s schema: %d "+
+						"invalid attributes", op.OperationID,
+					len(p.InvalidParams))
+			}
+			problem.Write(w, p)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckBody validates the request body of the operation, in the media type
+// contentType, and returns the problem rejecting it, nil when it is valid
+// or the operation has no JSON schema
+func (s *Spec) CheckBody(op *Operation, contentType string,
+	body []byte) *problem.Details {
+	schema := op.BodySchema()
+	if schema == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return problem.New(http.StatusBadRequest,
+				problem.CauseMandatoryIEMissing, "empty body")
+		}
+		return nil
+	}
+	/* the bodies of the other media types are validated through their
+	 * JSON representation */
+	data, err := codec.ToJSON(body, contentType)
+	if err != nil {
+		var unsupported *codec.UnsupportedError
+		if errors.As(err, &unsupported) {
+			return problem.New(http.StatusUnsupportedMediaType, "",
+				err.Error())
+		}
+		return problem.FromDecodeError(err)
+	}
+	payload, err := message.Payload(data, contentType, op.OperationID)
+	if err != nil {
+		return message.Problem(err)
+	}
+	params, missing, err := s.Validate(schema, payload)
+	if err != nil {
+		return problem.FromDecodeError(err)
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	if v, _ := message.Version(contentType); v > message.V1 {
+		/* the attributes are those of the envelope */
+		for i := range params {
+			params[i].Param = "/payload" + params[i].Param
+		}
+	}
+	cause := problem.CauseMandatoryIEIncorrect
+	if missing {
+		cause = problem.CauseMandatoryIEMissing
+	}
+	return problem.New(http.StatusBadRequest, cause,
+		"request body does not match the "+op.OperationID+" schema").
+		WithInvalidParams(params...)
+}
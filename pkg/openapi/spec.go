@@ -0,0 +1,295 @@
+// Package openapi loads the OpenAPI 3 specification of the NF interfaces and
+// validates the JSON request bodies against the schemas of its operations
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Spec is the part of an OpenAPI 3 document used for the validation
+type Spec struct {
+	OpenAPI    string              `json:"openapi"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+}
+
+// PathItem holds the operations of a path by lower case method
+type PathItem map[string]*Operation
+
+// UnmarshalJSON keeps the operations of the path item and ignores the other
+// members (summary, parameters...)
+func (p *PathItem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	*p = make(PathItem)
+	for name, raw := range members {
+		switch name {
+		case "get", "put", "post", "delete", "options", "head", "patch",
+			"trace":
+			var op Operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return fmt.Errorf("%s operation: %v", name, err)
+			}
+			(*p)[name] = &op
+		}
+	}
+	return nil
+}
+
+// Operation is an API operation
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Tags        []string             `json:"tags"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// RequestBody describes the body of an operation
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response of an operation, or references one of the
+// components
+type Response struct {
+	Ref         string               `json:"$ref"`
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a body media type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of the OpenAPI schema object checked by the
+// validation and used by the code generation
+type Schema struct {
+	Ref         string `json:"$ref"`
+	Description string `json:"description"`
+	// GoName is the name of the generated field, x-go-name
+	GoName string `json:"x-go-name"`
+	// GoType is the Go type generated for the value, x-go-type, e.g. a
+	// type of the generated package with its own JSON encoding
+	GoType     string             `json:"x-go-type"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+	Enum       []interface{}      `json:"enum"`
+	Pattern    string             `json:"pattern"`
+	MinLength  *int               `json:"minLength"`
+	MaxLength  *int               `json:"maxLength"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinItems   *int               `json:"minItems"`
+	MaxItems   *int               `json:"maxItems"`
+	Nullable   bool               `json:"nullable"`
+	// AdditionalProperties applies to the members of an object not in
+	// Properties
+	AdditionalProperties *Additional `json:"additionalProperties"`
+	// OneOf lists the schemas one of which the value must match
+	OneOf []*Schema `json:"oneOf"`
+}
+
+// Additional is the additionalProperties of a schema: false rejects the
+// members not in the properties, a schema validates them
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalJSON accepts a boolean or a schema
+func (a *Additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// Load reads the specification file, which must be in JSON
+func Load(file string) (*Spec, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", file, err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, fmt.Errorf("%s is not an OpenAPI 3 document", file)
+	}
+	if err := spec.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &spec, nil
+}
+
+// Operation returns the operation of the method on the path, nil when the
+// specification does not describe it. Path templates such as
+// /subscriptions/{subscriptionId} match any value of their parameters
+func (s *Spec) Operation(method, path string) *Operation {
+	method = strings.ToLower(method)
+	if item, ok := s.Paths[path]; ok {
+		return item[method]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for template, item := range s.Paths {
+		if matchTemplate(strings.Split(strings.Trim(template, "/"), "/"),
+			segments) {
+			if op := item[method]; op != nil {
+				return op
+			}
+		}
+	}
+	return nil
+}
+
+func matchTemplate(template, segments []string) bool {
+	if len(template) != len(segments) {
+		return false
+	}
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if t != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// BodySchema returns the schema of the JSON body of the operation, nil when
+// it has none
+func (op *Operation) BodySchema() *Schema {
+	if op.RequestBody == nil {
+		return nil
+	}
+	for mediaType, content := range op.RequestBody.Content {
+		if mediaType == "application/json" ||
+			strings.HasSuffix(mediaType, "+json") {
+			return content.Schema
+		}
+	}
+	return nil
+}
+
+// compile checks that the references and patterns of the schemas resolve,
+// so that the validation cannot fail on the specification itself
+func (s *Spec) compile() error {
+	for name, schema := range s.Components.Schemas {
+		if err := s.check(schema, map[*Schema]bool{}); err != nil {
+			return fmt.Errorf("schema %s: %v", name, err)
+		}
+	}
+	for path, item := range s.Paths {
+		for method, op := range item {
+			if schema := op.BodySchema(); schema != nil {
+				if err := s.check(schema, map[*Schema]bool{}); err != nil {
+					return fmt.Errorf("%s %s: %v", strings.ToUpper(method),
+						path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Spec) check(schema *Schema, seen map[*Schema]bool) error {
+	if schema == nil || seen[schema] {
+		return nil
+	}
+	seen[schema] = true
+	if schema.Ref != "" {
+		target, err := s.resolve(schema.Ref)
+		if err != nil {
+			return err
+		}
+		return s.check(target, seen)
+	}
+	if schema.Pattern != "" {
+		if _, err := compilePattern(schema.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, p := range schema.Properties {
+		if err := s.check(p, seen); err != nil {
+			return err
+		}
+	}
+	for _, o := range schema.OneOf {
+		if err := s.check(o, seen); err != nil {
+			return err
+		}
+	}
+	if schema.AdditionalProperties != nil {
+		if err := s.check(schema.AdditionalProperties.Schema,
+			seen); err != nil {
+			return err
+		}
+	}
+	return s.check(schema.Items, seen)
+}
+
+// LoadSchema reads a standalone JSON Schema file, e.g. the schema of a
+// configuration file, to validate values with ValidateSchema. Its
+// references are not resolved
+func LoadSchema(file string) (*Schema, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", file, err)
+	}
+	if err := (&Spec{}).check(&schema, map[*Schema]bool{}); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &schema, nil
+}
+
+// ResponseOf returns the response, resolving its reference to the
+// components
+func (s *Spec) ResponseOf(r *Response) *Response {
+	const prefix = "#/components/responses/"
+	if r != nil && strings.HasPrefix(r.Ref, prefix) {
+		if target, ok := s.Components.Responses[strings.TrimPrefix(r.Ref,
+			prefix)]; ok {
+			return target
+		}
+	}
+	return r
+}
+
+// resolve returns the schema of a local reference such as
+// #/components/schemas/NF
+func (s *Spec) resolve(ref string) (*Schema, error) {
+	const prefix = "#/components/schemas/"
+	if !strings.HasPrefix(ref, prefix) {
+		return nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+	schema, ok := s.Components.Schemas[strings.TrimPrefix(ref, prefix)]
+	if !ok {
+		return nil, fmt.Errorf("unknown reference %q", ref)
+	}
+	return schema, nil
+}
@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+var (
+	patternsMu sync.Mutex
+	patterns   = make(map[string]*regexp.Regexp)
+)
+
+// compilePattern returns the compiled regular expression of a schema
+// pattern, compiled once
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	if re, ok := patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %v", pattern, err)
+	}
+	patterns[pattern] = re
+	return re, nil
+}
+
+// ValidateSchema checks the JSON value against a standalone schema, see
+// Validate
+func ValidateSchema(schema *Schema, body []byte) (
+	params []problem.InvalidParam, missing bool, err error) {
+	return (&Spec{}).Validate(schema, body)
+}
+
+// Validate checks the JSON value against the schema and returns the
+// attributes that do not match, as JSON pointers. missing reports whether
+// one of them is a missing required attribute
+func (s *Spec) Validate(schema *Schema, body []byte) (
+	params []problem.InvalidParam, missing bool, err error) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, false, err
+	}
+	val := validation{spec: s}
+	val.value(schema, v, "")
+	return val.params, val.missing, nil
+}
+
+type validation struct {
+	spec    *Spec
+	params  []problem.InvalidParam
+	missing bool
+}
+
+func (val *validation) fail(pointer, format string, args ...interface{}) {
+	if pointer == "" {
+		pointer = "/"
+	}
+	val.params = append(val.params, problem.InvalidParam{Param: pointer,
+		Reason: fmt.Sprintf(format, args...)})
+}
+
+func (val *validation) value(schema *Schema, v interface{}, pointer string) {
+	if schema == nil {
+		return
+	}
+	if schema.Ref != "" {
+		/* the references were resolved when the spec was loaded */
+		target, _ := val.spec.resolve(schema.Ref)
+		val.value(target, v, pointer)
+		return
+	}
+	if v == nil {
+		if !schema.Nullable && schema.Type != "" {
+			val.fail(pointer, "must not be null")
+		}
+		return
+	}
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, v) {
+		val.fail(pointer, "not one of the allowed values")
+		return
+	}
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, o := range schema.OneOf {
+			alt := validation{spec: val.spec}
+			alt.value(o, v, pointer)
+			if len(alt.params) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			val.fail(pointer, "must match exactly one of %d schemas",
+				len(schema.OneOf))
+			return
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			val.fail(pointer, "must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				val.fail(pointer+"/"+escape(name), "missing")
+				val.missing = true
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if pv, ok := obj[name]; ok {
+				val.value(schema.Properties[name], pv,
+					pointer+"/"+escape(name))
+			}
+		}
+		if extra := schema.AdditionalProperties; extra != nil {
+			val.additional(schema, extra, obj, pointer)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			val.fail(pointer, "must be an array")
+			return
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			val.fail(pointer, "must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			val.fail(pointer, "must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range items {
+			val.value(schema.Items, item, fmt.Sprintf("%s/%d", pointer, i))
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			val.fail(pointer, "must be a string")
+			return
+		}
+		val.str(schema, str, pointer)
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			val.fail(pointer, "must be a number")
+			return
+		}
+		if schema.Type == "integer" && n != math.Trunc(n) {
+			val.fail(pointer, "must be an integer")
+			return
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			val.fail(pointer, "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			val.fail(pointer, "must be at most %v", *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			val.fail(pointer, "must be a boolean")
+		}
+	}
+}
+
+// additional validates the members of the object not in the properties of
+// the schema
+func (val *validation) additional(schema *Schema, extra *Additional,
+	obj map[string]interface{}, pointer string) {
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		if _, ok := schema.Properties[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch {
+		case !extra.Allowed:
+			val.fail(pointer+"/"+escape(name), "unknown member")
+		case extra.Schema != nil:
+			val.value(extra.Schema, obj[name], pointer+"/"+escape(name))
+		}
+	}
+}
+
+func (val *validation) str(schema *Schema, str, pointer string) {
+	length := len([]rune(str))
+	if schema.MinLength != nil && length < *schema.MinLength {
+		val.fail(pointer, "must be at least %d characters", *schema.MinLength)
+		return
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		val.fail(pointer, "must be at most %d characters", *schema.MaxLength)
+		return
+	}
+	if schema.Pattern != "" {
+		if re, err := compilePattern(schema.Pattern); err == nil &&
+			!re.MatchString(str) {
+			val.fail(pointer, "does not match %s", schema.Pattern)
+			return
+		}
+	}
+	switch schema.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			val.fail(pointer, "must be an RFC 3339 date-time")
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", str); err != nil {
+			val.fail(pointer, "must be a full-date")
+		}
+	case "uri":
+		if u, err := url.Parse(str); err != nil || !u.IsAbs() {
+			val.fail(pointer, "must be an absolute URI")
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(str) {
+			val.fail(pointer, "must be a UUID")
+		}
+	}
+}
+
+var uuidPattern = regexp.MustCompile(
+	`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// escape escapes a member name in a JSON pointer (RFC 6901)
+func escape(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1",
+		-1)
+}
@@ -0,0 +1,414 @@
+// Package outbox keeps the POST requests the NF sends on its own, e.g. the
+// location reports and the notifications, when their peer is unreachable,
+// and sends them again with backoff once it recovers. The requests are kept
+// in a BoltDB file, so that they outlive a restart of the NF
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	collection            = "outbox"
+	defaultInitialBackoff = 1000
+	defaultMaxBackoff     = 60000
+	defaultMaxAge         = 3600000
+	defaultMaxEntries     = 10000
+	// pollInterval is the period of the checks of the requests due
+	pollInterval = time.Second
+)
+
+var (
+	requests = metrics.NewCounterVec("nf_outbox_requests_total",
+		"Requests of the outbound queue by result: queued, delivered, "+
+			"rejected, expired or dropped.", "result")
+	depth = metrics.NewGaugeVec("nf_outbox_entries",
+		"Requests waiting in the outbound queue.", "path")
+)
+
+// Entry is a request waiting in the queue
+type Entry struct {
+	ID     string      `json:"id"`
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"headers,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+	// Created is the time the request was queued
+	Created time.Time `json:"created"`
+	// Attempts is the number of redeliveries that failed
+	Attempts int `json:"attempts"`
+	// Next is the time of the next redelivery
+	Next time.Time `json:"next"`
+	// LastError is the failure of the last attempt
+	LastError string `json:"lasterror,omitempty"`
+}
+
+// Outbox is the queue of the requests sent again. A nil Outbox keeps none
+type Outbox struct {
+	cfg    config.OutboxConfig
+	st     store.Store
+	client *client.Client
+
+	initial time.Duration
+	max     time.Duration
+	maxAge  time.Duration
+	size    int
+	// wake starts a delivery pass at once
+	wake chan struct{}
+
+	mu sync.Mutex
+	// entries is the number of requests in the queue
+	entries int
+}
+
+// New opens the queue file of the configuration, the requests being sent
+// again with the client
+func New(cfg config.OutboxConfig, c *client.Client) (*Outbox, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	st, err := store.Open(config.StoreConfig{Backend: config.StoreBolt,
+		Path: cfg.Path})
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %v", err)
+	}
+	o := &Outbox{cfg: cfg, st: st, client: c,
+		initial: time.Duration(cfg.InitialBackoff) * time.Millisecond,
+		max:     time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		maxAge:  time.Duration(cfg.MaxAge) * time.Millisecond,
+		size:    cfg.MaxEntries,
+		wake:    make(chan struct{}, 1),
+	}
+	if o.initial <= 0 {
+		o.initial = defaultInitialBackoff * time.Millisecond
+	}
+	if o.max <= 0 {
+		o.max = defaultMaxBackoff * time.Millisecond
+	}
+	if o.max < o.initial {
+		o.max = o.initial
+	}
+	if o.maxAge <= 0 {
+		o.maxAge = defaultMaxAge * time.Millisecond
+	}
+	if o.size <= 0 {
+		o.size = defaultMaxEntries
+	}
+	entries, err := o.list(context.Background())
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("outbox: %v", err)
+	}
+	o.setEntries(len(entries))
+	if len(entries) > 0 {
+		logging.Infof("Outbox %s: %d requests to send again", cfg.Path,
+			len(entries))
+	}
+	return o, nil
+}
+
+// Defer queues the POST request when err tells that its peer was
+// unreachable, and returns whether it did. req is a request not sent yet,
+// its body being read
+func (o *Outbox) Defer(req *http.Request, err error) bool {
+	if o == nil || req.Method != http.MethodPost || !client.Unreachable(err) {
+		return false
+	}
+	l := logging.FromContext(req.Context())
+	o.mu.Lock()
+	full := o.entries >= o.size
+	if !full {
+		o.entries++
+		depth.WithLabelValues(o.cfg.Path).Set(float64(o.entries))
+	}
+	o.mu.Unlock()
+	if full {
+		requests.WithLabelValues("dropped").Inc()
+		l.Warnf("Outbox full, %s %s dropped", req.Method, req.URL)
+		return false
+	}
+	e := Entry{ID: uuid.New(), Method: req.Method, URL: req.URL.String(),
+		Header: req.Header.Clone(), Created: time.Now()}
+	e.Next = e.Created.Add(o.initial)
+	if req.Body != nil {
+		body, rerr := io.ReadAll(req.Body)
+		req.Body.Close()
+		if rerr != nil {
+			o.removed()
+			l.Warnf("%s %s not queued: %v", req.Method, req.URL, rerr)
+			return false
+		}
+		e.Body = body
+	}
+	if perr := store.PutJSON(context.Background(), o.st, collection, e.ID,
+		e, 0); perr != nil {
+		o.removed()
+		l.Warnf("%s %s not queued: %v", req.Method, req.URL, perr)
+		return false
+	}
+	requests.WithLabelValues("queued").Inc()
+	l.Infof("%s %s queued, to be sent again: %v", req.Method, req.URL, err)
+	return true
+}
+
+// Run sends the requests due until the context is canceled, then closes
+// the queue file
+func (o *Outbox) Run(ctx context.Context) {
+	defer o.st.Close()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		o.deliver(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// deliver sends the requests due in the order they were queued. Once a
+// peer is unreachable, its next requests wait for the next pass
+func (o *Outbox) deliver(ctx context.Context) {
+	entries, err := o.list(ctx)
+	if err != nil {
+		logging.Warnf("Outbox not read: %v", err)
+		return
+	}
+	o.setEntries(len(entries))
+	down := make(map[string]bool)
+	now := time.Now()
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if now.Sub(e.Created) > o.maxAge {
+			o.drop(ctx, e, "expired", "older than "+o.maxAge.String())
+			continue
+		}
+		host := hostOf(e.URL)
+		if e.Next.After(now) || down[host] {
+			continue
+		}
+		status, err := o.send(ctx, e)
+		switch {
+		case err == nil && status/100 == 2:
+			o.remove(ctx, e.ID)
+			requests.WithLabelValues("delivered").Inc()
+			logging.Infof("Outbox: %s %s delivered after %d attempts",
+				e.Method, e.URL, e.Attempts+1)
+			continue
+		case err == nil && !retryable(status):
+			o.drop(ctx, e, "rejected", fmt.Sprintf("status %d", status))
+			continue
+		case err == nil:
+			err = fmt.Errorf("status %d", status)
+		case client.Unreachable(err):
+			down[host] = true
+		}
+		e.Attempts++
+		if o.cfg.MaxAttempts > 0 && e.Attempts >= o.cfg.MaxAttempts {
+			o.drop(ctx, e, "expired", fmt.Sprintf("%d attempts, last: %v",
+				e.Attempts, err))
+			continue
+		}
+		e.Next = time.Now().Add(o.backoff(e.Attempts))
+		e.LastError = err.Error()
+		/* unless dropped from the admin view meanwhile */
+		if _, err := o.st.Get(ctx, collection, e.ID); errors.Is(err,
+			store.ErrNotFound) {
+			continue
+		}
+		if err := store.PutJSON(ctx, o.st, collection, e.ID, e,
+			0); err != nil {
+			logging.Warnf("Outbox: %s %s not updated: %v", e.Method, e.URL,
+				err)
+		}
+		logging.Debugf("Outbox: %s %s failed (%v), next attempt at %s",
+			e.Method, e.URL, err, e.Next.Format(time.RFC3339))
+	}
+}
+
+// send sends the request of the entry as a callback, and returns the
+// status of the response
+func (o *Outbox) send(ctx context.Context, e Entry) (int, error) {
+	req, err := http.NewRequest(e.Method, e.URL, bytes.NewReader(e.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = e.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	resp, err := o.client.Do(req.WithContext(o.client.CallbackContext(ctx)))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// retryable tells whether a request answered with the status is sent again
+func retryable(status int) bool {
+	return status/100 == 5 || status == http.StatusRequestTimeout ||
+		status == http.StatusTooManyRequests
+}
+
+// backoff returns the delay before the attempt following the failed ones
+func (o *Outbox) backoff(failed int) time.Duration {
+	d := o.initial
+	for i := 1; i < failed && d < o.max; i++ {
+		d *= 2
+	}
+	if d > o.max {
+		d = o.max
+	}
+	return d
+}
+
+// drop removes the entry the queue gives up on
+func (o *Outbox) drop(ctx context.Context, e Entry, result, reason string) {
+	o.remove(ctx, e.ID)
+	requests.WithLabelValues(result).Inc()
+	logging.Errorf("Outbox: %s %s dropped, %s: %s", e.Method, e.URL, result,
+		reason)
+}
+
+func (o *Outbox) remove(ctx context.Context, id string) bool {
+	ok, err := o.st.Delete(ctx, collection, id)
+	if err != nil {
+		logging.Warnf("Outbox: %s not removed: %v", id, err)
+		return false
+	}
+	if ok {
+		o.removed()
+	}
+	return ok
+}
+
+// list returns the entries in the order they were queued
+func (o *Outbox) list(ctx context.Context) ([]Entry, error) {
+	values, err := o.st.List(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(values))
+	for id, v := range values {
+		var e Entry
+		if err := json.Unmarshal(v, &e); err != nil {
+			logging.Warnf("Outbox: invalid entry %s dropped: %v", id, err)
+			_, _ = o.st.Delete(ctx, collection, id)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// setEntries sets the number of entries
+func (o *Outbox) setEntries(n int) {
+	o.mu.Lock()
+	o.entries = n
+	depth.WithLabelValues(o.cfg.Path).Set(float64(n))
+	o.mu.Unlock()
+}
+
+// removed counts an entry removed, or not queued after all
+func (o *Outbox) removed() {
+	o.mu.Lock()
+	o.entries--
+	depth.WithLabelValues(o.cfg.Path).Set(float64(o.entries))
+	o.mu.Unlock()
+}
+
+func hostOf(url string) string {
+	rest := url
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest
+}
+
+// Handler serves the admin view of the queue: GET lists the requests
+// waiting, without their body, DELETE drops the one of the id query
+// parameter, or all of them without, and POST sends them at once
+func (o *Outbox) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries, err := o.list(r.Context())
+		if err != nil {
+			problem.Error(w, http.StatusInternalServerError,
+				problem.CauseSystemFailure, err.Error())
+			return
+		}
+		id := r.URL.Query().Get("id")
+		switch r.Method {
+		case http.MethodGet:
+			for i := range entries {
+				entries[i].Body = nil
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(entries)
+		case http.MethodDelete:
+			n := 0
+			for _, e := range entries {
+				if (id == "" || e.ID == id) && o.remove(r.Context(), e.ID) {
+					n++
+				}
+			}
+			if id != "" && n == 0 {
+				problem.Error(w, http.StatusNotFound,
+					problem.CauseContextNotFound, "no request "+id)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]int{"dropped": n})
+		case http.MethodPost:
+			n := 0
+			for _, e := range entries {
+				if id != "" && e.ID != id {
+					continue
+				}
+				e.Next = time.Now()
+				if err := store.PutJSON(r.Context(), o.st, collection, e.ID,
+					e, 0); err == nil {
+					n++
+				}
+			}
+			select {
+			case o.wake <- struct{}{}:
+			default:
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]int{"scheduled": n})
+		default:
+			w.Header().Set("Allow", "GET, DELETE, POST")
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Package overload encodes the Overload Control Information of 3GPP TS
+// 29.500, sent by an overloaded NF in the 3gpp-Sbi-Oci header of its
+// responses to ask its peers to reduce the requests they send it by the
+// overload reduction metric (Olc, in percent) until the validity expires:
+//
+//	3gpp-Sbi-Oci: Timestamp: "Tue, 04 Feb 2020 08:49:37.845 GMT"; Validity: 60; Olc: 50; NF-Inst: 54804518-4191-46b3-955c-ac631f953ed8
+package overload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header carries the Overload Control Information
+const Header = "3gpp-Sbi-Oci"
+
+// TimestampLayout is the HTTP-date with milliseconds of the timestamps
+const TimestampLayout = "Mon, 02 Jan 2006 15:04:05.000 GMT"
+
+// Scopes of the OCI, the NF, NF set or service the overload applies to
+const (
+	ScopeNFInstance      = "NF-Inst"
+	ScopeNFSet           = "NF-Set"
+	ScopeServiceInstance = "NF-Service-Inst"
+	ScopeServiceSet      = "NF-Service-Set"
+)
+
+// OCI is an Overload Control Information
+type OCI struct {
+	// Timestamp orders the OCI of an NF, a newer one replacing the older
+	Timestamp time.Time
+	// Validity is how long the OCI applies from its timestamp
+	Validity time.Duration
+	// Reduction is the percentage of the requests to stop sending, 0
+	// ending the overload
+	Reduction int
+	// Scope is the kind of the ScopeID, e.g. ScopeNFInstance
+	Scope   string
+	ScopeID string
+}
+
+// Expires returns the time the OCI stops applying
+func (o OCI) Expires() time.Time {
+	return o.Timestamp.Add(o.Validity)
+}
+
+// String returns the header value of the OCI
+func (o OCI) String() string {
+	s := fmt.Sprintf("Timestamp: %q; Validity: %d; Olc: %d",
+		o.Timestamp.UTC().Format(TimestampLayout),
+		int(o.Validity/time.Second), o.Reduction)
+	if o.Scope != "" {
+		s += "; " + o.Scope + ": " + o.ScopeID
+	}
+	return s
+}
+
+// Parse decodes a header value. The timestamp, validity and reduction are
+// mandatory, the reduction from 0 to 100
+func Parse(s string) (OCI, error) {
+	var o OCI
+	var timestamp, validity, reduction bool
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, ":")
+		if !ok {
+			return o, fmt.Errorf("overload: %q is not a parameter", part)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "Timestamp":
+			o.Timestamp, err = time.Parse(TimestampLayout,
+				strings.Trim(value, `"`))
+			timestamp = true
+		case "Validity":
+			var n int
+			n, err = strconv.Atoi(value)
+			if err == nil && n < 0 {
+				err = errors.New("negative validity")
+			}
+			o.Validity, validity = time.Duration(n)*time.Second, true
+		case "Olc":
+			o.Reduction, err = strconv.Atoi(value)
+			if err == nil && (o.Reduction < 0 || o.Reduction > 100) {
+				err = errors.New("reduction out of 0-100")
+			}
+			reduction = true
+		case ScopeNFInstance, ScopeNFSet, ScopeServiceInstance,
+			ScopeServiceSet:
+			o.Scope, o.ScopeID = name, value
+		}
+		if err != nil {
+			return o, fmt.Errorf("overload: invalid %s %q: %v", name, value,
+				err)
+		}
+	}
+	if !timestamp || !validity || !reduction {
+		return o, errors.New("overload: Timestamp, Validity or Olc missing")
+	}
+	return o, nil
+}
@@ -0,0 +1,76 @@
+// Package priority carries the priority of the SBI messages, the
+// 3gpp-Sbi-Message-Priority header of 3GPP TS 29.500 from 0, the highest,
+// to 31, the lowest. The priority of a request served is attached to its
+// context and sent on with the requests made on its behalf
+package priority
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+const (
+	// Header carries the message priority
+	Header = "3gpp-Sbi-Message-Priority"
+	// Highest is the priority of the most urgent messages
+	Highest = 0
+	// Lowest is the priority of the least urgent messages
+	Lowest = 31
+)
+
+type contextKey struct{}
+
+// Parse returns the priority of a header value, false when it is not an
+// integer from Highest to Lowest
+func Parse(s string) (int, bool) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || p < Highest || p > Lowest {
+		return 0, false
+	}
+	return p, true
+}
+
+// NewContext returns a context carrying the message priority
+func NewContext(ctx context.Context, p int) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the message priority carried by the context, false
+// when there is none
+func FromContext(ctx context.Context) (int, bool) {
+	p, ok := ctx.Value(contextKey{}).(int)
+	return p, ok
+}
+
+// Middleware attaches the message priority of the request header to its
+// context. An invalid priority is ignored, the request being handled as
+// one without priority
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(Header)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, ok := Parse(v)
+		if !ok {
+			logging.FromContext(r.Context()).Debugf(
+				"Invalid %s %q ignored", Header, v)
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+	})
+}
+
+// Inject sets the message priority of the context on the headers of an
+// outbound request, unless it is already set
+func Inject(ctx context.Context, h http.Header) {
+	if p, ok := FromContext(ctx); ok && h.Get(Header) == "" {
+		h.Set(Header, strconv.Itoa(p))
+	}
+}
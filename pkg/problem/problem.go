@@ -0,0 +1,131 @@
+// Package problem writes the ProblemDetails error bodies (RFC 7807, 3GPP TS
+// 29.571) returned by the NFs
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of a ProblemDetails body
+const ContentType = "application/problem+json"
+
+// Application error causes of 3GPP TS 29.500 used by the NFs
+const (
+	CauseInvalidMsgFormat     = "INVALID_MSG_FORMAT"
+	CauseMandatoryIEIncorrect = "MANDATORY_IE_INCORRECT"
+	CauseMandatoryIEMissing   = "MANDATORY_IE_MISSING"
+	CauseResourceURINotFound  = "RESOURCE_URI_STRUCTURE_NOT_FOUND"
+	CauseSubscriptionNotFound = "SUBSCRIPTION_NOT_FOUND"
+	CauseContextNotFound      = "CONTEXT_NOT_FOUND"
+	CauseSystemFailure        = "SYSTEM_FAILURE"
+	CauseNFServiceUnavailable = "NF_SERVICE_UNAVAILABLE"
+	CauseTargetNFNotReachable = "TARGET_NF_NOT_REACHABLE"
+	CauseTimedOutRequest      = "TIMED_OUT_REQUEST"
+	CauseNFCongestionRisk     = "NF_CONGESTION_RISK"
+	CauseNFCongestion         = "NF_CONGESTION"
+)
+
+// InvalidParam identifies an attribute of the request that is wrong
+type InvalidParam struct {
+	// Param is the attribute as a JSON pointer, e.g. /location
+	Param  string `json:"param"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Details is the ProblemDetails structure
+type Details struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Cause is the 3GPP application error cause
+	Cause         string         `json:"cause,omitempty"`
+	InvalidParams []InvalidParam `json:"invalidParams,omitempty"`
+	// SupportedVersions lists the API versions served, on the requests of
+	// an unknown version
+	SupportedVersions []string `json:"supportedVersions,omitempty"`
+}
+
+// New returns the problem details of the status code
+func New(status int, cause, detail string) *Details {
+	return &Details{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Cause:  cause,
+	}
+}
+
+// WithInvalidParams adds the wrong attributes to the problem
+func (p *Details) WithInvalidParams(params ...InvalidParam) *Details {
+	p.InvalidParams = append(p.InvalidParams, params...)
+	return p
+}
+
+// WithSupportedVersions adds the API versions served to the problem
+func (p *Details) WithSupportedVersions(versions ...string) *Details {
+	p.SupportedVersions = append(p.SupportedVersions, versions...)
+	return p
+}
+
+// Error implements error, so that a problem can be returned by the code
+// building it and written by the handler
+func (p *Details) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// Write sends the problem details as the response
+func Write(w http.ResponseWriter, p *Details) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, p.Detail, p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(body)
+}
+
+// Error sends a problem details response built from the status, cause and
+// detail
+func Error(w http.ResponseWriter, status int, cause, detail string) {
+	Write(w, New(status, cause, detail))
+}
+
+// FromDecodeError returns the 400 problem of a JSON body that could not be
+// decoded, with the offending attribute in the invalid parameters when it is
+// known, or the 413 problem of a body over its size limit
+func FromDecodeError(err error) *Details {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return New(http.StatusRequestEntityTooLarge, "",
+			fmt.Sprintf("body larger than %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.EOF):
+		return New(http.StatusBadRequest, CauseMandatoryIEMissing,
+			"empty body")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return New(http.StatusBadRequest, CauseMandatoryIEIncorrect,
+			err.Error()).WithInvalidParams(InvalidParam{
+			Param: "/" + strings.Replace(typeErr.Field, ".", "/", -1),
+			Reason: fmt.Sprintf("expected %s, got %s", typeErr.Type,
+				typeErr.Value),
+		})
+	case errors.As(err, &syntaxErr):
+		return New(http.StatusBadRequest, CauseInvalidMsgFormat,
+			fmt.Sprintf("%v at offset %d", err, syntaxErr.Offset))
+	}
+	return New(http.StatusBadRequest, CauseInvalidMsgFormat, err.Error())
+}
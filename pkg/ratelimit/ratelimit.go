@@ -0,0 +1,143 @@
+// Package ratelimit contains the token buckets limiting the rate of the
+// requests received and sent by the NFs
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Time after which the bucket of a key that was not used is forgotten
+const idleExpiry = 5 * time.Minute
+
+// Bucket is a token bucket refilled at Rate tokens per second up to Burst
+// tokens. A nil bucket allows everything
+type Bucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket, nil when rate is not positive
+func NewBucket(rate float64, burst int) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst),
+		last: time.Now()}
+}
+
+// reserve takes a token, borrowed from the future when borrow is set, and
+// returns how long the caller must wait before it is available
+func (b *Bucket) reserve(now time.Time, borrow bool) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if borrow {
+		b.tokens--
+		return delay, true
+	}
+	return delay, false
+}
+
+// Allow takes a token if one is available. Otherwise it returns the time
+// after which one will be
+func (b *Bucket) Allow() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	delay, ok := b.reserve(time.Now(), false)
+	return ok, delay
+}
+
+// Wait takes a token, waiting until one is available or the context is
+// done
+func (b *Bucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	delay, _ := b.reserve(time.Now(), true)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		/* give the token back */
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// idle reports whether the bucket was not used since the time
+func (b *Bucket) idle(since time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last.Before(since)
+}
+
+// Limiter holds a bucket per key, e.g. per client or per peer
+type Limiter struct {
+	rate  float64
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+	swept   time.Time
+}
+
+// NewLimiter returns a limiter giving each key a bucket of the rate and
+// burst, nil when rate is not positive. A nil limiter allows everything
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: rate, burst: burst,
+		buckets: make(map[string]*Bucket), swept: time.Now()}
+}
+
+// Bucket returns the bucket of the key. The buckets not used for a while
+// are dropped
+func (l *Limiter) Bucket(key string) *Bucket {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > idleExpiry {
+		for k, b := range l.buckets {
+			if b.idle(now.Add(-idleExpiry)) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
@@ -0,0 +1,456 @@
+// Package record records the exchanges of the NF, the requests served and
+// sent with their responses and timing, to a file of one JSON object per
+// line, and replays them against an NF to check that its answers did not
+// change, e.g. after a change of its interfaces
+package record
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Sides of the exchanges
+const (
+	// SideServer is a request served by the NF
+	SideServer = "server"
+	// SideClient is a request sent by the NF to a peer
+	SideClient = "client"
+)
+
+const (
+	defaultMaxBodySize = 1 << 20
+	// Redacted replaces the values of the sensitive headers
+	Redacted = "<redacted>"
+)
+
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization",
+	"Cookie", "Set-Cookie"}
+
+var recorded = metrics.NewCounterVec("nf_recorded_exchanges_total",
+	"Exchanges recorded by side (server or client).", "side")
+
+// Exchange is a request and its response as recorded
+type Exchange struct {
+	Time time.Time `json:"time"`
+	Side string    `json:"side"`
+	// Endpoint is the server endpoint name (e.g. "API") of a request
+	// served, the peer host:port of a request sent
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+	// URL is the request URI of a request served, the absolute URL of a
+	// request sent
+	URL            string      `json:"url"`
+	Proto          string      `json:"proto,omitempty"`
+	RequestHeader  http.Header `json:"requestheader,omitempty"`
+	RequestBody    Body        `json:"requestbody,omitempty"`
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"responseheader,omitempty"`
+	ResponseBody   Body        `json:"responsebody,omitempty"`
+	// DurationMs is the time from the request to the end of the response
+	DurationMs float64 `json:"duration_ms"`
+	// Error is the failure of a request sent or the abort of a request
+	// served
+	Error string `json:"error,omitempty"`
+	// RequestTruncated and ResponseTruncated are set when the body was
+	// longer than the maximum body size
+	RequestTruncated  bool `json:"requesttruncated,omitempty"`
+	ResponseTruncated bool `json:"responsetruncated,omitempty"`
+}
+
+// Body is a recorded body, a JSON string when it is valid UTF-8, else an
+// object with its base64 encoding
+type Body []byte
+
+type binaryBody struct {
+	Base64 string `json:"base64"`
+}
+
+// MarshalJSON encodes the body as a string, or as {"base64": "..."}
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(binaryBody{Base64: base64.StdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON decodes the two encodings of MarshalJSON
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var bin binaryBody
+	if err := json.Unmarshal(data, &bin); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(bin.Base64)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// Recorder appends the exchanges matching its settings to the recording
+// file
+type Recorder struct {
+	mu   sync.Mutex
+	cfg  config.RecordConfig
+	file *os.File
+	enc  *json.Encoder
+}
+
+// New creates a recorder with the settings of cfg, opening its file when
+// enabled
+func New(cfg config.RecordConfig) (*Recorder, error) {
+	rec := &Recorder{}
+	if err := rec.Set(cfg); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Set replaces the settings, closing the file of the previous ones when
+// it changes
+func (rec *Recorder) Set(cfg config.RecordConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !cfg.Enabled || (rec.file != nil && cfg.File != rec.cfg.File) {
+		rec.closeFile()
+	}
+	if cfg.Enabled && rec.file == nil {
+		file, err := os.OpenFile(filepath.Clean(cfg.File),
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("opening the recording: %v", err)
+		}
+		rec.file, rec.enc = file, json.NewEncoder(file)
+		logging.Infof("Recording the exchanges to %s", cfg.File)
+	}
+	rec.cfg = cfg
+	return nil
+}
+
+// Prepare validates the settings of cfg, checking that their recording
+// file opens, and returns the function replacing the settings with them
+func (rec *Recorder) Prepare(cfg config.RecordConfig) (func(), error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	rec.mu.Lock()
+	opened := rec.file != nil && cfg.File == rec.cfg.File
+	rec.mu.Unlock()
+	if cfg.Enabled && !opened {
+		file, err := os.OpenFile(filepath.Clean(cfg.File),
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening the recording: %v", err)
+		}
+		_ = file.Close()
+	}
+	return func() {
+		if err := rec.Set(cfg); err != nil {
+			logging.Errorf("Recording not reloaded: %v", err)
+		}
+	}, nil
+}
+
+// Config returns the settings in use
+func (rec *Recorder) Config() config.RecordConfig {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.cfg
+}
+
+// Close closes the recording file
+func (rec *Recorder) Close() error {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.closeFile()
+}
+
+func (rec *Recorder) closeFile() error {
+	if rec.file == nil {
+		return nil
+	}
+	err := rec.file.Close()
+	rec.file, rec.enc = nil, nil
+	return err
+}
+
+// match returns the settings when the requests of the side on the path are
+// recorded, false when they are not
+func (rec *Recorder) match(side, path string) (config.RecordConfig, bool) {
+	if rec == nil {
+		return config.RecordConfig{}, false
+	}
+	rec.mu.Lock()
+	cfg := rec.cfg
+	rec.mu.Unlock()
+	if !cfg.Enabled || (side == SideServer && !cfg.Server) ||
+		(side == SideClient && !cfg.Client) {
+		return cfg, false
+	}
+	if len(cfg.Paths) == 0 {
+		return cfg, true
+	}
+	for _, prefix := range cfg.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return cfg, true
+		}
+	}
+	return cfg, false
+}
+
+// write appends the exchange to the recording
+func (rec *Recorder) write(e *Exchange) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.enc == nil {
+		/* stopped meanwhile */
+		return
+	}
+	if err := rec.enc.Encode(e); err != nil {
+		logging.Warnf("Exchange %s %s not recorded: %v", e.Method, e.URL, err)
+		return
+	}
+	recorded.WithLabelValues(e.Side).Inc()
+}
+
+// redact returns a copy of the headers with the values of the sensitive
+// ones replaced
+func redact(h http.Header, cfg config.RecordConfig) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	h = h.Clone()
+	for _, names := range [][]string{sensitiveHeaders, cfg.RedactHeaders} {
+		for _, name := range names {
+			if _, ok := h[http.CanonicalHeaderKey(name)]; ok {
+				h.Set(name, Redacted)
+			}
+		}
+	}
+	return h
+}
+
+func maxBodySize(cfg config.RecordConfig) int {
+	if cfg.MaxBodySize > 0 {
+		return cfg.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// Middleware records the requests served by the named server with their
+// responses, once the handler returned. The requests aborted are recorded
+// with an error
+func (rec *Recorder) Middleware(server string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg, ok := rec.match(SideServer, r.URL.Path)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limit := maxBodySize(cfg)
+			start := time.Now()
+			e := Exchange{Time: start, Side: SideServer, Endpoint: server,
+				Method: r.Method, URL: r.URL.RequestURI(), Proto: r.Proto,
+				RequestHeader: redact(r.Header, cfg)}
+			var reqBody *body
+			if r.Body != nil && r.Body != http.NoBody {
+				reqBody = &body{ReadCloser: r.Body, limit: limit}
+				r.Body = reqBody
+			}
+			rw := &writer{StatusWriter: logging.StatusWriter{ResponseWriter: w},
+				body: body{limit: limit}}
+			defer func() {
+				p := recover()
+				if p != nil {
+					e.Error = fmt.Sprint(p)
+				}
+				e.Status = rw.Status
+				if e.Status == 0 && p == nil {
+					e.Status = http.StatusOK
+				}
+				e.ResponseHeader = redact(w.Header(), cfg)
+				e.ResponseBody, e.ResponseTruncated = rw.body.recorded()
+				if reqBody != nil {
+					e.RequestBody, e.RequestTruncated = reqBody.recorded()
+				}
+				e.DurationMs = millis(time.Since(start))
+				rec.write(&e)
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// RoundTrip sends the request with send and records it with its response,
+// once the response body is read to its end or closed
+func (rec *Recorder) RoundTrip(req *http.Request,
+	send func(*http.Request) (*http.Response, error)) (*http.Response,
+	error) {
+	cfg, ok := rec.match(SideClient, req.URL.Path)
+	if !ok {
+		return send(req)
+	}
+	limit := maxBodySize(cfg)
+	start := time.Now()
+	e := Exchange{Time: start, Side: SideClient, Endpoint: req.URL.Host,
+		Method: req.Method, URL: req.URL.String(), Proto: req.Proto,
+		RequestHeader: redact(req.Header, cfg)}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody != nil {
+		/* a copy, the transport may still send the body after the
+		   response */
+		if rc, err := req.GetBody(); err == nil {
+			b := &body{ReadCloser: rc, limit: limit}
+			_, _ = io.Copy(io.Discard, b)
+			_ = rc.Close()
+			e.RequestBody, e.RequestTruncated = b.recorded()
+		}
+	}
+	resp, err := send(req)
+	if err != nil {
+		e.Error = err.Error()
+		e.DurationMs = millis(time.Since(start))
+		rec.write(&e)
+		return resp, err
+	}
+	e.Proto, e.Status = resp.Proto, resp.StatusCode
+	e.ResponseHeader = redact(resp.Header, cfg)
+	resp.Body = &responseBody{body: body{ReadCloser: resp.Body, limit: limit},
+		done: func(b *body, err error) {
+			if err != nil && err != io.EOF {
+				e.Error = err.Error()
+			}
+			e.ResponseBody, e.ResponseTruncated = b.recorded()
+			e.DurationMs = millis(time.Since(start))
+			rec.write(&e)
+		}}
+	return resp, nil
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// body records the first limit bytes of a body
+type body struct {
+	io.ReadCloser
+	limit int
+	mu    sync.Mutex
+	buf   []byte
+	n     int
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.record(p[:n])
+	return n, err
+}
+
+func (b *body) record(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.n += len(p)
+	if room := b.limit - len(b.buf); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		b.buf = append(b.buf, p...)
+	}
+}
+
+// recorded returns the bytes recorded and whether the body was longer
+func (b *body) recorded() (Body, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Body(b.buf), b.n > len(b.buf)
+}
+
+// writer records the status code and the first bytes of a response
+type writer struct {
+	logging.StatusWriter
+	body body
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.StatusWriter.Write(p)
+	w.body.record(p[:n])
+	return n, err
+}
+
+// responseBody records a response body read by the client, calling done
+// once at its end, on an error or when it is closed
+type responseBody struct {
+	body
+	once sync.Once
+	done func(*body, error)
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err != nil {
+		b.once.Do(func() { b.done(&b.body, err) })
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() { b.done(&b.body, nil) })
+	return err
+}
+
+// Handler serves the settings on GET and replaces them on PUT, e.g. with
+// {"enabled": true, "file": "/tmp/nf1.jsonl", "server": true}
+func (rec *Recorder) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var cfg config.RecordConfig
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				problem.Write(w, problem.FromDecodeError(err))
+				return
+			}
+			if err := rec.Set(cfg); err != nil {
+				problem.Error(w, http.StatusBadRequest,
+					problem.CauseMandatoryIEIncorrect, err.Error())
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rec.Config())
+	})
+}
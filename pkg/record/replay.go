@@ -0,0 +1,220 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// hopHeaders are the headers of the recorded requests not replayed, set by
+// the transport of the replayed ones
+var hopHeaders = []string{"Connection", "Content-Length", "Host",
+	"Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding",
+	"Upgrade"}
+
+// Read returns the exchanges of a recording
+func Read(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	dec := json.NewDecoder(r)
+	for {
+		var e Exchange
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return exchanges, nil
+		}
+		if err != nil {
+			return exchanges, fmt.Errorf("exchange %d: %v",
+				len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, e)
+	}
+}
+
+// ReadFile returns the exchanges of a recording file
+func ReadFile(path string) ([]Exchange, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Replayer sends recorded requests to a target NF and compares its
+// responses with the recorded ones
+type Replayer struct {
+	// Target is the scheme and host:port the requests are sent to, e.g.
+	// https://localhost:8080, their path and query being the recorded ones
+	Target *url.URL
+	// Do sends the requests, e.g. the Do of an NF client
+	Do func(*http.Request) (*http.Response, error)
+	// Speed paces the requests as recorded when 1, faster when higher. The
+	// requests are sent back to back when 0
+	Speed float64
+	// CompareBodies compares the response bodies besides the statuses
+	CompareBodies bool
+	// Ignore lists the members of the JSON bodies, at any depth, left out
+	// of the comparison, e.g. the timestamps
+	Ignore []string
+	// Timeout bounds each request, none when 0
+	Timeout time.Duration
+}
+
+// Result is the outcome of a replayed exchange
+type Result struct {
+	Exchange Exchange
+	Status   int
+	Duration time.Duration
+	// Err is the failure to send the request or read its response
+	Err error
+	// Differences lists how the response differs from the recorded one
+	Differences []string
+}
+
+// OK tells whether the response matches the recorded one
+func (r Result) OK() bool {
+	return r.Err == nil && len(r.Differences) == 0
+}
+
+// Replay sends the exchanges in their order, calling report with the result
+// of each, and returns the number of those not OK. It stops early only when
+// the context ends
+func (p *Replayer) Replay(ctx context.Context, exchanges []Exchange,
+	report func(Result)) (int, error) {
+	if p.Target == nil || p.Target.Host == "" {
+		return 0, errors.New("missing replay target")
+	}
+	failed := 0
+	start := time.Now()
+	for i, e := range exchanges {
+		if p.Speed > 0 && i > 0 {
+			at := time.Duration(float64(e.Time.Sub(exchanges[0].Time)) /
+				p.Speed)
+			if wait := at - time.Since(start); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return failed, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return failed, err
+		}
+		r := p.replay(ctx, e)
+		if !r.OK() {
+			failed++
+		}
+		report(r)
+	}
+	return failed, nil
+}
+
+// replay sends the request of the exchange and compares the response
+func (p *Replayer) replay(ctx context.Context, e Exchange) Result {
+	r := Result{Exchange: e}
+	if e.RequestTruncated {
+		r.Err = errors.New("request body truncated in the recording")
+		return r
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	target := *p.Target
+	target.Path, target.RawPath, target.RawQuery = u.Path, u.RawPath,
+		u.RawQuery
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, e.Method, target.String(),
+		bytes.NewReader(e.RequestBody))
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	if len(e.RequestBody) == 0 {
+		req.Body = http.NoBody
+	}
+	for name, values := range e.RequestHeader {
+		for _, v := range values {
+			if v != Redacted {
+				req.Header.Add(name, v)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		req.Header.Del(name)
+	}
+
+	start := time.Now()
+	resp, err := p.Do(req)
+	if err != nil {
+		r.Err = err
+		r.Duration = time.Since(start)
+		return r
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	r.Duration = time.Since(start)
+	r.Status = resp.StatusCode
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	if e.Status == 0 {
+		/* the recorded request failed, there is nothing to compare */
+		return r
+	}
+	if r.Status != e.Status {
+		r.Differences = append(r.Differences, fmt.Sprintf(
+			"status %d, recorded %d", r.Status, e.Status))
+	}
+	if p.CompareBodies && !e.ResponseTruncated &&
+		!p.sameBody(e.ResponseBody, got) {
+		r.Differences = append(r.Differences, "body differs")
+	}
+	return r
+}
+
+// sameBody compares the bodies as JSON values without the ignored members
+// when both are JSON, byte for byte otherwise
+func (p *Replayer) sameBody(recorded, got []byte) bool {
+	var a, b interface{}
+	if json.Unmarshal(recorded, &a) != nil || json.Unmarshal(got, &b) != nil {
+		return bytes.Equal(recorded, got)
+	}
+	return reflect.DeepEqual(p.strip(a), p.strip(b))
+}
+
+// strip removes the ignored members from the JSON value
+func (p *Replayer) strip(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range p.Ignore {
+			delete(v, name)
+		}
+		for k, member := range v {
+			v[k] = p.strip(member)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = p.strip(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,456 @@
+// Package replication propagates the NF state between the replicas of an
+// NF that keep it in memory or in a local file: the subscriptions, the
+// pending correlations and the NF records, so that a callback reaching
+// another replica than the one waiting for it, or a failover, does not lose
+// its context. Each replica serves the stream of the writes to its store
+// over HTTP/2, starting with a snapshot of the replicated collections, to
+// its peers only, and follows the streams of its peers, applying their
+// writes to its own store
+package replication
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	// DefaultPath is the path of the stream on the NF server
+	DefaultPath          = "/replication"
+	defaultRetryInterval = 1000
+	// replicaHeader carries the ID of the replica following a stream
+	replicaHeader = "X-Replica-Id"
+	// backlog is the number of writes buffered for a follower, which is
+	// disconnected when it falls further behind
+	backlog    = 1024
+	maxOpSize  = 4 << 20
+	mediaType  = "application/x-ndjson"
+	opPut      = "put"
+	opDelete   = "delete"
+	opSnapshot = "snapshot"
+)
+
+var (
+	replicatedOps = metrics.NewCounterVec("nf_replication_ops_total",
+		"Store writes replicated by direction (sent or received) and "+
+			"operation.", "direction", "op")
+	followedPeers = metrics.NewGaugeVec("nf_replication_peer_connected",
+		"1 while the stream of the peer replica is followed.", "peer")
+)
+
+// errSelf is answered to a replica following its own stream
+var errSelf = errors.New("replication: the peer is this replica")
+
+// Doer sends the requests following the peers
+type Doer interface {
+	Stream(req *http.Request) (*http.Response, error)
+}
+
+// op is a write streamed to the peers
+type op struct {
+	Op         string `json:"op"`
+	Collection string `json:"collection,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Value      []byte `json:"value,omitempty"`
+	// TTL is the time to live left in milliseconds, none when 0
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// Replicator is the store of the NF replicating its writes to the peer
+// replicas. Create is atomic on the replica only
+type Replicator struct {
+	store.Store
+	id       string
+	cfg      config.ReplicationConfig
+	doer     Doer
+	retry    time.Duration
+	snapshot map[string]bool
+	// token authenticates the followers, none when empty
+	token string
+	// peerHosts are the host names of the peers, lower case, which the
+	// certificates of the followers verified by mutual TLS must name
+	peerHosts map[string]bool
+
+	mu sync.Mutex
+	// collections are those replicated in the snapshots
+	collections map[string]bool
+	// expiries holds the expiry of the keys written with a TTL
+	expiries  map[string]time.Time
+	followers map[chan op]bool
+}
+
+// New returns the store replicating the writes to base, of the backend,
+// following the peers with doer
+func New(cfg config.ReplicationConfig, backend string, base store.Store,
+	doer Doer) (*Replicator, error) {
+	if err := cfg.Validate(backend); err != nil {
+		return nil, err
+	}
+	retry := cfg.RetryInterval
+	if retry == 0 {
+		retry = defaultRetryInterval
+	}
+	r := &Replicator{Store: base, id: uuid.New(), cfg: cfg, doer: doer,
+		retry:       time.Duration(retry) * time.Millisecond,
+		collections: make(map[string]bool), expiries: make(map[string]time.Time),
+		followers: make(map[chan op]bool),
+		peerHosts: make(map[string]bool)}
+	for _, c := range cfg.Collections {
+		r.collections[c] = true
+	}
+	for _, peer := range cfg.Peers {
+		if u, err := url.Parse(peer); err == nil {
+			r.peerHosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	token, err := secrets.Value(cfg.Token)
+	if err != nil {
+		return nil, fmt.Errorf("replication.token: %v", err)
+	}
+	r.token = token
+	return r, nil
+}
+
+// Path returns the path of the stream on the NF server
+func (r *Replicator) Path() string {
+	if r.cfg.Path == "" {
+		return DefaultPath
+	}
+	return r.cfg.Path
+}
+
+// Put stores the value and streams it to the peers
+func (r *Replicator) Put(ctx context.Context, collection, key string,
+	value []byte, ttl time.Duration) error {
+	if err := r.Store.Put(ctx, collection, key, value, ttl); err != nil {
+		return err
+	}
+	r.publish(op{Op: opPut, Collection: collection, Key: key, Value: value,
+		TTL: ttlMillis(ttl)})
+	return nil
+}
+
+// Create stores the value unless it exists and streams it to the peers
+func (r *Replicator) Create(ctx context.Context, collection, key string,
+	value []byte, ttl time.Duration) (bool, error) {
+	created, err := r.Store.Create(ctx, collection, key, value, ttl)
+	if err != nil || !created {
+		return created, err
+	}
+	r.publish(op{Op: opPut, Collection: collection, Key: key, Value: value,
+		TTL: ttlMillis(ttl)})
+	return true, nil
+}
+
+// Delete removes the key and streams the deletion to the peers when it
+// existed
+func (r *Replicator) Delete(ctx context.Context, collection,
+	key string) (bool, error) {
+	deleted, err := r.Store.Delete(ctx, collection, key)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	r.publish(op{Op: opDelete, Collection: collection, Key: key})
+	return true, nil
+}
+
+// ttlMillis returns the time to live of the ops, in milliseconds rounded
+// up, so that a positive one is not taken for no expiry
+func ttlMillis(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// publish records the write and sends it to the followers
+func (r *Replicator) publish(o op) {
+	replicatedOps.WithLabelValues("sent", o.Op).Inc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.track(o)
+	for ch := range r.followers {
+		select {
+		case ch <- o:
+		default:
+			/* too far behind, it gets a new snapshot once reconnected */
+			delete(r.followers, ch)
+			close(ch)
+		}
+	}
+}
+
+// track records the collection and expiry of a write, with r.mu held
+func (r *Replicator) track(o op) {
+	r.collections[o.Collection] = true
+	k := o.Collection + "\x00" + o.Key
+	if o.Op == opPut && o.TTL > 0 {
+		r.expiries[k] = time.Now().Add(time.Duration(o.TTL) *
+			time.Millisecond)
+	} else {
+		delete(r.expiries, k)
+	}
+}
+
+// Handler serves the stream of the writes to the followers authenticated
+// as peers: the snapshot of the replicated collections, then the writes as
+// they happen, one JSON object per line
+func (r *Replicator) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				req.Method+" not allowed")
+			return
+		}
+		if !r.authenticated(req) {
+			logging.FromContext(req.Context()).Warnf("Replication stream "+
+				"refused to %s: not a peer", req.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			problem.Error(w, http.StatusUnauthorized, "",
+				"missing or wrong replication token")
+			return
+		}
+		if req.Header.Get(replicaHeader) == r.id {
+			problem.Error(w, http.StatusConflict, "", errSelf.Error())
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			problem.Error(w, http.StatusInternalServerError,
+				problem.CauseSystemFailure, "streaming not supported")
+			return
+		}
+		ctx := req.Context()
+		/* registered before the snapshot, so that no write is missed */
+		ch := make(chan op, backlog)
+		r.mu.Lock()
+		r.followers[ch] = true
+		r.mu.Unlock()
+		defer r.unfollow(ch)
+
+		ops, err := r.snapshotOps(ctx)
+		if err != nil {
+			problem.Error(w, http.StatusInternalServerError,
+				problem.CauseSystemFailure, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, o := range ops {
+			if enc.Encode(o) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		logging.FromContext(ctx).Infof("Replica %s follows the store, "+
+			"%d keys sent", req.Header.Get(replicaHeader), len(ops)-1)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case o, ok := <-ch:
+				if !ok || enc.Encode(o) != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// authenticated tells whether the follower is a peer: it sends the token,
+// or its client certificate, verified by mutual TLS, names one of the
+// peer hosts
+func (r *Replicator) authenticated(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	if r.token != "" && len(auth) > 7 && strings.EqualFold(auth[:7],
+		"Bearer ") && hmac.Equal([]byte(strings.TrimSpace(auth[7:])),
+		[]byte(r.token)) {
+		return true
+	}
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 ||
+		len(req.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	for _, name := range tlsutil.Names(req.TLS.VerifiedChains[0][0]) {
+		if r.peerHosts[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Replicator) unfollow(ch chan op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.followers[ch] {
+		delete(r.followers, ch)
+		close(ch)
+	}
+}
+
+// snapshotOps returns the writes of the replicated collections, ending
+// with the snapshot marker
+func (r *Replicator) snapshotOps(ctx context.Context) ([]op, error) {
+	r.mu.Lock()
+	collections := make([]string, 0, len(r.collections))
+	for c := range r.collections {
+		collections = append(collections, c)
+	}
+	r.mu.Unlock()
+	var ops []op
+	now := time.Now()
+	for _, c := range collections {
+		values, err := r.Store.List(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c, err)
+		}
+		r.mu.Lock()
+		for key, value := range values {
+			o := op{Op: opPut, Collection: c, Key: key, Value: value}
+			if at, ok := r.expiries[c+"\x00"+key]; ok {
+				left := at.Sub(now)
+				if left <= 0 {
+					continue
+				}
+				o.TTL = ttlMillis(left)
+			}
+			ops = append(ops, o)
+		}
+		r.mu.Unlock()
+	}
+	return append(ops, op{Op: opSnapshot}), nil
+}
+
+// Run follows the streams of the peers until the context is canceled
+func (r *Replicator) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, peer := range r.cfg.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			r.follow(ctx, strings.TrimSuffix(peer, "/"))
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// follow applies the writes of the peer, following its stream again after
+// the retry interval when it fails, until the context is canceled. Only the
+// first of the successive failures is logged as a warning
+func (r *Replicator) follow(ctx context.Context, peer string) {
+	failing := false
+	for {
+		connected, err := r.followOnce(ctx, peer)
+		followedPeers.WithLabelValues(peer).Set(0)
+		if errors.Is(err, errSelf) {
+			logging.Debugf("Replication peer %s is this replica", peer)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if connected || !failing {
+			logging.Warnf("Replication from %s stopped: %v", peer, err)
+		} else {
+			logging.Debugf("Replication from %s failed: %v", peer, err)
+		}
+		failing = !connected
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.retry):
+		}
+	}
+}
+
+// followOnce applies the writes of the stream of the peer until it fails,
+// connected telling whether the peer answered it
+func (r *Replicator) followOnce(ctx context.Context,
+	peer string) (connected bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, peer+r.Path(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(replicaHeader, r.id)
+	req.Header.Set("Accept", mediaType)
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.doer.Stream(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		return false, errSelf
+	default:
+		return false, fmt.Errorf("%s answered %d", peer, resp.StatusCode)
+	}
+	followedPeers.WithLabelValues(peer).Set(1)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxOpSize)
+	keys := 0
+	for scanner.Scan() {
+		var o op
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			return true, fmt.Errorf("invalid write: %v", err)
+		}
+		if o.Op == opSnapshot {
+			logging.Infof("Replication from %s: %d keys of the snapshot "+
+				"applied", peer, keys)
+			continue
+		}
+		if err := r.apply(ctx, o); err != nil {
+			return true, err
+		}
+		keys++
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, errors.New("end of stream")
+}
+
+// apply applies a write of a peer to the store, without streaming it
+func (r *Replicator) apply(ctx context.Context, o op) error {
+	var err error
+	switch o.Op {
+	case opPut:
+		err = r.Store.Put(ctx, o.Collection, o.Key, o.Value,
+			time.Duration(o.TTL)*time.Millisecond)
+	case opDelete:
+		_, err = r.Store.Delete(ctx, o.Collection, o.Key)
+	default:
+		return fmt.Errorf("unknown operation %q", o.Op)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s/%s: %v", o.Op, o.Collection, o.Key, err)
+	}
+	replicatedOps.WithLabelValues("received", o.Op).Inc()
+	r.mu.Lock()
+	r.track(o)
+	r.mu.Unlock()
+	return nil
+}
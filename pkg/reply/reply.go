@@ -0,0 +1,197 @@
+// Package reply renders the bodies the NF handlers answer with from the Go
+// templates of the configuration, selected by route, so that the NFs serve
+// as configurable test doubles of their peers. The templates are rendered
+// with Data, with a json function encoding a value as in the mock
+// templates, e.g. {{json .Body.correlationid}}
+package reply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+// DefaultBody is the reply of the routes without template
+const DefaultBody = "Hello Thanks !!!"
+
+// Data is what the template of a reply is rendered with
+type Data struct {
+	// Method, Path, Query and Header of the request, empty for the gRPC
+	// calls
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Body is the NF message received, decoded from JSON
+	Body interface{}
+	// NF is the role answering, e.g. nf2
+	NF string
+	// Location is the URI of the NF endpoint, as the NF sends it
+	Location string
+	// State is what the handler tells of the NF, e.g. the revision of the
+	// location recorded
+	State map[string]interface{}
+	// Now is the current time, in the format of the NF messages
+	Now string
+	// UUID is a random UUID, e.g. a resource identifier
+	UUID string
+	// Count is the number of the reply among those of the route, from 1
+	Count int
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Replies renders the replies of the routes of an NF
+type Replies struct {
+	nf       string
+	location string
+
+	mu     sync.RWMutex
+	routes map[string]*route
+}
+
+type route struct {
+	body    *template.Template
+	headers map[string]string
+
+	mu    sync.Mutex
+	count int
+}
+
+// New returns the replies of the configuration of the nf role, whose
+// endpoint is at location
+func New(nf, location string, cfg config.RepliesConfig) (*Replies, error) {
+	r := &Replies{nf: nf, location: location}
+	if err := r.Set(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Set replaces the templates with those of the configuration. The counts of
+// the routes start over
+func (r *Replies) Set(cfg config.RepliesConfig) error {
+	apply, err := r.Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare parses the templates of the configuration, and returns the
+// function replacing the templates with them
+func (r *Replies) Prepare(cfg config.RepliesConfig) (func(), error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	routes := make(map[string]*route, len(cfg.Routes))
+	for pattern, rc := range cfg.Routes {
+		t, err := template.New("replies.routes[" + pattern + "].body").
+			Funcs(funcs).Parse(rc.Body)
+		if err != nil {
+			return nil, err
+		}
+		routes[pattern] = &route{body: t, headers: rc.Headers}
+	}
+	return func() {
+		r.mu.Lock()
+		r.routes = routes
+		r.mu.Unlock()
+	}, nil
+}
+
+// Write answers the request of the route pattern with its reply to the NF
+// message msg, DefaultBody without template. A template failing to render
+// is answered with a 500 problem
+func (r *Replies) Write(w http.ResponseWriter, req *http.Request,
+	pattern string, msg interface{}, state map[string]interface{}) {
+	rt := r.route(pattern)
+	if rt == nil {
+		fmt.Fprint(w, DefaultBody)
+		return
+	}
+	data := r.data(rt, msg, state)
+	data.Method, data.Path = req.Method, req.URL.Path
+	data.Query, data.Header = req.URL.Query(), req.Header
+	var body bytes.Buffer
+	if err := rt.body.Execute(&body, data); err != nil {
+		logging.FromContext(req.Context()).Errorf("Reply not rendered: %v",
+			err)
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	for name, value := range rt.headers {
+		w.Header().Set(name, value)
+	}
+	if w.Header().Get("Content-Type") == "" && body.Len() > 0 {
+		w.Header().Set("Content-Type", contentType(body.Bytes()))
+	}
+	_, _ = w.Write(body.Bytes())
+}
+
+// Message returns the reply of the route pattern to the NF message msg
+// received over gRPC, DefaultBody without template
+func (r *Replies) Message(ctx context.Context, pattern string,
+	msg interface{}, state map[string]interface{}) (string, error) {
+	rt := r.route(pattern)
+	if rt == nil {
+		return DefaultBody, nil
+	}
+	var body bytes.Buffer
+	if err := rt.body.Execute(&body, r.data(rt, msg, state)); err != nil {
+		logging.FromContext(ctx).Errorf("Reply not rendered: %v", err)
+		return "", err
+	}
+	return body.String(), nil
+}
+
+func (r *Replies) route(pattern string) *route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.routes[pattern]
+}
+
+// data returns the data of the next reply of the route, without request
+func (r *Replies) data(rt *route, msg interface{},
+	state map[string]interface{}) Data {
+	rt.mu.Lock()
+	rt.count++
+	count := rt.count
+	rt.mu.Unlock()
+	data := Data{NF: r.nf, Location: r.location, State: state,
+		Now: time.Now().Format(time.RFC3339Nano), UUID: uuid.New(),
+		Count: count}
+	/* the message as the peer sent it, e.g. .Body.correlationid */
+	if b, err := json.Marshal(msg); err == nil {
+		_ = json.Unmarshal(b, &data.Body)
+	}
+	return data
+}
+
+// contentType returns JSON for the bodies looking like JSON, plain text
+// otherwise
+func contentType(body []byte) string {
+	b := bytes.TrimSpace(body)
+	if len(b) > 0 && (b[0] == '{' || b[0] == '[') {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,93 @@
+// Package requestid identifies the requests of an exchange across the NFs
+// with the X-Request-ID and 3gpp-Sbi-Correlation-Info headers
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	// Header carries the request ID
+	Header = "X-Request-ID"
+	// CorrelationHeader carries the 3GPP TS 29.500 correlation information
+	// of the exchange, e.g. "imsi-001010123456789"
+	CorrelationHeader = "3gpp-Sbi-Correlation-Info"
+	// Longest request ID accepted from a client
+	maxLength = 200
+)
+
+type contextKey struct{}
+
+type ids struct {
+	requestID   string
+	correlation string
+}
+
+// NewContext returns a context carrying the request ID and correlation
+// information
+func NewContext(ctx context.Context, id, correlation string) context.Context {
+	return context.WithValue(ctx, contextKey{}, ids{requestID: id,
+		correlation: correlation})
+}
+
+// FromContext returns the request ID carried by the context, empty when
+// there is none
+func FromContext(ctx context.Context) string {
+	v, _ := ctx.Value(contextKey{}).(ids)
+	return v.requestID
+}
+
+// CorrelationInfo returns the correlation information carried by the
+// context, empty when there is none
+func CorrelationInfo(ctx context.Context) string {
+	v, _ := ctx.Value(contextKey{}).(ids)
+	return v.correlation
+}
+
+// Middleware attaches the request ID and correlation information of the
+// request headers to its context. A request without a valid ID gets a new
+// one. The ID is echoed in the response headers
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = uuid.New()
+		}
+		correlation := r.Header.Get(CorrelationHeader)
+		if !valid(correlation) {
+			correlation = ""
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id,
+			correlation)))
+	})
+}
+
+// Inject sets the request ID and correlation information of the context on
+// the headers of an outbound request, unless they are already set
+func Inject(ctx context.Context, h http.Header) {
+	v, _ := ctx.Value(contextKey{}).(ids)
+	if v.requestID != "" && h.Get(Header) == "" {
+		h.Set(Header, v.requestID)
+	}
+	if v.correlation != "" && h.Get(CorrelationHeader) == "" {
+		h.Set(CorrelationHeader, v.correlation)
+	}
+}
+
+// valid reports whether a header value received from a client is short
+// and made of printable ASCII, so that it can be logged and sent on
+func valid(s string) bool {
+	if s == "" || len(s) > maxLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
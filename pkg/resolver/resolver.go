@@ -0,0 +1,160 @@
+// Package resolver expands the API root of a peer NF into the API roots of
+// its instances with DNS: the addresses of its host, e.g. the pods behind
+// a Kubernetes headless service, or the targets of the SRV records of a
+// host named _service._proto.name. The results are cached for the
+// configured TTL and resolved again once expired
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const defaultTTL = 30
+
+var resolutions = metrics.NewCounterVec("nf_dns_resolutions_total",
+	"DNS resolutions of the peer API roots by result.", "name", "result")
+
+type entry struct {
+	instances []client.Instance
+	expiry    time.Time
+}
+
+// Resolver resolves the API roots and caches the results per API root
+type Resolver struct {
+	ttl    time.Duration
+	lookup *net.Resolver
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+// New creates a resolver querying the DNS server of the configuration, or
+// the one of the system
+func New(cfg config.DNSConfig) *Resolver {
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	r := &Resolver{
+		ttl:    time.Duration(ttl) * time.Second,
+		lookup: net.DefaultResolver,
+		cache:  make(map[string]entry),
+	}
+	if cfg.Server != "" {
+		server := cfg.Server
+		r.lookup = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network,
+				_ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r
+}
+
+// Resolve returns the instances of the API root, e.g.
+// https://nf2.ns.svc.cluster.local:8090 or
+// https://_nf2._tcp.nf2.ns.svc.cluster.local, with the scheme and path of
+// the API root. The instances of the SRV records carry their priority, and
+// their weight as capacity. The cached instances are returned while they
+// are valid, and after they expired when the resolution fails
+func (r *Resolver) Resolve(ctx context.Context,
+	apiRoot string) ([]client.Instance, error) {
+	r.mu.Lock()
+	e, ok := r.cache[apiRoot]
+	r.mu.Unlock()
+	if ok && time.Now().Before(e.expiry) {
+		return e.instances, nil
+	}
+
+	u, err := url.Parse(apiRoot)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid API root %q", apiRoot)
+	}
+	instances, err := r.resolve(ctx, u)
+	if err == nil && len(instances) == 0 {
+		err = fmt.Errorf("no address for %s", u.Hostname())
+	}
+	if err != nil {
+		resolutions.WithLabelValues(u.Hostname(), "error").Inc()
+		if ok {
+			logging.FromContext(ctx).Warnf(
+				"DNS resolution of %s failed, using the previous "+
+					"addresses: %v", u.Hostname(), err)
+			return e.instances, nil
+		}
+		return nil, err
+	}
+	resolutions.WithLabelValues(u.Hostname(), "success").Inc()
+
+	r.mu.Lock()
+	r.cache[apiRoot] = entry{instances: instances,
+		expiry: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+	return instances, nil
+}
+
+// resolve looks up the SRV records of the host when named
+// _service._proto.name, its addresses otherwise
+func (r *Resolver) resolve(ctx context.Context,
+	u *url.URL) ([]client.Instance, error) {
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return []client.Instance{{APIRoot: u.String()}}, nil
+	}
+	if strings.HasPrefix(host, "_") {
+		_, records, err := r.lookup.LookupSRV(ctx, "", "", host)
+		if err != nil {
+			return nil, err
+		}
+		instances := make([]client.Instance, 0, len(records))
+		for _, srv := range records {
+			instances = append(instances, client.Instance{
+				APIRoot: root(u, strings.TrimSuffix(srv.Target, "."),
+					strconv.Itoa(int(srv.Port))),
+				Priority: int(srv.Priority),
+				Capacity: int(srv.Weight),
+			})
+		}
+		return instances, nil
+	}
+	addrs, err := r.lookup.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]client.Instance, 0, len(addrs))
+	for _, addr := range addrs {
+		instances = append(instances, client.Instance{
+			APIRoot: root(u, addr, u.Port())})
+	}
+	return instances, nil
+}
+
+// root returns the API root u on the host and port, the default port of
+// its scheme when empty
+func root(u *url.URL, host, port string) string {
+	out := *u
+	if port == "" {
+		out.Host = host
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			out.Host = "[" + host + "]"
+		}
+	} else {
+		out.Host = net.JoinHostPort(host, port)
+	}
+	return out.String()
+}
@@ -0,0 +1,498 @@
+// Package revocation checks whether the peer certificates are revoked, by
+// the CRLs of their issuer or the OCSP responder of the certificates, and
+// staples the OCSP responses of the server certificates to the handshakes
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+var (
+	revocationChecks = metrics.NewCounterVec(
+		"nf_tls_revocation_checks_total",
+		"Client certificates checked for revocation by result: good, "+
+			"revoked, unknown when neither the CRLs nor OCSP tell, or "+
+			"pending when accepted in soft fail mode before the first "+
+			"OCSP response.",
+		"result")
+	ocspFetches = metrics.NewCounterVec("nf_tls_ocsp_fetches_total",
+		"OCSP responses fetched by result: ok or failed.", "result")
+)
+
+// Default OCSP settings
+const (
+	defaultCacheTime = 3600 // seconds
+	defaultTimeout   = 5000 // milliseconds
+	// failedRetry is the time a failed OCSP query is not retried
+	failedRetry = time.Minute
+	// Largest OCSP response read
+	maxResponseSize = 1 << 20
+)
+
+// RevokedError rejects a revoked certificate
+type RevokedError struct {
+	Serial    *big.Int
+	RevokedAt time.Time
+	// Source is "CRL" or "OCSP"
+	Source string
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("certificate %x revoked on %s by %s", e.Serial,
+		e.RevokedAt.Format(time.RFC3339), e.Source)
+}
+
+// OCSP queries the OCSP responders of the certificates and keeps their
+// responses until their next update, for CacheTime at most. The responses
+// are fetched in the background, only the hard fail checks waiting for the
+// ones missing or failed
+type OCSP struct {
+	client *http.Client
+	maxAge time.Duration
+	// pending receives when a certificate is stapled for the first time
+	pending chan struct{}
+
+	mu        sync.Mutex
+	responses map[string]*response
+	// stapled are the certificates whose responses Refresh fetches, by key
+	stapled map[string]*stapledCert
+}
+
+// response is an OCSP response of the cache, or the error of a failed query
+type response struct {
+	resp    *ocsp.Response
+	raw     []byte
+	err     error
+	expires time.Time
+	// fetching is closed once the response fetched again is kept, nil when
+	// not fetching
+	fetching chan struct{}
+}
+
+// stapledCert is a certificate whose OCSP response is stapled
+type stapledCert struct {
+	cert *tls.Certificate
+	leaf *x509.Certificate
+	// used is the last time the certificate was stapled
+	used time.Time
+}
+
+// errNotFetched is returned for a certificate whose response is fetched
+// and none current is kept
+var errNotFetched = errors.New("no OCSP response yet, being fetched")
+
+// NewOCSP returns the OCSP responses of the configuration
+func NewOCSP(cfg config.RevocationConfig) *OCSP {
+	maxAge, timeout := cfg.CacheTime, cfg.Timeout
+	if maxAge == 0 {
+		maxAge = defaultCacheTime
+	}
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &OCSP{
+		client: &http.Client{
+			Timeout: time.Duration(timeout) * time.Millisecond},
+		maxAge:    time.Duration(maxAge) * time.Second,
+		pending:   make(chan struct{}, 1),
+		responses: make(map[string]*response),
+		stapled:   make(map[string]*stapledCert),
+	}
+}
+
+func cacheKey(cert *x509.Certificate) string {
+	return string(cert.RawIssuer) + cert.SerialNumber.String()
+}
+
+// current tells whether the response has not reached its next update
+func current(resp *ocsp.Response, now time.Time) bool {
+	return resp.NextUpdate.IsZero() || now.Before(resp.NextUpdate)
+}
+
+// lookup returns the response kept for the key, and whether it is to be
+// fetched, missing or past its cache time: it is then marked fetching, and
+// kept empty when missing
+func (o *OCSP) lookup(key string) (*response, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.responses[key]
+	if r != nil && (r.fetching != nil || time.Now().Before(r.expires)) {
+		return r, false
+	}
+	if r == nil {
+		r = &response{}
+		o.responses[key] = r
+	}
+	r.fetching = make(chan struct{})
+	return r, true
+}
+
+// closed is returned by refetch for the failed queries not retried yet
+var closed = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// refetch returns the channel closed once the response of the key is
+// fetched again, starting the query unless one is running, whatever the
+// cache time of the response kept. A failed query is not retried before
+// failedRetry, the channel returned being closed already
+func (o *OCSP) refetch(key string, cert,
+	issuer *x509.Certificate) <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.responses[key]
+	if r == nil {
+		r = &response{}
+		o.responses[key] = r
+	}
+	if r.fetching == nil && r.err != nil && time.Now().Before(r.expires) {
+		return closed
+	}
+	if r.fetching == nil {
+		r.fetching = make(chan struct{})
+		go o.fetch(key, r, cert, issuer)
+	}
+	return r.fetching
+}
+
+// Response returns the OCSP response kept for the certificate issued by
+// issuer. A response missing or past its cache time is fetched in the
+// background, the one kept being returned meanwhile until its next update
+func (o *OCSP) Response(cert, issuer *x509.Certificate) (*ocsp.Response,
+	error) {
+	key := cacheKey(cert)
+	r, fetch := o.lookup(key)
+	if fetch {
+		go o.fetch(key, r, cert, issuer)
+	}
+	switch {
+	case r.resp != nil && current(r.resp, time.Now()):
+		return r.resp, nil
+	case r.err != nil:
+		return nil, r.err
+	}
+	return nil, errNotFetched
+}
+
+// Wait returns the OCSP response of the certificate issued by issuer as
+// Response does, but fetches again the response missing or outdated, or
+// failed more than failedRetry ago, and waits for it until ctx is done
+func (o *OCSP) Wait(ctx context.Context, cert, issuer *x509.Certificate) (
+	*ocsp.Response, error) {
+	if resp, err := o.Response(cert, issuer); err == nil {
+		return resp, nil
+	}
+	select {
+	case <-o.refetch(cacheKey(cert), cert, issuer):
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for the OCSP response: %v",
+			ctx.Err())
+	}
+	return o.Response(cert, issuer)
+}
+
+// fetch queries the responder of the certificate and keeps the response in
+// place of prev, marked fetching by lookup
+func (o *OCSP) fetch(key string, prev *response, cert,
+	issuer *x509.Certificate) *response {
+	r := &response{}
+	defer o.keep(key, prev, r)
+	r.resp, r.raw, r.err = o.query(cert, issuer)
+	return r
+}
+
+// keep keeps the response of a query in place of prev, which is no longer
+// fetching. A failed query is kept for failedRetry
+func (o *OCSP) keep(key string, prev, r *response) {
+	now := time.Now()
+	if r.err != nil {
+		ocspFetches.WithLabelValues("failed").Inc()
+		r.expires = now.Add(failedRetry)
+	} else {
+		ocspFetches.WithLabelValues("ok").Inc()
+		r.expires = now.Add(o.maxAge)
+		next := r.resp.NextUpdate
+		if !next.IsZero() && next.Before(r.expires) {
+			r.expires = next
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	close(prev.fetching)
+	prev.fetching = nil
+	o.responses[key] = r
+}
+
+func (o *OCSP) query(cert, issuer *x509.Certificate) (*ocsp.Response,
+	[]byte, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := o.client.Post(cert.OCSPServer[0],
+		"application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder %s: %s",
+			cert.OCSPServer[0], resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OCSP responder %s: %v",
+			cert.OCSPServer[0], err)
+	}
+	return parsed, raw, nil
+}
+
+// Staple returns the certificate with the OCSP response of its leaf
+// stapled, when a good one is kept, and otherwise without staple. The
+// certificate is then stapled by Refresh, its response fetched in the
+// background. The certificates without their issuer in their chain are not
+// stapled
+func (o *OCSP) Staple(cert *tls.Certificate) *tls.Certificate {
+	if cert == nil || len(cert.Certificate) < 2 {
+		return cert
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return cert
+		}
+	}
+	key := cacheKey(leaf)
+	now := time.Now()
+	o.mu.Lock()
+	if sc := o.stapled[key]; sc != nil {
+		sc.used = now
+	} else {
+		o.stapled[key] = &stapledCert{cert: cert, leaf: leaf, used: now}
+		select {
+		case o.pending <- struct{}{}:
+		default:
+		}
+	}
+	r := o.responses[key]
+	o.mu.Unlock()
+	/* a response past its cache time is stapled until its next update */
+	var raw []byte
+	if r != nil && r.resp != nil && r.resp.Status == ocsp.Good &&
+		current(r.resp, now) {
+		raw = r.raw
+	}
+	if bytes.Equal(raw, cert.OCSPStaple) {
+		return cert
+	}
+	stapled := *cert
+	stapled.OCSPStaple = raw
+	return &stapled
+}
+
+// Pending receives when a certificate is stapled for the first time, its
+// response to be fetched by Refresh
+func (o *OCSP) Pending() <-chan struct{} {
+	return o.pending
+}
+
+// Refresh fetches the responses of the certificates stapled that are
+// missing or past their cache time, one after the other, and forgets the
+// certificates no longer stapled for the cache time
+func (o *OCSP) Refresh() {
+	now := time.Now()
+	var certs []*stapledCert
+	o.mu.Lock()
+	for key, sc := range o.stapled {
+		if now.Sub(sc.used) > o.maxAge {
+			delete(o.stapled, key)
+			continue
+		}
+		certs = append(certs, sc)
+	}
+	o.mu.Unlock()
+	for _, sc := range certs {
+		key := cacheKey(sc.leaf)
+		prev, fetch := o.lookup(key)
+		if !fetch {
+			continue
+		}
+		issuer, err := x509.ParseCertificate(sc.cert.Certificate[1])
+		if err != nil {
+			o.keep(key, prev, &response{err: err})
+			logging.Warnf("OCSP staple of %s: issuer: %v", sc.leaf.Subject,
+				err)
+			continue
+		}
+		if r := o.fetch(key, prev, sc.leaf, issuer); r.err != nil {
+			logging.Warnf("OCSP staple of %s: %v", sc.leaf.Subject, r.err)
+		}
+	}
+}
+
+// Checker checks the revocation of the peer certificates, with the CRLs of
+// their issuer first, then with OCSP
+type Checker struct {
+	hardFail bool
+	crls     []*x509.RevocationList
+	// ocsp queries the responders, nil without OCSP
+	ocsp *OCSP
+}
+
+// New returns the checker of the configuration, loading its CRL files, the
+// OCSP queries going through o. Nil when the revocation is not checked
+func New(cfg config.RevocationConfig, o *OCSP) (*Checker, error) {
+	if cfg.Mode == "" {
+		return nil, nil
+	}
+	c := &Checker{hardFail: cfg.Mode == config.RevocationHardFail}
+	if cfg.OCSP {
+		c.ocsp = o
+	}
+	for _, file := range cfg.CRLFiles {
+		crl, err := loadCRL(file)
+		if err != nil {
+			return nil, fmt.Errorf("CRL %s: %v", file, err)
+		}
+		c.crls = append(c.crls, crl)
+	}
+	return c, nil
+}
+
+func loadCRL(file string) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseRevocationList(data)
+}
+
+// HardFail tells whether the certificates of unknown status are rejected,
+// the OCSP queries then holding the handshakes
+func (c *Checker) HardFail() bool {
+	return c.hardFail
+}
+
+// Verifier returns the tls.Config.VerifyPeerCertificate checking the
+// revocation of the leaf of the verified chain, the OCSP queries of hard
+// fail mode bounded by ctx, the context of the handshake. Without a
+// verified chain there is nothing to check
+func (c *Checker) Verifier(ctx context.Context) func([][]byte,
+	[][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		if len(chains) == 0 || len(chains[0]) < 2 {
+			return nil
+		}
+		return c.Check(ctx, chains[0][0], chains[0][1])
+	}
+}
+
+// Check returns a *RevokedError when the certificate issued by issuer is
+// revoked. When neither a current CRL of the issuer nor OCSP tells its
+// status, the certificate is rejected in hard fail mode, which waits for
+// the OCSP response until ctx is done, and accepted in soft fail mode,
+// which does not wait and accepts the certificates whose first response is
+// still being fetched
+func (c *Checker) Check(ctx context.Context, cert,
+	issuer *x509.Certificate) error {
+	status, err := c.status(ctx, cert, issuer)
+	switch {
+	case err == nil:
+		revocationChecks.WithLabelValues("good").Inc()
+		return nil
+	case status == ocsp.Revoked:
+		revocationChecks.WithLabelValues("revoked").Inc()
+		return err
+	case !c.hardFail && errors.Is(err, errNotFetched):
+		revocationChecks.WithLabelValues("pending").Inc()
+		logging.Debugf("Revocation status of %s not fetched yet, accepted",
+			cert.Subject)
+		return nil
+	}
+	revocationChecks.WithLabelValues("unknown").Inc()
+	if c.hardFail {
+		return fmt.Errorf("revocation status of %s unknown: %v",
+			cert.Subject, err)
+	}
+	logging.Warnf("Revocation status of %s unknown, accepted: %v",
+		cert.Subject, err)
+	return nil
+}
+
+// status returns ocsp.Good with no error, ocsp.Revoked with a
+// *RevokedError, or ocsp.Unknown with the reason
+func (c *Checker) status(ctx context.Context, cert,
+	issuer *x509.Certificate) (int, error) {
+	reason := fmt.Errorf("no CRL of the issuer")
+	for _, crl := range c.crls {
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) ||
+			crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			reason = fmt.Errorf("CRL of the issuer outdated since %s",
+				crl.NextUpdate.Format(time.RFC3339))
+			continue
+		}
+		for _, e := range crl.RevokedCertificateEntries {
+			if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return ocsp.Revoked, &RevokedError{Serial: cert.SerialNumber,
+					RevokedAt: e.RevocationTime, Source: "CRL"}
+			}
+		}
+		return ocsp.Good, nil
+	}
+	if c.ocsp == nil {
+		return ocsp.Unknown, reason
+	}
+	var resp *ocsp.Response
+	var err error
+	if c.hardFail {
+		resp, err = c.ocsp.Wait(ctx, cert, issuer)
+	} else {
+		resp, err = c.ocsp.Response(cert, issuer)
+	}
+	switch {
+	case err != nil:
+		return ocsp.Unknown, err
+	case resp.Status == ocsp.Good:
+		return ocsp.Good, nil
+	case resp.Status == ocsp.Revoked:
+		return ocsp.Revoked, &RevokedError{Serial: cert.SerialNumber,
+			RevokedAt: resp.RevokedAt, Source: "OCSP"}
+	}
+	return ocsp.Unknown, fmt.Errorf("OCSP status unknown")
+}
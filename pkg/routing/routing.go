@@ -0,0 +1,464 @@
+// Package routing forwards the requests to upstream NFs after the routing
+// table of the configuration, so that the NF binary acts as a lightweight
+// SBI proxy, in the manner of an SCP. The requests are sent with the NF
+// client, so that the upstreams get the TLS, h2c, circuit breaker and
+// access token settings of their peer, and are streamed both ways
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Default health check settings
+const (
+	defaultCheckInterval      = 10000
+	defaultCheckTimeout       = 2000
+	defaultUnhealthyThreshold = 2
+	defaultHealthyThreshold   = 1
+)
+
+var (
+	proxiedRequests = metrics.NewCounterVec("nf_proxy_requests_total",
+		"Requests forwarded to the upstreams by route, upstream and status "+
+			"code, 0 when the upstream was not reached.",
+		"route", "upstream", "code")
+	upstreamHealthy = metrics.NewGaugeVec("nf_proxy_upstream_healthy",
+		"Health of the upstreams: 1 healthy, 0 unhealthy.", "upstream")
+)
+
+// hopHeaders are the hop-by-hop headers, not forwarded
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Proxy-Connection", "Te", "Trailer",
+	"Transfer-Encoding", "Upgrade"}
+
+// upstream is an upstream NF, shared by the routes listing it
+type upstream struct {
+	root    *url.URL
+	healthy atomic.Bool
+	// failures and successes count the checks in a row, guarded by the
+	// checker
+	failures  int
+	successes int
+}
+
+type route struct {
+	cfg       config.RouteConfig
+	upstreams []*upstream
+	balancer  client.Balancer
+}
+
+// Proxy forwards the requests of its routes
+type Proxy struct {
+	client    *client.Client
+	check     config.UpstreamCheckConfig
+	routes    []*route
+	upstreams []*upstream
+}
+
+// New creates the proxy of the routing table, sending the requests with
+// the client
+func New(cfg config.RoutingConfig, c *client.Client) (*Proxy, error) {
+	p := &Proxy{client: c, check: cfg.HealthCheck}
+	byRoot := make(map[string]*upstream)
+	seen := make(map[string]bool)
+	for _, rc := range cfg.Routes {
+		if !strings.HasPrefix(rc.Prefix, "/") {
+			return nil, fmt.Errorf("route prefix %q does not start with /",
+				rc.Prefix)
+		}
+		if seen[rc.Prefix] {
+			return nil, fmt.Errorf("route prefix %q listed twice", rc.Prefix)
+		}
+		seen[rc.Prefix] = true
+		if len(rc.Upstreams) == 0 {
+			return nil, fmt.Errorf("route %s has no upstream", rc.Prefix)
+		}
+		balancer, err := client.NewBalancer(rc.LoadBalancing)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %v", rc.Prefix, err)
+		}
+		r := &route{cfg: rc, balancer: balancer}
+		for _, root := range rc.Upstreams {
+			u, err := url.Parse(strings.TrimRight(root, "/"))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+				u.Host == "" {
+				return nil, fmt.Errorf("route %s: invalid upstream %q",
+					rc.Prefix, root)
+			}
+			up, ok := byRoot[u.String()]
+			if !ok {
+				up = &upstream{root: u}
+				up.healthy.Store(true)
+				upstreamHealthy.WithLabelValues(u.Host).Set(1)
+				byRoot[u.String()] = up
+				p.upstreams = append(p.upstreams, up)
+			}
+			r.upstreams = append(r.upstreams, up)
+		}
+		p.routes = append(p.routes, r)
+	}
+	return p, nil
+}
+
+// Patterns returns the router patterns of the routes: the prefixes, and
+// the prefixes followed by "/" to match the paths below them
+func (p *Proxy) Patterns() []string {
+	var patterns []string
+	for _, r := range p.routes {
+		patterns = append(patterns, r.cfg.Prefix)
+		if !strings.HasSuffix(r.cfg.Prefix, "/") {
+			patterns = append(patterns, r.cfg.Prefix+"/")
+		}
+	}
+	return patterns
+}
+
+// ServeHTTP forwards the request to an upstream of the route with the
+// longest prefix of its path
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := p.match(r.URL.Path)
+	if rt == nil {
+		problem.Error(w, http.StatusNotFound,
+			problem.CauseResourceURINotFound, "no route for "+r.URL.Path)
+		return
+	}
+	up, done := rt.pick()
+	if up == nil {
+		problem.Error(w, http.StatusServiceUnavailable,
+			problem.CauseNFServiceUnavailable,
+			"no healthy upstream for "+rt.cfg.Prefix)
+		return
+	}
+	defer done()
+	p.forward(w, r, rt, up)
+}
+
+// match returns the route with the longest prefix of the path
+func (p *Proxy) match(path string) *route {
+	var best *route
+	for _, r := range p.routes {
+		prefix := r.cfg.Prefix
+		if path != prefix && !strings.HasPrefix(path,
+			strings.TrimSuffix(prefix, "/")+"/") {
+			continue
+		}
+		if best == nil || len(prefix) > len(best.cfg.Prefix) {
+			best = r
+		}
+	}
+	return best
+}
+
+// pick returns the healthy upstream of the route chosen by its balancer
+// and the function to call once the request completed, nil when none is
+// healthy
+func (r *route) pick() (*upstream, func()) {
+	var healthy []*upstream
+	var instances []client.Instance
+	for _, up := range r.upstreams {
+		if up.healthy.Load() {
+			healthy = append(healthy, up)
+			instances = append(instances,
+				client.Instance{APIRoot: up.root.String()})
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, nil
+	}
+	inst, done := r.balancer.Pick(instances)
+	for _, up := range healthy {
+		if up.root.String() == inst.APIRoot {
+			return up, done
+		}
+	}
+	return healthy[0], done
+}
+
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, rt *route,
+	up *upstream) {
+	ctx := r.Context()
+	l := logging.FromContext(ctx)
+	target := *up.root
+	path := r.URL.Path
+	if rt.cfg.Rewrite != "" {
+		path = strings.TrimSuffix(rt.cfg.Rewrite, "/") + "/" +
+			strings.TrimPrefix(strings.TrimPrefix(path,
+				strings.TrimSuffix(rt.cfg.Prefix, "/")), "/")
+	}
+	target.Path = up.root.Path + path
+	target.RawQuery = r.URL.RawQuery
+
+	var body io.Reader
+	if r.ContentLength != 0 && r.Body != nil && r.Body != http.NoBody {
+		body = r.Body
+	}
+	out, err := http.NewRequestWithContext(ctx, r.Method, target.String(),
+		body)
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	out.ContentLength = r.ContentLength
+	out.Header = r.Header.Clone()
+	removeHopHeaders(out.Header)
+	forwardedFor(out.Header, r)
+	for _, name := range rt.cfg.RemoveHeaders {
+		out.Header.Del(name)
+	}
+	for name, value := range rt.cfg.SetHeaders {
+		out.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Stream(out)
+	if err != nil {
+		proxiedRequests.WithLabelValues(rt.cfg.Prefix, up.root.Host,
+			"0").Inc()
+		l.Warnf("Request to upstream %s failed: %v", up.root.Host, err)
+		var open *client.CircuitOpenError
+		var overloaded *client.OverloadError
+		switch {
+		case errors.As(err, &open), errors.As(err, &overloaded):
+			problem.Error(w, http.StatusServiceUnavailable,
+				problem.CauseNFServiceUnavailable, err.Error())
+		case ctx.Err() == context.DeadlineExceeded:
+			problem.Error(w, http.StatusGatewayTimeout,
+				problem.CauseTimedOutRequest, err.Error())
+		default:
+			problem.Error(w, http.StatusBadGateway,
+				problem.CauseTargetNFNotReachable, err.Error())
+		}
+		return
+	}
+	defer resp.Body.Close()
+	proxiedRequests.WithLabelValues(rt.cfg.Prefix, up.root.Host,
+		strconv.Itoa(resp.StatusCode)).Inc()
+
+	h := w.Header()
+	for name, values := range resp.Header {
+		h[name] = values
+	}
+	removeHopHeaders(h)
+	h.Del("Content-Length")
+	for name, value := range rt.cfg.ResponseHeaders {
+		h.Set(name, value)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if err := copyFlushing(w, resp.Body); err != nil {
+		/* the streams end when the client leaves */
+		if ctx.Err() != context.Canceled {
+			l.Warnf("Response of upstream %s cut: %v", up.root.Host, err)
+		}
+		return
+	}
+	/* the trailers, e.g. the gRPC status, are known once the body is read */
+	for name, values := range resp.Trailer {
+		h[http.TrailerPrefix+name] = values
+	}
+}
+
+// copyFlushing copies the response body as it arrives, so that the
+// streams and server-sent events are not held back
+func copyFlushing(w http.ResponseWriter, src io.Reader) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if ferr := rc.Flush(); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// removeHopHeaders removes the hop-by-hop headers, including the ones
+// listed in Connection
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// forwardedFor adds the client address, host and scheme of the request to
+// the X-Forwarded headers
+func forwardedFor(h http.Header, r *http.Request) {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := h.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		h.Set("X-Forwarded-For", host)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		h.Set("X-Forwarded-Proto", proto)
+	}
+}
+
+// UpstreamStatus is the health of an upstream
+type UpstreamStatus struct {
+	Upstream string `json:"upstream"`
+	Healthy  bool   `json:"healthy"`
+}
+
+// Status returns the health of the upstreams, sorted
+func (p *Proxy) Status() []UpstreamStatus {
+	out := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, up := range p.upstreams {
+		out = append(out, UpstreamStatus{Upstream: up.root.String(),
+			Healthy: up.healthy.Load()})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Upstream < out[j].Upstream
+	})
+	return out
+}
+
+// Run checks the health of the upstreams until the context is canceled
+func (p *Proxy) Run(ctx context.Context) {
+	interval := time.Duration(p.check.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultCheckInterval * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll checks the upstreams at once
+func (p *Proxy) checkAll(ctx context.Context) {
+	timeout := time.Duration(p.check.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout * time.Millisecond
+	}
+	unhealthy := p.check.UnhealthyThreshold
+	if unhealthy <= 0 {
+		unhealthy = defaultUnhealthyThreshold
+	}
+	healthy := p.check.HealthyThreshold
+	if healthy <= 0 {
+		healthy = defaultHealthyThreshold
+	}
+	var wg sync.WaitGroup
+	for _, up := range p.upstreams {
+		wg.Add(1)
+		go func(up *upstream) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			err := p.probe(cctx, up)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			up.update(err, unhealthy, healthy)
+		}(up)
+	}
+	wg.Wait()
+}
+
+// probe requests the health check path of the upstream, or connects to it
+func (p *Proxy) probe(ctx context.Context, up *upstream) error {
+	if p.check.Path == "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", hostPort(up.root))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	u := *up.root
+	u.Path += p.check.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(),
+		nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// update counts the outcome of a check and changes the health of the
+// upstream past the thresholds
+func (up *upstream) update(err error, unhealthy, healthy int) {
+	if err != nil {
+		up.successes = 0
+		up.failures++
+		if up.failures >= unhealthy && up.healthy.Load() {
+			up.healthy.Store(false)
+			upstreamHealthy.WithLabelValues(up.root.Host).Set(0)
+			logging.Warnf("Upstream %s unhealthy: %v", up.root, err)
+		}
+		return
+	}
+	up.failures = 0
+	up.successes++
+	if up.successes >= healthy && !up.healthy.Load() {
+		up.healthy.Store(true)
+		upstreamHealthy.WithLabelValues(up.root.Host).Set(1)
+		logging.Infof("Upstream %s healthy again", up.root)
+	}
+}
+
+// hostPort returns the host:port of the URL, with the default port of its
+// scheme
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), u.Scheme)
+}
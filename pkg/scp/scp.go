@@ -0,0 +1,84 @@
+// Package scp implements the 3GPP TS 29.500 indirect communication through
+// a Service Communication Proxy: the requests are sent to the SCP with the
+// API root of their target in the 3gpp-Sbi-Target-apiRoot header, and the
+// URIs answered to a request routed by an SCP are built on the API root the
+// consumer addressed
+package scp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TargetAPIRootHeader carries the API root of the NF a request routed by an
+// SCP is meant for
+const TargetAPIRootHeader = "3gpp-Sbi-Target-apiRoot"
+
+// ParseAPIRoot parses an API root: an http or https URI with an optional
+// path prefix, without query or fragment
+func ParseAPIRoot(s string) (*url.URL, bool) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" || u.User != nil || u.RawQuery != "" ||
+		u.Fragment != "" {
+		return nil, false
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, true
+}
+
+// Route returns a copy of the request sent to the SCP of the API root
+// instead of its target: the path is appended to the path prefix of the SCP
+// and the API root of the target is set in the 3gpp-Sbi-Target-apiRoot
+// header. The copy shares the body of the request
+func Route(req *http.Request, apiRoot *url.URL) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set(TargetAPIRootHeader, req.URL.Scheme+"://"+req.URL.Host)
+	out.URL.Scheme = apiRoot.Scheme
+	out.URL.Host = apiRoot.Host
+	out.URL.Path = apiRoot.Path + req.URL.Path
+	if req.URL.RawPath != "" {
+		out.URL.RawPath = apiRoot.EscapedPath() + req.URL.RawPath
+	}
+	out.Host = ""
+	return out
+}
+
+// TargetAPIRoot returns the API root the consumer addressed when the request
+// was routed by an SCP, nil otherwise or when the header is invalid
+func TargetAPIRoot(r *http.Request) *url.URL {
+	v := r.Header.Get(TargetAPIRootHeader)
+	if v == "" {
+		return nil
+	}
+	u, ok := ParseAPIRoot(v)
+	if !ok {
+		return nil
+	}
+	return u
+}
+
+// ReplyURI returns the URI of a resource of the NF to answer to the
+// request, e.g. in a Location header. When the request was routed by an
+// SCP, the scheme and host of uri are replaced with the target API root of
+// the request, so that the consumer reaches the resource through the SCP as
+// well
+func ReplyURI(r *http.Request, uri string) string {
+	root := TargetAPIRoot(r)
+	if root == nil {
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return uri
+	}
+	u.Scheme = root.Scheme
+	u.Host = root.Host
+	if root.Path != "" {
+		u.Path = root.Path + u.Path
+		u.RawPath = ""
+	}
+	return u.String()
+}
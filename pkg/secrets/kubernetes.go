@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+const defaultKubernetesDir = "/var/run/secrets/nf"
+
+// kubernetes reads the keys of the Kubernetes secrets mounted as volumes,
+// one directory per secret holding a file per key. The kubelet updates
+// them in place when the secrets change
+type kubernetes struct {
+	dir string
+}
+
+func newKubernetes(cfg config.KubernetesConfig) *kubernetes {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = defaultKubernetesDir
+	}
+	return &kubernetes{dir: dir}
+}
+
+// Get reads the key of the secret named "<secret>/<key>"
+func (k *kubernetes) Get(_ context.Context, name string) ([]byte, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" ||
+		strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[1], ".") {
+		return nil, errors.New("expected <secret>/<key>")
+	}
+	return ioutil.ReadFile(filepath.Join(k.dir, parts[0], parts[1]))
+}
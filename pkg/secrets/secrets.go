@@ -0,0 +1,197 @@
+// Package secrets reads the secrets referenced from the configuration,
+// "vault:<path>#<key>" from HashiCorp Vault and "k8s:<secret>/<key>" from
+// the mounted Kubernetes secrets, instead of plaintext files or values.
+// The secrets read are cached, and read again by the Watcher to follow
+// their rotation
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const defaultRefreshInterval = 60
+
+var reads = metrics.NewCounterVec("nf_secret_reads_total",
+	"Secrets read from their provider by provider and result.",
+	"provider", "result")
+
+// Provider reads the secrets of a reference scheme
+type Provider interface {
+	// Get returns the current value of the named secret
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+var (
+	mu        sync.Mutex
+	providers = map[string]Provider{}
+	// cache holds the values of the references read, by reference
+	cache = map[string][]byte{}
+)
+
+// Configure sets up the providers of the configuration: Vault when its
+// address is known and the mounted Kubernetes secrets. The cached secrets
+// are dropped
+func Configure(cfg config.SecretsConfig) error {
+	vault, err := newVault(cfg.Vault)
+	if err != nil {
+		return fmt.Errorf("vault: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	providers = map[string]Provider{
+		config.SecretKubernetes: newKubernetes(cfg.Kubernetes),
+	}
+	if vault != nil {
+		providers[config.SecretVault] = vault
+	}
+	cache = map[string][]byte{}
+	return nil
+}
+
+// Register sets the provider of the references of the scheme, e.g. to
+// replace one with a test double
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	providers[scheme] = p
+	for ref := range cache {
+		if strings.HasPrefix(ref, scheme+":") {
+			delete(cache, ref)
+		}
+	}
+	mu.Unlock()
+}
+
+// ReadFile returns the content of the file, or of the secret when path is
+// a reference
+func ReadFile(path string) ([]byte, error) {
+	if _, _, ok := config.SecretRef(path); !ok {
+		return ioutil.ReadFile(filepath.Clean(path))
+	}
+	return read(context.Background(), path)
+}
+
+// Value returns the value, or the value of the secret without the
+// surrounding white space when it is a reference
+func Value(s string) (string, error) {
+	if _, _, ok := config.SecretRef(s); !ok {
+		return s, nil
+	}
+	data, err := read(context.Background(), s)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// read returns the cached value of the reference, reading it from its
+// provider the first time
+func read(ctx context.Context, ref string) ([]byte, error) {
+	mu.Lock()
+	data, ok := cache[ref]
+	mu.Unlock()
+	if ok {
+		return data, nil
+	}
+	data, err := fetch(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	cache[ref] = data
+	mu.Unlock()
+	return data, nil
+}
+
+// fetch reads the reference from its provider
+func fetch(ctx context.Context, ref string) ([]byte, error) {
+	scheme, name, _ := config.SecretRef(ref)
+	mu.Lock()
+	p, ok := providers[scheme]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s: no %s secret provider configured", ref,
+			scheme)
+	}
+	data, err := p.Get(ctx, name)
+	if err != nil {
+		reads.WithLabelValues(scheme, "error").Inc()
+		return nil, fmt.Errorf("%s: %v", ref, err)
+	}
+	reads.WithLabelValues(scheme, "ok").Inc()
+	return data, nil
+}
+
+// Watcher reads the cached secrets again periodically and calls a function
+// when one of them changed, e.g. to reload the certificates
+type Watcher struct {
+	interval time.Duration
+	changed  func()
+}
+
+// NewWatcher creates a watcher reading the secrets every refresh interval
+// of the configuration. changed is called from the watcher Run goroutine
+func NewWatcher(cfg config.SecretsConfig, changed func()) *Watcher {
+	interval := cfg.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	return &Watcher{interval: time.Duration(interval) * time.Second,
+		changed: changed}
+}
+
+// Run watches the secrets until the context is canceled
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if Refresh(ctx) {
+				w.changed()
+			}
+		}
+	}
+}
+
+// Refresh reads the cached secrets again and tells whether one of them
+// changed. A secret that cannot be read keeps its cached value
+func Refresh(ctx context.Context) bool {
+	mu.Lock()
+	refs := make([]string, 0, len(cache))
+	for ref := range cache {
+		refs = append(refs, ref)
+	}
+	mu.Unlock()
+	sort.Strings(refs)
+	changed := false
+	for _, ref := range refs {
+		data, err := fetch(ctx, ref)
+		if err != nil {
+			logging.Warnf("Secret not refreshed: %v", err)
+			continue
+		}
+		mu.Lock()
+		old, ok := cache[ref]
+		if ok && !bytes.Equal(old, data) {
+			cache[ref] = data
+			changed = true
+			logging.Infof("Secret %s changed", ref)
+		}
+		mu.Unlock()
+	}
+	return changed
+}
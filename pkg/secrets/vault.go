@@ -0,0 +1,231 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// Default Vault settings
+const (
+	defaultVaultMount    = "secret"
+	defaultVaultAuthPath = "kubernetes"
+	defaultVaultTimeout  = 5000
+	// serviceAccountTokenFile is the token of the pod logging in with the
+	// Kubernetes auth method
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// vault reads the secrets of the KV version 2 engine of HashiCorp Vault
+type vault struct {
+	address   string
+	namespace string
+	mount     string
+	tokenFile string
+	role      string
+	authPath  string
+	client    *http.Client
+
+	mu sync.Mutex
+	// token is the token of the Kubernetes login, renewed once expired
+	token  string
+	expiry time.Time
+}
+
+// newVault creates the provider, nil when no Vault address is known
+func newVault(cfg config.VaultConfig) (*vault, error) {
+	v := &vault{
+		address:   strings.TrimRight(orEnv(cfg.Address, "VAULT_ADDR"), "/"),
+		namespace: orEnv(cfg.Namespace, "VAULT_NAMESPACE"),
+		mount:     strings.Trim(cfg.Mount, "/"),
+		tokenFile: cfg.TokenFile,
+		role:      cfg.Role,
+		authPath:  strings.Trim(cfg.AuthPath, "/"),
+	}
+	if v.address == "" {
+		return nil, nil
+	}
+	if v.mount == "" {
+		v.mount = defaultVaultMount
+	}
+	if v.authPath == "" {
+		v.authPath = defaultVaultAuthPath
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultVaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		ca, err := ioutil.ReadFile(filepath.Clean(cfg.CAFile))
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool,
+			MinVersion: tls.VersionTLS12}
+	}
+	v.client = &http.Client{Transport: transport,
+		Timeout: time.Duration(timeout) * time.Millisecond}
+	return v, nil
+}
+
+func orEnv(value, env string) string {
+	if value == "" {
+		return os.Getenv(env)
+	}
+	return value
+}
+
+// Get reads the key of the secret named "<path>#<key>", logging in again
+// once when the Kubernetes login token was refused
+func (v *vault) Get(ctx context.Context, name string) ([]byte, error) {
+	i := strings.LastIndex(name, "#")
+	if i <= 0 || i == len(name)-1 {
+		return nil, errors.New("expected <path>#<key>")
+	}
+	path, key := strings.Trim(name[:i], "/"), name[i+1:]
+	data, status, err := v.read(ctx, path, key)
+	if status == http.StatusForbidden && v.role != "" {
+		v.mu.Lock()
+		v.token = ""
+		v.mu.Unlock()
+		data, _, err = v.read(ctx, path, key)
+	}
+	return data, err
+}
+
+// read reads the key of the secret at path and returns the status of the
+// response
+func (v *vault) read(ctx context.Context, path, key string) ([]byte, int,
+	error) {
+	token, err := v.currentToken(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rsp struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	status, err := v.do(ctx, http.MethodGet,
+		"/v1/"+v.mount+"/data/"+path, token, nil, &rsp)
+	if err != nil {
+		return nil, status, err
+	}
+	value, ok := rsp.Data.Data[key]
+	if !ok {
+		return nil, status, fmt.Errorf("no key %q in %s", key, path)
+	}
+	switch value := value.(type) {
+	case string:
+		return []byte(value), status, nil
+	default:
+		data, err := json.Marshal(value)
+		return data, status, err
+	}
+}
+
+// currentToken returns the token of the requests: the one of the
+// Kubernetes login with a role, else the one of the token file or of
+// VAULT_TOKEN
+func (v *vault) currentToken(ctx context.Context) (string, error) {
+	if v.role == "" {
+		if v.tokenFile == "" {
+			return os.Getenv("VAULT_TOKEN"), nil
+		}
+		data, err := ioutil.ReadFile(filepath.Clean(v.tokenFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && time.Now().Before(v.expiry) {
+		return v.token, nil
+	}
+	jwt, err := ioutil.ReadFile(serviceAccountTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("kubernetes login: %v", err)
+	}
+	var rsp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role": v.role,
+		"jwt": strings.TrimSpace(string(jwt))}
+	if _, err := v.do(ctx, http.MethodPost, "/v1/auth/"+v.authPath+"/login",
+		"", body, &rsp); err != nil {
+		return "", fmt.Errorf("kubernetes login: %v", err)
+	}
+	if rsp.Auth.ClientToken == "" {
+		return "", errors.New("kubernetes login: no token")
+	}
+	/* renewed at two thirds of the lease */
+	lease := time.Duration(rsp.Auth.LeaseDuration) * time.Second
+	v.token, v.expiry = rsp.Auth.ClientToken, time.Now().Add(lease*2/3)
+	return v.token, nil
+}
+
+// do sends a request to Vault and decodes its response into out
+func (v *vault) do(ctx context.Context, method, path, token string,
+	in, out interface{}) (int, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+path,
+		bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var verr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &verr) == nil && len(verr.Errors) > 0 {
+			return resp.StatusCode, fmt.Errorf("%s %s: %d %s", method, path,
+				resp.StatusCode, strings.Join(verr.Errors, ", "))
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %d", method, path,
+			resp.StatusCode)
+	}
+	return resp.StatusCode, json.Unmarshal(data, out)
+}
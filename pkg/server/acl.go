@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+)
+
+// acl decides the access to the routes by the identity of the client
+// certificates. Its rules are replaced on reload
+type acl struct {
+	cfg atomic.Value
+}
+
+func newACL(cfg config.ACLConfig) (*acl, error) {
+	a := &acl{}
+	if err := a.set(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// set replaces the rules
+func (a *acl) set(cfg config.ACLConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	a.cfg.Store(cfg)
+	return nil
+}
+
+// decide returns the action for the request of the identities to the route
+// pattern of the server, with the index of the rule deciding it, -1 for the
+// default action
+func (a *acl) decide(identities []string, server, pattern,
+	method string) (string, int) {
+	cfg := a.cfg.Load().(config.ACLConfig)
+	for i, r := range cfg.Rules {
+		if matchAny(r.Clients, identities) &&
+			(len(r.Servers) == 0 || contains(r.Servers, server)) &&
+			matchAny(r.Routes, []string{pattern}) &&
+			(len(r.Methods) == 0 || containsFold(r.Methods, method)) {
+			return r.Action, i
+		}
+	}
+	if cfg.Default == "" {
+		return config.ACLDeny, -1
+	}
+	return cfg.Default, -1
+}
+
+// matchAny tells whether one of the values matches one of the patterns,
+// true when there are no patterns
+func matchAny(patterns, values []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		for _, v := range values {
+			if ok, _ := path.Match(p, v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware rejects the requests to the route pattern of the named server
+// the rules deny with 403 problem details, writing them to the audit log
+func (a *acl) middleware(server, pattern string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var identities []string
+			if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+				identities = tlsutil.Names(r.TLS.PeerCertificates[0])
+			}
+			action, rule := a.decide(identities, server, pattern, r.Method)
+			aclDecisions.WithLabelValues(server, pattern, action).Inc()
+			l := logging.FromContext(r.Context()).With(logging.Fields{
+				"audit": "acl", "decision": action, "rule": rule,
+				"client": strings.Join(identities, ",")})
+			if action == config.ACLDeny {
+				l.Warnf("Access to %s %s denied to %s", r.Method, pattern,
+					clientIdentity(r))
+				audit.Log(r.Context(), audit.Event{Type: audit.AuthzDenied,
+					Outcome: audit.Failure, Subject: clientIdentity(r),
+					Action: r.Method + " " + r.URL.Path,
+					Reason: "denied by the access control",
+					Fields: map[string]interface{}{"server": server,
+						"route": pattern, "rule": rule,
+						"identities": identities}})
+				problem.Error(w, http.StatusForbidden, "",
+					"access to "+pattern+" not allowed")
+				return
+			}
+			l.Debugf("Access to %s %s allowed to %s", r.Method, pattern,
+				clientIdentity(r))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
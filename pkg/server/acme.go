@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+// newACME returns the manager obtaining and renewing the certificates of
+// the ACME configuration. The certificates of a host are obtained at its
+// first handshake and kept in the cache directory
+func newACME(cfg config.ACMEConfig) (*autocert.Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	dir := cfg.CacheDir
+	if dir == "" {
+		dir = config.DefaultACMECacheDir
+	}
+	m := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(dir),
+		HostPolicy:  autocert.HostWhitelist(cfg.Hosts...),
+		Email:       cfg.Email,
+		RenewBefore: time.Duration(cfg.RenewBefore) * time.Hour,
+	}
+	if cfg.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	return m, nil
+}
+
+// acmeChallenges returns the task answering the HTTP-01 challenges of the
+// CA on the address, which redirects the other requests to https
+func acmeChallenges(m *autocert.Manager, addr string) func(context.Context) {
+	if addr == "" {
+		addr = config.DefaultACMEHTTPAddress
+	}
+	return func(ctx context.Context) {
+		srv := &http.Server{Addr: addr, Handler: m.HTTPHandler(nil),
+			ReadHeaderTimeout: millis(0, defaultReadHeaderTimeout)}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+		logging.Infof("ACME HTTP-01 challenges served on %s", addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			logging.Errorf("ACME HTTP-01 server error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+)
+
+// Default admin settings
+const (
+	defaultAdminAddress = "127.0.0.1:9990"
+	defaultDrainTimeout = 30000
+	adminServerName     = "Admin"
+)
+
+// AddAdminServer adds the admin listener of the configuration. It serves
+// on plain HTTP the health, readiness and metrics endpoints along with the
+// runtime controls:
+//
+//	/admin/logging      log level and request capture, see
+//	                    logging.CaptureHandler
+//	/admin/inflight     requests in progress and their correlation IDs
+//	/admin/drain        POST drains the NF, see Drain
+//	/admin/maintenance  routes out of service: GET, PUT or DELETE
+//	/admin/slo          route latencies against their objectives, when
+//	                    tracked
+//
+// the WebSocket traffic monitor on /admin/monitor when enabled, see Publish,
+// and, when debug is set, the pprof profiles under /debug/pprof/ and the
+// expvar variables on /debug/vars. With a token file the controls and the
+// debug endpoints require its Bearer token. The NFs register their own
+// controls on the Admin router. Nothing is added when the listener is
+// disabled
+func (s *Service) AddAdminServer() error {
+	cfg := s.Config.Admin
+	if !cfg.Enabled {
+		return nil
+	}
+	addr := cfg.Address
+	if addr == "" {
+		addr = defaultAdminAddress
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("failed at configuring %s server: %v",
+			adminServerName, err)
+	}
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	router := NewRouter("")
+	var token string
+	if cfg.TokenFile != "" {
+		token, err = readToken(cfg.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s server: %v",
+				adminServerName, err)
+		}
+		router.Use(func(h http.Handler) http.Handler {
+			return requireToken(token, h)
+		})
+	}
+	handler := Chain{RequestID(), Logging(), auditActions,
+		Recover(adminServerName, router)}.Then(router)
+	ns := &namedServer{name: adminServerName, router: router, plain: true,
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: millis(0, defaultReadHeaderTimeout),
+			ErrorLog: log.New(errorLogWriter{server: adminServerName},
+				"", 0),
+		},
+		addrs: []*listenAddr{{address: addr}}}
+	router.handleRaw(healthzPath, http.HandlerFunc(healthz))
+	router.handleRaw(readyzPath, http.HandlerFunc(s.readyz))
+	router.handleRaw(defaultMetricsPath, metrics.Handler())
+	router.Handle("/admin/logging", logging.CaptureHandler())
+	router.Handle("/admin/inflight", http.HandlerFunc(s.inflight.list))
+	router.Handle("/admin/drain", http.HandlerFunc(s.drainHandler))
+	router.Handle("/admin/maintenance", s.maintenance.handler())
+	if s.Faults != nil {
+		router.Handle("/admin/faults", s.Faults.Handler())
+	}
+	if s.Recorder != nil {
+		router.Handle("/admin/record", s.Recorder.Handler())
+	}
+	if s.slos != nil {
+		router.Handle("/admin/slo", s.slos.handler())
+	}
+	if cfg.Monitor.Enabled {
+		if err := s.addMonitor(router, cfg.Monitor, token); err != nil {
+			return fmt.Errorf("failed at configuring %s monitor: %v",
+				adminServerName, err)
+		}
+	}
+	if cfg.Debug {
+		router.HandleFunc("/debug/pprof/", pprof.Index)
+		router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		router.Handle("/debug/vars", expvar.Handler())
+	}
+	s.admin = ns
+	return nil
+}
+
+// readToken reads the admin token from the file or secret reference,
+// ignoring the surrounding white space
+func readToken(file string) (string, error) {
+	data, err := secrets.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("empty token in " + file)
+	}
+	return token, nil
+}
+
+// requireToken rejects the requests without the Bearer token with 401
+// problem details
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[7:])),
+				[]byte(token)) != 1 {
+			auditAuthn(r, "missing or wrong admin token")
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			problem.Error(w, http.StatusUnauthorized, "",
+				"missing or wrong admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditActions writes the admin requests acting on the NF, those other
+// than GET, HEAD and OPTIONS, to the audit log with their status
+func auditActions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &logging.StatusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.Status == 0 {
+			sw.Status = http.StatusOK
+		}
+		outcome := audit.Success
+		if sw.Status >= http.StatusBadRequest {
+			outcome = audit.Failure
+		}
+		audit.Log(r.Context(), audit.Event{Type: audit.AdminAction,
+			Outcome: outcome, Subject: clientIdentity(r),
+			Action: r.Method + " " + r.URL.Path,
+			Fields: map[string]interface{}{"status": sw.Status}})
+	})
+}
+
+// Admin returns the router of the admin server, nil when it is disabled
+func (s *Service) Admin() *Router {
+	if s.admin == nil {
+		return nil
+	}
+	return s.admin.router
+}
+
+// JSONHandler returns a handler answering GET requests with the JSON
+// encoding of the value returned by get, e.g. the active configuration
+func JSONHandler(get func() interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+			return
+		}
+		body, err := json.MarshalIndent(get(), "", "  ")
+		if err != nil {
+			problem.Error(w, http.StatusInternalServerError,
+				problem.CauseSystemFailure, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(body, '\n'))
+	})
+}
+
+// Drain stops the NF gracefully: the readiness probes fail from now on,
+// the servers stop accepting connections and Run returns once the requests
+// in progress completed or the drain timeout expired
+func (s *Service) Drain() {
+	s.drainOnce.Do(func() {
+		logging.Infof("Draining %s", s.Name)
+		atomic.StoreInt32(&s.draining, 1)
+		close(s.drained)
+	})
+}
+
+func (s *Service) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+func (s *Service) drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		problem.Error(w, http.StatusMethodNotAllowed, "",
+			r.Method+" not allowed")
+		return
+	}
+	s.Drain()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// shutdown stops the NF servers gracefully, closing the connections still
+// active after the timeout
+func (s *Service) shutdown(timeout time.Duration) {
+	for _, f := range s.onShutdown {
+		f()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, ns := range s.servers {
+		wg.Add(1)
+		go func(ns *namedServer) {
+			defer wg.Done()
+			if err := ns.server.Shutdown(ctx); err != nil {
+				logging.Warnf("%s server not drained: %v", ns.name, err)
+				_ = ns.server.Close()
+				return
+			}
+			logging.Infof("%s server drained", ns.name)
+		}(ns)
+	}
+	wg.Wait()
+}
+
+type inflightKey struct{}
+
+// inflight tracks the requests in progress of the servers
+type inflight struct {
+	mu       sync.Mutex
+	requests map[*inflightRequest]struct{}
+}
+
+type inflightRequest struct {
+	requestID string
+	server    string
+	method    string
+	path      string
+	peer      string
+	started   time.Time
+	// correlationID holds the string set by SetCorrelationID
+	correlationID atomic.Value
+}
+
+// track records the requests of the named server while they are handled
+func (in *inflight) track(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return in.handler(name, next)
+	}
+}
+
+func (in *inflight) handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &inflightRequest{requestID: requestid.FromContext(r.Context()),
+			server: name, method: r.Method,
+			path: r.URL.Path, peer: logging.PeerNF(r), started: time.Now()}
+		in.mu.Lock()
+		if in.requests == nil {
+			in.requests = make(map[*inflightRequest]struct{})
+		}
+		in.requests[req] = struct{}{}
+		in.mu.Unlock()
+		defer func() {
+			in.mu.Lock()
+			delete(in.requests, req)
+			in.mu.Unlock()
+		}()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(),
+			inflightKey{}, req)))
+	})
+}
+
+// SetCorrelationID attaches the correlation ID of the exchange to the
+// request of the context, listed by /admin/inflight
+func SetCorrelationID(ctx context.Context, id string) {
+	if req, ok := ctx.Value(inflightKey{}).(*inflightRequest); ok {
+		req.correlationID.Store(id)
+	}
+}
+
+// list answers with the requests in progress, the oldest first
+func (in *inflight) list(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		Server        string  `json:"server"`
+		Method        string  `json:"method"`
+		Path          string  `json:"path"`
+		Peer          string  `json:"peer"`
+		RequestID     string  `json:"requestid,omitempty"`
+		CorrelationID string  `json:"correlationid,omitempty"`
+		Started       string  `json:"started"`
+		AgeMs         float64 `json:"age_ms"`
+	}
+	now := time.Now()
+	in.mu.Lock()
+	reqs := make([]*inflightRequest, 0, len(in.requests))
+	for req := range in.requests {
+		reqs = append(reqs, req)
+	}
+	in.mu.Unlock()
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].started.Before(reqs[j].started)
+	})
+	entries := make([]entry, 0, len(reqs))
+	for _, req := range reqs {
+		id, _ := req.correlationID.Load().(string)
+		entries = append(entries, entry{Server: req.server,
+			Method: req.method, Path: req.path, Peer: req.peer,
+			RequestID: req.requestID, CorrelationID: id,
+			Started: req.started.Format(time.RFC3339Nano),
+			AgeMs:   float64(now.Sub(req.started).Microseconds()) / 1000,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/priority"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Default admission settings
+const (
+	defaultQueueTimeout    = 1000
+	defaultRetryAfter      = 1
+	defaultPriorityLevels  = 4
+	defaultMessagePriority = 24
+)
+
+// admission limits the requests a server handles at once. The requests
+// over the limit wait for a slot in a bounded queue, by priority level and
+// in order of arrival within a level
+type admission struct {
+	server          string
+	max             int
+	maxQueue        int
+	levels          int
+	defaultPriority int
+	timeout         time.Duration
+	retryAfter      string
+
+	mu       sync.Mutex
+	inflight int
+	queued   int
+	// queue holds the waiters of each level, the highest priority first
+	queue [][]*waiter
+}
+
+// waiter is a request waiting in the queue
+type waiter struct {
+	level int
+	// done is closed when the request is admitted or shed
+	done chan struct{}
+	// shed is the reason why the request is shed, empty when admitted
+	shed string
+}
+
+// newAdmission returns the admission control of the named server, nil when
+// it is disabled
+func newAdmission(server string, cfg config.AdmissionConfig) *admission {
+	if !cfg.Enabled || cfg.MaxInFlight <= 0 {
+		return nil
+	}
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	levels := cfg.PriorityLevels
+	switch {
+	case levels <= 0:
+		levels = defaultPriorityLevels
+	case levels > priority.Lowest+1:
+		levels = priority.Lowest + 1
+	}
+	defaultPriority := cfg.DefaultPriority
+	switch {
+	case defaultPriority <= 0:
+		defaultPriority = defaultMessagePriority
+	case defaultPriority > priority.Lowest:
+		defaultPriority = priority.Lowest
+	}
+	return &admission{
+		server:          server,
+		max:             cfg.MaxInFlight,
+		maxQueue:        cfg.MaxQueue,
+		levels:          levels,
+		defaultPriority: defaultPriority,
+		timeout:         millis(cfg.QueueTimeout, defaultQueueTimeout),
+		retryAfter:      strconv.Itoa(retryAfter),
+		queue:           make([][]*waiter, levels),
+	}
+}
+
+// middleware sheds the requests that cannot be admitted with 503 problem
+// details and a Retry-After header, rather than letting them time out
+func (a *admission) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := a.acquire(r); reason != "" {
+			requestsShed.WithLabelValues(a.server, reason).Inc()
+			logging.FromContext(r.Context()).Warnf(
+				"%s server saturated, request shed (%s)", a.server, reason)
+			w.Header().Set("Retry-After", a.retryAfter)
+			problem.Error(w, http.StatusServiceUnavailable,
+				problem.CauseNFCongestion, "server overloaded")
+			return
+		}
+		defer a.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// level returns the queue level of the message priority of the request, 0
+// for the highest priorities
+func (a *admission) level(r *http.Request) int {
+	p, ok := priority.FromContext(r.Context())
+	if !ok {
+		p = a.defaultPriority
+	}
+	return p * a.levels / (priority.Lowest + 1)
+}
+
+// acquire takes a slot, waiting in the queue when there is room or when a
+// request of a lower level waits, which is shed to make room. It returns
+// the reason why the request is shed otherwise
+func (a *admission) acquire(r *http.Request) string {
+	level := a.level(r)
+	a.mu.Lock()
+	if a.inflight < a.max {
+		a.inflight++
+		a.mu.Unlock()
+		return ""
+	}
+	if a.queued >= a.maxQueue && !a.preempt(level) {
+		a.mu.Unlock()
+		return "queue_full"
+	}
+	w := &waiter{level: level, done: make(chan struct{})}
+	a.queue[level] = append(a.queue[level], w)
+	a.queued++
+	a.mu.Unlock()
+
+	depth := admissionQueue.WithLabelValues(a.server)
+	depth.Inc()
+	defer depth.Dec()
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+	reason := ""
+	select {
+	case <-w.done:
+		return w.shed
+	case <-timer.C:
+		reason = "queue_timeout"
+	case <-r.Context().Done():
+		reason = "canceled"
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-w.done:
+		/* admitted or shed meanwhile */
+		return w.shed
+	default:
+	}
+	a.remove(w)
+	return reason
+}
+
+// preempt sheds the last request of the lowest level below level to make
+// room in the queue, and reports whether there was one. a.mu is held
+func (a *admission) preempt(level int) bool {
+	for l := a.levels - 1; l > level; l-- {
+		if n := len(a.queue[l]); n > 0 {
+			w := a.queue[l][n-1]
+			a.remove(w)
+			w.shed = "preempted"
+			close(w.done)
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes the waiter out of the queue. a.mu is held
+func (a *admission) remove(w *waiter) {
+	q := a.queue[w.level]
+	for i, v := range q {
+		if v == w {
+			a.queue[w.level] = append(q[:i:i], q[i+1:]...)
+			a.queued--
+			return
+		}
+	}
+}
+
+// release hands the slot of a finished request to the first waiter of the
+// highest level, or frees it
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for l, q := range a.queue {
+		if len(q) > 0 {
+			w := q[0]
+			a.queue[l] = q[1:]
+			a.queued--
+			close(w.done)
+			return
+		}
+	}
+	a.inflight--
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"golang.org/x/crypto/acme"
+)
+
+var alpnNegotiated = metrics.NewCounterVec("nf_tls_alpn_negotiated_total",
+	"TLS connections to the NF servers by the protocol negotiated by ALPN: "+
+		"h2, http/1.1, or none for the clients without ALPN.",
+	"server", "protocol", "peer")
+
+// errNoHTTP11 rejects the handshakes of the clients without ALPN, or
+// offering HTTP/1.1 only, on the endpoints not serving it
+var errNoHTTP11 = errors.New("tls: no application protocol negotiated and " +
+	"http/1.1 not allowed")
+
+// connKey is the context key of the connection of a request
+type connKey struct{}
+
+// conn records whether the negotiated protocol of a connection was counted
+type conn struct {
+	once sync.Once
+}
+
+// connContext attaches a record of the connection to the context of its
+// requests
+func connContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, &conn{})
+}
+
+// alpnMetrics counts the protocol negotiated on the TLS connections of the
+// named server and their peer, at the first request of each connection
+func alpnMetrics(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c, ok := r.Context().Value(connKey{}).(*conn); ok &&
+				r.TLS != nil {
+				c.once.Do(func() {
+					protocol := r.TLS.NegotiatedProtocol
+					if protocol == "" {
+						protocol = "none"
+					}
+					alpnNegotiated.WithLabelValues(name, protocol,
+						peerLabel(r)).Inc()
+				})
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withALPN restricts the protocols the TLS configuration negotiates to the
+// ones listed, in order, keeping the ACME challenges. Without HTTP/1.1,
+// the handshakes negotiating no protocol are rejected: the clients would
+// speak HTTP/1.1 over them
+func withALPN(tlsConfig *tls.Config, protocols []string) {
+	if len(protocols) == 0 {
+		return
+	}
+	next := append([]string(nil), protocols...)
+	http11 := false
+	for _, p := range protocols {
+		http11 = http11 || p == config.ALPNHTTP11
+	}
+	for _, p := range tlsConfig.NextProtos {
+		if p == acme.ALPNProto {
+			next = append(next, p)
+		}
+	}
+	tlsConfig.NextProtos = next
+	if http11 {
+		return
+	}
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if cs.NegotiatedProtocol == "" {
+			return errNoHTTP11
+		}
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/jwt"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// authenticate rejects the requests without a valid Bearer token granting
+// the scopes, with 401 and 403 problem details respectively, both audited
+func authenticate(v *jwt.Validator, scopes []string,
+	next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := logging.FromContext(r.Context())
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			auditAuthn(r, "missing Bearer access token")
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			problem.Error(w, http.StatusUnauthorized, "",
+				"missing Bearer access token")
+			return
+		}
+		claims, err := v.Validate(r.Context(), strings.TrimSpace(auth[7:]))
+		if err != nil {
+			l.Warnf("Access token rejected: %v", err)
+			auditAuthn(r, err.Error())
+			w.Header().Set("WWW-Authenticate",
+				`Bearer error="invalid_token"`)
+			problem.Error(w, http.StatusUnauthorized, "",
+				err.Error())
+			return
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				l.Warnf("Access token of %s lacks scope %s", claims.Subject,
+					scope)
+				audit.Log(r.Context(), audit.Event{Type: audit.AuthzDenied,
+					Outcome: audit.Failure, Subject: claims.Subject,
+					Action: r.Method + " " + r.URL.Path,
+					Reason: "scope " + scope + " not granted"})
+				w.Header().Set("WWW-Authenticate",
+					`Bearer error="insufficient_scope", scope="`+
+						strings.Join(scopes, " ")+`"`)
+				problem.Error(w, http.StatusForbidden, "",
+					"scope "+scope+" not granted")
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(jwt.NewContext(r.Context(), claims)))
+	})
+}
+
+// auditAuthn writes the authentication failure of the request to the audit
+// log
+func auditAuthn(r *http.Request, reason string) {
+	audit.Log(r.Context(), audit.Event{Type: audit.AuthnFailure,
+		Outcome: audit.Failure, Subject: clientIdentity(r),
+		Action: r.Method + " " + r.URL.Path, Reason: reason})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/compress"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+const defaultCompressionMinSize = 1024
+
+var compressedMessages = metrics.NewCounterVec(
+	"nf_compressed_messages_total",
+	"Compressed request bodies decoded and responses compressed, by "+
+		"direction (request or response) and content coding.",
+	"server", "direction", "encoding")
+
+// Compression decodes the request bodies of a known Content-Encoding and,
+// when enabled, compresses the responses of the named server in the
+// encoding negotiated with Accept-Encoding
+func Compression(server string, cfg config.CompressionConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return compression(server, cfg, next)
+	}
+}
+
+// compression answers a request body of an unknown Content-Encoding with a
+// 415 problem listing the accepted ones in Accept-Encoding. The responses
+// of at least cfg.MinSize bytes are compressed, unless the handler encoded
+// them or their media type is compressed already
+func compression(server string, cfg config.CompressionConfig,
+	next http.Handler) http.Handler {
+	offers := cfg.Encodings
+	if len(offers) == 0 {
+		offers = []string{"br", "gzip"}
+	}
+	minSize := cfg.MinSize
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ce := r.Header.Get("Content-Encoding"); ce != "" &&
+			r.Body != nil && r.Body != http.NoBody {
+			body, err := compress.NewReader(r.Body, ce)
+			if err != nil {
+				w.Header().Set("Accept-Encoding",
+					strings.Join(compress.Names(), ", "))
+				problem.Error(w, http.StatusUnsupportedMediaType, "",
+					err.Error())
+				return
+			}
+			compressedMessages.WithLabelValues(server, "request",
+				strings.ToLower(ce)).Inc()
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+		if !cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := compress.Negotiate(r.Header.Get("Accept-Encoding"),
+			offers)
+		if encoding == compress.Identity || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, server: server,
+			encoding: encoding, minSize: minSize}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds the response until minSize bytes were written, then
+// compresses it when it can be. The shorter responses are written as they
+// are
+type compressWriter struct {
+	http.ResponseWriter
+	server   string
+	encoding string
+	minSize  int
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status == 0 && !w.decided {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.minSize {
+			return len(b), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// start writes the header, compressing the response when large is set and
+// it can be, then the body held
+func (w *compressWriter) start(large bool) error {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.ResponseWriter.Header()
+	if large && h.Get("Content-Type") == "" {
+		/* sniffed before compression, as the server would */
+		h.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if large && compressible(w.status, h) {
+		e, _ := compress.Lookup(w.encoding)
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if tag := h.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+			/* the compressed body differs from the one the strong ETag
+			 * identifies */
+			h.Set("ETag", "W/"+tag)
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		w.encoder = e.NewWriter(w.ResponseWriter)
+		compressedMessages.WithLabelValues(w.server, "response",
+			w.encoding).Inc()
+		_, err := w.encoder.Write(w.buf.Bytes())
+		w.buf.Reset()
+		return err
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// close writes the response held when shorter than minSize, or completes
+// the compressed body
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && w.buf.Len() == 0 {
+			/* nothing was written, let the server answer */
+			return
+		}
+		_ = w.start(false)
+		return
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+	}
+}
+
+// Unwrap returns the underlying writer to the http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressible tells whether a response of the status and header can be
+// compressed: it has a body, is not encoded yet and its media type is not
+// compressed already
+func compressible(status int, h http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent ||
+		status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "audio/"),
+		strings.HasPrefix(mt, "video/"), mt == "text/event-stream",
+		mt == "application/gzip", mt == "application/zip":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/ratelimit"
+)
+
+// Reasons of the connections rejected by the limits
+const (
+	rejectMaxPerIP         = "maxperip"
+	rejectAccept           = "accept"
+	rejectHandshakes       = "handshakes"
+	rejectHandshakeTimeout = "handshaketimeout"
+	rejectExpiredCert      = "expiredcert"
+)
+
+const (
+	defaultHandshakeTimeout = 10000
+	// acceptBackoff spaces the accepts after a temporary error, e.g. too
+	// many open files
+	acceptBackoff = 50 * time.Millisecond
+)
+
+// connLimits protects a server against the connection floods: it bounds
+// the connections open and accepted per source IP, and the rate and the
+// duration of the TLS handshakes
+type connLimits struct {
+	server        string
+	maxPerIP      int
+	accept        *ratelimit.Limiter
+	handshakes    *ratelimit.Bucket
+	timeout       time.Duration
+	rejectExpired bool
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// newConnLimits returns the limits of the named server, nil when disabled
+func newConnLimits(server string, cfg config.ConnLimitsConfig) (*connLimits,
+	error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &connLimits{server: server, maxPerIP: cfg.MaxPerIP,
+		accept: ratelimit.NewLimiter(cfg.Accept.Rate, cfg.Accept.Burst),
+		handshakes: ratelimit.NewBucket(cfg.Handshakes.Rate,
+			cfg.Handshakes.Burst),
+		timeout:       millis(cfg.HandshakeTimeout, defaultHandshakeTimeout),
+		rejectExpired: cfg.RejectExpired, conns: make(map[string]int)}, nil
+}
+
+// admit counts a new connection from the source IP and returns the reason
+// it is rejected, empty when it is accepted. The connections of the unix
+// domain sockets, without IP, are not limited per IP
+func (cl *connLimits) admit(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	if ok, _ := cl.accept.Bucket(ip).Allow(); !ok {
+		return rejectAccept
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.maxPerIP > 0 && cl.conns[ip] >= cl.maxPerIP {
+		return rejectMaxPerIP
+	}
+	cl.conns[ip]++
+	return ""
+}
+
+// release uncounts a connection from the source IP once closed
+func (cl *connLimits) release(ip string) {
+	if ip == "" {
+		return
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.conns[ip]--; cl.conns[ip] <= 0 {
+		delete(cl.conns, ip)
+	}
+}
+
+// reject closes a connection refused by the limits
+func (cl *connLimits) reject(c net.Conn, reason string) {
+	connectionsRejected.WithLabelValues(cl.server, reason).Inc()
+	/* not a warning, a flood would flood the log as well */
+	logging.Debugf("%s connection from %s rejected: %s", cl.server,
+		c.RemoteAddr(), reason)
+	_ = c.Close()
+}
+
+// listener returns the listener serving the connections of l within the
+// limits, over TLS with tlsConfig when it is not nil. Without limits, it
+// only adds the TLS layer
+func (cl *connLimits) listener(l net.Listener,
+	tlsConfig *tls.Config) net.Listener {
+	if cl == nil {
+		if tlsConfig != nil {
+			return tls.NewListener(l, tlsConfig)
+		}
+		return l
+	}
+	ll := &limitListener{Listener: l, limits: cl, tls: tlsConfig,
+		conns: make(chan net.Conn), errs: make(chan error),
+		done: make(chan struct{})}
+	go ll.run()
+	return ll
+}
+
+// limitListener accepts the connections within the limits. It runs the TLS
+// handshakes itself, concurrently, to bound their rate and duration, and
+// hands the established connections to the server
+type limitListener struct {
+	net.Listener
+	limits *connLimits
+	tls    *tls.Config
+	conns  chan net.Conn
+	errs   chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *limitListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// run accepts the connections until the listener is closed
+func (l *limitListener) run() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if ne, ok := err.(net.Error); !ok || !ne.Temporary() {
+				return
+			}
+			time.Sleep(acceptBackoff)
+			continue
+		}
+		ip := remoteIP(c)
+		if reason := l.limits.admit(ip); reason != "" {
+			l.limits.reject(c, reason)
+			continue
+		}
+		c = &limitedConn{Conn: c, release: func() { l.limits.release(ip) }}
+		if l.tls == nil {
+			l.hand(c)
+			continue
+		}
+		if ok, _ := l.limits.handshakes.Allow(); !ok {
+			l.limits.reject(c, rejectHandshakes)
+			continue
+		}
+		go l.handshake(c)
+	}
+}
+
+// hand passes the connection to the server, closing it when the listener
+// is closed first
+func (l *limitListener) hand(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		_ = c.Close()
+	}
+}
+
+// handshake runs the TLS handshake of the connection within the timeout.
+// The failures are logged and counted as the server does for its own
+// handshakes
+func (l *limitListener) handshake(c net.Conn) {
+	tc := tls.Server(c, l.tls)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		l.limits.timeout)
+	defer cancel()
+	err := tc.HandshakeContext(ctx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		connectionsRejected.WithLabelValues(l.limits.server,
+			rejectHandshakeTimeout).Inc()
+	case err != nil && l.limits.rejectExpired && certificateExpired(err):
+		/* rejected by the verification of mutual TLS */
+		connectionsRejected.WithLabelValues(l.limits.server,
+			rejectExpiredCert).Inc()
+	case err == nil && l.limits.rejectExpired:
+		certs := tc.ConnectionState().PeerCertificates
+		if now := time.Now(); len(certs) > 0 &&
+			(now.After(certs[0].NotAfter) || now.Before(certs[0].NotBefore)) {
+			connectionsRejected.WithLabelValues(l.limits.server,
+				rejectExpiredCert).Inc()
+			err = errors.New("tls: client certificate expired or not yet " +
+				"valid")
+		}
+	}
+	if err != nil {
+		_, _ = errorLogWriter{server: l.limits.server}.Write([]byte(
+			fmt.Sprintf("http: TLS handshake error from %s: %v",
+				c.RemoteAddr(), err)))
+		_ = tc.Close()
+		return
+	}
+	l.hand(tc)
+}
+
+// certificateExpired tells whether the handshake failed on a certificate
+// outside its validity period
+func certificateExpired(err error) bool {
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &invalid) && invalid.Reason == x509.Expired
+}
+
+// remoteIP returns the source IP of a TCP connection, empty otherwise
+func remoteIP(c net.Conn) string {
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// limitedConn releases its count of the source IP once closed
+type limitedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
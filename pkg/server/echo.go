@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/codec"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+const defaultEchoPath = "/echo"
+
+// echoed is the request as the echo endpoint received it
+type echoed struct {
+	Method string      `json:"method"`
+	URI    string      `json:"uri"`
+	Proto  string      `json:"proto"`
+	Host   string      `json:"host"`
+	Peer   string      `json:"peer"`
+	Header http.Header `json:"headers"`
+	// Body is the JSON value of a body in a media type with a codec, the
+	// text of the other bodies or their base64 encoding
+	Body         interface{} `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyencoding,omitempty"`
+}
+
+// echo answers any request with the request it received, for the bring-up
+// of the interfaces with new peer NF implementations: method, URI,
+// protocol, peer, headers and body, decoded from its Content-Encoding
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		problem.Write(w, problem.FromDecodeError(err))
+		return
+	}
+	e := echoed{Method: r.Method, URI: r.URL.RequestURI(), Proto: r.Proto,
+		Host: r.Host, Peer: logging.PeerNF(r), Header: r.Header}
+	if len(body) > 0 {
+		data, err := codec.ToJSON(body, r.Header.Get("Content-Type"))
+		switch {
+		case err == nil && json.Valid(data):
+			e.Body = json.RawMessage(data)
+		case utf8.Valid(body):
+			e.Body = string(body)
+		default:
+			e.Body = base64.StdEncoding.EncodeToString(body)
+			e.BodyEncoding = "base64"
+		}
+	}
+	out, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(out, '\n'))
+}
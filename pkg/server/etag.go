@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+var conditionalResponses = metrics.NewCounterVec(
+	"nf_etag_responses_total",
+	"Responses to GET carrying an ETag by result: full or not_modified.",
+	"server", "route", "result")
+
+// ETag sets the ETag and Cache-Control of the responses to GET of the
+// route pattern of the named server, and answers the conditional requests
+func ETag(server, pattern string, cfg config.CacheConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return etag(server, pattern, cfg, next)
+	}
+}
+
+// etag buffers the 200 responses to GET to set their ETag, the
+// SHA-256 of the body unless the handler set one, and their Cache-Control
+// max-age unless the handler set one. A request whose If-None-Match
+// carries the ETag is answered 304 without the body
+func etag(server, pattern string, cfg config.CacheConfig,
+	next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bw := &bufferWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		h := w.Header()
+		if bw.status != http.StatusOK {
+			w.WriteHeader(bw.status)
+			_, _ = w.Write(bw.body.Bytes())
+			return
+		}
+		tag := h.Get("ETag")
+		if tag == "" {
+			sum := sha256.Sum256(bw.body.Bytes())
+			tag = `"` + hex.EncodeToString(sum[:16]) + `"`
+			h.Set("ETag", tag)
+		}
+		if cfg.MaxAge > 0 && h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", "max-age="+strconv.Itoa(cfg.MaxAge))
+		}
+		if matches(r.Header.Get("If-None-Match"), tag) {
+			conditionalResponses.WithLabelValues(server, pattern,
+				"not_modified").Inc()
+			h.Del("Content-Length")
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		conditionalResponses.WithLabelValues(server, pattern, "full").Inc()
+		h.Set("Content-Length", strconv.Itoa(bw.body.Len()))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bw.body.Bytes())
+	})
+}
+
+// matches tells whether an If-None-Match value carries the ETag, compared
+// weakly as RFC 9110 requires
+func matches(ifNoneMatch, tag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferWriter holds the status and body of the response written, the
+// header being written to the underlying writer
+type bufferWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
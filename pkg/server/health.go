@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	// Time given to all the readiness checks of a probe
+	checkTimeout = 2 * time.Second
+)
+
+// Check reports whether a dependency of the NF is ready, returning the
+// reason when it is not
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// AddCheck adds a readiness check reported by the /readyz endpoint of the
+// servers. The TLS certificates of the servers are checked without adding
+// a check
+func (s *Service) AddCheck(name string, check Check) {
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
+// TCPCheck returns a check connecting to the host:port returned by addr,
+// or to the unix domain socket of a unix:// address
+func TCPCheck(addr func() string) Check {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		network, address := "tcp", addr()
+		if path, ok := socketPath(address); ok {
+			network, address = "unix", path
+		}
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// tlsCheck verifies that the servers have a valid certificate loaded on
+// each of their listen addresses. The ACME certificates, obtained at the
+// first handshakes, are not checked
+func (s *Service) tlsCheck(context.Context) error {
+	for _, ns := range s.servers {
+		if s.Version != 2 || ns.protocol == config.ProtocolH2C ||
+			ns.acme != nil {
+			continue
+		}
+		for _, la := range ns.addrs {
+			name := ns.name
+			if la.address != ns.server.Addr {
+				name += " on " + la.address
+			}
+			tlsConfig, ok := la.tls.Load().(*tls.Config)
+			if !ok || len(tlsConfig.Certificates) == 0 {
+				return fmt.Errorf("%s has no certificate", name)
+			}
+			cert := tlsConfig.Certificates[0]
+			leaf := cert.Leaf
+			if leaf == nil {
+				var err error
+				if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+					return fmt.Errorf("%s certificate: %v", name, err)
+				}
+			}
+			if time.Now().After(leaf.NotAfter) {
+				return fmt.Errorf("%s certificate expired on %s", name,
+					leaf.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
+
+// healthz answers the liveness probes, the NF is alive as long as it serves
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// Ready returns nil when the NF is ready: all the readiness checks pass
+// and it is not draining. The reason of the first failure is returned
+// otherwise
+func (s *Service) Ready(ctx context.Context) error {
+	results, ready := s.ready(ctx)
+	if ready {
+		return nil
+	}
+	if reason, ok := results["drain"]; ok {
+		return errors.New(reason)
+	}
+	names := make([]string, 0, len(results))
+	for name, result := range results {
+		if result != "ok" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%s: %s", names[0], results[names[0]])
+}
+
+// ready runs the readiness checks and returns their results by name
+func (s *Service) ready(ctx context.Context) (map[string]string, bool) {
+	checks := append([]namedCheck{{name: "tls", check: s.tlsCheck}},
+		s.checks...)
+	results := make(map[string]string, len(checks))
+	ready := true
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			results[c.name] = err.Error()
+			ready = false
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	if s.isDraining() {
+		results["drain"] = "draining"
+		ready = false
+	}
+	return results, ready
+}
+
+// readyz answers the readiness probes with the result of every check, 503
+// when one of them fails or the NF is draining
+func (s *Service) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	results, ready := s.ready(ctx)
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"ready":  ready,
+		"checks": results,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
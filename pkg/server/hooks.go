@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/hooks"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Transform applies the post-receive hooks of the route pattern to the
+// request bodies and its pre-send hooks to the response bodies. A hook
+// failing fails the request with a 500 problem
+func Transform(pattern string, h *hooks.Hooks) Middleware {
+	receive := h.Route(hooks.PostReceive, pattern)
+	send := h.Route(hooks.PreSend, pattern)
+	return func(next http.Handler) http.Handler {
+		if len(receive) == 0 && len(send) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			peer := logging.PeerNF(r)
+			if len(receive) > 0 {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					problem.Write(w, problem.FromDecodeError(err))
+					return
+				}
+				body, err = receive.Transform(ctx, &hooks.Message{
+					Stage: hooks.PostReceive, Method: r.Method,
+					Path: r.URL.Path, Peer: peer, Header: r.Header,
+				}, r.Header.Get("Content-Type"), body)
+				if err != nil {
+					logging.FromContext(ctx).Errorf("%v", err)
+					problem.Error(w, http.StatusInternalServerError,
+						problem.CauseSystemFailure, err.Error())
+					return
+				}
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+				if r.Header.Get("Content-Length") != "" {
+					r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+				}
+			}
+			if len(send) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			bw := &bufferWriter{ResponseWriter: w}
+			next.ServeHTTP(bw, r)
+			if bw.status == 0 {
+				bw.status = http.StatusOK
+			}
+			h := w.Header()
+			body, err := send.Transform(ctx, &hooks.Message{
+				Stage: hooks.PreSend, Method: r.Method, Path: r.URL.Path,
+				Peer: peer, Status: bw.status, Header: h,
+			}, h.Get("Content-Type"), bw.body.Bytes())
+			if err != nil {
+				logging.FromContext(ctx).Errorf("%v", err)
+				h.Del("Content-Length")
+				problem.Error(w, http.StatusInternalServerError,
+					problem.CauseSystemFailure, err.Error())
+				return
+			}
+			if h.Get("Content-Length") != "" {
+				h.Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			w.WriteHeader(bw.status)
+			_, _ = w.Write(body)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+)
+
+// IdempotencyKeyHeader carries the key of a request the client may retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Default idempotency settings
+const (
+	defaultIdempotencyWindow  = 300000
+	defaultIdempotencyMaxBody = 64 << 10
+	// maxIdempotencyKey bounds the length of the keys
+	maxIdempotencyKey = 255
+	// idempotencyCollection of the store holding the responses
+	idempotencyCollection = "idempotency"
+)
+
+var idempotentRequests = metrics.NewCounterVec("nf_idempotent_requests_total",
+	"Requests with an Idempotency-Key by result: handled, replayed, "+
+		"conflict or mismatch.", "server", "route", "result")
+
+// idempotentResponse is the stored response to the request of a key
+type idempotentResponse struct {
+	// Fingerprint is the SHA-256 of the request body, which the retries
+	// must send again
+	Fingerprint string `json:"fingerprint"`
+	// Done is false while the first request is handled
+	Done   bool        `json:"done"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// idempotency answers the retries of the requests carrying an
+// Idempotency-Key with the response to the first one, kept in the store
+// during the window, so that they do not repeat the NF interactions. The
+// keys are scoped to the client, the method and the path. A retry while
+// the first request is handled gets 409, a retry with another body 422.
+// The 5xx responses are not kept, their retries are handled again
+func idempotency(server, pattern string, st store.Store,
+	cfg config.IdempotencyConfig, next http.Handler) http.Handler {
+	window := millis(cfg.Window, defaultIdempotencyWindow)
+	maxBody := cfg.MaxBodySize
+	if maxBody <= 0 {
+		maxBody = defaultIdempotencyMaxBody
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		l := logging.FromContext(ctx)
+		if len(key) > maxIdempotencyKey {
+			problem.Error(w, http.StatusBadRequest,
+				problem.CauseMandatoryIEIncorrect,
+				"Idempotency-Key longer than 255 characters")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			problem.Write(w, problem.FromDecodeError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+		id := idempotencyID(r, key)
+
+		pending, _ := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint})
+		created, err := st.Create(ctx, idempotencyCollection, id, pending,
+			window)
+		if err != nil {
+			l.Warnf("Idempotency-Key %q not checked: %v", key, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !created {
+			var prev idempotentResponse
+			err := store.GetJSON(ctx, st, idempotencyCollection, id, &prev)
+			if errors.Is(err, store.ErrNotFound) {
+				/* expired meanwhile, handled without being kept */
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				l.Warnf("Idempotency-Key %q not checked: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			switch {
+			case prev.Fingerprint != fingerprint:
+				idempotentRequests.WithLabelValues(server, pattern,
+					"mismatch").Inc()
+				problem.Error(w, http.StatusUnprocessableEntity,
+					problem.CauseMandatoryIEIncorrect,
+					"Idempotency-Key already used by another request")
+			case !prev.Done:
+				idempotentRequests.WithLabelValues(server, pattern,
+					"conflict").Inc()
+				w.Header().Set("Retry-After", "1")
+				problem.Error(w, http.StatusConflict, "",
+					"request with this Idempotency-Key in progress")
+			default:
+				idempotentRequests.WithLabelValues(server, pattern,
+					"replayed").Inc()
+				l.Infof("Replaying the response to Idempotency-Key %q", key)
+				replay(w, &prev)
+			}
+			return
+		}
+
+		/* the response is kept even when the client went away */
+		keepCtx := context.WithoutCancel(ctx)
+		release := func() {
+			if _, err := st.Delete(keepCtx, idempotencyCollection,
+				id); err != nil {
+				l.Warnf("Idempotency-Key %q not released: %v", key, err)
+			}
+		}
+		iw := &idempotencyWriter{StatusWriter: logging.StatusWriter{
+			ResponseWriter: w}, max: maxBody}
+		completed := false
+		defer func() {
+			if !completed {
+				/* a panic of the handler lets the retries through */
+				release()
+			}
+		}()
+		next.ServeHTTP(iw, r)
+		completed = true
+		idempotentRequests.WithLabelValues(server, pattern, "handled").Inc()
+		ctx = keepCtx
+		if iw.Status == 0 {
+			iw.Status = http.StatusOK
+		}
+		if iw.Status >= 500 || iw.overflow {
+			release()
+			return
+		}
+		err = store.PutJSON(ctx, st, idempotencyCollection, id,
+			idempotentResponse{Fingerprint: fingerprint, Done: true,
+				Status: iw.Status, Header: iw.header, Body: iw.body.Bytes()},
+			window)
+		if err != nil {
+			l.Warnf("Response to Idempotency-Key %q not kept: %v", key, err)
+		}
+	})
+}
+
+// idempotencyID returns the store key of the Idempotency-Key of the request
+func idempotencyID(r *http.Request, key string) string {
+	sum := sha256.Sum256([]byte(clientIdentity(r) + "\n" + r.Method + "\n" +
+		r.URL.Path + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// replay writes the stored response, keeping the request ID of the retry
+func replay(w http.ResponseWriter, resp *idempotentResponse) {
+	for name, values := range resp.Header {
+		if name == http.CanonicalHeaderKey(requestid.Header) {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// idempotencyWriter keeps the header and up to max bytes of the body of
+// the response written
+type idempotencyWriter struct {
+	logging.StatusWriter
+	max      int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *idempotencyWriter) WriteHeader(code int) {
+	if w.header == nil {
+		w.header = w.Header().Clone()
+	}
+	w.StatusWriter.WriteHeader(code)
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	if w.header == nil {
+		w.header = w.Header().Clone()
+	}
+	n, err := w.StatusWriter.Write(b)
+	if w.body.Len()+n > w.max {
+		w.overflow = true
+	} else {
+		w.body.Write(b[:n])
+	}
+	return n, err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
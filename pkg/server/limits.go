@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+const (
+	defaultMaxBodySize    = 1 << 20
+	defaultMaxHeaderBytes = 1 << 20
+)
+
+// BodyLimit bounds the request bodies of the route pattern of the named
+// server to limit bytes
+func BodyLimit(server, pattern string, limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return bodyLimit(server, pattern, limit, next)
+	}
+}
+
+// bodyLimit answers the requests whose Content-Length is over the limit
+// with a 413 problem, and makes reading past the limit fail with an
+// *http.MaxBytesError, which the handlers answer 413 as well
+func bodyLimit(server, pattern string, limit int64,
+	next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			oversized.WithLabelValues(server, pattern).Inc()
+			logging.FromContext(r.Context()).Warnf(
+				"Request of %s with a %d bytes body over the %d bytes limit",
+				clientIdentity(r), r.ContentLength, limit)
+			/* the rest of the body is not read */
+			w.Header().Set("Connection", "close")
+			problem.Write(w, problem.New(http.StatusRequestEntityTooLarge,
+				"", fmt.Sprintf("body larger than %d bytes", limit)))
+			return
+		}
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body,
+			limit), server: server, pattern: pattern, r: r}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitedBody counts and logs the request body read past its limit
+type limitedBody struct {
+	io.ReadCloser
+	server  string
+	pattern string
+	r       *http.Request
+	once    sync.Once
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if err != nil && errors.As(err, &tooLarge) {
+		b.once.Do(func() {
+			oversized.WithLabelValues(b.server, b.pattern).Inc()
+			logging.FromContext(b.r.Context()).Warnf(
+				"Request of %s with a body over the %d bytes limit",
+				clientIdentity(b.r), tooLarge.Limit)
+		})
+	}
+	return n, err
+}
+
+// routeBodyLimit returns the body size limit of the route pattern, 0 when
+// it is not limited
+func routeBodyLimit(cfg config.LimitsConfig, pattern string) int64 {
+	limit, ok := cfg.Routes[pattern]
+	if !ok {
+		limit = cfg.MaxBodySize
+	}
+	switch {
+	case limit == 0:
+		return defaultMaxBodySize
+	case limit < 0:
+		return 0
+	}
+	return limit
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixScheme prefixes the server endpoints that are unix domain sockets,
+// e.g. unix:///run/nf/api.sock
+const unixScheme = "unix://"
+
+// socketPath returns the path of a unix domain socket endpoint, false for
+// a TCP host:port
+func socketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixScheme), true
+}
+
+// parseSocketMode parses the octal permissions of a socket, e.g. "0660".
+// 0 keeps the permissions given by the umask
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("socket mode %q is not an octal permission",
+			mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// listen opens a listener of the server on the address, on TCP or on a
+// unix domain socket. A socket file left by a previous run is removed first
+func (ns *namedServer) listen(addr string) (net.Listener, error) {
+	path, ok := socketPath(addr)
+	if !ok {
+		if addr == "" {
+			addr = ":http"
+		}
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(path); err == nil &&
+		info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if ns.socketMode != 0 {
+		if err := os.Chmod(path, ns.socketMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
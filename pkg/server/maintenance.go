@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+const (
+	defaultMaintenanceRetryAfter = 60
+	defaultMaintenanceMessage    = "route under maintenance"
+)
+
+var maintenanceRejected = metrics.NewCounterVec(
+	"nf_http_maintenance_rejected_total",
+	"Requests answered with 503 by the routes under maintenance.",
+	"server", "route")
+
+// maintenance answers the requests of the routes out of service with 503.
+// The admin API switches it at runtime, a reload only when the
+// configuration changed, so that the reload of the certificates keeps the
+// switch of the operator
+type maintenance struct {
+	mu  sync.RWMutex
+	cfg config.MaintenanceConfig
+	// loaded is the configuration of the last load
+	loaded config.MaintenanceConfig
+}
+
+// set switches the maintenance to the configuration
+func (m *maintenance) set(cfg config.MaintenanceConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	was := m.cfg.Enabled
+	m.cfg = cfg
+	m.mu.Unlock()
+	switch {
+	case cfg.Enabled:
+		logging.Warnf("Maintenance mode on: servers %v, routes %v",
+			cfg.Servers, cfg.Routes)
+	case was:
+		logging.Infof("Maintenance mode off")
+	}
+	return nil
+}
+
+// load applies the configuration loaded when it differs from the previous
+// one
+func (m *maintenance) load(cfg config.MaintenanceConfig) error {
+	m.mu.RLock()
+	same := reflect.DeepEqual(cfg, m.loaded)
+	m.mu.RUnlock()
+	if same {
+		return nil
+	}
+	if err := m.set(cfg); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.loaded = cfg
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *maintenance) config() config.MaintenanceConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cfg
+}
+
+// covers tells whether the route of the named server is out of service
+func covers(cfg config.MaintenanceConfig, server, route string) bool {
+	if !cfg.Enabled || route == "" {
+		return false
+	}
+	return (len(cfg.Servers) == 0 || contains(cfg.Servers, server)) &&
+		(len(cfg.Routes) == 0 || contains(cfg.Routes, route))
+}
+
+// middleware answers the requests of the named server to the routes out of
+// service with 503 problem details and a Retry-After header. The raw
+// routes, e.g. the health and readiness probes, keep being served
+func (m *maintenance) middleware(server string, router *Router) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := m.config()
+			if !cfg.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+			route := router.route(r)
+			if router.raw[route] || !covers(cfg, server, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			maintenanceRejected.WithLabelValues(server, route).Inc()
+			retryAfter, message := cfg.RetryAfter, cfg.Message
+			if retryAfter == 0 {
+				retryAfter = defaultMaintenanceRetryAfter
+			}
+			if message == "" {
+				message = defaultMaintenanceMessage
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			problem.Error(w, http.StatusServiceUnavailable,
+				problem.CauseNFServiceUnavailable, message)
+		})
+	}
+}
+
+// handler serves the admin view of the maintenance: GET returns it, PUT
+// replaces it and DELETE turns it off
+func (m *maintenance) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var cfg config.MaintenanceConfig
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				problem.Write(w, problem.FromDecodeError(err))
+				return
+			}
+			if err := m.set(cfg); err != nil {
+				problem.Error(w, http.StatusBadRequest,
+					problem.CauseMandatoryIEIncorrect, err.Error())
+				return
+			}
+		case http.MethodDelete:
+			cfg := m.config()
+			cfg.Enabled = false
+			_ = m.set(cfg)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			problem.Error(w, http.StatusMethodNotAllowed, "",
+				r.Method+" not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m.config())
+	})
+}
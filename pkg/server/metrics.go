@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/audit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const defaultMetricsPath = "/metrics"
+
+var (
+	requestsTotal = metrics.NewCounterVec("nf_http_requests_total",
+		"Requests handled by the NF servers.",
+		"server", "route", "method", "code", "peer")
+	requestDuration = metrics.NewHistogramVec(
+		"nf_http_request_duration_seconds",
+		"Time taken to handle the requests.", nil,
+		"server", "route", "method")
+	requestsInFlight = metrics.NewGaugeVec("nf_http_requests_in_flight",
+		"Requests currently handled by the NF servers.", "server")
+	http2Streams = metrics.NewGaugeVec("nf_http2_active_streams",
+		"HTTP/2 streams currently open on the NF servers.", "server")
+	http2StreamsTotal = metrics.NewCounterVec("nf_http2_streams_total",
+		"HTTP/2 streams opened on the NF servers.", "server")
+	tlsHandshakeFailures = metrics.NewCounterVec(
+		"nf_tls_handshake_failures_total",
+		"TLS handshakes that failed on the NF servers.", "server")
+	connectionsRejected = metrics.NewCounterVec(
+		"nf_connections_rejected_total",
+		"Connections closed by the connection limits of the NF servers by "+
+			"reason: maxperip, accept, handshakes, handshaketimeout or "+
+			"expiredcert.", "server", "reason")
+	rateLimited = metrics.NewCounterVec("nf_http_rate_limited_total",
+		"Requests rejected by the rate limits of the NF servers.",
+		"server", "route", "limit")
+	oversized = metrics.NewCounterVec("nf_http_oversized_requests_total",
+		"Requests rejected by the NF servers for a body over the limit.",
+		"server", "route")
+	admissionQueue = metrics.NewGaugeVec("nf_http_admission_queue_depth",
+		"Requests waiting for admission on the NF servers.", "server")
+	requestsShed = metrics.NewCounterVec("nf_http_requests_shed_total",
+		"Requests shed by the NF servers when saturated.",
+		"server", "reason")
+	overloadReduction = metrics.NewGaugeVec("nf_http_overload_reduction",
+		"Reduction in percent the NF servers ask their peers for in the "+
+			"overload control information.", "server")
+	aclDecisions = metrics.NewCounterVec("nf_http_acl_decisions_total",
+		"Access control decisions of the NF servers by action: allow or "+
+			"deny.", "server", "route", "action")
+	signatureChecks = metrics.NewCounterVec("nf_http_signature_checks_total",
+		"Signature checks of the requests to the NF servers by result: "+
+			"valid, missing or invalid.", "server", "route", "result")
+	panics = metrics.NewCounterVec("nf_http_panics_total",
+		"Panics recovered in the handlers of the NF servers.",
+		"server", "route")
+)
+
+// instrument records the request metrics of the named server
+func instrument(name string, router *Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inFlight := requestsInFlight.WithLabelValues(name)
+		inFlight.Inc()
+		defer inFlight.Dec()
+		if r.ProtoMajor == 2 {
+			http2StreamsTotal.WithLabelValues(name).Inc()
+			streams := http2Streams.WithLabelValues(name)
+			streams.Inc()
+			defer streams.Dec()
+		}
+
+		sw := &logging.StatusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.Status == 0 {
+			sw.Status = http.StatusOK
+		}
+
+		route := router.route(r)
+		requestsTotal.WithLabelValues(name, route, r.Method,
+			strconv.Itoa(sw.Status), peerLabel(r)).Inc()
+		requestDuration.WithLabelValues(name, route, r.Method).Observe(
+			time.Since(start).Seconds())
+	})
+}
+
+// peerLabel identifies the peer NF without using its address, which would
+// create a series per connection
+func peerLabel(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if ua := r.UserAgent(); ua != "" {
+		return ua
+	}
+	return "unknown"
+}
+
+// errorLogWriter receives the http.Server error log, counting the TLS
+// handshake failures and forwarding the lines to the NF logger
+type errorLogWriter struct {
+	server string
+}
+
+func (w errorLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	if strings.Contains(line, "TLS handshake error") {
+		tlsHandshakeFailures.WithLabelValues(w.server).Inc()
+		auditHandshake(w.server, line)
+	}
+	logging.Warnf("%s server: %s", w.server, line)
+	return len(p), nil
+}
+
+// auditHandshake writes the TLS handshakes failing on the client
+// certificate to the audit log, from the error line of the server, e.g.
+// "http: TLS handshake error from 10.0.0.1:4242: tls: bad certificate"
+func auditHandshake(server, line string) {
+	_, from, _ := strings.Cut(line, " from ")
+	addr, reason, _ := strings.Cut(from, ": ")
+	if !strings.Contains(reason, "certificate") {
+		/* e.g. the probes closing the connection */
+		return
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	audit.Log(context.Background(), audit.Event{Type: audit.AuthnFailure,
+		Outcome: audit.Failure, Subject: addr, Action: "TLS handshake",
+		Reason: reason, Fields: map[string]interface{}{"server": server}})
+}
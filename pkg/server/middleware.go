@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/deadline"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/jwt"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/openapi"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/priority"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tracing"
+)
+
+// Middleware adds a cross-cutting behavior to a handler, such as logging or
+// the access token validation
+type Middleware func(http.Handler) http.Handler
+
+// Chain is a list of middleware, the first one seeing the requests first
+type Chain []Middleware
+
+// Append returns the chain followed by the middleware, leaving c unchanged
+func (c Chain) Append(mw ...Middleware) Chain {
+	return append(c[:len(c):len(c)], mw...)
+}
+
+// Then returns the handler wrapped by the middleware of the chain. Nil
+// middleware are skipped
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i] != nil {
+			h = c[i](h)
+		}
+	}
+	return h
+}
+
+// RequestID attaches the X-Request-ID and 3gpp-Sbi-Correlation-Info of the
+// request, or a new request ID, to its context. The client sends them on
+// with the requests made on behalf of the request
+func RequestID() Middleware {
+	return requestid.Middleware
+}
+
+// Priority attaches the 3gpp-Sbi-Message-Priority of the request to its
+// context, ordering the admission queue. The client sends it on with the
+// requests made on behalf of the request
+func Priority() Middleware {
+	return priority.Middleware
+}
+
+// MaxResponseTime shortens the deadline of the requests to the time their
+// client waits for the response, their 3gpp-Sbi-Max-Rsp-Time. The client
+// sends the time left on with the requests made on behalf of the request
+func MaxResponseTime() Middleware {
+	return deadline.Middleware
+}
+
+// Logging attaches the logger of the request to its context and logs the
+// completed requests
+func Logging() Middleware {
+	return logging.Middleware
+}
+
+// Capture logs the requests and responses when the capture is on
+func Capture() Middleware {
+	return logging.Capture
+}
+
+// Tracing records a span per request, named after the route it matches
+func Tracing(route func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return tracing.Middleware(route, next)
+	}
+}
+
+// Metrics records the request metrics of the named server, labeled with
+// the routes of the router
+func Metrics(server string, router *Router) Middleware {
+	return func(next http.Handler) http.Handler {
+		return instrument(server, router, next)
+	}
+}
+
+// Authenticate requires a Bearer access token granting the scopes
+func Authenticate(v *jwt.Validator, scopes ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return authenticate(v, scopes, next)
+	}
+}
+
+// RateLimit rejects the requests over the global or per client limits of
+// the route pattern of the named server
+func RateLimit(server, pattern string, cfg config.RouteRateLimit) Middleware {
+	return func(next http.Handler) http.Handler {
+		return rateLimit(server, pattern, cfg, next)
+	}
+}
+
+// Idempotency replays the responses to the retried requests of the route
+// pattern of the named server carrying an Idempotency-Key, kept in the
+// store
+func Idempotency(server, pattern string, st store.Store,
+	cfg config.IdempotencyConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return idempotency(server, pattern, st, cfg, next)
+	}
+}
+
+// Deadline gives the requests a deadline
+func Deadline(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return withDeadline(d, next)
+	}
+}
+
+// Validate checks the request bodies against the specification, the paths
+// being looked up without the prefix
+func Validate(spec *openapi.Spec, prefix string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return spec.Middleware(prefix, next)
+	}
+}
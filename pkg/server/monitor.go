@@ -0,0 +1,358 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/ratelimit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/requestid"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/websocket"
+)
+
+// Default monitor settings
+const (
+	defaultMonitorPath   = "/admin/monitor"
+	defaultMonitorRate   = 50
+	defaultMonitorBurst  = 100
+	defaultMonitorBuffer = 256
+	// monitorPingInterval keeps the idle connections open through the
+	// proxies and detects the dead peers
+	monitorPingInterval = 30 * time.Second
+	// monitorWriteTimeout bounds the write of a message to a connection
+	monitorWriteTimeout = 10 * time.Second
+	// monitorMaxMessage bounds the messages read from the dashboards,
+	// which have nothing to send
+	monitorMaxMessage = 4096
+)
+
+var (
+	monitorConnections = metrics.NewGaugeVec("nf_monitor_connections",
+		"WebSocket monitor connections open.")
+	monitorDropped = metrics.NewCounterVec("nf_monitor_dropped_total",
+		"Messages not sent to the monitor connections by reason: rate or "+
+			"buffer.", "reason")
+)
+
+// Message types of the monitor besides the NF events
+const (
+	// MonitorRequest summarizes a request handled by an NF server
+	MonitorRequest = "request"
+	// MonitorDropped counts the messages a connection missed since the
+	// previous message
+	MonitorDropped = "dropped"
+)
+
+// MonitorMessage is a message sent to the monitor connections
+type MonitorMessage struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// RequestSummary is the data of the request messages of the monitor
+type RequestSummary struct {
+	Server        string  `json:"server"`
+	Method        string  `json:"method"`
+	Path          string  `json:"path"`
+	Route         string  `json:"route,omitempty"`
+	Status        int     `json:"status"`
+	Peer          string  `json:"peer"`
+	RequestID     string  `json:"requestid,omitempty"`
+	CorrelationID string  `json:"correlationid,omitempty"`
+	LatencyMs     float64 `json:"latency_ms"`
+}
+
+// monitor mirrors the NF traffic to the WebSocket connections of the
+// monitoring dashboards. Each connection gets the messages at a bounded
+// rate, the messages above it or above its buffer are dropped
+type monitor struct {
+	token   string
+	origins []string
+	rate    float64
+	burst   int
+	buffer  int
+	max     int
+
+	mu     sync.Mutex
+	conns  map[*monitorConn]struct{}
+	closed chan struct{}
+}
+
+type monitorConn struct {
+	ws     *websocket.Conn
+	bucket *ratelimit.Bucket
+	out    chan []byte
+	// dropped counts the messages missed since the last one sent, guarded
+	// by the monitor lock
+	dropped int
+}
+
+func newMonitor(cfg config.MonitorConfig, token string) *monitor {
+	m := &monitor{token: token, origins: cfg.Origins, rate: cfg.Rate,
+		burst: cfg.Burst, buffer: cfg.BufferSize, max: cfg.MaxConnections,
+		conns: make(map[*monitorConn]struct{}), closed: make(chan struct{})}
+	if m.rate <= 0 {
+		m.rate = defaultMonitorRate
+	}
+	if m.burst <= 0 {
+		m.burst = defaultMonitorBurst
+	}
+	if m.buffer <= 0 {
+		m.buffer = defaultMonitorBuffer
+	}
+	return m
+}
+
+// active tells whether a dashboard is connected
+func (m *monitor) active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns) > 0
+}
+
+// publish sends the message to the connections, encoded once
+func (m *monitor) publish(msgType string, data interface{}) {
+	if !m.active() {
+		return
+	}
+	now := time.Now()
+	msg, err := json.Marshal(MonitorMessage{Type: msgType, Time: now,
+		Data: data})
+	if err != nil {
+		logging.Warnf("Monitor message %s not sent: %v", msgType, err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for c := range m.conns {
+		if ok, _ := c.bucket.Allow(); !ok {
+			c.dropped++
+			monitorDropped.WithLabelValues("rate").Inc()
+			continue
+		}
+		if c.dropped > 0 && len(c.out) < cap(c.out)-1 {
+			notice, _ := json.Marshal(MonitorMessage{Type: MonitorDropped,
+				Time: now, Data: map[string]int{"count": c.dropped}})
+			c.out <- notice
+			c.dropped = 0
+		}
+		select {
+		case c.out <- msg:
+		default:
+			c.dropped++
+			monitorDropped.WithLabelValues("buffer").Inc()
+		}
+	}
+}
+
+// authorized checks the monitor token of the request, from the
+// Authorization header or the access_token query parameter of the browsers
+// which cannot set headers on a WebSocket
+func (m *monitor) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); len(auth) >= 7 &&
+		strings.EqualFold(auth[:7], "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	}
+	return token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) == 1
+}
+
+// allowedOrigin checks the Origin of the browsers against the configured
+// dashboards
+func (m *monitor) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(m.origins) == 0 || origin == "" {
+		return true
+	}
+	for _, o := range m.origins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		problem.Error(w, http.StatusUnauthorized, "",
+			"missing or wrong monitor token")
+		return
+	}
+	if !m.allowedOrigin(r) {
+		problem.Error(w, http.StatusForbidden, "",
+			"origin not allowed: "+r.Header.Get("Origin"))
+		return
+	}
+	if !websocket.IsUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		problem.Error(w, http.StatusUpgradeRequired, "",
+			"WebSocket upgrade required")
+		return
+	}
+	m.mu.Lock()
+	full := m.max > 0 && len(m.conns) >= m.max
+	m.mu.Unlock()
+	if full {
+		problem.Error(w, http.StatusServiceUnavailable,
+			problem.CauseNFCongestion, "too many monitor connections")
+		return
+	}
+	select {
+	case <-m.closed:
+		problem.Error(w, http.StatusServiceUnavailable, "",
+			"monitor closed")
+		return
+	default:
+	}
+	ws, err := websocket.Upgrade(w, r, monitorMaxMessage)
+	if err != nil {
+		logging.FromContext(r.Context()).Warnf("Monitor upgrade: %v", err)
+		return
+	}
+	c := &monitorConn{ws: ws, out: make(chan []byte, m.buffer),
+		bucket: ratelimit.NewBucket(m.rate, m.burst)}
+	m.mu.Lock()
+	m.conns[c] = struct{}{}
+	m.mu.Unlock()
+	monitorConnections.WithLabelValues().Inc()
+	logging.Infof("Monitor connected from %s", ws.RemoteAddr())
+	defer func() {
+		m.mu.Lock()
+		delete(m.conns, c)
+		m.mu.Unlock()
+		monitorConnections.WithLabelValues().Dec()
+		logging.Infof("Monitor disconnected from %s", ws.RemoteAddr())
+	}()
+	m.serve(c)
+}
+
+// serve writes the messages of the connection until the peer leaves or
+// the monitor is closed
+func (m *monitor) serve(c *monitorConn) {
+	/* the reads answer the pings and notice the close of the peer */
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := c.ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(monitorPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-gone:
+			_ = c.ws.Close(websocket.CloseNormal, "")
+			return
+		case <-m.closed:
+			_ = c.ws.Close(websocket.CloseGoingAway, "NF shutting down")
+			<-gone
+			return
+		case msg := <-c.out:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+			err = c.ws.WriteText(msg)
+		case <-ping.C:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+			err = c.ws.Ping()
+		}
+		if err != nil {
+			_ = c.ws.Close(websocket.CloseGoingAway, "")
+			<-gone
+			return
+		}
+	}
+}
+
+// close disconnects the dashboards and refuses the new ones
+func (m *monitor) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.closed:
+	default:
+		close(m.closed)
+	}
+}
+
+// Publish mirrors an NF event, e.g. a callback received, to the monitor
+// connections. Nothing is sent when the monitor is disabled or no
+// dashboard is connected
+func (s *Service) Publish(event string, data interface{}) {
+	if s.monitor != nil {
+		s.monitor.publish(event, data)
+	}
+}
+
+// observe mirrors the summaries of the requests of the named server to the
+// monitor, except the probes
+func (s *Service) observe(name string, router *Router) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			m := s.monitor
+			if m == nil || !m.active() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			sw := &logging.StatusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			route := router.route(r)
+			if route == healthzPath || route == readyzPath {
+				return
+			}
+			if sw.Status == 0 {
+				sw.Status = http.StatusOK
+			}
+			summary := RequestSummary{Server: name, Method: r.Method,
+				Path: r.URL.Path, Route: route, Status: sw.Status,
+				Peer:      logging.PeerNF(r),
+				RequestID: requestid.FromContext(r.Context()),
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			req, ok := r.Context().Value(inflightKey{}).(*inflightRequest)
+			if ok {
+				summary.CorrelationID, _ = req.correlationID.Load().(string)
+			}
+			m.publish(MonitorRequest, summary)
+		})
+	}
+}
+
+// addMonitor serves the monitor on the admin router, outside of the admin
+// token check since the browsers send the token in the query
+func (s *Service) addMonitor(router *Router, cfg config.MonitorConfig,
+	adminToken string) error {
+	token := adminToken
+	if cfg.TokenFile != "" {
+		var err error
+		if token, err = readToken(cfg.TokenFile); err != nil {
+			return err
+		}
+	}
+	if token == "" {
+		return errors.New("no monitor or admin token file")
+	}
+	path := cfg.Path
+	if path == "" {
+		path = defaultMonitorPath
+	}
+	s.monitor = newMonitor(cfg, token)
+	router.handleRaw(path, s.monitor)
+	s.OnShutdown(s.monitor.close)
+	return nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/overload"
+)
+
+// Default overload control settings
+const defaultOCIValidity = 30
+
+var defaultOverloadLevels = []config.OverloadLevel{
+	{Load: 80, Reduction: 10}, {Load: 90, Reduction: 30},
+	{Load: 100, Reduction: 50}}
+
+// overloadControl measures the load of a server as the requests in
+// progress over its capacity, and attaches the OCI of the level reached to
+// the responses. Once the load falls under the levels, the responses carry
+// an OCI with no reduction until the last one sent expires, so that the
+// peers stop throttling at once
+type overloadControl struct {
+	server   string
+	capacity int64
+	levels   []config.OverloadLevel
+	validity time.Duration
+	// instanceID returns the NF instance ID the OCI is scoped to
+	instanceID func() string
+
+	inflight int64
+	// until is the expiry in Unix nanoseconds of the last OCI asking for
+	// a reduction
+	until int64
+}
+
+// newOverloadControl returns the overload control of the named server, nil
+// when it is disabled
+func newOverloadControl(server string, cfg config.Common,
+	instanceID func() string) (*overloadControl, error) {
+	oc := cfg.Overload
+	if !oc.Enabled {
+		return nil, nil
+	}
+	if err := oc.Validate(cfg.Admission); err != nil {
+		return nil, err
+	}
+	capacity := oc.Capacity
+	if capacity <= 0 {
+		capacity = cfg.Admission.MaxInFlight
+	}
+	levels := oc.Levels
+	if len(levels) == 0 {
+		levels = defaultOverloadLevels
+	}
+	/* the highest load first */
+	levels = append([]config.OverloadLevel(nil), levels...)
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].Load > levels[j].Load
+	})
+	validity := oc.Validity
+	if validity == 0 {
+		validity = defaultOCIValidity
+	}
+	return &overloadControl{server: server, capacity: int64(capacity),
+		levels: levels, validity: time.Duration(validity) * time.Second,
+		instanceID: instanceID}, nil
+}
+
+// Middleware returns the middleware of the overload control, nil when it
+// is disabled
+func (o *overloadControl) Middleware() Middleware {
+	if o == nil {
+		return nil
+	}
+	return o.middleware
+}
+
+// reduction returns the reduction of the level of the load in percent
+func (o *overloadControl) reduction(load int64) int {
+	for _, l := range o.levels {
+		if load >= int64(l.Load) {
+			return l.Reduction
+		}
+	}
+	return 0
+}
+
+// middleware counts the requests in progress and attaches the OCI of the
+// load, this request included, to the response
+func (o *overloadControl) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&o.inflight, 1)
+		defer atomic.AddInt64(&o.inflight, -1)
+		reduction := o.reduction(n * 100 / o.capacity)
+		overloadReduction.WithLabelValues(o.server).Set(float64(reduction))
+		now := time.Now()
+		if reduction > 0 {
+			atomic.StoreInt64(&o.until, now.Add(o.validity).UnixNano())
+		} else if now.UnixNano() >= atomic.LoadInt64(&o.until) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(overload.Header, overload.OCI{Timestamp: now,
+			Validity: o.validity, Reduction: reduction,
+			Scope: overload.ScopeNFInstance, ScopeID: o.instanceID()}.String())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ociInstanceID returns the NF instance ID of the service, a random one
+// for the NFs without one
+func (s *Service) ociInstanceID() string {
+	if s.InstanceID != "" {
+		return s.InstanceID
+	}
+	return s.fallbackID
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/http2"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+var pushes = metrics.NewCounterVec("nf_http2_pushes_total",
+	"Resources pushed by the NF servers by result: pushed, preload or "+
+		"failed.", "server", "route", "result")
+
+// pushHeaders are the request headers the pushed requests carry, so that
+// they are answered as the request pushing them
+var pushHeaders = []string{"Accept", "Accept-Encoding", "Authorization"}
+
+// Push sends the resources at targets with the responses to the GET
+// requests of the route: HTTP/2 clients accepting pushes get them pushed
+// before the response, the others get Link preload headers to fetch them
+// at once
+func Push(server, route string, targets []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			header := make(http.Header, len(pushHeaders))
+			for _, name := range pushHeaders {
+				if v := r.Header.Get(name); v != "" {
+					header.Set(name, v)
+				}
+			}
+			pusher, _ := w.(http.Pusher)
+			for _, target := range targets {
+				var err error = http.ErrNotSupported
+				if pusher != nil {
+					err = pusher.Push(target, &http.PushOptions{
+						Header: header})
+				}
+				switch {
+				case err == nil:
+					pushes.WithLabelValues(server, route, "pushed").Inc()
+				case errors.Is(err, http2.ErrRecursivePush):
+					/* a pushed request, its resources are pushed already */
+				case errors.Is(err, http.ErrNotSupported),
+					errors.Is(err, http2.ErrPushLimitReached):
+					w.Header().Add("Link", "<"+target+">; rel=preload")
+					pushes.WithLabelValues(server, route, "preload").Inc()
+				default:
+					logging.FromContext(r.Context()).Debugf(
+						"Push of %s failed: %v", target, err)
+					pushes.WithLabelValues(server, route, "failed").Inc()
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/ratelimit"
+)
+
+// rateLimit rejects the requests of the route over its global or per client
+// limit with 429 problem details and a Retry-After header
+func rateLimit(server, pattern string, cfg config.RouteRateLimit,
+	next http.Handler) http.Handler {
+	global := ratelimit.NewBucket(cfg.Global.Rate, cfg.Global.Burst)
+	clients := ratelimit.NewLimiter(cfg.Client.Rate, cfg.Client.Burst)
+	if global == nil && clients == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := "client"
+		ok, retry := clients.Bucket(clientIdentity(r)).Allow()
+		if ok {
+			limit = "global"
+			ok, retry = global.Allow()
+		}
+		if !ok {
+			rateLimited.WithLabelValues(server, pattern, limit).Inc()
+			logging.FromContext(r.Context()).Warnf(
+				"Request of %s over the %s rate limit", clientIdentity(r),
+				limit)
+			/* Retry-After is in whole seconds, at least 1 */
+			w.Header().Set("Retry-After", strconv.Itoa(
+				int(math.Max(1, math.Ceil(retry.Seconds())))))
+			problem.Error(w, http.StatusTooManyRequests,
+				problem.CauseNFCongestionRisk, "request rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIdentity returns the CN of the client certificate when mutual TLS
+// is used, the source IP otherwise
+func clientIdentity(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if cn := r.TLS.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// routeRateLimit returns the limits of the route pattern
+func routeRateLimit(cfg config.RateLimitConfig,
+	pattern string) config.RouteRateLimit {
+	if l, ok := cfg.Routes[pattern]; ok {
+		return l
+	}
+	return cfg.Default
+}
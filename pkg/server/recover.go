@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+)
+
+// Recover converts the panics of the handlers of the named server into 500
+// problem details. The stack trace is logged with the correlation ID of the
+// request and the panics are counted. The http.ErrAbortHandler panics,
+// which abort the response on purpose, are left to the server
+func Recover(server string, router *Router) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &logging.StatusWriter{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				route := router.route(r)
+				panics.WithLabelValues(server, route).Inc()
+				l := logging.FromContext(r.Context())
+				if id := correlationID(r.Context()); id != "" {
+					l = l.With(logging.Fields{"correlation_id": id})
+				}
+				l.Errorf("Panic in the %s handler: %v\n%s", route, p,
+					debug.Stack())
+				if sw.Status != 0 {
+					/* the response has started, it can only be cut */
+					panic(http.ErrAbortHandler)
+				}
+				problem.Error(sw, http.StatusInternalServerError,
+					problem.CauseSystemFailure, "internal error")
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// correlationID returns the correlation ID set on the request of the
+// context, empty when none was
+func correlationID(ctx context.Context) string {
+	if req, ok := ctx.Value(inflightKey{}).(*inflightRequest); ok {
+		id, _ := req.correlationID.Load().(string)
+		return id
+	}
+	return ""
+}
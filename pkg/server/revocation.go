@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/revocation"
+)
+
+// stapleInterval is the period the OCSP responses stapled are refreshed at,
+// and stapled to the default certificates of the listen addresses
+const stapleInterval = 10 * time.Second
+
+// withRevocation staples the OCSP responses kept by staple, when not nil,
+// to the certificates the TLS configuration serves by SNI, and checks the
+// revocation of the verified client certificates with the checker when not
+// nil. The default certificates are stapled by stapleOCSP. The check is
+// returned for the hard fail checks to be bound to each handshake by
+// configForClient, nil when none
+func withRevocation(tlsConfig *tls.Config, staple *revocation.OCSP,
+	checker *revocation.Checker) *revocationCheck {
+	if get := tlsConfig.GetCertificate; staple != nil && get != nil {
+		tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (
+			*tls.Certificate, error) {
+			cert, err := get(hello)
+			if err != nil {
+				return nil, err
+			}
+			return staple.Staple(cert), nil
+		}
+	}
+	if checker == nil ||
+		tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil
+	}
+	rc := &revocationCheck{verify: tlsConfig.VerifyPeerCertificate,
+		checker: checker}
+	tlsConfig.VerifyPeerCertificate = rc.verifier(context.Background())
+	return rc
+}
+
+// revocationCheck checks the revocation of the client certificates after
+// the verification of a TLS configuration
+type revocationCheck struct {
+	verify  func([][]byte, [][]*x509.Certificate) error
+	checker *revocation.Checker
+}
+
+// verifier returns the verification of the handshakes with the context
+// ctx, which bounds the OCSP queries of the hard fail checks
+func (rc *revocationCheck) verifier(ctx context.Context) func([][]byte,
+	[][]*x509.Certificate) error {
+	check := rc.checker.Verifier(ctx)
+	return func(raw [][]byte, chains [][]*x509.Certificate) error {
+		if rc.verify != nil {
+			if err := rc.verify(raw, chains); err != nil {
+				return err
+			}
+		}
+		return check(raw, chains)
+	}
+}
+
+// stapleOCSP refreshes the OCSP responses of the certificates stapled, and
+// staples them to the default certificates of the listen addresses of the
+// servers, until the context is canceled. The TLS configuration of an
+// address is replaced by a copy with the response stapled, the handshakes
+// serving the default certificate without calling GetCertificate
+func (s *Service) stapleOCSP(ctx context.Context) {
+	ticker := time.NewTicker(stapleInterval)
+	defer ticker.Stop()
+	for {
+		s.ocsp.Refresh()
+		for _, ns := range s.servers {
+			if ns.staple {
+				ns.stapleDefault()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.ocsp.Pending():
+		}
+	}
+}
+
+func (ns *namedServer) stapleDefault() {
+	for _, la := range ns.addrs {
+		tlsConfig, ok := la.tls.Load().(*tls.Config)
+		if !ok || len(tlsConfig.Certificates) == 0 {
+			continue
+		}
+		cert := ns.ocsp.Staple(&tlsConfig.Certificates[0])
+		if cert == &tlsConfig.Certificates[0] {
+			continue
+		}
+		stapled := tlsConfig.Clone()
+		stapled.Certificates = append([]tls.Certificate{*cert},
+			tlsConfig.Certificates[1:]...)
+		/* a reload stores the configuration first */
+		la.tls.CompareAndSwap(tlsConfig, stapled)
+	}
+}
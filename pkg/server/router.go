@@ -0,0 +1,220 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/stream"
+)
+
+// Router dispatches the requests received by one server. Patterns are
+// registered under the router path prefix
+type Router struct {
+	prefix string
+	mux    *http.ServeMux
+	// wrap, when set, wraps the handlers registered with Handle and
+	// HandleFunc, e.g. with the access token validation
+	wrap func(pattern string, handler http.Handler) http.Handler
+	// middleware wraps the handlers registered after Use
+	middleware Chain
+	// streams are the patterns registered with HandleStream
+	streams map[string]bool
+	// vhosts returns the routers of the route group of a virtual host,
+	// false for the other hosts. Nil without virtual hosts
+	vhosts func(host string) ([]*Router, bool)
+	// raw are the patterns registered without the router prefix
+	raw map[string]bool
+	// versions serves the routes under the API name and versions, nil for
+	// unversioned routes
+	versions *apiVersions
+}
+
+// NewRouter creates a router registering its patterns under prefix
+func NewRouter(prefix string) *Router {
+	return &Router{prefix: strings.TrimRight(prefix, "/"),
+		mux: http.NewServeMux()}
+}
+
+// Prefix returns the path prefix of the router
+func (r *Router) Prefix() string {
+	return r.prefix
+}
+
+// Use adds middleware to the routes registered from now on. They run after
+// the middleware of the server configuration and before the middleware of
+// the route
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = r.middleware.Append(mw...)
+}
+
+// Handle registers the handler for the pattern under the router prefix.
+// The middleware, applied in order, only wrap this route
+func (r *Router) Handle(pattern string, handler http.Handler,
+	mw ...Middleware) {
+	handler = r.middleware.Append(mw...).Then(handler)
+	if r.wrap != nil {
+		handler = r.wrap(pattern, handler)
+	}
+	r.mux.Handle(r.prefix+pattern, handler)
+}
+
+// HandleFunc registers the handler function for the pattern under the
+// router prefix
+func (r *Router) HandleFunc(pattern string,
+	handler func(http.ResponseWriter, *http.Request), mw ...Middleware) {
+	r.Handle(pattern, http.HandlerFunc(handler), mw...)
+}
+
+// HandleStream registers a handler streaming the request body: the body is
+// not buffered by the request validation, and reading more than limit bytes
+// from it fails with stream.ErrTooLarge. A limit of 0 or less does not
+// limit the body. The server read and write timeouts do not apply to the
+// stream, the route deadline does. The handler reads and writes the bodies
+// chunk by chunk with stream.Chunks and stream.Copy
+func (r *Router) HandleStream(pattern string, handler http.Handler,
+	limit int64, mw ...Middleware) {
+	if r.streams == nil {
+		r.streams = make(map[string]bool)
+	}
+	r.streams[pattern] = true
+	r.Handle(pattern, handler, append([]Middleware{streamBody(limit)},
+		mw...)...)
+}
+
+// HandleRPC registers the handler of a gRPC service for its path prefix,
+// e.g. /package.Service/. The gRPC paths are not under the router prefix.
+// Like HandleStream, the bodies are not validated and the server read and
+// write timeouts do not apply, so that the streaming RPCs last
+func (r *Router) HandleRPC(prefix string, handler http.Handler,
+	mw ...Middleware) {
+	if r.streams == nil {
+		r.streams = make(map[string]bool)
+	}
+	r.streams[prefix] = true
+	r.rawPattern(prefix)
+	handler = r.middleware.Append(append([]Middleware{streamBody(0)},
+		mw...)...).Then(handler)
+	if r.wrap != nil {
+		handler = r.wrap(prefix, handler)
+	}
+	r.mux.Handle(prefix, handler)
+}
+
+// streaming tells whether the pattern was registered with HandleStream
+func (r *Router) streaming(pattern string) bool {
+	return r.streams[pattern]
+}
+
+// streamBody lifts the connection deadlines, enables full duplex and makes
+// the request body fail with stream.ErrTooLarge past limit bytes
+func streamBody(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(time.Time{})
+			_ = rc.SetWriteDeadline(time.Time{})
+			/* HTTP/1.1 may answer while the request body is streamed */
+			_ = rc.EnableFullDuplex()
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{stream.LimitReader(r.Body, limit), r.Body}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handleRaw registers the handler for the pattern without the router prefix
+func (r *Router) handleRaw(pattern string, handler http.Handler) {
+	r.rawPattern(pattern)
+	r.mux.Handle(pattern, handler)
+}
+
+func (r *Router) rawPattern(pattern string) {
+	if r.raw == nil {
+		r.raw = make(map[string]bool)
+	}
+	r.raw[pattern] = true
+}
+
+// route returns the pattern matching the request, empty when none does
+func (r *Router) route(req *http.Request) string {
+	_, _, pattern := r.pick(req)
+	return pattern
+}
+
+// pick returns the router serving the request, the first router of the
+// route group of its virtual host with a route for it, the request as this
+// router serves it and the pattern matching the request, empty when none
+// does
+func (r *Router) pick(req *http.Request) (*Router, *http.Request, string) {
+	if r.vhosts != nil {
+		if routers, ok := r.vhosts(requestHost(req)); ok {
+			for _, router := range routers {
+				if rreq, pattern := router.match(req); pattern != "" {
+					return router, rreq, pattern
+				}
+			}
+			return nil, req, ""
+		}
+	}
+	rreq, pattern := r.match(req)
+	return r, rreq, pattern
+}
+
+// match returns the request without the API name and version of its path
+// and the pattern of the router matching it, empty when none does. With
+// versions, only the raw patterns match the unversioned paths, unless the
+// legacy paths are kept
+func (r *Router) match(req *http.Request) (*http.Request, string) {
+	if r.versions != nil {
+		rreq, versioned := r.versions.strip(r.prefix, req)
+		switch {
+		case rreq == nil:
+			return req, ""
+		case versioned:
+			req = rreq
+		case !r.versions.legacy:
+			if _, pattern := r.mux.Handler(req); r.raw[pattern] {
+				return req, pattern
+			}
+			return req, ""
+		}
+	}
+	_, pattern := r.mux.Handler(req)
+	return req, pattern
+}
+
+// ServeHTTP dispatches the request, answering the paths without a handler
+// with a 404 problem instead of the ServeMux plain-text page. With
+// versions, the problem lists the versions served
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	router, rreq, pattern := r.pick(req)
+	if pattern == "" {
+		p := problem.New(http.StatusNotFound,
+			problem.CauseResourceURINotFound, "no resource at "+req.URL.Path)
+		if r.versions != nil {
+			p.WithSupportedVersions(r.versions.served...)
+		}
+		problem.Write(w, p)
+		return
+	}
+	router.mux.ServeHTTP(w, rreq)
+}
+
+// splitAPIRoot splits a local API root prefix such as "://localhost/nnf/v1"
+// into its host and path parts
+func splitAPIRoot(apiRoot string) (host, path string) {
+	u, err := url.Parse("http" + apiRoot)
+	if err != nil || u.Host == "" {
+		return strings.TrimPrefix(apiRoot, "://"), ""
+	}
+	return u.Host, u.Path
+}
@@ -0,0 +1,998 @@
+// Package server contains the HTTP/HTTP2 server bootstrap shared by the NFs
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/exchanges"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/faults"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/hooks"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/jwt"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/openapi"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/record"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/revocation"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/signature"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+// Default server timeouts in milliseconds
+const (
+	defaultReadTimeout       = 30000
+	defaultReadHeaderTimeout = 10000
+	defaultWriteTimeout      = 30000
+	defaultIdleTimeout       = 120000
+)
+
+// Scheme returns the URL scheme used for the HTTP version (1 or 2)
+func Scheme(version int) (string, error) {
+	switch version {
+	case 2:
+		return "https", nil
+	case 1:
+		return "http", nil
+	}
+	return "", fmt.Errorf("wrong http version selected: %d", version)
+}
+
+// Service is an NF hosting one or more HTTP servers. New NFs are built by
+// adding servers and registering handlers, then calling Run
+type Service struct {
+	// Name of the NF, used in the logs
+	Name string
+	// HTTP version served: 1 or 2
+	Version int
+	// Config contains the TLS and per server settings
+	Config config.Common
+	// APIRoot is the local API root prefix (e.g. "://localhost/nnf/v1"). Its
+	// path is the prefix of the routes of all the servers. It must be set
+	// before adding the servers
+	APIRoot string
+	// Store keeps the responses replayed to the retried requests. An
+	// in-memory store is used when nil. It must be set before adding the
+	// servers
+	Store store.Store
+	// Faults injects faults in the requests served, none when nil. It must
+	// be set before adding the servers
+	Faults *faults.Injector
+	// Recorder records the requests served, none when nil. It must be set
+	// before adding the servers
+	Recorder *record.Recorder
+	// Exchanges keeps the history of the requests served, none when nil. It
+	// must be set before adding the servers
+	Exchanges *exchanges.Log
+	// InstanceID is the NF instance ID the Overload Control Information
+	// sent is scoped to. It must be set before running the servers
+	InstanceID string
+
+	scheme  string
+	servers []*namedServer
+	tasks   []task
+	checks  []namedCheck
+	// jwt validates the access tokens when enabled
+	jwt *jwt.Validator
+	// spec validates the request bodies when enabled
+	spec *openapi.Spec
+	// acl decides the access to the routes when enabled
+	acl *acl
+	// hooks transform the payloads of the routes, nil without hooks
+	hooks *hooks.Hooks
+	// signatures verify the signed routes when enabled
+	signatures *signature.Signer
+	// tenants recognizes the requests of the tenants, nil without any
+	tenants *tenants
+	// slos tracks the latency of the routes, nil when disabled
+	slos *slos
+	// acme obtains the server certificates when enabled
+	acme *autocert.Manager
+	// tickets rotates the TLS session ticket keys, nil when Go does
+	tickets *ticketKeys
+	// ocsp keeps the OCSP responses stapled and checked
+	ocsp *revocation.OCSP
+	// admin is the admin server, nil when disabled
+	admin    *namedServer
+	inflight inflight
+	// maintenance takes routes out of service
+	maintenance maintenance
+	// monitor mirrors the traffic to the dashboards, nil when disabled
+	monitor *monitor
+	// fallbackID scopes the OCI without InstanceID
+	fallbackID string
+
+	drainOnce sync.Once
+	draining  int32
+	drained   chan struct{}
+	// onShutdown are run before draining the servers
+	onShutdown []func()
+}
+
+type task struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+type namedServer struct {
+	name     string
+	server   *http.Server
+	router   *Router
+	protocol string
+	// plain serves HTTP/1.1 without TLS whatever the Service version
+	plain bool
+	// socketMode sets the permissions of a unix domain socket endpoint
+	socketMode os.FileMode
+	// addrs are the listen addresses, the server Addr first
+	addrs []*listenAddr
+	// connLimits protects the server against the connection floods, nil
+	// when disabled
+	connLimits *connLimits
+	// acme provides the certificates of the server instead of the files,
+	// nil when it does not use ACME
+	acme *autocert.Manager
+	// ocsp keeps the OCSP responses stapled and checked by the server
+	ocsp *revocation.OCSP
+	// staple staples the OCSP responses to the certificates served
+	staple bool
+	// tenants serve their certificates to their host names, nil without
+	// tenants
+	tenants *tenants
+	// vhosts are the virtual hosts of the server by lower case host name
+	vhosts map[string]config.VHostConfig
+	// alpn lists the protocols negotiated over TLS, both when empty
+	alpn []string
+}
+
+// listenAddr is a listen address of a server with its own TLS settings
+type listenAddr struct {
+	address string
+	// files override the certificate files of the server on this address
+	files config.TLSFiles
+	// tls holds the *tls.Config of the next handshakes, replaced on reload
+	tls atomic.Value
+	// revocation is the hard fail revocation check of the configuration
+	// held by tls, nil without
+	revocation atomic.Pointer[revocationCheck]
+}
+
+// New creates a Service serving the given HTTP version
+func New(name string, version int) (*Service, error) {
+	scheme, err := Scheme(version)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{Name: name, Version: version, scheme: scheme,
+		fallbackID: uuid.New(), drained: make(chan struct{})}, nil
+}
+
+// Scheme returns the URL scheme served by the Service
+func (s *Service) Scheme() string {
+	return s.scheme
+}
+
+// ServerScheme returns the URL scheme served by the named server endpoint,
+// which differs from the Service one for h2c endpoints
+func (s *Service) ServerScheme(name string) string {
+	if s.Config.Servers[name].Protocol == config.ProtocolH2C {
+		return "http"
+	}
+	return s.scheme
+}
+
+// AddServer adds a server listening on addr. name is only used for logging
+func (s *Service) AddServer(name, addr string) error {
+	timeouts := s.Config.Timeouts.Server
+	server := &http.Server{
+		Addr:              addr,
+		ReadTimeout:       millis(timeouts.Read, defaultReadTimeout),
+		ReadHeaderTimeout: millis(timeouts.ReadHeader, defaultReadHeaderTimeout),
+		WriteTimeout:      millis(timeouts.Write, defaultWriteTimeout),
+		IdleTimeout:       millis(timeouts.Idle, defaultIdleTimeout),
+		MaxHeaderBytes:    s.Config.Limits.MaxHeaderBytes,
+		ConnContext:       connContext,
+	}
+	if server.MaxHeaderBytes <= 0 {
+		server.MaxHeaderBytes = defaultMaxHeaderBytes
+	}
+	_, prefix := splitAPIRoot(s.APIRoot)
+	ns := &namedServer{name: name, server: server,
+		router:   NewRouter(prefix),
+		protocol: s.Config.Servers[name].Protocol}
+	mode, err := parseSocketMode(s.Config.Servers[name].SocketMode)
+	if err != nil {
+		return fmt.Errorf("failed at configuring %s server: %v", name, err)
+	}
+	ns.socketMode = mode
+	if ns.router.versions, err = newAPIVersions(s.Config.Versioning,
+		name); err != nil {
+		return fmt.Errorf("failed at configuring %s API versions: %v", name,
+			err)
+	}
+	if err := s.Config.Servers[name].Validate(); err != nil {
+		return fmt.Errorf("failed at configuring %s server: %v", name, err)
+	}
+	ns.alpn = s.Config.Servers[name].ALPN
+	if ns.connLimits, err = newConnLimits(name,
+		s.Config.ConnLimits); err != nil {
+		return fmt.Errorf("failed at configuring %s connection limits: %v",
+			name, err)
+	}
+	ns.addrs = []*listenAddr{{address: addr}}
+	for _, l := range s.Config.Servers[name].Listen {
+		if l.Address == "" {
+			return fmt.Errorf("failed at configuring %s server: empty listen address",
+				name)
+		}
+		ns.addrs = append(ns.addrs, &listenAddr{address: l.Address,
+			files: l.TLS})
+	}
+	server.ErrorLog = log.New(errorLogWriter{server: name}, "", 0)
+	if s.Config.JWT.Enabled && s.jwt == nil {
+		v, err := jwt.NewValidator(s.Config.JWT,
+			s.Config.TLS.ClientFiles().CAFile)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s token validation: %v",
+				name, err)
+		}
+		s.jwt = v
+	}
+	if s.Config.OpenAPI.Enabled && s.spec == nil {
+		spec, err := openapi.Load(s.Config.OpenAPI.Spec)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s request validation: %v",
+				name, err)
+		}
+		s.spec = spec
+	}
+	if s.Config.ACL.Enabled && s.acl == nil {
+		a, err := newACL(s.Config.ACL)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s access control: %v",
+				name, err)
+		}
+		s.acl = a
+	}
+	if s.Config.Signatures.Enabled && s.signatures == nil {
+		sig, err := signature.New(s.Config.Signatures)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s signatures: %v",
+				name, err)
+		}
+		s.signatures = sig
+	}
+	if len(s.Config.Tenants) > 0 && s.tenants == nil {
+		ts, err := newTenants(s.Config.Tenants)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s tenants: %v", name,
+				err)
+		}
+		s.tenants = ts
+	}
+	ns.tenants = s.tenants
+	if s.Config.SLO.Enabled && s.slos == nil {
+		if s.slos, err = newSLOs(s.Config.SLO); err != nil {
+			return fmt.Errorf("failed at configuring %s latency objectives: %v",
+				name, err)
+		}
+	}
+	if s.hooks == nil {
+		h, err := hooks.New(s.Config.Hooks)
+		if err != nil {
+			return fmt.Errorf("failed at configuring %s payload hooks: %v",
+				name, err)
+		}
+		s.hooks = h
+	}
+	if err := s.maintenance.load(s.Config.Maintenance); err != nil {
+		return fmt.Errorf("failed at configuring %s maintenance: %v", name,
+			err)
+	}
+	oc, err := newOverloadControl(name, s.Config, s.ociInstanceID)
+	if err != nil {
+		return fmt.Errorf("failed at configuring %s overload control: %v",
+			name, err)
+	}
+	ns.router.wrap = s.routeChain(name, ns.router)
+	s.setupVHosts(ns, s.Config.Servers[name].VHosts)
+	if s.Config.Interop.Echo {
+		path := s.Config.Interop.EchoPath
+		if path == "" {
+			path = defaultEchoPath
+		}
+		ns.router.HandleFunc(path, echo)
+	}
+	ns.router.handleRaw(healthzPath, http.HandlerFunc(healthz))
+	ns.router.handleRaw(readyzPath, http.HandlerFunc(s.readyz))
+	if s.Config.Metrics.Enabled {
+		path := s.Config.Metrics.Path
+		if path == "" {
+			path = defaultMetricsPath
+		}
+		ns.router.handleRaw(path, metrics.Handler())
+	}
+	server.Handler = Chain{
+		RequestID(),
+		Logging(),
+		alpnMetrics(name),
+		hsts(s.Config.TLS.Policy.HSTS),
+		s.tenants.middleware(name, ns.router),
+		s.maintenance.middleware(name, ns.router),
+		Priority(),
+		MaxResponseTime(),
+		oc.Middleware(),
+		Tracing(ns.router.route),
+		s.inflight.track(name),
+		s.observe(name, ns.router),
+		s.slos.middleware(name, ns.router),
+		Metrics(name, ns.router),
+		Recover(name, ns.router),
+	}.Then(ns.router)
+	h2s, err := http2Server(s.Config.HTTP2)
+	if err != nil {
+		return fmt.Errorf("failed at configuring %s HTTP/2: %v", name, err)
+	}
+	switch {
+	case ns.protocol == config.ProtocolH2C:
+		/* HTTP/2 over cleartext, the h2c handler upgrades the connections
+		 * to HTTP/2. They are hijacked from the server, whose graceful
+		 * shutdown only sends them a GOAWAY once h2s is configured on it */
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			return fmt.Errorf("failed at configuring %s h2c server: %v",
+				name, err)
+		}
+		server.Handler = h2c.NewHandler(server.Handler, h2s)
+	case s.Version == 2:
+		if acmeCfg := s.Config.TLS.ACME; acmeCfg.Serves(name) {
+			if err := s.setupACME(acmeCfg); err != nil {
+				return fmt.Errorf("failed at configuring %s ACME: %v", name,
+					err)
+			}
+			ns.acme = s.acme
+		}
+		revocationCfg := s.Config.TLS.Revocation
+		if s.ocsp == nil {
+			s.ocsp = revocation.NewOCSP(revocationCfg)
+			if revocationCfg.Staple {
+				s.AddTask("OCSP staples", s.stapleOCSP)
+			}
+		}
+		ns.ocsp, ns.staple = s.ocsp, revocationCfg.Staple
+		if err := ns.loadTLS(s.Config.TLS); err != nil {
+			return fmt.Errorf("failed at configuring %s %s server: %v",
+				name, s.scheme, err)
+		}
+		if s.tickets == nil {
+			if s.tickets = newTicketKeys(s.Config.TLS.Policy); s.tickets != nil {
+				s.AddTask("TLS session tickets", s.tickets.run)
+			}
+		}
+		/* the certificates come from the TLS configuration of each listen
+		 * address, see serveTLS */
+		server.TLSConfig = &tls.Config{
+			NextProtos: []string{http2.NextProtoTLS, "http/1.1"},
+		}
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			return fmt.Errorf("failed at configuring %s %s server: %v",
+				name, s.scheme, err)
+		}
+	}
+	s.servers = append(s.servers, ns)
+	return nil
+}
+
+// routeChain returns the wrap of the routes of the named server, which
+// applies the middleware of the configuration in this order: history,
+// compression, body limit, capture, access control, rate limit, admission,
+// route deadline, access token, idempotency, body validation, ETag and
+// payload hooks, the first three and last four except for the streaming
+// routes. The limit
+// applies to the decoded bodies, the bodies are captured decoded, the
+// rejected requests are captured as well, the clients denied and the
+// requests over the limits are rejected before any work, the deadline
+// covers the token check, and only the authenticated requests get the
+// replayed responses
+func (s *Service) routeChain(name string, router *Router) func(string,
+	http.Handler) http.Handler {
+	v, scopes, spec, acl := s.jwt, s.Config.JWT.Scopes, s.spec, s.acl
+	prefix := router.Prefix()
+	routeTimeouts := s.Config.Timeouts.Server.Routes
+	rateLimits := s.Config.RateLimit
+	admit := newAdmission(name, s.Config.Admission)
+	idempotent := s.Config.Idempotency
+	cache := s.Config.Cache
+	compression := s.Config.Compression
+	limits := s.Config.Limits
+	push := s.Config.HTTP2.Push
+	hks := s.hooks
+	sigs, signed := s.signatures, s.Config.Signatures.Routes
+	if idempotent.Enabled && s.Store == nil {
+		s.Store = store.NewMemory()
+	}
+	st := s.Store
+	return func(pattern string, h http.Handler) http.Handler {
+		var chain Chain
+		if targets := push.Routes[pattern]; push.Enabled && len(targets) > 0 {
+			chain = append(chain, Push(name, pattern, targets))
+		}
+		if s.Exchanges != nil && !router.streaming(pattern) {
+			/* the streams would be kept once closed, with their lifetime
+			   as latency */
+			chain = append(chain, s.Exchanges.Middleware(name))
+		}
+		if s.Recorder != nil {
+			/* the faults injected are recorded as the clients see them */
+			chain = append(chain, s.Recorder.Middleware(name))
+		}
+		if s.Faults != nil {
+			chain = append(chain, s.Faults.Middleware(name))
+		}
+		if !router.streaming(pattern) {
+			chain = append(chain, Compression(name, compression))
+			if limit := routeBodyLimit(limits, pattern); limit > 0 {
+				chain = append(chain, BodyLimit(name, pattern, limit))
+			}
+		}
+		chain = append(chain, Capture())
+		if acl != nil {
+			chain = append(chain, acl.middleware(name, pattern))
+		}
+		if rateLimits.Enabled {
+			chain = append(chain, RateLimit(name, pattern,
+				routeRateLimit(rateLimits, pattern)))
+		}
+		if admit != nil {
+			/* all the routes of the server share its slots */
+			chain = append(chain, admit.middleware)
+		}
+		if d := routeTimeouts[pattern]; d > 0 {
+			chain = append(chain, Deadline(time.Duration(d)*time.Millisecond))
+		}
+		if v != nil {
+			chain = append(chain, Authenticate(v, scopes[pattern]...))
+		}
+		if sigs != nil && contains(signed, pattern) &&
+			!router.streaming(pattern) {
+			chain = append(chain, VerifySignature(name, pattern, sigs))
+		}
+		if idempotent.Enabled && contains(idempotent.Routes, pattern) &&
+			!router.streaming(pattern) {
+			chain = append(chain, Idempotency(name, pattern, st, idempotent))
+		}
+		if spec != nil && !router.streaming(pattern) {
+			/* the validation would buffer the streamed bodies */
+			chain = append(chain, Validate(spec, prefix))
+		}
+		if cache.ETag && !router.streaming(pattern) &&
+			(len(cache.Routes) == 0 || contains(cache.Routes, pattern)) {
+			chain = append(chain, ETag(name, pattern, cache))
+		}
+		if hks != nil && !router.streaming(pattern) {
+			/* the handlers see the requests as transformed once validated,
+			 * the ETag is that of the responses transformed */
+			chain = append(chain, Transform(pattern, hks))
+		}
+		return chain.Then(h)
+	}
+}
+
+// http2Server returns the HTTP/2 server settings of the configuration
+func http2Server(cfg config.HTTP2Config) (*http2.Server, error) {
+	const (
+		minFrameSize = 1 << 14
+		maxFrameSize = 1<<24 - 1
+		minWindow    = 65535
+	)
+	if cfg.MaxReadFrameSize != 0 && (cfg.MaxReadFrameSize < minFrameSize ||
+		cfg.MaxReadFrameSize > maxFrameSize) {
+		return nil, fmt.Errorf("maxreadframesize %d not in [%d, %d]",
+			cfg.MaxReadFrameSize, minFrameSize, maxFrameSize)
+	}
+	if cfg.MaxUploadBufferPerConnection != 0 &&
+		cfg.MaxUploadBufferPerConnection < minWindow {
+		return nil, fmt.Errorf("maxuploadbufferperconnection %d below %d",
+			cfg.MaxUploadBufferPerConnection, minWindow)
+	}
+	if cfg.MaxUploadBufferPerStream != 0 &&
+		cfg.MaxUploadBufferPerStream < minWindow {
+		return nil, fmt.Errorf("maxuploadbufferperstream %d below %d",
+			cfg.MaxUploadBufferPerStream, minWindow)
+	}
+	for route, targets := range cfg.Push.Routes {
+		for _, target := range targets {
+			if !strings.HasPrefix(target, "/") {
+				return nil, fmt.Errorf("push.routes[%s]: %q is not an "+
+					"absolute path", route, target)
+			}
+		}
+	}
+	idle := time.Duration(cfg.IdleTimeout) * time.Millisecond
+	return &http2.Server{
+		MaxConcurrentStreams:         cfg.MaxConcurrentStreams,
+		MaxReadFrameSize:             cfg.MaxReadFrameSize,
+		IdleTimeout:                  idle,
+		MaxUploadBufferPerConnection: cfg.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     cfg.MaxUploadBufferPerStream,
+	}, nil
+}
+
+// millis returns the duration of a configured time in milliseconds, def when
+// it is not set
+func millis(ms, def int) time.Duration {
+	if ms <= 0 {
+		ms = def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// withDeadline gives the requests of the handler a deadline, after which the
+// handler stops waiting on the peers
+func withDeadline(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// loadTLS loads the certificates of the server listen addresses and stores
+// their TLS configurations, used from the next handshake on
+func (ns *namedServer) loadTLS(tlsCfg config.TLSConfig) error {
+	configs, err := ns.tlsConfigs(tlsCfg)
+	if err != nil {
+		return err
+	}
+	ns.storeTLS(configs)
+	return nil
+}
+
+// addrTLS is the TLS configuration of a listen address with its hard fail
+// revocation check, nil without
+type addrTLS struct {
+	config     *tls.Config
+	revocation *revocationCheck
+}
+
+// tlsConfigs returns the TLS configurations of the listen addresses, in
+// order
+func (ns *namedServer) tlsConfigs(tlsCfg config.TLSConfig) ([]addrTLS,
+	error) {
+	configs := make([]addrTLS, len(ns.addrs))
+	certs, err := ns.tenants.certificates()
+	if err != nil {
+		return nil, fmt.Errorf("tenants: %v", err)
+	}
+	vhostCerts, err := ns.vhostCertificates()
+	if err != nil {
+		return nil, err
+	}
+	checker, err := revocation.New(tlsCfg.Revocation, ns.ocsp)
+	if err != nil {
+		return nil, err
+	}
+	for host, cert := range vhostCerts {
+		/* the virtual host of the endpoint before the tenant */
+		if certs == nil {
+			certs = make(map[string]*tls.Certificate)
+		}
+		certs[host] = cert
+	}
+	for i, la := range ns.addrs {
+		files := tlsCfg.ServerFiles(ns.name).Override(la.files)
+		tlsConfig, err := serverTLSConfig(files, tlsCfg, ns.acme)
+		if err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("%s: %v", la.address, err)
+			}
+			return nil, err
+		}
+		withHostCertificates(tlsConfig, certs)
+		withALPN(tlsConfig, ns.alpn)
+		var staple *revocation.OCSP
+		if ns.staple {
+			staple = ns.ocsp
+		}
+		rc := withRevocation(tlsConfig, staple, checker)
+		if rc != nil && !checker.HardFail() {
+			/* the soft fail checks do not wait on the responders */
+			rc = nil
+		}
+		if ns.connLimits != nil && ns.connLimits.rejectExpired &&
+			tlsConfig.ClientAuth == tls.NoClientCert {
+			/* the validity of the certificates is checked by the
+			 * connection limits, the certificates given are verified
+			 * against the root CA to be trusted as identities */
+			pool, err := tlsutil.LoadCertPool(files.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			tlsConfig.ClientCAs = pool
+		}
+		configs[i] = addrTLS{config: tlsConfig, revocation: rc}
+	}
+	return configs, nil
+}
+
+func (ns *namedServer) storeTLS(configs []addrTLS) {
+	for i, la := range ns.addrs {
+		la.revocation.Store(configs[i].revocation)
+		la.tls.Store(configs[i].config)
+	}
+}
+
+// serverTLSConfig returns the TLS configuration of a server using the
+// files, or the certificates of the ACME manager when not nil, restricted
+// by the TLS policy. With mutual TLS the client certificate is required,
+// verified against the root CA and matched against the allowed client list
+func serverTLSConfig(files config.TLSFiles, tlsCfg config.TLSConfig,
+	m *autocert.Manager) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		NextProtos: []string{http2.NextProtoTLS, "http/1.1"},
+	}
+	tlsCfg.Policy.Apply(tlsConfig)
+	if m != nil {
+		tlsConfig.GetCertificate = m.GetCertificate
+		if tlsCfg.ACME.Challenge != config.ACMEHTTP01 {
+			/* the TLS-ALPN-01 challenges come as handshakes */
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos,
+				acme.ALPNProto)
+		}
+	} else {
+		cert, err := tlsutil.LoadKeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if tlsCfg.MutualTLS {
+		pool, err := tlsutil.LoadCertPool(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = pool
+		allowed := tlsCfg.AllowedClients
+		tlsConfig.VerifyPeerCertificate = func(_ [][]byte,
+			chains [][]*x509.Certificate) error {
+			if len(chains) == 0 || len(chains[0]) == 0 {
+				return fmt.Errorf("no verified client certificate")
+			}
+			return tlsutil.CheckAllowed(chains[0][0], allowed)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// configForClient returns the TLS configuration of the handshake, a copy
+// whose OCSP queries of the hard fail revocation checks are bounded by the
+// handshake when checked
+func (la *listenAddr) configForClient(hello *tls.ClientHelloInfo) (
+	*tls.Config, error) {
+	tlsConfig := la.tls.Load().(*tls.Config)
+	if rc := la.revocation.Load(); rc != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.VerifyPeerCertificate = rc.verifier(hello.Context())
+	}
+	return tlsConfig, nil
+}
+
+func (la *listenAddr) certificate(hello *tls.ClientHelloInfo) (
+	*tls.Certificate, error) {
+	tlsConfig := la.tls.Load().(*tls.Config)
+	if tlsConfig.GetCertificate != nil {
+		return tlsConfig.GetCertificate(hello)
+	}
+	return &tlsConfig.Certificates[0], nil
+}
+
+// setupACME creates the ACME manager shared by the servers, with the task
+// answering the HTTP-01 challenges
+func (s *Service) setupACME(cfg config.ACMEConfig) error {
+	if s.acme != nil {
+		return nil
+	}
+	m, err := newACME(cfg)
+	if err != nil {
+		return err
+	}
+	s.acme = m
+	if cfg.Challenge == config.ACMEHTTP01 {
+		s.AddTask("ACME challenges", acmeChallenges(m, cfg.HTTPAddress))
+	}
+	return nil
+}
+
+// Reload applies the settings of cfg that can change while the servers are
+// running: the TLS certificates, root CA, allowed clients, TLS policy,
+// its ticket rotation and HSTS aside, and revocation checks, the OCSP
+// stapling, cache time and timeout aside. The listen addresses, their
+// certificate file overrides and the protocols keep their startup values.
+// Nothing is applied when one of the settings fails to load
+func (s *Service) Reload(cfg config.Common) error {
+	apply, err := s.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReload loads and validates the settings of cfg, and returns the
+// function applying them all as Reload does
+func (s *Service) PrepareReload(cfg config.Common) (func(), error) {
+	configs := make(map[*namedServer][]addrTLS)
+	for _, ns := range s.servers {
+		if s.Version != 2 || ns.protocol == config.ProtocolH2C {
+			continue
+		}
+		tlsConfigs, err := ns.tlsConfigs(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("reloading %s TLS configuration: %v",
+				ns.name, err)
+		}
+		configs[ns] = tlsConfigs
+	}
+	if s.Faults != nil {
+		if err := cfg.Faults.Validate(); err != nil {
+			return nil, fmt.Errorf("reloading the faults: %v", err)
+		}
+	}
+	if err := cfg.Maintenance.Validate(); err != nil {
+		return nil, fmt.Errorf("reloading the maintenance: %v", err)
+	}
+	if s.acl != nil {
+		if err := cfg.ACL.Validate(); err != nil {
+			return nil, fmt.Errorf("reloading the access control: %v", err)
+		}
+	}
+	applySignatures := func() {}
+	if s.signatures != nil {
+		apply, err := s.signatures.Prepare(cfg.Signatures)
+		if err != nil {
+			return nil, fmt.Errorf("reloading the signatures: %v", err)
+		}
+		applySignatures = apply
+	}
+	applyRecord := func() {}
+	if s.Recorder != nil {
+		apply, err := s.Recorder.Prepare(cfg.Record)
+		if err != nil {
+			return nil, fmt.Errorf("reloading the recording: %v", err)
+		}
+		applyRecord = apply
+	}
+	return func() {
+		/* validated above, the settings below cannot fail */
+		if s.Faults != nil {
+			_ = s.Faults.Set(cfg.Faults)
+		}
+		_ = s.maintenance.load(cfg.Maintenance)
+		if s.acl != nil {
+			_ = s.acl.set(cfg.ACL)
+		}
+		applySignatures()
+		applyRecord()
+		for ns, tlsConfigs := range configs {
+			ns.storeTLS(tlsConfigs)
+		}
+		s.Config.TLS = cfg.TLS
+	}, nil
+}
+
+// Router returns the router of the named server, nil if there is no such
+// server
+func (s *Service) Router(name string) *Router {
+	if ns := s.server(name); ns != nil {
+		return ns.router
+	}
+	return nil
+}
+
+// URI returns the URI of path on the named server. It is built from the
+// local API root host, the server endpoint port, the route prefix and the
+// API name and version of the versioned routes, with an IPv6 host in
+// brackets. The URI of a unix domain socket endpoint only has the API root
+// host
+func (s *Service) URI(name, path string) string {
+	host, prefix := splitAPIRoot(s.APIRoot)
+	return s.uri(host, name, prefix+s.versionPath(name)+path)
+}
+
+// versionPath returns the API name and current version of the routes of
+// the named server, empty when they are unversioned
+func (s *Service) versionPath(name string) string {
+	if ns := s.server(name); ns != nil {
+		return ns.router.versions.path()
+	}
+	return ""
+}
+
+// uri returns the URI of path, prefix included, on the host and the port
+// of the named server
+func (s *Service) uri(host, name, path string) string {
+	addr := ""
+	if ns := s.server(name); ns != nil {
+		addr = ns.server.Addr
+	}
+	if _, ok := socketPath(addr); !ok {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			host = net.JoinHostPort(hostname(host), port)
+		}
+	}
+	/* the peers map the API root host to the socket of unix endpoints */
+	return s.ServerScheme(name) + "://" + host + path
+}
+
+// hostname returns the host of a host[:port], without the brackets of an
+// IPv6 address
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+func (s *Service) server(name string) *namedServer {
+	for _, ns := range s.servers {
+		if ns.name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+// AddTask adds a background task started by Run along with the servers. The
+// task context is canceled once the servers are stopped and Run waits for the
+// task to return
+func (s *Service) AddTask(name string, run func(ctx context.Context)) {
+	s.tasks = append(s.tasks, task{name: name, run: run})
+}
+
+// OnShutdown adds a function run when the NF is drained, before the servers
+// wait for the requests in progress, e.g. to end the long lived streams
+func (s *Service) OnShutdown(f func()) {
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// Run starts all the servers and blocks until the context is canceled, the
+// NF is drained or one of the servers stops
+func (s *Service) Run(ctx context.Context) error {
+	logging.Infof("Starting %s servers", s.Name)
+	if err := s.checkVHosts(); err != nil {
+		return err
+	}
+	servers := s.servers
+	if s.admin != nil {
+		servers = append(servers[:len(servers):len(servers)], s.admin)
+	}
+	listeners := 0
+	for _, ns := range servers {
+		listeners += len(ns.addrs)
+	}
+	stopServerCh := make(chan error, listeners)
+
+	taskCtx, stopTasks := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, t := range s.tasks {
+		wg.Add(1)
+		go func(t task) {
+			defer wg.Done()
+			t.run(taskCtx)
+			logging.Infof("%s task stopped", t.name)
+		}(t)
+	}
+
+	/* Go Routine is spawned here for each listen address of the HTTP
+	 * Servers */
+	for _, ns := range servers {
+		for _, la := range ns.addrs {
+			go s.startHTTPServer(ns, la, stopServerCh)
+		}
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case <-s.drained:
+		s.shutdown(millis(s.Config.Admin.DrainTimeout, defaultDrainTimeout))
+	case err = <-stopServerCh:
+	}
+
+	s.close(servers)
+	stopTasks()
+	wg.Wait()
+	logging.Infof("Exiting %s servers", s.Name)
+	return err
+}
+
+// serverScheme returns the URL scheme served by the server
+func (s *Service) serverScheme(ns *namedServer) string {
+	if ns.plain {
+		return "http"
+	}
+	return s.ServerScheme(ns.name)
+}
+
+/* starting HTTP Server */
+func (s *Service) startHTTPServer(ns *namedServer, la *listenAddr,
+	stopServerCh chan error) {
+	scheme := s.serverScheme(ns)
+	logging.Infof("%s %s listening on %s", ns.name, scheme, la.address)
+
+	l, err := ns.listen(la.address)
+	if err == nil {
+		switch {
+		case ns.plain, ns.protocol == config.ProtocolH2C, s.Version == 1:
+			err = ns.server.Serve(ns.connLimits.listener(l, nil))
+		case s.Version == 2:
+			/* the certificates come from the TLS configuration of the
+			 * listen address */
+			tlsConfig := ns.server.TLSConfig.Clone()
+			tlsConfig.GetCertificate = la.certificate
+			tlsConfig.GetConfigForClient = la.configForClient
+			/* the configurations of the listen address use its keys */
+			s.tickets.use(tlsConfig)
+			err = ns.server.Serve(ns.connLimits.listener(l, tlsConfig))
+		}
+	}
+	if err != http.ErrServerClosed {
+		logging.Errorf("%s %s server error: %v", ns.name, scheme, err)
+		stopServerCh <- err
+	}
+}
+
+/* graceful stop of all the HTTP Servers */
+func (s *Service) close(servers []*namedServer) {
+	for _, ns := range servers {
+		logging.Infof("Executing graceful stop for %s %s Server", ns.name,
+			s.serverScheme(ns))
+		if err := ns.server.Close(); err != nil {
+			logging.Errorf("Could not close %s %s server: %#v", ns.name,
+				s.serverScheme(ns), err)
+		}
+		logging.Infof("%s %s server stopped", ns.name, s.serverScheme(ns))
+	}
+}
+
+// SignalContext returns a context that is canceled when the process receives
+// os Interrupt or SIGTERM
+func SignalContext(parent context.Context) (context.Context,
+	context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	/* Subscribing to os Interrupt/Signal - SIGTERM and waiting for
+	 * notification in a separate go routine. When the notification is received
+	 * the created context will be canceled */
+	osSignalCh := make(chan os.Signal, 1)
+	signal.Notify(osSignalCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-osSignalCh:
+			logging.Infof("Received signal: %#v", sig)
+		case <-ctx.Done():
+		}
+		signal.Stop(osSignalCh)
+		cancel()
+	}()
+	return ctx, cancel
+}
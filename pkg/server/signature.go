@@ -0,0 +1,47 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/signature"
+)
+
+// VerifySignature rejects the requests to the route pattern of the named
+// server whose body is not signed, or not signed with one of the keys,
+// with a 401 problem, writing them to the audit log
+func VerifySignature(server, pattern string, s *signature.Signer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				problem.Write(w, problem.FromDecodeError(err))
+				return
+			}
+			kid, err := s.Verify(r.Header, body)
+			if err != nil {
+				result := "invalid"
+				if errors.Is(err, signature.ErrMissing) {
+					result = "missing"
+				}
+				signatureChecks.WithLabelValues(server, pattern, result).Inc()
+				logging.FromContext(r.Context()).Warnf(
+					"Signature of %s %s rejected: %v", r.Method, r.URL.Path,
+					err)
+				auditAuthn(r, "signature: "+err.Error())
+				problem.Error(w, http.StatusUnauthorized, "",
+					"signature: "+err.Error())
+				return
+			}
+			signatureChecks.WithLabelValues(server, pattern, "valid").Inc()
+			logging.FromContext(r.Context()).Debugf("Request signed with key %s",
+				kid)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+const (
+	defaultSLOWindow    = 1000
+	defaultSLOObjective = 99
+	// sloRefresh spaces the computations of the percentiles of a route
+	sloRefresh = time.Second
+)
+
+// sloQuantiles are the percentiles of the route latencies exposed
+var sloQuantiles = []float64{50, 95, 99}
+
+var (
+	sloLatency = metrics.NewGaugeVec("nf_http_slo_latency_seconds",
+		"Percentiles (quantile 0.5, 0.95 and 0.99) of the latency of the "+
+			"recent requests of the routes.", "server", "route", "quantile")
+	sloRequests = metrics.NewCounterVec("nf_http_slo_requests_total",
+		"Requests of the routes with a latency objective by result: good "+
+			"within the latency, bad over it.", "server", "route", "result")
+	sloBurnRate = metrics.NewGaugeVec("nf_http_slo_burn_rate",
+		"Share of the recent requests of the routes over their latency "+
+			"objective, divided by the share the objective allows: above 1 "+
+			"the error budget is consumed faster than allowed.",
+		"server", "route")
+)
+
+// slos tracks the latency of the routes against their objectives
+type slos struct {
+	cfg    config.SLOConfig
+	window int
+
+	mu     sync.Mutex
+	routes map[sloKey]*sloWindow
+}
+
+type sloKey struct {
+	server, route string
+}
+
+// sloWindow keeps the latencies of the recent requests of a route
+type sloWindow struct {
+	samples []time.Duration
+	next    int
+	// computed is the time the percentiles were last computed
+	computed time.Time
+}
+
+// newSLOs returns the tracker of the configuration, nil when disabled
+func newSLOs(cfg config.SLOConfig) (*slos, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	window := cfg.Window
+	if window == 0 {
+		window = defaultSLOWindow
+	}
+	return &slos{cfg: cfg, window: window,
+		routes: make(map[sloKey]*sloWindow)}, nil
+}
+
+// middleware records the latency of the requests of the named server
+// against the objective of their route. The raw routes, e.g. the probes,
+// are not tracked. Nil when disabled
+func (s *slos) middleware(server string, router *Router) Middleware {
+	if s == nil {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			route := router.route(r)
+			if route == "" || router.raw[route] {
+				return
+			}
+			d := time.Since(start)
+			s.record(server, route, d)
+			target := s.cfg.Target(route)
+			if target.Latency <= 0 {
+				return
+			}
+			limit := time.Duration(target.Latency) * time.Millisecond
+			if d <= limit {
+				sloRequests.WithLabelValues(server, route, "good").Inc()
+				return
+			}
+			sloRequests.WithLabelValues(server, route, "bad").Inc()
+			if s.cfg.LogViolations {
+				l := logging.FromContext(r.Context())
+				if id := correlationID(r.Context()); id != "" {
+					l = l.With(logging.Fields{"correlation_id": id})
+				}
+				l.Warnf("%s %s served in %v, over the %v latency objective",
+					r.Method, route, d.Round(time.Microsecond), limit)
+			}
+		})
+	}
+}
+
+// record adds the latency of a request of the route, and updates its
+// percentiles and burn rate at most every sloRefresh
+func (s *slos) record(server, route string, d time.Duration) {
+	key := sloKey{server, route}
+	s.mu.Lock()
+	w, ok := s.routes[key]
+	if !ok {
+		w = &sloWindow{}
+		s.routes[key] = w
+	}
+	if len(w.samples) < s.window {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % s.window
+	}
+	now := time.Now()
+	if now.Sub(w.computed) < sloRefresh {
+		s.mu.Unlock()
+		return
+	}
+	w.computed = now
+	samples := append([]time.Duration(nil), w.samples...)
+	s.mu.Unlock()
+	stats := s.stats(route, samples)
+	for i, q := range sloQuantiles {
+		sloLatency.WithLabelValues(server, route,
+			strconv.FormatFloat(q/100, 'f', -1, 64)).Set(
+			stats.Percentiles[i])
+	}
+	if stats.BurnRate != nil {
+		sloBurnRate.WithLabelValues(server, route).Set(*stats.BurnRate)
+	}
+}
+
+// sloStats are the statistics of the recent requests of a route
+type sloStats struct {
+	Server string `json:"server"`
+	Route  string `json:"route"`
+	// Requests is the number of recent requests
+	Requests int `json:"requests"`
+	// Percentiles are the p50, p95 and p99 latencies in seconds
+	Percentiles []float64        `json:"percentiles"`
+	Target      config.SLOTarget `json:"target"`
+	// BurnRate is the share of the requests over the latency objective
+	// divided by the share allowed, nil without objective
+	BurnRate *float64 `json:"burnrate,omitempty"`
+}
+
+// stats computes the statistics of the latencies of the route
+func (s *slos) stats(route string, samples []time.Duration) sloStats {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	st := sloStats{Route: route, Requests: len(samples),
+		Target: s.cfg.Target(route)}
+	for _, q := range sloQuantiles {
+		i := int(float64(len(samples))*q/100+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(samples) {
+			i = len(samples) - 1
+		}
+		st.Percentiles = append(st.Percentiles, samples[i].Seconds())
+	}
+	if st.Target.Latency > 0 {
+		objective := st.Target.Objective
+		if objective == 0 {
+			objective = defaultSLOObjective
+		}
+		limit := time.Duration(st.Target.Latency) * time.Millisecond
+		over := len(samples) - sort.Search(len(samples), func(i int) bool {
+			return samples[i] > limit
+		})
+		burn := float64(over) / float64(len(samples)) /
+			(1 - objective/100)
+		st.BurnRate = &burn
+	}
+	return st
+}
+
+// handler serves the statistics of the routes as JSON, by server and route
+func (s *slos) handler() http.Handler {
+	return JSONHandler(func() interface{} {
+		s.mu.Lock()
+		keys := make([]sloKey, 0, len(s.routes))
+		windows := make(map[sloKey][]time.Duration, len(s.routes))
+		for key, w := range s.routes {
+			keys = append(keys, key)
+			windows[key] = append([]time.Duration(nil), w.samples...)
+		}
+		s.mu.Unlock()
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].server != keys[j].server {
+				return keys[i].server < keys[j].server
+			}
+			return keys[i].route < keys[j].route
+		})
+		stats := make([]sloStats, 0, len(keys))
+		for _, key := range keys {
+			st := s.stats(key.route, windows[key])
+			st.Server = key.server
+			stats = append(stats, st)
+		}
+		return stats
+	})
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/ratelimit"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+)
+
+var tenantRequests = metrics.NewCounterVec("nf_tenant_requests_total",
+	"Requests handled by the NF servers for the tenants.",
+	"tenant", "server", "route", "code")
+
+// tenantKey is the context key of the tenant of a request
+type tenantKey struct{}
+
+// Tenant returns the tenant of the request of the context, false for the
+// requests of the default NF
+func Tenant(ctx context.Context) (config.TenantConfig, bool) {
+	t, ok := ctx.Value(tenantKey{}).(*tenant)
+	if !ok {
+		return config.TenantConfig{}, false
+	}
+	return t.cfg, true
+}
+
+// tenant is a tenant of the servers with its rate limits
+type tenant struct {
+	cfg     config.TenantConfig
+	global  *ratelimit.Bucket
+	clients *ratelimit.Limiter
+}
+
+// tenants recognizes the tenants of the requests, by the host name of the
+// request or the prefix of its path, which is removed before routing
+type tenants struct {
+	list  []*tenant
+	hosts map[string]*tenant
+}
+
+// newTenants returns the tenants of the configuration, nil without any
+func newTenants(cfg config.Tenants) (*tenants, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ts := &tenants{hosts: make(map[string]*tenant)}
+	for _, c := range cfg {
+		t := &tenant{cfg: c,
+			global: ratelimit.NewBucket(c.RateLimit.Global.Rate,
+				c.RateLimit.Global.Burst),
+			clients: ratelimit.NewLimiter(c.RateLimit.Client.Rate,
+				c.RateLimit.Client.Burst)}
+		ts.list = append(ts.list, t)
+		for _, h := range c.Hosts {
+			ts.hosts[strings.ToLower(h)] = t
+		}
+	}
+	return ts, nil
+}
+
+// match returns the tenant of the request and the path of the request
+// without the tenant prefix, nil for the default NF
+func (ts *tenants) match(r *http.Request) (*tenant, string) {
+	path := r.URL.Path
+	t := ts.hosts[strings.ToLower(hostname(r.Host))]
+	if t != nil {
+		if p := t.cfg.Prefix; p != "" && (path == p ||
+			strings.HasPrefix(path, p+"/")) {
+			path = strings.TrimPrefix(path, p)
+		}
+		return t, path
+	}
+	for _, t := range ts.list {
+		p := t.cfg.Prefix
+		if p != "" && (path == p || strings.HasPrefix(path, p+"/")) {
+			return t, strings.TrimPrefix(path, p)
+		}
+	}
+	return nil, path
+}
+
+// middleware attaches the tenant of the requests of the named server to
+// their context and logger, routes them without the tenant prefix, rejects
+// them over the limits of the tenant and counts them per tenant. Nil
+// without tenants
+func (ts *tenants) middleware(server string, router *Router) Middleware {
+	if ts == nil {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, path := ts.match(r)
+			if t == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name := t.cfg.Name
+			l := logging.FromContext(r.Context()).With(
+				logging.Fields{"tenant": name})
+			ctx := context.WithValue(r.Context(), tenantKey{}, t)
+			r = r.WithContext(logging.NewContext(ctx, l))
+			if path == "" {
+				path = "/"
+			}
+			if path != r.URL.Path {
+				u := *r.URL
+				u.Path, u.RawPath = path, ""
+				r.URL = &u
+			}
+			route := router.route(r)
+			limit := "client"
+			ok, retry := t.clients.Bucket(clientIdentity(r)).Allow()
+			if ok {
+				limit = "global"
+				ok, retry = t.global.Allow()
+			}
+			if !ok {
+				tenantRequests.WithLabelValues(name, server, route,
+					strconv.Itoa(http.StatusTooManyRequests)).Inc()
+				l.Warnf("Request of %s over the %s rate limit of the tenant",
+					clientIdentity(r), limit)
+				w.Header().Set("Retry-After", strconv.Itoa(
+					int(math.Max(1, math.Ceil(retry.Seconds())))))
+				problem.Error(w, http.StatusTooManyRequests,
+					problem.CauseNFCongestionRisk,
+					"tenant request rate limit exceeded")
+				return
+			}
+			sw := &logging.StatusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			if sw.Status == 0 {
+				sw.Status = http.StatusOK
+			}
+			tenantRequests.WithLabelValues(name, server, route,
+				strconv.Itoa(sw.Status)).Inc()
+		})
+	}
+}
+
+// certificates returns the certificates of the tenant host names
+func (ts *tenants) certificates() (map[string]*tls.Certificate, error) {
+	if ts == nil {
+		return nil, nil
+	}
+	certs := make(map[string]*tls.Certificate)
+	for _, t := range ts.list {
+		files := t.cfg.TLS
+		if files.CertFile == "" {
+			continue
+		}
+		cert, err := tlsutil.LoadKeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range t.cfg.Hosts {
+			certs[strings.ToLower(h)] = &cert
+		}
+	}
+	return certs, nil
+}
+
+// TenantURI returns the URI of path on the named server for the tenant of
+// the request of the context: under the host of its API root, its first
+// host name without one, and its path prefix. It is the URI of the default
+// NF for the other requests
+func (s *Service) TenantURI(ctx context.Context, name, path string) string {
+	t, ok := Tenant(ctx)
+	if !ok {
+		return s.URI(name, path)
+	}
+	host, prefix := splitAPIRoot(s.APIRoot)
+	switch {
+	case t.APIRoot != "":
+		host, _ = splitAPIRoot(t.APIRoot)
+	case len(t.Hosts) > 0:
+		host = t.Hosts[0]
+	}
+	return s.uri(host, name, t.Prefix+prefix+s.versionPath(name)+path)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+// ticketKeys rotates the keys the TLS servers encrypt the session tickets
+// with, keeping the previous key to resume the sessions of its tickets
+type ticketKeys struct {
+	rotation time.Duration
+
+	mu      sync.Mutex
+	configs []*tls.Config
+	keys    [][32]byte
+}
+
+// newTicketKeys returns the rotation of the ticket keys of the policy, nil
+// when Go rotates them or without session tickets
+func newTicketKeys(p config.TLSPolicy) *ticketKeys {
+	if p.TicketRotation == 0 || p.DisableSessionTickets {
+		return nil
+	}
+	return &ticketKeys{
+		rotation: time.Duration(p.TicketRotation) * time.Second}
+}
+
+// use makes the TLS configuration encrypt the tickets with the current key
+func (t *ticketKeys) use(c *tls.Config) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.keys == nil {
+		t.rotate()
+	}
+	t.configs = append(t.configs, c)
+	c.SetSessionTicketKeys(t.keys)
+}
+
+// rotate replaces the previous key by a new one, locked
+func (t *ticketKeys) rotate() {
+	var key [32]byte
+	_, _ = rand.Read(key[:])
+	keys := [][32]byte{key}
+	if len(t.keys) > 0 {
+		keys = append(keys, t.keys[0])
+	}
+	t.keys = keys
+	for _, c := range t.configs {
+		c.SetSessionTicketKeys(keys)
+	}
+}
+
+// run rotates the keys until the context is canceled
+func (t *ticketKeys) run(ctx context.Context) {
+	ticker := time.NewTicker(t.rotation)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			t.rotate()
+			t.mu.Unlock()
+			logging.Debugf("TLS session ticket key rotated")
+		}
+	}
+}
+
+// hsts sets the Strict-Transport-Security header of the responses over TLS
+// with the max-age in seconds. Nil when 0
+func hsts(maxAge int) Middleware {
+	if maxAge == 0 {
+		return nil
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// versionKey is the context key of the API version of a request
+type versionKey struct{}
+
+// APIVersion returns the API version of the path of the request of the
+// context, e.g. "v1", empty for the unversioned paths
+func APIVersion(ctx context.Context) string {
+	v, _ := ctx.Value(versionKey{}).(string)
+	return v
+}
+
+// apiVersions serves the routes of a router under /{apiName}/{apiVersion}
+type apiVersions struct {
+	name string
+	// served are the versions served, the current one first
+	served []string
+	legacy bool
+}
+
+// newAPIVersions returns the versions of the routes of the named server,
+// nil when they are unversioned
+func newAPIVersions(cfg config.VersioningConfig,
+	server string) (*apiVersions, error) {
+	if !cfg.Enabled || cfg.APIs[server] == "" {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &apiVersions{name: cfg.APIs[server], served: cfg.Served(),
+		legacy: cfg.Legacy}, nil
+}
+
+// path returns the API name and current version of the paths, e.g.
+// "/nnf1-loc/v1", empty when v is nil
+func (v *apiVersions) path() string {
+	if v == nil {
+		return ""
+	}
+	return "/" + v.name + "/" + v.served[0]
+}
+
+// strip returns the request with its path under the router prefix without
+// the API name and version, with the version in its context, and true. It
+// returns the request unchanged and false for the unversioned paths, and
+// nil for the versions not served
+func (v *apiVersions) strip(prefix string,
+	req *http.Request) (*http.Request, bool) {
+	rest := req.URL.Path
+	if prefix != "" {
+		if !strings.HasPrefix(rest, prefix+"/") {
+			return req, false
+		}
+		rest = rest[len(prefix):]
+	}
+	base := "/" + v.name
+	if rest != base && !strings.HasPrefix(rest, base+"/") {
+		return req, false
+	}
+	version, tail, _ := strings.Cut(strings.TrimPrefix(rest[len(base):], "/"),
+		"/")
+	served := false
+	for _, s := range v.served {
+		served = served || s == version
+	}
+	if !served {
+		return nil, true
+	}
+	rreq := req.WithContext(context.WithValue(req.Context(), versionKey{},
+		version))
+	u := *req.URL
+	u.Path, u.RawPath = prefix+"/"+tail, ""
+	rreq.URL = &u
+	return rreq, true
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/tlsutil"
+)
+
+// setupVHosts makes the router of the server dispatch the requests of its
+// virtual hosts to the routers of their route groups
+func (s *Service) setupVHosts(ns *namedServer,
+	vhosts map[string]config.VHostConfig) {
+	if len(vhosts) == 0 {
+		return
+	}
+	ns.vhosts = make(map[string]config.VHostConfig, len(vhosts))
+	for host, v := range vhosts {
+		ns.vhosts[strings.ToLower(host)] = v
+	}
+	ns.router.vhosts = func(host string) ([]*Router, bool) {
+		v, ok := ns.vhosts[host]
+		if !ok {
+			return nil, false
+		}
+		if len(v.Routes) == 0 {
+			return []*Router{ns.router}, true
+		}
+		routers := make([]*Router, 0, len(v.Routes))
+		for _, name := range v.Routes {
+			if other := s.server(name); other != nil {
+				routers = append(routers, other.router)
+			}
+		}
+		return routers, true
+	}
+}
+
+// checkVHosts checks that the route groups of the virtual hosts are
+// servers of the Service, once they are all added
+func (s *Service) checkVHosts() error {
+	for _, ns := range s.servers {
+		hosts := make([]string, 0, len(ns.vhosts))
+		for host := range ns.vhosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			for _, name := range ns.vhosts[host].Routes {
+				if s.server(name) == nil {
+					return fmt.Errorf("%s virtual host %s: no %s server",
+						ns.name, host, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// vhostCertificates returns the certificates of the virtual hosts of the
+// server by host name
+func (ns *namedServer) vhostCertificates() (map[string]*tls.Certificate,
+	error) {
+	certs := make(map[string]*tls.Certificate)
+	for host, v := range ns.vhosts {
+		if v.TLS.CertFile == "" {
+			continue
+		}
+		cert, err := tlsutil.LoadKeyPair(v.TLS.CertFile, v.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("virtual host %s: %v", host, err)
+		}
+		certs[host] = &cert
+	}
+	return certs, nil
+}
+
+// withHostCertificates makes the TLS configuration serve the certificates
+// to their host names, by SNI
+func withHostCertificates(tlsConfig *tls.Config,
+	certs map[string]*tls.Certificate) {
+	if len(certs) == 0 {
+		return
+	}
+	get := tlsConfig.GetCertificate
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (
+		*tls.Certificate, error) {
+		if cert, ok := certs[strings.ToLower(hello.ServerName)]; ok {
+			return cert, nil
+		}
+		if get != nil {
+			return get(hello)
+		}
+		return &tlsConfig.Certificates[0], nil
+	}
+}
+
+// requestHost returns the host name the request was sent to: the TLS
+// server name, or the host of the Host header without TLS
+func requestHost(r *http.Request) string {
+	if r.TLS != nil && r.TLS.ServerName != "" {
+		return strings.ToLower(r.TLS.ServerName)
+	}
+	return strings.ToLower(hostname(r.Host))
+}
@@ -0,0 +1,391 @@
+// Package signature signs the bodies of the callbacks sent by the NF and
+// verifies the signatures of the callbacks it receives, so that a
+// notification not signed with a key of the NF, or altered on the way, is
+// rejected. The HMAC format carries HMAC-SHA256 of the signing time and the
+// body in the X-Nf-Signature header, as "t=<unix time>,kid=<key ID>,v1=<hex
+// signature>". The JWS format carries a JWS of the body with a detached
+// payload (RFC 7515 appendix F) in the X-Nf-Jws-Signature header, its
+// protected header holding the key ID and the signing time "iat". The
+// signatures older than the tolerance are rejected as replayed
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/jwt"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+)
+
+// Signature headers
+const (
+	HeaderHMAC = "X-Nf-Signature"
+	HeaderJWS  = "X-Nf-Jws-Signature"
+)
+
+const defaultTolerance = 300
+
+// ErrMissing is returned by Verify for a request without signature
+var ErrMissing = errors.New("missing signature")
+
+// key signs or verifies the signatures: a shared secret, or an RSA or EC
+// private key signing and its public key verifying
+type key struct {
+	id      string
+	secret  []byte
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// keyring holds the keys of a configuration
+type keyring struct {
+	format    string
+	signing   *key
+	keys      map[string]*key
+	tolerance time.Duration
+	all       bool
+}
+
+// Signer signs and verifies the callbacks with the keys of the
+// configuration, replaced on reload
+type Signer struct {
+	ring atomic.Value
+}
+
+// New returns the signer of the configuration
+func New(cfg config.SignaturesConfig) (*Signer, error) {
+	s := &Signer{}
+	if err := s.Set(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the keys with those of the configuration. Disabling the
+// signatures takes a restart
+func (s *Signer) Set(cfg config.SignaturesConfig) error {
+	apply, err := s.Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare loads the keys of the configuration, and returns the function
+// replacing the keys with them
+func (s *Signer) Prepare(cfg config.SignaturesConfig) (func(), error) {
+	if !cfg.Enabled {
+		return nil, errors.New("signatures.enabled: disabled, which takes " +
+			"a restart")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ring := &keyring{format: cfg.Format, keys: make(map[string]*key),
+		tolerance: time.Duration(cfg.Tolerance) * time.Second, all: cfg.All}
+	if ring.format == "" {
+		ring.format = config.SignatureHMAC
+	}
+	if ring.tolerance == 0 {
+		ring.tolerance = defaultTolerance * time.Second
+	}
+	for i, kc := range cfg.Keys {
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("signatures.keys[%d]: %v", i, err)
+		}
+		ring.keys[k.id] = k
+		if (cfg.SigningKey == "" && i == 0) || cfg.SigningKey == k.id {
+			ring.signing = k
+		}
+	}
+	if ring.signing.secret == nil && ring.signing.private == nil {
+		return nil, fmt.Errorf("signatures.signingkey: key %s cannot sign",
+			ring.signing.id)
+	}
+	return func() { s.ring.Store(ring) }, nil
+}
+
+// loadKey reads the secret or the key files of the key
+func loadKey(cfg config.SignatureKey) (*key, error) {
+	k := &key{id: cfg.ID}
+	if cfg.Secret != "" {
+		secret, err := secrets.Value(cfg.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("secret: %v", err)
+		}
+		k.secret = []byte(secret)
+		return k, nil
+	}
+	if cfg.PrivateKeyFile != "" {
+		private, err := loadPrivateKey(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("privatekeyfile: %v", err)
+		}
+		k.private, k.public = private, private.Public()
+	}
+	if cfg.PublicKeyFile != "" {
+		public, err := jwt.LoadPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("publickeyfile: %v", err)
+		}
+		k.public = public
+	}
+	if _, err := algorithm(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// loadPrivateKey reads a PEM RSA or EC private key, from a file or a secret
+// reference
+func loadPrivateKey(file string) (crypto.Signer, error) {
+	data, err := secrets.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+	return signer, nil
+}
+
+// algorithm returns the JWS algorithm of the key
+func algorithm(k *key) (string, error) {
+	switch pub := k.public.(type) {
+	case nil:
+		return "HS256", nil
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported key type %T", k.public)
+}
+
+// All tells whether all the requests are signed, not only the callbacks
+func (s *Signer) All() bool {
+	return s.ring.Load().(*keyring).all
+}
+
+// Sign sets the signature header of the request with the body
+func (s *Signer) Sign(header http.Header, body []byte) error {
+	ring := s.ring.Load().(*keyring)
+	now := time.Now().Unix()
+	if ring.format == config.SignatureJWS {
+		jws, err := signJWS(ring.signing, now, body)
+		if err != nil {
+			return err
+		}
+		header.Set(HeaderJWS, jws)
+		return nil
+	}
+	header.Set(HeaderHMAC, fmt.Sprintf("t=%d,kid=%s,v1=%s", now,
+		ring.signing.id, hex.EncodeToString(mac(ring.signing.secret,
+			strconv.FormatInt(now, 10)+"."+string(body)))))
+	return nil
+}
+
+// Verify checks the signature of the request with the body, returning the
+// ID of the key it was signed with. It returns ErrMissing when the request
+// is not signed
+func (s *Signer) Verify(header http.Header, body []byte) (string, error) {
+	ring := s.ring.Load().(*keyring)
+	if ring.format == config.SignatureJWS {
+		jws := header.Get(HeaderJWS)
+		if jws == "" {
+			return "", ErrMissing
+		}
+		return ring.verifyJWS(jws, body)
+	}
+	sig := header.Get(HeaderHMAC)
+	if sig == "" {
+		return "", ErrMissing
+	}
+	fields := make(map[string]string, 3)
+	for _, f := range strings.Split(sig, ",") {
+		if i := strings.IndexByte(f, '='); i > 0 {
+			fields[strings.TrimSpace(f[:i])] = strings.TrimSpace(f[i+1:])
+		}
+	}
+	k := ring.keys[fields["kid"]]
+	if k == nil || k.secret == nil {
+		return fields["kid"], fmt.Errorf("unknown key %q", fields["kid"])
+	}
+	t, err := strconv.ParseInt(fields["t"], 10, 64)
+	if err != nil {
+		return k.id, errors.New("invalid signature time")
+	}
+	if err := ring.checkTime(t); err != nil {
+		return k.id, err
+	}
+	v1, err := hex.DecodeString(fields["v1"])
+	if err != nil || !hmac.Equal(v1, mac(k.secret, fields["t"]+"."+
+		string(body))) {
+		return k.id, errors.New("invalid signature")
+	}
+	return k.id, nil
+}
+
+// checkTime rejects the signatures made outside of the tolerance
+func (ring *keyring) checkTime(t int64) error {
+	age := time.Since(time.Unix(t, 0))
+	if age > ring.tolerance || age < -ring.tolerance {
+		return fmt.Errorf("signature made %v ago, outside of the tolerance",
+			age.Truncate(time.Second))
+	}
+	return nil
+}
+
+func mac(secret []byte, input string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(input))
+	return h.Sum(nil)
+}
+
+// jwsHeader is the protected header of the detached JWS
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Iat int64  `json:"iat"`
+}
+
+// signJWS returns the compact JWS of the body with a detached payload
+func signJWS(k *key, now int64, body []byte) (string, error) {
+	alg, err := algorithm(k)
+	if err != nil {
+		return "", err
+	}
+	h, err := json.Marshal(jwsHeader{Alg: alg, Kid: k.id, Iat: now})
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(h)
+	input := protected + "." + base64.RawURLEncoding.EncodeToString(body)
+	var sig []byte
+	if k.secret != nil {
+		sig = mac(k.secret, input)
+	} else {
+		hash := crypto.SHA256
+		if pub, ok := k.public.(*ecdsa.PublicKey); ok {
+			hash = ecHash(pub)
+		}
+		h := hash.New()
+		h.Write([]byte(input))
+		if sig, err = sign(k.private, hash, h.Sum(nil)); err != nil {
+			return "", err
+		}
+	}
+	return protected + ".." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ecHash returns the hash of the ES algorithm of the curve
+func ecHash(pub *ecdsa.PublicKey) crypto.Hash {
+	switch pub.Curve.Params().BitSize {
+	case 384:
+		return crypto.SHA384
+	case 521:
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+// sign returns the JWS signature of the digest: PKCS #1 v1.5 for RSA, the
+// fixed size R and S for ECDSA
+func sign(private crypto.Signer, hash crypto.Hash,
+	digest []byte) ([]byte, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", private)
+}
+
+// verifyJWS checks the detached JWS of the body
+func (ring *keyring) verifyJWS(jws string, body []byte) (string, error) {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return "", errors.New("malformed detached JWS")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed JWS header: %v", err)
+	}
+	var h jwsHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return "", fmt.Errorf("malformed JWS header: %v", err)
+	}
+	k := ring.keys[h.Kid]
+	if k == nil {
+		return h.Kid, fmt.Errorf("unknown key %q", h.Kid)
+	}
+	if alg, _ := algorithm(k); alg != h.Alg {
+		return k.id, fmt.Errorf("algorithm %s does not match key %s", h.Alg,
+			k.id)
+	}
+	if err := ring.checkTime(h.Iat); err != nil {
+		return k.id, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return k.id, fmt.Errorf("malformed JWS signature: %v", err)
+	}
+	input := parts[0] + "." + base64.RawURLEncoding.EncodeToString(body)
+	if k.secret != nil {
+		if !hmac.Equal(sig, mac(k.secret, input)) {
+			return k.id, errors.New("invalid signature")
+		}
+		return k.id, nil
+	}
+	return k.id, jwt.Verify(h.Alg, k.public, input, sig)
+}
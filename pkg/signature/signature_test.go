@@ -0,0 +1,182 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+var body = []byte(`{"correlationid":"c1","location":"loc"}`)
+
+func newSigner(t *testing.T, format string,
+	keys ...config.SignatureKey) *Signer {
+	t.Helper()
+	s, err := New(config.SignaturesConfig{Enabled: true, Format: format,
+		Keys: keys})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+// hmacHeader returns the X-Nf-Signature of the body signed at t
+func hmacHeader(secret, kid string, t int64, body []byte) string {
+	ts := strconv.FormatInt(t, 10)
+	return fmt.Sprintf("t=%s,kid=%s,v1=%s", ts, kid,
+		hex.EncodeToString(mac([]byte(secret), ts+"."+string(body))))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	s := newSigner(t, config.SignatureHMAC,
+		config.SignatureKey{ID: "k1", Secret: "secret1"})
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		err    string
+	}{
+		{"valid", hmacHeader("secret1", "k1", now, body), body, ""},
+		{"within the tolerance", hmacHeader("secret1", "k1", now-200, body),
+			body, ""},
+		{"too old", hmacHeader("secret1", "k1", now-400, body), body,
+			"outside of the tolerance"},
+		{"in the future", hmacHeader("secret1", "k1", now+400, body), body,
+			"outside of the tolerance"},
+		{"unknown kid", hmacHeader("secret1", "k2", now, body), body,
+			"unknown key"},
+		{"body altered", hmacHeader("secret1", "k1", now, body),
+			[]byte(`{"correlationid":"c2","location":"loc"}`),
+			"invalid signature"},
+		{"other secret", hmacHeader("secret2", "k1", now, body), body,
+			"invalid signature"},
+		{"missing v1", fmt.Sprintf("t=%d,kid=k1", now), body,
+			"invalid signature"},
+		{"missing t", "kid=k1,v1=00", body, "invalid signature time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set(HeaderHMAC, tt.header)
+			_, err := s.Verify(h, tt.body)
+			checkErr(t, err, tt.err)
+		})
+	}
+	if _, err := s.Verify(http.Header{}, body); !errors.Is(err, ErrMissing) {
+		t.Fatalf("unsigned request: %v, want ErrMissing", err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		format string
+		key    config.SignatureKey
+	}{
+		{config.SignatureHMAC, config.SignatureKey{ID: "k1",
+			Secret: "secret1"}},
+		{config.SignatureJWS, config.SignatureKey{ID: "k1",
+			Secret: "secret1"}},
+		{config.SignatureJWS, config.SignatureKey{ID: "ec",
+			PrivateKeyFile: keyFile}},
+	} {
+		t.Run(tt.format+"/"+tt.key.ID, func(t *testing.T) {
+			s := newSigner(t, tt.format, tt.key)
+			h := http.Header{}
+			if err := s.Sign(h, body); err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			kid, err := s.Verify(h, body)
+			if err != nil || kid != tt.key.ID {
+				t.Fatalf("Verify: %q, %v", kid, err)
+			}
+			if _, err := s.Verify(h, append(body, ' ')); err == nil {
+				t.Fatal("altered body accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyJWS(t *testing.T) {
+	s := newSigner(t, config.SignatureJWS,
+		config.SignatureKey{ID: "k1", Secret: "secret1"})
+	other := newSigner(t, config.SignatureJWS,
+		config.SignatureKey{ID: "k2", Secret: "secret2"})
+	h := http.Header{}
+	if err := s.Sign(h, body); err != nil {
+		t.Fatal(err)
+	}
+	valid := h.Get(HeaderJWS)
+	parts := strings.Split(valid, ".")
+	unknown := http.Header{}
+	if err := other.Sign(unknown, body); err != nil {
+		t.Fatal(err)
+	}
+	old, err := signJWS(&key{id: "k1", secret: []byte("secret1")},
+		time.Now().Add(-time.Hour).Unix(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		jws  string
+		body []byte
+		err  string
+	}{
+		{"valid", valid, body, ""},
+		{"payload not detached", parts[0] + ".e30." + parts[2], body,
+			"malformed detached JWS"},
+		{"body altered", valid, []byte(`{}`), "invalid signature"},
+		{"unknown kid", unknown.Get(HeaderJWS), body, "unknown key"},
+		{"too old", old, body, "outside of the tolerance"},
+		{"two segments", parts[0] + "." + parts[2], body,
+			"malformed detached JWS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set(HeaderJWS, tt.jws)
+			_, err := s.Verify(h, tt.body)
+			checkErr(t, err, tt.err)
+		})
+	}
+}
+
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("accepted, want error %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q, want %q", err, want)
+	}
+}
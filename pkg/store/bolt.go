@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt is the store of a BoltDB file, with a bucket per collection. The
+// file is locked by the process, the NF cannot have replicas with it
+type Bolt struct {
+	db *bolt.DB
+}
+
+// openBolt opens the BoltDB file, waiting at most timeout for its lock
+func openBolt(path string, timeout time.Duration) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+// encodeEntry prefixes the value with its expiry time in Unix nanoseconds,
+// 0 when it does not expire
+func encodeEntry(value []byte, ttl time.Duration) []byte {
+	b := make([]byte, 8, 8+len(value))
+	if exp := expiry(ttl); !exp.IsZero() {
+		binary.BigEndian.PutUint64(b, uint64(exp.UnixNano()))
+	}
+	return append(b, value...)
+}
+
+// decodeEntry returns the value of an entry, false once it expired
+func decodeEntry(b []byte, now time.Time) ([]byte, bool) {
+	if len(b) < 8 {
+		return nil, false
+	}
+	exp := int64(binary.BigEndian.Uint64(b))
+	if exp != 0 && now.UnixNano() > exp {
+		return nil, false
+	}
+	/* the bolt values are only valid during the transaction */
+	return append([]byte(nil), b[8:]...), true
+}
+
+// Get implements Store
+func (s *Bolt) Get(_ context.Context, collection, key string) ([]byte,
+	error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return ErrNotFound
+		}
+		v, ok := decodeEntry(b.Get([]byte(key)), time.Now())
+		if !ok {
+			return ErrNotFound
+		}
+		value = v
+		return nil
+	})
+	return value, err
+}
+
+// Put implements Store
+func (s *Bolt) Put(_ context.Context, collection, key string, value []byte,
+	ttl time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), encodeEntry(value, ttl))
+	})
+}
+
+// Create implements Store
+func (s *Bolt) Create(_ context.Context, collection, key string, value []byte,
+	ttl time.Duration) (bool, error) {
+	var created bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		if _, ok := decodeEntry(b.Get([]byte(key)), time.Now()); ok {
+			return nil
+		}
+		created = true
+		return b.Put([]byte(key), encodeEntry(value, ttl))
+	})
+	return created, err
+}
+
+// Delete implements Store
+func (s *Bolt) Delete(_ context.Context, collection, key string) (bool,
+	error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		_, found = decodeEntry(b.Get([]byte(key)), time.Now())
+		return b.Delete([]byte(key))
+	})
+	return found, err
+}
+
+// List implements Store. The expired entries are removed meanwhile
+func (s *Bolt) List(_ context.Context, collection string) (
+	map[string][]byte, error) {
+	out := make(map[string][]byte)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		now := time.Now()
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if value, ok := decodeEntry(v, now); ok {
+				out[string(k)] = value
+			} else {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// Close implements Store
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// Memory is the in-memory store, the default one. Its content is lost when
+// the NF stops
+type Memory struct {
+	mu          sync.Mutex
+	collections map[string]map[string]memoryEntry
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{collections: make(map[string]map[string]memoryEntry)}
+}
+
+// Get implements Store
+func (m *Memory) Get(_ context.Context, collection, key string) ([]byte,
+	error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.collections[collection][key]
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), e.value...), nil
+}
+
+// Put implements Store
+func (m *Memory) Put(_ context.Context, collection, key string, value []byte,
+	ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.collections[collection]
+	if !ok {
+		c = make(map[string]memoryEntry)
+		m.collections[collection] = c
+	}
+	c[key] = memoryEntry{value: append([]byte(nil), value...),
+		expires: expiry(ttl)}
+	return nil
+}
+
+// Create implements Store
+func (m *Memory) Create(_ context.Context, collection, key string,
+	value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	e, ok := m.collections[collection][key]
+	if ok && !e.expired(time.Now()) {
+		m.mu.Unlock()
+		return false, nil
+	}
+	c, ok := m.collections[collection]
+	if !ok {
+		c = make(map[string]memoryEntry)
+		m.collections[collection] = c
+	}
+	c[key] = memoryEntry{value: append([]byte(nil), value...),
+		expires: expiry(ttl)}
+	m.mu.Unlock()
+	return true, nil
+}
+
+// Delete implements Store
+func (m *Memory) Delete(_ context.Context, collection, key string) (bool,
+	error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.collections[collection][key]
+	delete(m.collections[collection], key)
+	return ok && !e.expired(time.Now()), nil
+}
+
+// List implements Store. The expired entries are removed meanwhile
+func (m *Memory) List(_ context.Context, collection string) (
+	map[string][]byte, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string][]byte)
+	for k, e := range m.collections[collection] {
+		if e.expired(now) {
+			delete(m.collections[collection], k)
+			continue
+		}
+		out[k] = append([]byte(nil), e.value...)
+	}
+	return out, nil
+}
+
+// Close implements Store
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,346 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+)
+
+// Default Redis settings
+const (
+	defaultRedisPrefix   = "nf:"
+	defaultRedisPoolSize = 4
+	// redisScanCount is the number of keys asked per SCAN call
+	redisScanCount = 100
+)
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Redis is the store of a Redis server, shared by the replicas of the NF.
+// The keys are the prefix, the collection and the key separated by colons
+// and expire with the ttl in Redis
+type Redis struct {
+	addr     string
+	username string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+	// idle holds the connections between the operations
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func newRedis(cfg config.StoreConfig, timeout time.Duration) (*Redis,
+	error) {
+	if cfg.Address == "" {
+		return nil, errors.New("no Redis address")
+	}
+	s := &Redis{addr: cfg.Address, username: cfg.Username,
+		password: cfg.Password, db: cfg.DB, prefix: cfg.Prefix,
+		timeout: timeout}
+	if s.prefix == "" {
+		s.prefix = defaultRedisPrefix
+	}
+	size := cfg.PoolSize
+	if size <= 0 {
+		size = defaultRedisPoolSize
+	}
+	s.idle = make(chan *redisConn, size)
+	/* fail at startup rather than at the first request */
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := s.do(ctx, "PING"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Redis) key(collection, key string) string {
+	return s.prefix + collection + ":" + key
+}
+
+// Get implements Store
+func (s *Redis) Get(ctx context.Context, collection, key string) ([]byte,
+	error) {
+	reply, err := s.do(ctx, "GET", s.key(collection, key))
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Put implements Store
+func (s *Redis) Put(ctx context.Context, collection, key string, value []byte,
+	ttl time.Duration) error {
+	_, err := s.do(ctx, s.set(collection, key, value, ttl)...)
+	return err
+}
+
+// Create implements Store
+func (s *Redis) Create(ctx context.Context, collection, key string,
+	value []byte, ttl time.Duration) (bool, error) {
+	reply, err := s.do(ctx, append(s.set(collection, key, value, ttl),
+		"NX")...)
+	if err != nil {
+		return false, err
+	}
+	/* SET NX answers nil when the key exists */
+	return reply != nil, nil
+}
+
+// set returns the SET command of the value, expiring after ttl
+func (s *Redis) set(collection, key string, value []byte,
+	ttl time.Duration) []string {
+	args := []string{"SET", s.key(collection, key), string(value)}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	return args
+}
+
+// Delete implements Store
+func (s *Redis) Delete(ctx context.Context, collection, key string) (bool,
+	error) {
+	reply, err := s.do(ctx, "DEL", s.key(collection, key))
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+// List implements Store. The keys are scanned, then their values fetched
+// at once, skipping the ones expired meanwhile
+func (s *Redis) List(ctx context.Context, collection string) (
+	map[string][]byte, error) {
+	base := s.key(collection, "")
+	match := globEscape(base) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.do(ctx, "SCAN", cursor, "MATCH", match, "COUNT",
+			strconv.Itoa(redisScanCount))
+		if err != nil {
+			return nil, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, errors.New("redis: unexpected SCAN reply")
+		}
+		next, _ := parts[0].([]byte)
+		batch, _ := parts[1].([]interface{})
+		for _, k := range batch {
+			if k, ok := k.([]byte); ok {
+				keys = append(keys, string(k))
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	out := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+	reply, err := s.do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	for i, v := range values {
+		if v, ok := v.([]byte); ok && i < len(keys) {
+			out[strings.TrimPrefix(keys[i], base)] = v
+		}
+	}
+	return out, nil
+}
+
+// Close implements Store, closing the idle connections
+func (s *Redis) Close() error {
+	for {
+		select {
+		case c := <-s.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// globEscape escapes the glob characters of a SCAN pattern
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// do sends the command on an idle connection, or a new one, and returns its
+// reply: a string, an int64, a []byte, nil or a []interface{} of them
+func (s *Redis) do(ctx context.Context, args ...string) (interface{},
+	error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, s.timeout, args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		/* the connection state is unknown after an I/O error */
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case s.idle <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+// conn returns an idle connection, or a new one authenticated and on the
+// configured database
+func (s *Redis) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+	d := net.Dialer{Timeout: s.timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, br: bufio.NewReader(conn)}
+	if s.password != "" {
+		/* resolved on every connection to follow the rotation of a
+		 * secret */
+		password, err := secrets.Value(s.password)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		args := []string{"AUTH", password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, password}
+		}
+		if _, err := c.do(ctx, s.timeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := c.do(ctx, s.timeout, "SELECT",
+			strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration,
+	args ...string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply reads a RESP2 reply
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.New("redis: invalid bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.br, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.New("redis: invalid array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				/* an error item leaves the connection usable */
+				var rerr redisError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				items[i] = nil
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,85 @@
+// Package store keeps the NF state that must survive restarts or be shared
+// by the replicas of an NF: the subscriptions, the pending correlations and
+// the NF records. The values are stored by key in named collections, in
+// memory, in a BoltDB file or in Redis
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+// defaultTimeout bounds the operations when the configuration sets none
+const defaultTimeout = 2000
+
+// ErrNotFound is returned for a key that does not exist or has expired
+var ErrNotFound = errors.New("store: key not found")
+
+// Store stores values by key in collections
+type Store interface {
+	// Get returns the value of the key, ErrNotFound when there is none
+	Get(ctx context.Context, collection, key string) ([]byte, error)
+	// Put stores the value of the key, expiring after ttl when positive
+	Put(ctx context.Context, collection, key string, value []byte,
+		ttl time.Duration) error
+	// Create stores the value of the key unless it exists, atomically, and
+	// reports whether it did
+	Create(ctx context.Context, collection, key string, value []byte,
+		ttl time.Duration) (bool, error)
+	// Delete removes the key and reports whether it existed
+	Delete(ctx context.Context, collection, key string) (bool, error)
+	// List returns the values of the collection by key
+	List(ctx context.Context, collection string) (map[string][]byte, error)
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Open opens the store of the configuration
+func Open(cfg config.StoreConfig) (Store, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout * time.Millisecond
+	}
+	switch cfg.Backend {
+	case "", config.StoreMemory:
+		return NewMemory(), nil
+	case config.StoreBolt:
+		return openBolt(cfg.Path, timeout)
+	case config.StoreRedis:
+		return newRedis(cfg, timeout)
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+// GetJSON decodes the JSON value of the key into v
+func GetJSON(ctx context.Context, s Store, collection, key string,
+	v interface{}) error {
+	data, err := s.Get(ctx, collection, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// PutJSON stores the JSON encoding of v
+func PutJSON(ctx context.Context, s Store, collection, key string,
+	v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, collection, key, data, ttl)
+}
+
+// expiry returns the expiry time of a ttl, zero when it does not expire
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
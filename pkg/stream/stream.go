@@ -0,0 +1,111 @@
+// Package stream processes large message bodies chunk by chunk instead of
+// buffering them, with a bound on their size. Writes to an HTTP/2 response
+// are flushed chunk by chunk, so that they wait for the flow control window
+// of the peer rather than pile up in memory
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// DefaultChunkSize is the size of the chunks read from a stream
+const DefaultChunkSize = 32 << 10
+
+// ErrTooLarge is returned when a stream exceeds its size limit
+var ErrTooLarge = errors.New("stream: body too large")
+
+// LimitReader returns a reader of r failing with ErrTooLarge once more than
+// limit bytes are read, unlike io.LimitReader which ends silently. A limit
+// of 0 or less does not limit r
+func LimitReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, left: limit}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, ErrTooLarge
+	}
+	/* read one byte more than allowed to tell the end from an excess */
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n + int(l.left), ErrTooLarge
+	}
+	return n, err
+}
+
+// Chunks reads src in chunks of size bytes, DefaultChunkSize when 0, and
+// calls fn with each of them. The chunk is only valid until fn returns. It
+// stops at the end of src, on the first error of fn, when the context is
+// done or when more than limit bytes are read (ErrTooLarge), and returns
+// the number of bytes handed to fn
+func Chunks(ctx context.Context, src io.Reader, size int, limit int64,
+	fn func(chunk []byte) error) (int64, error) {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	src = LimitReader(src, limit)
+	buf := make([]byte, size)
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if ferr := fn(buf[:n]); ferr != nil {
+				return total, ferr
+			}
+			total += int64(n)
+		}
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return total, nil
+		default:
+			return total, err
+		}
+	}
+}
+
+// Copy copies src to dst chunk by chunk, flushing dst after each chunk when
+// it is an http.Flusher. It stops like Chunks and returns the number of
+// bytes written
+func Copy(ctx context.Context, dst io.Writer, src io.Reader,
+	limit int64) (int64, error) {
+	flusher, _ := dst.(http.Flusher)
+	return Chunks(ctx, src, 0, limit, func(chunk []byte) error {
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// Pipe returns a reader of the data written by produce, which runs in its
+// own goroutine until it returns or the reader is closed. The error of
+// produce is returned by the reader once the data is read
+func Pipe(produce func(w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(produce(pw))
+	}()
+	return pr
+}
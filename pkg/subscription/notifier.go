@@ -0,0 +1,176 @@
+package subscription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+// Interval between the removals of the expired subscriptions
+const expiryInterval = 30 * time.Second
+
+var notifications = metrics.NewCounterVec("nf_notifications_total",
+	"Event notifications by result: delivered, failed, dropped or "+
+		"deferred to the outbox.", "event", "result")
+
+// Notification is the body POSTed to the notification URI
+type Notification struct {
+	SubscriptionID string      `json:"subscriptionId"`
+	Event          string      `json:"event"`
+	TimeStamp      time.Time   `json:"timeStamp"`
+	Data           interface{} `json:"data,omitempty"`
+}
+
+// delivery is a notification waiting to be sent to one subscription
+type delivery struct {
+	uri          string
+	notification Notification
+}
+
+// Notify queues the event for delivery to the subscriptions whose filter
+// matches it. Notifications are dropped when the queue is full
+func (m *Manager) Notify(ctx context.Context, event string,
+	data interface{}) {
+	subs, err := m.List(ctx)
+	if err != nil {
+		logging.Errorf("Notification %s not sent, subscriptions unknown: %v",
+			event, err)
+		notifications.WithLabelValues(event, "failed").Inc()
+		return
+	}
+	now := time.Now()
+	for _, s := range subs {
+		if !s.Filter.matches(event) {
+			continue
+		}
+		d := delivery{uri: s.NotificationURI, notification: Notification{
+			SubscriptionID: s.ID,
+			Event:          event,
+			TimeStamp:      now,
+			Data:           data,
+		}}
+		select {
+		case m.queue <- d:
+		default:
+			logging.Warnf("Notification queue full, %s dropped for %s",
+				event, s.ID)
+			notifications.WithLabelValues(event, "dropped").Inc()
+		}
+	}
+}
+
+// Run delivers the queued notifications and removes the expired
+// subscriptions until the context is canceled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.expire(ctx)
+		case d := <-m.queue:
+			m.deliver(ctx, d)
+		}
+	}
+}
+
+// deliver sends the notification, retrying up to the configured number of
+// attempts. The subscription is removed when the subscriber answers that
+// the notification URI does not exist anymore
+func (m *Manager) deliver(ctx context.Context, d delivery) {
+	n := d.notification
+	for attempt := 1; ; attempt++ {
+		if s, err := m.Get(ctx, n.SubscriptionID); err == nil && s == nil {
+			return
+		}
+		status, err := m.send(ctx, d)
+		if err == nil && status/100 == 2 {
+			notifications.WithLabelValues(n.Event, "delivered").Inc()
+			return
+		}
+		if status == http.StatusNotFound || status == http.StatusGone {
+			logging.Infof("Subscription %s removed, %s answered %d",
+				n.SubscriptionID, d.uri, status)
+			if _, err := m.Delete(ctx, n.SubscriptionID); err != nil {
+				logging.Warnf("Subscription %s not removed: %v",
+					n.SubscriptionID, err)
+			}
+			notifications.WithLabelValues(n.Event, "failed").Inc()
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("status %d", status)
+		}
+		if attempt >= m.attempts {
+			if m.deferred(ctx, d, err) {
+				notifications.WithLabelValues(n.Event, "deferred").Inc()
+				return
+			}
+			logging.Errorf("Notification %s to %s failed after %d attempts: %v",
+				n.Event, d.uri, attempt, err)
+			notifications.WithLabelValues(n.Event, "failed").Inc()
+			return
+		}
+		logging.Warnf("Notification %s to %s failed: %v", n.Event, d.uri, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+// deferred hands the notification that failed with err to the outbox
+func (m *Manager) deferred(ctx context.Context, d delivery, err error) bool {
+	if m.Outbox == nil {
+		return false
+	}
+	req, rerr := m.request(d)
+	if rerr != nil {
+		return false
+	}
+	return m.Outbox.Defer(req.WithContext(ctx), err)
+}
+
+// request returns the POST request of the notification
+func (m *Manager) request(d delivery) (*http.Request, error) {
+	body, err := json.Marshal(d.notification)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (m *Manager) send(ctx context.Context, d delivery) (int, error) {
+	req, err := m.request(d)
+	if err != nil {
+		return 0, err
+	}
+	if m.Publisher != nil {
+		/* delivered once the broker has it, a consumer sends it */
+		err := m.Publisher.PublishRequest(req.WithContext(ctx))
+		if err != nil {
+			return 0, err
+		}
+		return http.StatusAccepted, nil
+	}
+	resp, err := m.client.Do(req.WithContext(m.client.CallbackContext(ctx)))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,331 @@
+// Package subscription manages the subscriptions of peer NFs to the events
+// of an NF and delivers the event notifications to their notification URI
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/client"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/problem"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/scp"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/store"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/uuid"
+)
+
+const (
+	// DefaultPath is the subscriptions resource path used when the NF
+	// configures none
+	DefaultPath = "/subscriptions"
+
+	defaultMaxValidity      = 3600
+	defaultDeliveryAttempts = 3
+	defaultRetryInterval    = 1000
+	defaultQueueSize        = 1024
+
+	// collection of the store holding the subscriptions
+	collection = "subscriptions"
+)
+
+// Filter selects the events notified to a subscription
+type Filter struct {
+	// EventTypes notified, all the events when empty
+	EventTypes []string `json:"eventTypes,omitempty"`
+}
+
+func (f Filter) matches(eventType string) bool {
+	if len(f.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range f.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription is the subscription resource
+type Subscription struct {
+	ID              string `json:"subscriptionId,omitempty"`
+	NotificationURI string `json:"notificationUri"`
+	Filter          Filter `json:"filter"`
+	// ValidityTime is when the subscription expires. The subscriber may
+	// propose one, it is shortened to the configured maximum
+	ValidityTime time.Time `json:"validityTime,omitempty"`
+}
+
+// Publisher publishes the requests for others to send them
+type Publisher interface {
+	PublishRequest(req *http.Request) error
+}
+
+// Deferrer keeps the requests whose peer was unreachable to send them
+// again later
+type Deferrer interface {
+	Defer(req *http.Request, err error) bool
+}
+
+// Manager stores the subscriptions, serves the subscriptions resource and
+// notifies the subscribers. The subscriptions are kept in the store, so
+// that they outlive a restart and the replicas sharing the store notify
+// the same subscribers
+type Manager struct {
+	// BaseURI is the URI of the subscriptions resource, used to build the
+	// Location of the created subscriptions
+	BaseURI string
+	// Publisher publishes the notification requests, to be sent by the
+	// consumers of a message queue, instead of sending them when set
+	Publisher Publisher
+	// Outbox keeps the notifications whose subscriber stayed unreachable
+	// through the attempts, to send them again later, when set
+	Outbox Deferrer
+
+	maxValidity time.Duration
+	attempts    int
+	interval    time.Duration
+	client      *client.Client
+	queue       chan delivery
+	store       store.Store
+}
+
+// New creates a manager keeping the subscriptions in the store and sending
+// the notifications with the client
+func New(cfg config.SubscriptionConfig, c *client.Client,
+	st store.Store) *Manager {
+	m := &Manager{
+		maxValidity: time.Duration(cfg.MaxValidity) * time.Second,
+		attempts:    cfg.DeliveryAttempts,
+		interval:    time.Duration(cfg.RetryInterval) * time.Millisecond,
+		client:      c,
+		store:       st,
+	}
+	if m.maxValidity <= 0 {
+		m.maxValidity = defaultMaxValidity * time.Second
+	}
+	if m.attempts <= 0 {
+		m.attempts = defaultDeliveryAttempts
+	}
+	if m.interval <= 0 {
+		m.interval = defaultRetryInterval * time.Millisecond
+	}
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	m.queue = make(chan delivery, size)
+	return m
+}
+
+// Create validates and stores a new subscription. An invalid subscription
+// is rejected with a *problem.Details error
+func (m *Manager) Create(ctx context.Context, s Subscription) (*Subscription,
+	error) {
+	u, err := url.Parse(s.NotificationURI)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		return nil, problem.New(http.StatusBadRequest,
+			problem.CauseMandatoryIEIncorrect,
+			"invalid notification URI").WithInvalidParams(
+			problem.InvalidParam{Param: "/notificationUri",
+				Reason: fmt.Sprintf("%q is not an http(s) URI",
+					s.NotificationURI)})
+	}
+	if err := m.client.CheckCallback(ctx, s.NotificationURI); err != nil {
+		return nil, problem.New(http.StatusBadRequest,
+			problem.CauseMandatoryIEIncorrect,
+			"notification URI not allowed").WithInvalidParams(
+			problem.InvalidParam{Param: "/notificationUri",
+				Reason: err.Error()})
+	}
+	max := time.Now().Add(m.maxValidity)
+	if s.ValidityTime.IsZero() || s.ValidityTime.After(max) {
+		s.ValidityTime = max
+	}
+	s.ID = uuid.New()
+	/* the store drops the subscription once its validity time is over */
+	err = store.PutJSON(ctx, m.store, collection, s.ID, s,
+		time.Until(s.ValidityTime))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Get returns the subscription, nil when unknown or expired
+func (m *Manager) Get(ctx context.Context, id string) (*Subscription,
+	error) {
+	var s Subscription
+	err := store.GetJSON(ctx, m.store, collection, id, &s)
+	if errors.Is(err, store.ErrNotFound) ||
+		(err == nil && time.Now().After(s.ValidityTime)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List returns the subscriptions that have not expired
+func (m *Manager) List(ctx context.Context) ([]Subscription, error) {
+	values, err := m.store.List(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	out := make([]Subscription, 0, len(values))
+	for id, v := range values {
+		var s Subscription
+		if err := json.Unmarshal(v, &s); err != nil {
+			logging.Warnf("Subscription %s unreadable: %v", id, err)
+			continue
+		}
+		if !now.After(s.ValidityTime) {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// Delete removes the subscription and reports whether it existed
+func (m *Manager) Delete(ctx context.Context, id string) (bool, error) {
+	return m.store.Delete(ctx, collection, id)
+}
+
+// expire removes the expired subscriptions the store still holds, e.g.
+// after a clock change
+func (m *Manager) expire(ctx context.Context) {
+	values, err := m.store.List(ctx, collection)
+	if err != nil {
+		logging.Warnf("Subscriptions not expired: %v", err)
+		return
+	}
+	now := time.Now()
+	for id, v := range values {
+		var s Subscription
+		if json.Unmarshal(v, &s) == nil && !now.After(s.ValidityTime) {
+			continue
+		}
+		logging.Infof("Subscription %s expired", id)
+		if _, err := m.store.Delete(ctx, collection, id); err != nil {
+			logging.Warnf("Subscription %s not removed: %v", id, err)
+		}
+	}
+}
+
+// ServeHTTP serves the subscriptions collection (POST, GET) and the
+// individual subscriptions (GET, DELETE) below it. The manager is
+// registered both for the resource path and the path followed by "/"
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, m.basePath()), "/")
+	if strings.Contains(id, "/") {
+		notFound(w, id)
+		return
+	}
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		m.create(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		subs, err := m.List(r.Context())
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, subs)
+	case id != "" && r.Method == http.MethodGet:
+		s, err := m.Get(r.Context(), id)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		if s == nil {
+			notFound(w, id)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	case id != "" && r.Method == http.MethodDelete:
+		found, err := m.Delete(r.Context(), id)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		if !found {
+			notFound(w, id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		problem.Error(w, http.StatusMethodNotAllowed, "",
+			r.Method+" not allowed on "+r.URL.Path)
+	}
+}
+
+// basePath returns the path of the subscriptions resource
+func (m *Manager) basePath() string {
+	u, err := url.Parse(m.BaseURI)
+	if err != nil {
+		return m.BaseURI
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
+func (m *Manager) create(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context())
+	var s Subscription
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		problem.Write(w, problem.FromDecodeError(err))
+		return
+	}
+	created, err := m.Create(r.Context(), s)
+	var p *problem.Details
+	if errors.As(err, &p) {
+		problem.Write(w, p)
+		return
+	}
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	l.Infof("Subscription %s created for %s", created.ID,
+		created.NotificationURI)
+	w.Header().Set("Location", scp.ReplyURI(r,
+		strings.TrimRight(m.BaseURI, "/")+"/"+created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// storeError answers a failure of the store
+func storeError(w http.ResponseWriter, err error) {
+	problem.Error(w, http.StatusInternalServerError,
+		problem.CauseSystemFailure, "subscription store: "+err.Error())
+}
+
+func notFound(w http.ResponseWriter, id string) {
+	problem.Error(w, http.StatusNotFound, problem.CauseSubscriptionNotFound,
+		"subscription "+id+" not found")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		problem.Error(w, http.StatusInternalServerError,
+			problem.CauseSystemFailure, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
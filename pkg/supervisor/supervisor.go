@@ -0,0 +1,142 @@
+// Package supervisor runs several NF roles in one process and restarts
+// the ones that fail, with an exponential backoff, without stopping the
+// others
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/metrics"
+)
+
+// Default restart policy in milliseconds
+const (
+	defaultRestartDelay    = 1000
+	defaultMaxRestartDelay = 30000
+	defaultStableTime      = 60000
+)
+
+var restarts = metrics.NewCounterVec("nf_role_restarts_total",
+	"Restarts of the NF roles of the process after they failed.", "role")
+
+// Role is an NF run by the supervisor
+type Role struct {
+	Name string
+	// Run runs the role until the context is canceled. The role is
+	// restarted when it returns an error or panics, and left stopped when
+	// it returns nil before, e.g. once drained
+	Run func(ctx context.Context) error
+}
+
+// Supervisor runs the roles
+type Supervisor struct {
+	roles       []Role
+	delay       time.Duration
+	maxDelay    time.Duration
+	maxRestarts int
+	stable      time.Duration
+}
+
+// New creates a supervisor of the roles with the restart policy of cfg
+func New(cfg config.SupervisorConfig, roles ...Role) *Supervisor {
+	return &Supervisor{
+		roles:       roles,
+		delay:       millis(cfg.RestartDelay, defaultRestartDelay),
+		maxDelay:    millis(cfg.MaxRestartDelay, defaultMaxRestartDelay),
+		maxRestarts: cfg.MaxRestarts,
+		stable:      millis(cfg.StableTime, defaultStableTime),
+	}
+}
+
+func millis(value, def int) time.Duration {
+	if value <= 0 {
+		value = def
+	}
+	return time.Duration(value) * time.Millisecond
+}
+
+// Run runs the roles until the context is canceled or all of them
+// stopped. A role failing more than the maximum consecutive restarts stops
+// the others, and its error is returned
+func (s *Supervisor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		err  error
+	)
+	for _, r := range s.roles {
+		wg.Add(1)
+		go func(r Role) {
+			defer wg.Done()
+			if e := s.supervise(ctx, r); e != nil {
+				once.Do(func() {
+					err = e
+					cancel()
+				})
+			}
+		}(r)
+	}
+	wg.Wait()
+	return err
+}
+
+// supervise runs the role, restarting it after the backoff delay when it
+// fails
+func (s *Supervisor) supervise(ctx context.Context, r Role) error {
+	failures := 0
+	for {
+		start := time.Now()
+		logging.Infof("Starting role %s", r.Name)
+		err := run(ctx, r)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			logging.Infof("Role %s stopped", r.Name)
+			return nil
+		}
+		if time.Since(start) >= s.stable {
+			failures = 0
+		}
+		failures++
+		if s.maxRestarts > 0 && failures > s.maxRestarts {
+			return fmt.Errorf("role %s failed %d times in a row: %v",
+				r.Name, failures, err)
+		}
+		delay := s.delay
+		for i := 1; i < failures && delay < s.maxDelay; i++ {
+			delay *= 2
+		}
+		if delay > s.maxDelay {
+			delay = s.maxDelay
+		}
+		logging.Errorf("Role %s failed, restarting in %v: %v", r.Name,
+			delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		restarts.WithLabelValues(r.Name).Inc()
+	}
+}
+
+// run runs the role once, turning a panic of its Run goroutine into an
+// error
+func run(ctx context.Context, r Role) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logging.Errorf("Role %s panic: %v\n%s", r.Name, p, debug.Stack())
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return r.Run(ctx)
+}
@@ -0,0 +1,75 @@
+// Package tlsutil contains the TLS helpers shared by the NF servers and
+// clients
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/secrets"
+)
+
+// LoadCertPool reads a PEM CA bundle, a file or a secret reference, into a
+// certificate pool
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	caCert, err := secrets.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate %s: %v", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// LoadKeyPair reads a certificate and its key, files or secret references
+func LoadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := secrets.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := secrets.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(cert, key)
+}
+
+// Names returns the identities carried by a certificate: DNS and URI SANs,
+// IP SANs and the subject CN
+func Names(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// CheckAllowed returns an error unless one of the certificate identities is
+// in the allowed list. An empty list allows every certificate
+func CheckAllowed(cert *x509.Certificate, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	if cert == nil {
+		return errors.New("no peer certificate")
+	}
+	for _, name := range Names(cert) {
+		for _, a := range allowed {
+			if name == a {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate %q is not in the allowed list",
+		cert.Subject.CommonName)
+}
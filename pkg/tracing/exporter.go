@@ -0,0 +1,231 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/config"
+)
+
+const (
+	tracesPath    = "/v1/traces"
+	flushInterval = 5 * time.Second
+	maxBatchSize  = 512
+	maxQueueSize  = 4096
+)
+
+// Tracer creates the spans and exports them in batches to the collector
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	ratio       float64
+	client      *http.Client
+
+	mu    sync.Mutex
+	queue []*Span
+	flush chan struct{}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultTracer *Tracer
+)
+
+// Default returns the process wide tracer, nil when tracing is disabled
+func Default() *Tracer {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultTracer
+}
+
+// Configure creates the process wide tracer from the configuration. It
+// returns nil when tracing is disabled. The tracer Run method must be
+// running for the spans to be exported
+func Configure(cfg config.TracingConfig) *Tracer {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if !cfg.Enabled {
+		defaultTracer = nil
+		return nil
+	}
+	ratio := cfg.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	defaultTracer = &Tracer{
+		endpoint:    strings.TrimRight(cfg.Endpoint, "/"),
+		serviceName: cfg.ServiceName,
+		ratio:       ratio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flush:       make(chan struct{}, 1),
+	}
+	return defaultTracer
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind,
+	parent *SpanContext) *Span {
+	s := &Span{Name: name, Kind: kind, Start: time.Now(),
+		Attributes: make(map[string]interface{}), tracer: t}
+	if parent != nil {
+		s.TraceID = parent.TraceID
+		s.Parent = parent.SpanID
+		s.Sampled = parent.Sampled
+	} else {
+		randomBytes(s.TraceID[:])
+		s.Sampled = sampled(s.TraceID, t.ratio)
+	}
+	randomBytes(s.SpanID[:])
+	return s
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	if len(t.queue) < maxQueueSize {
+		t.queue = append(t.queue, s)
+	}
+	full := len(t.queue) >= maxBatchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run exports the finished spans periodically until the context is
+// canceled, then exports the remaining ones
+func (t *Tracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			t.export()
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+		t.export()
+	}
+}
+
+func (t *Tracer) export() {
+	t.mu.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		if err := t.send(spans[:n]); err != nil {
+			log.Printf("Exporting %d spans failed: %v", n, err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (t *Tracer) send(spans []*Span) error {
+	body, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.endpoint+tracesPath, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+/* OTLP/HTTP JSON encoding of the spans */
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpValue       `json:"status,omitempty"`
+}
+
+func attribute(key string, v interface{}) otlpAttribute {
+	switch x := v.(type) {
+	case string:
+		return otlpAttribute{key, otlpValue{"stringValue": x}}
+	case bool:
+		return otlpAttribute{key, otlpValue{"boolValue": x}}
+	case int:
+		return otlpAttribute{key, otlpValue{"intValue": strconv.Itoa(x)}}
+	case int64:
+		return otlpAttribute{key,
+			otlpValue{"intValue": strconv.FormatInt(x, 10)}}
+	case float64:
+		return otlpAttribute{key, otlpValue{"doubleValue": x}}
+	}
+	return otlpAttribute{key, otlpValue{"stringValue": fmt.Sprint(v)}}
+}
+
+func (t *Tracer) payload(spans []*Span) interface{} {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		os := otlpSpan{
+			TraceID:           s.TraceIDString(),
+			SpanID:            s.SpanIDString(),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent != [8]byte{} {
+			os.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		for k, v := range s.Attributes {
+			os.Attributes = append(os.Attributes, attribute(k, v))
+		}
+		if s.Error != "" {
+			// STATUS_CODE_ERROR
+			os.Status = otlpValue{"code": 2, "message": s.Error}
+		}
+		out = append(out, os)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{
+					attribute("service.name", t.serviceName),
+				},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "nfservice"},
+				"spans": out,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Nishat-Zaman/nfservice_http2/pkg/logging"
+)
+
+// Middleware creates a server span for each request, continuing the trace
+// of the peer NF. route returns the route matched by the request, used to
+// name the span. The trace ID is added to the request logger
+func Middleware(route func(*http.Request) string,
+	next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Default() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := StartRemote(r.Context(), r.Method+" "+route(r),
+			r.Header)
+		ctx = logging.NewContext(ctx, logging.FromContext(ctx).With(
+			logging.Fields{"trace_id": span.TraceIDString()}))
+
+		sw := &logging.StatusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.Status == 0 {
+			sw.Status = http.StatusOK
+		}
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.flavor", fmt.Sprintf("%d.%d", r.ProtoMajor,
+			r.ProtoMinor))
+		span.SetAttribute("http.user_agent", r.UserAgent())
+		span.SetAttribute("http.status_code", sw.Status)
+		if sw.Status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("status %d", sw.Status))
+		}
+		span.Finish()
+	})
+}
@@ -0,0 +1,197 @@
+// Package tracing creates spans for the requests exchanged by the NFs,
+// propagates them with the W3C traceparent header and exports them to an
+// OTLP/HTTP collector
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind tells whether a span handles or sends a request
+type SpanKind int
+
+// Span kinds, with the OTLP values
+const (
+	KindServer SpanKind = 2
+	KindClient SpanKind = 3
+)
+
+const traceparentHeader = "traceparent"
+
+// SpanContext identifies a span across processes
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// TraceIDString returns the trace ID in hex
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// SpanIDString returns the span ID in hex
+func (sc SpanContext) SpanIDString() string {
+	return hex.EncodeToString(sc.SpanID[:])
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	SpanContext
+	Parent     [8]byte
+	Name       string
+	Kind       SpanKind
+	Start, End time.Time
+	Attributes map[string]interface{}
+	Error      string
+
+	tracer *Tracer
+	once   sync.Once
+}
+
+// SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// Finish ends the span and queues it for export when sampled
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.End = time.Now()
+		if s.Sampled && s.tracer != nil {
+			s.tracer.enqueue(s)
+		}
+	})
+}
+
+type contextKey struct{}
+
+// ContextWithSpan returns a context carrying the span
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// SpanFromContext returns the span carried by the context, nil if none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+// Start starts a span, child of the span carried by ctx when there is one,
+// and returns a context carrying it. A nil span is returned when tracing is
+// disabled
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context,
+	*Span) {
+	t := Default()
+	if t == nil {
+		return ctx, nil
+	}
+	var parent *SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = &p.SpanContext
+	}
+	s := t.newSpan(name, kind, parent)
+	return ContextWithSpan(ctx, s), s
+}
+
+// StartRemote starts a server span continuing the trace propagated in the
+// request headers
+func StartRemote(ctx context.Context, name string,
+	h http.Header) (context.Context, *Span) {
+	t := Default()
+	if t == nil {
+		return ctx, nil
+	}
+	parent, ok := Extract(h)
+	var s *Span
+	if ok {
+		s = t.newSpan(name, KindServer, &parent)
+	} else {
+		s = t.newSpan(name, KindServer, nil)
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+// Inject writes the traceparent header of the span carried by ctx
+func Inject(ctx context.Context, h http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	h.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-%s", s.TraceIDString(),
+		s.SpanIDString(), flags))
+}
+
+// Extract reads the traceparent header
+func Extract(h http.Header) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h.Get(traceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return sc, false
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if sc.TraceID == [16]byte{} || sc.SpanID == [8]byte{} {
+		return sc, false
+	}
+	return sc, true
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("tracing: reading random bytes: %v", err))
+	}
+}
+
+// sampled decides with the ratio whether a new trace is exported, using
+// the trace ID as the random source
+func sampled(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])) <
+		ratio*float64(math.MaxUint64)
+}
@@ -0,0 +1,300 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) over HTTP/1.1, enough for the NFs to push text messages to
+// browsers: the opening handshake, unfragmented text messages, and the
+// ping and close control frames
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// guid is appended to the Sec-WebSocket-Key to compute the accept key
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxControlPayload bounds the payload of the control frames
+const maxControlPayload = 125
+
+// DefaultMaxMessageSize bounds the messages read when Upgrade is given no
+// limit
+const DefaultMaxMessageSize = 64 << 10
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooLarge      = 1009
+)
+
+// ErrClosed is returned once the connection is closed
+var ErrClosed = errors.New("websocket: connection closed")
+
+// IsUpgrade tells whether the request asks for a WebSocket connection
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is a server WebSocket connection. Its write methods may be called
+// concurrently with one reader
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// maxMessage bounds the payload of the messages read
+	maxMessage int64
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+// Upgrade runs the opening handshake of the request and takes over its
+// connection. On failure the error is answered with 400 and returned.
+// Messages larger than maxMessage bytes, DefaultMaxMessageSize when 0, are
+// refused when reading
+func Upgrade(w http.ResponseWriter, r *http.Request,
+	maxMessage int64) (*Conn, error) {
+	if maxMessage <= 0 {
+		maxMessage = DefaultMaxMessageSize
+	}
+	fail := func(msg string) (*Conn, error) {
+		http.Error(w, msg, http.StatusBadRequest)
+		return nil, errors.New("websocket: " + msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail("method not GET")
+	}
+	if !IsUpgrade(r) {
+		return fail("not a WebSocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil ||
+		len(b) != 16 {
+		return fail("invalid Sec-WebSocket-Key")
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail("connection not hijackable")
+	}
+	/* the server timeouts do not apply to the WebSocket connection */
+	_ = conn.SetDeadline(time.Time{})
+	sum := sha1.Sum([]byte(key + guid))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: brw.Reader, maxMessage: maxMessage}, nil
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Ping sends a ping, answered by the peer with a pong
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with the status code and reason and closes the
+// connection
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	err := c.writeFrame(opClose, payload)
+	c.wmu.Lock()
+	c.closed = true
+	c.wmu.Unlock()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SetWriteDeadline sets the deadline of the next writes
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	/* the server frames are not masked */
+	head := make([]byte, 2, 10)
+	head[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		head[1] = byte(n)
+	case n <= 0xffff:
+		head[1] = 126
+		head = append(head, byte(n>>8), byte(n))
+	default:
+		head[1] = 127
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		head = append(head, b[:]...)
+	}
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadMessage returns the next data message, answering the pings and the
+// close frame of the peer meanwhile. It returns io.EOF once the peer
+// closed the connection
+func (c *Conn) ReadMessage() (text bool, data []byte, err error) {
+	var msg []byte
+	var msgOpcode byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return false, nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return false, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			_ = c.Close(code, "")
+			return false, nil, io.EOF
+		case opText, opBinary:
+			if msgOpcode != 0 {
+				return false, nil, c.fail("unfinished fragmented message")
+			}
+			msgOpcode = opcode
+		case opContinuation:
+			if msgOpcode == 0 {
+				return false, nil, c.fail("unexpected continuation frame")
+			}
+		default:
+			return false, nil, c.fail(fmt.Sprintf("unknown opcode %d",
+				opcode))
+		}
+		if int64(len(msg)+len(payload)) > c.maxMessage {
+			_ = c.Close(CloseTooLarge, "message too large")
+			return false, nil, errors.New("websocket: message too large")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msgOpcode == opText, msg, nil
+		}
+	}
+}
+
+// fail closes the connection on a protocol error
+func (c *Conn) fail(reason string) error {
+	_ = c.Close(CloseProtocolError, reason)
+	return errors.New("websocket: " + reason)
+}
+
+// readFrame reads a frame and unmasks its payload
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte,
+	err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail("reserved bits set")
+	}
+	/* the client frames must be masked */
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail("unmasked client frame")
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if opcode >= opClose && (n > maxControlPayload || !fin) {
+		return false, 0, nil, c.fail("invalid control frame")
+	}
+	if n > uint64(c.maxMessage) {
+		_ = c.Close(CloseTooLarge, "message too large")
+		return false, 0, nil, errors.New("websocket: message too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
@@ -0,0 +1,294 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clientFrame returns a frame as sent by a client, masked unless unmasked
+func clientFrame(fin bool, opcode byte, payload []byte,
+	unmasked bool) []byte {
+	b := []byte{opcode, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b[1] = byte(n)
+	case n <= 0xffff:
+		b[1] = 126
+		b = append(b, byte(n>>8), byte(n))
+	default:
+		b[1] = 127
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(n))
+		b = append(b, l[:]...)
+	}
+	if unmasked {
+		return append(b, payload...)
+	}
+	b[1] |= 0x80
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+type serverFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+// serverFrames splits the unmasked frames sent by the server
+func serverFrames(t *testing.T, data []byte) []serverFrame {
+	t.Helper()
+	var frames []serverFrame
+	for len(data) > 0 {
+		if len(data) < 2 || data[1]&0x80 != 0 {
+			t.Fatalf("invalid server frame %x", data)
+		}
+		n, off := int(data[1]), 2
+		switch n {
+		case 126:
+			n, off = int(binary.BigEndian.Uint16(data[2:])), 4
+		case 127:
+			n, off = int(binary.BigEndian.Uint64(data[2:])), 10
+		}
+		frames = append(frames, serverFrame{data[0] & 0x0f,
+			data[off : off+n]})
+		data = data[off+n:]
+	}
+	return frames
+}
+
+// exchange reads a message of the frames sent to a connection and returns
+// the frames the connection sent back
+func exchange(t *testing.T, maxMessage int64,
+	frames ...[]byte) ([]serverFrame, bool, []byte, error) {
+	t.Helper()
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = client.Write(bytes.Join(frames, nil))
+	}()
+	replies := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(client)
+		replies <- data
+	}()
+	c := &Conn{conn: server, br: bufio.NewReader(server),
+		maxMessage: maxMessage}
+	text, data, err := c.ReadMessage()
+	server.Close()
+	return serverFrames(t, <-replies), text, data, err
+}
+
+func TestReadMessage(t *testing.T) {
+	closeCode := func(code int) []byte {
+		return binary.BigEndian.AppendUint16(nil, uint16(code))
+	}
+	tests := []struct {
+		name   string
+		frames [][]byte
+		text   bool
+		data   string
+		err    string
+		// replies are the opcodes sent back, the close code last
+		replies []byte
+		close   int
+	}{
+		{"text", [][]byte{clientFrame(true, opText, []byte("hello"),
+			false)}, true, "hello", "", nil, 0},
+		{"binary", [][]byte{clientFrame(true, opBinary, []byte{1, 2},
+			false)}, false, "\x01\x02", "", nil, 0},
+		{"fragmented with ping", [][]byte{
+			clientFrame(false, opText, []byte("hel"), false),
+			clientFrame(true, opPing, []byte("p"), false),
+			clientFrame(false, opContinuation, []byte("l"), false),
+			clientFrame(true, opContinuation, []byte("o"), false),
+		}, true, "hello", "", []byte{opPong}, 0},
+		{"at the limit", [][]byte{clientFrame(true, opText,
+			bytes.Repeat([]byte("a"), 16), false)}, true,
+			strings.Repeat("a", 16), "", nil, 0},
+		{"peer close", [][]byte{clientFrame(true, opClose,
+			closeCode(CloseGoingAway), false)}, false, "", "EOF",
+			[]byte{opClose}, CloseGoingAway},
+		{"unmasked", [][]byte{clientFrame(true, opText, []byte("hello"),
+			true)}, false, "", "unmasked client frame", []byte{opClose},
+			CloseProtocolError},
+		{"reserved bits", [][]byte{append([]byte{0xc1},
+			clientFrame(true, opText, nil, false)[1:]...)}, false, "",
+			"reserved bits set", []byte{opClose}, CloseProtocolError},
+		{"oversize ping", [][]byte{clientFrame(true, opPing,
+			make([]byte, maxControlPayload+1), false)}, false, "",
+			"invalid control frame", []byte{opClose}, CloseProtocolError},
+		{"fragmented ping", [][]byte{clientFrame(false, opPing, nil,
+			false)}, false, "", "invalid control frame", []byte{opClose},
+			CloseProtocolError},
+		{"continuation without start", [][]byte{clientFrame(true,
+			opContinuation, []byte("lo"), false)}, false, "",
+			"unexpected continuation frame", []byte{opClose},
+			CloseProtocolError},
+		{"unfinished fragmented", [][]byte{
+			clientFrame(false, opText, []byte("hel"), false),
+			clientFrame(true, opText, []byte("lo"), false),
+		}, false, "", "unfinished fragmented message", []byte{opClose},
+			CloseProtocolError},
+		{"unknown opcode", [][]byte{clientFrame(true, 0x3, nil, false)},
+			false, "", "unknown opcode 3", []byte{opClose},
+			CloseProtocolError},
+		{"too large frame", [][]byte{clientFrame(true, opText,
+			make([]byte, 17), false)}, false, "", "message too large",
+			[]byte{opClose}, CloseTooLarge},
+		{"too large fragments", [][]byte{
+			clientFrame(false, opText, make([]byte, 10), false),
+			clientFrame(true, opContinuation, make([]byte, 7), false),
+		}, false, "", "message too large", []byte{opClose}, CloseTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies, text, data, err := exchange(t, 16, tt.frames...)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if text != tt.text || string(data) != tt.data {
+				t.Errorf("got text %v %q, want %v %q", text, data, tt.text,
+					tt.data)
+			}
+			if len(replies) != len(tt.replies) {
+				t.Fatalf("got %d replies, want %d", len(replies),
+					len(tt.replies))
+			}
+			for i, r := range replies {
+				if r.opcode != tt.replies[i] {
+					t.Errorf("got reply opcode %d, want %d", r.opcode,
+						tt.replies[i])
+				}
+			}
+			if tt.close == 0 {
+				return
+			}
+			last := replies[len(replies)-1].payload
+			if len(last) < 2 ||
+				int(binary.BigEndian.Uint16(last)) != tt.close {
+				t.Errorf("got close payload %q, want code %d", last,
+					tt.close)
+			}
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		c, err := Upgrade(w, r, 0)
+		if err != nil {
+			return
+		}
+		defer c.Close(CloseNormal, "")
+		if _, data, err := c.ReadMessage(); err == nil {
+			_ = c.WriteText(data)
+		}
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	/* the sample handshake of RFC 6455 1.3 */
+	req := "GET /admin/monitor HTTP/1.1\r\nHost: nf\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") !=
+			"s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("got %s %v", resp.Status, resp.Header)
+	}
+
+	/* no limit given: the messages up to DefaultMaxMessageSize are read */
+	msg := bytes.Repeat([]byte("nf"), DefaultMaxMessageSize/2)
+	if _, err := conn.Write(clientFrame(true, opText, msg,
+		false)); err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(br, head); err != nil {
+		t.Fatal(err)
+	}
+	if head[0] != 0x80|opText || head[1] != 127 ||
+		binary.BigEndian.Uint64(head[2:]) != uint64(len(msg)) {
+		t.Fatalf("got frame head %x", head)
+	}
+	echo := make([]byte, len(msg))
+	if _, err := io.ReadFull(br, echo); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, msg) {
+		t.Error("echoed message differs")
+	}
+}
+
+func TestUpgradeRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		err    string
+	}{
+		{"method", http.MethodPost, nil, "method not GET"},
+		{"no upgrade", http.MethodGet, map[string]string{"Upgrade": ""},
+			"not a WebSocket upgrade"},
+		{"version", http.MethodGet,
+			map[string]string{"Sec-WebSocket-Version": "8"},
+			"unsupported WebSocket version"},
+		{"key", http.MethodGet,
+			map[string]string{"Sec-WebSocket-Key": "bmYx"},
+			"invalid Sec-WebSocket-Key"},
+		{"not hijackable", http.MethodGet, nil,
+			"connection not hijackable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/admin/monitor", nil)
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Sec-WebSocket-Version", "13")
+			r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			_, err := Upgrade(w, r, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d", w.Code)
+			}
+		})
+	}
+}